@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultACMEInterval is how often Wait of an ACMEChallenges without an
+// Interval queries the name servers.
+const defaultACMEInterval = 2 * time.Second
+
+var errNameOutOfZone = errors.New("name out of zone")
+
+// ACMEChallenges serves the TXT records of the DNS-01 challenges of ACME
+// certificate issuance, see RFC 8555, section 8.4, from a Zone: the record of
+// a domain is added at _acme-challenge.<domain> for the CA to check, and
+// removed once the challenge is done.
+//
+// Its Present and CleanUp methods are those of the challenge providers of
+// lego, and of their adapters to other ACME clients such as certmagic, so an
+// ACMEChallenges is used as is as the DNS provider of the ACME client.
+type ACMEChallenges struct {
+	Zone *Zone
+
+	// TTL is the TTL of the records. If zero, the TTL of the zone is used.
+	TTL time.Duration
+
+	// Lease, if not zero, removes the records Lease after they are added,
+	// in case the clean up of a challenge never comes.
+	Lease time.Duration
+
+	// NameServers are the servers of the zone queried by Wait, such as its
+	// secondaries. If empty, Wait checks the zone itself.
+	NameServers []net.Addr
+
+	// Client sends the queries of Wait. If nil, a zero Client is used.
+	Client *Client
+
+	// Interval is how often Wait queries the name servers. If zero, they are
+	// queried every 2 seconds.
+	Interval time.Duration
+}
+
+// ACMEChallengeValue returns the value of the TXT record of a DNS-01 challenge
+// for the key authorization keyAuth: its SHA-256 digest, base64url encoded
+// without padding.
+func ACMEChallengeValue(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Present adds the TXT record of the challenge of domain for the key
+// authorization keyAuth. The token is not used by DNS-01 challenges. The
+// challenge of a wildcard, such as *.example.com, is that of the domain
+// under it. The record is added next to those of the other pending
+// challenges of the domain, as the challenges of example.com and
+// *.example.com share their name.
+func (a *ACMEChallenges) Present(domain, token, keyAuth string) error {
+	k, err := a.key(domain)
+	if err != nil {
+		return err
+	}
+
+	e := &RREntry{
+		Record: &TXT{TXT: []string{ACMEChallengeValue(keyAuth)}},
+		TTL:    a.TTL,
+		Meta:   RRMeta{Source: "acme"},
+	}
+	if a.Lease > 0 {
+		e.Expires = time.Now().Add(a.Lease)
+	}
+
+	return a.Zone.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteRecordInKey(k, e)
+		tx.AppendRecordInKey(k, e)
+		return nil
+	})
+}
+
+// CleanUp removes the TXT record of the challenge of domain for the key
+// authorization keyAuth, leaving the records of the other challenges of the
+// domain.
+func (a *ACMEChallenges) CleanUp(domain, token, keyAuth string) error {
+	k, err := a.key(domain)
+	if err != nil {
+		return err
+	}
+
+	return a.Zone.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteRecordInKey(k, &TXT{TXT: []string{ACMEChallengeValue(keyAuth)}})
+		return nil
+	})
+}
+
+// Wait waits until the TXT record of the challenge of domain for keyAuth is
+// answered by every name server, so that the CA finds it whichever server it
+// queries, or until ctx is done. It returns ctx.Err() in that case.
+func (a *ACMEChallenges) Wait(ctx context.Context, domain, keyAuth string) error {
+	if _, err := a.key(domain); err != nil {
+		return err
+	}
+
+	name, value := a.name(domain), ACMEChallengeValue(keyAuth)
+
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultACMEInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if a.propagated(ctx, name, value) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// propagated reports whether every name server answers the TXT record value of
+// name.
+func (a *ACMEChallenges) propagated(ctx context.Context, name, value string) bool {
+	if len(a.NameServers) == 0 {
+		k, _ := a.Zone.key(name)
+		for _, rr := range a.Zone.GetRecords(k, TypeTXT) {
+			if txt, _ := unwrapRecord(rr); hasTXT([]Resource{{Record: txt}}, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	client := a.Client
+	if client == nil {
+		client = new(Client)
+	}
+
+	for _, addr := range a.NameServers {
+		msg, err := client.Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{{Name: name, Type: TypeTXT, Class: ClassIN}},
+			},
+		})
+		if err != nil || msg.RCode != NoError || !hasTXT(msg.Answers, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// name returns the name of the challenge of domain.
+func (a *ACMEChallenges) name(domain string) string {
+	domain = strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*.")
+	return "_acme-challenge." + domain + "."
+}
+
+// key returns the key of the challenge of domain in the zone.
+func (a *ACMEChallenges) key(domain string) (string, error) {
+	k, ok := a.Zone.key(a.name(domain))
+	if !ok {
+		return "", errNameOutOfZone
+	}
+	return k, nil
+}
+
+func hasTXT(rs []Resource, value string) bool {
+	for _, res := range rs {
+		if txt, ok := res.Record.(*TXT); ok && strings.Join(txt.TXT, "") == value {
+			return true
+		}
+	}
+	return false
+}
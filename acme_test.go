@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestACMEChallengeValue(t *testing.T) {
+	t.Parallel()
+
+	keyAuth := "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI"
+	if want, got := "lCM7cZyQXcVHK2nnW3jjAhNT3Fvm18UN-kWZZknKoYM", ACMEChallengeValue(keyAuth); want != got {
+		t.Errorf("want value %q, got %q", want, got)
+	}
+}
+
+func TestACMEChallenges(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "acme.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.acme.dev.", MBox: "hostmaster.acme.dev."},
+	}
+
+	srv := mustServer(zone)
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme := &ACMEChallenges{
+		Zone:        zone,
+		TTL:         time.Minute,
+		NameServers: []net.Addr{addr},
+		Interval:    10 * time.Millisecond,
+	}
+
+	if err := acme.Present("www.acme.dev", "token", "apex"); err != nil {
+		t.Fatal(err)
+	}
+	if err := acme.Present("*.www.acme.dev.", "token", "wildcard"); err != nil {
+		t.Fatal(err)
+	}
+	// presenting a challenge again keeps a single record
+	if err := acme.Present("www.acme.dev", "token", "apex"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 2, len(zone.GetRecords("_acme-challenge.www", TypeTXT)); want != got {
+		t.Fatalf("want %d challenge records, got %d", want, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := acme.Wait(ctx, "www.acme.dev", "wildcard"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := acme.CleanUp("*.www.acme.dev", "token", "wildcard"); err != nil {
+		t.Fatal(err)
+	}
+
+	rrs := zone.GetRecords("_acme-challenge.www", TypeTXT)
+	if want, got := 1, len(rrs); want != got {
+		t.Fatalf("want %d challenge records, got %d", want, got)
+	}
+	if want, got := ACMEChallengeValue("apex"), rrs[0].(*RREntry).Record.(*TXT).TXT[0]; want != got {
+		t.Errorf("want challenge %q left, got %q", want, got)
+	}
+
+	// the removed challenge is not seen before ctx is done
+	local := &ACMEChallenges{Zone: zone, Interval: 10 * time.Millisecond}
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer waitCancel()
+
+	if want, got := context.DeadlineExceeded, local.Wait(waitCtx, "www.acme.dev", "wildcard"); want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+	if err := local.Wait(ctx, "www.acme.dev", "apex"); err != nil {
+		t.Error(err)
+	}
+
+	if want, got := errNameOutOfZone, acme.Present("example.com", "token", "apex"); want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AddressBook is a Handler serving overridden addresses of domain names ahead
+// of recursion, like the address option of dnsmasq. An address of a domain
+// name also covers all the names under it, unless they have their own entry.
+// A and AAAA questions for covered names are answered with the addresses of
+// their family, any other question of theirs with no records. A name covered
+// by an entry without addresses does not exist. The questions for other names
+// are forwarded upstream.
+//
+// The entries may be replaced with Set while queries are served.
+type AddressBook struct {
+	// TTL is the TTL of the answers. If zero, one minute is used.
+	TTL time.Duration
+
+	mu    sync.RWMutex
+	addrs map[string][]net.IP
+}
+
+// Set replaces the entries of the address book with addrs, a map of domain
+// names to their addresses.
+func (b *AddressBook) Set(addrs map[string][]net.IP) {
+	m := make(map[string][]net.IP, len(addrs))
+	for name, ips := range addrs {
+		m[NormalizeKey(name)] = append([]net.IP(nil), ips...)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.addrs = m
+}
+
+// Add adds the addresses ips to the entry of the domain name.
+func (b *AddressBook) Add(name string, ips ...net.IP) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.addrs == nil {
+		b.addrs = make(map[string][]net.IP)
+	}
+
+	k := NormalizeKey(name)
+	b.addrs[k] = append(b.addrs[k], ips...)
+	if b.addrs[k] == nil {
+		b.addrs[k] = []net.IP{}
+	}
+}
+
+// lookup returns the addresses of the closest entry covering name.
+func (b *AddressBook) lookup(name string) ([]net.IP, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for k := NormalizeKey(name); ; {
+		if ips, ok := b.addrs[k]; ok {
+			return ips, true
+		}
+
+		i := strings.IndexByte(k, '.')
+		if i < 0 {
+			return nil, false
+		}
+		k = k[i+1:]
+	}
+}
+
+// ServeDNS answers the questions covered by the address book, and forwards
+// the others upstream.
+func (b *AddressBook) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	ttl := b.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+
+	var miss bool
+	for _, q := range r.Questions {
+		ips, ok := b.lookup(q.Name)
+		if !ok {
+			miss = true
+			continue
+		}
+		if len(ips) == 0 {
+			w.Status(NXDomain)
+			continue
+		}
+
+		for _, ip := range ips {
+			ip4 := ip.To4()
+			switch {
+			case q.Type == TypeA && ip4 != nil:
+				w.Answer(q.Name, ttl, &A{A: ip4})
+			case q.Type == TypeAAAA && ip4 == nil:
+				w.Answer(q.Name, ttl, &AAAA{AAAA: ip.To16()})
+			}
+		}
+	}
+
+	if !miss {
+		return
+	}
+
+	msg, err := w.Recur(ctx)
+	if err != nil || msg == nil {
+		w.Status(ServFail)
+		return
+	}
+	writeMessage(w, msg)
+}
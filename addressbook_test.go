@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(203, 0, 113, 1).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	book := new(AddressBook)
+	book.Add("nas.home.", net.ParseIP("192.168.1.10"), net.ParseIP("fd00::10"))
+	book.Add("printer.nas.home", net.ParseIP("192.168.1.20"))
+	book.Add("ads.example.")
+
+	client := &Client{Resolver: book}
+
+	tests := []struct {
+		name string
+		typ  Type
+
+		rcode  RCode
+		answer net.IP
+	}{
+		{name: "nas.home.", typ: TypeA, answer: net.ParseIP("192.168.1.10")},
+		{name: "NAS.home.", typ: TypeAAAA, answer: net.ParseIP("fd00::10")},
+		{name: "media.nas.home.", typ: TypeA, answer: net.ParseIP("192.168.1.10")},
+		{name: "printer.nas.home.", typ: TypeA, answer: net.ParseIP("192.168.1.20")},
+		{name: "printer.nas.home.", typ: TypeAAAA},
+		{name: "tracker.ads.example.", typ: TypeA, rcode: NXDomain},
+		{name: "app.dev.", typ: TypeA, answer: net.ParseIP("203.0.113.1")},
+	}
+
+	for _, test := range tests {
+		msg, err := client.Do(context.Background(), &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: test.name, Type: test.typ, Class: ClassIN},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s %s: want rcode %v, got %v", test.name, test.typ, want, got)
+		}
+		if test.answer == nil {
+			if want, got := 0, len(msg.Answers); want != got {
+				t.Errorf("%s %s: want %d answers, got %d", test.name, test.typ, want, got)
+			}
+			continue
+		}
+		if want, got := 1, len(msg.Answers); want != got {
+			t.Errorf("%s %s: want %d answers, got %d", test.name, test.typ, want, got)
+			continue
+		}
+
+		var got net.IP
+		switch rr := msg.Answers[0].Record.(type) {
+		case *A:
+			got = rr.A
+		case *AAAA:
+			got = rr.AAAA
+		}
+		if want := test.answer; !want.Equal(got) {
+			t.Errorf("%s %s: want answer %s, got %s", test.name, test.typ, want, got)
+		}
+	}
+
+	book.Set(map[string][]net.IP{"app.dev.": {net.ParseIP("10.0.0.1")}})
+
+	ips, ok := book.lookup("app.dev.")
+	if !ok || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("want reloaded address 10.0.0.1, got %v", ips)
+	}
+	if _, ok := book.lookup("nas.home."); ok {
+		t.Error("want entries replaced")
+	}
+}
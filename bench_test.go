@@ -0,0 +1,400 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchRecords are a record of each built-in type, for the benchmarks of
+// their pack and unpack.
+var benchRecords = []Record{
+	&A{A: net.IPv4(192, 0, 2, 1).To4()},
+	&NS{NS: "ns1.example.com."},
+	&CNAME{CNAME: "www.example.com."},
+	&SOA{
+		NS:      "ns1.example.com.",
+		MBox:    "hostmaster.example.com.",
+		Serial:  2024010101,
+		Refresh: 2 * time.Hour,
+		Retry:   30 * time.Minute,
+		Expire:  14 * 24 * time.Hour,
+		MinTTL:  5 * time.Minute,
+	},
+	&WKS{Address: net.IPv4(192, 0, 2, 1).To4(), Protocol: 6, BitMap: []byte{0, 0, 0, 0x40}},
+	&PTR{PTR: "www.example.com."},
+	&MX{Pref: 10, MX: "mail.example.com."},
+	&TXT{TXT: []string{"v=spf1 include:_spf.example.com -all"}},
+	&AAAA{AAAA: net.ParseIP("2001:db8::1")},
+	&SRV{Priority: 10, Weight: 20, Port: 5060, Target: "sip.example.com."},
+	&DNAME{DNAME: "example.net."},
+	&CAA{Tag: "issue", Value: "ca.example.net"},
+	&ZONEMD{Serial: 2024010101, Scheme: 1, Hash: 1, Digest: make([]byte, 48)},
+}
+
+// recordMessage returns a response with two answers of the type of rr.
+func recordMessage(rr Record) Message {
+	return Message{
+		ID:       0x1234,
+		Response: true,
+		Questions: []Question{
+			{Name: "www.example.com.", Type: rr.Type(), Class: ClassIN},
+		},
+		Answers: []Resource{
+			{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: rr},
+			{Name: "www.example.com.", Class: ClassIN, TTL: time.Minute, Record: rr},
+		},
+	}
+}
+
+func BenchmarkRecordPack(b *testing.B) {
+	for _, rr := range benchRecords {
+		msg := recordMessage(rr)
+
+		b.Run(typeText(rr.Type()), func(b *testing.B) {
+			b.Run("uncompressed", func(b *testing.B) {
+				benchamarkMessagePack(b, msg, make([]byte, 0, 512))
+			})
+			b.Run("compressed", func(b *testing.B) {
+				benchamarkMessageCompress(b, msg, make([]byte, 0, 512))
+			})
+		})
+	}
+}
+
+func BenchmarkRecordUnpack(b *testing.B) {
+	for _, rr := range benchRecords {
+		msg := recordMessage(rr)
+
+		b.Run(typeText(rr.Type()), func(b *testing.B) {
+			b.Run("uncompressed", func(b *testing.B) {
+				benchamarkMessageUnpack(b, msg, false)
+			})
+			b.Run("compressed", func(b *testing.B) {
+				benchamarkMessageUnpack(b, msg, true)
+			})
+		})
+	}
+}
+
+// benchZone returns a zone of names app0 to app<n-1>, each with an A record.
+func benchZone(n, packCacheSize int) *Zone {
+	all := make(map[string]map[Type][]Record, n)
+	for i := 0; i < n; i++ {
+		all["app"+strconv.Itoa(i)] = map[Type][]Record{
+			TypeA: {&A{A: net.IPv4(10, 0, byte(i>>8), byte(i)).To4()}},
+		}
+	}
+
+	return &Zone{
+		Origin:        "localhost.",
+		TTL:           time.Hour,
+		SOA:           &SOA{NS: "ns.localhost.", MBox: "hostmaster.localhost.", Serial: 1},
+		RRs:           NewRRSet(all),
+		PackCacheSize: packCacheSize,
+	}
+}
+
+func zoneQuery(name string) *Query {
+	return &Query{
+		Message: &Message{
+			ID: 0x1234,
+			Questions: []Question{
+				{Name: name, Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+}
+
+func BenchmarkMessageViewUnpack(b *testing.B) {
+	msg := largeTestMsg()
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var view MessageView
+
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := view.Unpack(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkZoneServeDNS(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		packCache int
+		query     *Query
+	}{
+		{name: "answer", query: zoneQuery("app1.localhost.")},
+		{name: "nxdomain", query: zoneQuery("none.localhost.")},
+		{name: "pack-cache", packCache: 16, query: zoneQuery("app1.localhost.")},
+	} {
+		bench := bench
+
+		b.Run(bench.name, func(b *testing.B) {
+			zone := benchZone(1024, bench.packCache)
+			defer zone.Close()
+
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+				zone.ServeDNS(ctx, w, bench.query)
+				if _, err := w.pack(nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkZonePack(b *testing.B) {
+	zone := benchZone(1024, 0)
+	p := new(ZonePacker)
+
+	buf, err := p.Pack(zone, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Pack(zone, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRRSetTxnParallel(b *testing.B) {
+	const keys = 1024
+
+	rrs := new(RRSet)
+	for i := 0; i < keys; i++ {
+		rrs.AppendRecordInKey("app"+strconv.Itoa(i), &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			k := "app" + strconv.Itoa(i%keys)
+			if i%10 == 0 {
+				err := rrs.Txn(func(txn *RRSetTxn) error {
+					txn.SetKey(k, map[Type][]Record{TypeA: {&A{A: net.IPv4(10, 0, 0, byte(i)).To4()}}})
+					return nil
+				})
+				if err != nil {
+					b.Error(err)
+				}
+			} else {
+				rrs.GetRecords(k, TypeA)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkServer(b *testing.B) {
+	srv := mustServer(benchZone(1024, 1024))
+
+	for _, network := range []string{"udp", "tcp"} {
+		network := network
+
+		b.Run(network, func(b *testing.B) {
+			var addr net.Addr
+			var err error
+			if network == "udp" {
+				addr, err = net.ResolveUDPAddr("udp", srv.Addr)
+			} else {
+				addr, err = net.ResolveTCPAddr("tcp", srv.Addr)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			client := new(Client)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					q := zoneQuery("app1.localhost.")
+					q.RemoteAddr = addr
+
+					if _, err := client.Do(context.Background(), q); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// allocBudgets are the maximum allocations of the hot paths, per operation.
+// TestAllocBudgets fails when an operation allocates more, so a change that
+// adds allocations to one of them must raise its budget on purpose.
+var allocBudgets = []struct {
+	name   string
+	budget float64
+	setup  func() func()
+}{
+	{
+		// The compression table of the names.
+		name:   "pack compressed",
+		budget: 4,
+		setup: func() func() {
+			msg, buf := recordMessage(benchRecords[0]), make([]byte, 0, 512)
+			return func() { msg.Pack(buf[:0], true) }
+		},
+	},
+	{
+		name:   "pack uncompressed",
+		budget: 1,
+		setup: func() func() {
+			msg, buf := recordMessage(benchRecords[0]), make([]byte, 0, 512)
+			return func() { msg.Pack(buf[:0], false) }
+		},
+	},
+	{
+		// The sections, and the name, record and address of each question
+		// and answer.
+		name:   "unpack A response",
+		budget: 15,
+		setup: func() func() {
+			msg := recordMessage(benchRecords[0])
+			buf, _ := msg.Pack(nil, true)
+			return func() { new(Message).Unpack(buf) }
+		},
+	},
+	{
+		// A reused view copies nothing.
+		name:   "unpack view",
+		budget: 0,
+		setup: func() func() {
+			msg := largeTestMsg()
+			buf, _ := msg.Pack(nil, true)
+
+			view := new(MessageView)
+			return func() { view.Unpack(buf) }
+		},
+	},
+	{
+		// The writer, the copy of the records read, and the answers.
+		name:   "zone answer",
+		budget: 9,
+		setup: func() func() {
+			zone, q := benchZone(16, 0), zoneQuery("app1.localhost.")
+			return func() {
+				w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+				zone.ServeDNS(context.Background(), w, q)
+			}
+		},
+	},
+	{
+		// The writer and the key of the cached response. The records are
+		// not read.
+		name:   "zone answer from the pack cache",
+		budget: 4,
+		setup: func() func() {
+			zone, q := benchZone(16, 16), zoneQuery("app1.localhost.")
+			return func() {
+				w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+				zone.ServeDNS(context.Background(), w, q)
+			}
+		},
+	},
+	{
+		// The snapshot of the records ranged over, and the name of each
+		// key. The buffer and the compression table are reused.
+		name:   "zone pack",
+		budget: 110,
+		setup: func() func() {
+			zone, p := benchZone(16, 0), new(ZonePacker)
+			return func() { p.Pack(zone, 1) }
+		},
+	},
+	{
+		// The copy of the records returned.
+		name:   "RRSet read",
+		budget: 1,
+		setup: func() func() {
+			rrs := benchZone(16, 0).RRs
+			return func() { rrs.GetRecords("app1", TypeA) }
+		},
+	},
+}
+
+func TestAllocBudgets(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not representative with the race detector")
+	}
+
+	for _, test := range allocBudgets {
+		f := test.setup()
+		f() // warm up the caches
+
+		if got := testing.AllocsPerRun(100, f); got > test.budget {
+			t.Errorf("%s: want at most %v allocations, got %v", test.name, test.budget, got)
+		}
+	}
+}
+
+// BenchmarkCacheParallel benchmarks the lookups of a Cache of 1024 entries, a
+// tenth of them replaced, to be run with -cpu 1,2,4,8 for their scalability.
+func BenchmarkCacheParallel(b *testing.B) {
+	const keys = 1024
+
+	var (
+		cache = new(Cache)
+		now   = time.Now()
+		msgs  = make([]*Message, keys)
+	)
+	for i := range msgs {
+		name := "app" + strconv.Itoa(i) + ".localhost."
+		msgs[i] = &Message{
+			Questions: []Question{{Name: name, Type: TypeA, Class: ClassIN}},
+			Answers: []Resource{
+				{Name: name, Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(10, 0, 0, 1).To4()}},
+			},
+		}
+		cache.insert(msgs[i], now)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			msg := msgs[i%keys]
+			if i%10 == 0 {
+				cache.insert(msg, now)
+			} else {
+				w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+				if !cache.lookup(msg.Questions[0], w, now) {
+					b.Error("cache miss")
+				}
+			}
+			i++
+		}
+	})
+}
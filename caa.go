@@ -0,0 +1,184 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// CAA property tags, see RFC 8659, section 4, and RFC 8657.
+const (
+	CAATagIssue        = "issue"
+	CAATagIssueWild    = "issuewild"
+	CAATagIODEF        = "iodef"
+	CAATagContactEmail = "contactemail"
+)
+
+var (
+	errInvalidCAA = errors.New("invalid CAA record")
+	errCAALookup  = errors.New("CAA lookup failed")
+)
+
+// Validate checks the tag of c and, for a known tag, the syntax of its value.
+func (c *CAA) Validate() error {
+	if len(c.Tag) == 0 || len(c.Tag) > 15 {
+		return errInvalidCAA
+	}
+	for _, r := range c.Tag {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return errInvalidCAA
+		}
+	}
+
+	switch strings.ToLower(c.Tag) {
+	case CAATagIssue, CAATagIssueWild:
+		if _, ok := c.issuer(); !ok {
+			return errInvalidCAA
+		}
+	case CAATagIODEF:
+		u, err := url.Parse(c.Value)
+		if err != nil || u.Scheme != "mailto" && u.Scheme != "http" && u.Scheme != "https" {
+			return errInvalidCAA
+		}
+	case CAATagContactEmail:
+		if _, err := mail.ParseAddress(c.Value); err != nil {
+			return errInvalidCAA
+		}
+	}
+	return nil
+}
+
+// known reports whether the tag of c is one of the CAA property tags.
+func (c *CAA) known() bool {
+	switch strings.ToLower(c.Tag) {
+	case CAATagIssue, CAATagIssueWild, CAATagIODEF, CAATagContactEmail:
+		return true
+	}
+	return false
+}
+
+// issuer returns the issuer domain name of an issue or issuewild property,
+// empty if no issuer is permitted, see RFC 8659, section 4.2.
+func (c *CAA) issuer() (string, bool) {
+	domain := c.Value
+	if i := strings.IndexByte(domain, ';'); i >= 0 {
+		domain = domain[:i]
+	}
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		return "", true
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+				return "", false
+			}
+		}
+	}
+	return strings.ToLower(domain), true
+}
+
+// CAASet is the relevant CAA RRset of a domain name, see RFC 8659, section 3.
+type CAASet []*CAA
+
+// Permits reports whether the issuer domain name may issue a certificate for
+// the domain name of s. For a wildcard name, the issuewild properties are used
+// if any, instead of the issue properties. A critical property with an
+// unknown tag forbids issuance.
+func (s CAASet) Permits(issuer string, wildcard bool) bool {
+	for _, c := range s {
+		if c.flags()&CAAFlagIssuerCritical != 0 && !c.known() {
+			return false
+		}
+	}
+
+	props := s.tagged(CAATagIssue)
+	if wildcard {
+		if wild := s.tagged(CAATagIssueWild); len(wild) > 0 {
+			props = wild
+		}
+	}
+	if len(props) == 0 {
+		return true
+	}
+
+	issuer = strings.ToLower(strings.TrimSuffix(issuer, "."))
+	for _, c := range props {
+		if domain, ok := c.issuer(); ok && domain != "" && domain == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// IODEF returns the URLs of the iodef properties of s, where a CA reports
+// requests that violate the CAA policy.
+func (s CAASet) IODEF() []*url.URL {
+	var urls []*url.URL
+	for _, c := range s.tagged(CAATagIODEF) {
+		if u, err := url.Parse(c.Value); err == nil {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (s CAASet) tagged(tag string) CAASet {
+	var v CAASet
+	for _, c := range s {
+		if strings.EqualFold(c.Tag, tag) {
+			v = append(v, c)
+		}
+	}
+	return v
+}
+
+// LookupCAA returns the relevant CAA RRset of name, queried from the server
+// at addr: the CAA records of the closest of name and its ancestors that has
+// any, see RFC 8659, section 3. The set is empty if no domain name up to the
+// root has CAA records. A response other than NOERROR or NXDOMAIN fails the
+// lookup, as a CA may not issue when the RRset cannot be determined.
+func (c *Client) LookupCAA(ctx context.Context, addr net.Addr, name string) (CAASet, error) {
+	name = strings.TrimSuffix(name, ".") + "."
+
+	for {
+		msg, err := c.Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				RecursionDesired: true,
+				Questions: []Question{
+					{Name: name, Type: TypeCAA, Class: ClassIN},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if msg.RCode != NoError && msg.RCode != NXDomain {
+			return nil, errCAALookup
+		}
+
+		var set CAASet
+		for _, res := range msg.Answers {
+			if caa, ok := res.Record.(*CAA); ok {
+				set = append(set, caa)
+			}
+		}
+		if len(set) > 0 || name == "." {
+			return set, nil
+		}
+
+		if i := strings.IndexByte(name, '.'); i < len(name)-1 {
+			name = name[i+1:]
+		} else {
+			name = "."
+		}
+	}
+}
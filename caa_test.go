@@ -0,0 +1,210 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCAAValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		caa   CAA
+		valid bool
+	}{
+		{CAA{Tag: CAATagIssue, Value: "ca.example.net; account=230123"}, true},
+		{CAA{Tag: CAATagIssue, Value: ";"}, true},
+		{CAA{Tag: CAATagIssueWild, Value: "ca.example.net"}, true},
+		{CAA{Tag: CAATagIODEF, Value: "mailto:security@example.com"}, true},
+		{CAA{Tag: CAATagIODEF, Value: "https://iodef.example.com/"}, true},
+		{CAA{Tag: CAATagContactEmail, Value: "domainowner@example.com"}, true},
+		{CAA{Tag: "tbs", Value: "Unknown"}, true},
+		{CAA{Tag: "", Value: "ca.example.net"}, false},
+		{CAA{Tag: "issue-wild", Value: "ca.example.net"}, false},
+		{CAA{Tag: "abcdefghijklmnop", Value: ""}, false},
+		{CAA{Tag: CAATagIssue, Value: "ca..example.net"}, false},
+		{CAA{Tag: CAATagIODEF, Value: "ftp://iodef.example.com/"}, false},
+		{CAA{Tag: CAATagContactEmail, Value: "example.com"}, false},
+	}
+
+	for _, test := range tests {
+		if want, got := test.valid, test.caa.Validate() == nil; want != got {
+			t.Errorf("%s %q: want valid %t, got %t", test.caa.Tag, test.caa.Value, want, got)
+		}
+	}
+}
+
+func TestCAAFlags(t *testing.T) {
+	t.Parallel()
+
+	b, err := CAA{Flags: 0x01, IssuerCritical: true, Tag: "issue", Value: ";"}.Pack(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := byte(0x81), b[0]; want != got {
+		t.Errorf("want flags %#x, got %#x", want, got)
+	}
+
+	var caa CAA
+	if _, err := caa.Unpack(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint8(0x81), caa.Flags; want != got {
+		t.Errorf("want flags %#x, got %#x", want, got)
+	}
+	if !caa.IssuerCritical {
+		t.Error("want issuer critical flag")
+	}
+}
+
+func TestCAASetPermits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		set      CAASet
+		issuer   string
+		wildcard bool
+		permits  bool
+	}{
+		{
+			name:    "empty set",
+			issuer:  "ca.example.net",
+			permits: true,
+		},
+		{
+			name: "issue",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: "ca.example.net; account=230123"},
+				{Tag: CAATagIODEF, Value: "mailto:security@example.com"},
+			},
+			issuer:  "CA.example.net.",
+			permits: true,
+		},
+		{
+			name: "other issuer",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: "ca.example.net"},
+			},
+			issuer: "ca.example.org",
+		},
+		{
+			name: "no issuer",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: ";"},
+			},
+			issuer: "ca.example.net",
+		},
+		{
+			name: "no issue property",
+
+			set: CAASet{
+				{Tag: CAATagIODEF, Value: "mailto:security@example.com"},
+			},
+			issuer:  "ca.example.net",
+			permits: true,
+		},
+		{
+			name: "issuewild",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: "ca.example.net"},
+				{Tag: CAATagIssueWild, Value: ";"},
+			},
+			issuer:   "ca.example.net",
+			wildcard: true,
+		},
+		{
+			name: "issue for wildcard",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: "ca.example.net"},
+			},
+			issuer:   "ca.example.net",
+			wildcard: true,
+			permits:  true,
+		},
+		{
+			name: "critical unknown tag",
+
+			set: CAASet{
+				{Tag: CAATagIssue, Value: "ca.example.net"},
+				{Flags: CAAFlagIssuerCritical, Tag: "tbs", Value: "Unknown"},
+			},
+			issuer: "ca.example.net",
+		},
+	}
+
+	for _, test := range tests {
+		if want, got := test.permits, test.set.Permits(test.issuer, test.wildcard); want != got {
+			t.Errorf("%s: want permits %t, got %t", test.name, want, got)
+		}
+	}
+}
+
+func TestClientLookupCAA(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "caa.dev.",
+		TTL:    time.Minute,
+		SOA: &SOA{
+			NS:   "ns.caa.dev.",
+			MBox: "hostmaster.caa.dev.",
+		},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"": {
+				TypeCAA: {
+					&CAA{Tag: CAATagIssue, Value: "ca.example.net"},
+					&CAA{Tag: CAATagIODEF, Value: "mailto:security@caa.dev"},
+				},
+			},
+			"app": {
+				TypeA: {&A{A: net.IPv4(10, 0, 0, 1).To4()}},
+			},
+			"own": {
+				TypeCAA: {&CAA{Tag: CAATagIssue, Value: "ca.example.org"}},
+			},
+		}),
+	}
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		issuer string
+		iodef  int
+	}{
+		{name: "www.app.caa.dev.", issuer: "ca.example.net", iodef: 1},
+		{name: "app.caa.dev", issuer: "ca.example.net", iodef: 1},
+		{name: "own.caa.dev.", issuer: "ca.example.org"},
+	}
+
+	for _, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		set, err := new(Client).LookupCAA(ctx, addr, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !set.Permits(test.issuer, false) {
+			t.Errorf("%s: want %s permitted by %v", test.name, test.issuer, set)
+		}
+		if want, got := test.iodef, len(set.IODEF()); want != got {
+			t.Errorf("%s: want %d iodef URLs, got %d", test.name, want, got)
+		}
+	}
+}
@@ -7,10 +7,51 @@ import (
 	"time"
 )
 
-// Cache is a DNS query cache handler.
+// cacheShards is the number of shards of a Cache.
+const cacheShards = 64
+
+// Cache is a DNS query cache handler. Its entries are spread over shards by a
+// hash of their question, each locked on its own, so that the lookups of
+// different questions do not contend for a single lock.
 type Cache struct {
+	shards [cacheShards]cacheShard
+}
+
+type cacheShard struct {
 	mu    sync.RWMutex
 	cache map[Question]*Message
+
+	_ [32]byte // pad to a cache line, against false sharing
+}
+
+// shard returns the shard of the entry of q.
+func (c *Cache) shard(q Question) *cacheShard {
+	return &c.shards[cacheHash(q)%cacheShards]
+}
+
+// cacheHash returns the FNV-1a hash of q.
+func cacheHash(q Question) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(q.Name); i++ {
+		h = (h ^ uint32(q.Name[i])) * 16777619
+	}
+	for _, b := range [...]byte{byte(q.Type >> 8), byte(q.Type), byte(q.Class >> 8), byte(q.Class)} {
+		h = (h ^ uint32(b)) * 16777619
+	}
+	return h
+}
+
+// len returns the number of entries of the cache, expired or not.
+func (c *Cache) len() int {
+	var n int
+	for i := range c.shards {
+		sh := &c.shards[i]
+
+		sh.mu.RLock()
+		n += len(sh.cache)
+		sh.mu.RUnlock()
+	}
+	return n
 }
 
 // ServeDNS answers query questions from a local cache, and forwards unanswered
@@ -22,13 +63,11 @@ func (c *Cache) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 		now = time.Now()
 	)
 
-	c.mu.RLock()
 	for _, q := range r.Questions {
 		if hit := c.lookup(q, w, now); !hit {
 			miss = true
 		}
 	}
-	c.mu.RUnlock()
 
 	if !miss {
 		return
@@ -45,9 +84,13 @@ func (c *Cache) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 	writeMessage(w, msg)
 }
 
-// c.mu.RLock held
 func (c *Cache) lookup(q Question, w MessageWriter, now time.Time) bool {
-	msg, ok := c.cache[q]
+	sh := c.shard(q)
+
+	// the entries are not modified once stored, only replaced
+	sh.mu.RLock()
+	msg, ok := sh.cache[q]
+	sh.mu.RUnlock()
 	if !ok {
 		return false
 	}
@@ -78,31 +121,33 @@ func (c *Cache) lookup(q Question, w MessageWriter, now time.Time) bool {
 
 	randomize(answers)
 	for _, res := range answers {
-		w.Answer(res.Name, res.TTL, res.Record)
+		writeAnswer(w, res)
 	}
 	for _, res := range authorities {
-		w.Authority(res.Name, res.TTL, res.Record)
+		writeAuthority(w, res)
 	}
 	for _, res := range additionals {
-		w.Additional(res.Name, res.TTL, res.Record)
+		writeAdditional(w, res)
 	}
 
 	return true
 }
 
 func (c *Cache) insert(msg *Message, now time.Time) {
+	answers, authorities, additionals := Dedup(msg.Answers), Dedup(msg.Authorities), Dedup(msg.Additionals)
+
 	cache := make(map[Question]*Message, len(msg.Questions))
 	for _, q := range msg.Questions {
 		m := new(Message)
-		for _, res := range msg.Answers {
+		for _, res := range answers {
 			res.TTL = cacheEpoch(res.TTL, now)
 			m.Answers = append(m.Answers, res)
 		}
-		for _, res := range msg.Authorities {
+		for _, res := range authorities {
 			res.TTL = cacheEpoch(res.TTL, now)
 			m.Authorities = append(m.Authorities, res)
 		}
-		for _, res := range msg.Additionals {
+		for _, res := range additionals {
 			res.TTL = cacheEpoch(res.TTL, now)
 			m.Additionals = append(m.Additionals, res)
 		}
@@ -110,16 +155,15 @@ func (c *Cache) insert(msg *Message, now time.Time) {
 		cache[q] = m
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.cache == nil {
-		c.cache = cache
-		return
-	}
-
 	for q, m := range cache {
-		c.cache[q] = m
+		sh := c.shard(q)
+
+		sh.mu.Lock()
+		if sh.cache == nil {
+			sh.cache = make(map[Question]*Message)
+		}
+		sh.cache[q] = m
+		sh.mu.Unlock()
 	}
 }
 
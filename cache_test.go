@@ -5,6 +5,8 @@ import (
 	"errors"
 	"math/rand"
 	"net"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -168,3 +170,49 @@ var badSend = errors.New("bad send")
 func (badConn) Send(_ *Message) error {
 	return badSend
 }
+
+func (badConn) Close() error { return nil }
+
+func TestCacheShards(t *testing.T) {
+	t.Parallel()
+
+	const keys = 256
+
+	var (
+		cache = new(Cache)
+		now   = time.Now()
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < keys; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			q := Question{Name: "app" + strconv.Itoa(i) + ".local.", Type: TypeA, Class: ClassIN}
+			cache.insert(&Message{
+				Questions: []Question{q},
+				Answers:   []Resource{{Name: q.Name, Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(10, 0, 0, byte(i)).To4()}}},
+			}, now)
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+			if !cache.lookup(q, w, now) {
+				t.Errorf("want %s cached", q.Name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if want, got := keys, cache.len(); want != got {
+		t.Errorf("want %d entries, got %d", want, got)
+	}
+
+	var used int
+	for i := range cache.shards {
+		if len(cache.shards[i].cache) > 0 {
+			used++
+		}
+	}
+	if used < cacheShards/2 {
+		t.Errorf("want entries spread over the shards, got %d of %d used", used, cacheShards)
+	}
+}
@@ -0,0 +1,213 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheSnapshotMagic starts the snapshots of a Cache, followed by the time of
+// the snapshot and the entries.
+const cacheSnapshotMagic = "DNSCACHE1"
+
+var (
+	errCacheSnapshot  = errors.New("malformed cache snapshot")
+	errCacheEntryPack = errors.New("cache entries not packed")
+)
+
+// WriteTo writes a snapshot of the entries of the cache not expired to w, to
+// be read back by ReadFrom, such as when a resolver restarts. An entry is
+// written in wire format, its question and its records with their TTL left.
+// The entries that fail to pack are left out, and reported by the error
+// returned once the others are written.
+func (c *Cache) WriteTo(w io.Writer) (int64, error) {
+	now := time.Now()
+
+	var hdr [8]byte
+	nbo.PutUint64(hdr[:], uint64(now.UnixNano()))
+
+	buf := append([]byte(cacheSnapshotMagic), hdr[:]...)
+
+	var (
+		skipped int
+		perr    error // of the first entry skipped
+	)
+	for i := range c.shards {
+		sh := &c.shards[i]
+
+		sh.mu.RLock()
+		for q, entry := range sh.cache {
+			msg, ok := cacheSnapshotEntry(q, entry, now)
+			if !ok {
+				continue
+			}
+
+			n := len(buf)
+
+			b, err := msg.Pack(append(buf, 0, 0, 0, 0), true)
+			if err != nil {
+				if skipped++; perr == nil {
+					perr = err
+				}
+				continue
+			}
+			buf = b
+			nbo.PutUint32(buf[n:], uint32(len(buf)-n-4))
+		}
+		sh.mu.RUnlock()
+	}
+
+	n, err := w.Write(buf)
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("%w: %d left out: %w", errCacheEntryPack, skipped, perr)
+	}
+	return int64(n), err
+}
+
+// cacheSnapshotEntry returns the message of the cache entry of q, with the TTLs
+// left at now, or false if a record of it expired.
+func cacheSnapshotEntry(q Question, entry *Message, now time.Time) (*Message, bool) {
+	msg := &Message{Response: true, Questions: []Question{q}}
+
+	sections := []struct {
+		from []Resource
+		to   *[]Resource
+	}{
+		{entry.Answers, &msg.Answers},
+		{entry.Authorities, &msg.Authorities},
+		{entry.Additionals, &msg.Additionals},
+	}
+	for _, s := range sections {
+		for _, res := range s.from {
+			if res.TTL = cacheTTL(res.TTL, now).Truncate(time.Second); res.TTL <= 0 {
+				return nil, false
+			}
+			*s.to = append(*s.to, res)
+		}
+	}
+	return msg, true
+}
+
+// ReadFrom reads the entries of a snapshot written by WriteTo from r into the
+// cache, their TTLs less the time elapsed since. The entries expired since
+// are left out.
+func (c *Cache) ReadFrom(r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	n := int64(len(b))
+	if err != nil {
+		return n, err
+	}
+
+	if !bytes.HasPrefix(b, []byte(cacheSnapshotMagic)) || len(b) < len(cacheSnapshotMagic)+8 {
+		return n, errCacheSnapshot
+	}
+	b = b[len(cacheSnapshotMagic):]
+
+	var (
+		now     = time.Now()
+		elapsed = now.Sub(time.Unix(0, int64(nbo.Uint64(b))))
+	)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	for b = b[8:]; len(b) > 0; {
+		if len(b) < 4 || int(nbo.Uint32(b)) > len(b)-4 {
+			return n, errCacheSnapshot
+		}
+		l := int(nbo.Uint32(b))
+
+		msg := new(Message)
+		if _, err := msg.Unpack(b[4 : 4+l]); err != nil {
+			return n, err
+		}
+		b = b[4+l:]
+
+		if len(msg.Questions) != 1 || !cacheSnapshotAge(msg, elapsed) {
+			continue
+		}
+		c.insert(msg, now)
+	}
+	return n, nil
+}
+
+// cacheSnapshotAge reduces the TTLs of the records of msg by elapsed, and
+// reports whether none expired.
+func cacheSnapshotAge(msg *Message, elapsed time.Duration) bool {
+	for _, rs := range [][]Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range rs {
+			if rs[i].TTL -= elapsed; rs[i].TTL <= 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SaveFile writes a snapshot of the cache to the file name, replacing it once
+// written, such as once the server is shut down; see Server.SnapshotCache. The
+// file is saved without the entries that fail to pack, whose error is still
+// returned.
+func (c *Cache) SaveFile(name string) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, werr := c.WriteTo(f)
+	if werr != nil && !errors.Is(werr, errCacheEntryPack) {
+		f.Close()
+		return werr
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	return werr
+}
+
+// LoadFile reads the snapshot of the file name written by SaveFile into the
+// cache, such as before the server is started. A missing file is no error, the
+// cache starting cold.
+func (c *Cache) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = c.ReadFrom(f)
+	return err
+}
+
+// loadSnapshot loads the snapshot of the SnapshotCache of s, if any.
+func (s *Server) loadSnapshot(ctx context.Context) {
+	if s.SnapshotCache == nil || s.SnapshotFile == "" {
+		return
+	}
+	if err := s.SnapshotCache.LoadFile(s.SnapshotFile); err != nil {
+		s.log(ctx, slog.LevelWarn, "dns cache snapshot", "file", s.SnapshotFile, "err", err)
+	}
+}
+
+// saveSnapshot saves the snapshot of the SnapshotCache of s, if any.
+func (s *Server) saveSnapshot(ctx context.Context) {
+	if s.SnapshotCache == nil || s.SnapshotFile == "" {
+		return
+	}
+	if err := s.SnapshotCache.SaveFile(s.SnapshotFile); err != nil {
+		s.log(ctx, slog.LevelError, "dns cache snapshot", "file", s.SnapshotFile, "err", err)
+	}
+}
@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	cache := new(Cache)
+	cache.insert(&Message{
+		Questions: []Question{{Name: "live.local.", Type: TypeA, Class: ClassIN}},
+		Answers: []Resource{
+			{Name: "live.local.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		},
+	}, now)
+	cache.insert(&Message{
+		Questions: []Question{{Name: "expired.local.", Type: TypeA, Class: ClassIN}},
+		Answers: []Resource{
+			{Name: "expired.local.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}},
+		},
+	}, now.Add(-time.Hour))
+
+	var buf bytes.Buffer
+	if _, err := cache.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(Cache)
+	if _, err := loaded.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, loaded.len(); want != got {
+		t.Fatalf("want %d entry loaded, got %d", want, got)
+	}
+
+	srv := &Server{
+		Handler: loaded,
+		Forwarder: &Client{
+			Transport: nopDialer{},
+			Resolver:  HandlerFunc(Refuse),
+		},
+	}
+	msg, err := srv.ServeMessage(context.Background(), &Query{
+		Message: &Message{Questions: []Question{{Name: "live.local.", Type: TypeA, Class: ClassIN}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answers) != 1 {
+		t.Fatalf("want answer from the cache loaded, got %+v", msg)
+	}
+	if ttl := msg.Answers[0].TTL; ttl > time.Hour || ttl < time.Hour-time.Minute {
+		t.Errorf("want TTL left of about an hour, got %v", ttl)
+	}
+
+	if _, err := new(Cache).ReadFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Error("want error for a truncated snapshot")
+	}
+	if _, err := new(Cache).ReadFrom(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Error("want error for a malformed snapshot")
+	}
+}
+
+func TestCacheSnapshotAge(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{
+		Answers: []Resource{{Name: "a.local.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+	}
+	if !cacheSnapshotAge(msg, time.Minute) {
+		t.Fatal("want records not expired")
+	}
+	if want, got := time.Hour-time.Minute, msg.Answers[0].TTL; want != got {
+		t.Errorf("want TTL %v, got %v", want, got)
+	}
+	if cacheSnapshotAge(msg, time.Hour) {
+		t.Error("want records expired")
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "cache")
+
+	if err := new(Cache).LoadFile(name); err != nil {
+		t.Fatalf("want missing file ignored, got %v", err)
+	}
+
+	cache := new(Cache)
+	cache.insert(&Message{
+		Questions: []Question{{Name: "a.local.", Type: TypeA, Class: ClassIN}},
+		Answers:   []Resource{{Name: "a.local.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+	}, time.Now())
+	if err := cache.SaveFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(Cache)
+	if err := loaded.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, loaded.len(); want != got {
+		t.Errorf("want %d entry loaded, got %d", want, got)
+	}
+}
+
+func TestCacheSnapshotPackError(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	long := strings.Repeat("a", 64) + ".local."
+
+	cache := new(Cache)
+	for _, name := range []string{"a.local.", long} {
+		cache.insert(&Message{
+			Questions: []Question{{Name: name, Type: TypeA, Class: ClassIN}},
+			Answers:   []Resource{{Name: name, Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}, now)
+	}
+
+	var buf bytes.Buffer
+	if _, err := cache.WriteTo(&buf); !errors.Is(err, errCacheEntryPack) {
+		t.Fatalf("want error %v, got %v", errCacheEntryPack, err)
+	}
+
+	loaded := new(Cache)
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, loaded.len(); want != got {
+		t.Errorf("want %d entry loaded, got %d", want, got)
+	}
+
+	name := filepath.Join(t.TempDir(), "cache")
+	if err := cache.SaveFile(name); !errors.Is(err, errCacheEntryPack) {
+		t.Fatalf("want error %v, got %v", errCacheEntryPack, err)
+	}
+
+	loaded = new(Cache)
+	if err := loaded.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, loaded.len(); want != got {
+		t.Errorf("want %d entry saved, got %d", want, got)
+	}
+}
+
+func TestServerSnapshotCache(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "cache")
+	now := time.Now()
+
+	entry := func(name string) *Message {
+		return &Message{
+			Questions: []Question{{Name: name, Type: TypeA, Class: ClassIN}},
+			Answers:   []Resource{{Name: name, Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}
+	}
+
+	saved := new(Cache)
+	saved.insert(entry("a.local."), now)
+	if err := saved.SaveFile(name); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := new(Cache)
+	srv := &Server{
+		Addr:          mustUnusedAddr(),
+		Handler:       cache,
+		SnapshotCache: cache,
+		SnapshotFile:  name,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe(ctx) }()
+
+	for deadline := time.Now().Add(5 * time.Second); cache.len() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("want the snapshot loaded once started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cache.insert(entry("b.local."), now)
+
+	cancel()
+	if want, got := context.Canceled, <-errc; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+
+	loaded := new(Cache)
+	if err := loaded.LoadFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, loaded.len(); want != got {
+		t.Errorf("want %d entries saved once shut down, got %d", want, got)
+	}
+}
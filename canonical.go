@@ -0,0 +1,69 @@
+package dns
+
+import "strings"
+
+// Canonicalize returns rr in the canonical form of RFC 4034, section 6.2:
+// with the domain names of its RDATA in lowercase, for the types whose
+// names RFC 4034 and RFC 6840 list. The records of an RREntry are unwrapped;
+// those of the other types, whose RDATA holds no name, or whose names are
+// compared as is, such as the records of unknown types, are returned as is.
+func Canonicalize(rr Record) Record {
+	rr, _ = unwrapRecord(rr)
+
+	switch rr := rr.(type) {
+	case *NS:
+		return &NS{NS: strings.ToLower(rr.NS)}
+	case *CNAME:
+		return &CNAME{CNAME: strings.ToLower(rr.CNAME)}
+	case *PTR:
+		return &PTR{PTR: strings.ToLower(rr.PTR)}
+	case *DNAME:
+		return &DNAME{DNAME: strings.ToLower(rr.DNAME)}
+	case *MX:
+		return &MX{Pref: rr.Pref, MX: strings.ToLower(rr.MX)}
+	case *SRV:
+		c := *rr
+		c.Target = strings.ToLower(rr.Target)
+		return &c
+	case *SOA:
+		c := *rr
+		c.NS, c.MBox = strings.ToLower(rr.NS), strings.ToLower(rr.MBox)
+		return &c
+	}
+	return rr
+}
+
+// CanonicalBytes returns the canonical wire format of res, see RFC 4034,
+// section 6.2: its owner name in lowercase, its record canonicalized, and no
+// name compressed. The TTL is that of res, whatever the TTL of an RREntry,
+// so that the records of an RRset are packed with the same TTL, such as the
+// Original TTL of the signature covering them.
+func CanonicalBytes(res Resource) ([]byte, error) {
+	res.Name = strings.ToLower(res.Name)
+	res.Record = Canonicalize(res.Record)
+	return res.Pack(nil, nil)
+}
+
+// canonicalLabels returns the labels of name from the root, the order in which
+// names are compared, see RFC 4034, section 6.1.
+func canonicalLabels(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil
+	}
+
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+func compareLabels(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rr, want Record
+	}{
+		{&NS{NS: "NS1.Example."}, &NS{NS: "ns1.example."}},
+		{&MX{Pref: 10, MX: "Mail.Example."}, &MX{Pref: 10, MX: "mail.example."}},
+		{&SRV{Priority: 1, Weight: 2, Port: 53, Target: "DNS.Example."}, &SRV{Priority: 1, Weight: 2, Port: 53, Target: "dns.example."}},
+		{&SOA{NS: "NS1.Example.", MBox: "Admin.Example.", Serial: 1}, &SOA{NS: "ns1.example.", MBox: "admin.example.", Serial: 1}},
+		{&RREntry{Record: &CNAME{CNAME: "WWW.Example."}, TTL: time.Minute}, &CNAME{CNAME: "www.example."}},
+		{&TXT{TXT: []string{"Mixed Case"}}, &TXT{TXT: []string{"Mixed Case"}}},
+	}
+
+	for _, test := range tests {
+		if want, got := test.want, Canonicalize(test.rr); !reflect.DeepEqual(want, got) {
+			t.Errorf("want canonical record %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestCanonicalBytes(t *testing.T) {
+	t.Parallel()
+
+	b, err := CanonicalBytes(Resource{
+		Name:   "WWW.Example.",
+		Class:  ClassIN,
+		TTL:    time.Hour,
+		Record: &RREntry{Record: &CNAME{CNAME: "Host.WWW.Example."}, TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0,
+		0, 5, // CNAME
+		0, 1, // IN
+		0, 0, 0x0e, 0x10, // TTL of the resource
+		0, 18,
+		4, 'h', 'o', 's', 't', 3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0, // not compressed
+	}
+	if !bytes.Equal(want, b) {
+		t.Errorf("want canonical bytes %v, got %v", want, b)
+	}
+
+	a, err := CanonicalBytes(Resource{Name: "ns1.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := CanonicalBytes(Resource{Name: "NS1.EXAMPLE.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}); err != nil || !bytes.Equal(a, c) {
+		t.Errorf("want same canonical bytes %v, got %v (%v)", a, c, err)
+	}
+}
@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CapturePacket is a DNS message read or written by a Server, as passed to
+// its Capture hook. Data must not be modified.
+type CapturePacket struct {
+	Time    time.Time
+	Network string // "udp" or "tcp"
+
+	Src, Dst net.Addr
+
+	Response bool
+	Data     []byte // packed message, without the TCP length prefix
+}
+
+const (
+	pcapMagic   = 0xa1b2c3d4
+	pcapSnapLen = 0xffff
+
+	// pcapLinkTypeRaw is the link type of packets starting with an IPv4 or
+	// IPv6 header.
+	pcapLinkTypeRaw = 101
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+)
+
+// PcapWriter writes the packets captured by a Server to a pcap file, such as
+// for inspection with Wireshark or tcpdump. Captured messages are wrapped in
+// synthesized IP and UDP or TCP headers built from their addresses; the TCP
+// segments of a connection carry sequence numbers counted from zero, without
+// the handshake.
+//
+// Its Capture method is assigned to the Capture hook of the Server. It is safe
+// for concurrent use.
+type PcapWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	err  error
+	seqs map[pcapFlow]uint32 // next sequence number of each TCP flow
+}
+
+type pcapFlow struct {
+	src, dst string
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a PcapWriter of
+// the following packets.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w, seqs: make(map[pcapFlow]uint32)}, nil
+}
+
+// Capture writes pkt to the pcap file. Once a write fails, the following
+// packets are discarded and Err returns the error.
+func (p *PcapWriter) Capture(pkt CapturePacket) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return
+	}
+
+	var payload []byte
+	if pkt.Network == "tcp" {
+		payload = p.tcpSegment(pkt)
+	} else {
+		payload = udpDatagram(pkt)
+	}
+	b := ipPacket(pkt, payload)
+
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(pkt.Time.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(pkt.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(b)))
+
+	if _, p.err = p.w.Write(hdr[:]); p.err == nil {
+		_, p.err = p.w.Write(b)
+	}
+}
+
+// Err returns the error of the first failed write.
+func (p *PcapWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// udpDatagram returns the UDP header and data of pkt, with a checksum set
+// later by ipPacket.
+func udpDatagram(pkt CapturePacket) []byte {
+	b := make([]byte, 8, 8+len(pkt.Data))
+	nbo.PutUint16(b[0:], uint16(addrPort(pkt.Src)))
+	nbo.PutUint16(b[2:], uint16(addrPort(pkt.Dst)))
+	nbo.PutUint16(b[4:], uint16(8+len(pkt.Data)))
+
+	return append(b, pkt.Data...)
+}
+
+// tcpSegment returns the TCP header and length prefixed data of pkt, numbered
+// after the previous segments of its flow.
+func (p *PcapWriter) tcpSegment(pkt CapturePacket) []byte {
+	flow := pcapFlow{src: pkt.Src.String(), dst: pkt.Dst.String()}
+	seq, ack := p.seqs[flow], p.seqs[pcapFlow{src: flow.dst, dst: flow.src}]
+	p.seqs[flow] = seq + uint32(2+len(pkt.Data))
+
+	b := make([]byte, 22, 22+len(pkt.Data))
+	nbo.PutUint16(b[0:], uint16(addrPort(pkt.Src)))
+	nbo.PutUint16(b[2:], uint16(addrPort(pkt.Dst)))
+	nbo.PutUint32(b[4:], seq)
+	nbo.PutUint32(b[8:], ack)
+	b[12] = 5 << 4      // data offset
+	b[13] = 0x08 | 0x10 // PSH, ACK
+	nbo.PutUint16(b[14:], 0xffff)
+	nbo.PutUint16(b[20:], uint16(len(pkt.Data)))
+
+	return append(b, pkt.Data...)
+}
+
+// ipPacket returns payload in an IPv4 packet if both addresses of pkt are IPv4
+// ones, or else in an IPv6 packet, and sets the checksum of the UDP or TCP
+// header.
+func ipPacket(pkt CapturePacket, payload []byte) []byte {
+	proto, csum := byte(ipProtoUDP), 6
+	if pkt.Network == "tcp" {
+		proto, csum = ipProtoTCP, 16
+	}
+
+	src, dst := addrIP(pkt.Src), addrIP(pkt.Dst)
+	if src == nil {
+		src = net.IPv4zero
+	}
+	if dst == nil {
+		dst = net.IPv4zero
+	}
+
+	var hdr []byte
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		hdr = make([]byte, 20)
+		hdr[0] = 0x45 // version 4, 5 words
+		nbo.PutUint16(hdr[2:], uint16(20+len(payload)))
+		nbo.PutUint16(hdr[6:], 0x4000) // don't fragment
+		hdr[8], hdr[9] = 64, proto
+		copy(hdr[12:], src4)
+		copy(hdr[16:], dst4)
+		nbo.PutUint16(hdr[10:], icmpChecksum(hdr))
+
+		src, dst = src4, dst4
+	} else {
+		hdr = make([]byte, 40)
+		hdr[0] = 0x60 // version 6
+		nbo.PutUint16(hdr[4:], uint16(len(payload)))
+		hdr[6], hdr[7] = proto, 64
+		copy(hdr[8:], src.To16())
+		copy(hdr[24:], dst.To16())
+
+		src, dst = src.To16(), dst.To16()
+	}
+
+	// the pseudo header of RFC 768 and RFC 8200, section 8.1
+	pseudo := make([]byte, 0, 2*len(src)+8+len(payload))
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	pseudo = append(pseudo, 0, 0, byte(len(payload)>>8), byte(len(payload)), 0, 0, 0, proto)
+	pseudo = append(pseudo, payload...)
+
+	sum := icmpChecksum(pseudo)
+	if sum == 0 && proto == ipProtoUDP {
+		sum = 0xffff
+	}
+	nbo.PutUint16(payload[csum:], sum)
+
+	return append(hdr, payload...)
+}
+
+// addrPort returns the port of addr, or 0 if it has none.
+func addrPort(addr net.Addr) int {
+	switch addr := addr.(type) {
+	case nil:
+		return 0
+	case *net.UDPAddr:
+		return addr.Port
+	case *net.TCPAddr:
+		return addr.Port
+	}
+
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
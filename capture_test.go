@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerCapture(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		packets []CapturePacket
+	)
+
+	srv := &Server{
+		Addr:    mustUnusedAddr(),
+		Handler: &answerHandler{answers},
+		Capture: func(pkt CapturePacket) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			packets = append(packets, pkt)
+		},
+	}
+	mustStart(srv)
+
+	for _, network := range []string{"udp", "tcp"} {
+		addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raddr net.Addr = addr
+		if network == "tcp" {
+			raddr = &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = (&Client{Transport: &Transport{DisablePipelining: true}}).Do(ctx, &Query{
+			RemoteAddr: raddr,
+			Message:    &Message{Questions: []Question{questions["A"]}},
+		})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if want, got := 4, len(packets); want != got {
+		t.Fatalf("want %d packets, got %d", want, got)
+	}
+
+	for i, pkt := range packets {
+		if want, got := []string{"udp", "udp", "tcp", "tcp"}[i], pkt.Network; want != got {
+			t.Errorf("packet %d: want network %s, got %s", i, want, got)
+		}
+		if want, got := i%2 == 1, pkt.Response; want != got {
+			t.Errorf("packet %d: want response %t, got %t", i, want, got)
+		}
+
+		msg := new(Message)
+		if _, err := msg.Unpack(pkt.Data); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if want, got := pkt.Response, msg.Response; want != got {
+			t.Errorf("packet %d: want QR %t, got %t", i, want, got)
+		}
+
+		server := pkt.Dst
+		if pkt.Response {
+			server = pkt.Src
+		}
+		if want, got := addrPort(mustAddr(srv.Addr)), addrPort(server); want != got {
+			t.Errorf("packet %d: want server port %d, got %d", i, want, got)
+		}
+	}
+}
+
+func TestPcapWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	pw, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := (&Message{Questions: []Question{questions["A"]}}).Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Unix(1700000000, 250000000)
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53000}
+	server := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53}
+
+	pw.Capture(CapturePacket{Time: ts, Network: "udp", Src: client, Dst: server, Data: msg})
+	pw.Capture(CapturePacket{
+		Time:    ts,
+		Network: "tcp",
+		Src:     &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53000},
+		Dst:     &net.TCPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53},
+		Data:    msg,
+	})
+	if err := pw.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if want, got := uint32(pcapMagic), binary.LittleEndian.Uint32(b); want != got {
+		t.Fatalf("want magic %#x, got %#x", want, got)
+	}
+	if want, got := uint32(pcapLinkTypeRaw), binary.LittleEndian.Uint32(b[20:]); want != got {
+		t.Errorf("want link type %d, got %d", want, got)
+	}
+	b = b[24:]
+
+	// IPv4 and UDP
+	if want, got := uint32(ts.Unix()), binary.LittleEndian.Uint32(b); want != got {
+		t.Errorf("want timestamp %d, got %d", want, got)
+	}
+	if want, got := uint32(250000), binary.LittleEndian.Uint32(b[4:]); want != got {
+		t.Errorf("want microseconds %d, got %d", want, got)
+	}
+	n := int(binary.LittleEndian.Uint32(b[8:]))
+	pkt := b[16 : 16+n]
+	b = b[16+n:]
+
+	if want, got := 20+8+len(msg), len(pkt); want != got {
+		t.Fatalf("want packet length %d, got %d", want, got)
+	}
+	if icmpChecksum(pkt[:20]) != 0 {
+		t.Error("bad IPv4 header checksum")
+	}
+	if want, got := ipProtoUDP, int(pkt[9]); want != got {
+		t.Errorf("want protocol %d, got %d", want, got)
+	}
+	if !net.IP(pkt[12:16]).Equal(client.IP) || !net.IP(pkt[16:20]).Equal(server.IP) {
+		t.Errorf("want addresses %v > %v, got %v > %v", client.IP, server.IP, net.IP(pkt[12:16]), net.IP(pkt[16:20]))
+	}
+	if want, got := 53, int(nbo.Uint16(pkt[22:])); want != got {
+		t.Errorf("want destination port %d, got %d", want, got)
+	}
+	if !bytes.Equal(msg, pkt[28:]) {
+		t.Error("want message in the UDP datagram")
+	}
+
+	pseudo := append(append([]byte{}, pkt[12:20]...), 0, ipProtoUDP, 0, byte(8+len(msg)))
+	if icmpChecksum(append(pseudo, pkt[20:]...)) != 0 {
+		t.Error("bad UDP checksum")
+	}
+
+	// IPv6 and TCP
+	n = int(binary.LittleEndian.Uint32(b[8:]))
+	pkt = b[16 : 16+n]
+
+	if want, got := 40+20+2+len(msg), len(pkt); want != got {
+		t.Fatalf("want packet length %d, got %d", want, got)
+	}
+	if want, got := byte(0x60), pkt[0]; want != got {
+		t.Errorf("want IPv6 version, got %#x", got)
+	}
+	if want, got := ipProtoTCP, int(pkt[6]); want != got {
+		t.Errorf("want next header %d, got %d", want, got)
+	}
+	if want, got := len(msg), int(nbo.Uint16(pkt[60:])); want != got {
+		t.Errorf("want length prefix %d, got %d", want, got)
+	}
+	if !bytes.Equal(msg, pkt[62:]) {
+		t.Error("want message in the TCP segment")
+	}
+}
+
+func mustAddr(addr string) net.Addr {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		panic(err)
+	}
+	return udpAddr
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// catalogVersion is the schema version of the catalog zones supported, see
+// RFC 9432, section 4.2.
+const catalogVersion = "2"
+
+var errInvalidCatalog = errors.New("invalid catalog zone")
+
+// Catalog is the list of member zones of a catalog zone, see RFC 9432.
+type Catalog struct {
+	Members []CatalogMember
+}
+
+// CatalogMember is a member zone of a catalog.
+type CatalogMember struct {
+	// ID is the unique label of the member within the catalog. If empty, an
+	// ID derived from Zone is used by Zone.
+	ID string
+
+	// Zone is the domain name of the member zone.
+	Zone string
+
+	// Group lists the groups of the member, used by consumers to apply
+	// different configurations to members.
+	Group []string
+
+	// COO, if not empty, is the catalog zone the member is migrating to.
+	COO string
+}
+
+// ParseCatalog returns the catalog of the catalog zone z, such as a zone
+// returned by Client.Transfer. A member zone listed more than once is only
+// kept under its first ID in sorted order.
+func ParseCatalog(z *Zone) (*Catalog, error) {
+	if !txtIs(z.GetRecords("version", TypeTXT), catalogVersion) {
+		return nil, errInvalidCatalog
+	}
+
+	var ids []string
+	for _, k := range z.Names() {
+		if id := strings.TrimSuffix(k, ".zones"); id != k && id != "" && !strings.Contains(id, ".") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var (
+		c    = new(Catalog)
+		seen = make(map[string]bool)
+	)
+	for _, id := range ids {
+		ptrs := z.GetRecords(id+".zones", TypePTR)
+		if len(ptrs) == 0 {
+			continue
+		}
+		if len(ptrs) > 1 {
+			return nil, errInvalidCatalog
+		}
+
+		m := CatalogMember{
+			ID:   id,
+			Zone: ptrName(ptrs[0]),
+		}
+		if seen[NormalizeKey(m.Zone)] {
+			continue
+		}
+		seen[NormalizeKey(m.Zone)] = true
+
+		for _, rr := range z.GetRecords("group."+id+".zones", TypeTXT) {
+			if rr, _ := unwrapRecord(rr); rr != nil {
+				m.Group = append(m.Group, strings.Join(rr.(*TXT).TXT, ""))
+			}
+		}
+		if coos := z.GetRecords("coo."+id+".zones", TypePTR); len(coos) == 1 {
+			m.COO = ptrName(coos[0])
+		}
+
+		c.Members = append(c.Members, m)
+	}
+	return c, nil
+}
+
+// Zone returns the catalog zone origin listing the members of c, with the SOA
+// record soa.
+func (c *Catalog) Zone(origin string, soa *SOA) *Zone {
+	all := map[string]map[Type][]Record{
+		"": {
+			TypeNS: {&NS{NS: "invalid."}},
+		},
+		"version": {
+			TypeTXT: {&TXT{TXT: []string{catalogVersion}}},
+		},
+	}
+
+	for _, m := range c.Members {
+		id := m.ID
+		if id == "" {
+			id = catalogID(m.Zone)
+		}
+
+		all[id+".zones"] = map[Type][]Record{
+			TypePTR: {&PTR{PTR: m.Zone}},
+		}
+		if len(m.Group) > 0 {
+			txts := make([]Record, 0, len(m.Group))
+			for _, g := range m.Group {
+				txts = append(txts, &TXT{TXT: []string{g}})
+			}
+			all["group."+id+".zones"] = map[Type][]Record{TypeTXT: txts}
+		}
+		if m.COO != "" {
+			all["coo."+id+".zones"] = map[Type][]Record{
+				TypePTR: {&PTR{PTR: m.COO}},
+			}
+		}
+	}
+
+	return &Zone{
+		Origin: origin,
+		SOA:    soa,
+		RRs:    NewRRSet(all),
+	}
+}
+
+// catalogID returns a stable unique ID for the member zone name.
+func catalogID(zone string) string {
+	sum := sha256.Sum256([]byte(NormalizeKey(zone)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func txtIs(rrs []Record, v string) bool {
+	if len(rrs) != 1 {
+		return false
+	}
+	rr, _ := unwrapRecord(rrs[0])
+	txt, ok := rr.(*TXT)
+	return ok && strings.Join(txt.TXT, "") == v
+}
+
+func ptrName(rr Record) string {
+	rr, _ = unwrapRecord(rr)
+	if ptr, ok := rr.(*PTR); ok {
+		return ptr.PTR
+	}
+	return ""
+}
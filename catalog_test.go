@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCatalog(t *testing.T) {
+	t.Parallel()
+
+	catalog := &Catalog{
+		Members: []CatalogMember{
+			{
+				ID:    "a",
+				Zone:  "example.com.",
+				Group: []string{"signed"},
+				COO:   "other.catalog.invalid.",
+			},
+			{
+				Zone: "example.net.",
+			},
+		},
+	}
+
+	zone := catalog.Zone("catalog.invalid.", &SOA{
+		NS:     "invalid.",
+		MBox:   "invalid.",
+		Serial: 1,
+	})
+	zone.TTL = time.Hour
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	xfr, err := new(Client).Transfer(ctx, addr, "catalog.invalid.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseCatalog(xfr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Catalog{
+		Members: []CatalogMember{
+			catalog.Members[0],
+			{
+				ID:   catalogID("example.net."),
+				Zone: "example.net.",
+			},
+		},
+	}
+	if want.Members[1].ID < want.Members[0].ID {
+		want.Members[0], want.Members[1] = want.Members[1], want.Members[0]
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want catalog %+v, got %+v", want, got)
+	}
+
+	xfr.DeleteKey("version")
+	if _, err := ParseCatalog(xfr); err != errInvalidCatalog {
+		t.Errorf("want error %v for a catalog without version, got %v", errInvalidCatalog, err)
+	}
+}
@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultCertInterval is how often a CertReloader without an Interval checks
+// its files.
+const defaultCertInterval = 10 * time.Second
+
+var errNoCertificate = errors.New("no certificate loaded")
+
+// CertReloader is a TLS certificate loaded from a certificate and a key file,
+// and reloaded when they change, for the long-lived endpoints of DNS over TLS
+// servers whose certificates rotate. Its GetCertificate is set as the
+// GetCertificate of a Server.TLSConfig or of an SNIRoute. Each handshake
+// uses the certificate loaded last, so a reload does not drop the connections
+// established.
+//
+// A certificate manager such as an autocert.Manager plugs in the same way,
+// through its GetCertificate method.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	// Interval is how often Watch checks the files for changes. If zero,
+	// they are checked every 10 seconds.
+	Interval time.Duration
+
+	// Logger, if not nil, logs the reloads, and the failures to reload.
+	Logger Logger
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	stamp certStamp // of the files of cert
+}
+
+// certStamp identifies the versions of the certificate and key files.
+type certStamp struct {
+	cert, key os.FileInfo
+}
+
+func (s certStamp) equal(o certStamp) bool {
+	same := func(a, b os.FileInfo) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+	}
+	return same(s.cert, o.cert) && same(s.key, o.key)
+}
+
+// Load reads the certificate and key files. If they fail to load, the
+// certificate loaded before is kept.
+func (r *CertReloader) Load() error {
+	stamp, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	return r.load(stamp)
+}
+
+func (r *CertReloader) load(stamp certStamp) error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert, r.stamp = &cert, stamp
+	return nil
+}
+
+func (r *CertReloader) statFiles() (certStamp, error) {
+	cert, err := os.Stat(r.CertFile)
+	if err != nil {
+		return certStamp{}, err
+	}
+	key, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return certStamp{}, err
+	}
+	return certStamp{cert: cert, key: key}, nil
+}
+
+// GetCertificate returns the certificate loaded last, loading it on the first
+// call if Load was not called.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	cert := r.cert
+	r.mu.RUnlock()
+
+	if cert != nil {
+		return cert, nil
+	}
+	if err := r.Load(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cert == nil {
+		return nil, errNoCertificate
+	}
+	return r.cert, nil
+}
+
+// Watch checks the files every Interval until ctx is done, and reloads the
+// certificate once they change. A pair of files failing to load, such as a
+// certificate renewed before its key, is retried at the next check. Watch
+// returns ctx.Err().
+func (r *CertReloader) Watch(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultCertInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		stamp, err := r.statFiles()
+		if err != nil {
+			r.log(ctx, slog.LevelWarn, "dns certificate reload", "cert", r.CertFile, "err", err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := !stamp.equal(r.stamp)
+		r.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+		if err := r.load(stamp); err != nil {
+			r.log(ctx, slog.LevelWarn, "dns certificate reload", "cert", r.CertFile, "err", err)
+			continue
+		}
+		r.log(ctx, slog.LevelInfo, "dns certificate reload", "cert", r.CertFile)
+	}
+}
+
+func (r *CertReloader) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if r.Logger != nil {
+		r.Logger.Log(ctx, level, msg, args...)
+	}
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/internal/must"
+)
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	ca := must.CACert("ca.dev", nil)
+	dir := t.TempDir()
+
+	r := &CertReloader{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		Interval: 5 * time.Millisecond,
+	}
+
+	write := func(hostname string, mtime time.Time) {
+		cert := must.LeafCert(hostname, ca)
+		for file, data := range map[string]string{r.CertFile: cert.CertPEM(), r.KeyFile: cert.KeyPEM()} {
+			if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(file, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := r.GetCertificate(nil); err == nil {
+		t.Fatal("want an error without certificate files")
+	}
+
+	now := time.Now()
+	write("one.dev", now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+
+	srv := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		TLSConfig: &tls.Config{GetCertificate: r.GetCertificate},
+	}
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(ctx, ln)
+
+	dial := func(serverName string) (*tls.Conn, error) {
+		return tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			ServerName: serverName,
+			RootCAs:    must.CertPool(ca.TLS()),
+		})
+	}
+
+	conn, err := dial("one.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a half rotated pair is not loaded
+	cert := must.LeafCert("two.dev", ca)
+	if err := os.WriteFile(r.CertFile, []byte(cert.CertPEM()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if c, err := dial("one.dev"); err != nil {
+		t.Fatalf("want the certificate kept, got %v", err)
+	} else {
+		c.Close()
+	}
+
+	write("two.dev", now.Add(time.Minute))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c, err := dial("two.dev")
+		if err == nil {
+			c.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate not reloaded: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the connection established before the reload is still served
+	sc := &StreamConn{Conn: conn}
+	if err := sc.Send(&Message{
+		ID:        1,
+		Questions: []Question{{Name: "app.dev.", Type: TypeA, Class: ClassIN}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := new(Message)
+	if err := sc.Recv(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answer, got %d", want, got)
+	}
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"context"
+	"strings"
+)
+
+// defaultMaxTargets is the number of targets resolved by a TargetChaser
+// without a MaxTargets.
+const defaultMaxTargets = 8
+
+// TargetChaser resolves the addresses of the targets of the SRV, MX and NS
+// answers of a response, so that the response holds every address needed to
+// reach them. The addresses found in the additional section of the response
+// are used as is; for each other target, A and AAAA queries are sent to the
+// server of the query, and their answers are added to the additional
+// section.
+//
+// A TargetChaser is added to a Client with Use:
+//
+//	client.Use(new(dns.TargetChaser).Intercept)
+type TargetChaser struct {
+	// Types are the types of the queries sent for a target. If empty, A
+	// and AAAA queries are sent.
+	Types []Type
+
+	// MaxTargets bounds the targets resolved for a response, since each
+	// may cost a query per type. If zero, 8 targets are resolved at most.
+	MaxTargets int
+}
+
+// Intercept returns a RoundTripper sending the queries to next, and the
+// queries for the targets of the responses too. A failed target query leaves
+// the target unresolved, the response is returned nonetheless.
+func (tc *TargetChaser) Intercept(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+		msg, err := next.Do(ctx, query)
+		if err != nil || msg.RCode != NoError {
+			return msg, err
+		}
+
+		var chased []Resource
+		for _, name := range tc.targets(msg) {
+			for _, t := range tc.types() {
+				res, err := next.Do(ctx, tc.query(query, name, t))
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					continue
+				}
+				if res.RCode == NoError {
+					chased = append(chased, res.Answers...)
+				}
+			}
+		}
+
+		if len(chased) > 0 {
+			msg.Additionals = insertAdditionals(msg.Additionals, chased)
+		}
+		return msg, nil
+	})
+}
+
+func (tc *TargetChaser) types() []Type {
+	if len(tc.Types) == 0 {
+		return []Type{TypeA, TypeAAAA}
+	}
+	return tc.Types
+}
+
+// targets returns the targets of the answers of msg without an address in the
+// additional section.
+func (tc *TargetChaser) targets(msg *Message) []string {
+	max := tc.MaxTargets
+	if max <= 0 {
+		max = defaultMaxTargets
+	}
+
+	var targets []string
+	for _, res := range msg.Answers {
+		var name string
+		switch rr := res.Record.(type) {
+		case *SRV:
+			name = rr.Target
+		case *MX:
+			name = rr.MX
+		case *NS:
+			name = rr.NS
+		default:
+			continue
+		}
+
+		// a target of "." means no service, see RFC 2782 and RFC 7505
+		if name == "." || name == "" || hasAddress(msg.Additionals, name) || hasName(targets, name) {
+			continue
+		}
+		if len(targets) == max {
+			break
+		}
+		targets = append(targets, name)
+	}
+	return targets
+}
+
+// query returns the query of type t for the target name, sent as query was.
+func (tc *TargetChaser) query(query *Query, name string, t Type) *Query {
+	msg := &Message{
+		RecursionDesired: query.RecursionDesired,
+		CheckingDisabled: query.CheckingDisabled,
+		Questions:        []Question{{Name: name, Type: t, Class: ClassIN}},
+	}
+	for _, res := range query.Additionals {
+		if _, ok := res.Record.(*OPT); ok {
+			msg.Additionals = []Resource{res}
+		}
+	}
+
+	return &Query{
+		Message:    msg,
+		RemoteAddr: query.RemoteAddr,
+	}
+}
+
+func hasAddress(rs []Resource, name string) bool {
+	for _, res := range rs {
+		if t := res.Record.Type(); (t == TypeA || t == TypeAAAA) && strings.EqualFold(res.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertAdditionals returns the additional section rs with chased, before its
+// OPT record, if any.
+func insertAdditionals(rs, chased []Resource) []Resource {
+	v := make([]Resource, 0, len(rs)+len(chased))
+	var opt []Resource
+	for _, res := range rs {
+		if _, ok := res.Record.(*OPT); ok {
+			opt = append(opt, res)
+			continue
+		}
+		v = append(v, res)
+	}
+	v = append(v, chased...)
+	return append(v, opt...)
+}
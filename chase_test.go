@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTargetChaser(t *testing.T) {
+	t.Parallel()
+
+	zone := func(full bool) *Zone {
+		return &Zone{
+			Origin:        "chase.dev.",
+			TTL:           time.Hour,
+			SOA:           &SOA{NS: "ns.chase.dev.", MBox: "hostmaster.chase.dev."},
+			FullResponses: full,
+			RRs: NewRRSet(map[string]map[Type][]Record{
+				"": {
+					TypeNS: {&NS{NS: "ns.chase.dev."}},
+					TypeMX: {
+						&MX{Pref: 10, MX: "mail.chase.dev."},
+						&MX{Pref: 20, MX: "MAIL.chase.dev."},
+						&MX{Pref: 30, MX: "gone.chase.dev."},
+					},
+				},
+				"_sip._udp": {
+					TypeSRV: {
+						&SRV{Priority: 1, Port: 5060, Target: "sip.chase.dev."},
+						&SRV{Priority: 2, Target: "."},
+					},
+				},
+				"ns":   {TypeA: {&A{A: net.IPv4(192, 0, 2, 53).To4()}}},
+				"mail": {TypeA: {&A{A: net.IPv4(192, 0, 2, 25).To4()}}, TypeAAAA: {&AAAA{AAAA: net.ParseIP("2001:db8::25")}}},
+				"sip":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 5).To4()}}},
+			}),
+		}
+	}
+
+	tests := []struct {
+		name string
+
+		zone       *Zone
+		chaser     *TargetChaser
+		question   Question
+		queries    int32
+		additional []string
+	}{
+		{
+			name:       "MX",
+			zone:       zone(false),
+			chaser:     new(TargetChaser),
+			question:   Question{Name: "chase.dev.", Type: TypeMX, Class: ClassIN},
+			queries:    1 + 2*2, // mail and gone
+			additional: []string{"192.0.2.25", "2001:db8::25"},
+		},
+		{
+			name:       "SRV",
+			zone:       zone(false),
+			chaser:     &TargetChaser{Types: []Type{TypeA}},
+			question:   Question{Name: "_sip._udp.chase.dev.", Type: TypeSRV, Class: ClassIN},
+			queries:    1 + 1, // not "."
+			additional: []string{"192.0.2.5"},
+		},
+		{
+			name:       "max targets",
+			zone:       zone(false),
+			chaser:     &TargetChaser{MaxTargets: 1},
+			question:   Question{Name: "chase.dev.", Type: TypeMX, Class: ClassIN},
+			queries:    1 + 2,
+			additional: []string{"192.0.2.25", "2001:db8::25"},
+		},
+		{
+			name:       "glue in the response",
+			zone:       zone(true),
+			chaser:     &TargetChaser{Types: []Type{TypeA}},
+			question:   Question{Name: "chase.dev.", Type: TypeNS, Class: ClassIN},
+			queries:    1,
+			additional: []string{"192.0.2.53"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := mustServer(test.zone)
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var queries int32
+
+			client := new(Client)
+			client.Use(test.chaser.Intercept, func(next RoundTripper) RoundTripper {
+				return RoundTripperFunc(func(ctx context.Context, q *Query) (*Message, error) {
+					atomic.AddInt32(&queries, 1)
+					return next.Do(ctx, q)
+				})
+			})
+
+			msg, err := client.Do(context.Background(), &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Questions: []Question{test.question},
+					Additionals: []Resource{
+						{Name: ".", Class: 1232, Record: &OPT{}},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.queries, atomic.LoadInt32(&queries); want != got {
+				t.Errorf("want %d queries, got %d", want, got)
+			}
+
+			var additional []string
+			for _, res := range msg.Additionals {
+				switch rr := res.Record.(type) {
+				case *A:
+					additional = append(additional, rr.A.String())
+				case *AAAA:
+					additional = append(additional, rr.AAAA.String())
+				}
+			}
+			if want, got := test.additional, additional; !reflect.DeepEqual(want, got) {
+				t.Errorf("want additional addresses %q, got %q", want, got)
+			}
+
+			var opts int
+			for _, res := range msg.Additionals {
+				if _, ok := res.Record.(*OPT); ok {
+					opts++
+				}
+			}
+			if want, got := 1, opts; want != got {
+				t.Errorf("want %d OPT record, got %d", want, got)
+			}
+		})
+	}
+}
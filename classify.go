@@ -0,0 +1,229 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// classifierWindow is the period the client rates of a Server are counted
+// for, and the previous one.
+const classifierWindow = 10 * time.Second
+
+// The defaults of a NXDomainDetector.
+const (
+	defaultNXDomainRatio        = 0.5
+	defaultNXDomainMinResponses = 50
+)
+
+// A QueryAction is what a Server does with a query, as classified by its
+// Classifier.
+type QueryAction int
+
+const (
+	// QueryServe serves the query.
+	QueryServe QueryAction = iota
+
+	// QueryDrop drops the query without a response.
+	QueryDrop
+
+	// QueryTruncate answers a UDP query with an empty truncated response,
+	// so that the client retries over TCP, which a spoofed source cannot.
+	// A TCP query is served.
+	QueryTruncate
+
+	// QueryTarpit serves the query, its response delayed by the Tarpit of
+	// the server, or by a second, to slow the scanners and the attacks down
+	// without blocking them.
+	QueryTarpit
+)
+
+// ClientRates are the counters of the queries of a client of a Server, and of
+// their responses, over the last 10 to 20 seconds.
+type ClientRates struct {
+	Queries   uint64        // queries classified
+	Responses uint64        // responses to the queries served
+	NXDomain  uint64        // of them, the "Name Error" responses
+	Window    time.Duration // the period counted
+}
+
+// A QueryClassifier classifies the queries of a Server, such as to detect the
+// random subdomain attacks, also known as water torture, whose queries for
+// names that do not exist, sent through many resolvers, flood the servers
+// of a zone.
+type QueryClassifier interface {
+	// Classify returns the action of the query for q from client, given the
+	// rates of the earlier queries of the client. The rates are zero for a
+	// query without a client address.
+	Classify(ctx context.Context, client net.Addr, q Question, rates ClientRates) QueryAction
+}
+
+// The QueryClassifierFunc type is an adapter to allow the use of ordinary
+// functions as query classifiers.
+type QueryClassifierFunc func(context.Context, net.Addr, Question, ClientRates) QueryAction
+
+// Classify calls f(ctx, client, q, rates).
+func (f QueryClassifierFunc) Classify(ctx context.Context, client net.Addr, q Question, rates ClientRates) QueryAction {
+	return f(ctx, client, q, rates)
+}
+
+// NXDomainDetector is a QueryClassifier of the queries of the clients whose
+// responses are mostly "Name Error" ones, those of random subdomain attacks,
+// or of scanners walking a zone.
+type NXDomainDetector struct {
+	// Ratio is the fraction of the responses to a client that are "Name
+	// Error" ones past which its queries are classified. If zero, 0.5.
+	Ratio float64
+
+	// MinResponses is the number of responses to a client before its
+	// queries are classified. If zero, 50.
+	MinResponses uint64
+
+	// Action is what is done with the queries classified. If QueryServe,
+	// the zero value, they are truncated.
+	Action QueryAction
+}
+
+// Classify returns the Action of d for the queries of a client whose rates are
+// past the ratio, or QueryServe.
+func (d *NXDomainDetector) Classify(ctx context.Context, client net.Addr, q Question, rates ClientRates) QueryAction {
+	min := d.MinResponses
+	if min == 0 {
+		min = defaultNXDomainMinResponses
+	}
+	ratio := d.Ratio
+	if ratio <= 0 {
+		ratio = defaultNXDomainRatio
+	}
+
+	if rates.Responses < min || float64(rates.NXDomain) <= ratio*float64(rates.Responses) {
+		return QueryServe
+	}
+	if d.Action == QueryServe {
+		return QueryTruncate
+	}
+	return d.Action
+}
+
+// clientRates counts the queries of the clients of a Server and their
+// responses, in the current window and the previous one.
+type clientRates struct {
+	mu        sync.Mutex
+	start     time.Time // of the current window
+	prevStart time.Time // of the previous window, zero if none
+	clients   map[string]*clientCounts
+}
+
+type clientCounts struct {
+	cur, prev rateCounts
+}
+
+type rateCounts struct {
+	queries, responses, nxdomain uint64
+}
+
+// query counts a query of client, and returns the rates of the client before
+// it.
+func (c *clientRates) query(client string, now time.Time) ClientRates {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cc := c.counts(client, now)
+
+	start := c.start
+	if !c.prevStart.IsZero() {
+		start = c.prevStart
+	}
+	rates := ClientRates{
+		Queries:   cc.cur.queries + cc.prev.queries,
+		Responses: cc.cur.responses + cc.prev.responses,
+		NXDomain:  cc.cur.nxdomain + cc.prev.nxdomain,
+		Window:    now.Sub(start),
+	}
+
+	cc.cur.queries++
+	return rates
+}
+
+// response counts a response of RCODE rcode to client.
+func (c *clientRates) response(client string, rcode RCode, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cc := c.counts(client, now)
+	cc.cur.responses++
+	if rcode == NXDomain {
+		cc.cur.nxdomain++
+	}
+}
+
+// counts returns the counters of client, once the windows are shifted to now.
+// The clients without queries in the previous window are forgotten.
+//
+// c.mu held
+func (c *clientRates) counts(client string, now time.Time) *clientCounts {
+	switch elapsed := now.Sub(c.start); {
+	case c.clients == nil || elapsed >= 2*classifierWindow || elapsed < 0:
+		c.start, c.prevStart, c.clients = now, time.Time{}, make(map[string]*clientCounts)
+	case elapsed >= classifierWindow:
+		for k, cc := range c.clients {
+			if cc.cur == (rateCounts{}) {
+				delete(c.clients, k)
+				continue
+			}
+			cc.prev, cc.cur = cc.cur, rateCounts{}
+		}
+		c.start, c.prevStart = now, c.start
+	}
+
+	cc, ok := c.clients[client]
+	if !ok {
+		cc = new(clientCounts)
+		c.clients[client] = cc
+	}
+	return cc
+}
+
+// classify applies the classifier c to the query r. It reports whether the
+// query was dropped or answered, and so is not to be served.
+func (s *Server) classify(ctx context.Context, c QueryClassifier, w *serverWriter, r *Query) bool {
+	if len(r.Questions) == 0 {
+		return false
+	}
+
+	var (
+		client string
+		rates  ClientRates
+	)
+	if ip := addrIP(r.RemoteAddr); ip != nil {
+		client = ip.String()
+		rates = s.rates.query(client, time.Now())
+	}
+
+	switch c.Classify(ctx, r.RemoteAddr, r.Questions[0], rates) {
+	case QueryDrop:
+		atomic.AddUint64(&s.queryc.dropped, 1)
+		return true
+	case QueryTruncate:
+		if r.RemoteAddr == nil || !strings.HasPrefix(r.RemoteAddr.Network(), "udp") {
+			break
+		}
+		atomic.AddUint64(&s.queryc.truncated, 1)
+
+		w.truncated(true)
+		if err := w.Reply(ctx); err != nil {
+			s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+		}
+		return true
+	case QueryTarpit:
+		atomic.AddUint64(&s.queryc.tarpitted, 1)
+		w.tarpitted = true
+	}
+
+	w.client = client
+	return false
+}
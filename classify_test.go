@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerClassifier(t *testing.T) {
+	t.Parallel()
+
+	var served int
+	srv := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			served++
+			if r.Questions[0].Name == "www.dev." {
+				w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+				return
+			}
+			w.Status(NXDomain)
+		}),
+		Classifier: &NXDomainDetector{MinResponses: 4},
+	}
+
+	var (
+		attacker = &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53000}
+		resolver = &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 53000}
+		retry    = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53000}
+	)
+
+	tests := []struct {
+		name string
+
+		addr      net.Addr
+		qname     string
+		rcode     RCode
+		truncated bool
+	}{
+		{name: "first", addr: attacker, qname: "x1.dev.", rcode: NXDomain},
+		{name: "second", addr: attacker, qname: "x2.dev.", rcode: NXDomain},
+		{name: "third", addr: attacker, qname: "x3.dev.", rcode: NXDomain},
+		{name: "fourth", addr: attacker, qname: "www.dev."},
+		{name: "classified", addr: attacker, qname: "x4.dev.", truncated: true},
+		{name: "classified existing", addr: attacker, qname: "www.dev.", truncated: true},
+		{name: "tcp", addr: retry, qname: "x5.dev.", rcode: NXDomain},
+		{name: "resolver", addr: resolver, qname: "x6.dev.", rcode: NXDomain},
+	}
+
+	for _, test := range tests {
+		msg, err := srv.ServeMessage(context.Background(), &Query{
+			RemoteAddr: test.addr,
+			Message:    &Message{Questions: []Question{{Name: test.qname, Type: TypeA, Class: ClassIN}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %v, got %v", test.name, want, got)
+		}
+		if want, got := test.truncated, msg.Truncated; want != got {
+			t.Errorf("%s: want truncated %t, got %t", test.name, want, got)
+		}
+	}
+
+	if want, got := 6, served; want != got {
+		t.Errorf("want %d queries served, got %d", want, got)
+	}
+	if want, got := uint64(2), srv.QueryStats().Truncated; want != got {
+		t.Errorf("want %d queries truncated, got %d", want, got)
+	}
+}
+
+func TestServerClassifierActions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		action QueryAction
+
+		replied bool
+		delayed bool
+	}{
+		{action: QueryServe, replied: true},
+		{action: QueryDrop},
+		{action: QueryTarpit, replied: true, delayed: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			rates := make(chan ClientRates, 1)
+			srv := &Server{
+				Addr: mustUnusedAddr(),
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+				}),
+				Classifier: QueryClassifierFunc(func(ctx context.Context, client net.Addr, q Question, r ClientRates) QueryAction {
+					rates <- r
+					return test.action
+				}),
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			start := time.Now()
+			_, err = new(Client).Do(ctx, &Query{
+				RemoteAddr: addr,
+				Message:    &Message{Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}}},
+			})
+			if want, got := test.replied, err == nil; want != got {
+				t.Fatalf("want replied %t, got error %v", want, err)
+			}
+			if want, got := test.delayed, time.Since(start) >= defaultTarpitDelay; test.replied && want != got {
+				t.Errorf("want delayed %t, got response after %v", want, time.Since(start))
+			}
+
+			if r := <-rates; r.Queries != 0 {
+				t.Errorf("want no earlier queries, got %+v", r)
+			}
+		})
+	}
+}
+
+func TestClientRates(t *testing.T) {
+	t.Parallel()
+
+	var (
+		c   clientRates
+		now = time.Now()
+	)
+
+	c.query("a", now)
+	c.response("a", NXDomain, now)
+	c.query("b", now)
+	c.response("b", NoError, now)
+
+	if want, got := (ClientRates{Queries: 1, Responses: 1, NXDomain: 1}), c.query("a", now); want != got {
+		t.Errorf("want rates %+v, got %+v", want, got)
+	}
+
+	now = now.Add(classifierWindow)
+	c.query("a", now)
+	if want, got := (ClientRates{Queries: 3, Responses: 1, NXDomain: 1, Window: classifierWindow}), c.query("a", now); want != got {
+		t.Errorf("want rates %+v, got %+v", want, got)
+	}
+
+	now = now.Add(classifierWindow)
+	if want, got := (ClientRates{Queries: 2, Window: classifierWindow}), c.query("a", now); want != got {
+		t.Errorf("want rates of the previous window %+v, got %+v", want, got)
+	}
+	if _, ok := c.clients["b"]; ok {
+		t.Error("want client without queries forgotten")
+	}
+
+	now = now.Add(2 * classifierWindow)
+	if want, got := (ClientRates{}), c.query("a", now); want != got {
+		t.Errorf("want no rates after two windows, got %+v", got)
+	}
+}
@@ -2,8 +2,13 @@ package dns
 
 import (
 	"context"
+	"crypto/rand"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Client is a DNS client.
@@ -16,11 +21,70 @@ type Client struct {
 	// server.
 	Resolver Handler
 
+	// Workers, if not nil, runs the queries written to the connections
+	// returned by Dial on a bounded pool of goroutines. A worker is held
+	// until the response is read from the connection, one response being
+	// buffered. When the pool rejects a query the write fails with
+	// ErrOverloaded, whatever its policy.
+	Workers *WorkerPool
+
+	// EDNSPayloadSize, if not zero, is the UDP payload size advertised in an
+	// EDNS OPT record added to the UDP queries without one. If a server
+	// answers FORMERR or BADVERS, or does not answer within EDNSTimeout, the
+	// query is retried with a payload size of 1232, then 512, then without
+	// EDNS. The size that worked is recorded per server and used for the
+	// following queries to it.
+	EDNSPayloadSize int
+
+	// EDNSTimeout, if not zero, is the time to wait for the response to a
+	// query with EDNS before falling back to a smaller payload size.
+	EDNSTimeout time.Duration
+
+	// ReservedBits is how responses with the reserved Z bit of the header,
+	// or reserved EDNS flags, set are handled. By default they are ignored.
+	ReservedBits ReservedBitsPolicy
+
+	// Logger, if not nil, logs the failed queries, the EDNS fallbacks and
+	// the zone transfers of the client.
+	Logger Logger
+
+	// MaxOutstanding bounds the queries written to a UDP connection returned
+	// by Dial and not answered. Past it, once the expired queries are
+	// dropped, a write fails with ErrOverloaded. If zero, 256 queries are
+	// outstanding at most.
+	MaxOutstanding int
+
+	// OutstandingTimeout is the time a query written to a UDP connection
+	// returned by Dial waits for its response, a retransmission of the
+	// query restarting it. If zero, a query waits 5 seconds.
+	OutstandingTimeout time.Duration
+
+	interceptors []func(RoundTripper) RoundTripper
+
+	ednsmu       sync.Mutex
+	ednsProfiles map[string]int
+
+	sessionc sessionCounters
+
 	id uint32
 }
 
+// Use adds interceptors around the queries sent by the client, through Do and
+// through the connections returned by Dial. The first interceptor added is the
+// outermost. The RoundTripper passed to an interceptor sends the query on the
+// connection already dialed for its RemoteAddr, or the query may be answered
+// without calling it. Use must not be called concurrently with queries.
+func (c *Client) Use(interceptors ...func(next RoundTripper) RoundTripper) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
 // Dial dials a DNS server and returns a net Conn that reads and writes DNS
 // messages.
+//
+// The queries written to a UDP connection are sent concurrently, each on a
+// connection dialed by the Transport, and a response is read once for the ID
+// of a query written: the duplicate responses of its retransmissions, and the
+// late responses past OutstandingTimeout, are dropped.
 func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	switch network {
 	case "tcp", "tcp4", "tcp6":
@@ -39,7 +103,7 @@ func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, e
 				Conn:    conn,
 				addr:    addr,
 				client:  c,
-				msgerrc: make(chan msgerr),
+				msgerrc: make(chan msgerr, 1),
 			},
 		}, nil
 	case "udp", "udp4", "udp6":
@@ -58,7 +122,7 @@ func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, e
 				Conn:    conn,
 				addr:    addr,
 				client:  c,
-				msgerrc: make(chan msgerr),
+				msgerrc: make(chan msgerr, 1),
 			},
 		}, nil
 	default:
@@ -67,19 +131,192 @@ func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, e
 }
 
 // Do sends a DNS query to a server and returns the response message.
+//
+// The deadline of ctx applies to the reads and writes of the connection, and
+// they are aborted once ctx is done, failing with ctx.Err(). The connection is
+// closed before Do returns, a pipelined connection only dropping the query.
 func (c *Client) Do(ctx context.Context, query *Query) (*Message, error) {
+	guard := forwardGuardFrom(ctx)
+	if guard != nil {
+		if err := guard.upstream(); err != nil {
+			return nil, err
+		}
+	}
+
+	ContextClientTrace(ctx).dnsStart(query)
+
 	conn, err := c.dial(ctx, query.RemoteAddr)
+	if err != nil {
+		c.log(ctx, slog.LevelWarn, "dns dial", "addr", query.RemoteAddr, "err", err)
+		return nil, err
+	}
+
+	// the connection may be nil for a Resolver answering every query
+	if conn != nil {
+		cconn := &cancelConn{Conn: conn}
+		defer cconn.Close()
+
+		stop := context.AfterFunc(ctx, cconn.cancel)
+		defer stop()
+
+		if t, ok := ctx.Deadline(); ok {
+			if err := cconn.SetDeadline(t); err != nil {
+				return nil, err
+			}
+		}
+		conn = cconn
+	}
+
+	msg, err := c.do(ctx, conn, query)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		err = c.reservedBits(msg)
+	}
+	if err == nil && guard != nil {
+		err = guard.response(query.Questions, msg)
+	}
+	if err != nil {
+		c.log(ctx, slog.LevelWarn, "dns query", "addr", query.RemoteAddr, "err", err)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DoStream sends a DNS query to a server over a stream connection and returns
+// the response messages as they are read. The channel is closed once the
+// response is complete: after the closing SOA record of an AXFR or IXFR
+// transfer, after an error response, or after the first message of any other
+// query. It is also closed when ctx is done or reading fails, in which case a
+// transfer is incomplete. The query is sent on a dedicated connection, without
+// the Resolver or the interceptors added by Use.
+func (c *Client) DoStream(ctx context.Context, query *Query) (<-chan *Message, error) {
+	if !strings.HasPrefix(query.RemoteAddr.Network(), "tcp") {
+		return nil, ErrUnsupportedNetwork
+	}
+
+	conn, err := c.dialStream(ctx, query.RemoteAddr)
 	if err != nil {
 		return nil, err
 	}
 
 	if t, ok := ctx.Deadline(); ok {
 		if err := conn.SetDeadline(t); err != nil {
+			conn.Close()
 			return nil, err
 		}
 	}
 
-	return c.do(ctx, conn, query)
+	id := query.ID
+
+	req := *query.Message
+
+	if err := c.send(ctx, conn, &req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var xfr transfer
+	if len(req.Questions) > 0 {
+		xfr.qtype = req.Questions[0].Type
+	}
+
+	msgc := make(chan *Message)
+	go func() {
+		defer close(msgc)
+		defer conn.Close()
+
+		stopc := make(chan struct{})
+		defer close(stopc)
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(aLongTimeAgo)
+			case <-stopc:
+			}
+		}()
+
+		for {
+			msg := new(Message)
+			if err := conn.Recv(msg); err != nil {
+				return
+			}
+			if msg.ID != req.ID {
+				continue
+			}
+			msg.ID = id
+
+			select {
+			case msgc <- msg:
+			case <-ctx.Done():
+				return
+			}
+
+			if xfr.done(msg) {
+				return
+			}
+		}
+	}()
+
+	return msgc, nil
+}
+
+// dialStream dials addr without pipelining, so that every message read from
+// the connection is a response to the query sent on it.
+func (c *Client) dialStream(ctx context.Context, addr net.Addr) (Conn, error) {
+	switch tport := c.Transport.(type) {
+	case nil:
+		return new(Transport).dialAddr(ctx, addr, false)
+	case *Transport:
+		return tport.dialAddr(ctx, addr, false)
+	}
+	return c.Transport.DialAddr(ctx, addr)
+}
+
+// transfer tracks the answers of a zone transfer to find its end, see RFC
+// 5936, section 2.2, and RFC 1995, section 4.
+type transfer struct {
+	qtype Type
+
+	n           int // answers read
+	serial      int // serial of the leading SOA
+	incremental bool
+	soas        int // SOA records of serial in an incremental transfer
+}
+
+func (x *transfer) done(msg *Message) bool {
+	if msg.RCode != NoError || (x.qtype != TypeAXFR && x.qtype != TypeIXFR) {
+		return true
+	}
+
+	for _, res := range msg.Answers {
+		soa, ok := res.Record.(*SOA)
+		x.n++
+
+		switch {
+		case x.n == 1:
+			if !ok {
+				return true
+			}
+			x.serial, x.soas = soa.Serial, 1
+		case !ok:
+		case x.n == 2 && x.qtype == TypeIXFR && soa.Serial != x.serial:
+			x.incremental = true
+		case x.incremental:
+			if soa.Serial == x.serial {
+				if x.soas++; x.soas == 3 {
+					return true
+				}
+			}
+		default:
+			return true
+		}
+	}
+
+	// an empty response, or a single SOA when the IXFR client is up to date
+	return x.n == 0 || x.n == 1 && x.qtype == TypeIXFR
 }
 
 func (c *Client) dial(ctx context.Context, addr net.Addr) (Conn, error) {
@@ -92,13 +329,27 @@ func (c *Client) dial(ctx context.Context, addr net.Addr) (Conn, error) {
 }
 
 func (c *Client) do(ctx context.Context, conn Conn, query *Query) (*Message, error) {
+	if len(c.interceptors) == 0 {
+		return c.exchange(ctx, conn, query)
+	}
+
+	var rt RoundTripper = RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+		return c.exchange(ctx, conn, query)
+	})
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		rt = c.interceptors[i](rt)
+	}
+	return rt.Do(ctx, query)
+}
+
+func (c *Client) exchange(ctx context.Context, conn Conn, query *Query) (*Message, error) {
 	if c.Resolver == nil {
-		return c.roundtrip(conn, query)
+		return c.roundtrip(ctx, conn, query)
 	}
 
 	w := &clientWriter{
 		messageWriter: &messageWriter{
-			msg: response(query.Message),
+			msg: reply(query.Message),
 		},
 
 		req:  request(query.Message),
@@ -115,17 +366,22 @@ func (c *Client) do(ctx context.Context, conn Conn, query *Query) (*Message, err
 	return response(w.msg), nil
 }
 
-func (c *Client) roundtrip(conn Conn, query *Query) (*Message, error) {
+func (c *Client) roundtrip(ctx context.Context, conn Conn, query *Query) (*Message, error) {
+	if c.negotiateEDNS(query) {
+		return c.roundtripEDNS(ctx, conn, query)
+	}
+
 	id := query.ID
 
 	msg := *query.Message
-	msg.ID = c.nextID()
 
-	if err := conn.Send(&msg); err != nil {
+	if err := c.send(ctx, conn, &msg); err != nil {
 		return nil, err
 	}
 
-	if err := conn.Recv(&msg); err != nil {
+	err := recvID(conn, &msg, msg.ID)
+	ContextClientTrace(ctx).gotResponse(&msg, err)
+	if err != nil {
 		return nil, err
 	}
 	msg.ID = id
@@ -133,10 +389,53 @@ func (c *Client) roundtrip(conn Conn, query *Query) (*Message, error) {
 	return &msg, nil
 }
 
-const idMask = (1 << 16) - 1
+const (
+	idMask = (1 << 16) - 1
 
+	// maxIDAttempts is the number of IDs drawn for a message before giving up
+	// on a connection with conflicting queries in flight.
+	maxIDAttempts = 8
+)
+
+// send writes msg to conn under a new random ID, drawing another one if the ID
+// is in flight on conn. The ID of the caller is restored on the response.
+func (c *Client) send(ctx context.Context, conn Conn, msg *Message) error {
+	for i := 1; ; i++ {
+		msg.ID = c.nextID()
+
+		err := conn.Send(msg)
+		if err != ErrConflictingID || i == maxIDAttempts {
+			ContextClientTrace(ctx).wroteQuery(err)
+			return err
+		}
+	}
+}
+
+// recvID reads messages from conn until the response with id, dropping those
+// with another ID, such as spoofed or late responses. A pipelined connection
+// already delivers the response by its ID.
+func recvID(conn Conn, msg *Message, id int) error {
+	if _, ok := conn.(*pipelineConn); ok {
+		return conn.Recv(msg)
+	}
+
+	for {
+		if err := conn.Recv(msg); err != nil {
+			return err
+		}
+		if msg.ID == id {
+			return nil
+		}
+	}
+}
+
+// nextID returns a random message ID, see RFC 5452, section 9.2.
 func (c *Client) nextID() int {
-	return int(atomic.AddUint32(&c.id, 1) & idMask)
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return int(atomic.AddUint32(&c.id, 1) & idMask)
+	}
+	return int(nbo.Uint16(b[:]))
 }
 
 type clientWriter struct {
@@ -148,10 +447,10 @@ type clientWriter struct {
 	addr net.Addr
 	conn Conn
 
-	roundtrip func(Conn, *Query) (*Message, error)
+	roundtrip func(context.Context, Conn, *Query) (*Message, error)
 }
 
-func (w *clientWriter) Recur(context.Context) (*Message, error) {
+func (w *clientWriter) Recur(ctx context.Context) (*Message, error) {
 	qs := make([]Question, 0, len(w.req.Questions))
 	for _, q := range w.req.Questions {
 		if !questionMatched(q, w.msg) {
@@ -165,7 +464,7 @@ func (w *clientWriter) Recur(context.Context) (*Message, error) {
 		RemoteAddr: w.addr,
 	}
 
-	msg, err := w.roundtrip(w.conn, req)
+	msg, err := w.roundtrip(ctx, w.conn, req)
 	if err != nil {
 		w.err = err
 	}
@@ -173,6 +472,23 @@ func (w *clientWriter) Recur(context.Context) (*Message, error) {
 	return msg, err
 }
 
+func (w *clientWriter) Forward(ctx context.Context, rt RoundTripper) (*Message, error) {
+	qs := make([]Question, 0, len(w.req.Questions))
+	for _, q := range w.req.Questions {
+		if !questionMatched(q, w.msg) {
+			qs = append(qs, q)
+		}
+	}
+
+	req := &Query{
+		Message:    request(w.req),
+		RemoteAddr: w.addr,
+	}
+	req.Questions = qs
+
+	return rt.Do(ctx, req)
+}
+
 func (w *clientWriter) Reply(context.Context) error {
 	return ErrUnsupportedOp
 }
@@ -206,14 +522,16 @@ func writeMessage(w MessageWriter, msg *Message) {
 	w.Status(msg.RCode)
 	w.Authoritative(msg.Authoritative)
 	w.Recursion(msg.RecursionAvailable)
+	w.AuthenticData(msg.AuthenticData)
+	w.CheckingDisabled(msg.CheckingDisabled)
 
 	for _, res := range msg.Answers {
-		w.Answer(res.Name, res.TTL, res.Record)
+		writeAnswer(w, res)
 	}
 	for _, res := range msg.Authorities {
-		w.Authority(res.Name, res.TTL, res.Record)
+		writeAuthority(w, res)
 	}
 	for _, res := range msg.Additionals {
-		w.Additional(res.Name, res.TTL, res.Record)
+		writeAdditional(w, res)
 	}
 }
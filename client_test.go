@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -32,7 +33,7 @@ func TestLookupHost(t *testing.T) {
 
 	srv := mustServer(&Zone{
 		Origin: "dev.",
-		RRs: RRSet{
+		RRs: NewRRSet(map[string]map[Type][]Record{
 			"localhost": {
 				TypeA: {
 					&A{A: net.IPv4(127, 0, 0, 1)},
@@ -41,7 +42,7 @@ func TestLookupHost(t *testing.T) {
 					&AAAA{AAAA: net.ParseIP("::1")},
 				},
 			},
-		},
+		}),
 	})
 
 	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
@@ -140,3 +141,474 @@ func TestClientResolver(t *testing.T) {
 		t.Errorf("want A record %q, got %q", want, got)
 	}
 }
+
+func TestClientUse(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	trace := func(name string) func(RoundTripper) RoundTripper {
+		return func(next RoundTripper) RoundTripper {
+			return RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+				calls = append(calls, name)
+				return next.Do(ctx, query)
+			})
+		}
+	}
+
+	cached := &Message{Response: true, RCode: NXDomain}
+	cache := func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+			if query.Questions[0].Type == TypeAAAA {
+				return cached, nil
+			}
+			return next.Do(ctx, query)
+		})
+	}
+
+	client := new(Client)
+	client.Use(trace("outer"), trace("inner"))
+	client.Use(cache)
+
+	msg, err := client.Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message:    &Message{Questions: []Question{questions["A"]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+	if want, got := []string{"outer", "inner"}, calls; !reflect.DeepEqual(want, got) {
+		t.Errorf("want interceptor calls %v, got %v", want, got)
+	}
+
+	msg, err = client.Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message:    &Message{Questions: []Question{questions["AAAA"]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := cached, msg; want != got {
+		t.Errorf("want cached response %+v, got %+v", want, got)
+	}
+}
+
+func TestClientDoStream(t *testing.T) {
+	t.Parallel()
+
+	soa := func(serial int) Resource {
+		return Resource{
+			Name:  "app.dev.",
+			Class: ClassIN,
+			TTL:   time.Minute,
+			Record: &SOA{
+				NS:     "ns.app.dev.",
+				MBox:   "admin.app.dev.",
+				Serial: serial,
+			},
+		}
+	}
+	a := func(ip string) Resource {
+		return Resource{
+			Name:   "app.dev.",
+			Class:  ClassIN,
+			TTL:    time.Minute,
+			Record: &A{A: net.ParseIP(ip).To4()},
+		}
+	}
+
+	tests := []struct {
+		name string
+
+		qtype    Type
+		messages [][]Resource
+
+		count int
+	}{
+		{
+			name: "axfr",
+
+			qtype: TypeAXFR,
+			messages: [][]Resource{
+				{soa(2), a("127.0.0.1")},
+				{a("127.0.0.2")},
+				{a("127.0.0.3"), soa(2)},
+				{a("127.0.0.4")},
+			},
+
+			count: 3,
+		},
+		{
+			name: "ixfr",
+
+			qtype: TypeIXFR,
+			messages: [][]Resource{
+				{soa(3), soa(1), a("127.0.0.1"), soa(2)},
+				{a("127.0.0.2"), soa(2), a("127.0.0.3")},
+				{soa(3), a("127.0.0.4")},
+				{soa(3)},
+				{a("127.0.0.5")},
+			},
+
+			count: 4,
+		},
+		{
+			name: "ixfr-up-to-date",
+
+			qtype: TypeIXFR,
+			messages: [][]Resource{
+				{soa(3)},
+				{a("127.0.0.1")},
+			},
+
+			count: 1,
+		},
+		{
+			name: "a",
+
+			qtype: TypeA,
+			messages: [][]Resource{
+				{a("127.0.0.1")},
+				{a("127.0.0.2")},
+			},
+
+			count: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				sconn := &StreamConn{Conn: conn}
+
+				var req Message
+				if err := sconn.Recv(&req); err != nil {
+					t.Error(err)
+					return
+				}
+
+				for _, answers := range test.messages {
+					res := response(&req)
+					res.Answers = answers
+					if err := sconn.Send(res); err != nil {
+						return
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			msgc, err := new(Client).DoStream(ctx, &Query{
+				RemoteAddr: ln.Addr(),
+				Message: &Message{
+					ID: 42,
+					Questions: []Question{
+						{Name: "app.dev.", Type: test.qtype, Class: ClassIN},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var count int
+			for msg := range msgc {
+				if want, got := 42, msg.ID; want != got {
+					t.Errorf("want message ID %d, got %d", want, got)
+				}
+				count++
+			}
+
+			if want, got := test.count, count; want != got {
+				t.Errorf("want %d messages, got %d", want, got)
+			}
+			if err := ctx.Err(); err != nil {
+				t.Errorf("want stream closed before deadline, got %v", err)
+			}
+		})
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := new(Client).DoStream(context.Background(), &Query{
+			RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53},
+			Message:    &Message{},
+		})
+		if want, got := ErrUnsupportedNetwork, err; want != got {
+			t.Errorf("want error %v, got %v", want, got)
+		}
+	})
+}
+
+func TestClientQueryID(t *testing.T) {
+	t.Parallel()
+
+	conn := &idConn{conflicts: 2}
+	client := &Client{
+		Transport: idDialer{conn},
+	}
+
+	msg, err := client.Do(context.Background(), &Query{
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53},
+		Message: &Message{
+			ID:        42,
+			Questions: []Question{questions["A"]},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 42, msg.ID; want != got {
+		t.Errorf("want restored message ID %d, got %d", want, got)
+	}
+	if want, got := 3, len(conn.ids); want != got {
+		t.Fatalf("want %d messages sent, got %d", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want answers of the response to the last ID sent, not of the spoofed one, got %d answers", got)
+	}
+
+	conn = &idConn{conflicts: maxIDAttempts}
+	client.Transport = idDialer{conn}
+
+	_, err = client.Do(context.Background(), &Query{
+		RemoteAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53},
+		Message:    &Message{},
+	})
+	if want, got := ErrConflictingID, err; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	t.Parallel()
+
+	// a UDP nameserver that never answers
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+
+	// a TCP nameserver that reads the queries but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				b := make([]byte, 512)
+				for {
+					if _, err := conn.Read(b); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	tests := []struct {
+		name string
+
+		addr net.Addr
+	}{
+		{name: "udp", addr: dead.LocalAddr()},
+		{name: "pipelined tcp", addr: ln.Addr()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				conns []*closeConn
+			)
+
+			tport := &Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+
+					cc := &closeConn{Conn: conn}
+					conns = append(conns, cc)
+					if _, ok := conn.(net.PacketConn); ok {
+						return packetConn{cc}, nil
+					}
+					return cc, nil
+				},
+			}
+			client := &Client{Transport: tport}
+
+			for i := 0; i < 3; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+
+				start := time.Now()
+				_, err := client.Do(ctx, &Query{
+					RemoteAddr: test.addr,
+					Message: &Message{
+						Questions: []Question{questions["A"]},
+					},
+				})
+				if want, got := context.Canceled, err; want != got {
+					t.Errorf("want error %v, got %v", want, got)
+				}
+				if d := time.Since(start); d > time.Second {
+					t.Errorf("want query aborted at once, took %s", d)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if pline := tport.getPipeline(test.addr); pline != nil {
+				pline.mu.Lock()
+				if want, got := 0, len(pline.inflight); want != got {
+					t.Errorf("want %d inflight queries, got %d", want, got)
+				}
+				pline.mu.Unlock()
+
+				if want, got := 1, len(conns); want != got {
+					t.Errorf("want %d pipelined connection, got %d", want, got)
+				}
+				return
+			}
+
+			for i, cc := range conns {
+				if !cc.closed() {
+					t.Errorf("want connection %d closed", i)
+				}
+			}
+		})
+	}
+}
+
+// closeConn records whether it was closed.
+type closeConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	isShut bool
+}
+
+func (c *closeConn) Close() error {
+	c.mu.Lock()
+	c.isShut = true
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
+
+func (c *closeConn) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.isShut
+}
+
+// packetConn is a net.Conn that is a net.PacketConn, for the Transport to
+// frame its messages as datagrams.
+type packetConn struct {
+	*closeConn
+}
+
+func (c packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c packetConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+type idDialer struct {
+	conn *idConn
+}
+
+func (d idDialer) DialAddr(context.Context, net.Addr) (Conn, error) {
+	return d.conn, nil
+}
+
+// idConn rejects the first conflicts IDs sent, then answers with a spoofed
+// response before the response to the last ID sent.
+type idConn struct {
+	Conn
+
+	conflicts int
+	ids       []int
+	spoofed   bool
+}
+
+func (c *idConn) Close() error { return nil }
+
+func (c *idConn) Send(msg *Message) error {
+	c.ids = append(c.ids, msg.ID)
+	if c.conflicts > 0 {
+		c.conflicts--
+		return ErrConflictingID
+	}
+	return nil
+}
+
+func (c *idConn) Recv(msg *Message) error {
+	id := c.ids[len(c.ids)-1]
+
+	if !c.spoofed {
+		c.spoofed = true
+		*msg = Message{ID: (id + 1) & idMask, Response: true}
+		return nil
+	}
+
+	res := response(&Message{ID: id, Questions: []Question{questions["A"]}})
+	res.Answers = []Resource{
+		{
+			Name:   questions["A"].Name,
+			Class:  ClassIN,
+			TTL:    time.Minute,
+			Record: answers[questions["A"]],
+		},
+	}
+	*msg = *res
+	return nil
+}
@@ -0,0 +1,253 @@
+package dns
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helmutkemper/dns/edns"
+)
+
+// ClientCache is a response cache for the Resolver of a Client. Unlike Cache,
+// responses are cached as a whole for each question, name, type and class,
+// along with the DNSSEC OK bit and the EDNS Client Subnet of the query. An
+// answer with an ECS scope prefix length of zero is shared by all subnets.
+//
+// A response is cached for the smallest TTL of its records, or for a negative
+// response the TTL from its SOA record, see RFC 2308. Responses with a TTL of
+// zero are not cached, whatever MinTTL. Only NOERROR and NXDOMAIN responses
+// to queries with a single question are cached.
+type ClientCache struct {
+	// MinTTL and MaxTTL, if not zero, clamp the time responses are cached.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// MaxEntries, if not zero, is the maximum number of cached responses.
+	// When it is reached, expired responses are evicted first, then any.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[clientCacheKey]*clientCacheEntry
+}
+
+type clientCacheKey struct {
+	Name  string
+	Type  Type
+	Class Class
+
+	DO  bool
+	ECS string
+}
+
+type clientCacheEntry struct {
+	rcode RCode
+
+	answers     []Resource
+	authorities []Resource
+	additionals []Resource
+
+	stored  time.Time
+	expires time.Time
+}
+
+// ServeDNS answers the query from the cache, or forwards it upstream and
+// caches the response.
+func (c *ClientCache) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	var (
+		key clientCacheKey
+		now = time.Now()
+	)
+
+	cacheable := len(r.Questions) == 1
+	if cacheable {
+		key = clientCacheKeyOf(r.Message)
+		if c.lookup(w, key, now) {
+			return
+		}
+	}
+
+	msg, err := w.Recur(ctx)
+	if err != nil || msg == nil {
+		w.Status(ServFail)
+		return
+	}
+	if cacheable {
+		c.insert(key, msg, now)
+	}
+	writeMessage(w, msg)
+}
+
+func (c *ClientCache) lookup(w MessageWriter, key clientCacheKey, now time.Time) bool {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok && key.ECS != "" {
+		global := key
+		global.ECS = ""
+		e, ok = c.entries[global]
+	}
+	c.mu.Unlock()
+
+	if !ok || !now.Before(e.expires) {
+		return false
+	}
+
+	var (
+		elapsed   = now.Sub(e.stored)
+		remaining = e.expires.Sub(now).Truncate(time.Second)
+	)
+	ttl := func(ttl time.Duration) time.Duration {
+		if ttl -= elapsed; ttl < 0 || ttl > remaining {
+			return remaining
+		}
+		return ttl.Truncate(time.Second)
+	}
+
+	w.Status(e.rcode)
+	for _, res := range e.answers {
+		res.TTL = ttl(res.TTL)
+		writeAnswer(w, res)
+	}
+	for _, res := range e.authorities {
+		res.TTL = ttl(res.TTL)
+		writeAuthority(w, res)
+	}
+	for _, res := range e.additionals {
+		res.TTL = ttl(res.TTL)
+		writeAdditional(w, res)
+	}
+	return true
+}
+
+func (c *ClientCache) insert(key clientCacheKey, msg *Message, now time.Time) {
+	if (msg.RCode != NoError && msg.RCode != NXDomain) || msg.Truncated {
+		return
+	}
+
+	ttl, ok := responseTTL(msg)
+	if !ok || ttl <= 0 {
+		return
+	}
+	if c.MinTTL > 0 && ttl < c.MinTTL {
+		ttl = c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+
+	if scope, ok := ecsScope(msg); ok && scope == 0 {
+		key.ECS = ""
+	}
+
+	e := &clientCacheEntry{
+		rcode:       msg.RCode,
+		answers:     append([]Resource(nil), msg.Answers...),
+		authorities: append([]Resource(nil), msg.Authorities...),
+		stored:      now,
+		expires:     now.Add(ttl),
+	}
+	for _, res := range msg.Additionals {
+		if res.Record.Type() != TypeOPT {
+			e.additionals = append(e.additionals, res)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[clientCacheKey]*clientCacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && c.MaxEntries > 0 && len(c.entries) >= c.MaxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = e
+}
+
+// c.mu held
+func (c *ClientCache) evict(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.MaxEntries {
+			return
+		}
+		delete(c.entries, k)
+	}
+}
+
+// responseTTL returns the smallest TTL of the records of msg, bounded by the
+// minimum TTL of the SOA record of a negative response.
+func responseTTL(msg *Message) (time.Duration, bool) {
+	var (
+		ttl time.Duration
+		ok  bool
+	)
+	min := func(d time.Duration) {
+		if !ok || d < ttl {
+			ttl, ok = d, true
+		}
+	}
+
+	for _, res := range msg.Answers {
+		min(res.TTL)
+	}
+	for _, res := range msg.Authorities {
+		min(res.TTL)
+		if soa, isSOA := res.Record.(*SOA); isSOA && len(msg.Answers) == 0 {
+			min(soa.MinTTL)
+		}
+	}
+	if len(msg.Answers) == 0 && !ok {
+		// a negative response without a SOA record may not be cached
+		return 0, false
+	}
+	return ttl, ok
+}
+
+func clientCacheKeyOf(msg *Message) clientCacheKey {
+	q := msg.Questions[0]
+
+	key := clientCacheKey{
+		Name:  strings.ToLower(q.Name),
+		Type:  q.Type,
+		Class: q.Class,
+	}
+
+	for _, res := range msg.Additionals {
+		opt, ok := res.Record.(*OPT)
+		if !ok {
+			continue
+		}
+
+		key.DO = uint32(res.TTL/time.Second)&0x8000 != 0
+		for _, o := range opt.Options {
+			if o.Code == edns.OptionCodeEDNSClientSubnet && len(o.Data) >= 4 {
+				// the scope prefix length is zero in queries
+				b := append([]byte(nil), o.Data...)
+				b[3] = 0
+				key.ECS = hex.EncodeToString(b)
+			}
+		}
+	}
+	return key
+}
+
+// ecsScope returns the scope prefix length of the EDNS Client Subnet option of
+// msg.
+func ecsScope(msg *Message) (int, bool) {
+	for _, res := range msg.Additionals {
+		if opt, ok := res.Record.(*OPT); ok {
+			for _, o := range opt.Options {
+				if o.Code == edns.OptionCodeEDNSClientSubnet && len(o.Data) >= 4 {
+					return int(o.Data[3]), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/edns"
+)
+
+func TestClientCache(t *testing.T) {
+	t.Parallel()
+
+	var queries int32
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		atomic.AddInt32(&queries, 1)
+
+		switch r.Questions[0].Name {
+		case "app.cache.dev.":
+			w.Answer("app.cache.dev.", time.Minute, &A{A: net.IPv4(10, 0, 0, 1).To4()})
+		case "zero.cache.dev.":
+			w.Answer("zero.cache.dev.", 0, &A{A: net.IPv4(10, 0, 0, 2).To4()})
+		default:
+			w.Status(NXDomain)
+			w.Authority("cache.dev.", time.Hour, &SOA{
+				NS:     "ns.cache.dev.",
+				MBox:   "hostmaster.cache.dev.",
+				MinTTL: time.Minute,
+			})
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Resolver: new(ClientCache),
+	}
+
+	query := func(name string, do bool) *Message {
+		msg := &Message{
+			Questions: []Question{
+				{Name: name, Type: TypeA, Class: ClassIN},
+			},
+		}
+		if do {
+			msg.Additionals = []Resource{
+				{Name: ".", Class: 1232, TTL: 0x8000 * time.Second, Record: &OPT{}},
+			}
+		}
+
+		res, err := client.Do(context.Background(), &Query{RemoteAddr: addr, Message: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name string
+		do   bool
+
+		rcode   RCode
+		queries int32
+	}{
+		{name: "app.cache.dev.", queries: 1},
+		{name: "APP.cache.dev.", queries: 1},
+		{name: "app.cache.dev.", do: true, queries: 2},
+		{name: "app.cache.dev.", do: true, queries: 2},
+		{name: "zero.cache.dev.", queries: 3},
+		{name: "zero.cache.dev.", queries: 4},
+		{name: "nx.cache.dev.", rcode: NXDomain, queries: 5},
+		{name: "nx.cache.dev.", rcode: NXDomain, queries: 5},
+	}
+
+	for _, test := range tests {
+		msg := query(test.name, test.do)
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %v, got %v", test.name, want, got)
+		}
+		if want, got := test.queries, atomic.LoadInt32(&queries); want != got {
+			t.Errorf("%s: want %d upstream queries, got %d", test.name, want, got)
+		}
+	}
+}
+
+func TestClientCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	c := &ClientCache{
+		MinTTL: 10 * time.Second,
+		MaxTTL: time.Minute,
+	}
+
+	now := time.Now()
+
+	short := clientCacheKey{Name: "short.dev.", Type: TypeA, Class: ClassIN}
+	c.insert(short, &Message{
+		Answers: []Resource{
+			{Name: "short.dev.", Class: ClassIN, TTL: time.Second, Record: &A{A: net.IPv4(10, 0, 0, 1).To4()}},
+		},
+	}, now)
+
+	long := clientCacheKey{Name: "long.dev.", Type: TypeA, Class: ClassIN}
+	c.insert(long, &Message{
+		Answers: []Resource{
+			{Name: "long.dev.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(10, 0, 0, 2).To4()}},
+		},
+	}, now)
+
+	tests := []struct {
+		key     clientCacheKey
+		elapsed time.Duration
+
+		hit bool
+		ttl time.Duration
+	}{
+		{key: short, elapsed: 5 * time.Second, hit: true, ttl: 5 * time.Second},
+		{key: short, elapsed: 10 * time.Second},
+		{key: long, elapsed: 30 * time.Second, hit: true, ttl: 30 * time.Second},
+		{key: long, elapsed: time.Minute},
+	}
+
+	for _, test := range tests {
+		w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+
+		if want, got := test.hit, c.lookup(w, test.key, now.Add(test.elapsed)); want != got {
+			t.Errorf("%s after %v: want hit %t, got %t", test.key.Name, test.elapsed, want, got)
+			continue
+		}
+		if test.hit {
+			if want, got := test.ttl, w.msg.Answers[0].TTL; want != got {
+				t.Errorf("%s after %v: want TTL %v, got %v", test.key.Name, test.elapsed, want, got)
+			}
+		}
+	}
+}
+
+func TestClientCacheECSScope(t *testing.T) {
+	t.Parallel()
+
+	ecs := func(scope byte) *Message {
+		return &Message{
+			Questions: []Question{{Name: "ecs.dev.", Type: TypeA, Class: ClassIN}},
+			Answers: []Resource{
+				{Name: "ecs.dev.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(10, 0, 0, 1).To4()}},
+			},
+			Additionals: []Resource{
+				{Name: ".", Class: 1232, Record: &OPT{
+					Options: []edns.Option{
+						{Code: edns.OptionCodeEDNSClientSubnet, Data: []byte{0, 1, 24, scope, 192, 0, 2}},
+					},
+				}},
+			},
+		}
+	}
+
+	now := time.Now()
+
+	c := new(ClientCache)
+	c.insert(clientCacheKeyOf(ecs(0)), ecs(24), now)
+
+	other := ecs(0)
+	other.Additionals[0].Record.(*OPT).Options[0].Data = []byte{0, 1, 24, 0, 198, 51, 100}
+
+	if !c.lookup(&clientWriter{messageWriter: &messageWriter{msg: new(Message)}}, clientCacheKeyOf(ecs(0)), now) {
+		t.Error("want hit for the same subnet")
+	}
+	if c.lookup(&clientWriter{messageWriter: &messageWriter{msg: new(Message)}}, clientCacheKeyOf(other), now) {
+		t.Error("want miss for another subnet")
+	}
+
+	c.insert(clientCacheKeyOf(ecs(0)), ecs(0), now)
+	if !c.lookup(&clientWriter{messageWriter: &messageWriter{msg: new(Message)}}, clientCacheKeyOf(other), now) {
+		t.Error("want hit for another subnet with a zero scope")
+	}
+}
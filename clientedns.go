@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// ednsSafePayloadSize is a UDP payload size that avoids IP fragmentation on
+// most paths, see https://www.dnsflagday.net/2020/.
+const ednsSafePayloadSize = 1232
+
+// extendedRCodeBadVers is the upper 8 bits of the BADVERS extended RCODE, see
+// RFC 6891, section 6.1.3.
+const extendedRCodeBadVers = 1
+
+// negotiateEDNS reports whether the client adds an EDNS OPT record to query.
+func (c *Client) negotiateEDNS(query *Query) bool {
+	if c.EDNSPayloadSize <= 0 || query.RemoteAddr == nil {
+		return false
+	}
+	if !strings.HasPrefix(query.RemoteAddr.Network(), "udp") {
+		return false
+	}
+
+	for _, res := range query.Additionals {
+		if _, ok := res.Record.(*OPT); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ednsSizes returns the payload sizes to try for the server at addr, starting
+// with the size recorded for it. A size of 0 is a query without EDNS.
+func (c *Client) ednsSizes(addr string) []int {
+	sizes := []int{c.EDNSPayloadSize}
+	if c.EDNSPayloadSize > ednsSafePayloadSize {
+		sizes = append(sizes, ednsSafePayloadSize)
+	}
+	if c.EDNSPayloadSize > maxPacketLen {
+		sizes = append(sizes, maxPacketLen)
+	}
+	sizes = append(sizes, 0)
+
+	c.ednsmu.Lock()
+	size, ok := c.ednsProfiles[addr]
+	c.ednsmu.Unlock()
+
+	if ok {
+		for i, s := range sizes {
+			if s <= size {
+				return sizes[i:]
+			}
+		}
+	}
+	return sizes
+}
+
+func (c *Client) setEDNSProfile(addr string, size int) {
+	c.ednsmu.Lock()
+	defer c.ednsmu.Unlock()
+
+	if c.ednsProfiles == nil {
+		c.ednsProfiles = make(map[string]int)
+	}
+	c.ednsProfiles[addr] = size
+}
+
+// roundtripEDNS sends query with decreasing EDNS payload sizes until one is
+// answered without an error indicating the size, or EDNS, is not supported.
+func (c *Client) roundtripEDNS(ctx context.Context, conn Conn, query *Query) (*Message, error) {
+	addr := query.RemoteAddr.String()
+	sizes := c.ednsSizes(addr)
+
+	if c.EDNSTimeout > 0 {
+		deadline, _ := ctx.Deadline()
+		defer conn.SetReadDeadline(deadline)
+	}
+
+	var (
+		msg *Message
+		err error
+	)
+	for i, size := range sizes {
+		last := i == len(sizes)-1
+
+		if c.EDNSTimeout > 0 {
+			deadline, ok := ctx.Deadline()
+			if t := time.Now().Add(c.EDNSTimeout); !last && (!ok || t.Before(deadline)) {
+				deadline = t
+			}
+			if err := conn.SetReadDeadline(deadline); err != nil {
+				return nil, err
+			}
+		}
+
+		if msg, err = c.sendEDNS(ctx, conn, query, size); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && c.EDNSTimeout > 0 && ctx.Err() == nil && !last {
+				c.log(ctx, slog.LevelDebug, "dns edns fallback", "addr", addr, "size", size, "err", err)
+				continue
+			}
+			return nil, err
+		}
+
+		if !last && ednsRejected(msg) {
+			c.log(ctx, slog.LevelDebug, "dns edns fallback", "addr", addr, "size", size, "rcode", msg.RCode)
+			continue
+		}
+
+		c.setEDNSProfile(addr, size)
+		if size > 0 {
+			removeOPT(msg)
+		}
+		return msg, nil
+	}
+	return msg, err
+}
+
+// sendEDNS sends query with an OPT record advertising size, if not zero.
+func (c *Client) sendEDNS(ctx context.Context, conn Conn, query *Query, size int) (*Message, error) {
+	req := *query.Message
+
+	if size > 0 {
+		req.Additionals = append(req.Additionals[:len(req.Additionals):len(req.Additionals)], Resource{
+			Name:   ".",
+			Class:  Class(size),
+			Record: new(OPT),
+		})
+	}
+
+	if err := c.send(ctx, conn, &req); err != nil {
+		return nil, err
+	}
+
+	// a response to an earlier attempt may arrive late
+	msg := new(Message)
+	err := recvID(conn, msg, req.ID)
+	ContextClientTrace(ctx).gotResponse(msg, err)
+	if err != nil {
+		return nil, err
+	}
+	msg.ID = query.ID
+
+	return msg, nil
+}
+
+// removeOPT removes the OPT record answering the one added to a query, as the
+// caller did not request EDNS.
+func removeOPT(msg *Message) {
+	ress := make([]Resource, 0, len(msg.Additionals))
+	for _, res := range msg.Additionals {
+		if _, ok := res.Record.(*OPT); !ok {
+			ress = append(ress, res)
+		}
+	}
+	msg.Additionals = ress
+}
+
+// ednsRejected reports whether msg indicates the server does not support the
+// EDNS OPT record of the query.
+func ednsRejected(msg *Message) bool {
+	if msg.RCode == FormErr {
+		return true
+	}
+
+	for _, res := range msg.Additionals {
+		if _, ok := res.Record.(*OPT); ok && uint32(res.TTL/time.Second)>>24 == extendedRCodeBadVers {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientEDNSFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		// serve returns the response to a query advertising size, 0 without
+		// EDNS, or nil to drop the query.
+		serve func(req *Message, size int) *Message
+
+		sizes, next []int
+	}{
+		{
+			name: "supported",
+
+			serve: func(req *Message, size int) *Message {
+				return ednsResponse(req, size, NoError, 0)
+			},
+
+			sizes: []int{4096},
+			next:  []int{4096},
+		},
+		{
+			name: "formerr",
+
+			serve: func(req *Message, size int) *Message {
+				if size > 0 {
+					return ednsResponse(req, 0, FormErr, 0)
+				}
+				return ednsResponse(req, 0, NoError, 0)
+			},
+
+			sizes: []int{4096, 1232, 512, 0},
+			next:  []int{0},
+		},
+		{
+			name: "badvers",
+
+			serve: func(req *Message, size int) *Message {
+				if size > 512 {
+					return ednsResponse(req, size, NoError, extendedRCodeBadVers)
+				}
+				return ednsResponse(req, size, NoError, 0)
+			},
+
+			sizes: []int{4096, 1232, 512},
+			next:  []int{512},
+		},
+		{
+			name: "timeout",
+
+			serve: func(req *Message, size int) *Message {
+				if size > ednsSafePayloadSize {
+					return nil
+				}
+				return ednsResponse(req, size, NoError, 0)
+			},
+
+			sizes: []int{4096, 1232},
+			next:  []int{1232},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ln, sizes := mustEDNSServer(t, test.serve)
+			defer ln.Close()
+
+			client := &Client{
+				EDNSPayloadSize: 4096,
+				EDNSTimeout:     50 * time.Millisecond,
+			}
+
+			query := &Query{
+				RemoteAddr: ln.LocalAddr(),
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+				},
+			}
+
+			for _, want := range [][]int{test.sizes, test.next} {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				msg, err := client.Do(ctx, query)
+				cancel()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want, got := NoError, msg.RCode; want != got {
+					t.Errorf("want rcode %v, got %v", want, got)
+				}
+				if want, got := 0, len(msg.Additionals); want != got {
+					t.Errorf("want %d additionals, got %d", want, got)
+				}
+
+				var got []int
+				for len(sizes) > 0 {
+					got = append(got, <-sizes)
+				}
+				if !reflect.DeepEqual(want, got) {
+					t.Errorf("want payload sizes %v, got %v", want, got)
+				}
+			}
+		})
+	}
+}
+
+func ednsResponse(req *Message, size int, rcode RCode, extended uint32) *Message {
+	res := response(req)
+	res.RCode = rcode
+	res.Answers = []Resource{
+		{
+			Name:   questions["A"].Name,
+			Class:  ClassIN,
+			TTL:    time.Minute,
+			Record: answers[questions["A"]],
+		},
+	}
+	if size > 0 {
+		res.Additionals = []Resource{
+			{
+				Name:   ".",
+				Class:  Class(size),
+				TTL:    time.Duration(extended<<24) * time.Second,
+				Record: new(OPT),
+			},
+		}
+	}
+	return res
+}
+
+// mustEDNSServer serves UDP queries with serve, sending the EDNS payload size
+// of each query received on the returned channel.
+func mustEDNSServer(t *testing.T, serve func(*Message, int) *Message) (net.PacketConn, chan int) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(chan int, 8)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := ln.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var req Message
+			if _, err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+
+			var size int
+			for _, res := range req.Additionals {
+				if _, ok := res.Record.(*OPT); ok {
+					size = int(res.Class)
+				}
+			}
+			sizes <- size
+
+			if res := serve(&req, size); res != nil {
+				b, err := res.Pack(nil, true)
+				if err != nil {
+					continue
+				}
+				ln.WriteTo(b, addr)
+			}
+		}
+	}()
+
+	return ln, sizes
+}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+type clientTraceKey struct{}
+
+// ClientTrace is a set of hooks called at the stages of the queries sent by a
+// Client, in the manner of net/http/httptrace. Any hook may be nil. The hooks
+// may be called concurrently from different goroutines, and some may be
+// called more than once for a query, such as when a query is retried with a
+// smaller EDNS payload size.
+type ClientTrace struct {
+	// DNSStart is called when Client.Do starts a query.
+	DNSStart func(DNSStartInfo)
+
+	// ConnectStart is called when a Transport starts dialing a server. It is
+	// not called when a query reuses a pipelined connection.
+	ConnectStart func(network, addr string)
+
+	// ConnectDone is called when a Transport is done dialing a server, with
+	// the error of the dial if it failed.
+	ConnectDone func(network, addr string, err error)
+
+	// WroteQuery is called with the result of writing a query message to
+	// the connection.
+	WroteQuery func(WroteQueryInfo)
+
+	// GotFirstByte is called when the first byte of a response is read from
+	// a connection dialed by a Transport for the query. It is not called for
+	// the queries sharing a pipelined connection, whose responses are read
+	// in the background.
+	GotFirstByte func()
+
+	// GotResponse is called with the result of reading a response message
+	// from the connection.
+	GotResponse func(GotResponseInfo)
+
+	// RetriedTCP is called by a Racer when a truncated response starts the
+	// attempt to the next of its addresses, a TCP one.
+	RetriedTCP func(addr net.Addr)
+}
+
+// DNSStartInfo is passed to the DNSStart hook.
+type DNSStartInfo struct {
+	Questions []Question
+	Addr      net.Addr
+}
+
+// WroteQueryInfo is passed to the WroteQuery hook.
+type WroteQueryInfo struct {
+	Err error
+}
+
+// GotResponseInfo is passed to the GotResponse hook.
+type GotResponseInfo struct {
+	Msg *Message // nil if reading the response failed
+	Err error
+}
+
+// WithClientTrace returns a context carrying trace, whose hooks are called for
+// the queries sent with the context. A trace already carried by ctx is
+// replaced.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the trace carried by ctx, or nil if there is none.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+func (t *ClientTrace) dnsStart(query *Query) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(DNSStartInfo{Questions: query.Questions, Addr: query.RemoteAddr})
+	}
+}
+
+func (t *ClientTrace) connectStart(network, addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, addr)
+	}
+}
+
+func (t *ClientTrace) connectDone(network, addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, addr, err)
+	}
+}
+
+func (t *ClientTrace) wroteQuery(err error) {
+	if t != nil && t.WroteQuery != nil {
+		t.WroteQuery(WroteQueryInfo{Err: err})
+	}
+}
+
+func (t *ClientTrace) gotResponse(msg *Message, err error) {
+	if t == nil || t.GotResponse == nil {
+		return
+	}
+	if err != nil {
+		msg = nil
+	}
+	t.GotResponse(GotResponseInfo{Msg: msg, Err: err})
+}
+
+func (t *ClientTrace) retriedTCP(addr net.Addr) {
+	if t != nil && t.RetriedTCP != nil {
+		t.RetriedTCP(addr)
+	}
+}
+
+// traceConn is a net Conn calling the GotFirstByte hook of a trace on the
+// first read following each write.
+type traceConn struct {
+	net.Conn
+
+	trace *ClientTrace
+
+	wrote int32
+}
+
+// traceFirstByte wraps conn to call the GotFirstByte hook of the trace of ctx,
+// if any.
+func traceFirstByte(ctx context.Context, conn net.Conn) net.Conn {
+	if trace := ContextClientTrace(ctx); trace != nil && trace.GotFirstByte != nil {
+		return &traceConn{Conn: conn, trace: trace}
+	}
+	return conn
+}
+
+func (c *traceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && atomic.CompareAndSwapInt32(&c.wrote, 1, 0) {
+		c.trace.GotFirstByte()
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.StoreInt32(&c.wrote, 1)
+	}
+	return n, err
+}
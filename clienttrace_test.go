@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientTrace(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addrUDP, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrTCP, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		addr      net.Addr
+		transport *Transport
+	}{
+		{name: "udp", addr: addrUDP, transport: new(Transport)},
+		{name: "tcp", addr: addrTCP, transport: &Transport{DisablePipelining: true}},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu     sync.Mutex
+				events []string
+			)
+			event := func(ev string) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				events = append(events, ev)
+			}
+
+			trace := &ClientTrace{
+				DNSStart: func(info DNSStartInfo) {
+					if info.Addr != test.addr || len(info.Questions) != 1 {
+						t.Errorf("want start of query to %v, got %+v", test.addr, info)
+					}
+					event("DNSStart")
+				},
+				ConnectStart: func(network, addr string) {
+					if want, got := srv.Addr, addr; want != got {
+						t.Errorf("want connect to %s, got %s", want, got)
+					}
+					event("ConnectStart")
+				},
+				ConnectDone: func(network, addr string, err error) {
+					if err != nil {
+						t.Error(err)
+					}
+					event("ConnectDone")
+				},
+				WroteQuery: func(info WroteQueryInfo) {
+					if info.Err != nil {
+						t.Error(info.Err)
+					}
+					event("WroteQuery")
+				},
+				GotFirstByte: func() { event("GotFirstByte") },
+				GotResponse: func(info GotResponseInfo) {
+					if info.Err != nil || info.Msg == nil {
+						t.Errorf("want response, got %+v", info)
+					}
+					event("GotResponse")
+				},
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			client := &Client{Transport: test.transport}
+			if _, err := client.Do(WithClientTrace(ctx, trace), &Query{
+				RemoteAddr: test.addr,
+				Message:    &Message{Questions: []Question{questions["A"]}},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			want := "DNSStart ConnectStart ConnectDone WroteQuery GotFirstByte GotResponse"
+			if got := strings.Join(events, " "); want != got {
+				t.Errorf("want events %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestRacerTraceRetriedTCP(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addrTCP, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a nameserver truncating every response
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, maxPacketLen)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			msg := new(Message)
+			if _, err := msg.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			msg.Response, msg.Truncated = true, true
+
+			b, err := msg.Pack(nil, true)
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+	addrUDP := conn.LocalAddr()
+
+	var retried []net.Addr
+	ctx := WithClientTrace(context.Background(), &ClientTrace{
+		RetriedTCP: func(addr net.Addr) { retried = append(retried, addr) },
+	})
+
+	racer := &Racer{
+		Addrs:   []net.Addr{addrUDP, addrTCP},
+		Stagger: time.Minute,
+	}
+
+	msg, err := racer.Do(ctx, &Query{
+		Message: &Message{Questions: []Question{questions["A"]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Truncated {
+		t.Error("want response over TCP")
+	}
+
+	if len(retried) != 1 || retried[0] != addrTCP {
+		t.Errorf("want retry on %v, got %v", addrTCP, retried)
+	}
+}
+
+func TestContextClientTrace(t *testing.T) {
+	t.Parallel()
+
+	if trace := ContextClientTrace(context.Background()); trace != nil {
+		t.Errorf("want no trace, got %+v", trace)
+	}
+
+	trace := new(ClientTrace)
+	if want, got := trace, ContextClientTrace(WithClientTrace(context.Background(), trace)); want != got {
+		t.Errorf("want trace %p, got %p", want, got)
+	}
+}
@@ -0,0 +1,268 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The limits of a ChangeResourceRecordSets request of Route53, an UPSERT
+// counting twice.
+const (
+	maxRoute53Records = 1000
+	maxRoute53Chars   = 32000
+)
+
+// The actions of a Route53Change.
+const (
+	Route53Create = "CREATE"
+	Route53Delete = "DELETE"
+	Route53Upsert = "UPSERT"
+)
+
+var errNoSOA = errors.New("no SOA record")
+
+// Route53ChangeBatch is the ChangeBatch of a ChangeResourceRecordSets request
+// of Amazon Route53, in the JSON form of the API and of the --change-batch
+// option of "aws route53 change-resource-record-sets".
+type Route53ChangeBatch struct {
+	Comment string          `json:",omitempty"`
+	Changes []Route53Change `json:"Changes"`
+}
+
+// Route53Change is a change of a Route53ChangeBatch.
+type Route53Change struct {
+	Action            string                   `json:"Action"`
+	ResourceRecordSet Route53ResourceRecordSet `json:"ResourceRecordSet"`
+}
+
+// Route53ResourceRecordSet is the RRset of a name and type of a Route53
+// hosted zone. The alias record sets have no ResourceRecords.
+type Route53ResourceRecordSet struct {
+	Name            string                  `json:"Name"`
+	Type            string                  `json:"Type"`
+	TTL             int64                   `json:"TTL,omitempty"`
+	ResourceRecords []Route53ResourceRecord `json:"ResourceRecords,omitempty"`
+}
+
+// Route53ResourceRecord is a record of a Route53ResourceRecordSet, its value
+// in presentation format.
+type Route53ResourceRecord struct {
+	Value string `json:"Value"`
+}
+
+// CloudDNSRecordSet is the ResourceRecordSet of a name and type of a Google
+// Cloud DNS managed zone, in the JSON form of the API, such as the additions
+// of a change, and of "gcloud dns record-sets list --format=json".
+type CloudDNSRecordSet struct {
+	Kind    string   `json:"kind,omitempty"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+// Route53ChangeBatches returns the changes of action, such as Route53Upsert,
+// of the RRsets of z, split into the batches of the requests. The SOA and
+// NS records of the apex are left out, as they are those of the hosted zone,
+// and so are the records of classes other than IN. The TTL of an RRset is the
+// lowest of its records.
+func Route53ChangeBatches(z *Zone, action string) []Route53ChangeBatch {
+	weight := 1
+	if action == Route53Upsert {
+		weight = 2
+	}
+
+	var (
+		batches []Route53ChangeBatch
+		batch   Route53ChangeBatch
+		records int
+		chars   int
+	)
+	for _, set := range cloudRRsets(z) {
+		n, c := len(set.rrs)*weight, 0
+		values := set.values()
+		for _, v := range values {
+			c += len(v) * weight
+		}
+		if len(batch.Changes) > 0 && (records+n > maxRoute53Records || chars+c > maxRoute53Chars) {
+			batches, batch, records, chars = append(batches, batch), Route53ChangeBatch{}, 0, 0
+		}
+
+		rrs := make([]Route53ResourceRecord, len(values))
+		for i, v := range values {
+			rrs[i] = Route53ResourceRecord{Value: v}
+		}
+		batch.Changes = append(batch.Changes, Route53Change{
+			Action: action,
+			ResourceRecordSet: Route53ResourceRecordSet{
+				Name:            set.name,
+				Type:            typeText(set.t),
+				TTL:             int64(set.ttl / time.Second),
+				ResourceRecords: rrs,
+			},
+		})
+		records, chars = records+n, chars+c
+	}
+	if len(batch.Changes) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// CloudDNSRecordSets returns the RRsets of z, leaving out the same records
+// as Route53ChangeBatches.
+func CloudDNSRecordSets(z *Zone) []CloudDNSRecordSet {
+	sets := cloudRRsets(z)
+
+	rrsets := make([]CloudDNSRecordSet, 0, len(sets))
+	for _, set := range sets {
+		rrsets = append(rrsets, CloudDNSRecordSet{
+			Kind:    "dns#resourceRecordSet",
+			Name:    set.name,
+			Type:    typeText(set.t),
+			TTL:     int64(set.ttl / time.Second),
+			RRDatas: set.values(),
+		})
+	}
+	return rrsets
+}
+
+// ParseRoute53 returns the zone origin of the record sets b exported from a
+// Route53 hosted zone, the output of "aws route53 list-resource-record-sets"
+// or its list of ResourceRecordSets. The zone TTL is that of the SOA record,
+// and the records with another TTL are stored as an RREntry with their TTL.
+// The alias record sets, and the records of types without a presentation
+// format here, are left out.
+func ParseRoute53(b []byte, origin string) (*Zone, error) {
+	var sets []Route53ResourceRecordSet
+	if err := json.Unmarshal(b, &sets); err != nil {
+		var list struct {
+			ResourceRecordSets []Route53ResourceRecordSet `json:"ResourceRecordSets"`
+		}
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		sets = list.ResourceRecordSets
+	}
+
+	var rs []Resource
+	for _, set := range sets {
+		values := make([]string, len(set.ResourceRecords))
+		for i, rr := range set.ResourceRecords {
+			values[i] = rr.Value
+		}
+		rs = append(rs, cloudResources(unescapeOctal(set.Name), set.Type, set.TTL, values)...)
+	}
+	return cloudZone(rs, origin)
+}
+
+// ParseCloudDNS returns the zone origin of the record sets b exported from a
+// Cloud DNS managed zone, a list of ResourceRecordSets or the response of
+// their list method, as ParseRoute53 does.
+func ParseCloudDNS(b []byte, origin string) (*Zone, error) {
+	var sets []CloudDNSRecordSet
+	if err := json.Unmarshal(b, &sets); err != nil {
+		var list struct {
+			RRSets []CloudDNSRecordSet `json:"rrsets"`
+		}
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, err
+		}
+		sets = list.RRSets
+	}
+
+	var rs []Resource
+	for _, set := range sets {
+		rs = append(rs, cloudResources(set.Name, set.Type, set.TTL, set.RRDatas)...)
+	}
+	return cloudZone(rs, origin)
+}
+
+// cloudRRsets returns the RRsets of z without those of the apex NS records.
+func cloudRRsets(z *Zone) []zoneRRset {
+	sets := zoneRRsets(z)
+
+	out := sets[:0]
+	for _, set := range sets {
+		if set.name != z.fqdn("") || set.t != TypeNS {
+			out = append(out, set)
+		}
+	}
+	return out
+}
+
+// cloudResources returns the records of the RRset of name and type text with
+// the values in presentation format.
+func cloudResources(name, text string, ttl int64, values []string) []Resource {
+	t, ok := parseTypeText(text)
+	if !ok {
+		return nil
+	}
+
+	var rs []Resource
+	for _, v := range values {
+		rr, err := parseRDataText(t, v)
+		if err != nil {
+			continue
+		}
+		rs = append(rs, Resource{
+			Name:   strings.TrimSuffix(name, ".") + ".",
+			Class:  ClassIN,
+			TTL:    time.Duration(ttl) * time.Second,
+			Record: rr,
+		})
+	}
+	return rs
+}
+
+// cloudZone returns the zone origin of the records rs, read as a zone
+// transfer opened and closed by its SOA record.
+func cloudZone(rs []Resource, origin string) (*Zone, error) {
+	origin = strings.TrimSuffix(origin, ".") + "."
+
+	var soa *Resource
+	for i, res := range rs {
+		if res.Record.Type() == TypeSOA && strings.EqualFold(res.Name, origin) {
+			soa = &rs[i]
+		}
+	}
+	if soa == nil {
+		return nil, errNoSOA
+	}
+
+	answers := make([]Resource, 0, len(rs)+2)
+	answers = append(answers, *soa)
+	for _, res := range rs {
+		if res.Record.Type() != TypeSOA {
+			answers = append(answers, res)
+		}
+	}
+	answers = append(answers, *soa)
+
+	zr := newZoneReader(origin)
+	zr.add(&Message{Answers: answers})
+	return zr.zone()
+}
+
+// unescapeOctal returns name with its \ooo octal escapes unescaped, such as
+// the \052 of a wildcard in Route53, or the \072 of a colon in tinydns-data.
+func unescapeOctal(name string) string {
+	if !strings.Contains(name, `\`) {
+		return name
+	}
+
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if n, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				b, i = append(b, byte(n)), i+3
+				continue
+			}
+		}
+		b = append(b, name[i])
+	}
+	return string(b)
+}
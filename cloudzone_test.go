@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var cloudDevZone = &Zone{
+	Origin: "cloud.dev.",
+	TTL:    5 * time.Minute,
+	SOA:    &SOA{NS: "ns.cloud.dev.", MBox: "hostmaster.cloud.dev.", Serial: 3},
+	RRs: NewRRSet(map[string]map[Type][]Record{
+		"": {
+			TypeNS: {&NS{NS: "ns.cloud.dev."}},
+			TypeMX: {&MX{Pref: 10, MX: "mail.cloud.dev."}},
+		},
+		"www": {
+			TypeA: {
+				&A{A: net.IPv4(192, 0, 2, 1).To4()},
+				&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}, TTL: time.Minute},
+			},
+			TypeTXT: {&TXT{TXT: []string{"v=spf1 -all"}}},
+		},
+		"*.apps": {TypeCNAME: {&CNAME{CNAME: "www.cloud.dev."}}},
+		"chaos":  {TypeTXT: {&RREntry{Record: &TXT{TXT: []string{"local"}}, Class: ClassCH}}},
+	}),
+}
+
+func TestRoute53ChangeBatches(t *testing.T) {
+	t.Parallel()
+
+	batches := Route53ChangeBatches(cloudDevZone, Route53Upsert)
+	if want, got := 1, len(batches); want != got {
+		t.Fatalf("want %d batches, got %d", want, got)
+	}
+
+	b, err := json.Marshal(batches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"Changes":[` +
+		`{"Action":"UPSERT","ResourceRecordSet":{"Name":"*.apps.cloud.dev.","Type":"CNAME","TTL":300,"ResourceRecords":[{"Value":"www.cloud.dev."}]}},` +
+		`{"Action":"UPSERT","ResourceRecordSet":{"Name":"cloud.dev.","Type":"MX","TTL":300,"ResourceRecords":[{"Value":"10 mail.cloud.dev."}]}},` +
+		`{"Action":"UPSERT","ResourceRecordSet":{"Name":"www.cloud.dev.","Type":"A","TTL":60,"ResourceRecords":[{"Value":"192.0.2.1"},{"Value":"192.0.2.2"}]}},` +
+		`{"Action":"UPSERT","ResourceRecordSet":{"Name":"www.cloud.dev.","Type":"TXT","TTL":300,"ResourceRecords":[{"Value":"\"v=spf1 -all\""}]}}]}`
+	if got := string(b); want != got {
+		t.Errorf("want batch %s, got %s", want, got)
+	}
+
+	rrs := make(map[string]map[Type][]Record)
+	for i := 0; i < 600; i++ {
+		rrs[fmt.Sprintf("host%d", i)] = map[Type][]Record{TypeA: {&A{A: net.IPv4(192, 0, 2, byte(i)).To4()}}}
+	}
+	z := &Zone{Origin: "cloud.dev.", TTL: time.Minute, RRs: NewRRSet(rrs)}
+
+	for _, test := range []struct {
+		action  string
+		batches int
+	}{
+		{Route53Create, 1},
+		{Route53Upsert, 2},
+	} {
+		batches := Route53ChangeBatches(z, test.action)
+		if want, got := test.batches, len(batches); want != got {
+			t.Errorf("%s: want %d batches, got %d", test.action, want, got)
+		}
+
+		var changes int
+		for _, batch := range batches {
+			changes += len(batch.Changes)
+		}
+		if want, got := 600, changes; want != got {
+			t.Errorf("%s: want %d changes, got %d", test.action, want, got)
+		}
+	}
+}
+
+func TestParseRoute53(t *testing.T) {
+	t.Parallel()
+
+	export := `{"ResourceRecordSets":[
+		{"Name":"cloud.dev.","Type":"SOA","TTL":900,"ResourceRecords":[{"Value":"ns-1.awsdns-01.org. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400"}]},
+		{"Name":"cloud.dev.","Type":"NS","TTL":172800,"ResourceRecords":[{"Value":"ns-1.awsdns-01.org."}]},
+		{"Name":"\\052.apps.cloud.dev.","Type":"CNAME","TTL":900,"ResourceRecords":[{"Value":"www.cloud.dev"}]},
+		{"Name":"www.cloud.dev.","Type":"A","TTL":60,"ResourceRecords":[{"Value":"192.0.2.1"},{"Value":"192.0.2.2"}]},
+		{"Name":"www.cloud.dev.","Type":"TXT","TTL":900,"ResourceRecords":[{"Value":"\"v=spf1 -all\""}]},
+		{"Name":"cdn.cloud.dev.","Type":"A","AliasTarget":{"HostedZoneId":"Z2FDTNDATAQYW2","DNSName":"d111111abcdef8.cloudfront.net.","EvaluateTargetHealth":false}},
+		{"Name":"other.dev.","Type":"A","TTL":60,"ResourceRecords":[{"Value":"192.0.2.9"}]}
+	]}`
+
+	z, err := ParseRoute53([]byte(export), "cloud.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 15*time.Minute, z.TTL; want != got {
+		t.Errorf("want zone TTL %v, got %v", want, got)
+	}
+	if want, got := 1, z.SOA.Serial; want != got {
+		t.Errorf("want serial %d, got %d", want, got)
+	}
+	if want, got := []string{"", "*.apps", "www"}, z.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+
+	want := []Record{
+		&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}, TTL: time.Minute},
+		&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}, TTL: time.Minute},
+	}
+	if got := z.GetRecords("www", TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+
+	if _, err := ParseRoute53([]byte(`[]`), "cloud.dev."); err != errNoSOA {
+		t.Errorf("want error %v, got %v", errNoSOA, err)
+	}
+}
+
+func TestCloudDNSRecordSets(t *testing.T) {
+	t.Parallel()
+
+	src := cloudDevZone
+	sets := CloudDNSRecordSets(src)
+	if want, got := 4, len(sets); want != got {
+		t.Fatalf("want %d record sets, got %d", want, got)
+	}
+
+	sets = append(sets, CloudDNSRecordSet{
+		Name:    "cloud.dev.",
+		Type:    "SOA",
+		TTL:     300,
+		RRDatas: []string{rdataText(src.SOA)},
+	})
+	b, err := json.Marshal(map[string]interface{}{"kind": "dns#resourceRecordSetsListResponse", "rrsets": sets})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := ParseCloudDNS(b, "cloud.dev.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := src.SOA, z.SOA; !reflect.DeepEqual(want, got) {
+		t.Errorf("want SOA %v, got %v", want, got)
+	}
+	for _, test := range []struct {
+		name string
+		t    Type
+	}{
+		{"", TypeMX},
+		{"*.apps", TypeCNAME},
+		{"www", TypeTXT},
+	} {
+		if want, got := src.GetRecords(test.name, test.t), z.GetRecords(test.name, test.t); !reflect.DeepEqual(want, got) {
+			t.Errorf("%q %s: want records %v, got %v", test.name, typeText(test.t), want, got)
+		}
+	}
+
+	// the RRset of www takes the lowest TTL of its records
+	want := []Record{
+		&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}, TTL: time.Minute},
+		&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}, TTL: time.Minute},
+	}
+	if got := z.GetRecords("www", TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+}
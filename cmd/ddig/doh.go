@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/helmutkemper/dns"
+)
+
+// dohTransport is a dns.RoundTripper sending queries over HTTPS, with the POST
+// method of RFC 8484, section 4.1.
+type dohTransport struct {
+	url    string
+	client *http.Client // http.DefaultClient if nil
+}
+
+const dnsMessageType = "application/dns-message"
+
+func (t *dohTransport) Do(ctx context.Context, query *dns.Query) (*dns.Message, error) {
+	// The ID should be 0 to make the responses cacheable, see section 4.1.
+	msg := *query.Message
+	msg.ID = 0
+
+	b, err := msg.Pack(nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	client := t.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("https: %s", res.Status)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != dnsMessageType {
+		return nil, fmt.Errorf("https: content type %q", ct)
+	}
+
+	if b, err = io.ReadAll(io.LimitReader(res.Body, 0xFFFF+1)); err != nil {
+		return nil, err
+	}
+
+	resp := new(dns.Message)
+	if _, err := resp.Unpack(b); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// tlsConfig returns the TLS config of a server named host.
+func tlsConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host}
+}
@@ -0,0 +1,453 @@
+// Command ddig queries DNS servers, in the manner of dig.
+//
+// Usage:
+//
+//	ddig [@server] [-p port] [-t type] [-c class] [-x addr] [name] [type] [class] [+option...]
+//
+// The query options are:
+//
+//	+tcp              query over TCP
+//	+tls              query over TLS, see RFC 7858
+//	+https[=path]     query over HTTPS, see RFC 8484 ("/dns-query" by default)
+//	+dnssec           set the DNSSEC OK bit
+//	+short            print the data of the answers only
+//	+[no]recurse      set the RD bit (the default)
+//	+cd, +adflag      set the CD and AD bits
+//	+noedns           send the query without an OPT record
+//	+bufsize=N        advertise an EDNS UDP payload size of N (1232 by default)
+//	+nsid             request the name server identifier
+//	+subnet=addr/len  send an EDNS Client Subnet option
+//	+cookie           send a random client cookie
+//	+ednsopt=code[:hex]
+//	                  send an EDNS option of code with the hex encoded data
+//	+timeout=N        wait N seconds for a response (5 by default)
+//
+// The server defaults to the first nameserver of /etc/resolv.conf. The exit
+// status is 1 for usage errors and 9 when no response is received, as for dig.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helmutkemper/dns"
+	"github.com/helmutkemper/dns/edns"
+)
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout, nil)
+
+	var uerr usageError
+	switch {
+	case err == nil:
+	case errors.As(err, &uerr):
+		fmt.Fprintln(os.Stderr, "ddig:", err)
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stdout, ";; communications error: %s\n", err)
+		os.Exit(9)
+	}
+}
+
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// options are the parsed arguments of a query.
+type options struct {
+	server string
+	port   int
+
+	name   string
+	qtype  dns.Type
+	qclass dns.Class
+
+	tcp, tls  bool
+	https     bool
+	httpsPath string
+
+	short   bool
+	recurse bool
+	dnssec  bool
+	cd, ad  bool
+
+	noedns  bool
+	bufsize int
+	opts    []edns.Option
+
+	timeout time.Duration
+}
+
+func parseArgs(args []string) (*options, error) {
+	o := &options{
+		qclass:    dns.ClassIN,
+		recurse:   true,
+		bufsize:   1232,
+		httpsPath: "/dns-query",
+		timeout:   5 * time.Second,
+	}
+
+	var typed bool
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		value := func() (string, error) {
+			if i+1 == len(args) {
+				return "", usagef("missing argument of %s", arg)
+			}
+			i++
+			return args[i], nil
+		}
+
+		switch {
+		case strings.HasPrefix(arg, "@"):
+			o.server = arg[1:]
+		case strings.HasPrefix(arg, "+"):
+			if err := o.parseOption(arg[1:]); err != nil {
+				return nil, err
+			}
+		case arg == "-p":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if o.port, err = strconv.Atoi(v); err != nil || o.port <= 0 || o.port > 0xFFFF {
+				return nil, usagef("invalid port %q", v)
+			}
+		case arg == "-t":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			t, ok := parseType(v)
+			if !ok {
+				return nil, usagef("invalid type %q", v)
+			}
+			o.qtype, typed = t, true
+		case arg == "-c":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			c, ok := parseClass(v)
+			if !ok {
+				return nil, usagef("invalid class %q", v)
+			}
+			o.qclass = c
+		case arg == "-x":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := reverseName(v)
+			if !ok {
+				return nil, usagef("invalid address %q", v)
+			}
+			o.name, o.qtype, typed = name, dns.TypePTR, true
+		case strings.HasPrefix(arg, "-"):
+			return nil, usagef("unknown flag %s", arg)
+		default:
+			if t, ok := parseType(arg); ok && o.name != "" && !typed {
+				o.qtype, typed = t, true
+				continue
+			}
+			if c, ok := parseClass(arg); ok && o.name != "" {
+				o.qclass = c
+				continue
+			}
+			if o.name != "" {
+				return nil, usagef("unexpected argument %q", arg)
+			}
+			o.name = arg
+		}
+	}
+
+	if o.name == "" {
+		o.name = "."
+	}
+	if !strings.HasSuffix(o.name, ".") {
+		o.name += "."
+	}
+	if !typed {
+		o.qtype = dns.TypeA
+		if o.name == "." {
+			o.qtype = dns.TypeNS
+		}
+	}
+	return o, nil
+}
+
+func (o *options) parseOption(opt string) error {
+	name, value, hasValue := strings.Cut(opt, "=")
+
+	switch name {
+	case "tcp", "vc":
+		o.tcp = true
+	case "notcp", "novc":
+		o.tcp = false
+	case "tls":
+		o.tls = true
+	case "https":
+		o.https = true
+		if hasValue {
+			o.httpsPath = value
+		}
+	case "dnssec":
+		o.dnssec = true
+	case "short":
+		o.short = true
+	case "recurse":
+		o.recurse = true
+	case "norecurse":
+		o.recurse = false
+	case "cd", "cdflag":
+		o.cd = true
+	case "ad", "adflag":
+		o.ad = true
+	case "edns":
+		o.noedns = false
+	case "noedns":
+		o.noedns = true
+	case "bufsize":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 0xFFFF {
+			return usagef("invalid bufsize %q", value)
+		}
+		o.bufsize = n
+	case "timeout":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return usagef("invalid timeout %q", value)
+		}
+		o.timeout = time.Duration(n) * time.Second
+	case "nsid":
+		o.opts = append(o.opts, edns.Option{Code: edns.OptionCodeNSID})
+	case "cookie":
+		cookie := make([]byte, 8)
+		if hasValue {
+			var err error
+			if cookie, err = hex.DecodeString(value); err != nil {
+				return usagef("invalid cookie %q", value)
+			}
+		} else if _, err := rand.Read(cookie); err != nil {
+			return err
+		}
+		o.opts = append(o.opts, edns.Option{Code: edns.OptionCodeCookie, Data: cookie})
+	case "subnet":
+		data, ok := clientSubnet(value)
+		if !ok {
+			return usagef("invalid subnet %q", value)
+		}
+		o.opts = append(o.opts, edns.Option{Code: edns.OptionCodeEDNSClientSubnet, Data: data})
+	case "ednsopt":
+		code, data, _ := strings.Cut(value, ":")
+		n, err := strconv.ParseUint(code, 10, 16)
+		if err != nil {
+			return usagef("invalid EDNS option %q", value)
+		}
+		b, err := hex.DecodeString(data)
+		if err != nil {
+			return usagef("invalid EDNS option data %q", data)
+		}
+		o.opts = append(o.opts, edns.Option{Code: edns.OptionCode(n), Data: b})
+	default:
+		return usagef("unknown option +%s", opt)
+	}
+	return nil
+}
+
+// clientSubnet returns the data of the EDNS Client Subnet option of the
+// network s, see RFC 7871, section 6.
+func clientSubnet(s string) ([]byte, bool) {
+	if !strings.Contains(s, "/") {
+		if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, false
+	}
+	ones, _ := ipnet.Mask.Size()
+
+	family, ip := 2, ipnet.IP.To16()
+	if ip4 := ipnet.IP.To4(); ip4 != nil {
+		family, ip = 1, ip4
+	}
+
+	data := []byte{0, byte(family), byte(ones), 0}
+	return append(data, ip[:(ones+7)/8]...), true
+}
+
+// reverseName returns the PTR name of the address s.
+func reverseName(s string) (string, bool) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", false
+	}
+
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa.", true
+	}
+
+	const digits = "0123456789abcdef"
+	for i := len(ip) - 1; i >= 0; i-- {
+		labels = append(labels, string(digits[ip[i]&0xF]), string(digits[ip[i]>>4]))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa.", true
+}
+
+// defaultServer returns the first nameserver of /etc/resolv.conf.
+func defaultServer() string {
+	b, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1"
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+			return f[1]
+		}
+	}
+	return "127.0.0.1"
+}
+
+// message returns the query message of o.
+func (o *options) message() *dns.Message {
+	msg := &dns.Message{
+		RecursionDesired: o.recurse,
+		CheckingDisabled: o.cd,
+		AuthenticData:    o.ad,
+		Questions: []dns.Question{
+			{Name: o.name, Type: o.qtype, Class: o.qclass},
+		},
+	}
+
+	if !o.noedns {
+		var flags uint32
+		if o.dnssec {
+			flags = 0x8000
+		}
+
+		msg.Additionals = append(msg.Additionals, dns.Resource{
+			Name:   ".",
+			Class:  dns.Class(o.bufsize),
+			TTL:    time.Duration(flags) * time.Second,
+			Record: &dns.OPT{Options: o.opts},
+		})
+	}
+	return msg
+}
+
+// run queries the server of args and writes the response to w. The queries
+// not over HTTPS are sent through tport, a new dns.Transport if nil.
+func run(ctx context.Context, args []string, w io.Writer, tport dns.AddrDialer) error {
+	o, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	if o.server == "" {
+		o.server = defaultServer()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	rt, addr, proto, err := o.roundTripper(tport)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	msg, err := rt.Do(ctx, &dns.Query{
+		RemoteAddr: addr,
+		Message:    o.message(),
+	})
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	if o.short {
+		printShort(w, msg)
+		return nil
+	}
+
+	printHeader(w, args, msg)
+	printMessage(w, msg)
+	printFooter(w, msg, elapsed, o.server, addr, proto, start)
+	return nil
+}
+
+// roundTripper returns the RoundTripper and address of the server of o, and
+// the name of the protocol used.
+func (o *options) roundTripper(tport dns.AddrDialer) (dns.RoundTripper, net.Addr, string, error) {
+	if o.https {
+		port := o.port
+		if port == 0 {
+			port = 443
+		}
+
+		u := "https://" + net.JoinHostPort(o.server, strconv.Itoa(port)) + o.httpsPath
+		addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(o.server, strconv.Itoa(port)))
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return &dohTransport{url: u}, addr, "HTTPS", nil
+	}
+
+	port := o.port
+	if port == 0 {
+		port = 53
+		if o.tls {
+			port = 853
+		}
+	}
+	hostport := net.JoinHostPort(o.server, strconv.Itoa(port))
+
+	if tport == nil {
+		t := &dns.Transport{DisablePipelining: true}
+		if net.ParseIP(o.server) == nil {
+			t.TLSConfig = tlsConfig(o.server)
+		}
+		tport = t
+	}
+	client := &dns.Client{Transport: tport}
+
+	switch {
+	case o.tls:
+		addr, err := net.ResolveTCPAddr("tcp", hostport)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return client, dns.OverTLSAddr{Addr: addr}, "TLS", nil
+	case o.tcp:
+		addr, err := net.ResolveTCPAddr("tcp", hostport)
+		return client, addr, "TCP", err
+	default:
+		addr, err := net.ResolveUDPAddr("udp", hostport)
+		return client, addr, "UDP", err
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+	"github.com/benburkert/dns/dnstest"
+	"github.com/benburkert/dns/edns"
+)
+
+func TestParseArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args []string
+
+		name   string
+		qtype  dns.Type
+		qclass dns.Class
+		server string
+	}{
+		{
+			args:  nil,
+			name:  ".",
+			qtype: dns.TypeNS, qclass: dns.ClassIN,
+		},
+		{
+			args:  []string{"example.com"},
+			name:  "example.com.",
+			qtype: dns.TypeA, qclass: dns.ClassIN,
+		},
+		{
+			args:  []string{"@1.1.1.1", "example.com.", "mx", "ch"},
+			name:  "example.com.",
+			qtype: dns.TypeMX, qclass: dns.ClassCH, server: "1.1.1.1",
+		},
+		{
+			args:  []string{"-t", "TYPE65", "-c", "CLASS3", "example.com"},
+			name:  "example.com.",
+			qtype: dns.Type(65), qclass: dns.ClassCH,
+		},
+		{
+			args:  []string{"example.com", "ANY"},
+			name:  "example.com.",
+			qtype: dns.TypeALL, qclass: dns.ClassIN,
+		},
+		{
+			args:  []string{"-x", "192.0.2.1"},
+			name:  "1.2.0.192.in-addr.arpa.",
+			qtype: dns.TypePTR, qclass: dns.ClassIN,
+		},
+		{
+			args:  []string{"-x", "2001:db8::1"},
+			name:  "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+			qtype: dns.TypePTR, qclass: dns.ClassIN,
+		},
+		{
+			// the name is parsed before a type or class.
+			args:  []string{"a", "ns"},
+			name:  "a.",
+			qtype: dns.TypeNS, qclass: dns.ClassIN,
+		},
+	}
+
+	for _, test := range tests {
+		o, err := parseArgs(test.args)
+		if err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+
+		if want, got := test.name, o.name; want != got {
+			t.Errorf("%q: want name %q, got %q", test.args, want, got)
+		}
+		if want, got := test.qtype, o.qtype; want != got {
+			t.Errorf("%q: want type %d, got %d", test.args, want, got)
+		}
+		if want, got := test.qclass, o.qclass; want != got {
+			t.Errorf("%q: want class %d, got %d", test.args, want, got)
+		}
+		if want, got := test.server, o.server; want != got {
+			t.Errorf("%q: want server %q, got %q", test.args, want, got)
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, args := range [][]string{
+		{"-p"},
+		{"-p", "99999"},
+		{"-t", "BOGUS"},
+		{"-x", "nope"},
+		{"+bogus"},
+		{"+bufsize=x"},
+		{"+ednsopt=8:zz"},
+		{"+subnet=1.2.3.4/99"},
+		{"a.", "b."},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%q: want error", args)
+		}
+	}
+}
+
+func TestQueryMessage(t *testing.T) {
+	t.Parallel()
+
+	o, err := parseArgs([]string{"example.com", "+dnssec", "+norecurse", "+cd", "+bufsize=4096", "+nsid", "+subnet=192.0.2.0/24", "+ednsopt=65001:beef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := o.message()
+
+	if msg.RecursionDesired {
+		t.Error("want RD unset")
+	}
+	if !msg.CheckingDisabled {
+		t.Error("want CD set")
+	}
+
+	res := opt(msg)
+	if res == nil {
+		t.Fatal("want OPT record")
+	}
+	if want, got := dns.Class(4096), res.Class; want != got {
+		t.Errorf("want udp size %d, got %d", want, got)
+	}
+	if want, got := uint32(0x8000), uint32(res.TTL/time.Second); want != got {
+		t.Errorf("want OPT flags %#x, got %#x", want, got)
+	}
+
+	want := []edns.Option{
+		{Code: edns.OptionCodeNSID},
+		{Code: edns.OptionCodeEDNSClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}},
+		{Code: 65001, Data: []byte{0xbe, 0xef}},
+	}
+	got := res.Record.(*dns.OPT).Options
+	if len(want) != len(got) {
+		t.Fatalf("want %d options, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i].Code != got[i].Code || !bytes.Equal(want[i].Data, got[i].Data) {
+			t.Errorf("option %d: want %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	if o, _ := parseArgs([]string{"example.com", "+noedns"}); opt(o.message()) != nil {
+		t.Error("want no OPT record with +noedns")
+	}
+}
+
+func TestRawRecord(t *testing.T) {
+	t.Parallel()
+
+	rr := dns.NewRecordByType[48]()
+	if _, err := rr.Unpack([]byte{1, 0, 3, 13}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := `\# 4 0100030D`, rdata(rr); want != got {
+		t.Errorf("want rdata %q, got %q", want, got)
+	}
+	if want, got := "DNSKEY", typeName(rr.Type()); want != got {
+		t.Errorf("want type %q, got %q", want, got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	srv := dnstest.NewServer(dns.HandlerFunc(func(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+		q := r.Questions[0]
+		switch q.Type {
+		case dns.TypeA:
+			w.Answer(q.Name, time.Minute, &dns.A{A: net.IPv4(127, 0, 0, 1).To4()})
+		case dns.TypeTXT:
+			w.Answer(q.Name, time.Minute, &dns.TXT{TXT: []string{"hello world"}})
+		default:
+			w.Status(dns.NXDomain)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{
+			args: []string{"@192.0.2.53", "app.dev"},
+			want: []string{
+				";; ->>HEADER<<- opcode: QUERY, status: NOERROR",
+				";; flags: qr rd; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1",
+				";; OPT PSEUDOSECTION:\n; EDNS: version: 0, flags:; udp: ",
+				";; QUESTION SECTION:\n;app.dev.\t\tIN\tA\n",
+				";; ANSWER SECTION:\napp.dev.\t60\tIN\tA\t127.0.0.1\n",
+				";; SERVER: 192.0.2.53#53(192.0.2.53) (UDP)",
+			},
+		},
+		{
+			args: []string{"@192.0.2.53", "+tcp", "+noedns", "app.dev", "txt"},
+			want: []string{
+				";; flags: qr rd; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 0",
+				"app.dev.\t60\tIN\tTXT\t\"hello world\"\n",
+				"(TCP)",
+			},
+		},
+		{
+			args: []string{"@192.0.2.53", "none.dev", "mx"},
+			want: []string{"status: NXDOMAIN"},
+		},
+		{
+			args: []string{"@192.0.2.53", "+short", "app.dev"},
+			want: []string{"127.0.0.1\n"},
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := run(context.Background(), test.args, &buf, srv.Transport()); err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+
+		for _, want := range test.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%q: want %q in output:\n%s", test.args, want, buf.String())
+			}
+		}
+	}
+}
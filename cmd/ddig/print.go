@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helmutkemper/dns"
+	"github.com/helmutkemper/dns/edns"
+)
+
+// rdata returns the record data of r in the presentation format.
+func rdata(r dns.Record) string {
+	switch r := r.(type) {
+	case *dns.A:
+		return r.A.String()
+	case *dns.AAAA:
+		return r.AAAA.String()
+	case *dns.NS:
+		return r.NS
+	case *dns.CNAME:
+		return r.CNAME
+	case *dns.PTR:
+		return r.PTR
+	case *dns.DNAME:
+		return r.DNAME
+	case *dns.MX:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dns.SRV:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dns.SOA:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, uint32(r.Serial),
+			seconds(r.Refresh), seconds(r.Retry), seconds(r.Expire), seconds(r.MinTTL))
+	case *dns.TXT:
+		quoted := make([]string, len(r.TXT))
+		for i, s := range r.TXT {
+			quoted[i] = strconv.Quote(s)
+		}
+		return strings.Join(quoted, " ")
+	default:
+		return r.Key()
+	}
+}
+
+func seconds(d time.Duration) int64 { return int64(d / time.Second) }
+
+func printShort(w io.Writer, msg *dns.Message) {
+	for _, res := range msg.Answers {
+		fmt.Fprintln(w, rdata(res.Record))
+	}
+}
+
+func printHeader(w io.Writer, args []string, msg *dns.Message) {
+	fmt.Fprintf(w, "\n; <<>> ddig <<>> %s\n", strings.Join(args, " "))
+	fmt.Fprintln(w, ";; global options: +cmd")
+	fmt.Fprintln(w, ";; Got answer:")
+	fmt.Fprintf(w, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n",
+		opcodeName(msg.OpCode), rcodeName(extendedRCode(msg)), msg.ID)
+
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{msg.Response, "qr"},
+		{msg.Authoritative, "aa"},
+		{msg.Truncated, "tc"},
+		{msg.RecursionDesired, "rd"},
+		{msg.RecursionAvailable, "ra"},
+		{msg.AuthenticData, "ad"},
+		{msg.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+
+	fmt.Fprintf(w, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(msg.Questions), len(msg.Answers), len(msg.Authorities), len(msg.Additionals))
+}
+
+// opt returns the OPT record of msg, or nil.
+func opt(msg *dns.Message) *dns.Resource {
+	for i, res := range msg.Additionals {
+		if _, ok := res.Record.(*dns.OPT); ok {
+			return &msg.Additionals[i]
+		}
+	}
+	return nil
+}
+
+// extendedRCode returns the RCODE of msg with the upper 8 bits from its OPT
+// record, see RFC 6891, section 6.1.3.
+func extendedRCode(msg *dns.Message) dns.RCode {
+	rcode := msg.RCode
+	if res := opt(msg); res != nil {
+		rcode |= dns.RCode(uint32(res.TTL/time.Second)>>24) << 4
+	}
+	return rcode
+}
+
+func printMessage(w io.Writer, msg *dns.Message) {
+	if res := opt(msg); res != nil {
+		printOPT(w, res)
+	}
+
+	if len(msg.Questions) > 0 {
+		fmt.Fprintln(w, "\n;; QUESTION SECTION:")
+		for _, q := range msg.Questions {
+			fmt.Fprintf(w, ";%s\t\t%s\t%s\n", q.Name, className(q.Class), typeName(q.Type))
+		}
+	}
+
+	for _, section := range []struct {
+		name      string
+		resources []dns.Resource
+	}{
+		{"ANSWER", msg.Answers},
+		{"AUTHORITY", msg.Authorities},
+		{"ADDITIONAL", msg.Additionals},
+	} {
+		var lines []string
+		for _, res := range section.resources {
+			if _, ok := res.Record.(*dns.OPT); ok {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s\t%s", res.Name, seconds(res.TTL),
+				className(res.Class), typeName(res.Record.Type()), rdata(res.Record)))
+		}
+
+		if len(lines) > 0 {
+			fmt.Fprintf(w, "\n;; %s SECTION:\n", section.name)
+			fmt.Fprintln(w, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func printOPT(w io.Writer, res *dns.Resource) {
+	ttl := uint32(res.TTL / time.Second)
+
+	var flags string
+	if ttl&0x8000 != 0 {
+		flags = " do"
+	}
+
+	fmt.Fprintln(w, "\n;; OPT PSEUDOSECTION:")
+	fmt.Fprintf(w, "; EDNS: version: %d, flags:%s; udp: %d\n", uint8(ttl>>16), flags, res.Class)
+
+	for _, o := range res.Record.(*dns.OPT).Options {
+		switch o.Code {
+		case edns.OptionCodeNSID:
+			fmt.Fprintf(w, "; NSID: %s (%q)\n", hex.EncodeToString(o.Data), o.Data)
+		case edns.OptionCodeCookie:
+			fmt.Fprintf(w, "; COOKIE: %s\n", hex.EncodeToString(o.Data))
+		case edns.OptionCodeEDNSClientSubnet:
+			fmt.Fprintf(w, "; CLIENT-SUBNET: %s\n", subnetString(o.Data))
+		case edns.OptionCodeExtendedDNSError:
+			if len(o.Data) < 2 {
+				fmt.Fprintf(w, "; EDE: %s\n", hex.EncodeToString(o.Data))
+				continue
+			}
+			fmt.Fprintf(w, "; EDE: %d", binary.BigEndian.Uint16(o.Data))
+			if text := o.Data[2:]; len(text) > 0 {
+				fmt.Fprintf(w, " (%q)", text)
+			}
+			fmt.Fprintln(w)
+		case edns.OptionCodePadding:
+			fmt.Fprintf(w, "; PAD: (%d bytes)\n", len(o.Data))
+		default:
+			fmt.Fprintf(w, "; OPT=%d: %s\n", o.Code, hex.EncodeToString(o.Data))
+		}
+	}
+}
+
+// subnetString returns the EDNS Client Subnet option data b as
+// address/source/scope.
+func subnetString(b []byte) string {
+	if len(b) < 4 {
+		return hex.EncodeToString(b)
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	if binary.BigEndian.Uint16(b) == 1 {
+		ip = make(net.IP, net.IPv4len)
+	}
+	copy(ip, b[4:])
+
+	return fmt.Sprintf("%s/%d/%d", ip, b[2], b[3])
+}
+
+func printFooter(w io.Writer, msg *dns.Message, elapsed time.Duration, server string, addr net.Addr, proto string, when time.Time) {
+	host, port, _ := net.SplitHostPort(addr.String())
+
+	size := 0
+	if b, err := msg.Pack(nil, true); err == nil {
+		size = len(b)
+	}
+
+	fmt.Fprintf(w, "\n;; Query time: %d msec\n", elapsed.Milliseconds())
+	fmt.Fprintf(w, ";; SERVER: %s#%s(%s) (%s)\n", host, port, server, proto)
+	fmt.Fprintf(w, ";; WHEN: %s\n", when.Format("Mon Jan 02 15:04:05 MST 2006"))
+	fmt.Fprintf(w, ";; MSG SIZE  rcvd: %d\n\n", size)
+}
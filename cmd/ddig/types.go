@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/helmutkemper/dns"
+)
+
+// rawTypes are the types without a Record in package dns that ddig prints in
+// the RFC 3597 format, so that responses with them can still be unpacked.
+var rawTypes = map[dns.Type]string{
+	43:  "DS",
+	44:  "SSHFP",
+	46:  "RRSIG",
+	47:  "NSEC",
+	48:  "DNSKEY",
+	50:  "NSEC3",
+	51:  "NSEC3PARAM",
+	52:  "TLSA",
+	59:  "CDS",
+	60:  "CDNSKEY",
+	61:  "OPENPGPKEY",
+	62:  "CSYNC",
+	64:  "SVCB",
+	65:  "HTTPS",
+	99:  "SPF",
+	108: "EUI48",
+	109: "EUI64",
+	256: "URI",
+	13:  "HINFO",
+	14:  "MINFO",
+	17:  "RP",
+	18:  "AFSDB",
+	29:  "LOC",
+	35:  "NAPTR",
+	37:  "CERT",
+	42:  "APL",
+	45:  "IPSECKEY",
+	49:  "DHCID",
+	55:  "HIP",
+}
+
+func init() {
+	for t := range rawTypes {
+		if _, ok := dns.NewRecordByType[t]; ok {
+			continue
+		}
+
+		t := t
+		dns.NewRecordByType[t] = func() dns.Record { return &rawRecord{typ: t} }
+	}
+}
+
+// typeName returns the mnemonic of t, or TYPEn for types without one, see
+// RFC 3597, section 5.
+func typeName(t dns.Type) string {
+	switch t {
+	case dns.TypeAXFR:
+		return "AXFR"
+	case dns.TypeIXFR:
+		return "IXFR"
+	case dns.TypeALL:
+		return "ANY"
+	case dns.TypeANY:
+		return "TYPE0" // not the QTYPE *, see TypeALL
+	}
+	if name := t.String(); name != "" {
+		return strings.TrimPrefix(name, "Type")
+	}
+	if name, ok := rawTypes[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func parseType(s string) (dns.Type, bool) {
+	s = strings.ToUpper(s)
+	if n, ok := strings.CutPrefix(s, "TYPE"); ok {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return dns.Type(v), true
+		}
+	}
+
+	for t := 0; t <= 0xFFFF; t++ {
+		if typeName(dns.Type(t)) == s {
+			return dns.Type(t), true
+		}
+	}
+	return 0, false
+}
+
+var classNames = map[dns.Class]string{
+	dns.ClassIN:  "IN",
+	dns.ClassCH:  "CH",
+	dns.ClassHS:  "HS",
+	dns.ClassANY: "ANY",
+}
+
+// className returns the mnemonic of c, or CLASSn for classes without one.
+func className(c dns.Class) string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+func parseClass(s string) (dns.Class, bool) {
+	s = strings.ToUpper(s)
+	if n, ok := strings.CutPrefix(s, "CLASS"); ok {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return dns.Class(v), true
+		}
+	}
+
+	for c, name := range classNames {
+		if name == s {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+var rcodeNames = map[dns.RCode]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADVERS",
+	23: "BADCOOKIE",
+}
+
+func rcodeName(rcode dns.RCode) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return "RESERVED" + strconv.Itoa(int(rcode))
+}
+
+var opcodeNames = map[dns.OpCode]string{
+	0: "QUERY",
+	1: "IQUERY",
+	2: "STATUS",
+	4: "NOTIFY",
+	5: "UPDATE",
+}
+
+func opcodeName(op dns.OpCode) string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return "RESERVED" + strconv.Itoa(int(op))
+}
+
+// rawRecord is a record of a type unknown to package dns, kept as its RDATA.
+type rawRecord struct {
+	typ  dns.Type
+	Data []byte
+}
+
+func (r *rawRecord) Type() dns.Type { return r.typ }
+
+func (r *rawRecord) Length(_ dns.Compressor) (int, error) { return len(r.Data), nil }
+
+func (r *rawRecord) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	return append(b, r.Data...), nil
+}
+
+func (r *rawRecord) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) {
+	r.Data = append([]byte(nil), b...)
+	return nil, nil
+}
+
+func (r *rawRecord) Get() interface{} { return r.Data }
+
+// Key returns the RDATA in the RFC 3597 format.
+func (r *rawRecord) Key() string {
+	if len(r.Data) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %s`, len(r.Data), strings.ToUpper(hex.EncodeToString(r.Data)))
+}
+
+func (r *rawRecord) Equal(o dns.Record) bool {
+	raw, ok := o.(*rawRecord)
+	return ok && raw.typ == r.typ && bytes.Equal(raw.Data, r.Data)
+}
+
+func (r *rawRecord) String() string {
+	bOut, _ := json.Marshal(r)
+	return string(bOut)
+}
+
+func (r *rawRecord) FromJSon(v string) error {
+	return json.Unmarshal([]byte(v), r)
+}
@@ -0,0 +1,235 @@
+// Command dnsserved is an authoritative DNS server for zone files.
+//
+// Usage:
+//
+//	dnsserved [flags] [origin=]file...
+//
+// Each file is a zone file in the master file format of RFC 1035, section 5,
+// with records of the types known to package dns. The origin of a zone is the
+// one given before "=", or else the first $ORIGIN of its file.
+//
+// The zones are served over UDP and TCP on -addr, and over TLS on -tls-addr
+// with the certificate of -cert and -key. Queries for names outside of the
+// zones are refused. A SIGHUP reloads the zone files; the zones being served
+// are kept if a file fails to load. The certificate is reloaded once its
+// files change, or on a SIGHUP, without closing the TLS connections.
+//
+// The counters of the server and of the zones are published with expvar as
+// "dns" and "zones", and served on -debug-addr at /debug/vars and
+// /debug/dns.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/helmutkemper/dns"
+)
+
+var (
+	addr      = flag.String("addr", ":53", "UDP and TCP `address` to serve on")
+	tlsAddr   = flag.String("tls-addr", "", "TCP `address` to serve DNS over TLS on")
+	certFile  = flag.String("cert", "", "TLS certificate `file`")
+	keyFile   = flag.String("key", "", "TLS key `file`")
+	debugAddr = flag.String("debug-addr", "", "HTTP `address` to serve the counters on")
+	packCache = flag.Int("pack-cache", 1024, "number of packed responses cached per zone")
+	maxUDP    = flag.Int("max-udp-size", 1232, "largest UDP response `size`, for queries advertising as much with EDNS")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [origin=]file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if err := run(logger); err != nil {
+		logger.Error("dnsserved", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	if (*tlsAddr != "") != (*certFile != "" && *keyFile != "") {
+		return errors.New("-tls-addr requires -cert and -key")
+	}
+
+	d := &daemon{
+		packCacheSize: *packCache,
+		maxUDPSize:    *maxUDP,
+		logger:        logger,
+	}
+	for _, arg := range flag.Args() {
+		d.files = append(d.files, parseZoneFile(arg))
+	}
+	if err := d.reload(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		tlsConfig *tls.Config
+		certs     *dns.CertReloader
+	)
+	if *tlsAddr != "" {
+		certs = &dns.CertReloader{CertFile: *certFile, KeyFile: *keyFile, Logger: logger}
+		if err := certs.Load(); err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+
+		go certs.Watch(ctx)
+	}
+
+	ls, err := listen(*addr, *tlsAddr)
+	if err != nil {
+		return err
+	}
+	defer ls.Close()
+
+	srv := d.server(tlsConfig)
+
+	expvar.Publish("dns", srv.Expvar())
+	expvar.Publish("zones", expvar.Func(d.zones.vars))
+	if *debugAddr != "" {
+		go func() {
+			err := http.ListenAndServe(*debugAddr, debugMux(srv))
+			logger.Error("dnsserved debug", "err", err)
+		}()
+	}
+
+	hupc := make(chan os.Signal, 1)
+	signal.Notify(hupc, syscall.SIGHUP)
+	go func() {
+		for range hupc {
+			if err := d.reload(); err != nil {
+				logger.Error("dnsserved reload", "err", err)
+			}
+			if certs != nil {
+				if err := certs.Load(); err != nil {
+					logger.Error("dnsserved reload", "cert", *certFile, "err", err)
+				}
+			}
+		}
+	}()
+
+	errc := d.serve(ctx, srv, ls)
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// daemon serves the zones of its files.
+type daemon struct {
+	files         []zoneFile
+	packCacheSize int
+	maxUDPSize    int
+	logger        *slog.Logger
+
+	zones zoneSet
+}
+
+// reload loads the zone files and serves their zones, or keeps the zones
+// being served if a file fails to load.
+func (d *daemon) reload() error {
+	zones, err := loadZones(d.files, d.packCacheSize)
+	if err != nil {
+		return err
+	}
+	d.zones.swap(zones)
+
+	for _, z := range zones {
+		d.logger.Info("dnsserved zone", "zone", z.Origin, "serial", z.SOA.Serial, "names", z.Len())
+
+		if names := z.Occluded(); len(names) > 0 {
+			d.logger.Warn("dnsserved zone occluded", "zone", z.Origin, "names", names)
+		}
+	}
+	return nil
+}
+
+// server returns the Server of the zones of d.
+func (d *daemon) server(tlsConfig *tls.Config) *dns.Server {
+	return &dns.Server{
+		Handler:    &d.zones,
+		TLSConfig:  tlsConfig,
+		Logger:     d.logger,
+		MaxUDPSize: d.maxUDPSize,
+	}
+}
+
+// listeners are the sockets of a daemon. tls is nil without DNS over TLS.
+type listeners struct {
+	udp      net.PacketConn
+	tcp, tls net.Listener
+}
+
+func listen(addr, tlsAddr string) (*listeners, error) {
+	ls := new(listeners)
+
+	var err error
+	if ls.udp, err = net.ListenPacket("udp", addr); err != nil {
+		return nil, err
+	}
+	if ls.tcp, err = net.Listen("tcp", addr); err != nil {
+		ls.Close()
+		return nil, err
+	}
+	if tlsAddr != "" {
+		if ls.tls, err = net.Listen("tcp", tlsAddr); err != nil {
+			ls.Close()
+			return nil, err
+		}
+	}
+	return ls, nil
+}
+
+func (ls *listeners) Close() error {
+	for _, c := range []interface{ Close() error }{ls.udp, ls.tcp, ls.tls} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// serve serves srv on the listeners, and returns the channel of the first
+// error of the Serve methods.
+func (d *daemon) serve(ctx context.Context, srv *dns.Server, ls *listeners) <-chan error {
+	errc := make(chan error, 3)
+	go func() { errc <- srv.ServePacket(ctx, ls.udp) }()
+	go func() { errc <- srv.Serve(ctx, ls.tcp) }()
+	if ls.tls != nil {
+		go func() { errc <- srv.ServeTLS(ctx, ls.tls) }()
+	}
+	return errc
+}
+
+// debugMux serves the expvar counters at /debug/vars, and the counters of
+// srv at /debug/dns.
+func debugMux(srv *dns.Server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/debug/dns", srv.DebugHandler())
+	return mux
+}
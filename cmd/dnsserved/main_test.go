@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+)
+
+func zoneSource(serial string) string {
+	return "$ORIGIN example.com.\n" +
+		"@ 3600 IN SOA ns hostmaster " + serial + " 2 3 4 5\n" +
+		"www 60 IN A 192.0.2.80\n"
+}
+
+func TestDaemon(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.com.zone")
+	mustWrite(t, path, zoneSource("1"))
+	mustWrite(t, filepath.Join(dir, "sub.zone"), "@ 60 SOA ns hostmaster 7 2 3 4 5\nwww 60 A 192.0.2.81\n")
+
+	d := &daemon{
+		files: []zoneFile{
+			parseZoneFile(path),
+			parseZoneFile("sub.example.com=" + filepath.Join(dir, "sub.zone")),
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	ls, err := listen("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := d.server(nil)
+	d.serve(ctx, srv, ls)
+
+	query := func(addr net.Addr, name string, typ dns.Type) *dns.Message {
+		t.Helper()
+
+		msg, err := new(dns.Client).Do(ctx, &dns.Query{
+			RemoteAddr: addr,
+			Message: &dns.Message{
+				Questions: []dns.Question{{Name: name, Type: typ, Class: dns.ClassIN}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		addr net.Addr
+		name string
+		typ  dns.Type
+
+		rcode  dns.RCode
+		answer string
+	}{
+		{addr: ls.udp.LocalAddr(), name: "www.example.com.", typ: dns.TypeA, answer: "192.0.2.80"},
+		{addr: ls.tcp.Addr(), name: "www.example.com.", typ: dns.TypeA, answer: "192.0.2.80"},
+		{addr: ls.udp.LocalAddr(), name: "WWW.Sub.Example.COM.", typ: dns.TypeA, answer: "192.0.2.81"},
+		{addr: ls.udp.LocalAddr(), name: "none.example.com.", typ: dns.TypeA, rcode: dns.NXDomain},
+		{addr: ls.udp.LocalAddr(), name: "www.example.org.", typ: dns.TypeA, rcode: dns.Refused},
+	}
+
+	for _, test := range tests {
+		msg := query(test.addr, test.name, test.typ)
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %d, got %d", test.name, want, got)
+		}
+		if test.answer == "" {
+			continue
+		}
+		if len(msg.Answers) != 1 {
+			t.Errorf("%s: want 1 answer, got %d", test.name, len(msg.Answers))
+			continue
+		}
+		if want, got := test.answer, msg.Answers[0].Record.Key(); want != got {
+			t.Errorf("%s: want answer %q, got %q", test.name, want, got)
+		}
+	}
+
+	serial := func() int {
+		msg := query(ls.udp.LocalAddr(), "example.com.", dns.TypeSOA)
+		if len(msg.Answers) != 1 {
+			t.Fatalf("want SOA answer, got %d answers", len(msg.Answers))
+		}
+		return msg.Answers[0].Record.(*dns.SOA).Serial
+	}
+
+	mustWrite(t, path, zoneSource("2"))
+	if err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, serial(); want != got {
+		t.Errorf("want serial %d after reload, got %d", want, got)
+	}
+
+	mustWrite(t, path, "bogus")
+	if err := d.reload(); err == nil {
+		t.Error("want reload error")
+	}
+	if want, got := 2, serial(); want != got {
+		t.Errorf("want serial %d after failed reload, got %d", want, got)
+	}
+
+	vars := d.zones.vars().(map[string]zoneVars)
+	if want, got := 2, vars["example.com."].Serial; want != got {
+		t.Errorf("want published serial %d, got %d", want, got)
+	}
+
+	rec := httptest.NewRecorder()
+	debugMux(srv).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dns", nil))
+	if want, got := 200, rec.Code; want != got {
+		t.Errorf("want debug status %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+var (
+	errNoOrigin     = errors.New("no $ORIGIN")
+	errNoOwner      = errors.New("no owner name")
+	errNoTTL        = errors.New("no TTL")
+	errNoSOA        = errors.New("no SOA record")
+	errSOANotFirst  = errors.New("SOA record not at the zone origin or not first")
+	errOutOfZone    = errors.New("name outside the zone")
+	errParen        = errors.New("unbalanced parentheses")
+	errQuote        = errors.New("unterminated quoted string")
+	errIncludeDepth = errors.New("$INCLUDE nested too deeply")
+)
+
+// maxIncludeDepth bounds the nesting of $INCLUDE directives.
+const maxIncludeDepth = 8
+
+// field is a token of a zone file entry.
+type field struct {
+	s      string
+	quoted bool
+}
+
+// entry is a logical line of a zone file, joined across parentheses.
+type entry struct {
+	line   int
+	blank  bool // the line starts with whitespace, the owner is omitted
+	fields []field
+}
+
+// lexZone splits the zone file src into its entries, see RFC 1035, section
+// 5.1. Comments are dropped, and quoted strings are unescaped.
+func lexZone(src string) ([]entry, error) {
+	var (
+		entries []entry
+		cur     entry
+		tok     strings.Builder
+		inTok   bool
+		quoted  bool
+		depth   int
+		line    = 1
+	)
+
+	cur = entry{line: line, blank: len(src) > 0 && (src[0] == ' ' || src[0] == '\t')}
+
+	flush := func() {
+		if inTok {
+			cur.fields = append(cur.fields, field{s: tok.String(), quoted: quoted})
+		}
+		tok.Reset()
+		inTok, quoted = false, false
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+
+		if quoted {
+			switch c {
+			case '"':
+				flush()
+			case '\\':
+				n, r := unescape(src[i+1:])
+				tok.WriteString(r)
+				i += n
+			case '\n':
+				return nil, fmt.Errorf("line %d: %w", line, errQuote)
+			default:
+				tok.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case ';':
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case '"':
+			flush()
+			inTok, quoted = true, true
+		case '(':
+			flush()
+			depth++
+		case ')':
+			flush()
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("line %d: %w", line, errParen)
+			}
+		case ' ', '\t', '\r':
+			flush()
+		case '\n':
+			flush()
+			line++
+			if depth > 0 {
+				continue
+			}
+			if len(cur.fields) > 0 {
+				entries = append(entries, cur)
+			}
+			next := src[i+1:]
+			cur = entry{line: line, blank: len(next) > 0 && (next[0] == ' ' || next[0] == '\t')}
+		case '\\':
+			// escapes outside of quotes are kept for the name parser
+			tok.WriteByte(c)
+			if i+1 < len(src) {
+				i++
+				tok.WriteByte(src[i])
+			}
+			inTok = true
+		default:
+			tok.WriteByte(c)
+			inTok = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("line %d: %w", line, errQuote)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("line %d: %w", line, errParen)
+	}
+	flush()
+	if len(cur.fields) > 0 {
+		entries = append(entries, cur)
+	}
+	return entries, nil
+}
+
+// unescape decodes the escape \X or \DDD at the start of s, after the
+// backslash, and returns the number of bytes read.
+func unescape(s string) (int, string) {
+	if len(s) >= 3 {
+		if n, err := strconv.ParseUint(s[:3], 10, 8); err == nil {
+			return 3, string([]byte{byte(n)})
+		}
+	}
+	if len(s) == 0 {
+		return 0, ""
+	}
+	return 1, s[:1]
+}
+
+// zoneParser builds a Zone from the entries of a zone file and its includes.
+type zoneParser struct {
+	z *dns.Zone
+
+	ttl       time.Duration // $TTL, or zero
+	lastOwner string
+	lastTTL   time.Duration
+
+	records []zoneRecord
+}
+
+type zoneRecord struct {
+	key string
+	ttl time.Duration
+	rr  dns.Record
+}
+
+// loadZone reads the zone file path. The zone origin is the $ORIGIN of the
+// file if origin is empty.
+func loadZone(path, origin string) (*dns.Zone, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseZone(path, string(b), origin)
+}
+
+// parseZone parses the zone file src named file. Included files are relative
+// to the directory of file.
+func parseZone(file, src, origin string) (*dns.Zone, error) {
+	if origin != "" && !strings.HasSuffix(origin, ".") {
+		origin += "."
+	}
+
+	p := &zoneParser{z: &dns.Zone{Origin: origin}}
+	if err := p.parse(file, src, origin, 0); err != nil {
+		return nil, err
+	}
+	return p.zone()
+}
+
+func (p *zoneParser) include(path, origin string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: %w", path, errIncludeDepth)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return p.parse(path, string(b), origin, depth)
+}
+
+func (p *zoneParser) parse(file, src, origin string, depth int) error {
+	entries, err := lexZone(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	for _, e := range entries {
+		if origin, err = p.entry(file, e, origin, depth); err != nil {
+			return fmt.Errorf("%s:%d: %w", file, e.line, err)
+		}
+	}
+	return nil
+}
+
+// entry parses e, and returns the origin of the following entries.
+func (p *zoneParser) entry(file string, e entry, origin string, depth int) (string, error) {
+	fields := e.fields
+
+	switch strings.ToUpper(fields[0].s) {
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return "", errors.New("$ORIGIN wants a name")
+		}
+		name, err := absName(fields[1].s, origin)
+		if err != nil {
+			return "", err
+		}
+		if p.z.Origin == "" {
+			p.z.Origin = name
+		}
+		return name, nil
+	case "$TTL":
+		if len(fields) != 2 {
+			return "", errors.New("$TTL wants a TTL")
+		}
+		ttl, err := parseTTL(fields[1].s)
+		if err != nil {
+			return "", err
+		}
+		p.ttl = ttl
+		return origin, nil
+	case "$INCLUDE":
+		if len(fields) != 2 && len(fields) != 3 {
+			return "", errors.New("$INCLUDE wants a file and an optional origin")
+		}
+		path := fields[1].s
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		incOrigin := origin
+		if len(fields) == 3 {
+			var err error
+			if incOrigin, err = absName(fields[2].s, origin); err != nil {
+				return "", err
+			}
+		}
+		// the origin is restored after the included file, RFC 1035, section 5.1
+		return origin, p.include(path, incOrigin, depth+1)
+	}
+
+	if origin == "" {
+		return "", errNoOrigin
+	}
+
+	owner := p.lastOwner
+	if !e.blank {
+		var err error
+		if owner, err = absName(fields[0].s, origin); err != nil {
+			return "", err
+		}
+		fields = fields[1:]
+	}
+	if owner == "" {
+		return "", errNoOwner
+	}
+	p.lastOwner = owner
+
+	var (
+		ttl    time.Duration
+		hasTTL bool
+	)
+	for i := 0; i < 2 && len(fields) > 0; i++ {
+		if t, err := parseTTL(fields[0].s); err == nil && !hasTTL && !fields[0].quoted {
+			ttl, hasTTL = t, true
+			fields = fields[1:]
+			continue
+		}
+		if c := strings.ToUpper(fields[0].s); c == "IN" {
+			fields = fields[1:]
+			continue
+		} else if c == "CH" || c == "HS" || c == "ANY" || strings.HasPrefix(c, "CLASS") {
+			return "", fmt.Errorf("class %s not supported", fields[0].s)
+		}
+		break
+	}
+	if len(fields) == 0 {
+		return "", errors.New("no record type")
+	}
+
+	switch {
+	case hasTTL:
+	case p.ttl != 0:
+		ttl = p.ttl
+	case p.lastTTL != 0:
+		ttl = p.lastTTL
+	default:
+		return "", errNoTTL
+	}
+	p.lastTTL = ttl
+
+	rr, err := parseRData(strings.ToUpper(fields[0].s), fields[1:], origin)
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := zoneKey(owner, p.z.Origin)
+	if !ok {
+		return "", errOutOfZone
+	}
+
+	if soa, ok := rr.(*dns.SOA); ok {
+		if key != "" || p.z.SOA != nil || len(p.records) > 0 {
+			return "", errSOANotFirst
+		}
+		p.z.SOA, p.z.TTL = soa, ttl
+		return origin, nil
+	}
+	if p.z.SOA == nil {
+		return "", errSOANotFirst
+	}
+
+	p.records = append(p.records, zoneRecord{key: key, ttl: ttl, rr: rr})
+	return origin, nil
+}
+
+// zone returns the parsed zone. Records with a TTL other than the one of the
+// SOA record are stored as an RREntry with their TTL, as by Client.Transfer.
+func (p *zoneParser) zone() (*dns.Zone, error) {
+	if p.z.SOA == nil {
+		return nil, errNoSOA
+	}
+
+	all := make(map[string]map[dns.Type][]dns.Record)
+	for _, r := range p.records {
+		rr := r.rr
+		if r.ttl != p.z.TTL {
+			rr = &dns.RREntry{Record: rr, TTL: r.ttl}
+		}
+
+		if all[r.key] == nil {
+			all[r.key] = make(map[dns.Type][]dns.Record)
+		}
+		all[r.key][r.rr.Type()] = append(all[r.key][r.rr.Type()], rr)
+	}
+
+	p.z.RRs = dns.NewRRSet(all)
+	return p.z, nil
+}
+
+// absName returns the domain name s relative to origin, or s if absolute.
+func absName(s, origin string) (string, error) {
+	switch {
+	case s == "@":
+		if origin == "" {
+			return "", errNoOrigin
+		}
+		return origin, nil
+	case strings.HasSuffix(s, ".") && !strings.HasSuffix(s, `\.`):
+		return s, nil
+	case origin == "":
+		return "", errNoOrigin
+	case origin == ".":
+		return s + ".", nil
+	}
+	return s + "." + origin, nil
+}
+
+// zoneKey returns the RRSet key of the domain name within the zone origin, or
+// false if the name is outside the zone.
+func zoneKey(name, origin string) (string, bool) {
+	name, origin = dns.NormalizeKey(name), dns.NormalizeKey(origin)
+
+	switch {
+	case name == origin:
+		return "", true
+	case origin == "":
+		return name, true
+	case strings.HasSuffix(name, "."+origin):
+		return name[:len(name)-len(origin)-1], true
+	}
+	return "", false
+}
+
+// parseTTL returns the TTL s, in seconds or with the units of BIND, such as
+// "1h30m".
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, errors.New("invalid TTL")
+	}
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	var (
+		ttl time.Duration
+		n   uint64
+		num bool
+	)
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			n, num = n*10+uint64(c-'0'), true
+			continue
+		}
+		if !num {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+
+		var unit time.Duration
+		switch c {
+		case 's':
+			unit = time.Second
+		case 'm':
+			unit = time.Minute
+		case 'h':
+			unit = time.Hour
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		default:
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		ttl += time.Duration(n) * unit
+		n, num = 0, false
+	}
+	if num {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return ttl, nil
+}
+
+// parseRData returns the record of type typ with the RDATA fields.
+func parseRData(typ string, fields []field, origin string) (dns.Record, error) {
+	want := func(n int) error {
+		if len(fields) != n {
+			return fmt.Errorf("%s wants %d fields, got %d", typ, n, len(fields))
+		}
+		return nil
+	}
+	name := func(i int) (string, error) { return absName(fields[i].s, origin) }
+	number := func(i, bits int) (int, error) {
+		n, err := strconv.ParseUint(fields[i].s, 10, bits)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid number %q", typ, fields[i].s)
+		}
+		return int(n), nil
+	}
+
+	switch typ {
+	case "A":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0].s).To4()
+		if ip == nil || strings.Contains(fields[0].s, ":") {
+			return nil, fmt.Errorf("invalid IPv4 address %q", fields[0].s)
+		}
+		return &dns.A{A: ip}, nil
+	case "AAAA":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0].s)
+		if ip == nil || !strings.Contains(fields[0].s, ":") {
+			return nil, fmt.Errorf("invalid IPv6 address %q", fields[0].s)
+		}
+		return &dns.AAAA{AAAA: ip.To16()}, nil
+	case "NS", "CNAME", "PTR", "DNAME":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		n, err := name(0)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "NS":
+			return &dns.NS{NS: n}, nil
+		case "CNAME":
+			return &dns.CNAME{CNAME: n}, nil
+		case "PTR":
+			return &dns.PTR{PTR: n}, nil
+		}
+		return &dns.DNAME{DNAME: n}, nil
+	case "MX":
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		pref, err := number(0, 16)
+		if err != nil {
+			return nil, err
+		}
+		mx, err := name(1)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.MX{Pref: pref, MX: mx}, nil
+	case "SRV":
+		if err := want(4); err != nil {
+			return nil, err
+		}
+		var v [3]int
+		for i := range v {
+			n, err := number(i, 16)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = n
+		}
+		target, err := name(3)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.SRV{Priority: v[0], Weight: v[1], Port: v[2], Target: target}, nil
+	case "TXT":
+		if len(fields) == 0 {
+			return nil, errors.New("TXT wants a string")
+		}
+		txt := make([]string, len(fields))
+		for i, f := range fields {
+			txt[i] = f.s
+		}
+		return &dns.TXT{TXT: txt}, nil
+	case "SOA":
+		if err := want(7); err != nil {
+			return nil, err
+		}
+		ns, err := name(0)
+		if err != nil {
+			return nil, err
+		}
+		mbox, err := name(1)
+		if err != nil {
+			return nil, err
+		}
+		serial, err := number(2, 32)
+		if err != nil {
+			return nil, err
+		}
+		var timers [4]time.Duration
+		for i := range timers {
+			if timers[i], err = parseTTL(fields[3+i].s); err != nil {
+				return nil, err
+			}
+		}
+		return &dns.SOA{
+			NS:      ns,
+			MBox:    mbox,
+			Serial:  serial,
+			Refresh: timers[0],
+			Retry:   timers[1],
+			Expire:  timers[2],
+			MinTTL:  timers[3],
+		}, nil
+	case "CAA":
+		if err := want(3); err != nil {
+			return nil, err
+		}
+		flags, err := number(0, 8)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.CAA{Flags: uint8(flags), Tag: fields[1].s, Value: fields[2].s}, nil
+	}
+	return nil, fmt.Errorf("record type %s not supported", typ)
+}
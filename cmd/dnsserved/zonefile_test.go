@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+)
+
+const exampleZone = `
+$ORIGIN example.com.
+$TTL 1h
+@	IN	SOA	ns1 hostmaster (
+		2024010101 ; serial
+		2h 30m 2w 5m )
+	IN	NS	ns1
+	IN	MX	10 mail
+ns1	IN	A	192.0.2.53
+www	300	IN	A	192.0.2.80
+	IN	300	AAAA	2001:db8::80
+ftp		CNAME	www.example.com.
+txt		TXT	"v=spf1 -all" "a \"quoted\" \059"
+_sip._udp	SRV	10 20 5060 sip
+@	CAA	0 issue "letsencrypt.org"
+`
+
+func TestParseZone(t *testing.T) {
+	t.Parallel()
+
+	z, err := parseZone("example.com.zone", exampleZone, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "example.com.", z.Origin; want != got {
+		t.Errorf("want origin %q, got %q", want, got)
+	}
+	if want, got := time.Hour, z.TTL; want != got {
+		t.Errorf("want TTL %v, got %v", want, got)
+	}
+
+	wantSOA := &dns.SOA{
+		NS:      "ns1.example.com.",
+		MBox:    "hostmaster.example.com.",
+		Serial:  2024010101,
+		Refresh: 2 * time.Hour,
+		Retry:   30 * time.Minute,
+		Expire:  14 * 24 * time.Hour,
+		MinTTL:  5 * time.Minute,
+	}
+	if !reflect.DeepEqual(wantSOA, z.SOA) {
+		t.Errorf("want SOA %+v, got %+v", wantSOA, z.SOA)
+	}
+
+	tests := []struct {
+		name string
+		typ  dns.Type
+
+		key string
+		ttl time.Duration
+	}{
+		{name: "", typ: dns.TypeNS, key: "ns1.example.com."},
+		{name: "", typ: dns.TypeMX, key: "10 mail.example.com."},
+		{name: "ns1", typ: dns.TypeA, key: "192.0.2.53"},
+		{name: "www", typ: dns.TypeA, key: "192.0.2.80", ttl: 300 * time.Second},
+		{name: "www", typ: dns.TypeAAAA, key: "2001:db8::80", ttl: 300 * time.Second},
+		{name: "ftp", typ: dns.TypeCNAME, key: "www.example.com."},
+		{name: "_sip._udp", typ: dns.TypeSRV},
+		{name: "", typ: dns.TypeCAA, key: `0 issue "letsencrypt.org"`},
+	}
+
+	for _, test := range tests {
+		rrs := z.GetRecords(test.name, test.typ)
+		if len(rrs) != 1 {
+			t.Errorf("%q %d: want 1 record, got %d", test.name, test.typ, len(rrs))
+			continue
+		}
+
+		var ttl time.Duration
+		rr := rrs[0]
+		if e, ok := rr.(*dns.RREntry); ok {
+			rr, ttl = e.Record, e.TTL
+		}
+
+		if test.key != "" {
+			if want, got := test.key, rr.Key(); want != got {
+				t.Errorf("%q %d: want record %q, got %q", test.name, test.typ, want, got)
+			}
+		}
+		if want, got := test.ttl, ttl; want != got {
+			t.Errorf("%q %d: want TTL %v, got %v", test.name, test.typ, want, got)
+		}
+	}
+
+	txt := z.GetRecords("txt", dns.TypeTXT)
+	if len(txt) != 1 {
+		t.Fatalf("want 1 TXT record, got %d", len(txt))
+	}
+	if want, got := []string{"v=spf1 -all", `a "quoted" ;`}, txt[0].(*dns.TXT).TXT; !reflect.DeepEqual(want, got) {
+		t.Errorf("want TXT %q, got %q", want, got)
+	}
+}
+
+func TestLoadZoneInclude(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	mustWrite(t, filepath.Join(dir, "hosts"), "app 60 A 192.0.2.1\n")
+	mustWrite(t, filepath.Join(dir, "sub"), "@ 60 A 192.0.2.2\n")
+	mustWrite(t, filepath.Join(dir, "zone"), strings.Join([]string{
+		"@ 3600 SOA ns hostmaster 1 2 3 4 5",
+		"$INCLUDE hosts",
+		"$INCLUDE sub sub",
+		"after A 192.0.2.3",
+	}, "\n"))
+
+	z, err := loadZone(filepath.Join(dir, "zone"), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app", "sub", "after"} {
+		if len(z.GetRecords(name, dns.TypeA)) != 1 {
+			t.Errorf("want A record of %q", name)
+		}
+	}
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	t.Parallel()
+
+	const soa = "@ 3600 IN SOA ns hostmaster 1 2 3 4 5\n"
+
+	tests := []struct {
+		name string
+
+		src string
+		err string
+	}{
+		{name: "no origin", src: "@ 3600 IN SOA ns hostmaster 1 2 3 4 5", err: errNoOrigin.Error()},
+		{name: "no SOA", src: "$ORIGIN a.\nwww 60 A 192.0.2.1", err: errSOANotFirst.Error()},
+		{name: "empty", src: "$ORIGIN a.\n", err: errNoSOA.Error()},
+		{name: "no TTL", src: "$ORIGIN a.\n@ IN SOA ns hostmaster 1 2 3 4 5", err: errNoTTL.Error()},
+		{name: "out of zone", src: "$ORIGIN a.\n" + soa + "www.b. A 192.0.2.1", err: errOutOfZone.Error()},
+		{name: "paren", src: "$ORIGIN a.\n" + soa + "www A ( 192.0.2.1", err: errParen.Error()},
+		{name: "quote", src: "$ORIGIN a.\n" + soa + "www TXT \"abc\n", err: errQuote.Error()},
+		{name: "class", src: "$ORIGIN a.\n" + soa + "www CH A 192.0.2.1", err: "class CH"},
+		{name: "type", src: "$ORIGIN a.\n" + soa + "www HINFO a b", err: "type HINFO"},
+		{name: "address", src: "$ORIGIN a.\n" + soa + "www A 2001:db8::1", err: "IPv4"},
+		{name: "fields", src: "$ORIGIN a.\n" + soa + "www MX mail", err: "MX wants 2 fields"},
+		{name: "line", src: "$ORIGIN a.\n" + soa + "\nwww MX x mail", err: "zone:4:"},
+	}
+
+	for _, test := range tests {
+		_, err := parseZone("zone", test.src, "")
+		if err == nil {
+			t.Errorf("%s: want error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: want error %q, got %q", test.name, test.err, err)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]time.Duration{
+		"0":      0,
+		"3600":   time.Hour,
+		"1h30m":  90 * time.Minute,
+		"1W":     7 * 24 * time.Hour,
+		"2d12h":  60 * time.Hour,
+		"10s":    10 * time.Second,
+		"1h1m1s": time.Hour + time.Minute + time.Second,
+	}
+	for s, want := range tests {
+		got, err := parseTTL(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if want != got {
+			t.Errorf("%q: want %v, got %v", s, want, got)
+		}
+	}
+
+	for _, s := range []string{"", "h", "1x", "1h2", "-1"} {
+		if _, err := parseTTL(s); err == nil {
+			t.Errorf("%q: want error", s)
+		}
+	}
+}
+
+func mustWrite(t *testing.T, path, data string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/helmutkemper/dns"
+)
+
+// zoneFile is a zone file argument, [origin=]path.
+type zoneFile struct {
+	origin string // the $ORIGIN of the file if empty
+	path   string
+}
+
+func parseZoneFile(arg string) zoneFile {
+	if origin, path, ok := strings.Cut(arg, "="); ok {
+		return zoneFile{origin: origin, path: path}
+	}
+	return zoneFile{path: arg}
+}
+
+// loadZones reads the zone files, and returns their zones by normalized
+// origin. Each zone keeps a pack cache of packCacheSize responses, and adds
+// the NS records and glue addresses of the zone to its answers.
+func loadZones(files []zoneFile, packCacheSize int) (map[string]*dns.Zone, error) {
+	zones := make(map[string]*dns.Zone, len(files))
+	for _, f := range files {
+		z, err := loadZone(f.path, f.origin)
+		if err != nil {
+			return nil, err
+		}
+		z.PackCacheSize = packCacheSize
+		z.FullResponses = true
+
+		k := dns.NormalizeKey(z.Origin)
+		if _, ok := zones[k]; ok {
+			return nil, fmt.Errorf("%s: zone %s loaded twice", f.path, z.Origin)
+		}
+		zones[k] = z
+	}
+	return zones, nil
+}
+
+// zoneSet serves the queries for the names of its zones from the zone of the
+// closest origin, and refuses the other queries.
+type zoneSet struct {
+	mu    sync.RWMutex
+	zones map[string]*dns.Zone // by normalized origin
+}
+
+func (s *zoneSet) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	if len(r.Questions) == 0 {
+		w.Status(dns.FormErr)
+		return
+	}
+
+	z := s.lookup(r.Questions[0].Name)
+	if z == nil {
+		w.Status(dns.Refused)
+		return
+	}
+	z.ServeDNS(ctx, w, r)
+}
+
+// lookup returns the zone of the closest origin of name, or nil.
+func (s *zoneSet) lookup(name string) *dns.Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	k := dns.NormalizeKey(name)
+	for {
+		if z, ok := s.zones[k]; ok {
+			return z
+		}
+		if k == "" {
+			return nil
+		}
+
+		if i := strings.IndexByte(k, '.'); i >= 0 {
+			k = k[i+1:]
+		} else {
+			k = ""
+		}
+	}
+}
+
+// swap replaces the zones of s, and closes the previous zones.
+func (s *zoneSet) swap(zones map[string]*dns.Zone) {
+	s.mu.Lock()
+	old := s.zones
+	s.zones = zones
+	s.mu.Unlock()
+
+	for _, z := range old {
+		z.Close()
+	}
+}
+
+// zoneVars are the counters of a zone published by expvar.
+type zoneVars struct {
+	Serial  int                `json:"serial"`
+	Names   int                `json:"names"`
+	Records map[string]int     `json:"records"`
+	Cache   dns.PackCacheStats `json:"pack_cache"`
+}
+
+// vars returns the counters of the zones by origin.
+func (s *zoneSet) vars() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vars := make(map[string]zoneVars, len(s.zones))
+	for _, z := range s.zones {
+		stats := z.Stats()
+
+		records := make(map[string]int, len(stats.Records))
+		for t, n := range stats.Records {
+			records[typeName(t)] = n
+		}
+
+		vars[z.Origin] = zoneVars{
+			Serial:  z.SOA.Serial,
+			Names:   stats.Names,
+			Records: records,
+			Cache:   z.PackCacheStats(),
+		}
+	}
+	return vars
+}
+
+func typeName(t dns.Type) string {
+	if s := t.String(); s != "" {
+		return strings.TrimPrefix(s, "Type")
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
@@ -2,8 +2,13 @@ package dns
 
 import (
 	"strings"
+	"sync/atomic"
 )
 
+// maxPointerOffset is the largest offset of a compression pointer, see RFC
+// 1035, section 4.1.4.
+const maxPointerOffset = 0x3FFF
+
 // Compressor encodes domain names.
 type Compressor interface {
 	Length(...string) (int, error)
@@ -15,9 +20,60 @@ type Decompressor interface {
 	Unpack([]byte) (string, []byte, error)
 }
 
+// CompressionWriter is implemented by the MessageWriters of a Server, and of
+// the handlers of a ResolveMux, to control the name compression of a
+// response. Some broken clients fail to parse compressed names.
+type CompressionWriter interface {
+	// Compress enables or disables the name compression of the response.
+	Compress(bool)
+}
+
+// CompressionStats is a snapshot of the name compression counters of a Server.
+type CompressionStats struct {
+	Compressed   uint64 // responses packed with name compression
+	Uncompressed uint64 // responses packed without name compression
+
+	Bytes uint64 // size of the packed responses
+	Saved uint64 // bytes saved by name compression
+}
+
+// compressionMode is the name compression setting of a response.
+type compressionMode uint8
+
+const (
+	compressionDefault compressionMode = iota // compressed, unless disabled by the Server
+	compressionOn
+	compressionOff
+)
+
+type compressionCounters struct {
+	compressed, uncompressed, bytes, saved uint64
+}
+
+func (c *compressionCounters) add(n, saved int, compressed bool) {
+	if compressed {
+		atomic.AddUint64(&c.compressed, 1)
+	} else {
+		atomic.AddUint64(&c.uncompressed, 1)
+	}
+	atomic.AddUint64(&c.bytes, uint64(n))
+	atomic.AddUint64(&c.saved, uint64(saved))
+}
+
+func (c *compressionCounters) stats() CompressionStats {
+	return CompressionStats{
+		Compressed:   atomic.LoadUint64(&c.compressed),
+		Uncompressed: atomic.LoadUint64(&c.uncompressed),
+		Bytes:        atomic.LoadUint64(&c.bytes),
+		Saved:        atomic.LoadUint64(&c.saved),
+	}
+}
+
 type compressor struct {
 	tbl    map[string]int
 	offset int
+
+	saved *int // if not nil, incremented by the bytes saved by pointers
 }
 
 func (c compressor) Length(names ...string) (int, error) {
@@ -75,6 +131,9 @@ func (c compressor) Pack(b []byte, fqdn string) ([]byte, error) {
 			if err != nil {
 				return nil, err
 			}
+			if c.saved != nil {
+				*c.saved += len(fqdn) + 1 - len(ptr)
+			}
 
 			return append(b, ptr...), nil
 		}
@@ -90,11 +149,9 @@ func (c compressor) Pack(b []byte, fqdn string) ([]byte, error) {
 		return nil, errSegTooLong
 	}
 
-	if c.tbl != nil {
-		idx := len(b) - c.offset
-		if int(uint16(idx)) != idx {
-			return nil, errInvalidPtr
-		}
+	// a pointer holds an offset of 14 bits, so the names packed past it
+	// are not pointed to
+	if idx := len(b) - c.offset; c.tbl != nil && idx <= maxPointerOffset {
 		c.tbl[fqdn] = idx
 	}
 
@@ -178,11 +235,10 @@ func (d decompressor) deref(name []byte, ptr uint16, visited []int) ([]byte, err
 func isPointer(b byte) bool { return b&0xC0 > 0 }
 
 func pointerTo(idx int) ([]byte, error) {
-	ptr := uint16(idx)
-	if int(ptr) != idx {
+	if idx < 0 || idx > maxPointerOffset {
 		return nil, errInvalidPtr
 	}
-	ptr |= 0xC000
+	ptr := uint16(idx) | 0xC000
 
 	buf := [2]byte{}
 	nbo.PutUint16(buf[:], ptr)
@@ -2,7 +2,10 @@ package dns
 
 import (
 	"bytes"
+	"context"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestCompressor(t *testing.T) {
@@ -63,7 +66,7 @@ func TestCompressor(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			com := compressor{test.state, 0}
+			com := compressor{tbl: test.state}
 
 			length, err := com.Length(test.fqdn)
 			if err != nil {
@@ -160,3 +163,120 @@ func TestDecompressor(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCompression(t *testing.T) {
+	t.Parallel()
+
+	answer := func(w MessageWriter, r *Query) {
+		for i := 0; i < 3; i++ {
+			w.Answer(r.Questions[0].Name, time.Minute, &CNAME{CNAME: "target." + r.Questions[0].Name})
+		}
+		w.Answer(r.Questions[0].Name, time.Minute, &DNAME{DNAME: "target." + r.Questions[0].Name})
+	}
+
+	tests := []struct {
+		name string
+
+		disable  bool
+		compress func(CompressionWriter)
+
+		compressed bool
+	}{
+		{name: "default", compressed: true},
+		{name: "disabled", disable: true},
+		{name: "disabled by handler", compress: func(w CompressionWriter) { w.Compress(false) }},
+		{
+			name:       "enabled by handler",
+			disable:    true,
+			compress:   func(w CompressionWriter) { w.Compress(true) },
+			compressed: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr:               mustUnusedAddr(),
+				DisableCompression: test.disable,
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					if test.compress != nil {
+						cw, ok := w.(CompressionWriter)
+						if !ok {
+							t.Fatal("want a CompressionWriter")
+						}
+						test.compress(cw)
+					}
+					answer(w, r)
+				}),
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			query := &Message{
+				Questions: []Question{
+					{Name: "app.compress.dev.", Type: TypeCNAME, Class: ClassIN},
+				},
+			}
+			msg, err := new(Client).Do(context.Background(), &Query{
+				RemoteAddr: addr,
+				Message:    query,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := 4, len(msg.Answers); want != got {
+				t.Fatalf("want %d answers, got %d", want, got)
+			}
+
+			res := response(query)
+			answer(&clientWriter{messageWriter: &messageWriter{msg: res}}, &Query{Message: query})
+			b, saved, err := res.pack(nil, test.compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats := srv.CompressionStats()
+			if want, got := uint64(len(b)), stats.Bytes; want != got {
+				t.Errorf("want %d response bytes, got %d", want, got)
+			}
+			if want, got := uint64(saved), stats.Saved; want != got {
+				t.Errorf("want %d saved bytes, got %d", want, got)
+			}
+			if test.compressed && (stats.Compressed != 1 || stats.Saved == 0) {
+				t.Errorf("want a compressed response, got %+v", stats)
+			}
+			if !test.compressed && (stats.Uncompressed != 1 || stats.Saved != 0) {
+				t.Errorf("want an uncompressed response, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestDNAMENotCompressed(t *testing.T) {
+	t.Parallel()
+
+	msg := &Message{
+		Answers: []Resource{
+			{Name: "example.com.", Class: ClassIN, Record: &CNAME{CNAME: "example.com."}},
+			{Name: "example.com.", Class: ClassIN, Record: &DNAME{DNAME: "example.com."}},
+		},
+	}
+
+	b, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}
+	if !bytes.HasSuffix(b, name) {
+		t.Errorf("want uncompressed DNAME target, got %x", b)
+	}
+}
@@ -3,6 +3,8 @@ package dns
 import (
 	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 // Conn is a network connection to a DNS resolver.
@@ -23,12 +25,20 @@ type PacketConn struct {
 	net.Conn
 
 	rbuf, wbuf []byte
+
+	payload int // EDNS payload size of the last message sent
+	maxSize int // largest message sent, if larger than 512 bytes
 }
 
-// Recv reads a DNS message from the underlying connection.
+// Recv reads a DNS message from the underlying connection. Messages up to
+// the EDNS UDP payload size of the last message sent are read.
 func (c *PacketConn) Recv(msg *Message) error {
-	if len(c.rbuf) != maxPacketLen {
-		c.rbuf = make([]byte, maxPacketLen)
+	n := c.payload
+	if n < maxPacketLen {
+		n = maxPacketLen
+	}
+	if len(c.rbuf) != n {
+		c.rbuf = make([]byte, n)
 	}
 
 	n, err := c.Read(c.rbuf)
@@ -40,10 +50,12 @@ func (c *PacketConn) Recv(msg *Message) error {
 	return err
 }
 
-// Send writes a DNS message to the underlying connection.
+// Send writes a DNS message to the underlying connection. Messages larger
+// than 512 bytes fail with ErrOversizedMessage, unless the Transport dialing
+// the connection has a larger MaxUDPSize.
 func (c *PacketConn) Send(msg *Message) error {
-	if len(c.wbuf) != maxPacketLen {
-		c.wbuf = make([]byte, maxPacketLen)
+	if c.wbuf == nil {
+		c.wbuf = make([]byte, 0, maxPacketLen)
 	}
 
 	var err error
@@ -51,14 +63,27 @@ func (c *PacketConn) Send(msg *Message) error {
 		return err
 	}
 
-	if len(c.wbuf) > maxPacketLen {
+	if len(c.wbuf) > maxPacketLen && len(c.wbuf) > c.maxSize {
 		return ErrOversizedMessage
 	}
 
+	c.payload = udpPayloadSize(msg)
+
 	_, err = c.Write(c.wbuf)
 	return err
 }
 
+// udpPayloadSize returns the UDP payload size advertised by the EDNS OPT
+// record of msg, or 512 bytes without a larger one.
+func udpPayloadSize(msg *Message) int {
+	for _, res := range msg.Additionals {
+		if _, ok := res.Record.(*OPT); ok && int(res.Class) > maxPacketLen {
+			return int(res.Class)
+		}
+	}
+	return maxPacketLen
+}
+
 // StreamConn is a stream-oriented network connection to a DNS resolver that
 // expects transmitted messages to adhere to RFC 1035 Section 4.2.2. "TCP
 // usage".
@@ -91,23 +116,68 @@ func (c *StreamConn) Recv(msg *Message) error {
 	return err
 }
 
-// Send writes a DNS message to the underlying connection.
+// Send writes a DNS message to the underlying connection. Messages up to
+// 65535 bytes are written, the buffer growing to the largest one.
 func (c *StreamConn) Send(msg *Message) error {
-	if len(c.wbuf) < 2 {
-		c.wbuf = make([]byte, 1024)
+	if c.wbuf == nil {
+		c.wbuf = make([]byte, 0, 1024)
 	}
 
-	b, err := msg.Pack(c.wbuf[2:2], true)
+	b, err := msg.Pack(c.wbuf[:2], true)
 	if err != nil {
 		return err
 	}
+	c.wbuf = b[:0]
 
-	mlen := uint16(len(b))
-	if int(mlen) != len(b) {
+	mlen := uint16(len(b) - 2)
+	if int(mlen) != len(b)-2 {
 		return ErrOversizedMessage
 	}
-	nbo.PutUint16(c.wbuf[:2], mlen)
+	nbo.PutUint16(b[:2], mlen)
 
-	_, err = c.Write(c.wbuf[:len(b)+2])
+	_, err = c.Write(b)
 	return err
 }
+
+// aLongTimeAgo is a deadline in the past, set to abort the pending reads and
+// writes of a connection.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// cancelConn is a Conn whose pending and future reads and writes are aborted
+// once cancel is called.
+type cancelConn struct {
+	Conn
+
+	mu       sync.Mutex
+	canceled bool
+}
+
+func (c *cancelConn) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.canceled = true
+	c.Conn.SetDeadline(aLongTimeAgo)
+}
+
+func (c *cancelConn) SetDeadline(t time.Time) error {
+	return c.setDeadline(t, c.Conn.SetDeadline)
+}
+
+func (c *cancelConn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(t, c.Conn.SetReadDeadline)
+}
+
+func (c *cancelConn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(t, c.Conn.SetWriteDeadline)
+}
+
+func (c *cancelConn) setDeadline(t time.Time, set func(time.Time) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.canceled {
+		t = aLongTimeAgo
+	}
+	return set(t)
+}
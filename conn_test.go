@@ -135,6 +135,12 @@ func TestStreamConnRoundTrip(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "jumbo-messages",
+
+			req: jumboMessage(100),
+			res: jumboMessage(2000),
+		},
 	}
 
 	t.Parallel()
@@ -206,3 +212,22 @@ func testRoundTrip(client, server Conn, req, res *Message) error {
 
 	return g.Wait()
 }
+
+// jumboMessage returns a message of n answers with distinct names, larger
+// than the range of the compression pointers for a few thousand answers.
+func jumboMessage(n int) *Message {
+	msg := &Message{
+		Questions: []Question{
+			{Name: "example.com.", Type: TypeA, Class: ClassIN},
+		},
+	}
+	for i := 0; i < n; i++ {
+		msg.Answers = append(msg.Answers, Resource{
+			Name:   fmt.Sprintf("host%d.example.com.", i),
+			Class:  ClassIN,
+			TTL:    60 * time.Second,
+			Record: &A{A: net.IPv4(10, 0, byte(i>>8), byte(i)).To4()},
+		})
+	}
+	return msg
+}
@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/helmutkemper/dns/edns"
+)
+
+// badCookie is the extended RCODE of the responses to the queries without a
+// valid server cookie, see RFC 7873, section 8.
+const badCookie RCode = 23
+
+// The server cookies of a Server are those of RFC 9018, section 4: a version,
+// a timestamp and a hash of the client cookie, of both and of the client
+// address, valid for an hour.
+const (
+	clientCookieLen = 8
+	serverCookieLen = 16
+
+	cookieVersion  = 1
+	cookieLifetime = time.Hour
+	cookieSkew     = 5 * time.Minute
+)
+
+// requireCookie applies the RequireCookies policy of a listener to the UDP
+// query r. It reports whether the query was answered for its missing or
+// invalid cookie, and so is not to be served.
+func (s *Server) requireCookie(ctx context.Context, w *serverWriter, r *Query) bool {
+	var (
+		cookie []byte
+		found  bool
+		ip     = addrIP(r.RemoteAddr)
+		now    = time.Now()
+	)
+	for _, res := range r.Additionals {
+		if opt, ok := res.Record.(*OPT); ok {
+			for _, o := range opt.Options {
+				if o.Code == edns.OptionCodeCookie {
+					cookie, found = o.Data, true
+				}
+			}
+		}
+	}
+
+	var bad bool
+	switch n := len(cookie); {
+	case !found:
+		w.truncated(true)
+	case n != clientCookieLen && (n < clientCookieLen+8 || n > clientCookieLen+32):
+		w.Status(FormErr)
+	case s.validCookie(cookie, ip, now):
+		w.setOption(s.cookie(cookie[:clientCookieLen], ip, now))
+		return false
+	default:
+		bad = true
+	}
+	atomic.AddUint64(&s.queryc.cookies, 1)
+
+	if bad {
+		w.setOption(s.cookie(cookie[:clientCookieLen], ip, now))
+		w.extendedRCode(badCookie)
+	}
+	if err := w.Reply(ctx); err != nil {
+		s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+	}
+	return true
+}
+
+// cookie returns the COOKIE option of the response to the client of address
+// ip, its client cookie and a new server cookie.
+func (s *Server) cookie(client []byte, ip net.IP, now time.Time) edns.Option {
+	data := make([]byte, clientCookieLen, clientCookieLen+serverCookieLen)
+	copy(data, client)
+	return edns.Option{
+		Code: edns.OptionCodeCookie,
+		Data: append(data, s.serverCookie(client, ip, uint32(now.Unix()))...),
+	}
+}
+
+// serverCookie returns the server cookie of the client cookie of the client of
+// address ip at the timestamp ts.
+func (s *Server) serverCookie(client []byte, ip net.IP, ts uint32) []byte {
+	b := make([]byte, serverCookieLen)
+	b[0] = cookieVersion
+	nbo.PutUint32(b[4:8], ts)
+
+	h := hmac.New(sha256.New, s.cookieSecret())
+	h.Write(client)
+	h.Write(b[:8])
+	if ip4 := ip.To4(); ip4 != nil {
+		h.Write(ip4)
+	} else {
+		h.Write(ip.To16())
+	}
+	copy(b[8:], h.Sum(nil))
+	return b
+}
+
+// validCookie reports whether cookie holds a server cookie of the server for
+// the client of address ip, not expired.
+func (s *Server) validCookie(cookie []byte, ip net.IP, now time.Time) bool {
+	if len(cookie) != clientCookieLen+serverCookieLen || cookie[clientCookieLen] != cookieVersion {
+		return false
+	}
+
+	sc := cookie[clientCookieLen:]
+	ts := time.Unix(int64(nbo.Uint32(sc[4:8])), 0)
+	if now.Sub(ts) > cookieLifetime || ts.Sub(now) > cookieSkew {
+		return false
+	}
+	return hmac.Equal(sc, s.serverCookie(cookie[:clientCookieLen], ip, nbo.Uint32(sc[4:8])))
+}
+
+// cookieSecret returns the CookieSecret of the server, or a random secret
+// generated once if nil.
+func (s *Server) cookieSecret() []byte {
+	if s.CookieSecret != nil {
+		return s.CookieSecret
+	}
+
+	s.cookieo.Do(func() {
+		s.cookieKey = make([]byte, 32)
+		if _, err := rand.Read(s.cookieKey); err != nil {
+			panic(err)
+		}
+	})
+	return s.cookieKey
+}
+
+func (w *serverWriter) setOption(opt edns.Option) {
+	if ow, ok := w.MessageWriter.(interface{ setOption(edns.Option) }); ok {
+		ow.setOption(opt)
+	}
+}
+
+// extendedRCode sets the RCODE of the response to rcode, its upper bits in the
+// OPT record, see RFC 6891, section 6.1.3.
+func (w *serverWriter) extendedRCode(rcode RCode) {
+	if ew, ok := w.MessageWriter.(interface{ extendedRCode(RCode) }); ok {
+		ew.extendedRCode(rcode)
+	}
+}
+
+func (w *messageWriter) extendedRCode(rcode RCode) {
+	w.msg.RCode = rcode & 0xF
+
+	for i, res := range w.msg.Additionals {
+		if res.Record.Type() == TypeOPT {
+			ttl := uint32(res.TTL/time.Second)&0x00FFFFFF | uint32(rcode>>4)<<24
+
+			ress := append([]Resource(nil), w.msg.Additionals...)
+			ress[i].TTL = time.Duration(ttl) * time.Second
+			w.msg.Additionals = ress
+			return
+		}
+	}
+}
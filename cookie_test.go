@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/edns"
+)
+
+func TestServerRequireCookies(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		Listeners: []ListenerPolicy{{Network: "udp", RequireCookies: true}},
+	}
+	mustStart(srv)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(addr net.Addr, cookie []byte) *Message {
+		t.Helper()
+
+		msg := &Message{Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}}}
+		if cookie != nil {
+			msg.Additionals = []Resource{{
+				Name:   ".",
+				Class:  4096,
+				Record: &OPT{Options: []edns.Option{{Code: edns.OptionCodeCookie, Data: cookie}}},
+			}}
+		}
+
+		res, err := new(Client).Do(context.Background(), &Query{RemoteAddr: addr, Message: msg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	responseCookie := func(msg *Message) ([]byte, RCode) {
+		t.Helper()
+
+		for _, res := range msg.Additionals {
+			if opt, ok := res.Record.(*OPT); ok {
+				rcode := msg.RCode | RCode(uint32(res.TTL/time.Second)>>24)<<4
+				for _, o := range opt.Options {
+					if o.Code == edns.OptionCodeCookie {
+						return o.Data, rcode
+					}
+				}
+				return nil, rcode
+			}
+		}
+		return nil, msg.RCode
+	}
+
+	if msg := query(udpAddr, nil); !msg.Truncated || len(msg.Answers) > 0 {
+		t.Errorf("want truncated response without a cookie, got %+v", msg)
+	}
+	if msg := query(tcpAddr, nil); len(msg.Answers) != 1 {
+		t.Errorf("want TCP query served without a cookie, got %+v", msg)
+	}
+	if msg := query(udpAddr, make([]byte, 10)); msg.RCode != FormErr {
+		t.Errorf("want %v for a malformed cookie, got %v", FormErr, msg.RCode)
+	}
+
+	client := []byte("clientck")
+	cookie, rcode := responseCookie(query(udpAddr, client))
+	if rcode != badCookie {
+		t.Errorf("want rcode %d for a client cookie only, got %d", badCookie, rcode)
+	}
+	if len(cookie) != clientCookieLen+serverCookieLen || !bytes.HasPrefix(cookie, client) {
+		t.Fatalf("want server cookie for client cookie %q, got %q", client, cookie)
+	}
+
+	msg := query(udpAddr, cookie)
+	if len(msg.Answers) != 1 {
+		t.Errorf("want query with a server cookie served, got %+v", msg)
+	}
+	if next, rcode := responseCookie(msg); rcode != NoError || !bytes.HasPrefix(next, client) {
+		t.Errorf("want new server cookie, got %q (rcode %d)", next, rcode)
+	}
+
+	forged := append([]byte(nil), cookie...)
+	forged[len(forged)-1] ^= 0xFF
+	if _, rcode := responseCookie(query(udpAddr, forged)); rcode != badCookie {
+		t.Errorf("want rcode %d for an invalid server cookie, got %d", badCookie, rcode)
+	}
+
+	if want, got := uint64(4), srv.QueryStats().Cookies; want != got {
+		t.Errorf("want %d queries answered for their cookie, got %d", want, got)
+	}
+}
+
+func TestServerValidCookie(t *testing.T) {
+	t.Parallel()
+
+	var (
+		srv = &Server{CookieSecret: []byte("secret")}
+		ip  = net.ParseIP("192.0.2.1")
+		now = time.Now()
+	)
+
+	cookie := srv.cookie([]byte("clientck"), ip, now).Data
+	if !srv.validCookie(cookie, ip, now.Add(time.Minute)) {
+		t.Error("want cookie valid")
+	}
+	if srv.validCookie(cookie, net.ParseIP("192.0.2.2"), now) {
+		t.Error("want cookie of another client invalid")
+	}
+	if srv.validCookie(cookie, ip, now.Add(cookieLifetime+time.Second)) {
+		t.Error("want expired cookie invalid")
+	}
+	if (&Server{CookieSecret: []byte("other")}).validCookie(cookie, ip, now) {
+		t.Error("want cookie of another secret invalid")
+	}
+}
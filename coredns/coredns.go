@@ -0,0 +1,102 @@
+// Package coredns adapts the Servers of package dns to CoreDNS, so that the
+// zones and handlers written with package dns are served by a plugin of an
+// existing CoreDNS deployment.
+package coredns
+
+import (
+	"context"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	miekg "github.com/miekg/dns"
+
+	"github.com/helmutkemper/dns"
+)
+
+// defaultName is the name of a Plugin without a PluginName.
+const defaultName = "dns"
+
+// Plugin is a CoreDNS plugin answering the queries with a Server. The queries
+// and responses are converted between the messages of miekg/dns and those of
+// package dns through their wire format.
+//
+// A Plugin is added to the server blocks of a Corefile by the setup function
+// of the plugin registered by the deployment, such as:
+//
+//	plugin.Register("zone", func(c *caddy.Controller) error {
+//		p := &coredns.Plugin{PluginName: "zone", Server: &dns.Server{Handler: zone}}
+//		dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+//			p.Next = next
+//			return p
+//		})
+//		return nil
+//	})
+type Plugin struct {
+	// Server answers the queries, its Handler serving them as those of its
+	// connections, and its Forwarder relaying their recursion.
+	Server *dns.Server
+
+	// PluginName is the name of the plugin in the logs and metrics of
+	// CoreDNS. If empty, "dns" is used.
+	PluginName string
+
+	// Zones, if not empty, are the zones answered by the Server. The
+	// queries for other names are passed to Next.
+	Zones []string
+
+	// Fallthrough passes the queries answered with NXDOMAIN to Next, such
+	// as for a Server holding the local overrides of a forwarded zone.
+	Fallthrough bool
+
+	// Next is the next plugin of the server block.
+	Next plugin.Handler
+}
+
+// Name returns the name of the plugin.
+func (p *Plugin) Name() string {
+	if p.PluginName == "" {
+		return defaultName
+	}
+	return p.PluginName
+}
+
+// ServeDNS answers the query r with the response of the Server, written to w
+// in the size limits of the query.
+func (p *Plugin) ServeDNS(ctx context.Context, w miekg.ResponseWriter, r *miekg.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if len(p.Zones) > 0 && plugin.Zones(p.Zones).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+
+	b, err := r.Pack()
+	if err != nil {
+		return miekg.RcodeFormatError, plugin.Error(p.Name(), err)
+	}
+
+	query := &dns.Query{Message: new(dns.Message), RemoteAddr: w.RemoteAddr()}
+	if _, err := query.Unpack(b); err != nil {
+		return miekg.RcodeFormatError, plugin.Error(p.Name(), err)
+	}
+
+	msg, err := p.Server.ServeMessage(ctx, query)
+	if err != nil {
+		return miekg.RcodeServerFailure, plugin.Error(p.Name(), err)
+	}
+	if p.Fallthrough && msg.RCode == dns.NXDomain {
+		return plugin.NextOrFailure(p.Name(), p.Next, ctx, w, r)
+	}
+
+	if b, err = msg.Pack(nil, true); err != nil {
+		return miekg.RcodeServerFailure, plugin.Error(p.Name(), err)
+	}
+
+	res := new(miekg.Msg)
+	if err := res.Unpack(b); err != nil {
+		return miekg.RcodeServerFailure, plugin.Error(p.Name(), err)
+	}
+
+	if err := w.WriteMsg(state.Scrub(res)); err != nil {
+		return miekg.RcodeServerFailure, plugin.Error(p.Name(), err)
+	}
+	return miekg.RcodeSuccess, nil
+}
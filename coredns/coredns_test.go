@@ -0,0 +1,107 @@
+package coredns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	coretest "github.com/coredns/coredns/plugin/test"
+	miekg "github.com/miekg/dns"
+
+	"github.com/benburkert/dns"
+)
+
+func TestPlugin(t *testing.T) {
+	t.Parallel()
+
+	zone := &dns.Zone{
+		Origin: "coredns.dev.",
+		TTL:    time.Minute,
+		SOA:    &dns.SOA{NS: "ns.coredns.dev.", MBox: "hostmaster.coredns.dev."},
+		RRs: dns.NewRRSet(map[string]map[dns.Type][]dns.Record{
+			"www": {dns.TypeA: {&dns.A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}),
+	}
+
+	tests := []struct {
+		name string
+
+		plugin *Plugin
+		qname  string
+
+		rcode   int // returned
+		written bool
+		answer  string
+	}{
+		{
+			name:    "answer",
+			plugin:  &Plugin{Server: &dns.Server{Handler: zone}},
+			qname:   "www.coredns.dev.",
+			written: true,
+			answer:  "www.coredns.dev.\t60\tIN\tA\t192.0.2.1",
+		},
+		{
+			name:    "nxdomain",
+			plugin:  &Plugin{Server: &dns.Server{Handler: zone}},
+			qname:   "none.coredns.dev.",
+			written: true,
+		},
+		{
+			name:   "fallthrough",
+			plugin: &Plugin{Server: &dns.Server{Handler: zone}, Fallthrough: true, Next: coretest.NextHandler(miekg.RcodeRefused, nil)},
+			qname:  "none.coredns.dev.",
+			rcode:  miekg.RcodeRefused,
+		},
+		{
+			name:   "out of zones",
+			plugin: &Plugin{Server: &dns.Server{Handler: zone}, Zones: []string{"coredns.dev."}, Next: coretest.NextHandler(miekg.RcodeRefused, nil)},
+			qname:  "example.org.",
+			rcode:  miekg.RcodeRefused,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := new(miekg.Msg)
+			r.SetQuestion(test.qname, miekg.TypeA)
+
+			rec := dnstest.NewRecorder(&coretest.ResponseWriter{})
+			rcode, err := test.plugin.ServeDNS(context.Background(), rec, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, rcode; want != got {
+				t.Errorf("want rcode %d, got %d", want, got)
+			}
+			if want, got := test.written, rec.Msg != nil; want != got {
+				t.Fatalf("want response written %t, got %t", want, got)
+			}
+			if !test.written {
+				return
+			}
+
+			if want, got := r.Id, rec.Msg.Id; want != got {
+				t.Errorf("want ID %d, got %d", want, got)
+			}
+			if test.answer == "" {
+				if want, got := miekg.RcodeNameError, rec.Msg.Rcode; want != got {
+					t.Errorf("want response rcode %d, got %d", want, got)
+				}
+				return
+			}
+			if len(rec.Msg.Answer) != 1 {
+				t.Fatalf("want one answer, got %v", rec.Msg.Answer)
+			}
+			if want, got := test.answer, rec.Msg.Answer[0].String(); want != got {
+				t.Errorf("want answer %q, got %q", want, got)
+			}
+		})
+	}
+}
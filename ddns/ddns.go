@@ -0,0 +1,267 @@
+// Package ddns keeps the A and AAAA records of a host up to date on a DNS
+// server with dynamic updates, see RFC 2136, signed with TSIG, see RFC 8945.
+package ddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+// opUpdate is the opcode of the dynamic updates.
+const opUpdate dns.OpCode = 5
+
+const (
+	defaultTTL      = time.Minute
+	defaultInterval = 5 * time.Minute
+	defaultRetry    = 10 * time.Second
+)
+
+var (
+	errNoAddrs = errors.New("no addresses to register")
+	errUpdate  = errors.New("update refused")
+)
+
+// Client registers the addresses of a host on the primary server of its zone.
+// Run detects the addresses of the host periodically and updates its records
+// once they change; Update replaces them once.
+type Client struct {
+	// Zone is the zone of the host, such as "example.com.".
+	Zone string
+
+	// Name is the domain name of the host, such as "home.example.com.".
+	Name string
+
+	// Server is the address of the primary server of the zone.
+	Server net.Addr
+
+	// Key, if not nil, signs the updates.
+	Key *Key
+
+	// TTL is the TTL of the records. If zero, one minute is used.
+	TTL time.Duration
+
+	// Addrs, if not nil, detects the addresses of the host, such as with a
+	// query to an external service for a host behind NAT. If nil, the
+	// global unicast addresses of the interfaces are used.
+	Addrs func(context.Context) ([]net.IP, error)
+
+	// Interval is how often Run detects the addresses. If zero, they are
+	// detected every 5 minutes.
+	Interval time.Duration
+
+	// Retry is the delay before Run retries a failed update, doubled after
+	// each failure up to Interval. If zero, 10 seconds is used.
+	Retry time.Duration
+
+	// Client sends the updates. If nil, a zero Client is used. It must not
+	// negotiate EDNS, as an OPT record added to a signed update would break
+	// its signature.
+	Client *dns.Client
+
+	// Logger, if not nil, logs the updates, and the failures to update.
+	Logger dns.Logger
+}
+
+// Update replaces the A and AAAA records of the host with the addresses ips.
+// The records of a family without an address are deleted.
+func (c *Client) Update(ctx context.Context, ips []net.IP) error {
+	if len(ips) == 0 {
+		return errNoAddrs
+	}
+
+	msg := c.update(ips)
+	if c.Key != nil {
+		if err := c.Key.Sign(msg, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	client := c.Client
+	if client == nil {
+		client = new(dns.Client)
+	}
+
+	res, err := client.Do(ctx, &dns.Query{RemoteAddr: c.Server, Message: msg})
+	if err != nil {
+		return err
+	}
+	if res.RCode != dns.NoError {
+		for _, rr := range res.Additionals {
+			if t, ok := rr.Record.(*TSIG); ok && t.Error != dns.NoError {
+				return fmt.Errorf("%w: rcode %d, TSIG error %d", errUpdate, res.RCode, t.Error)
+			}
+		}
+		return fmt.Errorf("%w: rcode %d", errUpdate, res.RCode)
+	}
+	return nil
+}
+
+// update returns the update message deleting the address RRsets of the host,
+// and adding the addresses ips, see RFC 2136, section 2.5.
+func (c *Client) update(ips []net.IP) *dns.Message {
+	msg := &dns.Message{
+		OpCode:    opUpdate,
+		Questions: []dns.Question{{Name: c.Zone, Type: dns.TypeSOA, Class: dns.ClassIN}},
+		Authorities: []dns.Resource{
+			{Name: c.Name, Class: dns.ClassANY, Record: rrset(dns.TypeA)},
+			{Name: c.Name, Class: dns.ClassANY, Record: rrset(dns.TypeAAAA)},
+		},
+	}
+
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	for _, ip := range ips {
+		var rr dns.Record = &dns.AAAA{AAAA: ip.To16()}
+		if ip4 := ip.To4(); ip4 != nil {
+			rr = &dns.A{A: ip4}
+		}
+		msg.Authorities = append(msg.Authorities, dns.Resource{Name: c.Name, Class: dns.ClassIN, TTL: ttl, Record: rr})
+	}
+	return msg
+}
+
+// Run detects the addresses of the host every Interval, and updates its
+// records on the first run and once they change, until ctx is done. A failed
+// detection or update is retried after Retry, backing off. Run returns
+// ctx.Err().
+func (c *Client) Run(ctx context.Context) error {
+	var (
+		last  []net.IP // registered
+		fails int
+	)
+
+	for {
+		wait := c.interval()
+
+		ips, err := c.addrs(ctx)
+		if err == nil && (last == nil || !sameAddrs(ips, last)) {
+			if err = c.Update(ctx, ips); err == nil {
+				last = ips
+				c.log(ctx, slog.LevelInfo, "ddns update", "name", c.Name, "addrs", ips)
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.log(ctx, slog.LevelWarn, "ddns update", "name", c.Name, "err", err)
+
+			wait = c.retry(fails)
+			fails++
+		} else {
+			fails = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) addrs(ctx context.Context) ([]net.IP, error) {
+	if c.Addrs != nil {
+		return c.Addrs(ctx)
+	}
+	return InterfaceAddrs(ctx)
+}
+
+func (c *Client) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultInterval
+	}
+	return c.Interval
+}
+
+// retry returns the delay before the retry of the update that failed fails
+// times in a row before.
+func (c *Client) retry(fails int) time.Duration {
+	d := c.Retry
+	if d <= 0 {
+		d = defaultRetry
+	}
+	for ; fails > 0 && d < c.interval(); fails-- {
+		d *= 2
+	}
+	if max := c.interval(); d > max {
+		d = max
+	}
+	return d
+}
+
+func (c *Client) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Log(ctx, level, msg, args...)
+	}
+}
+
+// InterfaceAddrs returns the global unicast addresses of the network
+// interfaces of the host, the addresses detected by a Client without Addrs.
+func InterfaceAddrs(context.Context) ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
+
+func sameAddrs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, ip := range a {
+		found := false
+		for _, other := range b {
+			if ip.Equal(other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rrset is the record of the RRset of a type in an update: its owner, type
+// and class, without RDATA.
+type rrset dns.Type
+
+func (r rrset) Type() dns.Type { return dns.Type(r) }
+
+func (rrset) Length(dns.Compressor) (int, error) { return 0, nil }
+
+func (rrset) Pack(b []byte, _ dns.Compressor) ([]byte, error) { return b, nil }
+
+func (rrset) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, nil }
+
+func (r rrset) Get() interface{} { return r }
+
+func (r rrset) String() string { return fmt.Sprintf("%d", r) }
+
+func (rrset) FromJSon(string) error { return nil }
+
+func (r rrset) Key() string { return "" }
+
+func (r rrset) Equal(o dns.Record) bool { return o != nil && o.Type() == r.Type() && o.Key() == "" }
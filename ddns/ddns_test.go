@@ -0,0 +1,238 @@
+package ddns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+)
+
+var testKey = &Key{Name: "host.ddns.dev.", Secret: []byte("0123456789abcdef0123456789abcdef")}
+
+// mustServer starts a primary server answering the updates signed by testKey,
+// and refusing the others with NOTAUTH. It sends the updates received to
+// updatec.
+func mustServer(t *testing.T, updatec chan<- []byte) net.Addr {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			b := append([]byte(nil), buf[:n]...)
+
+			rcode := dns.RCode(9) // NOTAUTH
+			if testKey.Verify(b, time.Now()) == nil {
+				rcode = dns.NoError
+			}
+
+			res := make([]byte, 12)
+			copy(res[:2], b[:2])
+			nbo.PutUint16(res[2:4], 1<<15|uint16(opUpdate)<<11|uint16(rcode))
+			conn.WriteTo(res, addr)
+
+			if updatec != nil && rcode == dns.NoError {
+				updatec <- b
+			}
+		}
+	}()
+
+	return conn.LocalAddr()
+}
+
+func TestClientUpdate(t *testing.T) {
+	t.Parallel()
+
+	updatec := make(chan []byte, 1)
+	addr := mustServer(t, updatec)
+
+	c := &Client{Zone: "ddns.dev.", Name: "host.ddns.dev.", Server: addr, Key: testKey}
+
+	ips := []net.IP{net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1")}
+	if err := c.Update(context.Background(), ips); err != nil {
+		t.Fatal(err)
+	}
+
+	b := <-updatec
+	if want, got := opUpdate, dns.OpCode(nbo.Uint16(b[2:4])>>11&0xF); want != got {
+		t.Errorf("want opcode %d, got %d", want, got)
+	}
+
+	wrong := *c
+	wrong.Key = &Key{Name: testKey.Name, Secret: []byte("wrong")}
+	if err := wrong.Update(context.Background(), ips); !errors.Is(err, errUpdate) {
+		t.Errorf("want error %v, got %v", errUpdate, err)
+	}
+
+	if want, got := errNoAddrs, c.Update(context.Background(), nil); want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
+
+func TestClientUpdateMessage(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{Zone: "ddns.dev.", Name: "host.ddns.dev.", TTL: time.Hour}
+	msg := c.update([]net.IP{net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1")})
+
+	if want, got := []dns.Question{{Name: "ddns.dev.", Type: dns.TypeSOA, Class: dns.ClassIN}}, msg.Questions; !reflect.DeepEqual(want, got) {
+		t.Errorf("want zone section %v, got %v", want, got)
+	}
+
+	want := []dns.Resource{
+		{Name: "host.ddns.dev.", Class: dns.ClassANY, Record: rrset(dns.TypeA)},
+		{Name: "host.ddns.dev.", Class: dns.ClassANY, Record: rrset(dns.TypeAAAA)},
+		{Name: "host.ddns.dev.", Class: dns.ClassIN, TTL: time.Hour, Record: &dns.A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		{Name: "host.ddns.dev.", Class: dns.ClassIN, TTL: time.Hour, Record: &dns.AAAA{AAAA: net.ParseIP("2001:db8::1")}},
+	}
+	if got := msg.Authorities; !reflect.DeepEqual(want, got) {
+		t.Errorf("want update section %v, got %v", want, got)
+	}
+
+	b, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, int(nbo.Uint16(b[8:10])); want != got {
+		t.Errorf("want %d updates, got %d", want, got)
+	}
+}
+
+func TestKeySign(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+
+	for _, alg := range []string{"", HMACSHA1, HMACSHA256, HMACSHA384, HMACSHA512} {
+		key := &Key{Name: "Key.ddns.dev", Algorithm: alg, Secret: []byte("secret")}
+
+		msg := (&Client{Zone: "ddns.dev.", Name: "host.ddns.dev."}).update([]net.IP{net.IPv4(192, 0, 2, 1)})
+		msg.ID = 0x1234
+		if err := key.Sign(msg, now); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := msg.Pack(nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nbo.PutUint16(b[:2], 0x4321) // the ID set by the client
+
+		if err := key.Verify(b, now.Add(time.Minute)); err != nil {
+			t.Errorf("%q: %v", alg, err)
+		}
+		if want, got := errBadTime, key.Verify(b, now.Add(time.Hour)); want != got {
+			t.Errorf("%q: want error %v, got %v", alg, want, got)
+		}
+
+		b[len(b)-20] ^= 0xFF // in the MAC
+		if want, got := errBadSig, key.Verify(b, now); want != got {
+			t.Errorf("%q: want error %v, got %v", alg, want, got)
+		}
+	}
+
+	other := &Key{Name: "other.", Secret: []byte("secret")}
+	msg := &dns.Message{ID: 1}
+	if err := other.Sign(msg, now); err != nil {
+		t.Fatal(err)
+	}
+	b, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := errBadKey, testKey.Verify(b, now); want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+
+	// a signed response unpacks
+	if _, err := new(dns.Message).Unpack(b); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientRun(t *testing.T) {
+	t.Parallel()
+
+	updatec := make(chan []byte, 4)
+	addr := mustServer(t, updatec)
+
+	var calls int32
+	addrs := [][]net.IP{
+		{net.IPv4(192, 0, 2, 1)},
+		{net.IPv4(192, 0, 2, 1)},
+		{net.IPv4(192, 0, 2, 2)},
+	}
+
+	c := &Client{
+		Zone:     "ddns.dev.",
+		Name:     "host.ddns.dev.",
+		Server:   addr,
+		Key:      testKey,
+		Interval: 10 * time.Millisecond,
+		Addrs: func(context.Context) ([]net.IP, error) {
+			i := int(atomic.AddInt32(&calls, 1)) - 1
+			if i >= len(addrs) {
+				i = len(addrs) - 1
+			}
+			return addrs[i], nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(ctx) }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-updatec:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want update %d", i+1)
+		}
+	}
+
+	for atomic.LoadInt32(&calls) < int32(len(addrs))+2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	if want, got := context.Canceled, <-errc; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+	if want, got := 0, len(updatec); want != got {
+		t.Errorf("want %d more updates, got %d", want, got)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{Retry: time.Second, Interval: 5 * time.Second}
+	for _, test := range []struct {
+		fails int
+		wait  time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 5 * time.Second},
+		{10, 5 * time.Second},
+	} {
+		if want, got := test.wait, c.retry(test.fails); want != got {
+			t.Errorf("%d fails: want retry after %v, got %v", test.fails, want, got)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package ddns
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+var nbo = binary.BigEndian
+
+// TypeTSIG is the type of the TSIG records signing the messages.
+const TypeTSIG dns.Type = 250
+
+// The HMAC algorithms of the TSIG keys, see RFC 8945, section 6.
+const (
+	HMACSHA1   = "hmac-sha1."
+	HMACSHA256 = "hmac-sha256."
+	HMACSHA384 = "hmac-sha384."
+	HMACSHA512 = "hmac-sha512."
+)
+
+// defaultFudge is the time difference allowed between the signer and the
+// verifier of a message.
+const defaultFudge = 300 * time.Second
+
+// TSIG errors, see RFC 8945, section 3.
+const (
+	BadSig  dns.RCode = 16
+	BadKey  dns.RCode = 17
+	BadTime dns.RCode = 18
+)
+
+var (
+	errAlgorithm = errors.New("unsupported TSIG algorithm")
+	errNoTSIG    = errors.New("message not signed")
+	errTSIGLen   = errors.New("invalid TSIG record length")
+	errBadKey    = errors.New("TSIG key mismatch")
+	errBadSig    = errors.New("TSIG signature mismatch")
+	errBadTime   = errors.New("TSIG time out of range")
+)
+
+func init() {
+	dns.NewRecordByType[TypeTSIG] = func() dns.Record { return new(TSIG) }
+}
+
+// Key is a TSIG key shared with a server, see RFC 8945.
+type Key struct {
+	// Name is the name of the key, as configured on the server.
+	Name string
+
+	// Algorithm is the HMAC algorithm of the key, such as HMACSHA256. If
+	// empty, HMACSHA256 is used.
+	Algorithm string
+
+	// Secret is the secret of the key, decoded from its base64 form.
+	Secret []byte
+}
+
+// TSIG is a TSIG record, the signature of a message added as its last
+// additional record.
+type TSIG struct {
+	Algorithm  string
+	TimeSigned time.Time
+	Fudge      time.Duration
+	MAC        []byte
+	OriginalID int
+	Error      dns.RCode
+	OtherData  []byte
+}
+
+// Type returns the RR type identifier.
+func (TSIG) Type() dns.Type { return TypeTSIG }
+
+// Length returns the encoded RDATA size.
+func (t TSIG) Length(_ dns.Compressor) (int, error) {
+	return len(appendName(nil, t.Algorithm)) + 16 + len(t.MAC) + len(t.OtherData), nil
+}
+
+// Pack encodes t as RDATA. The algorithm name is not compressed as per RFC
+// 8945.
+func (t TSIG) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	b = appendName(b, t.Algorithm)
+	b = appendTime(b, t.TimeSigned, t.Fudge)
+
+	b = nbo.AppendUint16(b, uint16(len(t.MAC)))
+	b = append(b, t.MAC...)
+	b = nbo.AppendUint16(b, uint16(t.OriginalID))
+
+	return appendOther(b, t.Error, t.OtherData), nil
+}
+
+// Unpack decodes t from RDATA in b.
+func (t *TSIG) Unpack(b []byte, dec dns.Decompressor) ([]byte, error) {
+	var err error
+	if t.Algorithm, b, err = dec.Unpack(b); err != nil {
+		return nil, err
+	}
+	return t.unpack(b)
+}
+
+// unpack decodes the fields of t after its algorithm name from b.
+func (t *TSIG) unpack(b []byte) ([]byte, error) {
+	if len(b) < 10 {
+		return nil, errTSIGLen
+	}
+
+	secs := uint64(nbo.Uint16(b[:2]))<<32 | uint64(nbo.Uint32(b[2:6]))
+	t.TimeSigned = time.Unix(int64(secs), 0)
+	t.Fudge = time.Duration(nbo.Uint16(b[6:8])) * time.Second
+
+	n := int(nbo.Uint16(b[8:10]))
+	if b = b[10:]; len(b) < n+6 {
+		return nil, errTSIGLen
+	}
+	t.MAC, b = append([]byte(nil), b[:n]...), b[n:]
+
+	t.OriginalID = int(nbo.Uint16(b[:2]))
+	t.Error = dns.RCode(nbo.Uint16(b[2:4]))
+
+	n = int(nbo.Uint16(b[4:6]))
+	if b = b[6:]; len(b) < n {
+		return nil, errTSIGLen
+	}
+	t.OtherData = append([]byte(nil), b[:n]...)
+	return b[n:], nil
+}
+
+func (t *TSIG) Get() interface{} {
+	return t
+}
+
+// Key returns the RDATA as a comparable string.
+func (t *TSIG) Key() string {
+	return fmt.Sprintf("%s %d %d %x %d %d %x", strings.ToLower(t.Algorithm), t.TimeSigned.Unix(), t.Fudge/time.Second, t.MAC, t.OriginalID, t.Error, t.OtherData)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (t *TSIG) Equal(r dns.Record) bool {
+	return r != nil && r.Type() == TypeTSIG && r.Key() == t.Key()
+}
+
+func (t *TSIG) String() string {
+	bOut, _ := json.Marshal(t)
+	return string(bOut)
+}
+
+func (t *TSIG) FromJSon(v string) error {
+	return json.Unmarshal([]byte(v), t)
+}
+
+// Sign adds the TSIG record signing msg at now to its additional section. The
+// message must not be changed afterwards, but for its ID, as the signature
+// covers its packed form with name compression: it must be sent by a Client
+// that does not add an OPT record to it.
+func (k *Key) Sign(msg *dns.Message, now time.Time) error {
+	mac, err := k.hash()
+	if err != nil {
+		return err
+	}
+
+	b, err := msg.Pack(nil, true)
+	if err != nil {
+		return err
+	}
+
+	t := &TSIG{
+		Algorithm:  k.algorithm(),
+		TimeSigned: now,
+		Fudge:      defaultFudge,
+		OriginalID: msg.ID,
+	}
+	mac.Write(b)
+	mac.Write(k.variables(t))
+	t.MAC = mac.Sum(nil)
+
+	msg.Additionals = append(msg.Additionals, dns.Resource{
+		Name:   strings.TrimSuffix(k.Name, ".") + ".",
+		Class:  dns.ClassANY,
+		Record: t,
+	})
+	return nil
+}
+
+// Verify checks the TSIG record signing the packed message b, for a server to
+// authenticate the updates it receives. The signatures of the responses,
+// which also cover the signature of their request, are not checked.
+//
+// The message is not unpacked, as the records of an update, such as those
+// deleting an RRset with no RDATA, are not valid records of a message.
+func (k *Key) Verify(b []byte, now time.Time) error {
+	mac, err := k.hash()
+	if err != nil {
+		return err
+	}
+
+	name, t, off, err := lastTSIG(b)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(k.Name, ".")) ||
+		!strings.EqualFold(strings.TrimSuffix(t.Algorithm, "."), strings.TrimSuffix(k.algorithm(), ".")) {
+		return errBadKey
+	}
+
+	// the message as signed: its original ID, without the TSIG record
+	signed := append([]byte(nil), b[:off]...)
+	nbo.PutUint16(signed[:2], uint16(t.OriginalID))
+	nbo.PutUint16(signed[10:12], nbo.Uint16(signed[10:12])-1)
+
+	mac.Write(signed)
+	mac.Write(k.variables(t))
+	if !hmac.Equal(mac.Sum(nil), t.MAC) {
+		return errBadSig
+	}
+
+	if d := now.Sub(t.TimeSigned); d > t.Fudge || d < -t.Fudge {
+		return errBadTime
+	}
+	return nil
+}
+
+func (k *Key) algorithm() string {
+	if k.Algorithm == "" {
+		return HMACSHA256
+	}
+	return k.Algorithm
+}
+
+func (k *Key) hash() (hash.Hash, error) {
+	var h func() hash.Hash
+	switch strings.ToLower(strings.TrimSuffix(k.algorithm(), ".")) {
+	case "hmac-sha1":
+		h = sha1.New
+	case "hmac-sha256":
+		h = sha256.New
+	case "hmac-sha384":
+		h = sha512.New384
+	case "hmac-sha512":
+		h = sha512.New
+	default:
+		return nil, errAlgorithm
+	}
+	return hmac.New(h, k.Secret), nil
+}
+
+// variables returns the TSIG variables of t covered by its MAC, see RFC 8945,
+// section 4.3.3.
+func (k *Key) variables(t *TSIG) []byte {
+	b := appendName(nil, k.Name)
+	b = nbo.AppendUint16(b, uint16(dns.ClassANY))
+	b = nbo.AppendUint32(b, 0) // TTL
+	b = appendName(b, t.Algorithm)
+	b = appendTime(b, t.TimeSigned, t.Fudge)
+	return appendOther(b, t.Error, t.OtherData)
+}
+
+// appendName appends the name in canonical form: uncompressed, in lowercase.
+func appendName(b []byte, name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(strings.ToLower(name), ".") {
+			b = append(append(b, byte(len(label))), label...)
+		}
+	}
+	return append(b, 0)
+}
+
+func appendTime(b []byte, t time.Time, fudge time.Duration) []byte {
+	secs := uint64(t.Unix())
+	b = nbo.AppendUint16(b, uint16(secs>>32))
+	b = nbo.AppendUint32(b, uint32(secs))
+	return nbo.AppendUint16(b, uint16(fudge/time.Second))
+}
+
+func appendOther(b []byte, rcode dns.RCode, other []byte) []byte {
+	b = nbo.AppendUint16(b, uint16(rcode))
+	b = nbo.AppendUint16(b, uint16(len(other)))
+	return append(b, other...)
+}
+
+// lastTSIG returns the owner name and the TSIG record of the last record of
+// the packed message b, and its offset.
+func lastTSIG(b []byte) (string, *TSIG, int, error) {
+	if len(b) < 12 {
+		return "", nil, 0, errTSIGLen
+	}
+	if nbo.Uint16(b[10:12]) == 0 {
+		return "", nil, 0, errNoTSIG
+	}
+
+	var (
+		qd = int(nbo.Uint16(b[4:6]))
+		rr = int(nbo.Uint16(b[6:8])) + int(nbo.Uint16(b[8:10])) + int(nbo.Uint16(b[10:12]))
+
+		off = 12
+		err error
+	)
+
+	// the questions, then the records but the last
+	for i := 0; i < qd; i++ {
+		if _, off, err = readName(b, off); err != nil {
+			return "", nil, 0, err
+		}
+		if off += 4; off > len(b) {
+			return "", nil, 0, errTSIGLen
+		}
+	}
+	for i := 0; i < rr-1; i++ {
+		if _, off, err = readName(b, off); err != nil {
+			return "", nil, 0, err
+		}
+		if off+10 > len(b) {
+			return "", nil, 0, errTSIGLen
+		}
+		off += 10 + int(nbo.Uint16(b[off+8:off+10]))
+	}
+
+	start := off
+
+	name, off, err := readName(b, off)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if off+10 > len(b) {
+		return "", nil, 0, errTSIGLen
+	}
+	if dns.Type(nbo.Uint16(b[off:off+2])) != TypeTSIG {
+		return "", nil, 0, errNoTSIG
+	}
+
+	end := off + 10 + int(nbo.Uint16(b[off+8:off+10]))
+	if end != len(b) {
+		return "", nil, 0, errTSIGLen
+	}
+
+	t := new(TSIG)
+	if t.Algorithm, off, err = readName(b, off+10); err != nil {
+		return "", nil, 0, err
+	}
+	if off > end {
+		return "", nil, 0, errTSIGLen
+	}
+	if _, err := t.unpack(b[off:end]); err != nil {
+		return "", nil, 0, err
+	}
+	return name, t, start, nil
+}
+
+// readName returns the domain name at off in the packed message b, following
+// its compression pointers, and the offset past it.
+func readName(b []byte, off int) (string, int, error) {
+	var (
+		name  []byte
+		next  = -1 // offset past the name, once a pointer is followed
+		jumps int
+	)
+
+	for {
+		if off >= len(b) {
+			return "", 0, errTSIGLen
+		}
+
+		switch l := int(b[off]); {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if len(name) == 0 {
+				return ".", next, nil
+			}
+			return string(name), next, nil
+		case l&0xC0 == 0xC0:
+			if off+2 > len(b) || jumps > 126 {
+				return "", 0, errTSIGLen
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off, jumps = int(nbo.Uint16(b[off:off+2])&0x3FFF), jumps+1
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errTSIGLen
+			}
+			name = append(append(name, b[off+1:off+1+l]...), '.')
+			off += 1 + l
+		}
+	}
+}
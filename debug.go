@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// QueryStats is a snapshot of the query counters of a Server.
+type QueryStats struct {
+	Queries uint64           // queries passed to the handler
+	RCodes  map[RCode]uint64 // responses packed, by RCODE
+	Conns   int64            // TCP and TLS connections being served
+
+	Duplicates uint64 // UDP retransmissions coalesced with the query served
+
+	Minimized uint64 // responses with records omitted by MinimalResponses
+	Omitted   uint64 // records omitted from them
+
+	Dropped   uint64 // queries dropped by the Classifier, or past the MaxPending of the Tarpit
+	Truncated uint64 // queries answered with a truncated response by the Classifier
+	Tarpitted uint64 // queries whose response was delayed by the Classifier or the Tarpit
+	Delayed   int64  // responses being delayed
+	Cookies   uint64 // UDP queries answered for their missing or invalid cookie
+
+	RateLimited uint64 // UDP responses dropped or truncated by the ResponseRateLimit
+}
+
+type queryCounters struct {
+	queries    uint64
+	conns      int64
+	duplicates uint64
+	minimized  uint64
+	omitted    uint64
+	dropped    uint64
+	truncated  uint64
+	tarpitted  uint64
+	delayed    int64
+	cookies    uint64
+
+	rateLimited uint64
+
+	mu     sync.Mutex
+	rcodes map[RCode]uint64
+}
+
+func (c *queryCounters) response(rcode RCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.rcodes == nil {
+		c.rcodes = make(map[RCode]uint64)
+	}
+	c.rcodes[rcode]++
+}
+
+func (c *queryCounters) duplicate() { atomic.AddUint64(&c.duplicates, 1) }
+
+func (c *queryCounters) minimize(omitted int) {
+	atomic.AddUint64(&c.minimized, 1)
+	atomic.AddUint64(&c.omitted, uint64(omitted))
+}
+
+func (c *queryCounters) stats() QueryStats {
+	c.mu.Lock()
+	rcodes := make(map[RCode]uint64, len(c.rcodes))
+	for rcode, n := range c.rcodes {
+		rcodes[rcode] = n
+	}
+	c.mu.Unlock()
+
+	return QueryStats{
+		Queries: atomic.LoadUint64(&c.queries),
+		RCodes:  rcodes,
+		Conns:   atomic.LoadInt64(&c.conns),
+
+		Duplicates: atomic.LoadUint64(&c.duplicates),
+
+		Minimized: atomic.LoadUint64(&c.minimized),
+		Omitted:   atomic.LoadUint64(&c.omitted),
+
+		Dropped:   atomic.LoadUint64(&c.dropped),
+		Truncated: atomic.LoadUint64(&c.truncated),
+		Tarpitted: atomic.LoadUint64(&c.tarpitted),
+		Delayed:   atomic.LoadInt64(&c.delayed),
+		Cookies:   atomic.LoadUint64(&c.cookies),
+
+		RateLimited: atomic.LoadUint64(&c.rateLimited),
+	}
+}
+
+// QueryStats returns the query counters of the server.
+func (s *Server) QueryStats() QueryStats {
+	return s.queryc.stats()
+}
+
+// debugVars are the counters of a Server exposed by Expvar and DebugHandler.
+type debugVars struct {
+	Queries     uint64           `json:"queries"`
+	RCodes      map[RCode]uint64 `json:"rcodes"`
+	Conns       int64            `json:"conns"`
+	Duplicates  uint64           `json:"duplicates"`
+	Minimized   uint64           `json:"minimized"`
+	Omitted     uint64           `json:"omitted"`
+	Dropped     uint64           `json:"dropped"`
+	Truncated   uint64           `json:"truncated"`
+	Tarpitted   uint64           `json:"tarpitted"`
+	Delayed     int64            `json:"delayed"`
+	Cookies     uint64           `json:"cookies"`
+	RateLimited uint64           `json:"rate_limited"`
+	Compression CompressionStats `json:"compression"`
+	PackCache   *PackCacheStats  `json:"pack_cache,omitempty"`
+	Workers     *WorkerPoolStats `json:"workers,omitempty"`
+}
+
+func (s *Server) debugVars() debugVars {
+	qs := s.QueryStats()
+
+	v := debugVars{
+		Queries:     qs.Queries,
+		RCodes:      qs.RCodes,
+		Conns:       qs.Conns,
+		Duplicates:  qs.Duplicates,
+		Minimized:   qs.Minimized,
+		Omitted:     qs.Omitted,
+		Dropped:     qs.Dropped,
+		Truncated:   qs.Truncated,
+		Tarpitted:   qs.Tarpitted,
+		Delayed:     qs.Delayed,
+		Cookies:     qs.Cookies,
+		RateLimited: qs.RateLimited,
+		Compression: s.CompressionStats(),
+	}
+	if pc, ok := s.Handler.(interface{ PackCacheStats() PackCacheStats }); ok {
+		stats := pc.PackCacheStats()
+		v.PackCache = &stats
+	}
+	if s.Workers != nil {
+		stats := s.Workers.Stats()
+		v.Workers = &stats
+	}
+	return v
+}
+
+// Expvar returns the counters of the server as an expvar.Var: the queries
+// served, the responses by RCODE, the open connections, the name compression
+// and pack cache counters, and the state of the worker pool. It is published
+// with expvar.Publish, such as under "dns".
+func (s *Server) Expvar() expvar.Var {
+	return expvar.Func(func() any { return s.debugVars() })
+}
+
+// DebugHandler returns an HTTP handler serving the counters of Expvar as JSON,
+// such as at /debug/dns.
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(s.debugVars())
+	})
+}
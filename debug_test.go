@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerDebugVars(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "debug.dev.",
+		TTL:    time.Minute,
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(127, 0, 0, 1).To4()})
+
+	srv := &Server{
+		Addr:    mustUnusedAddr(),
+		Handler: zone,
+		Workers: new(WorkerPool),
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app.debug.dev.", "app.debug.dev.", "none.debug.dev."} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := new(Client).Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: name, Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := srv.QueryStats()
+	if want, got := uint64(3), stats.Queries; want != got {
+		t.Errorf("want %d queries, got %d", want, got)
+	}
+	if want, got := uint64(2), stats.RCodes[NoError]; want != got {
+		t.Errorf("want %d NOERROR responses, got %d", want, got)
+	}
+	if want, got := uint64(1), stats.RCodes[NXDomain]; want != got {
+		t.Errorf("want %d NXDOMAIN responses, got %d", want, got)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dns", nil))
+
+	var v struct {
+		Queries   uint64            `json:"queries"`
+		RCodes    map[string]uint64 `json:"rcodes"`
+		PackCache *PackCacheStats   `json:"pack_cache"`
+		Workers   *WorkerPoolStats  `json:"workers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint64(3), v.Queries; want != got {
+		t.Errorf("want %d queries, got %d", want, got)
+	}
+	if want, got := uint64(1), v.RCodes["3"]; want != got {
+		t.Errorf("want %d NXDOMAIN responses, got %d", want, got)
+	}
+	if v.PackCache == nil || v.Workers == nil {
+		t.Errorf("want pack cache and worker stats, got %s", rec.Body)
+	}
+
+	if err := json.Unmarshal([]byte(srv.Expvar().String()), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := uint64(3), v.Queries; want != got {
+		t.Errorf("want %d queries in expvar, got %d", want, got)
+	}
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"sync"
+)
+
+// dupKey identifies a UDP query and its retransmissions: the source
+// address, the socket it was read from, its ID and its question.
+type dupKey struct {
+	remote, local string
+	id            int
+	q             Question
+}
+
+// duplicates tracks the UDP queries being served, and the retransmissions
+// received for them.
+type duplicates struct {
+	mu       sync.Mutex
+	inflight map[dupKey]int // retransmissions, by query
+}
+
+// add reports whether the query of key is already being served, counting it
+// as a retransmission. Otherwise the query is tracked until done.
+func (d *duplicates) add(key dupKey) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if n, ok := d.inflight[key]; ok {
+		d.inflight[key] = n + 1
+		return true
+	}
+
+	if d.inflight == nil {
+		d.inflight = make(map[dupKey]int)
+	}
+	d.inflight[key] = 0
+	return false
+}
+
+// done stops tracking the query of key, and returns its retransmissions.
+func (d *duplicates) done(key dupKey) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := d.inflight[key]
+	delete(d.inflight, key)
+	return n
+}
+
+// coalesce reports whether req is a retransmission of a query being served.
+// Otherwise it returns the function to call once req is served, writing its
+// response again for each retransmission received meanwhile.
+func (s *Server) coalesce(pw *packetWriter, req *Query) (func(), bool) {
+	if !s.SuppressDuplicates || len(req.Questions) != 1 {
+		return nil, false
+	}
+
+	key := dupKey{
+		remote: req.RemoteAddr.String(),
+		local:  pw.conn.LocalAddr().String(),
+		id:     req.ID,
+		q:      req.Questions[0],
+	}
+	if s.dups.add(key) {
+		s.queryc.duplicate()
+		return nil, true
+	}
+
+	// the response of a tarpitted query is written once it is served
+	var (
+		mu  sync.Mutex
+		res []byte
+	)
+	hook := pw.hook
+	pw.hook = func(b []byte) {
+		mu.Lock()
+		res = b
+		mu.Unlock()
+		if hook != nil {
+			hook(b)
+		}
+	}
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for n := s.dups.done(key); n > 0 && res != nil; n-- {
+			if _, err := pw.conn.WriteTo(res, pw.addr); err != nil {
+				return
+			}
+			if hook != nil {
+				hook(res)
+			}
+		}
+	}, false
+}
@@ -0,0 +1,310 @@
+// Package dhcplease publishes the leases of a DHCP server as records: the
+// address records of the host names of its clients in a Zone, and the PTR
+// records of their addresses in the reverse zones, each record expiring with
+// its lease. The leases are read from the lease files of ISC dhcpd or Kea,
+// or given one by one, such as by the hooks of the server.
+package dhcplease
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+const (
+	defaultTTL      = 5 * time.Minute
+	defaultInterval = 10 * time.Second
+)
+
+var errHostname = errors.New("invalid host name")
+
+// Lease is the lease of an address to a client of a DHCP server.
+type Lease struct {
+	IP net.IP
+
+	// Hostname is the host name of the client, a name relative to the
+	// origin of the forward zone, such as "laptop", or absolute, such as
+	// "laptop.lan.".
+	Hostname string
+
+	// Expires is the end of the lease. If zero, the lease never expires.
+	Expires time.Time
+}
+
+// Publisher maintains the records of the leases of a DHCP server.
+//
+// The records are stored as entries expiring with their lease, with the
+// "dhcp" source, next to the other records of the zones. A renewed lease
+// extends the expiry of its records, and a new lease of an address replaces
+// the records of its previous one.
+type Publisher struct {
+	// Zone is the forward zone of the host names.
+	Zone *dns.Zone
+
+	// Reverse are the reverse zones of the PTR records, such as
+	// 1.168.192.in-addr.arpa. The addresses outside of them have no PTR
+	// record.
+	Reverse []*dns.Zone
+
+	// TTL is the TTL of the records, lowered to the rest of their lease.
+	// If zero, 5 minutes is used.
+	TTL time.Duration
+
+	// Logger, if not nil, logs the leases published and removed, and the
+	// failures to read a lease file.
+	Logger dns.Logger
+
+	mu     sync.Mutex
+	leases map[string]published // by address
+}
+
+// published are the records of a lease.
+type published struct {
+	lease Lease
+
+	key     string
+	forward dns.Record
+
+	reverse *dns.Zone // nil without PTR record
+	revKey  string
+	ptr     dns.Record
+}
+
+// Add publishes the records of the lease l, or renews them.
+func (p *Publisher) Add(l Lease) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.add(context.Background(), l)
+}
+
+// Release removes the records of the lease of the address ip.
+func (p *Publisher) Release(ip net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.remove(context.Background(), ip.String())
+}
+
+// Set replaces the published leases with leases, those of a lease file. The
+// expired leases, and those without a valid host name, are left out.
+func (p *Publisher) Set(leases []Lease) {
+	p.set(context.Background(), leases)
+}
+
+func (p *Publisher) set(ctx context.Context, leases []Lease) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	want := make(map[string]Lease, len(leases))
+	for _, l := range leases {
+		if l.Expires.IsZero() || l.Expires.After(now) {
+			want[l.IP.String()] = l
+		}
+	}
+
+	for ip := range p.leases {
+		if _, ok := want[ip]; !ok {
+			p.remove(ctx, ip)
+		}
+	}
+	for ip, l := range want {
+		if old, ok := p.leases[ip]; ok && sameLease(old.lease, l) {
+			continue
+		}
+		if err := p.add(ctx, l); err != nil {
+			p.log(ctx, slog.LevelDebug, "dhcp lease", "ip", ip, "hostname", l.Hostname, "err", err)
+		}
+	}
+}
+
+// add publishes l.
+//
+// p.mu held
+func (p *Publisher) add(ctx context.Context, l Lease) error {
+	name, err := p.name(l.Hostname)
+	if err != nil {
+		return err
+	}
+	key, ok := p.Zone.NameKey(name)
+	if !ok {
+		return errHostname
+	}
+
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	entry := func(rr dns.Record) *dns.RREntry {
+		return &dns.RREntry{Record: rr, TTL: ttl, Expires: l.Expires, Meta: dns.RRMeta{Source: "dhcp"}}
+	}
+
+	ip := l.IP.String()
+	pub := published{lease: l, key: key}
+	if ip4 := l.IP.To4(); ip4 != nil {
+		pub.forward = &dns.A{A: ip4}
+	} else {
+		pub.forward = &dns.AAAA{AAAA: l.IP.To16()}
+	}
+	if rev, ok := dns.ReverseName(l.IP); ok {
+		for _, z := range p.Reverse {
+			if k, ok := z.NameKey(rev); ok {
+				pub.reverse, pub.revKey, pub.ptr = z, k, &dns.PTR{PTR: name}
+				break
+			}
+		}
+	}
+
+	// the records of a renewed lease are replaced in place
+	if old, ok := p.leases[ip]; ok && old.key != key {
+		p.unpublish(old)
+	} else if ok && old.reverse != nil && !old.ptr.Equal(pub.ptr) {
+		old.reverse.DeleteRecordInKey(old.revKey, old.ptr)
+	}
+
+	err = p.Zone.Txn(func(tx *dns.RRSetTxn) error {
+		tx.DeleteRecordInKey(key, pub.forward)
+		tx.AppendRecordInKey(key, entry(pub.forward))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if pub.reverse != nil {
+		pub.reverse.Txn(func(tx *dns.RRSetTxn) error {
+			tx.DeleteRecordInKey(pub.revKey, pub.ptr)
+			tx.AppendRecordInKey(pub.revKey, entry(pub.ptr))
+			return nil
+		})
+	}
+
+	if p.leases == nil {
+		p.leases = make(map[string]published)
+	}
+	p.leases[ip] = pub
+
+	p.log(ctx, slog.LevelInfo, "dhcp lease", "ip", ip, "name", name, "expires", l.Expires)
+	return nil
+}
+
+// remove removes the records of the lease of ip.
+//
+// p.mu held
+func (p *Publisher) remove(ctx context.Context, ip string) {
+	pub, ok := p.leases[ip]
+	if !ok {
+		return
+	}
+	p.unpublish(pub)
+	delete(p.leases, ip)
+
+	p.log(ctx, slog.LevelInfo, "dhcp release", "ip", ip, "name", pub.lease.Hostname)
+}
+
+func (p *Publisher) unpublish(pub published) {
+	p.Zone.DeleteRecordInKey(pub.key, pub.forward)
+	if pub.reverse != nil {
+		pub.reverse.DeleteRecordInKey(pub.revKey, pub.ptr)
+	}
+}
+
+// name returns the domain name of the host name of a lease.
+func (p *Publisher) name(hostname string) (string, error) {
+	hostname = strings.ToLower(strings.TrimSpace(hostname))
+	if hostname == "" {
+		return "", errHostname
+	}
+	if !strings.HasSuffix(hostname, ".") {
+		if !validLabel(hostname) {
+			return "", errHostname
+		}
+		return strings.TrimSuffix(hostname+"."+strings.TrimSuffix(p.Zone.Origin, "."), ".") + ".", nil
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(hostname, "."), ".") {
+		if !validLabel(label) {
+			return "", errHostname
+		}
+	}
+	return hostname, nil
+}
+
+// validLabel reports whether label is a label of a host name, see RFC 952 and
+// RFC 1123, section 2.1.
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(label); i++ {
+		switch c := label[i]; {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func sameLease(a, b Lease) bool {
+	return a.IP.Equal(b.IP) && a.Hostname == b.Hostname && a.Expires.Equal(b.Expires)
+}
+
+// WatchFile publishes the leases of the lease file at path, read by parse,
+// such as ParseDhcpd, once it is read and then each time it changes, checked
+// every interval, until ctx is done. If interval is zero, the file is checked
+// every 10 seconds. WatchFile returns ctx.Err().
+func (p *Publisher) WatchFile(ctx context.Context, path string, parse func(io.Reader) ([]Lease, error), interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	for {
+		if fi, err := os.Stat(path); err != nil {
+			p.log(ctx, slog.LevelWarn, "dhcp lease file", "path", path, "err", err)
+		} else if !fi.ModTime().Equal(modTime) {
+			leases, err := readLeases(path, parse)
+			if err != nil {
+				p.log(ctx, slog.LevelWarn, "dhcp lease file", "path", path, "err", err)
+			} else {
+				modTime = fi.ModTime()
+				p.set(ctx, leases)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func readLeases(path string, parse func(io.Reader) ([]Lease, error)) ([]Lease, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parse(f)
+}
+
+func (p *Publisher) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if p.Logger != nil {
+		p.Logger.Log(ctx, level, msg, args...)
+	}
+}
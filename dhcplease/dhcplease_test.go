@@ -0,0 +1,188 @@
+package dhcplease
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+	"github.com/benburkert/dns/internal/zonetest"
+)
+
+func testPublisher() *Publisher {
+	return &Publisher{
+		Zone: zonetest.Zone("lan.", nil),
+		Reverse: []*dns.Zone{zonetest.Zone("1.168.192.in-addr.arpa.", map[string]map[dns.Type][]dns.Record{
+			"1": {dns.TypePTR: {&dns.PTR{PTR: "router.lan."}}},
+		})},
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	t.Parallel()
+
+	p := testPublisher()
+	fwd, rev := p.Zone, p.Reverse[0]
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := p.Add(Lease{IP: net.IPv4(192, 168, 1, 10), Hostname: "Laptop", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(Lease{IP: net.ParseIP("fd00::10"), Hostname: "laptop.lan.", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := []dns.Record{&dns.A{A: net.IPv4(192, 168, 1, 10).To4()}}, zonetest.Records(fwd, "laptop", dns.TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if want, got := []dns.Record{&dns.AAAA{AAAA: net.ParseIP("fd00::10")}}, zonetest.Records(fwd, "laptop", dns.TypeAAAA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if want, got := []dns.Record{&dns.PTR{PTR: "laptop.lan."}}, zonetest.Records(rev, "10", dns.TypePTR); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+
+	e := fwd.GetEntries("laptop", dns.TypeA)[0]
+	if want, got := expires, e.Expires; !want.Equal(got) {
+		t.Errorf("want expiry %v, got %v", want, got)
+	}
+	if want, got := "dhcp", e.Meta.Source; want != got {
+		t.Errorf("want source %q, got %q", want, got)
+	}
+
+	// a renewal extends the lease in place
+	renewed := expires.Add(time.Hour)
+	if err := p.Add(Lease{IP: net.IPv4(192, 168, 1, 10), Hostname: "laptop", Expires: renewed}); err != nil {
+		t.Fatal(err)
+	}
+	if es := fwd.GetEntries("laptop", dns.TypeA); len(es) != 1 || !es[0].Expires.Equal(renewed) {
+		t.Errorf("want one entry expiring at %v, got %+v", renewed, es)
+	}
+
+	// a new client of the address replaces the records of the previous one
+	if err := p.Add(Lease{IP: net.IPv4(192, 168, 1, 10), Hostname: "phone", Expires: renewed}); err != nil {
+		t.Fatal(err)
+	}
+	if got := zonetest.Records(fwd, "laptop", dns.TypeA); len(got) != 0 {
+		t.Errorf("want the records of the previous lease removed, got %v", got)
+	}
+	if want, got := []dns.Record{&dns.PTR{PTR: "phone.lan."}}, zonetest.Records(rev, "10", dns.TypePTR); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+
+	p.Release(net.IPv4(192, 168, 1, 10))
+	if got := zonetest.Records(fwd, "phone", dns.TypeA); len(got) != 0 {
+		t.Errorf("want the records of a released lease removed, got %v", got)
+	}
+	if got := zonetest.Records(rev, "10", dns.TypePTR); len(got) != 0 {
+		t.Errorf("want the records of a released lease removed, got %v", got)
+	}
+	if want, got := []dns.Record{&dns.PTR{PTR: "router.lan."}}, zonetest.Records(rev, "1", dns.TypePTR); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+
+	for _, hostname := range []string{"", "bad_name", "-x", "laptop.other.", "a..lan."} {
+		if err := p.Add(Lease{IP: net.IPv4(192, 168, 1, 11), Hostname: hostname}); err != errHostname {
+			t.Errorf("%q: want error %v, got %v", hostname, errHostname, err)
+		}
+	}
+}
+
+func TestPublisherExpiry(t *testing.T) {
+	t.Parallel()
+
+	p := testPublisher()
+	if err := p.Add(Lease{IP: net.IPv4(192, 168, 1, 10), Hostname: "laptop", Expires: time.Now().Add(50 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := zonetest.Records(p.Zone, "laptop", dns.TypeA); len(got) != 1 {
+		t.Fatalf("want one record, got %v", got)
+	}
+
+	for deadline := time.Now().Add(time.Second); len(zonetest.Records(p.Zone, "laptop", dns.TypeA)) > 0 || len(zonetest.Records(p.Reverse[0], "10", dns.TypePTR)) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("want the records removed once the lease expires")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPublisherSet(t *testing.T) {
+	t.Parallel()
+
+	p := testPublisher()
+	now := time.Now()
+
+	p.Set([]Lease{
+		{IP: net.IPv4(192, 168, 1, 10), Hostname: "laptop", Expires: now.Add(time.Hour)},
+		{IP: net.IPv4(192, 168, 1, 11), Hostname: "old", Expires: now.Add(-time.Hour)},
+		{IP: net.IPv4(192, 168, 1, 12), Hostname: "printer"},
+		{IP: net.IPv4(192, 168, 1, 13), Hostname: ""},
+	})
+	if want, got := []string{"laptop", "printer"}, p.Zone.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+	if es := p.Zone.GetEntries("printer", dns.TypeA); len(es) != 1 || !es[0].Expires.IsZero() {
+		t.Errorf("want an entry without expiry, got %+v", es)
+	}
+
+	p.Set([]Lease{
+		{IP: net.IPv4(192, 168, 1, 12), Hostname: "printer"},
+	})
+	if want, got := []string{"printer"}, p.Zone.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+}
+
+func TestPublisherWatchFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dhcpd.leases")
+	write := func(leases ...string) {
+		var b strings.Builder
+		for _, l := range leases {
+			b.WriteString(l)
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lease := func(ip, hostname string) string {
+		return "lease " + ip + " {\n  ends never;\n  binding state active;\n  client-hostname \"" + hostname + "\";\n}\n"
+	}
+
+	write(lease("192.168.1.10", "laptop"))
+
+	p := testPublisher()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- p.WatchFile(ctx, path, ParseDhcpd, 10*time.Millisecond) }()
+
+	wait := func(want ...string) {
+		for deadline := time.Now().Add(5 * time.Second); !reflect.DeepEqual(want, p.Zone.Names()); {
+			if time.Now().After(deadline) {
+				t.Fatalf("want names %q, got %q", want, p.Zone.Names())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	wait("laptop")
+
+	// the modification time may not change within the resolution of the
+	// file system
+	time.Sleep(20 * time.Millisecond)
+	write(lease("192.168.1.10", "laptop"), lease("192.168.1.11", "phone"))
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+	wait("laptop", "phone")
+
+	cancel()
+	if want, got := context.Canceled, <-errc; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,188 @@
+package dhcplease
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errLeaseFile = errors.New("invalid lease file")
+
+// ParseDhcpd returns the active leases of the dhcpd.leases file of ISC dhcpd
+// read from r, the last of the entries of an address in the file being its
+// lease. The leases of DHCPv6, which hold no host name, are left out.
+func ParseDhcpd(r io.Reader) ([]Lease, error) {
+	var (
+		leases []Lease
+		index  = make(map[string]int) // of the lease of an address
+		active = make(map[string]bool)
+
+		cur   *Lease
+		state string
+		line  int
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line++
+
+		text := strings.TrimSpace(sc.Text())
+		if i := strings.IndexByte(text, '#'); i >= 0 && !strings.Contains(text[:i], `"`) {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+
+		if cur == nil {
+			if f := strings.Fields(text); len(f) == 3 && f[0] == "lease" && f[2] == "{" {
+				ip := net.ParseIP(f[1])
+				if ip == nil {
+					return nil, fmt.Errorf("%w: line %d: invalid address %q", errLeaseFile, line, f[1])
+				}
+				cur, state = &Lease{IP: ip}, ""
+			}
+			continue
+		}
+
+		if text == "}" {
+			ip := cur.IP.String()
+			if i, ok := index[ip]; ok {
+				leases[i] = *cur
+			} else {
+				index[ip] = len(leases)
+				leases = append(leases, *cur)
+			}
+			active[ip] = state == "active"
+			cur = nil
+			continue
+		}
+
+		text = strings.TrimSuffix(text, ";")
+		switch {
+		case strings.HasPrefix(text, "binding state "):
+			state = strings.TrimPrefix(text, "binding state ")
+		case strings.HasPrefix(text, "client-hostname "):
+			name, err := strconv.Unquote(strings.TrimPrefix(text, "client-hostname "))
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: invalid host name", errLeaseFile, line)
+			}
+			cur.Hostname = name
+		case strings.HasPrefix(text, "ends "):
+			ends, err := dhcpdTime(strings.TrimPrefix(text, "ends "))
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", errLeaseFile, line, err)
+			}
+			cur.Expires = ends
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	out := leases[:0]
+	for _, l := range leases {
+		if active[l.IP.String()] {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// dhcpdTime returns the time of a date of dhcpd: "never", "epoch" and its
+// Unix time, or the weekday, date and time in UTC.
+func dhcpdTime(s string) (time.Time, error) {
+	f := strings.Fields(s)
+	switch {
+	case len(f) == 1 && f[0] == "never":
+		return time.Time{}, nil
+	case len(f) >= 2 && f[0] == "epoch":
+		secs, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(secs, 0), nil
+	case len(f) == 3:
+		return time.Parse("2006/01/02 15:04:05", f[1]+" "+f[2])
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// ParseKea returns the leases of the memfile CSV lease file of Kea, of
+// DHCPv4 or DHCPv6, read from r, the last of the rows of an address in the
+// file being its lease. The released and declined leases are left out.
+func ParseKea(r io.Reader) ([]Lease, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range []string{"address", "expire", "valid_lifetime", "hostname", "state"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("%w: no %s column", errLeaseFile, name)
+		}
+	}
+
+	var (
+		leases []Lease
+		index  = make(map[string]int)
+		valid  = make(map[string]bool)
+	)
+	for row := 2; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < len(header) {
+			return nil, fmt.Errorf("%w: row %d: %d columns", errLeaseFile, row, len(rec))
+		}
+
+		ip := net.ParseIP(rec[cols["address"]])
+		expire, err1 := strconv.ParseInt(rec[cols["expire"]], 10, 64)
+		lifetime, err2 := strconv.ParseUint(rec[cols["valid_lifetime"]], 10, 32)
+		if ip == nil || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%w: row %d", errLeaseFile, row)
+		}
+
+		l := Lease{
+			IP:       ip,
+			Hostname: strings.ReplaceAll(rec[cols["hostname"]], "&#x2c", ","),
+			Expires:  time.Unix(expire, 0),
+		}
+
+		k := ip.String()
+		if i, ok := index[k]; ok {
+			leases[i] = l
+		} else {
+			index[k] = len(leases)
+			leases = append(leases, l)
+		}
+
+		// state 0 is an assigned lease, and a lifetime of zero a released one
+		valid[k] = rec[cols["state"]] == "0" && lifetime > 0
+	}
+
+	out := leases[:0]
+	for _, l := range leases {
+		if valid[l.IP.String()] {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+package dhcplease
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDhcpd(t *testing.T) {
+	t.Parallel()
+
+	file := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+# This lease file was written by isc-dhcp-4.4.3
+
+authoring-byte-order little-endian;
+
+lease 192.168.1.10 {
+  starts 4 2024/01/04 10:00:00;
+  ends 4 2024/01/04 22:00:00;
+  cltt 4 2024/01/04 10:00:00;
+  binding state active;
+  next binding state free;
+  rewind binding state free;
+  hardware ethernet 00:11:22:33:44:55;
+  client-hostname "laptop";
+}
+lease 192.168.1.11 {
+  starts 4 2024/01/04 10:00:00;
+  ends epoch 1704405600; # Thu Jan 04 22:00:00 2024
+  binding state active;
+  client-hostname "phone # 2";
+}
+lease 192.168.1.12 {
+  ends never;
+  binding state free;
+  client-hostname "gone";
+}
+lease 192.168.1.10 {
+  starts 5 2024/01/05 10:00:00;
+  ends 5 2024/01/05 22:00:00;
+  binding state active;
+  client-hostname "laptop";
+}
+server-duid "\000\001\000\001";
+`
+
+	leases, err := ParseDhcpd(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Lease{
+		{IP: net.ParseIP("192.168.1.10"), Hostname: "laptop", Expires: time.Date(2024, 1, 5, 22, 0, 0, 0, time.UTC)},
+		{IP: net.ParseIP("192.168.1.11"), Hostname: "phone # 2", Expires: time.Unix(1704405600, 0)},
+	}
+	if !reflect.DeepEqual(want, leases) {
+		t.Errorf("want leases %+v, got %+v", want, leases)
+	}
+
+	if _, err := ParseDhcpd(strings.NewReader("lease 192.168.1.300 {\n}\n")); !errors.Is(err, errLeaseFile) {
+		t.Errorf("want error %v, got %v", errLeaseFile, err)
+	}
+}
+
+func TestParseKea(t *testing.T) {
+	t.Parallel()
+
+	file := `address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id
+192.168.1.10,00:11:22:33:44:55,,3600,1704405600,1,0,0,laptop,0,,0
+192.168.1.11,00:11:22:33:44:56,,3600,1704405600,1,0,0,phone.lan.,0,,0
+192.168.1.12,00:11:22:33:44:57,,3600,1704405600,1,0,0,declined,1,,0
+192.168.1.11,00:11:22:33:44:56,,0,1704402000,1,0,0,phone.lan.,0,,0
+192.168.1.10,00:11:22:33:44:55,,3600,1704409200,1,0,0,laptop,0,,0
+`
+
+	leases, err := ParseKea(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Lease{
+		{IP: net.ParseIP("192.168.1.10"), Hostname: "laptop", Expires: time.Unix(1704409200, 0)},
+	}
+	if !reflect.DeepEqual(want, leases) {
+		t.Errorf("want leases %+v, got %+v", want, leases)
+	}
+
+	if _, err := ParseKea(strings.NewReader("address,expire\n")); !errors.Is(err, errLeaseFile) {
+		t.Errorf("want error %v, got %v", errLeaseFile, err)
+	}
+}
@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var errUnsupportedProxy = errors.New("unsupported proxy scheme")
+
+// ViaProxyAddr indicates the remote DNS service is dialed through the proxy
+// at URL. Supported proxies are SOCKS5, with a "socks5" scheme, and HTTP
+// CONNECT, with an "http" scheme. Credentials are taken from the user info of
+// URL. Only stream networks, such as TCP or DNS-over-TLS, may be proxied.
+type ViaProxyAddr struct {
+	net.Addr
+
+	URL *url.URL
+}
+
+// proxyURL returns the URL of the proxy of addr, or nil.
+func proxyURL(addr net.Addr) *url.URL {
+	if a, ok := addr.(OverTLSAddr); ok {
+		addr = a.Addr
+	}
+	if a, ok := addr.(ViaProxyAddr); ok {
+		return a.URL
+	}
+	return nil
+}
+
+// dialProxy dials addr through the proxy u.
+func dialProxy(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), u *url.URL, addr string) (net.Conn, error) {
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return nil, errUnsupportedProxy
+	}
+
+	conn, err := dial(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if u.Scheme == "socks5" {
+		err = socks5Connect(conn, u.User, addr)
+	} else {
+		conn, err = httpConnect(conn, u.User, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// See RFC 1928 and RFC 1929 for the SOCKS5 protocol.
+const (
+	socks5Version = 0x05
+
+	socks5NoAuth   = 0x00
+	socks5UserPass = 0x02
+
+	socks5CmdConnect = 0x01
+
+	socks5IPv4   = 0x01
+	socks5Domain = 0x03
+	socks5IPv6   = 0x04
+)
+
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	method := byte(socks5NoAuth)
+	if user != nil {
+		method = socks5UserPass
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	var b [2]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return err
+	}
+	if b[0] != socks5Version || b[1] != method {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+
+	if method == socks5UserPass {
+		pass, _ := user.Password()
+
+		req := []byte{0x01, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(pass)))
+		req = append(req, pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, b[:]); err != nil {
+			return err
+		}
+		if b[1] != 0x00 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[1] != 0x00 {
+		return errors.New("socks5: connect failed with code " + strconv.Itoa(int(hdr[1])))
+	}
+
+	var n int
+	switch hdr[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return errors.New("socks5: invalid bound address")
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(r, req)
+	if err != nil {
+		return conn, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return conn, errors.New("http connect: " + res.Status)
+	}
+
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data read ahead into r.
+type bufferedConn struct {
+	net.Conn
+
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
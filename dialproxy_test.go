@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTransportViaProxy(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("socks5", func(t *testing.T) {
+		t.Parallel()
+
+		targets := make(chan string, 1)
+		proxy := mustProxy(t, func(conn net.Conn) net.Conn { return socks5Accept(t, conn, targets) })
+
+		u := &url.URL{Scheme: "socks5", Host: proxy, User: url.UserPassword("user", "pass")}
+		testViaProxy(t, ViaProxyAddr{Addr: addr, URL: u})
+
+		if want, got := addr.String(), <-targets; want != got {
+			t.Errorf("want proxied target %q, got %q", want, got)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		t.Parallel()
+
+		targets := make(chan string, 1)
+		proxy := mustProxy(t, func(conn net.Conn) net.Conn { return httpAccept(t, conn, targets) })
+
+		u := &url.URL{Scheme: "http", Host: proxy}
+		testViaProxy(t, ViaProxyAddr{Addr: addr, URL: u})
+
+		if want, got := addr.String(), <-targets; want != got {
+			t.Errorf("want proxied target %q, got %q", want, got)
+		}
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		t.Parallel()
+
+		udpAddr, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := &url.URL{Scheme: "socks5", Host: "127.0.0.1:1"}
+		_, err = new(Transport).DialAddr(context.Background(), ViaProxyAddr{Addr: udpAddr, URL: u})
+		if want, got := ErrUnsupportedNetwork, err; want != got {
+			t.Errorf("want error %v, got %v", want, got)
+		}
+	})
+}
+
+func testViaProxy(t *testing.T, addr net.Addr) {
+	client := &Client{
+		Transport: &Transport{DisablePipelining: true},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := client.Do(ctx, &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+}
+
+// mustProxy serves a proxy that connects each client with the connection
+// returned by accept.
+func mustProxy(t *testing.T, accept func(net.Conn) net.Conn) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				upstream := accept(conn)
+				if upstream == nil {
+					return
+				}
+				defer upstream.Close()
+
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func socks5Accept(t *testing.T, conn net.Conn, targets chan<- string) net.Conn {
+	buf := make([]byte, 262)
+
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil || buf[2] != socks5UserPass {
+		t.Errorf("want username/password method, got %v (%v)", buf[:3], err)
+		return nil
+	}
+	conn.Write([]byte{socks5Version, socks5UserPass})
+
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		t.Error(err)
+		return nil
+	}
+	user := make([]byte, buf[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, buf[:1])
+	pass := make([]byte, buf[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "user" || string(pass) != "pass" {
+		t.Errorf("want credentials user:pass, got %s:%s", user, pass)
+		conn.Write([]byte{0x01, 0x01})
+		return nil
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[3] != socks5IPv4 && buf[3] != socks5IPv6 {
+		t.Errorf("want IP connect request, got %v (%v)", buf[:4], err)
+		return nil
+	}
+	ip := make(net.IP, net.IPv4len)
+	if buf[3] == socks5IPv6 {
+		ip = make(net.IP, net.IPv6len)
+	}
+	io.ReadFull(conn, ip)
+	io.ReadFull(conn, buf[:2])
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(int(nbo.Uint16(buf[:2]))))
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{socks5Version, 0x05, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+		return nil
+	}
+	targets <- target
+
+	conn.Write([]byte{socks5Version, 0x00, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return upstream
+}
+
+func httpAccept(t *testing.T, conn net.Conn, targets chan<- string) net.Conn {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if req.Method != http.MethodConnect {
+		t.Errorf("want CONNECT request, got %s", req.Method)
+		return nil
+	}
+
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return nil
+	}
+	targets <- req.Host
+
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return upstream
+}
@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"strings"
+)
+
+// maxNameLen is the maximum length of a domain name in presentation format,
+// the trailing dot included: 255 octets on the wire, see RFC 1035, section
+// 3.1.
+const maxNameLen = 254
+
+// dname returns the answers for question q of a name below the DNAME record
+// at the key cut: the DNAME, the CNAME synthesized from it, and, if recursion
+// is desired, the answers for the target of the CNAME in the zone, see RFC
+// 6672, section 3.1. It returns keys with the keys read, and false if the
+// synthesized name is too long, with the DNAME as the only answer.
+func (z *Zone) dname(r *Query, q Question, cut string, keys []string, volatile *bool) ([]Resource, []string, bool) {
+	answers := z.answers(r, z.fqdn(cut), q.Class, z.store().GetRecords(cut, TypeDNAME), volatile)
+	if len(answers) == 0 {
+		return nil, keys, true
+	}
+
+	// the owner keeps the case of the question, and the target the case of
+	// the DNAME
+	name := strings.TrimSuffix(q.Name, ".") + "."
+	prefix := name[:len(name)-len(z.fqdn(cut))]
+
+	dname := answers[0]
+	target := dname.Record.(*DNAME).DNAME
+	if target = strings.TrimSuffix(target, "."); target != "" {
+		target = prefix + target + "."
+	} else {
+		target = prefix
+	}
+
+	if len(target) > maxNameLen {
+		return answers[:1], keys, false
+	}
+
+	answers = append(answers, Resource{
+		Name:   name,
+		Class:  dname.Class,
+		TTL:    dname.TTL,
+		Record: &CNAME{CNAME: target},
+	})
+
+	if r.RecursionDesired && q.Type != TypeALL && q.Type != TypeCNAME {
+		var chased []Resource
+		chased, keys = z.chase(r, q, target, keys, volatile)
+		answers = append(answers, chased...)
+	}
+	return answers, keys, true
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZoneDNAME(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "dname.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.dname.dev.", MBox: "hostmaster.dname.dev."},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"old":   {TypeDNAME: {&DNAME{DNAME: "new.dname.dev."}}},
+			"ext":   {TypeDNAME: {&DNAME{DNAME: "example.net."}}},
+			"long":  {TypeDNAME: {&DNAME{DNAME: strings.Repeat("b", 60) + ".example.net."}}},
+			"a.new": {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}),
+	}
+
+	long := strings.Repeat(strings.Repeat("a", 63)+".", 3) + "long.dname.dev."
+
+	tests := []struct {
+		name string
+
+		question  Question
+		recursion bool
+		rcode     RCode
+		answers   []string
+	}{
+		{
+			name:     "synthesized",
+			question: Question{Name: "a.old.dname.dev.", Type: TypeA, Class: ClassIN},
+			answers:  []string{"old.dname.dev. new.dname.dev.", "a.old.dname.dev. a.new.dname.dev."},
+		},
+		{
+			name:      "chased",
+			question:  Question{Name: "A.Old.dname.dev.", Type: TypeA, Class: ClassIN},
+			recursion: true,
+			answers: []string{
+				"old.dname.dev. new.dname.dev.",
+				"A.Old.dname.dev. A.new.dname.dev.",
+				"A.new.dname.dev. 192.0.2.1",
+			},
+		},
+		{
+			name:      "out of the zone",
+			question:  Question{Name: "b.a.ext.dname.dev.", Type: TypeA, Class: ClassIN},
+			recursion: true,
+			answers:   []string{"ext.dname.dev. example.net.", "b.a.ext.dname.dev. b.a.example.net."},
+		},
+		{
+			name:     "name too long",
+			question: Question{Name: long, Type: TypeA, Class: ClassIN},
+			rcode:    YXDomain,
+			answers:  []string{"long.dname.dev. " + strings.Repeat("b", 60) + ".example.net."},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{Message: &Message{RecursionDesired: test.recursion, Questions: []Question{test.question}}}
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			zone.ServeDNS(context.Background(), w, q)
+
+			if want, got := test.rcode, w.msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+
+			var answers []string
+			for _, res := range w.msg.Answers {
+				switch rr := res.Record.(type) {
+				case *DNAME:
+					answers = append(answers, res.Name+" "+rr.DNAME)
+				case *CNAME:
+					answers = append(answers, res.Name+" "+rr.CNAME)
+				case *A:
+					answers = append(answers, res.Name+" "+rr.A.String())
+				}
+			}
+			if want, got := test.answers, answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %q, got %q", want, got)
+			}
+		})
+	}
+}
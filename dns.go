@@ -24,6 +24,26 @@ var (
 
 	// ErrUnsupportedOp indicates the operation is not supported by callee.
 	ErrUnsupportedOp = errors.New("unsupported operation")
+
+	// ErrOverloaded is returned when a query is rejected because all the
+	// workers of a WorkerPool are busy.
+	ErrOverloaded = errors.New("overloaded")
+
+	// ErrForwardingLoop is returned when a query forwarded by a Server would
+	// be sent back to the address it was received on.
+	ErrForwardingLoop = errors.New("forwarding loop")
+
+	// ErrUpstreamLimit is returned when a query forwarded by a Server exceeds
+	// its MaxUpstreamQueries.
+	ErrUpstreamLimit = errors.New("too many upstream queries")
+
+	// ErrCNAMEChain is returned when the response to a query forwarded by a
+	// Server has a CNAME chain longer than its MaxCNAMEChain.
+	ErrCNAMEChain = errors.New("CNAME chain too long")
+
+	// ErrReservedBits is returned by a Client for a response with reserved
+	// header bits or EDNS flags set, as per its ReservedBits policy.
+	ErrReservedBits = errors.New("reserved bits set")
 )
 
 // AddrDialer dials a net Addr.
@@ -37,6 +57,22 @@ type Query struct {
 
 	// RemoteAddr is the address of a DNS resolver.
 	RemoteAddr net.Addr
+
+	// LocalAddr is the address of the Server the query was received on. It
+	// is nil for queries not received by a Server.
+	LocalAddr net.Addr
+
+	// Raw is the packed message as it was read from the connection, without
+	// any transport framing. It is nil for queries not received by a Server.
+	Raw []byte
+
+	// Identity is the identity of a client that presented a verified TLS
+	// certificate to ServeTLS. It is nil for other queries.
+	Identity *ClientIdentity
+
+	// ServerName is the server name requested by the client of a TLS
+	// connection (SNI) to ServeTLS. It is empty for other queries.
+	ServerName string
 }
 
 // OverTLSAddr indicates the remote DNS service implements DNS-over-TLS as
@@ -58,3 +94,13 @@ type ProxyFunc func(context.Context, net.Addr) (net.Addr, error)
 type RoundTripper interface {
 	Do(context.Context, *Query) (*Message, error)
 }
+
+// The RoundTripperFunc type is an adapter to allow the use of ordinary
+// functions as RoundTrippers. If f is a function with the appropriate
+// signature, RoundTripperFunc(f) is a RoundTripper that calls f.
+type RoundTripperFunc func(context.Context, *Query) (*Message, error)
+
+// Do calls f(ctx, query).
+func (f RoundTripperFunc) Do(ctx context.Context, query *Query) (*Message, error) {
+	return f(ctx, query)
+}
@@ -0,0 +1,260 @@
+package dnstest
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// packet is a datagram, or a chunk of a stream, in flight on the in-memory
+// network.
+type packet struct {
+	data []byte
+	addr net.Addr // sender
+}
+
+// queue holds the packets delivered to a conn until they are read.
+type queue struct {
+	mu      sync.Mutex
+	packets []packet
+	closed  bool
+	readyc  chan struct{} // closed when a packet is pushed or the queue closed
+}
+
+func newQueue() *queue {
+	return &queue{readyc: make(chan struct{})}
+}
+
+func (q *queue) push(p packet) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.packets = append(q.packets, p)
+
+	close(q.readyc)
+	q.readyc = make(chan struct{})
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.readyc)
+	}
+}
+
+// pop reads the next packet into b, or returns the channel signaled once there
+// is one. A stream keeps the rest of a packet larger than b for the next read,
+// a datagram is truncated.
+func (q *queue) pop(b []byte, stream bool) (int, net.Addr, <-chan struct{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.packets) == 0 {
+		if q.closed {
+			return 0, nil, nil, io.EOF
+		}
+		return 0, nil, q.readyc, nil
+	}
+
+	p := q.packets[0]
+	n := copy(b, p.data)
+	if stream && n < len(p.data) {
+		q.packets[0].data = p.data[n:]
+	} else {
+		q.packets = q.packets[1:]
+	}
+	return n, p.addr, nil, nil
+}
+
+// deadline is a read or write deadline of a conn.
+type deadline struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	c     chan struct{} // closed once the deadline is exceeded
+}
+
+func newDeadline() *deadline {
+	return &deadline{c: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.c // the timer closed c
+	}
+	d.timer = nil
+
+	select {
+	case <-d.c:
+		d.c = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		c := d.c
+		d.timer = time.AfterFunc(dur, func() { close(c) })
+		return
+	}
+	close(d.c)
+}
+
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.c
+}
+
+// conn is an endpoint of the in-memory network. Written data is passed to
+// send, and data delivered to in is read. A conn of datagrams is wrapped in a
+// packetConn.
+type conn struct {
+	laddr, raddr net.Addr
+
+	stream bool
+	in     *queue
+	send   func(b []byte, to net.Addr) error
+
+	rdl, wdl *deadline
+
+	closeo  sync.Once
+	closec  chan struct{}
+	onClose func()
+}
+
+func newConn(laddr, raddr net.Addr, stream bool, send func([]byte, net.Addr) error) *conn {
+	return &conn{
+		laddr:  laddr,
+		raddr:  raddr,
+		stream: stream,
+		in:     newQueue(),
+		send:   send,
+		rdl:    newDeadline(),
+		wdl:    newDeadline(),
+		closec: make(chan struct{}),
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, _, err := c.readFrom(b)
+	return n, err
+}
+
+func (c *conn) readFrom(b []byte) (int, net.Addr, error) {
+	for {
+		select {
+		case <-c.closec:
+			return 0, nil, net.ErrClosed
+		case <-c.rdl.wait():
+			return 0, nil, os.ErrDeadlineExceeded
+		default:
+		}
+
+		n, addr, readyc, err := c.in.pop(b, c.stream)
+		if readyc == nil {
+			return n, addr, err
+		}
+
+		select {
+		case <-readyc:
+		case <-c.closec:
+		case <-c.rdl.wait():
+		}
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	return c.writeTo(b, c.raddr)
+}
+
+func (c *conn) writeTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closec:
+		return 0, net.ErrClosed
+	case <-c.wdl.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	if err := c.send(append([]byte(nil), b...), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *conn) Close() error {
+	c.closeo.Do(func() {
+		close(c.closec)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.laddr }
+func (c *conn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.rdl.set(t)
+	c.wdl.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.rdl.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.wdl.set(t)
+	return nil
+}
+
+// packetConn is a conn of datagrams, a net.PacketConn.
+type packetConn struct {
+	*conn
+}
+
+func (c packetConn) ReadFrom(b []byte) (int, net.Addr, error) { return c.readFrom(b) }
+
+func (c packetConn) WriteTo(b []byte, addr net.Addr) (int, error) { return c.writeTo(b, addr) }
+
+// listener is the in-memory Listener of a server, accepting the stream conns
+// dialed to it.
+type listener struct {
+	addr net.Addr
+
+	connc  chan net.Conn
+	closeo sync.Once
+	closec chan struct{}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connc:
+		return conn, nil
+	case <-l.closec:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeo.Do(func() { close(l.closec) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,109 @@
+package dnstest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/helmutkemper/dns"
+)
+
+// RoundTrip decodes the hex encoded wire message vector, unpacks it and packs
+// it again, and returns an error unless the packed message is the same bytes,
+// with or without name compression. The message must be packed as package dns
+// packs it, so a compressed vector must use its compression pointers.
+//
+// Whitespace in vector is ignored, as are comments from a '#' to the end of a
+// line, so that vectors can be laid out by section. Records of custom types
+// are unpacked once registered in dns.NewRecordByType.
+func RoundTrip(vector string) error {
+	raw, err := decodeVector(vector)
+	if err != nil {
+		return err
+	}
+
+	msg := new(dns.Message)
+	rest, err := msg.Unpack(raw)
+	if err != nil {
+		return fmt.Errorf("unpack: %w", err)
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("unpack: %d trailing bytes", len(rest))
+	}
+
+	compressed, err := msg.Pack(nil, true)
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	if bytes.Equal(raw, compressed) {
+		return nil
+	}
+
+	uncompressed, err := msg.Pack(nil, false)
+	if err != nil {
+		return fmt.Errorf("pack: %w", err)
+	}
+	if bytes.Equal(raw, uncompressed) {
+		return nil
+	}
+
+	want := compressed
+	if len(raw) == len(uncompressed) {
+		want = uncompressed
+	}
+	return fmt.Errorf("round trip: %s", diff(raw, want))
+}
+
+// TestVectors reports to t the vectors failing RoundTrip.
+func TestVectors(t testing.TB, vectors ...string) {
+	t.Helper()
+
+	for i, vector := range vectors {
+		if err := RoundTrip(vector); err != nil {
+			t.Errorf("vector %d: %v", i, err)
+		}
+	}
+}
+
+var errOddVector = errors.New("odd number of hex digits")
+
+// decodeVector returns the bytes of the hex encoded vector, without its
+// whitespace and comments.
+func decodeVector(vector string) ([]byte, error) {
+	var digits strings.Builder
+	for _, line := range strings.Split(vector, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, f := range strings.Fields(line) {
+			digits.WriteString(f)
+		}
+	}
+
+	if digits.Len()%2 != 0 {
+		return nil, errOddVector
+	}
+	return hex.DecodeString(digits.String())
+}
+
+// diff describes the first difference of the vector got and the packed
+// message want.
+func diff(got, want []byte) string {
+	n := len(got)
+	if len(want) < n {
+		n = len(want)
+	}
+
+	i := 0
+	for i < n && got[i] == want[i] {
+		i++
+	}
+
+	if i < n {
+		return fmt.Sprintf("byte %d of the vector is %#02x, packed %#02x", i, got[i], want[i])
+	}
+	return fmt.Sprintf("vector is %d bytes, packed %d", len(got), len(want))
+}
@@ -0,0 +1,91 @@
+package dnstest
+
+import (
+	"strings"
+	"testing"
+)
+
+// golden are wire messages of the built-in record types.
+var golden = []string{
+	`# query example.com. IN A
+	1234 0100 0001 0000 0000 0000
+	076578616d706c6503636f6d00 0001 0001`,
+
+	`# response example.com. 300 IN A 93.184.216.34
+	1234 8180 0001 0001 0000 0000
+	076578616d706c6503636f6d00 0001 0001
+	c00c 0001 0001 0000012c 0004 5db8d822`,
+
+	`# the same response, without name compression
+	1234 8180 0001 0001 0000 0000
+	076578616d706c6503636f6d00 0001 0001
+	076578616d706c6503636f6d00 0001 0001 0000012c 0004 5db8d822`,
+
+	`# example.com. 3600 IN MX 10 mail.example.com.
+	beef 8400 0001 0001 0000 0000
+	076578616d706c6503636f6d00 000f 0001
+	c00c 000f 0001 00000e10 0009 000a 046d61696cc00c`,
+
+	`# NXDOMAIN none.example.com. IN AAAA, with the SOA of example.com.
+	0001 8403 0001 0000 0001 0000
+	046e6f6e65076578616d706c6503636f6d00 001c 0001
+	c011 0006 0001 00000e10 0026
+	026e73c011 0a686f73746d6173746572c011
+	78a3f175 00001c20 00000e10 00127500 0000012c`,
+
+	`# example.com. 60 IN TXT "v=spf1 -all"
+	0002 8000 0001 0001 0000 0000
+	076578616d706c6503636f6d00 0010 0001
+	c00c 0010 0001 0000003c 000c 0b763d73706631202d616c6c`,
+}
+
+func TestGoldenVectors(t *testing.T) {
+	t.Parallel()
+
+	TestVectors(t, golden...)
+}
+
+func TestRoundTripErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		vector string
+		err    string
+	}{
+		{
+			name:   "odd digits",
+			vector: "123",
+			err:    errOddVector.Error(),
+		},
+		{
+			name:   "trailing bytes",
+			vector: golden[0] + " ff",
+			err:    "1 trailing bytes",
+		},
+		{
+			name:   "truncated",
+			vector: "1234 0100 0001",
+			err:    "unpack",
+		},
+		{
+			name: "partial compression",
+			vector: `beef 8400 0001 0001 0000 0000
+			076578616d706c6503636f6d00 000f 0001
+			076578616d706c6503636f6d00 000f 0001 00000e10 0009 000a 046d61696cc00c`,
+			err: "byte 29 of the vector",
+		},
+	}
+
+	for _, test := range tests {
+		err := RoundTrip(test.vector)
+		if err == nil {
+			t.Errorf("%s: want error", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: want error %q, got %q", test.name, test.err, err)
+		}
+	}
+}
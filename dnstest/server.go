@@ -0,0 +1,314 @@
+// Package dnstest provides an in-memory DNS server for testing the clients and
+// handlers of package dns, with scripted faults in the delivery of its
+// responses.
+package dnstest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+// Behavior is a fault applied to the delivery of a response. The zero value
+// delivers the response as written by the server.
+type Behavior struct {
+	// Delay delays the delivery of the response.
+	Delay time.Duration
+
+	// Drop drops the response.
+	Drop bool
+
+	// Truncate replaces the response with an empty one with the TC bit set.
+	Truncate bool
+
+	// WrongID changes the message ID of the response.
+	WrongID bool
+
+	// RCode, if not NoError, replaces the response with an empty one with
+	// this RCODE, such as dns.FormErr.
+	RCode dns.RCode
+
+	// Duplicates is the number of extra copies of the response delivered.
+	Duplicates int
+}
+
+// Server is a DNS server on an in-memory network, for end to end tests of
+// clients without sockets. Its Transport and Client dial it over UDP or TCP
+// at the addresses of UDPAddr and TCPAddr.
+type Server struct {
+	// Config is the served dns.Server, which may be changed before Start
+	// by a Server from NewUnstartedServer. Its Addr is ignored.
+	Config *dns.Server
+
+	// Default is the behavior of the responses not covered by a Script.
+	Default Behavior
+
+	UDPAddr *net.UDPAddr
+	TCPAddr *net.TCPAddr
+
+	ln    *listener
+	pconn *conn
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	script  []Behavior
+	clients map[string]*conn // UDP clients by address
+	queries int
+	ports   int
+}
+
+var errRefused = &net.OpError{Op: "dial", Net: "memory", Err: syscall.ECONNREFUSED}
+
+// NewServer starts and returns a server serving handler.
+func NewServer(handler dns.Handler) *Server {
+	s := NewUnstartedServer(handler)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server serving handler, which is started with
+// Start.
+func NewUnstartedServer(handler dns.Handler) *Server {
+	s := &Server{
+		Config:  &dns.Server{Handler: handler},
+		UDPAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53},
+		TCPAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 53), Port: 53},
+		clients: make(map[string]*conn),
+	}
+
+	s.ln = &listener{
+		addr:   s.TCPAddr,
+		connc:  make(chan net.Conn),
+		closec: make(chan struct{}),
+	}
+	s.pconn = newConn(s.UDPAddr, nil, false, s.sendPacket)
+	return s
+}
+
+// Start starts serving the queries sent to the server.
+func (s *Server) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.Config.ServePacket(ctx, packetConn{s.pconn})
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.Config.Serve(ctx, s.ln)
+	}()
+}
+
+// Close stops the server and waits for it to return.
+func (s *Server) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.ln.Close()
+	s.pconn.Close()
+	s.wg.Wait()
+}
+
+// Script sets the behaviors of the next responses, one per response in order.
+// Once they are used, the following responses have the Default behavior.
+func (s *Server) Script(behaviors ...Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.script = append(s.script[:0:0], behaviors...)
+}
+
+// Queries returns the number of queries received by the server.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queries
+}
+
+// Transport returns a dns.Transport dialing the server over the in-memory
+// network. Pipelining is disabled, so that each query has its own connection.
+func (s *Server) Transport() *dns.Transport {
+	return &dns.Transport{
+		DialContext:       s.DialContext,
+		DisablePipelining: true,
+	}
+}
+
+// Client returns a dns.Client sending its queries to the server.
+func (s *Server) Client() *dns.Client {
+	return &dns.Client{Transport: s.Transport()}
+}
+
+// DialContext dials the server at address over the in-memory network. The
+// dial is refused for any other address.
+func (s *Server) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	s.mu.Lock()
+	s.ports++
+	laddr := net.IPv4(192, 0, 2, 1)
+	port := 49152 + s.ports%16384
+	s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(network, "udp") && address == s.UDPAddr.String():
+		c := newConn(&net.UDPAddr{IP: laddr, Port: port}, s.UDPAddr, false, nil)
+		c.send = func(b []byte, _ net.Addr) error {
+			s.count()
+			s.pconn.in.push(packet{data: b, addr: c.laddr})
+			return nil
+		}
+
+		s.mu.Lock()
+		s.clients[c.laddr.String()] = c
+		s.mu.Unlock()
+
+		c.onClose = func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			delete(s.clients, c.laddr.String())
+		}
+		return packetConn{c}, nil
+	case strings.HasPrefix(network, "tcp") && address == s.TCPAddr.String():
+		caddr := &net.TCPAddr{IP: laddr, Port: port}
+
+		client := newConn(caddr, s.TCPAddr, true, nil)
+		server := newConn(s.TCPAddr, caddr, true, nil)
+
+		client.send = func(b []byte, _ net.Addr) error {
+			if len(b) > 2 {
+				s.count() // a query is written at once, with its length prefix
+			}
+			server.in.push(packet{data: b})
+			return nil
+		}
+		server.send = func(b []byte, _ net.Addr) error {
+			s.sendStream(client, b)
+			return nil
+		}
+		client.onClose = server.in.close
+		server.onClose = client.in.close
+
+		select {
+		case s.ln.connc <- server:
+			return client, nil
+		case <-s.ln.closec:
+			return nil, errRefused
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errRefused
+}
+
+// next returns the behavior of the next response.
+func (s *Server) next() Behavior {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.script) == 0 {
+		return s.Default
+	}
+
+	b := s.script[0]
+	s.script = s.script[1:]
+	return b
+}
+
+// sendPacket delivers the UDP response b to the client at addr.
+func (s *Server) sendPacket(b []byte, addr net.Addr) error {
+	s.mu.Lock()
+	c := s.clients[addr.String()]
+	s.mu.Unlock()
+
+	if c == nil {
+		return nil // a client gone, as a datagram lost
+	}
+
+	bhv := s.next()
+	bufs := apply(bhv, b)
+	if len(bufs) == 0 {
+		return nil
+	}
+
+	deliver := func() {
+		for _, b := range bufs {
+			c.in.push(packet{data: b, addr: s.UDPAddr})
+		}
+	}
+	if bhv.Delay > 0 {
+		time.AfterFunc(bhv.Delay, deliver)
+		return nil
+	}
+	deliver()
+	return nil
+}
+
+// sendStream delivers the length prefixed TCP response b to client. A delay
+// holds the connection, as the responses of a stream are in order.
+func (s *Server) sendStream(client *conn, b []byte) {
+	if len(b) < 2 || int(b[0])<<8|int(b[1]) != len(b)-2 {
+		client.in.push(packet{data: b}) // not a whole message
+		return
+	}
+
+	bhv := s.next()
+	if bhv.Delay > 0 {
+		time.Sleep(bhv.Delay)
+	}
+
+	for _, msg := range apply(bhv, b[2:]) {
+		buf := make([]byte, 2, 2+len(msg))
+		buf[0], buf[1] = byte(len(msg)>>8), byte(len(msg))
+		client.in.push(packet{data: append(buf, msg...)})
+	}
+}
+
+// apply returns the messages delivered for the response b with behavior bhv.
+func apply(bhv Behavior, b []byte) [][]byte {
+	if bhv.Drop {
+		return nil
+	}
+
+	if bhv.Truncate || bhv.WrongID || bhv.RCode != dns.NoError {
+		msg := new(dns.Message)
+		if _, err := msg.Unpack(b); err == nil {
+			if bhv.Truncate || bhv.RCode != dns.NoError {
+				msg.Answers, msg.Authorities, msg.Additionals = nil, nil, nil
+				msg.Truncated = bhv.Truncate
+				if bhv.RCode != dns.NoError {
+					msg.RCode = bhv.RCode
+				}
+			}
+			if bhv.WrongID {
+				msg.ID = (msg.ID + 1) & 0xFFFF
+			}
+
+			if buf, err := msg.Pack(nil, true); err == nil {
+				b = buf
+			}
+		}
+	}
+
+	bufs := make([][]byte, 1+bhv.Duplicates)
+	for i := range bufs {
+		bufs[i] = b
+	}
+	return bufs
+}
+
+func (s *Server) count() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries++
+}
@@ -0,0 +1,150 @@
+package dnstest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+)
+
+var question = dns.Question{Name: "app.dev.", Type: dns.TypeA, Class: dns.ClassIN}
+
+func mustServer(t *testing.T) *Server {
+	srv := NewServer(dns.HandlerFunc(func(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+		w.Answer(r.Questions[0].Name, time.Minute, &dns.A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestServerBehaviors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		network  string
+		behavior Behavior
+
+		err       bool
+		truncated bool
+		rcode     dns.RCode
+		answers   int
+		delay     time.Duration
+	}{
+		{name: "udp", network: "udp", answers: 1},
+		{name: "tcp", network: "tcp", answers: 1},
+		{name: "drop", network: "udp", behavior: Behavior{Drop: true}, err: true},
+		{name: "truncate", network: "udp", behavior: Behavior{Truncate: true}, truncated: true},
+		{name: "formerr", network: "udp", behavior: Behavior{RCode: dns.FormErr}, rcode: dns.FormErr},
+		{name: "tcp formerr", network: "tcp", behavior: Behavior{RCode: dns.FormErr}, rcode: dns.FormErr},
+		{
+			name:     "delay",
+			network:  "udp",
+			behavior: Behavior{Delay: 50 * time.Millisecond},
+			answers:  1,
+			delay:    50 * time.Millisecond,
+		},
+		{
+			name:     "tcp delay",
+			network:  "tcp",
+			behavior: Behavior{Delay: 50 * time.Millisecond},
+			answers:  1,
+			delay:    50 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := mustServer(t)
+			srv.Script(test.behavior)
+
+			var addr net.Addr = srv.UDPAddr
+			if test.network == "tcp" {
+				addr = srv.TCPAddr
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			msg, err := srv.Client().Do(ctx, &dns.Query{
+				RemoteAddr: addr,
+				Message:    &dns.Message{Questions: []dns.Question{question}},
+			})
+			if test.err {
+				if err == nil {
+					t.Error("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.truncated, msg.Truncated; want != got {
+				t.Errorf("want truncated %t, got %t", want, got)
+			}
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if want, got := test.answers, len(msg.Answers); want != got {
+				t.Errorf("want %d answers, got %d", want, got)
+			}
+			if elapsed := time.Since(start); elapsed < test.delay {
+				t.Errorf("want response after %v, got %v", test.delay, elapsed)
+			}
+			if want, got := 1, srv.Queries(); want != got {
+				t.Errorf("want %d queries, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestServerWrongIDAndDuplicates(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(t)
+	srv.Script(Behavior{WrongID: true, Duplicates: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := srv.DialContext(ctx, "udp", srv.UDPAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	pconn := &dns.PacketConn{Conn: conn}
+	if err := pconn.Send(&dns.Message{ID: 7, Questions: []dns.Question{question}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg := new(dns.Message)
+		if err := pconn.Recv(msg); err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if want, got := 8, msg.ID; want != got {
+			t.Errorf("response %d: want ID %d, got %d", i, want, got)
+		}
+	}
+}
+
+func TestServerDialRefused(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(t)
+
+	if _, err := srv.DialContext(context.Background(), "udp", "192.0.2.54:53"); err == nil {
+		t.Error("want dial refused for another address")
+	}
+}
@@ -43,12 +43,12 @@ A handler answers queries for a server or a local resolver for a client:
 	zone := &dns.Zone{
 		Origin: "localhost.",
 		TTL:    5 * time.Minute,
-		RRs: dns.RRSet{
+		RRs: dns.NewRRSet(map[string]map[dns.Type][]dns.Record{
 			"alpha": []dns.Record{
 				&dns.A{net.IPv4(127, 0, 0, 42).To4()},
 				&dns.AAAA{net.ParseIP("::42")},
 			},
-		},
+		}),
 	}
 
 	srv := &dns.Server{
@@ -0,0 +1,423 @@
+// Package docker keeps the address records of the running containers of a
+// Docker engine in a Zone, and the SRV records of their services, as set by
+// the labels of the containers. It follows the events of the engine API, so
+// the records come and go with the containers.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+const (
+	defaultHost     = "unix:///var/run/docker.sock"
+	defaultLabel    = "dns.name"
+	defaultSRVLabel = "dns.srv"
+	defaultTTL      = 30 * time.Second
+	defaultRetry    = time.Second
+	maxRetry        = time.Minute
+)
+
+var errEngine = errors.New("docker engine request failed")
+
+// Watcher maintains the records of the containers of a Docker engine in a
+// Zone. A running container with the Label gets the A and AAAA records of its
+// addresses at each of the names of the label, a comma separated list of
+// names absolute, such as "web.example.com.", or relative to the origin of
+// the zone, such as "web". A container with the SRVLabel also gets the SRV
+// records of its services at the first of its names, the label being a comma
+// separated list of services and their port in the container, such as
+// "_http._tcp:80,_https._tcp:443".
+//
+// The records of the watcher are stored as entries with the "docker" source,
+// next to the other records of the zone.
+type Watcher struct {
+	Zone *dns.Zone
+
+	// Host is the address of the engine API, such as
+	// "unix:///var/run/docker.sock" or "tcp://10.0.0.2:2375". If empty,
+	// the DOCKER_HOST environment variable is used, or the default socket
+	// of the engine.
+	Host string
+
+	// Client, if not nil, sends the requests to the engine, such as one
+	// with the TLS configuration of a remote engine. Its Transport is
+	// then used as is, whatever the Host.
+	Client *http.Client
+
+	// Label is the label of the names of a container. If empty, "dns.name"
+	// is used.
+	Label string
+
+	// SRVLabel is the label of the services of a container. If empty,
+	// "dns.srv" is used.
+	SRVLabel string
+
+	// Network, if not empty, is the only network of the containers whose
+	// addresses are published, such as that of a reverse proxy.
+	Network string
+
+	// HostAddrs, if not empty, are the addresses published for every
+	// container in place of its own, those of the host of the engine, and
+	// the ports of the SRV records are the host ports the services are
+	// published on. The services without a published port are left out.
+	HostAddrs []net.IP
+
+	// TTL is the TTL of the records. If zero, 30 seconds is used.
+	TTL time.Duration
+
+	// Logger, if not nil, logs the changes of the records, and the failures
+	// of the requests to the engine.
+	Logger dns.Logger
+
+	mu    sync.Mutex
+	owned map[record]dns.Record // the records in the zone
+}
+
+// record identifies a record of the watcher in the zone.
+type record struct {
+	key  string
+	t    dns.Type
+	data string
+}
+
+// Run syncs the records of the zone with the containers of the engine, and
+// then again on each of the container events of the engine, until ctx is
+// done. A failed request is retried, backing off. Run returns ctx.Err().
+// The records are left in the zone once it returns.
+func (w *Watcher) Run(ctx context.Context) error {
+	retry := defaultRetry
+	for {
+		err := w.Sync(ctx)
+		if err == nil {
+			retry = defaultRetry
+			err = w.follow(ctx)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		w.log(ctx, slog.LevelWarn, "docker watch", "err", err)
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if retry *= 2; retry > maxRetry {
+			retry = maxRetry
+		}
+	}
+}
+
+// follow syncs the records on each container event, until the stream of the
+// events fails or ends.
+func (w *Watcher) follow(ctx context.Context) error {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "stop", "die", "destroy", "pause", "unpause", "rename", "update", "connect", "disconnect"},
+	})
+
+	res, err := w.get(ctx, "/events?filters="+url.QueryEscape(string(filters)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	dec := json.NewDecoder(res.Body)
+	for {
+		var event struct{}
+		if err := dec.Decode(&event); err != nil {
+			return err
+		}
+		if err := w.Sync(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// container is a container of the list of the engine API.
+type container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Sync replaces the records of the watcher in the zone with those of the
+// running containers of the engine.
+func (w *Watcher) Sync(ctx context.Context) error {
+	label := w.Label
+	if label == "" {
+		label = defaultLabel
+	}
+
+	res, err := w.get(ctx, "/containers/json?filters="+url.QueryEscape(`{"label":["`+label+`"]}`))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var containers []container
+	if err := json.NewDecoder(res.Body).Decode(&containers); err != nil {
+		return err
+	}
+
+	want := make(map[record]dns.Record)
+	for _, c := range containers {
+		for _, rr := range w.records(ctx, c) {
+			want[rr.id] = rr.rr
+		}
+	}
+	w.apply(ctx, want)
+	return nil
+}
+
+type ownedRecord struct {
+	id record
+	rr dns.Record
+}
+
+// records returns the records of the container c.
+func (w *Watcher) records(ctx context.Context, c container) []ownedRecord {
+	label, srvLabel := w.Label, w.SRVLabel
+	if label == "" {
+		label = defaultLabel
+	}
+	if srvLabel == "" {
+		srvLabel = defaultSRVLabel
+	}
+
+	var keys, names []string
+	for _, name := range strings.Split(c.Labels[label], ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !strings.HasSuffix(name, ".") {
+			name = strings.TrimSuffix(name+"."+strings.TrimSuffix(w.Zone.Origin, "."), ".") + "."
+		}
+
+		k, ok := w.Zone.NameKey(name)
+		if !ok {
+			w.log(ctx, slog.LevelWarn, "docker watch", "container", c.ID, "name", name, "err", "name out of zone")
+			continue
+		}
+		keys, names = append(keys, k), append(names, name)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var rrs []ownedRecord
+	add := func(k string, rr dns.Record) {
+		rrs = append(rrs, ownedRecord{record{k, rr.Type(), rr.Key()}, rr})
+	}
+
+	for _, ip := range w.addrs(c) {
+		var rr dns.Record = &dns.AAAA{AAAA: ip.To16()}
+		if ip4 := ip.To4(); ip4 != nil {
+			rr = &dns.A{A: ip4}
+		}
+		for _, k := range keys {
+			add(k, rr)
+		}
+	}
+
+	for _, svc := range strings.Split(c.Labels[srvLabel], ",") {
+		if svc = strings.TrimSpace(svc); svc == "" {
+			continue
+		}
+
+		service, portText, _ := strings.Cut(svc, ":")
+		port, err := strconv.Atoi(portText)
+		if err != nil || !strings.HasPrefix(service, "_") {
+			w.log(ctx, slog.LevelWarn, "docker watch", "container", c.ID, "service", svc, "err", "invalid service")
+			continue
+		}
+		if port = w.port(c, service, port); port == 0 {
+			continue
+		}
+
+		k := service
+		if keys[0] != "" {
+			k += "." + keys[0]
+		}
+		add(k, &dns.SRV{Port: port, Target: names[0]})
+	}
+	return rrs
+}
+
+// addrs returns the addresses published for the container c.
+func (w *Watcher) addrs(c container) []net.IP {
+	if len(w.HostAddrs) > 0 {
+		return w.HostAddrs
+	}
+
+	var ips []net.IP
+	for name, network := range c.NetworkSettings.Networks {
+		if w.Network != "" && name != w.Network {
+			continue
+		}
+		for _, s := range []string{network.IPAddress, network.GlobalIPv6Address} {
+			if ip := net.ParseIP(s); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// port returns the port of the SRV record of the service of c on its port,
+// or zero if it is not published.
+func (w *Watcher) port(c container, service string, port int) int {
+	if len(w.HostAddrs) == 0 {
+		return port
+	}
+
+	proto := "tcp"
+	if strings.HasSuffix(service, "._udp") {
+		proto = "udp"
+	}
+	for _, p := range c.Ports {
+		if p.PrivatePort == port && p.PublicPort != 0 && p.Type == proto {
+			return p.PublicPort
+		}
+	}
+	return 0
+}
+
+// apply replaces the records of the watcher in the zone with want.
+func (w *Watcher) apply(ctx context.Context, want map[record]dns.Record) {
+	ttl := w.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var added, removed int
+	w.Zone.Txn(func(tx *dns.RRSetTxn) error {
+		for id, rr := range w.owned {
+			if _, ok := want[id]; !ok {
+				tx.DeleteRecordInKey(id.key, rr)
+				removed++
+			}
+		}
+		for id, rr := range want {
+			if _, ok := w.owned[id]; !ok {
+				tx.AppendRecordInKey(id.key, &dns.RREntry{Record: rr, TTL: ttl, Meta: dns.RRMeta{Source: "docker"}})
+				added++
+			}
+		}
+		return nil
+	})
+	w.owned = want
+
+	if added > 0 || removed > 0 {
+		w.log(ctx, slog.LevelInfo, "docker sync", "added", added, "removed", removed, "records", len(want))
+	}
+}
+
+// get sends the GET request of the path of the engine API.
+func (w *Watcher) get(ctx context.Context, path string) (*http.Response, error) {
+	client, base, err := w.client()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s", errEngine, res.Status)
+	}
+	return res, nil
+}
+
+// client returns the client of the engine API, and the base URL of its
+// requests.
+func (w *Watcher) client() (*http.Client, string, error) {
+	host := w.Host
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if w.Client != nil {
+		if u.Scheme == "unix" {
+			return w.Client, "http://docker", nil
+		}
+		return w.Client, schemeURL(u), nil
+	}
+
+	switch u.Scheme {
+	case "unix":
+		var d net.Dialer
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return d.DialContext(ctx, "unix", u.Path)
+				},
+			},
+		}, "http://docker", nil
+	case "tcp", "http", "https":
+		return http.DefaultClient, schemeURL(u), nil
+	}
+	return nil, "", fmt.Errorf("%w: unsupported host %q", errEngine, host)
+}
+
+// schemeURL returns the base URL of the engine at u, a tcp address being
+// served over http.
+func schemeURL(u *url.URL) string {
+	scheme := u.Scheme
+	if scheme == "tcp" {
+		scheme = "http"
+	}
+	return scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/")
+}
+
+func (w *Watcher) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if w.Logger != nil {
+		w.Logger.Log(ctx, level, msg, args...)
+	}
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns"
+	"github.com/benburkert/dns/internal/zonetest"
+)
+
+// engine is a fake of the engine API, listing its containers and sending an
+// event on each of the changes of its containers.
+type engine struct {
+	mu         sync.Mutex
+	containers []container
+
+	events chan struct{}
+}
+
+func mustEngine(t *testing.T, containers ...container) (*engine, string) {
+	e := &engine{containers: containers, events: make(chan struct{}, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		json.NewEncoder(w).Encode(e.containers)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-e.events:
+				w.Write([]byte(`{"Type":"container","Action":"start"}` + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return e, "tcp://" + srv.Listener.Addr().String()
+}
+
+func (e *engine) set(containers ...container) {
+	e.mu.Lock()
+	e.containers = containers
+	e.mu.Unlock()
+
+	e.events <- struct{}{}
+}
+
+func testContainer(id, names, services, ip string) container {
+	var c container
+	c.ID = id
+	c.Labels = map[string]string{"dns.name": names}
+	if services != "" {
+		c.Labels["dns.srv"] = services
+	}
+	c.NetworkSettings.Networks = map[string]struct {
+		IPAddress         string `json:"IPAddress"`
+		GlobalIPv6Address string `json:"GlobalIPv6Address"`
+	}{"bridge": {IPAddress: ip}}
+	return c
+}
+
+func TestWatcherSync(t *testing.T) {
+	t.Parallel()
+
+	e, host := mustEngine(t,
+		testContainer("1", "web, www.docker.dev., web.other.dev.", "_http._tcp:80", "172.17.0.2"),
+		testContainer("2", "db", "", "172.17.0.3"),
+	)
+
+	z := zonetest.Zone("docker.dev.", map[string]map[dns.Type][]dns.Record{
+		"web": {dns.TypeTXT: {&dns.TXT{TXT: []string{"static"}}}},
+	})
+	w := &Watcher{Zone: z, Host: host}
+	if err := w.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		k    string
+		t    dns.Type
+		want []dns.Record
+	}{
+		{"web", dns.TypeA, []dns.Record{&dns.A{A: net.IPv4(172, 17, 0, 2).To4()}}},
+		{"www", dns.TypeA, []dns.Record{&dns.A{A: net.IPv4(172, 17, 0, 2).To4()}}},
+		{"db", dns.TypeA, []dns.Record{&dns.A{A: net.IPv4(172, 17, 0, 3).To4()}}},
+		{"_http._tcp.web", dns.TypeSRV, []dns.Record{&dns.SRV{Port: 80, Target: "web.docker.dev."}}},
+		{"web", dns.TypeTXT, []dns.Record{&dns.TXT{TXT: []string{"static"}}}},
+	}
+	for _, test := range tests {
+		if want, got := test.want, zonetest.Records(z, test.k, test.t); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want records %v, got %v", test.k, want, got)
+		}
+	}
+	if got := z.GetEntries("db", dns.TypeA); len(got) != 1 || got[0].Meta.Source != "docker" || got[0].TTL != defaultTTL {
+		t.Errorf("want a docker entry, got %+v", got)
+	}
+
+	e.set(testContainer("2", "db", "", "172.17.0.4"))
+	<-e.events // no watch in progress
+	if err := w.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := zonetest.Records(z, "web", dns.TypeA); len(got) != 0 {
+		t.Errorf("want the records of a stopped container removed, got %v", got)
+	}
+	if want, got := []dns.Record{&dns.A{A: net.IPv4(172, 17, 0, 4).To4()}}, zonetest.Records(z, "db", dns.TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if want, got := []dns.Record{&dns.TXT{TXT: []string{"static"}}}, zonetest.Records(z, "web", dns.TypeTXT); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+}
+
+func TestWatcherHostAddrs(t *testing.T) {
+	t.Parallel()
+
+	c := testContainer("1", "web", "_http._tcp:80,_https._tcp:443", "172.17.0.2")
+	c.Ports = append(c.Ports, struct {
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	}{PrivatePort: 80, PublicPort: 8080, Type: "tcp"})
+	_, host := mustEngine(t, c)
+
+	z := zonetest.Zone("docker.dev.", nil)
+	w := &Watcher{Zone: z, Host: host, HostAddrs: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}}
+	if err := w.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := []dns.Record{&dns.A{A: net.IPv4(192, 0, 2, 1).To4()}}, zonetest.Records(z, "web", dns.TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if want, got := []dns.Record{&dns.AAAA{AAAA: net.ParseIP("2001:db8::1")}}, zonetest.Records(z, "web", dns.TypeAAAA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if want, got := []dns.Record{&dns.SRV{Port: 8080, Target: "web.docker.dev."}}, zonetest.Records(z, "_http._tcp.web", dns.TypeSRV); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+	if got := zonetest.Records(z, "_https._tcp.web", dns.TypeSRV); len(got) != 0 {
+		t.Errorf("want no record of an unpublished service, got %v", got)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	t.Parallel()
+
+	e, host := mustEngine(t, testContainer("1", "web", "", "172.17.0.2"))
+
+	z := zonetest.Zone("docker.dev.", nil)
+	w := &Watcher{Zone: z, Host: host}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- w.Run(ctx) }()
+
+	wait := func(k string, want int) {
+		for deadline := time.Now().Add(5 * time.Second); len(zonetest.Records(z, k, dns.TypeA)) != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: want %d records, got %v", k, want, zonetest.Records(z, k, dns.TypeA))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	wait("web", 1)
+	e.set(testContainer("1", "web", "", "172.17.0.2"), testContainer("2", "db", "", "172.17.0.3"))
+	wait("db", 1)
+	e.set(testContainer("2", "db", "", "172.17.0.3"))
+	wait("web", 0)
+
+	cancel()
+	if want, got := context.Canceled, <-errc; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
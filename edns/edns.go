@@ -31,13 +31,48 @@ const (
 	OptionCodePadding          OptionCode = 12 // Standard [RFC7830]
 	OptionCodeChain            OptionCode = 13 // Standard [RFC7901]
 	OptionCodeEDNSKeyTag       OptionCode = 14 // Optional [RFC8145]
-	// 15-26945	Unassigned
+	OptionCodeExtendedDNSError OptionCode = 15 // Standard [RFC8914]
+	// 16-26945	Unassigned
 	OptionCodeDeviceID OptionCode = 26946 // Optional [https://docs.umbrella.com/developer/networkdevices-api/identifying-dns-traffic2][Brian_Hartvigsen]
 	// 26947-65000	Unassigned
 	// 65001-65534	Reserved for Local/Experimental Use	[RFC6891]
 	// 65535	Reserved for future expansion		[RFC6891]
 )
 
+// An ExtendedErrorCode is an Extended DNS Error INFO-CODE.
+type ExtendedErrorCode uint16
+
+// Extended DNS Error Codes.
+//
+// Taken from https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#extended-dns-error-codes
+const (
+	ExtendedErrorOther                      ExtendedErrorCode = 0  // [RFC8914]
+	ExtendedErrorUnsupportedDNSKEYAlgorithm ExtendedErrorCode = 1  // [RFC8914]
+	ExtendedErrorUnsupportedDSDigestType    ExtendedErrorCode = 2  // [RFC8914]
+	ExtendedErrorStaleAnswer                ExtendedErrorCode = 3  // [RFC8914]
+	ExtendedErrorForgedAnswer               ExtendedErrorCode = 4  // [RFC8914]
+	ExtendedErrorDNSSECIndeterminate        ExtendedErrorCode = 5  // [RFC8914]
+	ExtendedErrorDNSSECBogus                ExtendedErrorCode = 6  // [RFC8914]
+	ExtendedErrorSignatureExpired           ExtendedErrorCode = 7  // [RFC8914]
+	ExtendedErrorSignatureNotYetValid       ExtendedErrorCode = 8  // [RFC8914]
+	ExtendedErrorDNSKEYMissing              ExtendedErrorCode = 9  // [RFC8914]
+	ExtendedErrorRRSIGsMissing              ExtendedErrorCode = 10 // [RFC8914]
+	ExtendedErrorNoZoneKeyBitSet            ExtendedErrorCode = 11 // [RFC8914]
+	ExtendedErrorNSECMissing                ExtendedErrorCode = 12 // [RFC8914]
+	ExtendedErrorCachedError                ExtendedErrorCode = 13 // [RFC8914]
+	ExtendedErrorNotReady                   ExtendedErrorCode = 14 // [RFC8914]
+	ExtendedErrorBlocked                    ExtendedErrorCode = 15 // [RFC8914]
+	ExtendedErrorCensored                   ExtendedErrorCode = 16 // [RFC8914]
+	ExtendedErrorFiltered                   ExtendedErrorCode = 17 // [RFC8914]
+	ExtendedErrorProhibited                 ExtendedErrorCode = 18 // [RFC8914]
+	ExtendedErrorStaleNXDomainAnswer        ExtendedErrorCode = 19 // [RFC8914]
+	ExtendedErrorNotAuthoritative           ExtendedErrorCode = 20 // [RFC8914]
+	ExtendedErrorNotSupported               ExtendedErrorCode = 21 // [RFC8914]
+	ExtendedErrorNoReachableAuthority       ExtendedErrorCode = 22 // [RFC8914]
+	ExtendedErrorNetworkError               ExtendedErrorCode = 23 // [RFC8914]
+	ExtendedErrorInvalidData                ExtendedErrorCode = 24 // [RFC8914]
+)
+
 var errOptionLen = errors.New("insufficient data for option length")
 
 // Option is a EDNS0 option.
@@ -82,3 +117,15 @@ func (o *Option) Unpack(b []byte) ([]byte, error) {
 
 	return b[4+l:], nil
 }
+
+// ExtendedError returns an Extended DNS Error option with the INFO-CODE code
+// and the EXTRA-TEXT text, see RFC 8914.
+func ExtendedError(code ExtendedErrorCode, text string) Option {
+	data := make([]byte, 2, 2+len(text))
+	nbo.PutUint16(data, uint16(code))
+
+	return Option{
+		Code: OptionCodeExtendedDNSError,
+		Data: append(data, text...),
+	}
+}
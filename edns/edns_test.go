@@ -53,6 +53,18 @@ func TestOptionPackUnpack(t *testing.T) {
 				0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E, 0x1F,
 			},
 		},
+		{
+			name: "EDE: no reachable authority",
+
+			opt: ExtendedError(ExtendedErrorNoReachableAuthority, "loop"),
+
+			raw: []byte{
+				0x00, 0x0F, // OPTION-CODE = 15
+				0x00, 0x06, // OPTION-LENGTH = 6
+				0x00, 0x16, // INFO-CODE = 22
+				'l', 'o', 'o', 'p', // EXTRA-TEXT
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,43 @@
+package dns
+
+import "github.com/helmutkemper/dns/edns"
+
+// defaultForwardEDNSOptions are the EDNS options copied into the queries
+// forwarded by a Server without ForwardEDNSOptions.
+var defaultForwardEDNSOptions = []edns.OptionCode{edns.OptionCodeEDNSClientSubnet}
+
+// forwardEDNSOptions returns the additional records rs of a query with the
+// EDNS options of its OPT record not in codes stripped, the OPT record itself
+// kept. rs is returned as is if no option is stripped.
+func forwardEDNSOptions(rs []Resource, codes []edns.OptionCode) []Resource {
+	for i, res := range rs {
+		opt, ok := res.Record.(*OPT)
+		if !ok {
+			continue
+		}
+
+		var options []edns.Option
+		for _, o := range opt.Options {
+			if hasOptionCode(codes, o.Code) {
+				options = append(options, o)
+			}
+		}
+		if len(options) == len(opt.Options) {
+			return rs
+		}
+
+		v := append([]Resource(nil), rs...)
+		v[i].Record = &OPT{Options: options}
+		return v
+	}
+	return rs
+}
+
+func hasOptionCode(codes []edns.OptionCode, code edns.OptionCode) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
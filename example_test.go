@@ -70,7 +70,7 @@ func ExampleServer_authoritative() {
 			MBox:   "hostmaster.tld.",
 			Serial: 1234,
 		},
-		RRs: dns.RRSet{
+		RRs: dns.NewRRSet(map[string]map[dns.Type][]dns.Record{
 			"1.app": {
 				dns.TypeA: {
 					&dns.A{A: net.IPv4(10, 42, 0, 1).To4()},
@@ -107,7 +107,7 @@ func ExampleServer_authoritative() {
 					&dns.AAAA{AAAA: net.ParseIP("dead:beef::3")},
 				},
 			},
-		},
+		}),
 	}
 
 	srv := &dns.Server{
@@ -224,13 +224,13 @@ func ExampleServer_recursive() {
 func ExampleServer_recursiveWithZone() {
 	customTLD := &dns.Zone{
 		Origin: "tld.",
-		RRs: dns.RRSet{
+		RRs: dns.NewRRSet(map[string]map[dns.Type][]dns.Record{
 			"foo": {
 				dns.TypeA: {
 					&dns.A{A: net.IPv4(127, 0, 0, 1).To4()},
 				},
 			},
-		},
+		}),
 	}
 
 	mux := new(dns.ResolveMux)
@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/helmutkemper/dns/edns"
+)
+
+type forwardGuardKey struct{}
+
+// forwardGuard bounds the upstream queries made while a Server serves a
+// query. It is carried by the context of the handler, and checked by the
+// Client and Transport forwarding the query.
+type forwardGuard struct {
+	local net.Addr // address the query was received on
+
+	maxQueries int
+	maxCNAME   int
+
+	queries int32
+
+	mu  sync.Mutex
+	err error // first violation
+}
+
+func withForwardGuard(ctx context.Context, g *forwardGuard) context.Context {
+	return context.WithValue(ctx, forwardGuardKey{}, g)
+}
+
+func forwardGuardFrom(ctx context.Context) *forwardGuard {
+	g, _ := ctx.Value(forwardGuardKey{}).(*forwardGuard)
+	return g
+}
+
+func (g *forwardGuard) fail(err error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err == nil {
+		g.err = err
+	}
+	return err
+}
+
+func (g *forwardGuard) violation() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.err
+}
+
+// upstream counts a query sent upstream.
+func (g *forwardGuard) upstream() error {
+	if g.maxQueries > 0 && int(atomic.AddInt32(&g.queries, 1)) > g.maxQueries {
+		return g.fail(ErrUpstreamLimit)
+	}
+	return nil
+}
+
+// dial checks that addr, about to be dialed, is not the address the query was
+// received on.
+func (g *forwardGuard) dial(addr net.Addr) error {
+	if g.local != nil && sameHostPort(g.local, addr) {
+		return g.fail(ErrForwardingLoop)
+	}
+	return nil
+}
+
+// response checks the CNAME chains of the answers of msg to the questions.
+func (g *forwardGuard) response(questions []Question, msg *Message) error {
+	if g.maxCNAME <= 0 {
+		return nil
+	}
+
+	for _, q := range questions {
+		name := q.Name
+		for n := 0; ; n++ {
+			if n > g.maxCNAME {
+				return g.fail(ErrCNAMEChain)
+			}
+
+			target, ok := cnameOf(name, msg.Answers)
+			if !ok {
+				break
+			}
+			name = target
+		}
+	}
+	return nil
+}
+
+func cnameOf(name string, answers []Resource) (string, bool) {
+	for _, res := range answers {
+		if cname, ok := res.Record.(*CNAME); ok && strings.EqualFold(res.Name, name) {
+			return cname.CNAME, true
+		}
+	}
+	return "", false
+}
+
+// sameHostPort reports whether target is the address local, a listener that
+// may be bound to all the addresses of the host.
+func sameHostPort(local, target net.Addr) bool {
+	lhost, lport, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return false
+	}
+	thost, tport, err := net.SplitHostPort(target.String())
+	if err != nil || lport != tport {
+		return false
+	}
+
+	lip, tip := net.ParseIP(lhost), net.ParseIP(thost)
+	if tip == nil {
+		return false
+	}
+	if lip.Equal(tip) {
+		return true
+	}
+	if lip != nil && !lip.IsUnspecified() {
+		return false
+	}
+	if tip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(tip) {
+			return true
+		}
+	}
+	return false
+}
+
+// extendedError returns the Extended DNS Error option reporting err.
+func extendedError(err error) edns.Option {
+	return edns.ExtendedError(edns.ExtendedErrorOther, err.Error())
+}
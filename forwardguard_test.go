@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/edns"
+)
+
+func TestServerForwardGuard(t *testing.T) {
+	t.Parallel()
+
+	cnames := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer("a.dev.", time.Minute, &CNAME{CNAME: "b.dev."})
+		w.Answer("b.dev.", time.Minute, &CNAME{CNAME: "c.dev."})
+		w.Answer("c.dev.", time.Minute, &CNAME{CNAME: "d.dev."})
+		w.Answer("d.dev.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	})
+
+	recur3 := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		for i := 0; i < 3; i++ {
+			msg, err := w.Recur(ctx)
+			if err != nil {
+				w.Status(ServFail)
+				return
+			}
+			for _, res := range msg.Answers {
+				w.Answer(res.Name, res.TTL, res.Record)
+			}
+		}
+	})
+
+	tests := []struct {
+		name string
+
+		srv *Server
+
+		rcode RCode
+		err   error
+	}{
+		{
+			name: "forwarding loop",
+
+			srv: &Server{
+				Handler: HandlerFunc(Recursor),
+			},
+
+			rcode: ServFail,
+			err:   ErrForwardingLoop,
+		},
+		{
+			name: "upstream limit",
+
+			srv: &Server{
+				Handler:            recur3,
+				Forwarder:          &Client{Transport: nopDialer{}, Resolver: &answerHandler{answers}},
+				MaxUpstreamQueries: 2,
+			},
+
+			rcode: ServFail,
+			err:   ErrUpstreamLimit,
+		},
+		{
+			name: "upstream queries",
+
+			srv: &Server{
+				Handler:            recur3,
+				Forwarder:          &Client{Transport: nopDialer{}, Resolver: &answerHandler{answers}},
+				MaxUpstreamQueries: 3,
+			},
+
+			rcode: NoError,
+		},
+		{
+			name: "CNAME chain limit",
+
+			srv: &Server{
+				Handler:       HandlerFunc(Recursor),
+				Forwarder:     &Client{Transport: nopDialer{}, Resolver: cnames},
+				MaxCNAMEChain: 2,
+			},
+
+			rcode: ServFail,
+			err:   ErrCNAMEChain,
+		},
+		{
+			name: "CNAME chain",
+
+			srv: &Server{
+				Handler:       HandlerFunc(Recursor),
+				Forwarder:     &Client{Transport: nopDialer{}, Resolver: cnames},
+				MaxCNAMEChain: 3,
+			},
+
+			rcode: NoError,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := test.srv
+			srv.Addr = mustUnusedAddr()
+
+			_, port, err := net.SplitHostPort(srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			self, err := net.ResolveUDPAddr("udp", net.JoinHostPort("127.0.0.1", port))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if srv.Forwarder == nil {
+				srv.Forwarder = &Client{
+					Transport: &Transport{
+						Proxy: func(context.Context, net.Addr) (net.Addr, error) {
+							return self, nil
+						},
+					},
+				}
+			}
+			mustStart(srv)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			msg, err := new(Client).Do(ctx, &Query{
+				RemoteAddr: self,
+				Message: &Message{
+					Questions: []Question{
+						{Name: "a.dev.", Type: TypeA, Class: ClassIN},
+					},
+					Additionals: []Resource{
+						{Name: ".", Class: 1232, Record: new(OPT)},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+
+			var opts []edns.Option
+			for _, res := range msg.Additionals {
+				if opt, ok := res.Record.(*OPT); ok {
+					opts = opt.Options
+				}
+			}
+
+			if test.err == nil {
+				if len(opts) != 0 {
+					t.Errorf("want no EDNS options, got %v", opts)
+				}
+				return
+			}
+
+			want := edns.ExtendedError(edns.ExtendedErrorOther, test.err.Error())
+			if len(opts) != 1 || opts[0].Code != want.Code || string(opts[0].Data) != string(want.Data) {
+				t.Errorf("want extended DNS error %v, got %v", want, opts)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/helmutkemper/dns/edns"
+)
+
+// GeoIP looks up the regions of IP addresses, such as the countries or the
+// continents of a MaxMind GeoIP2 database wrapped in a GeoIPFunc.
+type GeoIP interface {
+	// Region returns the region of ip, or false if it is unknown.
+	Region(ip net.IP) (string, bool)
+}
+
+// GeoIPFunc is an adapter allowing the use of a function as a GeoIP.
+type GeoIPFunc func(net.IP) (string, bool)
+
+// Region calls f(ip).
+func (f GeoIPFunc) Region(ip net.IP) (string, bool) {
+	return f(ip)
+}
+
+// GeoIPNetwork is a network of the addresses of a region.
+type GeoIPNetwork struct {
+	Network *net.IPNet
+	Region  string
+}
+
+// GeoIPNetworks is a GeoIP with a static table of networks. The region of an
+// address is the region of the longest network containing it.
+type GeoIPNetworks []GeoIPNetwork
+
+// Region returns the region of the longest network containing ip.
+func (n GeoIPNetworks) Region(ip net.IP) (string, bool) {
+	var (
+		region string
+		best   = -1
+	)
+	for _, nw := range n {
+		if !nw.Network.Contains(ip) {
+			continue
+		}
+		if ones, _ := nw.Network.Mask.Size(); ones > best {
+			region, best = nw.Region, ones
+		}
+	}
+	return region, best >= 0
+}
+
+// GeoSelector is a Selector answering the clients with the records of their
+// region, set in the RRMeta of the records. The region of a client is looked
+// up from the address of its EDNS Client Subnet option, or else from its own.
+//
+// The clients of a region without records, or of an unknown region, get the
+// records of the Fallback region. If there are none either, all the records
+// are answered.
+type GeoSelector struct {
+	GeoIP GeoIP
+
+	// Fallback is the region of the records answered to the clients of
+	// other regions. If empty, the records without a region are answered.
+	Fallback string
+
+	// Next, if not nil, selects the answers among the records of the region,
+	// such as a StickySelector.
+	Next Selector
+
+	// OnSelect, if not nil, is called with the client address and region of
+	// each question, such as for logging. The region is empty if unknown.
+	OnSelect func(r *Query, ip net.IP, region string)
+}
+
+// Select answers with the records of the region of the client of r.
+func (s *GeoSelector) Select(r *Query, answers []Resource) []Resource {
+	ip := ecsAddr(r.Message)
+	if ip == nil {
+		ip = addrIP(r.RemoteAddr)
+	}
+
+	var region string
+	if ip != nil && s.GeoIP != nil {
+		region, _ = s.GeoIP.Region(ip)
+	}
+	if s.OnSelect != nil {
+		s.OnSelect(r, ip, region)
+	}
+
+	out := regionAnswers(answers, region)
+	if len(out) == 0 || region == "" {
+		out = regionAnswers(answers, s.Fallback)
+	}
+	if len(out) == 0 {
+		out = answers
+	}
+
+	if s.Next != nil {
+		return s.Next.Select(r, out)
+	}
+	return out
+}
+
+// regionAnswers returns the answers with records of region.
+func regionAnswers(answers []Resource, region string) []Resource {
+	var out []Resource
+	for _, res := range answers {
+		var rr string
+		if e, ok := res.Record.(*RREntry); ok {
+			rr = e.Meta.Region
+		}
+		if rr == region {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// ecsAddr returns the address of the EDNS Client Subnet option of msg, or nil
+// if it has none.
+func ecsAddr(msg *Message) net.IP {
+	if msg == nil {
+		return nil
+	}
+
+	for _, res := range msg.Additionals {
+		opt, ok := res.Record.(*OPT)
+		if !ok {
+			continue
+		}
+
+		for _, o := range opt.Options {
+			if o.Code != edns.OptionCodeEDNSClientSubnet || len(o.Data) < 4 {
+				continue
+			}
+
+			var ip net.IP
+			switch nbo.Uint16(o.Data[:2]) {
+			case 1:
+				ip = make(net.IP, net.IPv4len)
+			case 2:
+				ip = make(net.IP, net.IPv6len)
+			default:
+				return nil
+			}
+			copy(ip, o.Data[4:])
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/edns"
+)
+
+func TestGeoSelector(t *testing.T) {
+	t.Parallel()
+
+	_, europe, _ := net.ParseCIDR("192.0.2.0/24")
+	_, office, _ := net.ParseCIDR("192.0.2.128/25")
+	_, america, _ := net.ParseCIDR("198.51.100.0/24")
+
+	var regions []string
+
+	zone := &Zone{
+		Origin: "geo.dev.",
+		TTL:    time.Minute,
+		Selector: &GeoSelector{
+			GeoIP: GeoIPNetworks{
+				{Network: europe, Region: "eu"},
+				{Network: office, Region: "office"},
+				{Network: america, Region: "us"},
+			},
+			Fallback: "us",
+			OnSelect: func(r *Query, ip net.IP, region string) {
+				regions = append(regions, region)
+			},
+		},
+	}
+	zone.AppendEntryInKey("app", &RREntry{
+		Record: &A{A: net.IPv4(10, 0, 1, 1).To4()},
+		Meta:   RRMeta{Region: "eu"},
+	})
+	zone.AppendEntryInKey("app", &RREntry{
+		Record: &A{A: net.IPv4(10, 0, 2, 1).To4()},
+		Meta:   RRMeta{Region: "us"},
+	})
+
+	tests := []struct {
+		name string
+
+		client net.IP
+		ecs    []byte
+
+		region string
+		answer string
+	}{
+		{name: "region", client: net.IPv4(192, 0, 2, 10), region: "eu", answer: "10.0.1.1"},
+		{name: "other region", client: net.IPv4(198, 51, 100, 10), region: "us", answer: "10.0.2.1"},
+		{name: "region without records", client: net.IPv4(192, 0, 2, 200), region: "office", answer: "10.0.2.1"},
+		{name: "unknown region", client: net.IPv4(203, 0, 113, 10), answer: "10.0.2.1"},
+		{
+			name:   "client subnet",
+			client: net.IPv4(203, 0, 113, 10),
+			ecs:    []byte{0, 1, 24, 0, 192, 0, 2},
+			region: "eu",
+			answer: "10.0.1.1",
+		},
+	}
+
+	for _, test := range tests {
+		msg := &Message{
+			Questions: []Question{
+				{Name: "app.geo.dev.", Type: TypeA, Class: ClassIN},
+			},
+		}
+		if test.ecs != nil {
+			msg.Additionals = []Resource{
+				{Name: ".", Class: 1232, Record: &OPT{
+					Options: []edns.Option{
+						{Code: edns.OptionCodeEDNSClientSubnet, Data: test.ecs},
+					},
+				}},
+			}
+		}
+
+		w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+		zone.ServeDNS(context.Background(), w, &Query{
+			RemoteAddr: &net.UDPAddr{IP: test.client, Port: 53000},
+			Message:    msg,
+		})
+
+		if want, got := test.region, regions[len(regions)-1]; want != got {
+			t.Errorf("%s: want region %q, got %q", test.name, want, got)
+		}
+		if want, got := 1, len(w.msg.Answers); want != got {
+			t.Errorf("%s: want %d answers, got %d", test.name, want, got)
+			continue
+		}
+		if want, got := test.answer, w.msg.Answers[0].Record.(*A).A.String(); want != got {
+			t.Errorf("%s: want answer %s, got %s", test.name, want, got)
+		}
+	}
+}
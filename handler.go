@@ -3,6 +3,8 @@ package dns
 import (
 	"context"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Handler responds to a DNS query.
@@ -16,32 +18,12 @@ import (
 // query.
 type Handler interface {
 	ServeDNS(context.Context, MessageWriter, *Query)
-	Clear()
-	Set(map[string]map[Type][]Record)
-	SetKey(string, map[Type][]Record)
-	Len() int
-	GetKey(string) (map[Type][]Record, bool)
-	DeleteKey(string)
-	DeleteRecordInKey(string, Record)
-	AppendRecordInKey(string, Record)
-	GetAll() map[string]map[Type][]Record
-	SetBeforeOnClear(func(map[string]map[Type][]Record))
-	SetBeforeOnChange(func(Event, string, interface{}, interface{}))
-	SetBeforeOnSetKey(func(string, map[Type][]Record, map[Type][]Record))
-	SetBeforeDeleteKey(func(string, map[Type][]Record))
-	SetBeforeOnDeleteKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
-	SetBeforeOnAppendKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
-	SetOnClear(func(map[string]map[Type][]Record))
-	SetOnChange(func(Event, string, interface{}, interface{}))
-	SetOnSetKey(func(string, map[Type][]Record, map[Type][]Record))
-	SetOnDeleteKey(func(string, map[Type][]Record))
-	SetOnDeleteKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
-	SetOnAppendKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
 }
 
 // The HandlerFunc type is an adapter to allow the use of ordinary functions as
 // DNS handlers. If f is a function with the appropriate signature,
-// HandlerFunc(f) is a Handler that calls f.
+// HandlerFunc(f) is a Handler that calls f. A HandlerFunc is also an RRStore
+// without records.
 type HandlerFunc func(context.Context, MessageWriter, *Query)
 
 // ServeDNS calls f(w, r).
@@ -85,6 +67,63 @@ func (f HandlerFunc) GetAll() map[string]map[Type][]Record {
 	return nil
 }
 
+func (f HandlerFunc) Txn(v func(*RRSetTxn) error) error {
+	return ErrUnsupportedOp
+}
+
+func (f HandlerFunc) Range(v func(string, Type, Record) bool) {
+
+}
+
+func (f HandlerFunc) AppendEntryInKey(k string, e *RREntry) {
+
+}
+
+func (f HandlerFunc) AppendLeaseInKey(k string, r Record, lease time.Duration) {
+
+}
+
+func (f HandlerFunc) AppendClassRecordInKey(k string, class Class, r Record) {
+
+}
+
+func (f HandlerFunc) Stats() RRSetStats {
+	return RRSetStats{}
+}
+
+func (f HandlerFunc) DumpText() string {
+	return ""
+}
+
+func (f HandlerFunc) GetEntries(k string, t Type) []*RREntry {
+	return nil
+}
+
+func (f HandlerFunc) GetRecords(k string, t Type) []Record {
+	return nil
+}
+
+func (f HandlerFunc) Names() []string {
+	return nil
+}
+
+func (f HandlerFunc) CountByType() map[Type]int {
+	return nil
+}
+
+func (f HandlerFunc) Watch(ctx context.Context) <-chan ChangeEvent {
+	c := make(chan ChangeEvent)
+	if ctx.Done() == nil {
+		return c // never done, so never closed
+	}
+
+	go func() {
+		<-ctx.Done()
+		close(c)
+	}()
+	return c
+}
+
 func (f HandlerFunc) SetBeforeOnClear(v func(map[string]map[Type][]Record)) {
 
 }
@@ -152,7 +191,22 @@ func Refuse(ctx context.Context, w MessageWriter, r *Query) {
 // ResolveMux is a DNS query multiplexer. It matches a question type and name
 // suffix to a Handler.
 type ResolveMux struct {
-	tbl []muxEntry
+	// Timeout bounds the time a handler may take to answer its question. A
+	// handler that has not replied by the deadline is abandoned and its
+	// question is answered with a "Server Failure" status. If zero, handlers
+	// are bounded only by the query context.
+	Timeout time.Duration
+
+	// MaxConcurrency limits the number of handlers serving questions at the
+	// same time across all queries. A handler abandoned after Timeout holds
+	// its slot until it returns. If zero, there is no limit.
+	MaxConcurrency int
+
+	tbl       []muxEntry
+	fallbacks []muxEntry
+
+	semo sync.Once
+	sem  chan struct{}
 }
 
 type muxEntry struct {
@@ -166,10 +220,39 @@ func (m *ResolveMux) Handle(typ Type, suffix string, h Handler) {
 	m.tbl = append(m.tbl, muxEntry{typ: typ, suffix: suffix, h: h})
 }
 
+// HandleTypes registers the handler for each of the given question types and
+// the name suffix.
+func (m *ResolveMux) HandleTypes(types []Type, suffix string, h Handler) {
+	for _, typ := range types {
+		m.Handle(typ, suffix, h)
+	}
+}
+
+// HandleFunc registers the handler function for the given question type and
+// name suffix.
+func (m *ResolveMux) HandleFunc(typ Type, suffix string, f func(context.Context, MessageWriter, *Query)) {
+	m.Handle(typ, suffix, HandlerFunc(f))
+}
+
+// HandleFallback registers the handler for questions matching the name suffix
+// whose type is not matched by any handler registered with Handle.
+func (m *ResolveMux) HandleFallback(suffix string, h Handler) {
+	m.fallbacks = append(m.fallbacks, muxEntry{typ: TypeANY, suffix: suffix, h: h})
+}
+
 // ServeDNS dispatches the query to the handler(s) whose pattern most closely
 // matches each question.
+//
+// Each question is served by its own handler goroutine. The upstream queries
+// of all handlers that call Recur are merged into a single upstream query.
 func (m *ResolveMux) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
-	var muxw *muxWriter
+	if len(r.Questions) == 0 {
+		return
+	}
+
+	parent, _ := w.(queryForwarder)
+
+	muxws := make([]*muxWriter, 0, len(r.Questions))
 	for _, q := range r.Questions {
 		h := m.lookup(q)
 
@@ -181,31 +264,75 @@ func (m *ResolveMux) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
 		*muxr = *r
 		muxr.Message = muxm
 
-		muxw = &muxWriter{
+		muxw := &muxWriter{
 			messageWriter: &messageWriter{
-				msg: response(muxr.Message),
+				msg: reply(muxr.Message),
 			},
 
-			recurc: make(chan msgerr),
-			replyc: make(chan msgerr),
+			query:  muxr,
+			parent: parent,
 
-			next: muxw,
+			recurc: make(chan *Message),
+			resc:   make(chan msgerr, 1),
+			replyc: make(chan error, 1),
+			donec:  make(chan struct{}),
 		}
+		muxws = append(muxws, muxw)
 
 		go m.serveMux(ctx, h, muxw, muxr)
 	}
 
-	if me, ok := <-muxw.recurc; ok {
-		writeMessage(w, me.msg)
+	var (
+		recurs []*muxWriter
+		req    *Message
+	)
+	for _, muxw := range muxws {
+		select {
+		case msg := <-muxw.recurc:
+			if req == nil {
+				req = msg
+			} else {
+				mergeRequests(msg, req)
+				req = msg
+			}
+			recurs = append(recurs, muxw)
+		case <-muxw.donec:
+		}
+	}
+
+	if req != nil {
+		writeMessage(w, req)
 		msg, err := w.Recur(ctx)
-		muxw.recurc <- msgerr{msg, err}
+		for _, muxw := range recurs {
+			if err != nil {
+				muxw.resc <- msgerr{nil, err}
+				continue
+			}
+			muxw.resc <- msgerr{responseFor(muxw.question(), msg), nil}
+		}
 	}
 
-	me := <-muxw.replyc
-	writeMessage(w, me.msg)
+	for _, muxw := range muxws {
+		<-muxw.donec
+	}
+
+	msg := muxws[len(muxws)-1].response()
+	for i := len(muxws) - 2; i >= 0; i-- {
+		mergeResponses(msg, muxws[i].response())
+	}
+	writeMessage(w, msg)
+
+	if cw, ok := w.(CompressionWriter); ok {
+		for _, muxw := range muxws {
+			if mode := muxw.compressionMode(); mode != compressionDefault {
+				cw.Compress(mode == compressionOn)
+			}
+		}
+	}
 
-	if err := w.Reply(ctx); err != nil {
-		muxw.replyc <- msgerr{nil, err}
+	err := w.Reply(ctx)
+	for _, muxw := range muxws {
+		muxw.replyc <- err
 	}
 }
 
@@ -219,15 +346,17 @@ var recursiveHandler = HandlerFunc(func(ctx context.Context, w MessageWriter, r
 	w.Status(msg.RCode)
 	w.Authoritative(msg.Authoritative)
 	w.Recursion(msg.RecursionAvailable)
+	w.AuthenticData(msg.AuthenticData)
+	w.CheckingDisabled(msg.CheckingDisabled)
 
 	for _, rec := range msg.Answers {
-		w.Answer(rec.Name, rec.TTL, rec.Record)
+		writeAnswer(w, rec)
 	}
 	for _, rec := range msg.Authorities {
-		w.Authority(rec.Name, rec.TTL, rec.Record)
+		writeAuthority(w, rec)
 	}
 	for _, rec := range msg.Additionals {
-		w.Additional(rec.Name, rec.TTL, rec.Record)
+		writeAdditional(w, rec)
 	}
 })
 
@@ -240,81 +369,308 @@ func (m *ResolveMux) lookup(q Question) Handler {
 			return e.h
 		}
 	}
+	for _, e := range m.fallbacks {
+		if strings.HasSuffix(q.Name, e.suffix) {
+			return e.h
+		}
+	}
 
 	return recursiveHandler
 }
 
 func (m *ResolveMux) serveMux(ctx context.Context, h Handler, w *muxWriter, r *Query) {
-	h.ServeDNS(ctx, w, r)
-	w.finish(ctx)
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	if err := m.acquire(ctx); err != nil {
+		w.abort()
+		return
+	}
+
+	servec := make(chan struct{})
+	go func() {
+		defer close(servec)
+		defer m.release()
+
+		h.ServeDNS(ctx, w, r)
+	}()
+
+	select {
+	case <-servec:
+		w.finish()
+	case <-ctx.Done():
+		w.abort()
+	}
+}
+
+func (m *ResolveMux) acquire(ctx context.Context) error {
+	if m.MaxConcurrency <= 0 {
+		return nil
+	}
+
+	m.semo.Do(func() { m.sem = make(chan struct{}, m.MaxConcurrency) })
+
+	select {
+	case m.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *ResolveMux) release() {
+	if m.MaxConcurrency > 0 {
+		<-m.sem
+	}
+}
+
+// muxWriter is the MessageWriter of a single question handler. Once the
+// handler replies, or is abandoned, further writes are discarded.
+// queryForwarder is the writer of a Server forwarding the queries of the
+// handlers of a ResolveMux.
+type queryForwarder interface {
+	forward(ctx context.Context, rt RoundTripper, query *Query) (*Message, error)
 }
 
 type muxWriter struct {
 	*messageWriter
 
-	recurc, replyc chan msgerr
+	query  *Query
+	parent queryForwarder // the writer of the mux, if a Server one
 
-	next *muxWriter
+	mu       sync.Mutex
+	done     bool
+	recurred bool
+
+	recurc chan *Message
+	resc   chan msgerr
+	replyc chan error
+	donec  chan struct{}
 }
 
-func (w muxWriter) Recur(ctx context.Context) (*Message, error) {
-	var (
-		nextOK bool
+func (w *muxWriter) Authoritative(aa bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		msg = request(w.msg)
-	)
+	if !w.done {
+		w.messageWriter.Authoritative(aa)
+	}
+}
 
-	if w.next != nil {
-		var me msgerr
-		if me, nextOK = <-w.next.recurc; nextOK {
-			mergeRequests(msg, me.msg)
-		}
+func (w *muxWriter) Compress(compress bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Compress(compress)
+	}
+}
+
+func (w *muxWriter) AuthenticData(ad bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.AuthenticData(ad)
+	}
+}
+
+func (w *muxWriter) CheckingDisabled(cd bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.CheckingDisabled(cd)
+	}
+}
+
+func (w *muxWriter) Recursion(ra bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Recursion(ra)
+	}
+}
+
+func (w *muxWriter) Status(rc RCode) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Status(rc)
+	}
+}
+
+func (w *muxWriter) Answer(fqdn string, ttl time.Duration, rec Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Answer(fqdn, ttl, rec)
+	}
+}
+
+func (w *muxWriter) Authority(fqdn string, ttl time.Duration, rec Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Authority(fqdn, ttl, rec)
+	}
+}
+
+func (w *muxWriter) Additional(fqdn string, ttl time.Duration, rec Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.Additional(fqdn, ttl, rec)
 	}
-	w.recurc <- msgerr{msg, nil}
+}
+
+func (w *muxWriter) AnswerResource(res Resource) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.AnswerResource(res)
+	}
+}
+
+func (w *muxWriter) AuthorityResource(res Resource) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.done {
+		w.messageWriter.AuthorityResource(res)
+	}
+}
+
+func (w *muxWriter) AdditionalResource(res Resource) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	me := <-w.recurc
-	if nextOK {
-		w.next.recurc <- me
+	if !w.done {
+		w.messageWriter.AdditionalResource(res)
+	}
+}
+
+func (w *muxWriter) Recur(ctx context.Context) (*Message, error) {
+	w.mu.Lock()
+	if w.done || w.recurred {
+		w.mu.Unlock()
+		return nil, ErrUnsupportedOp
+	}
+	w.recurred = true
+	msg := request(w.msg)
+	w.mu.Unlock()
+
+	select {
+	case w.recurc <- msg:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var me msgerr
+	select {
+	case me = <-w.resc:
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 	if me.err != nil {
 		return nil, me.err
 	}
-	return responseFor(w.msg.Questions[0], me.msg), nil
+	return me.msg, nil
 }
 
-func (w muxWriter) Reply(ctx context.Context) error {
-	msg := response(w.msg)
-	if w.next != nil {
-		if me, ok := <-w.next.recurc; ok {
-			w.recurc <- me
-			me = <-w.recurc
-			w.next.recurc <- me
-		}
+// Forward sends only the handler's question to rt, bypassing the merged
+// upstream query of the mux, with the EDNS options forwarded by the server.
+func (w *muxWriter) Forward(ctx context.Context, rt RoundTripper) (*Message, error) {
+	w.mu.Lock()
+	done := w.done
+	w.mu.Unlock()
 
-		me, ok := <-w.next.replyc
-		if !ok || me.err != nil {
-			panic("impossible")
-		}
-		mergeResponses(msg, me.msg)
+	if done {
+		return nil, ErrUnsupportedOp
+	}
+
+	query := &Query{
+		Message:    request(w.query.Message),
+		RemoteAddr: w.query.RemoteAddr,
+	}
+	if w.parent != nil {
+		return w.parent.forward(ctx, rt, query)
+	}
+
+	if rt == nil {
+		rt = refuser
+	}
+	query.Additionals = forwardEDNSOptions(query.Additionals, defaultForwardEDNSOptions)
+	return rt.Do(ctx, query)
+}
+
+func (w *muxWriter) Reply(ctx context.Context) error {
+	if !w.close() {
+		return ErrUnsupportedOp
 	}
-	close(w.recurc)
-	w.replyc <- msgerr{msg, nil}
 
-	me := <-w.replyc
-	if w.next != nil {
-		w.next.replyc <- me
+	select {
+	case err := <-w.replyc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	close(w.replyc)
-	w.replyc = nil
+// finish completes the handler's response if it returned without replying.
+func (w *muxWriter) finish() {
+	w.close()
+}
+
+// abort abandons the handler and marks its response as a server failure.
+func (w *muxWriter) abort() {
+	w.mu.Lock()
+	if !w.done {
+		w.msg.RCode = ServFail
+	}
+	w.mu.Unlock()
 
-	return me.err
+	w.close()
 }
 
-func (w muxWriter) finish(ctx context.Context) {
-	if w.replyc != nil {
-		w.Reply(ctx)
+func (w *muxWriter) close() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done {
+		return false
 	}
+	w.done = true
+	close(w.donec)
+
+	return true
+}
+
+func (w *muxWriter) question() Question {
+	return w.msg.Questions[0]
+}
+
+func (w *muxWriter) compressionMode() compressionMode {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.compression
+}
+
+func (w *muxWriter) response() *Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return response(w.msg)
 }
 
 func mergeRequests(to, from *Message) {
@@ -322,19 +678,22 @@ func mergeRequests(to, from *Message) {
 		to.OpCode = from.OpCode
 	}
 	to.RecursionDesired = to.RecursionDesired || from.RecursionDesired
+	to.CheckingDisabled = to.CheckingDisabled || from.CheckingDisabled
 	to.Questions = append(from.Questions, to.Questions...)
 }
 
 func mergeResponses(to, from *Message) {
 	to.Authoritative = to.Authoritative && from.Authoritative
 	to.RecursionAvailable = to.RecursionAvailable || from.RecursionAvailable
+	to.AuthenticData = to.AuthenticData && from.AuthenticData
+	to.CheckingDisabled = to.CheckingDisabled || from.CheckingDisabled
 	if from.RCode > to.RCode {
 		to.RCode = from.RCode
 	}
 	to.Questions = append(from.Questions, to.Questions...)
-	to.Answers = append(from.Answers, to.Answers...)
-	to.Authorities = append(from.Authorities, to.Authorities...)
-	to.Additionals = append(from.Additionals, to.Additionals...)
+	to.Answers = Dedup(append(from.Answers, to.Answers...))
+	to.Authorities = Dedup(append(from.Authorities, to.Authorities...))
+	to.Additionals = Dedup(append(from.Additionals, to.Additionals...))
 }
 
 func responseFor(q Question, res *Message) *Message {
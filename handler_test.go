@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/benburkert/dns/edns"
 )
 
 func TestResolveMux(t *testing.T) {
@@ -14,7 +16,7 @@ func TestResolveMux(t *testing.T) {
 	mailZone := &Zone{
 		Origin: "mx.",
 		TTL:    24 * time.Hour,
-		RRs: RRSet{
+		RRs: NewRRSet(map[string]map[Type][]Record{
 			"foo": {
 				TypeMX: {
 					&MX{
@@ -39,7 +41,7 @@ func TestResolveMux(t *testing.T) {
 					},
 				},
 			},
-		},
+		}),
 	}
 
 	mux := new(ResolveMux)
@@ -72,10 +74,10 @@ func TestResolveMux(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if want, got := len(mailZone.RRs["foo"][TypeMX]), len(msg.Answers); want != got {
+		if want, got := len(mailZone.GetRecords("foo", TypeMX)), len(msg.Answers); want != got {
 			t.Fatalf("want %d answers, got %d", want, got)
 		}
-		for i, rec := range mailZone.RRs["foo"][TypeMX] {
+		for i, rec := range mailZone.GetRecords("foo", TypeMX) {
 			if want, got := rec, msg.Answers[i].Record; !reflect.DeepEqual(want, got) {
 				t.Errorf("want MX record %#v, got %#v", want, got)
 			}
@@ -100,11 +102,11 @@ func TestResolveMux(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if want, got := len(mailZone.RRs["foo"][TypeMX])+len(mailZone.RRs["bar"][TypeMX]), len(msg.Answers); want != got {
+		if want, got := len(mailZone.GetRecords("foo", TypeMX))+len(mailZone.GetRecords("bar", TypeMX)), len(msg.Answers); want != got {
 			t.Fatalf("want %d answers, got %d", want, got)
 		}
 
-		for i, rec := range append(mailZone.RRs["foo"][TypeMX], mailZone.RRs["bar"][TypeMX]...) {
+		for i, rec := range append(mailZone.GetRecords("foo", TypeMX), mailZone.GetRecords("bar", TypeMX)...) {
 			if want, got := rec, msg.Answers[i].Record; !reflect.DeepEqual(want, got) {
 				t.Errorf("want MX record %#v, got %#v", want, got)
 			}
@@ -129,7 +131,7 @@ func TestResolveMux(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		answers := append(localhostZone.RRs["app"][TypeA], localhostZone.RRs["app"][TypeAAAA]...)
+		answers := append(localhostZone.GetRecords("app", TypeA), localhostZone.GetRecords("app", TypeAAAA)...)
 		if want, got := len(answers), len(msg.Answers); want != got {
 			t.Fatalf("want %d answers, got %d", want, got)
 		}
@@ -163,3 +165,201 @@ func TestResolveMux(t *testing.T) {
 		}
 	})
 }
+
+func TestResolveMuxTimeout(t *testing.T) {
+	t.Parallel()
+
+	stuckc := make(chan struct{})
+	defer close(stuckc)
+
+	mux := &ResolveMux{
+		Timeout:        50 * time.Millisecond,
+		MaxConcurrency: 2,
+	}
+	mux.Handle(TypeA, "stuck.", HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		<-stuckc
+	}))
+	mux.Handle(TypeA, "ok.", HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	srv := mustServer(HandlerFunc(mux.ServeDNS))
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.ok.", Type: TypeA, Class: ClassIN},
+				{Name: "test.stuck.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := new(Client).Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want response RCODE %d, got %d", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+}
+
+func TestResolveMuxTimeoutHoldsSlot(t *testing.T) {
+	t.Parallel()
+
+	stuckc := make(chan struct{})
+
+	mux := &ResolveMux{
+		Timeout:        50 * time.Millisecond,
+		MaxConcurrency: 1,
+	}
+	mux.Handle(TypeA, "stuck.", HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		<-stuckc
+	}))
+
+	srv := mustServer(HandlerFunc(mux.ServeDNS))
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.stuck.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := new(Client).Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want response RCODE %d, got %d", want, got)
+	}
+	held := func() int {
+		mux.semo.Do(func() {})
+		return len(mux.sem)
+	}
+	if want, got := 1, held(); want != got {
+		t.Errorf("want %d slot held by the abandoned handler, got %d", want, got)
+	}
+
+	close(stuckc)
+
+	deadline := time.Now().Add(time.Second)
+	for held() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("slot not released after the handler returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResolveMuxLookup(t *testing.T) {
+	t.Parallel()
+
+	var (
+		addrs    = &Zone{Origin: "example."}
+		fallback = &Zone{Origin: "example."}
+	)
+
+	mux := new(ResolveMux)
+	mux.HandleTypes([]Type{TypeA, TypeAAAA, TypeSRV}, "example.", addrs)
+	mux.HandleFallback("example.", fallback)
+	mux.HandleFunc(TypeANY, "local.", Refuse)
+
+	tests := []struct {
+		q    Question
+		want Handler
+	}{
+		{Question{Name: "a.example.", Type: TypeA}, addrs},
+		{Question{Name: "a.example.", Type: TypeAAAA}, addrs},
+		{Question{Name: "a.example.", Type: TypeSRV}, addrs},
+		{Question{Name: "a.example.", Type: TypeMX}, fallback},
+	}
+
+	for _, test := range tests {
+		if want, got := test.want, mux.lookup(test.q); want != got {
+			t.Errorf("%s %s: want handler %p, got %p", test.q.Name, test.q.Type, want, got)
+		}
+	}
+
+	if _, ok := mux.lookup(Question{Name: "a.local.", Type: TypeMX}).(HandlerFunc); !ok {
+		t.Error("want HandlerFunc for local. suffix")
+	}
+}
+
+func TestResolveMuxForward(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ecs    = edns.Option{Code: edns.OptionCodeEDNSClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}}
+		cookie = edns.Option{Code: edns.OptionCodeCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	)
+
+	forwardedc := make(chan *Query, 1)
+	rt := RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+		forwardedc <- query
+		return response(query.Message), nil
+	})
+
+	refusedc := make(chan RCode, 1)
+
+	mux := new(ResolveMux)
+	mux.HandleFunc(TypeA, "forward.local.", func(ctx context.Context, w MessageWriter, r *Query) {
+		if _, err := w.Forward(ctx, rt); err != nil {
+			w.Status(ServFail)
+		}
+	})
+	mux.HandleFunc(TypeA, "refuse.local.", func(ctx context.Context, w MessageWriter, r *Query) {
+		msg, err := w.Forward(ctx, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		refusedc <- msg.RCode
+	})
+
+	srv := &Server{Handler: mux}
+
+	opt := &OPT{Options: []edns.Option{ecs, cookie}}
+	_, err := srv.ServeMessage(context.Background(), &Query{
+		Message: &Message{
+			Questions: []Question{
+				{Name: "forward.local.", Type: TypeA, Class: ClassIN},
+				{Name: "refuse.local.", Type: TypeA, Class: ClassIN},
+			},
+			Additionals: []Resource{{Name: ".", Class: 1232, Record: opt}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwarded := <-forwardedc
+	if want, got := 1, len(forwarded.Additionals); want != got {
+		t.Fatalf("want %d OPT record forwarded, got %d", want, got)
+	}
+	if want, got := []edns.Option{ecs}, forwarded.Additionals[0].Record.(*OPT).Options; !reflect.DeepEqual(want, got) {
+		t.Errorf("want options %v, got %v", want, got)
+	}
+	if want, got := Refused, <-refusedc; want != got {
+		t.Errorf("want rcode %v without a round tripper, got %v", want, got)
+	}
+}
@@ -0,0 +1,451 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errProbeRecord = errors.New("record type not probed")
+	errProbeReply  = errors.New("no echo reply")
+)
+
+// Probe checks the health of the endpoint of an A, AAAA or SRV record.
+type Probe interface {
+	Probe(ctx context.Context, rec Record) error
+}
+
+// ProbeFunc is an adapter allowing the use of a function as a Probe.
+type ProbeFunc func(context.Context, Record) error
+
+// Probe calls f(ctx, rec).
+func (f ProbeFunc) Probe(ctx context.Context, rec Record) error {
+	return f(ctx, rec)
+}
+
+// HealthEvent is a change of the health of a record.
+type HealthEvent struct {
+	Key     string // key of the record in the zone
+	Record  Record // record, without its metadata
+	Healthy bool
+	Err     error // error of the last probe of an unhealthy record
+}
+
+// HealthChecker probes the A, AAAA and SRV records of a Zone and marks them as
+// unhealthy, in their RRMeta, while their probes fail. Unhealthy records are
+// not used in the answers of the zone, so the clients fail over to the
+// healthy records of an RRset.
+type HealthChecker struct {
+	Zone  *Zone
+	Probe Probe
+
+	// Interval is the time between the probes of a record. If zero, records
+	// are probed every 10 seconds.
+	Interval time.Duration
+
+	// Timeout limits each probe. If zero, a probe times out after 2 seconds.
+	Timeout time.Duration
+
+	// Fall and Rise are the numbers of consecutive failed and successful
+	// probes that mark a record as unhealthy and healthy again. If zero, 3
+	// and 2 are used.
+	Fall, Rise int
+
+	// OnChange, if not nil, is called on each change of the health of a
+	// record, after the change is made to the zone.
+	OnChange func(HealthEvent)
+
+	mu     sync.Mutex
+	states map[healthKey]*healthState
+}
+
+type healthKey struct {
+	key  string
+	typ  Type
+	data string
+}
+
+type healthState struct {
+	healthy    bool
+	fails, oks int
+	seen       bool
+}
+
+type healthTarget struct {
+	healthKey
+
+	rec     Record
+	healthy bool
+}
+
+// Run probes the records every Interval until ctx is done.
+func (hc *HealthChecker) Run(ctx context.Context) error {
+	interval := hc.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		hc.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check probes each record once, concurrently, and updates the zone with the
+// changes of their health.
+func (hc *HealthChecker) Check(ctx context.Context) {
+	timeout := hc.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	var targets []healthTarget
+	hc.Zone.Range(func(key string, t Type, rr Record) bool {
+		if t != TypeA && t != TypeAAAA && t != TypeSRV {
+			return true
+		}
+
+		healthy := true
+		if e, ok := rr.(*RREntry); ok {
+			healthy = !e.Meta.Unhealthy
+		}
+		rec, _ := unwrapRecord(rr)
+
+		targets = append(targets, healthTarget{
+			healthKey: healthKey{key: key, typ: t, data: rec.Key()},
+			rec:       rec,
+			healthy:   healthy,
+		})
+		return true
+	})
+
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, tgt := range targets {
+		wg.Add(1)
+		go func(i int, rec Record) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			errs[i] = hc.Probe.Probe(ctx, rec)
+		}(i, tgt.rec)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	events := hc.update(targets, errs)
+	if len(events) == 0 {
+		return
+	}
+
+	hc.Zone.Txn(func(tx *RRSetTxn) error {
+		for _, ev := range events {
+			markHealth(tx, ev)
+		}
+		return nil
+	})
+
+	if hc.OnChange != nil {
+		for _, ev := range events {
+			hc.OnChange(ev)
+		}
+	}
+}
+
+// update records the results of the probes of targets, and returns the
+// changes of their health.
+func (hc *HealthChecker) update(targets []healthTarget, errs []error) []HealthEvent {
+	fall, rise := hc.Fall, hc.Rise
+	if fall == 0 {
+		fall = 3
+	}
+	if rise == 0 {
+		rise = 2
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hc.states == nil {
+		hc.states = make(map[healthKey]*healthState)
+	}
+	for _, s := range hc.states {
+		s.seen = false
+	}
+
+	var events []HealthEvent
+	for i, tgt := range targets {
+		s, ok := hc.states[tgt.healthKey]
+		if !ok {
+			s = &healthState{healthy: tgt.healthy}
+			hc.states[tgt.healthKey] = s
+		}
+		s.seen = true
+
+		if errs[i] != nil {
+			s.fails, s.oks = s.fails+1, 0
+			if s.healthy && s.fails >= fall {
+				s.healthy = false
+			}
+		} else {
+			s.fails, s.oks = 0, s.oks+1
+			if !s.healthy && s.oks >= rise {
+				s.healthy = true
+			}
+		}
+
+		if s.healthy != tgt.healthy {
+			events = append(events, HealthEvent{
+				Key:     tgt.key,
+				Record:  tgt.rec,
+				Healthy: s.healthy,
+				Err:     errs[i],
+			})
+		}
+	}
+
+	// forget the records removed from the zone
+	for k, s := range hc.states {
+		if !s.seen {
+			delete(hc.states, k)
+		}
+	}
+	return events
+}
+
+// markHealth sets the health of the record of ev, if still in the zone.
+func markHealth(tx *RRSetTxn, ev HealthEvent) {
+	v, ok := tx.GetKey(ev.Key)
+	if !ok {
+		return
+	}
+
+	t := ev.Record.Type()
+	for i, rr := range v[t] {
+		if rec, _ := unwrapRecord(rr); rec.Key() != ev.Record.Key() {
+			continue
+		}
+
+		e := &RREntry{Record: rr}
+		if re, ok := rr.(*RREntry); ok {
+			cp := *re
+			e = &cp
+		}
+		e.Meta.Unhealthy = !ev.Healthy
+
+		v[t][i] = e
+		tx.SetKey(ev.Key, v)
+		return
+	}
+}
+
+// probeAddr returns the host and port of the endpoint of rec. The port of an
+// SRV record is used unless port is set.
+func probeAddr(rec Record, port int) (string, error) {
+	switch rec := rec.(type) {
+	case *A:
+		return net.JoinHostPort(rec.A.String(), strconv.Itoa(port)), nil
+	case *AAAA:
+		return net.JoinHostPort(rec.AAAA.String(), strconv.Itoa(port)), nil
+	case *SRV:
+		if port == 0 {
+			port = rec.Port
+		}
+		return net.JoinHostPort(rec.Target, strconv.Itoa(port)), nil
+	}
+	return "", errProbeRecord
+}
+
+// TCPProbe is a Probe connecting to the endpoint of a record over TCP.
+type TCPProbe struct {
+	// Port is the port of the endpoint. It may be zero for SRV records,
+	// which are probed on their own port.
+	Port int
+}
+
+// Probe connects to the endpoint of rec and closes the connection.
+func (p TCPProbe) Probe(ctx context.Context, rec Record) error {
+	addr, err := probeAddr(rec, p.Port)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// HTTPProbe is a Probe requesting a URL of the endpoint of a record. The
+// endpoint is healthy if the response has a 2xx or 3xx status.
+type HTTPProbe struct {
+	// Scheme is the scheme of the URL. If empty, "http" is used.
+	Scheme string
+
+	// Port is the port of the endpoint. If zero, the default port of the
+	// scheme is used for A and AAAA records.
+	Port int
+
+	// Path is the path of the URL, such as "/healthz".
+	Path string
+
+	// Host, if not empty, is sent as the Host header, such as to reach a
+	// virtual host.
+	Host string
+
+	// Client sends the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Probe sends a GET request to the endpoint of rec.
+func (p HTTPProbe) Probe(ctx context.Context, rec Record) error {
+	scheme := p.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	port := p.Port
+	if _, ok := rec.(*SRV); !ok && port == 0 {
+		port = 80
+		if scheme == "https" {
+			port = 443
+		}
+	}
+
+	addr, err := probeAddr(rec, port)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, scheme+"://"+addr+p.Path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if p.Host != "" {
+		req.Host = p.Host
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return errors.New("http health check: " + res.Status)
+	}
+	return nil
+}
+
+// ICMPProbe is a Probe sending an ICMP echo request to the endpoint of a
+// record. The target of an SRV record is resolved first. It needs the
+// privilege to open raw IP sockets.
+type ICMPProbe struct{}
+
+// Probe sends an echo request to the endpoint of rec and waits for its reply.
+func (ICMPProbe) Probe(ctx context.Context, rec Record) error {
+	var ip net.IP
+	switch rec := rec.(type) {
+	case *A:
+		ip = rec.A
+	case *AAAA:
+		ip = rec.AAAA
+	case *SRV:
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, rec.Target)
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return errProbeReply
+		}
+		ip = addrs[0].IP
+	default:
+		return errProbeRecord
+	}
+
+	network, request, reply := "ip6:ipv6-icmp", byte(128), byte(129)
+	if ip4 := ip.To4(); ip4 != nil {
+		network, request, reply, ip = "ip4:icmp", 8, 0, ip4
+	}
+
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	id, seq := uint16(time.Now().UnixNano()), uint16(1)
+
+	msg := []byte{request, 0, 0, 0, 0, 0, 0, 0, 'd', 'n', 's'}
+	nbo.PutUint16(msg[4:6], id)
+	nbo.PutUint16(msg[6:8], seq)
+	if request == 8 {
+		// the kernel computes the checksum of ICMPv6 messages
+		nbo.PutUint16(msg[2:4], icmpChecksum(msg))
+	}
+
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+
+		if n < 8 || b[0] != reply || nbo.Uint16(b[4:6]) != id || nbo.Uint16(b[6:8]) != seq {
+			continue
+		}
+		if from, ok := addr.(*net.IPAddr); ok && from.IP.Equal(ip) {
+			return nil
+		}
+	}
+}
+
+// icmpChecksum returns the internet checksum of b.
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	zone := &Zone{
+		Origin: "health.dev.",
+		TTL:    time.Minute,
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	zone.AppendEntryInKey("app", &RREntry{
+		Record: &A{A: net.IPv4(127, 0, 0, 2).To4()},
+		Meta:   RRMeta{Source: "test"},
+	})
+
+	var (
+		mu     sync.Mutex
+		events []HealthEvent
+	)
+
+	hc := &HealthChecker{
+		Zone:  zone,
+		Probe: TCPProbe{Port: ln.Addr().(*net.TCPAddr).Port},
+		Fall:  2,
+		Rise:  1,
+		OnChange: func(ev HealthEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			events = append(events, ev)
+		},
+	}
+
+	answers := func() []string {
+		w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+		zone.ServeDNS(context.Background(), w, &Query{
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.health.dev.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+
+		var addrs []string
+		for _, res := range w.msg.Answers {
+			addrs = append(addrs, res.Record.(*A).A.String())
+		}
+		return addrs
+	}
+
+	ctx := context.Background()
+
+	hc.Check(ctx)
+	if want, got := 2, len(answers()); want != got {
+		t.Fatalf("want %d answers before Fall probes, got %d", want, got)
+	}
+
+	hc.Check(ctx)
+	if got := answers(); len(got) != 1 || got[0] != "127.0.0.1" {
+		t.Fatalf("want answer 127.0.0.1, got %v", got)
+	}
+
+	entries := zone.GetEntries("app", TypeA)
+	if want, got := "test", entries[1].Meta.Source; want != got {
+		t.Errorf("want entry source %q, got %q", want, got)
+	}
+
+	mu.Lock()
+	if want, got := 1, len(events); want != got {
+		t.Fatalf("want %d events, got %d", want, got)
+	}
+	if ev := events[0]; ev.Healthy || ev.Err == nil || !ev.Record.Equal(&A{A: net.IPv4(127, 0, 0, 2).To4()}) {
+		t.Errorf("want unhealthy event of 127.0.0.2, got %+v", ev)
+	}
+	mu.Unlock()
+
+	hc.Probe = ProbeFunc(func(context.Context, Record) error { return nil })
+	hc.Check(ctx)
+
+	if want, got := 2, len(answers()); want != got {
+		t.Errorf("want %d answers after recovery, got %d", want, got)
+	}
+
+	mu.Lock()
+	if want, got := 2, len(events); want != got {
+		t.Fatalf("want %d events, got %d", want, got)
+	}
+	if !events[1].Healthy {
+		t.Errorf("want healthy event, got %+v", events[1])
+	}
+	mu.Unlock()
+}
+
+func TestHTTPProbe(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	rec := &A{A: addr.IP.To4()}
+
+	if err := (HTTPProbe{Port: addr.Port, Path: "/healthz"}).Probe(context.Background(), rec); err != nil {
+		t.Error(err)
+	}
+	if err := (HTTPProbe{Port: addr.Port, Path: "/"}).Probe(context.Background(), rec); err == nil {
+		t.Error("want error for 503 status")
+	}
+
+	srvRec := &SRV{Target: addr.IP.String(), Port: addr.Port}
+	if err := (HTTPProbe{Path: "/healthz"}).Probe(context.Background(), srvRec); err != nil {
+		t.Error(err)
+	}
+
+	if err := (TCPProbe{}).Probe(context.Background(), &TXT{}); err != errProbeRecord {
+		t.Errorf("want error %v, got %v", errProbeRecord, err)
+	}
+}
+
+func TestICMPProbe(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("ip4:icmp", "")
+	if err != nil {
+		t.Skip("raw IP sockets unavailable: ", err)
+	}
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := (ICMPProbe{}).Probe(ctx, &A{A: net.IPv4(127, 0, 0, 1).To4()}); err != nil {
+		t.Error(err)
+	}
+}
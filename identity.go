@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// ClientIdentity is the identity of a client that presented a verified TLS
+// certificate.
+type ClientIdentity struct {
+	// Name is the common name of the certificate, or its first DNS name if the
+	// common name is empty.
+	Name string
+
+	// Certificate is the verified leaf certificate of the client.
+	Certificate *x509.Certificate
+
+	// Roles are the roles mapped to Name by Server.ClientRoles.
+	Roles []string
+}
+
+// HasRole reports whether the identity holds role. A nil identity holds no
+// roles.
+func (id *ClientIdentity) HasRole(role string) bool {
+	if id == nil {
+		return false
+	}
+
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIdentity returns the verified identity of the client of conn, or nil.
+func (s *Server) clientIdentity(conn *tls.Conn) *ClientIdentity {
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+
+	return &ClientIdentity{
+		Name:        name,
+		Certificate: cert,
+		Roles:       s.ClientRoles[name],
+	}
+}
+
+// tlsConfig returns the TLS config of ServeTLS.
+func (s *Server) tlsConfig() *tls.Config {
+	cfg := s.TLSConfig.Clone()
+	if cfg == nil {
+		cfg = new(tls.Config)
+	}
+	if s.ClientCAs != nil {
+		cfg.ClientCAs = s.ClientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(s.SNIRoutes) > 0 {
+		cfg.GetCertificate = s.getCertificate(cfg.GetCertificate)
+	}
+	cfg.NextProtos = withALPN(cfg.NextProtos)
+	return cfg
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/internal/must"
+)
+
+func TestServeTLSClientIdentity(t *testing.T) {
+	t.Parallel()
+
+	ca := must.CACert("ca.dev", nil)
+
+	identities := make(chan *ClientIdentity, 1)
+	srv := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			identities <- r.Identity
+
+			if !r.Identity.HasRole("admin") {
+				w.Status(Refused)
+				return
+			}
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{
+				*must.LeafCert("dns-server.dev", ca).TLS(),
+				*ca.TLS(),
+			},
+		},
+		ClientCAs:   must.CertPool(ca.TLS()),
+		ClientRoles: map[string][]string{"admin.dev": {"admin"}},
+	}
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(context.Background(), ln)
+
+	query := func(cert *tls.Certificate) (*Message, error) {
+		tlsConfig := &tls.Config{
+			ServerName: "dns-server.dev",
+			RootCAs:    must.CertPool(ca.TLS()),
+		}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+
+		client := &Client{
+			Transport: &Transport{TLSConfig: tlsConfig},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		return client.Do(ctx, &Query{
+			RemoteAddr: OverTLSAddr{ln.Addr()},
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.dev.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+	}
+
+	msg, err := query(must.LeafCert("admin.dev", ca).TLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+	if id := <-identities; id == nil || id.Name != "admin.dev" {
+		t.Errorf("want identity %q, got %+v", "admin.dev", id)
+	}
+
+	msg, err = query(must.LeafCert("guest.dev", ca).TLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := Refused, msg.RCode; want != got {
+		t.Errorf("want response RCODE %d, got %d", want, got)
+	}
+	if id := <-identities; id == nil || len(id.Roles) != 0 {
+		t.Errorf("want identity without roles, got %+v", id)
+	}
+
+	if _, err := query(nil); err == nil {
+		t.Error("want query without a client certificate to fail")
+	}
+}
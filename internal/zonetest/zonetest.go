@@ -0,0 +1,30 @@
+// Package zonetest holds the zone helpers of the tests of the subpackages
+// populating a dns.Zone.
+package zonetest
+
+import (
+	"time"
+
+	"github.com/helmutkemper/dns"
+)
+
+// Zone returns the zone origin with the records rrs, a TTL of an hour and a
+// SOA record of the servers ns and hostmaster of origin.
+func Zone(origin string, rrs map[string]map[dns.Type][]dns.Record) *dns.Zone {
+	return &dns.Zone{
+		Origin: origin,
+		TTL:    time.Hour,
+		SOA:    &dns.SOA{NS: "ns." + origin, MBox: "hostmaster." + origin},
+		RRs:    dns.NewRRSet(rrs),
+	}
+}
+
+// Records returns the records of type t of the key k of z, those stored as an
+// RREntry unwrapped.
+func Records(z *dns.Zone, k string, t dns.Type) []dns.Record {
+	var rrs []dns.Record
+	for _, e := range z.GetEntries(k, t) {
+		rrs = append(rrs, e.Record)
+	}
+	return rrs
+}
@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ListenerPolicy overrides the handler and the policies of a Server for the
+// queries received over a transport, or on a local address, such as to serve
+// the recursive queries of the clients of an internal listener but refuse
+// those of the public DNS over TLS one. See Server.Listeners.
+type ListenerPolicy struct {
+	// Network is the transport of the queries: "udp", "tcp" or "tls". If
+	// empty, the queries of any transport match.
+	Network string
+
+	// Addr, if not empty, is the local address of the queries, such as
+	// "192.0.2.1:53", ":853" or "192.0.2.1". A host matches the queries of
+	// the listeners bound to that address, not to the unspecified one; a
+	// port, those of the listeners on that port.
+	Addr string
+
+	// Handler, if not nil, serves the queries in place of Server.Handler.
+	// The Handler of an SNIRoute still serves the queries of its TLS
+	// connections.
+	Handler Handler
+
+	// Forwarder, if not nil, relays the recursive queries in place of
+	// Server.Forwarder.
+	Forwarder RoundTripper
+
+	// DenyRecursion answers the recursive queries with a "Query Refused"
+	// message, whatever the Forwarder.
+	DenyRecursion bool
+
+	// Padding, if positive, is the block length the responses to the
+	// queries with an EDNS Padding option are padded to, see RFC 7830. RFC
+	// 8467 recommends 468 bytes for the encrypted transports. The padded
+	// responses are not served from the pack cache of a Zone.
+	Padding int
+
+	// RequireCookies requires a valid server cookie of the UDP queries,
+	// see RFC 7873, section 5.2: a query without a cookie is answered with
+	// an empty truncated response, so that the client retries over TCP,
+	// and a query with only a client cookie, or an invalid server cookie,
+	// with a "Bad/missing Server Cookie" response carrying a new one. The
+	// TCP and TLS queries, whose source a spoofing client cannot forge,
+	// are served.
+	RequireCookies bool
+
+	// Classifier and Tarpit, if not nil, are the classifier and the tarpit
+	// of the queries in place of those of the Server.
+	Classifier QueryClassifier
+	Tarpit     *Tarpit
+
+	// ResponseRateLimit, if not nil, limits the UDP responses in place of
+	// the ResponseRateLimit of the Server, such as to limit those of a
+	// public listener only. Its responses are counted apart from those of
+	// the other listeners.
+	ResponseRateLimit *ResponseRateLimit
+}
+
+// matches reports whether the queries received over transport on the local
+// address of p.
+func (p *ListenerPolicy) matches(transport string, local net.Addr) bool {
+	if p.Network != "" && p.Network != transport {
+		return false
+	}
+	if p.Addr == "" {
+		return true
+	}
+	if local == nil {
+		return false
+	}
+
+	host, port, err := net.SplitHostPort(p.Addr)
+	if err != nil {
+		host, port = p.Addr, ""
+	}
+	if host != "" && !net.ParseIP(host).Equal(addrIP(local)) {
+		return false
+	}
+	if port != "" {
+		if _, lport, err := net.SplitHostPort(local.String()); err != nil || lport != port {
+			return false
+		}
+	}
+	return true
+}
+
+// listenerPolicy returns the first of the Listeners of the server matching the
+// query r received over transport, or nil.
+func (s *Server) listenerPolicy(transport string, r *Query) *ListenerPolicy {
+	for i := range s.Listeners {
+		if p := &s.Listeners[i]; p.matches(transport, r.LocalAddr) {
+			return p
+		}
+	}
+	return nil
+}
+
+// forwarder returns the forwarder of the queries of the listener policy p.
+func (s *Server) forwarder(p *ListenerPolicy) RoundTripper {
+	switch {
+	case p == nil:
+		return s.Forwarder
+	case p.DenyRecursion:
+		return refuser
+	case p.Forwarder != nil:
+		return p.Forwarder
+	}
+	return s.Forwarder
+}
+
+// writerTransport returns the transport of the queries answered with w, or ""
+// for those served in memory.
+func writerTransport(w MessageWriter) string {
+	if tw, ok := w.(interface{ transport() string }); ok {
+		return tw.transport()
+	}
+	return ""
+}
+
+func (packetWriter) transport() string { return "udp" }
+
+func (w streamWriter) transport() string {
+	if _, ok := w.conn.(*tls.Conn); ok {
+		return "tls"
+	}
+	return "tcp"
+}
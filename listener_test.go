@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerListeners(t *testing.T) {
+	t.Parallel()
+
+	recur := HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		msg, err := w.Recur(ctx)
+		if err != nil {
+			w.Status(ServFail)
+			return
+		}
+		writeMessage(w, msg)
+	})
+
+	srv := &Server{
+		Addr:    mustUnusedAddr(),
+		Handler: recur,
+		Forwarder: &Client{
+			Transport: nopDialer{},
+			Resolver: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+				w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(192, 0, 2, 1).To4()})
+			}),
+		},
+		Listeners: []ListenerPolicy{
+			{Network: "tcp", DenyRecursion: true},
+			{Network: "udp", Addr: "192.0.2.53:53", Handler: HandlerFunc(Refuse)},
+		},
+	}
+	mustStart(srv)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		addr  net.Addr
+		rcode RCode
+	}{
+		{name: "udp", addr: udpAddr},
+		{name: "tcp", addr: tcpAddr, rcode: Refused},
+	}
+
+	for _, test := range tests {
+		msg, err := new(Client).Do(context.Background(), &Query{
+			RemoteAddr: test.addr,
+			Message: &Message{
+				RecursionDesired: true,
+				Questions:        []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %v, got %v", test.name, want, got)
+		}
+		if want, got := test.rcode == NoError, len(msg.Answers) == 1; want != got {
+			t.Errorf("%s: want answered %t, got %v", test.name, want, msg.Answers)
+		}
+	}
+}
+
+func TestListenerPolicyMatches(t *testing.T) {
+	t.Parallel()
+
+	local := &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+
+	tests := []struct {
+		policy    ListenerPolicy
+		transport string
+		local     net.Addr
+		want      bool
+	}{
+		{policy: ListenerPolicy{}, transport: "udp", local: local, want: true},
+		{policy: ListenerPolicy{Network: "udp"}, transport: "udp", local: local, want: true},
+		{policy: ListenerPolicy{Network: "tls"}, transport: "tcp", local: local},
+		{policy: ListenerPolicy{Addr: "192.0.2.53:53"}, transport: "udp", local: local, want: true},
+		{policy: ListenerPolicy{Addr: "192.0.2.53"}, transport: "udp", local: local, want: true},
+		{policy: ListenerPolicy{Addr: ":53"}, transport: "udp", local: local, want: true},
+		{policy: ListenerPolicy{Addr: ":853"}, transport: "udp", local: local},
+		{policy: ListenerPolicy{Addr: "192.0.2.54:53"}, transport: "udp", local: local},
+		{policy: ListenerPolicy{Addr: "2001:db8::53"}, transport: "udp", local: &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}, want: true},
+		{policy: ListenerPolicy{Addr: ":53"}, transport: "udp"},
+	}
+
+	for _, test := range tests {
+		if want, got := test.want, test.policy.matches(test.transport, test.local); want != got {
+			t.Errorf("%+v over %s on %v: want match %t, got %t", test.policy, test.transport, test.local, want, got)
+		}
+	}
+}
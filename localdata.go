@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteUnbound writes the records of z to w as the local-zone and local-data
+// clauses of the server section of an unbound configuration, the zone static
+// so that unbound answers the names of z from its local data alone. The
+// records of the types without a presentation format here are written in the
+// generic format of RFC 3597. The wildcard records are left out, as
+// local-data has no wildcards, and so are the records of classes other than
+// IN.
+func WriteUnbound(w io.Writer, z *Zone) error {
+	bw := bufio.NewWriter(w)
+
+	origin := z.fqdn("")
+	fmt.Fprintf(bw, "local-zone: %q static\n", origin)
+	if z.SOA != nil {
+		writeUnboundData(bw, origin, z.TTL, z.SOA)
+	}
+
+	for _, set := range zoneRRsets(z) {
+		if isWildcard(set.name) {
+			continue
+		}
+		for _, rr := range set.rrs {
+			writeUnboundData(bw, set.name, set.ttl, rr)
+		}
+	}
+	return bw.Flush()
+}
+
+func writeUnboundData(w io.Writer, name string, ttl time.Duration, rr Record) {
+	line := fmt.Sprintf("%s %d IN %s %s", name, ttl/time.Second, typeText(rr.Type()), rdataOrGeneric(rr))
+
+	// the data is quoted with single quotes once it holds double quotes
+	quote := `"`
+	if strings.Contains(line, `"`) {
+		quote = `'`
+	}
+	fmt.Fprintf(w, "local-data: %s%s%s\n", quote, line, quote)
+}
+
+// WriteDnsmasq writes the records of z to w as the lines of a dnsmasq
+// configuration: the host-record, cname, mx-host, srv-host, txt-record and
+// ptr-record options, and the dns-rr option for the records of other types.
+// The TTL of the records is written where the option has one. The SOA and NS
+// records are left out, as dnsmasq is no authoritative server, and so are the
+// wildcard records and the records of classes other than IN.
+//
+// A host-record also answers the PTR queries of its address.
+func WriteDnsmasq(w io.Writer, z *Zone) error {
+	bw := bufio.NewWriter(w)
+
+	for _, set := range zoneRRsets(z) {
+		if isWildcard(set.name) || set.t == TypeNS {
+			continue
+		}
+
+		name, ttl := strings.TrimSuffix(set.name, "."), set.ttl/time.Second
+		for _, rr := range set.rrs {
+			switch rr := rr.(type) {
+			case *A:
+				fmt.Fprintf(bw, "host-record=%s,%s,%d\n", name, rr.A, ttl)
+			case *AAAA:
+				fmt.Fprintf(bw, "host-record=%s,%s,%d\n", name, rr.AAAA, ttl)
+			case *CNAME:
+				fmt.Fprintf(bw, "cname=%s,%s,%d\n", name, strings.TrimSuffix(rr.CNAME, "."), ttl)
+			case *MX:
+				fmt.Fprintf(bw, "mx-host=%s,%s,%d\n", name, strings.TrimSuffix(rr.MX, "."), rr.Pref)
+			case *SRV:
+				fmt.Fprintf(bw, "srv-host=%s,%s,%d,%d,%d\n", name, strings.TrimSuffix(rr.Target, "."), rr.Port, rr.Priority, rr.Weight)
+			case *PTR:
+				fmt.Fprintf(bw, "ptr-record=%s,%s\n", name, strings.TrimSuffix(rr.PTR, "."))
+			case *TXT:
+				if text, ok := dnsmasqText(rr.TXT); ok {
+					fmt.Fprintf(bw, "txt-record=%s,%s\n", name, text)
+					break
+				}
+				writeDnsmasqRR(bw, name, rr)
+			default:
+				writeDnsmasqRR(bw, name, rr)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func writeDnsmasqRR(w io.Writer, name string, rr Record) {
+	b, _ := rr.Pack(nil, nil)
+	fmt.Fprintf(w, "dns-rr=%s,%d,%s\n", name, rr.Type(), hex.EncodeToString(b))
+}
+
+// dnsmasqText returns the strings of a TXT record as the quoted values of a
+// txt-record option, or false if a string holds a byte dnsmasq has no
+// escape for.
+func dnsmasqText(txt []string) (string, bool) {
+	quoted := make([]string, len(txt))
+	for i, s := range txt {
+		for j := 0; j < len(s); j++ {
+			if s[j] < ' ' || s[j] > '~' {
+				return "", false
+			}
+		}
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return strings.Join(quoted, ","), true
+}
+
+func isWildcard(name string) bool { return strings.HasPrefix(name, "*.") }
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+var lanZone = &Zone{
+	Origin: "lan.",
+	TTL:    time.Hour,
+	SOA:    &SOA{NS: "ns.lan.", MBox: "hostmaster.lan.", Serial: 1},
+	RRs: NewRRSet(map[string]map[Type][]Record{
+		"": {
+			TypeNS:     {&NS{NS: "ns.lan."}},
+			TypeMX:     {&MX{Pref: 10, MX: "mail.lan."}},
+			TypeZONEMD: {&ZONEMD{Serial: 1, Scheme: 1, Hash: 1, Digest: []byte{0xab, 0xcd}}},
+		},
+		"nas": {
+			TypeA:    {&RREntry{Record: &A{A: net.IPv4(192, 168, 1, 10).To4()}, TTL: time.Minute}},
+			TypeAAAA: {&AAAA{AAAA: net.ParseIP("fd00::10")}},
+			TypeTXT:  {&TXT{TXT: []string{`say "hi"`, "two"}}},
+			TypeCAA:  {&CAA{Tag: "issue", Value: "ca.lan"}},
+		},
+		"files":                {TypeCNAME: {&CNAME{CNAME: "nas.lan."}}},
+		"_smb._tcp":            {TypeSRV: {&SRV{Priority: 0, Weight: 5, Port: 445, Target: "nas.lan."}}},
+		"10.1.168.192.in-addr": {TypePTR: {&PTR{PTR: "nas.lan."}}},
+		"*.dev":                {TypeA: {&A{A: net.IPv4(192, 168, 1, 20).To4()}}},
+		"bell":                 {TypeTXT: {&TXT{TXT: []string{"ring\a"}}}},
+	}),
+}
+
+func TestWriteUnbound(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	if err := WriteUnbound(&b, lanZone); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `local-zone: "lan." static
+local-data: "lan. 3600 IN SOA ns.lan. hostmaster.lan. 1 0 0 0 0"
+local-data: "10.1.168.192.in-addr.lan. 3600 IN PTR nas.lan."
+local-data: "_smb._tcp.lan. 3600 IN SRV 0 5 445 nas.lan."
+local-data: 'bell.lan. 3600 IN TXT "ring\007"'
+local-data: "files.lan. 3600 IN CNAME nas.lan."
+local-data: "lan. 3600 IN NS ns.lan."
+local-data: "lan. 3600 IN MX 10 mail.lan."
+local-data: "lan. 3600 IN ZONEMD \# 8 000000010101abcd"
+local-data: "nas.lan. 60 IN A 192.168.1.10"
+local-data: 'nas.lan. 3600 IN TXT "say \"hi\"" "two"'
+local-data: "nas.lan. 3600 IN AAAA fd00::10"
+local-data: 'nas.lan. 3600 IN CAA 0 issue "ca.lan"'
+`
+	if got := b.String(); want != got {
+		t.Errorf("want config\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestWriteDnsmasq(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	if err := WriteDnsmasq(&b, lanZone); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ptr-record=10.1.168.192.in-addr.lan,nas.lan
+srv-host=_smb._tcp.lan,nas.lan,445,0,5
+dns-rr=bell.lan,16,0572696e6707
+cname=files.lan,nas.lan,3600
+mx-host=lan,mail.lan,10
+dns-rr=lan,63,000000010101abcd
+host-record=nas.lan,192.168.1.10,60
+txt-record=nas.lan,"say \"hi\"","two"
+host-record=nas.lan,fd00::10,3600
+dns-rr=nas.lan,257,0005697373756563612e6c616e
+`
+	if got := b.String(); want != got {
+		t.Errorf("want config\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger logs the events of a Server or a Client, at the levels of log/slog.
+// The args are alternating keys and values, as for slog, so a *slog.Logger is
+// a Logger.
+//
+// Malformed queries, read and write failures are logged at LevelWarn, handler
+// panics and failed replies at LevelError, upstream failures at LevelWarn,
+// and zone transfers and EDNS fallbacks at LevelInfo and LevelDebug.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// stdLogger is a Logger writing the events of LevelWarn and above to a
+// log.Logger, or to the standard logger if nil.
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (l stdLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if level < slog.LevelWarn {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+
+	if l.l != nil {
+		l.l.Print(b.String())
+		return
+	}
+	log.Print(b.String())
+}
+
+func (s *Server) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Log(ctx, level, msg, args...)
+		return
+	}
+	stdLogger{s.ErrorLog}.Log(ctx, level, msg, args...)
+}
+
+func (c *Client) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Log(ctx, level, msg, args...)
+	}
+}
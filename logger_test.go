@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type logEntry struct {
+	level slog.Level
+	msg   string
+	args  []any
+}
+
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+	logc    chan logEntry
+}
+
+func (l *testLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	e := logEntry{level, msg, args}
+
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+
+	if l.logc != nil {
+		l.logc <- e
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	t.Parallel()
+
+	logger := &testLogger{logc: make(chan logEntry, 8)}
+
+	srv := &Server{
+		Addr:   mustUnusedAddr(),
+		Logger: logger,
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+			panic("boom")
+		}),
+	}
+	mustStart(srv)
+
+	conn, err := net.Dial("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-logger.logc:
+		if want, got := slog.LevelWarn, e.level; want != got {
+			t.Errorf("want level %v, got %v", want, got)
+		}
+		if want, got := "dns unpack", e.msg; want != got {
+			t.Errorf("want message %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("malformed query not logged")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := new(Client).Do(ctx, &Query{
+		RemoteAddr: addr,
+		Message:    &Message{Questions: []Question{questions["A"]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %v after a panic, got %v", want, got)
+	}
+
+	select {
+	case e := <-logger.logc:
+		if want, got := slog.LevelError, e.level; want != got {
+			t.Errorf("want level %v, got %v", want, got)
+		}
+		if want, got := "dns handler panic", e.msg; want != got {
+			t.Errorf("want message %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler panic not logged")
+	}
+}
+
+func TestClientLogger(t *testing.T) {
+	t.Parallel()
+
+	addr, err := net.ResolveTCPAddr("tcp", mustUnusedAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := new(testLogger)
+	client := &Client{Logger: logger}
+
+	if _, err := client.Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message:    &Message{Questions: []Question{questions["A"]}},
+	}); err == nil {
+		t.Fatal("want error for refused connection")
+	}
+
+	if want, got := 1, len(logger.entries); want != got {
+		t.Fatalf("want %d log entries, got %d", want, got)
+	}
+	if e := logger.entries[0]; e.level != slog.LevelWarn || e.msg != "dns dial" {
+		t.Errorf("want dial failure logged, got %+v", e)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := stdLogger{log.New(&buf, "", 0)}
+
+	l.Log(context.Background(), slog.LevelInfo, "dns transfer", "zone", "dev.")
+	l.Log(context.Background(), slog.LevelWarn, "dns unpack", "remote", "127.0.0.1:53", "err", errBaseLen)
+
+	if want, got := "dns unpack remote=127.0.0.1:53 err="+errBaseLen.Error()+"\n", buf.String(); want != got {
+		t.Errorf("want log %q, got %q", want, got)
+	}
+	if strings.Contains(buf.String(), "transfer") {
+		t.Error("want info events dropped")
+	}
+}
@@ -0,0 +1,251 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// Peer is a peer of an overlay network.
+type Peer struct {
+	// Name is the name of the peer under the domain of the network, such
+	// as "laptop".
+	Name string
+
+	// Addrs are the tunnel addresses of the peer.
+	Addrs []net.IP
+}
+
+// PeerProvider provides the peers of an overlay network, such as those of the
+// control plane of a WireGuard mesh. Peers is called for each query, so a
+// provider querying a remote service caches its result.
+type PeerProvider interface {
+	Peers(context.Context) ([]Peer, error)
+}
+
+// PeersFunc is an adapter to use a function as a PeerProvider.
+type PeersFunc func(context.Context) ([]Peer, error)
+
+// Peers calls f(ctx).
+func (f PeersFunc) Peers(ctx context.Context) ([]Peer, error) { return f(ctx) }
+
+// MagicDNS is a Handler resolving the names of the peers of an overlay
+// network to their tunnel addresses, like the MagicDNS of Tailscale. The A
+// and AAAA questions for <peer>.<Domain> are answered with the addresses of
+// the peer, and the PTR questions for its addresses with its name. A name
+// under the domain without a peer does not exist. The questions for other
+// names are forwarded upstream.
+//
+// The queries of the clients outside of the Networks, or received on a Server
+// address outside of the Interfaces, are refused, so that the names of the
+// network are only answered within it.
+type MagicDNS struct {
+	// Domain is the domain of the names of the peers, such as
+	// "tailnet.example.".
+	Domain string
+
+	Peers PeerProvider
+
+	// Networks, if not empty, are the networks of the clients answered,
+	// such as the tunnel network 100.64.0.0/10.
+	Networks []*net.IPNet
+
+	// Interfaces, if not empty, are the names of the network interfaces
+	// the queries are answered on, such as "wg0".
+	Interfaces []string
+
+	// TTL is the TTL of the answers. If zero, one minute is used.
+	TTL time.Duration
+}
+
+// ServeDNS answers the questions for the names of the peers, and forwards the
+// others upstream.
+func (m *MagicDNS) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	if !m.allowed(r) {
+		w.Status(Refused)
+		return
+	}
+
+	ttl := m.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+
+	var (
+		peers  []Peer
+		loaded bool
+	)
+	load := func() bool {
+		if !loaded {
+			var err error
+			if peers, err = m.Peers.Peers(ctx); err != nil {
+				w.Status(ServFail)
+				return false
+			}
+			loaded = true
+		}
+		return true
+	}
+
+	var miss bool
+	for _, q := range r.Questions {
+		if q.Type == TypePTR {
+			if !load() {
+				return
+			}
+			if peer, ok := m.reverse(peers, q.Name); ok {
+				w.Answer(q.Name, ttl, &PTR{PTR: m.fqdn(peer.Name)})
+				continue
+			}
+		}
+
+		k, ok := m.key(q.Name)
+		if !ok {
+			miss = true
+			continue
+		}
+		if !load() {
+			return
+		}
+		if k == "" {
+			continue // the domain itself
+		}
+
+		peer, ok := m.lookup(peers, k)
+		if !ok {
+			w.Status(NXDomain)
+			continue
+		}
+		for _, ip := range peer.Addrs {
+			ip4 := ip.To4()
+			switch {
+			case (q.Type == TypeA || q.Type == TypeALL) && ip4 != nil:
+				w.Answer(q.Name, ttl, &A{A: ip4})
+			case (q.Type == TypeAAAA || q.Type == TypeALL) && ip4 == nil:
+				w.Answer(q.Name, ttl, &AAAA{AAAA: ip.To16()})
+			}
+		}
+	}
+
+	if !miss {
+		return
+	}
+
+	msg, err := w.Recur(ctx)
+	if err != nil || msg == nil {
+		w.Status(ServFail)
+		return
+	}
+	writeMessage(w, msg)
+}
+
+// allowed reports whether the query r is answered.
+func (m *MagicDNS) allowed(r *Query) bool {
+	if len(m.Networks) > 0 {
+		ip := addrIP(r.RemoteAddr)
+		if ip == nil || !inNetworks(m.Networks, ip) {
+			return false
+		}
+	}
+
+	if len(m.Interfaces) > 0 {
+		ip := addrIP(r.LocalAddr)
+		if ip == nil || !onInterfaces(m.Interfaces, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// key returns the name relative to the domain, or false if the name is
+// outside of the domain.
+func (m *MagicDNS) key(name string) (string, bool) {
+	name, domain := NormalizeKey(name), NormalizeKey(m.Domain)
+	switch {
+	case name == domain:
+		return "", true
+	case strings.HasSuffix(name, "."+domain):
+		return name[:len(name)-len(domain)-1], true
+	}
+	return "", false
+}
+
+func (m *MagicDNS) fqdn(name string) string {
+	return NormalizeKey(name) + "." + NormalizeKey(m.Domain) + "."
+}
+
+func (m *MagicDNS) lookup(peers []Peer, k string) (Peer, bool) {
+	for _, peer := range peers {
+		if NormalizeKey(peer.Name) == k {
+			return peer, true
+		}
+	}
+	return Peer{}, false
+}
+
+// reverse returns the peer of the address of the reverse name, if any.
+func (m *MagicDNS) reverse(peers []Peer, name string) (Peer, bool) {
+	name = NormalizeKey(name) + "."
+	for _, peer := range peers {
+		for _, ip := range peer.Addrs {
+			if rev, ok := ReverseName(ip); ok && rev == name {
+				return peer, true
+			}
+		}
+	}
+	return Peer{}, false
+}
+
+// ReverseName returns the domain name of the PTR record of ip, under
+// in-addr.arpa. for an IPv4 address, or ip6.arpa. for an IPv6 one, see RFC
+// 1035, section 3.5, and RFC 3596, section 2.5. It returns false if ip is not
+// an address.
+func ReverseName(ip net.IP) (string, bool) {
+	const hexDigits = "0123456789abcdef"
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return net.IPv4(ip4[3], ip4[2], ip4[1], ip4[0]).String() + ".in-addr.arpa.", true
+	}
+
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return "", false
+	}
+
+	b := make([]byte, 0, 4*len(ip16)+len("ip6.arpa."))
+	for i := len(ip16) - 1; i >= 0; i-- {
+		b = append(b, hexDigits[ip16[i]&0xF], '.', hexDigits[ip16[i]>>4], '.')
+	}
+	return string(append(b, "ip6.arpa."...)), true
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// onInterfaces reports whether ip is an address of one of the network
+// interfaces named.
+func onInterfaces(names []string, ip net.IP) bool {
+	for _, name := range names {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMagicDNS(t *testing.T) {
+	t.Parallel()
+
+	_, tunnel, _ := net.ParseCIDR("100.64.0.0/10")
+
+	magic := &MagicDNS{
+		Domain: "tailnet.example.",
+		Peers: PeersFunc(func(context.Context) ([]Peer, error) {
+			return []Peer{
+				{Name: "laptop", Addrs: []net.IP{net.ParseIP("100.64.0.1"), net.ParseIP("fd7a:115c:a1e0::1")}},
+				{Name: "NAS", Addrs: []net.IP{net.ParseIP("100.64.0.2")}},
+			}, nil
+		}),
+		Networks:   []*net.IPNet{tunnel},
+		Interfaces: []string{"lo"},
+	}
+
+	upstream := Question{Name: "example.com.", Type: TypeA, Class: ClassIN}
+	srv := &Server{
+		Handler: magic,
+		Forwarder: &Client{
+			Transport: nopDialer{},
+			Resolver:  &answerHandler{map[Question]Record{upstream: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		},
+	}
+
+	peer := &net.UDPAddr{IP: net.ParseIP("100.64.0.9"), Port: 53000}
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+
+	tests := []struct {
+		name string
+
+		question      Question
+		remote, local net.Addr
+
+		rcode   RCode
+		answers []Record
+	}{
+		{
+			name:     "A",
+			question: Question{Name: "laptop.tailnet.example.", Type: TypeA, Class: ClassIN},
+			answers:  []Record{&A{A: net.ParseIP("100.64.0.1").To4()}},
+		},
+		{
+			name:     "AAAA",
+			question: Question{Name: "Laptop.Tailnet.Example.", Type: TypeAAAA, Class: ClassIN},
+			answers:  []Record{&AAAA{AAAA: net.ParseIP("fd7a:115c:a1e0::1")}},
+		},
+		{
+			name:     "no data",
+			question: Question{Name: "nas.tailnet.example.", Type: TypeAAAA, Class: ClassIN},
+		},
+		{
+			name:     "domain",
+			question: Question{Name: "tailnet.example.", Type: TypeA, Class: ClassIN},
+		},
+		{
+			name:     "nxdomain",
+			question: Question{Name: "phone.tailnet.example.", Type: TypeA, Class: ClassIN},
+			rcode:    NXDomain,
+		},
+		{
+			name:     "PTR",
+			question: Question{Name: "2.0.64.100.in-addr.arpa.", Type: TypePTR, Class: ClassIN},
+			answers:  []Record{&PTR{PTR: "nas.tailnet.example."}},
+		},
+		{
+			name:     "forwarded",
+			question: upstream,
+			answers:  []Record{&A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		},
+		{
+			name:     "outside network",
+			question: Question{Name: "laptop.tailnet.example.", Type: TypeA, Class: ClassIN},
+			remote:   &net.UDPAddr{IP: net.ParseIP("192.0.2.9"), Port: 53000},
+			rcode:    Refused,
+		},
+		{
+			name:     "outside interfaces",
+			question: Question{Name: "laptop.tailnet.example.", Type: TypeA, Class: ClassIN},
+			local:    &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53},
+			rcode:    Refused,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{
+				Message:    &Message{Questions: []Question{test.question}},
+				RemoteAddr: peer,
+				LocalAddr:  local,
+			}
+			if test.remote != nil {
+				q.RemoteAddr = test.remote
+			}
+			if test.local != nil {
+				q.LocalAddr = test.local
+			}
+
+			msg, err := srv.ServeMessage(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+
+			var answers []Record
+			for _, res := range msg.Answers {
+				answers = append(answers, res.Record)
+			}
+			if want, got := test.answers, answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestMagicDNSPeersError(t *testing.T) {
+	t.Parallel()
+
+	magic := &MagicDNS{
+		Domain: "tailnet.example.",
+		Peers: PeersFunc(func(context.Context) ([]Peer, error) {
+			return nil, errors.New("control plane unreachable")
+		}),
+	}
+
+	msg, err := (&Server{Handler: magic}).ServeMessage(context.Background(), &Query{
+		Message: &Message{Questions: []Question{{Name: "laptop.tailnet.example.", Type: TypeA, Class: ClassIN}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %v, got %v", want, got)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ip   net.IP
+		name string
+	}{
+		{net.ParseIP("192.0.2.1"), "1.2.0.192.in-addr.arpa."},
+		{net.ParseIP("2001:db8::1"), "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, test := range tests {
+		if got, ok := ReverseName(test.ip); !ok || test.name != got {
+			t.Errorf("%s: want name %q, got %q", test.ip, test.name, got)
+		}
+	}
+	if _, ok := ReverseName(nil); ok {
+		t.Error("want no name of no address")
+	}
+}
@@ -10,7 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/helmutkemper/dns/edns"
@@ -51,6 +52,10 @@ func (el Type) String() string {
 		return "TypeDNAME"
 	case 41:
 		return "TypeOPT"
+	case 63:
+		return "TypeZONEMD"
+	case 251:
+		return "TypeIXFR"
 	case 252:
 		return "TypeAXFR"
 	case 255:
@@ -78,23 +83,25 @@ type RCode uint16
 // Taken from https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml
 const (
 	// Resource Record (RR) TYPEs
-	TypeA     Type = 1   // [RFC1035] a host address
-	TypeNS    Type = 2   // [RFC1035] an authoritative name server
-	TypeCNAME Type = 5   // [RFC1035] the canonical name for an alias
-	TypeSOA   Type = 6   // [RFC1035] marks the start of a zone of authority
-	TypeWKS   Type = 11  // [RFC1035] a well known service description
-	TypePTR   Type = 12  // [RFC1035] a domain name pointer
-	TypeHINFO Type = 13  // [RFC1035] host information
-	TypeMINFO Type = 14  // [RFC1035] mailbox or mail list information
-	TypeMX    Type = 15  // [RFC1035] mail exchange
-	TypeTXT   Type = 16  // [RFC1035] text strings
-	TypeAAAA  Type = 28  // [RFC3596] IP6 Address
-	TypeSRV   Type = 33  // [RFC2782] Server Selection
-	TypeDNAME Type = 39  // [RFC6672] DNAME
-	TypeOPT   Type = 41  // [RFC6891][RFC3225] OPT
-	TypeAXFR  Type = 252 // [RFC1035][RFC5936] transfer of an entire zone
-	TypeALL   Type = 255 // [RFC1035][RFC6895] A request for all records the server/cache has available
-	TypeCAA   Type = 257 // [RFC6844] Certification Authority Restriction
+	TypeA      Type = 1   // [RFC1035] a host address
+	TypeNS     Type = 2   // [RFC1035] an authoritative name server
+	TypeCNAME  Type = 5   // [RFC1035] the canonical name for an alias
+	TypeSOA    Type = 6   // [RFC1035] marks the start of a zone of authority
+	TypeWKS    Type = 11  // [RFC1035] a well known service description
+	TypePTR    Type = 12  // [RFC1035] a domain name pointer
+	TypeHINFO  Type = 13  // [RFC1035] host information
+	TypeMINFO  Type = 14  // [RFC1035] mailbox or mail list information
+	TypeMX     Type = 15  // [RFC1035] mail exchange
+	TypeTXT    Type = 16  // [RFC1035] text strings
+	TypeAAAA   Type = 28  // [RFC3596] IP6 Address
+	TypeSRV    Type = 33  // [RFC2782] Server Selection
+	TypeDNAME  Type = 39  // [RFC6672] DNAME
+	TypeOPT    Type = 41  // [RFC6891][RFC3225] OPT
+	TypeZONEMD Type = 63  // [RFC8976] Message Digest for DNS Zones
+	TypeIXFR   Type = 251 // [RFC1995] incremental transfer
+	TypeAXFR   Type = 252 // [RFC1035][RFC5936] transfer of an entire zone
+	TypeALL    Type = 255 // [RFC1035][RFC6895] A request for all records the server/cache has available
+	TypeCAA    Type = 257 // [RFC8659] Certification Authority Restriction
 
 	TypeANY Type = 0
 
@@ -111,24 +118,27 @@ const (
 	NXDomain RCode = 3 // [RFC1035] Non-Existent Domain
 	NotImp   RCode = 4 // [RFC1035] Not Implemented
 	Refused  RCode = 5 // [RFC1035] Query Refused
+	YXDomain RCode = 6 // [RFC2136] Name Exists when it should not
 
 	maxPacketLen = 512
 )
 
 // NewRecordByType returns a new instance of a Record for a Type.
 var NewRecordByType = map[Type]func() Record{
-	TypeA:     func() Record { return new(A) },
-	TypeNS:    func() Record { return new(NS) },
-	TypeCNAME: func() Record { return new(CNAME) },
-	TypeSOA:   func() Record { return new(SOA) },
-	TypePTR:   func() Record { return new(PTR) },
-	TypeMX:    func() Record { return new(MX) },
-	TypeTXT:   func() Record { return new(TXT) },
-	TypeAAAA:  func() Record { return new(AAAA) },
-	TypeSRV:   func() Record { return new(SRV) },
-	TypeDNAME: func() Record { return new(DNAME) },
-	TypeOPT:   func() Record { return new(OPT) },
-	TypeCAA:   func() Record { return new(CAA) },
+	TypeA:      func() Record { return new(A) },
+	TypeNS:     func() Record { return new(NS) },
+	TypeCNAME:  func() Record { return new(CNAME) },
+	TypeSOA:    func() Record { return new(SOA) },
+	TypeWKS:    func() Record { return new(WKS) },
+	TypePTR:    func() Record { return new(PTR) },
+	TypeMX:     func() Record { return new(MX) },
+	TypeTXT:    func() Record { return new(TXT) },
+	TypeAAAA:   func() Record { return new(AAAA) },
+	TypeSRV:    func() Record { return new(SRV) },
+	TypeDNAME:  func() Record { return new(DNAME) },
+	TypeOPT:    func() Record { return new(OPT) },
+	TypeCAA:    func() Record { return new(CAA) },
+	TypeZONEMD: func() Record { return new(ZONEMD) },
 }
 
 var (
@@ -168,6 +178,9 @@ type Message struct {
 	Truncated          bool
 	RecursionDesired   bool
 	RecursionAvailable bool
+	AuthenticData      bool // AD bit, RFC 4035
+	CheckingDisabled   bool // CD bit, RFC 4035
+	Z                  bool // reserved bit, zero in valid messages
 	RCode              RCode
 
 	Questions   []Question
@@ -179,35 +192,44 @@ type Message struct {
 // Pack encodes m as a byte slice. If b is not nil, m is appended into b.
 // Domain name compression is enabled by setting compress.
 func (m *Message) Pack(b []byte, compress bool) ([]byte, error) {
+	b, _, err := m.pack(b, compress)
+	return b, err
+}
+
+// pack is Pack also returning the number of bytes saved by name compression.
+func (m *Message) pack(b []byte, compress bool) ([]byte, int, error) {
 	if b == nil {
 		b = make([]byte, 0, maxPacketLen)
 	}
 
-	var com Compressor
+	var (
+		com   Compressor
+		saved int
+	)
 	if compress {
-		com = compressor{tbl: make(map[string]int), offset: len(b)}
+		com = compressor{tbl: make(map[string]int), offset: len(b), saved: &saved}
 	}
 
 	var err error
 	if b, err = m.packHeader(b); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	for _, q := range m.Questions {
 		if b, err = q.Pack(b, com); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
 	for _, rs := range [3][]Resource{m.Answers, m.Authorities, m.Additionals} {
 		for _, r := range rs {
 			if b, err = r.Pack(b, com); err != nil {
-				return nil, err
+				return nil, 0, err
 			}
 		}
 	}
 
-	return b, nil
+	return b, saved, nil
 }
 
 // Unpack decodes m from b. Unused bytes are returned.
@@ -257,8 +279,41 @@ const (
 	headerBitTC = 1 << 9  // truncated
 	headerBitRD = 1 << 8  // recursion desired
 	headerBitRA = 1 << 7  // recursion available
+	headerBitZ  = 1 << 6  // reserved
+	headerBitAD = 1 << 5  // authentic data
+	headerBitCD = 1 << 4  // checking disabled
 )
 
+// headerBits returns the flags and codes of the header.
+func (m *Message) headerBits() uint16 {
+	bits := uint16(m.OpCode&0x0F)<<11 | uint16(m.RCode&0x0F)
+	if m.Response {
+		bits |= headerBitQR
+	}
+	if m.RecursionAvailable {
+		bits |= headerBitRA
+	}
+	if m.RecursionDesired {
+		bits |= headerBitRD
+	}
+	if m.Truncated {
+		bits |= headerBitTC
+	}
+	if m.Authoritative {
+		bits |= headerBitAA
+	}
+	if m.AuthenticData {
+		bits |= headerBitAD
+	}
+	if m.CheckingDisabled {
+		bits |= headerBitCD
+	}
+	if m.Z {
+		bits |= headerBitZ
+	}
+	return bits
+}
+
 func (m *Message) packHeader(b []byte) ([]byte, error) {
 	id := uint16(m.ID)
 	if int(id) != m.ID {
@@ -275,22 +330,7 @@ func (m *Message) packHeader(b []byte) ([]byte, error) {
 		return nil, errFieldOverflow
 	}
 
-	bits := uint16(opcode)<<11 | uint16(rcode)
-	if m.Response {
-		bits |= headerBitQR
-	}
-	if m.RecursionAvailable {
-		bits |= headerBitRA
-	}
-	if m.RecursionDesired {
-		bits |= headerBitRD
-	}
-	if m.Truncated {
-		bits |= headerBitTC
-	}
-	if m.Authoritative {
-		bits |= headerBitAA
-	}
+	bits := m.headerBits()
 
 	qdcount := uint16(len(m.Questions))
 	if int(qdcount) != len(m.Questions) {
@@ -323,17 +363,37 @@ func (m *Message) packHeader(b []byte) ([]byte, error) {
 }
 
 func (m *Message) unpackHeader(b []byte) ([]byte, error) {
+	qdcount, ancount, nscount, arcount, err := m.readHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if qdcount > 0 {
+		m.Questions = make([]Question, 0, qdcount)
+	}
+	if ancount > 0 {
+		m.Answers = make([]Resource, 0, ancount)
+	}
+	if nscount > 0 {
+		m.Authorities = make([]Resource, 0, nscount)
+	}
+	if arcount > 0 {
+		m.Additionals = make([]Resource, 0, arcount)
+	}
+
+	return b[12:], nil
+}
+
+// readHeader reads the header of b into m, without sections, and returns the
+// record count of each section.
+func (m *Message) readHeader(b []byte) (qdcount, ancount, nscount, arcount int, err error) {
 	if len(b) < 12 {
-		return nil, errResourceLen
+		return 0, 0, 0, 0, errResourceLen
 	}
 
 	var (
-		id      = int(nbo.Uint16(b))
-		bits    = nbo.Uint16(b[2:])
-		qdcount = nbo.Uint16(b[4:])
-		ancount = nbo.Uint16(b[6:])
-		nscount = nbo.Uint16(b[8:])
-		arcount = nbo.Uint16(b[10:])
+		id   = int(nbo.Uint16(b))
+		bits = nbo.Uint16(b[2:])
 	)
 
 	*m = Message{
@@ -344,23 +404,13 @@ func (m *Message) unpackHeader(b []byte) ([]byte, error) {
 		Truncated:          (bits & headerBitTC) > 0,
 		RecursionDesired:   (bits & headerBitRD) > 0,
 		RecursionAvailable: (bits & headerBitRA) > 0,
+		AuthenticData:      (bits & headerBitAD) > 0,
+		CheckingDisabled:   (bits & headerBitCD) > 0,
+		Z:                  (bits & headerBitZ) > 0,
 		RCode:              RCode(bits) & 0xF,
 	}
 
-	if qdcount > 0 {
-		m.Questions = make([]Question, 0, qdcount)
-	}
-	if ancount > 0 {
-		m.Answers = make([]Resource, 0, ancount)
-	}
-	if nscount > 0 {
-		m.Authorities = make([]Resource, 0, nscount)
-	}
-	if arcount > 0 {
-		m.Additionals = make([]Resource, 0, arcount)
-	}
-
-	return b[12:], nil
+	return int(nbo.Uint16(b[4:])), int(nbo.Uint16(b[6:])), int(nbo.Uint16(b[8:])), int(nbo.Uint16(b[10:])), nil
 }
 
 // A Question is a DNS query.
@@ -435,24 +485,25 @@ func (r Resource) Pack(b []byte, com Compressor) ([]byte, error) {
 		return nil, errFieldOverflow
 	}
 
-	rlen, err := r.Record.Length(com)
-	if err != nil {
-		return nil, err
-	}
-
-	rdatalen := uint16(rlen)
-	if int(rdatalen) != rlen {
-		return nil, errFieldOverflow
-	}
-
 	buf := [10]byte{}
 	nbo.PutUint16(buf[:2], uint16(rtype))
 	nbo.PutUint16(buf[2:4], uint16(r.Class))
 	nbo.PutUint32(buf[4:8], ttl)
-	nbo.PutUint16(buf[8:10], rdatalen)
 	b = append(b, buf[:]...)
 
-	return r.Record.Pack(b, com)
+	// RDLENGTH is set once the RDATA is packed, the names it compresses
+	// depending on their offsets in the message
+	start := len(b)
+	if b, err = r.Record.Pack(b, com); err != nil {
+		return nil, err
+	}
+
+	rdatalen := uint16(len(b) - start)
+	if int(rdatalen) != len(b)-start {
+		return nil, errFieldOverflow
+	}
+	nbo.PutUint16(b[start-2:start], rdatalen)
+	return b, nil
 }
 
 // Unpack decodes r from b.
@@ -494,6 +545,11 @@ func (r *Resource) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 }
 
 // Record is a DNS record.
+//
+// Records are immutable values: once a record is stored in an RRSet, added to
+// a message or shared between goroutines it must not be modified, and may be
+// read concurrently without locking. Unpack and FromJSon decode into a newly
+// created record. To change a record, build a new one and replace the old.
 type Record interface {
 	Type() Type
 	Length(Compressor) (int, error)
@@ -502,11 +558,25 @@ type Record interface {
 	Get() interface{}
 	String() string
 	FromJSon(string) error
+
+	// Key returns the record data in a form that identifies it among
+	// records of the same type. Domain names are lowercased, since they
+	// compare case-insensitively.
+	Key() string
+
+	// Equal reports whether the record has the same type and data as r.
+	Equal(r Record) bool
+}
+
+func recordEqual(a, b Record) bool {
+	if b == nil || a.Type() != b.Type() {
+		return false
+	}
+	return a.Key() == b.Key()
 }
 
 // A A is a DNS A record.
 type A struct {
-	l sync.Mutex
 	A net.IP
 }
 
@@ -518,9 +588,6 @@ func (A) Length(Compressor) (int, error) { return 4, nil }
 
 // Pack encodes a as RDATA.
 func (a A) Pack(b []byte, _ Compressor) ([]byte, error) {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	if len(a.A) < 4 {
 		return nil, errResourceLen
 	}
@@ -529,9 +596,6 @@ func (a A) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes a from RDATA in b.
 func (a *A) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	if len(b) < 4 {
 		return nil, errResourceLen
 	}
@@ -546,22 +610,24 @@ func (a *A) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 func (a *A) Get() interface{} { return a }
 
 func (a *A) String() string {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	bOut, _ := json.Marshal(a)
 	return string(bOut)
 }
 
+// Key returns the RDATA as a comparable string.
+func (a *A) Key() string {
+	return a.A.String()
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (a *A) Equal(r Record) bool { return recordEqual(a, r) }
+
 func (a *A) FromJSon(v string) error {
-	a.l.Lock()
-	defer a.l.Unlock()
 	return json.Unmarshal([]byte(v), a)
 }
 
 // AAAA is a DNS AAAA record.
 type AAAA struct {
-	l    sync.Mutex
 	AAAA net.IP
 }
 
@@ -573,9 +639,6 @@ func (AAAA) Length(Compressor) (int, error) { return 16, nil }
 
 // Pack encodes a as RDATA.
 func (a AAAA) Pack(b []byte, _ Compressor) ([]byte, error) {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	if len(a.AAAA) != 16 {
 		return nil, errResourceLen
 	}
@@ -584,9 +647,6 @@ func (a AAAA) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes a from RDATA in b.
 func (a *AAAA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	if len(b) < 16 {
 		return nil, errResourceLen
 	}
@@ -599,29 +659,28 @@ func (a *AAAA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 }
 
 func (a *AAAA) Get() interface{} {
-	a.l.Lock()
-	defer a.l.Unlock()
 	return a
 }
 
-func (a *AAAA) String() string {
-	a.l.Lock()
-	defer a.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (a *AAAA) Key() string {
+	return a.AAAA.String()
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (a *AAAA) Equal(r Record) bool { return recordEqual(a, r) }
 
+func (a *AAAA) String() string {
 	bOut, _ := json.Marshal(a)
 	return string(bOut)
 }
 
 func (a *AAAA) FromJSon(v string) error {
-	a.l.Lock()
-	defer a.l.Unlock()
-
 	return json.Unmarshal([]byte(v), a)
 }
 
 // CNAME is a DNS CNAME record.
 type CNAME struct {
-	l     sync.Mutex
 	CNAME string
 }
 
@@ -630,53 +689,44 @@ func (CNAME) Type() Type { return TypeCNAME }
 
 // Length returns the encoded RDATA size.
 func (c CNAME) Length(com Compressor) (int, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	return com.Length(c.CNAME)
 }
 
 // Pack encodes c as RDATA.
 func (c CNAME) Pack(b []byte, com Compressor) ([]byte, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	return com.Pack(b, c.CNAME)
 }
 
 // Unpack decodes c from RDATA in b.
 func (c *CNAME) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	var err error
 	c.CNAME, b, err = dec.Unpack(b)
 	return b, err
 }
 
 func (c *CNAME) Get() interface{} {
-	c.l.Lock()
-	defer c.l.Unlock()
 	return c
 }
 
-func (c *CNAME) String() string {
-	c.l.Lock()
-	defer c.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (c *CNAME) Key() string {
+	return strings.ToLower(c.CNAME)
+}
 
+// Equal reports whether r has the same type and RDATA.
+func (c *CNAME) Equal(r Record) bool { return recordEqual(c, r) }
+
+func (c *CNAME) String() string {
 	bOut, _ := json.Marshal(c)
 	return string(bOut)
 }
 
 func (c *CNAME) FromJSon(v string) error {
-	c.l.Lock()
-	defer c.l.Unlock()
 	return json.Unmarshal([]byte(v), c)
 }
 
 // SOA is a DNS SOA record.
 type SOA struct {
-	l       sync.Mutex
 	NS      string
 	MBox    string
 	Serial  int
@@ -691,9 +741,6 @@ func (SOA) Type() Type { return TypeSOA }
 
 // Length returns the encoded RDATA size.
 func (s SOA) Length(com Compressor) (int, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	n, err := com.Length(s.NS, s.MBox)
 	if err != nil {
 		return 0, err
@@ -703,9 +750,6 @@ func (s SOA) Length(com Compressor) (int, error) {
 
 // Pack encodes s as RDATA.
 func (s SOA) Pack(b []byte, com Compressor) ([]byte, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	var err error
 	if b, err = com.Pack(b, s.NS); err != nil {
 		return nil, err
@@ -750,9 +794,6 @@ func (s SOA) Pack(b []byte, com Compressor) ([]byte, error) {
 
 // Unpack decodes s from RDATA in b.
 func (s *SOA) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	var err error
 	if s.NS, b, err = dec.Unpack(b); err != nil {
 		return nil, err
@@ -783,29 +824,99 @@ func (s *SOA) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 }
 
 func (s *SOA) Get() interface{} {
-	s.l.Lock()
-	defer s.l.Unlock()
 	return s
 }
 
-func (s *SOA) String() string {
-	s.l.Lock()
-	defer s.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (s *SOA) Key() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", strings.ToLower(s.NS), strings.ToLower(s.MBox), s.Serial,
+		s.Refresh/time.Second, s.Retry/time.Second, s.Expire/time.Second, s.MinTTL/time.Second)
+}
 
+// Equal reports whether r has the same type and RDATA.
+func (s *SOA) Equal(r Record) bool { return recordEqual(s, r) }
+
+func (s *SOA) String() string {
 	bOut, _ := json.Marshal(s)
 	return string(bOut)
 }
 
 func (s *SOA) FromJSon(v string) error {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	return json.Unmarshal([]byte(v), s)
 }
 
+// WKS is a DNS WKS record, describing the well known services of a protocol
+// at an address, see RFC 1035, section 3.4.2. WKS is obsolete, it is only
+// supported so that legacy zones can still be transferred and served.
+type WKS struct {
+	Address  net.IP
+	Protocol int
+
+	// BitMap has bit n set, from the most significant bit of the first byte,
+	// if the service on port n is available.
+	BitMap []byte
+}
+
+// Type returns the RR type identifier.
+func (WKS) Type() Type { return TypeWKS }
+
+// Length returns the encoded RDATA size.
+func (w WKS) Length(_ Compressor) (int, error) {
+	return 5 + len(w.BitMap), nil
+}
+
+// Pack encodes w as RDATA.
+func (w WKS) Pack(b []byte, _ Compressor) ([]byte, error) {
+	ip := w.Address.To4()
+	if ip == nil {
+		return nil, errResourceLen
+	}
+
+	protocol := uint8(w.Protocol)
+	if int(protocol) != w.Protocol {
+		return nil, errFieldOverflow
+	}
+
+	b = append(append(b, ip...), protocol)
+	return append(b, w.BitMap...), nil
+}
+
+// Unpack decodes w from RDATA in b.
+func (w *WKS) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 5 {
+		return nil, errResourceLen
+	}
+
+	w.Address = append(net.IP(nil), b[:4]...)
+	w.Protocol = int(b[4])
+	w.BitMap = append([]byte(nil), b[5:]...)
+
+	return nil, nil
+}
+
+func (w *WKS) Get() interface{} {
+	return w
+}
+
+// Key returns the RDATA as a comparable string.
+func (w *WKS) Key() string {
+	return fmt.Sprintf("%s %d %x", w.Address, w.Protocol, w.BitMap)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (w *WKS) Equal(r Record) bool { return recordEqual(w, r) }
+
+func (w *WKS) String() string {
+	bOut, _ := json.Marshal(w)
+	return string(bOut)
+}
+
+func (w *WKS) FromJSon(v string) error {
+	return json.Unmarshal([]byte(v), w)
+}
+
 // PTR is a DNS PTR record.
 type PTR struct {
-	l   sync.Mutex
 	PTR string
 }
 
@@ -814,55 +925,44 @@ func (PTR) Type() Type { return TypePTR }
 
 // Length returns the encoded RDATA size.
 func (p PTR) Length(com Compressor) (int, error) {
-	p.l.Lock()
-	defer p.l.Unlock()
-
 	return com.Length(p.PTR)
 }
 
 // Pack encodes p as RDATA.
 func (p PTR) Pack(b []byte, com Compressor) ([]byte, error) {
-	p.l.Lock()
-	defer p.l.Unlock()
-
 	return com.Pack(b, p.PTR)
 }
 
 // Unpack decodes p from RDATA in b.
 func (p *PTR) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	p.l.Lock()
-	defer p.l.Unlock()
-
 	var err error
 	p.PTR, b, err = dec.Unpack(b)
 	return b, err
 }
 
 func (p *PTR) Get() interface{} {
-	p.l.Lock()
-	defer p.l.Unlock()
-
 	return p
 }
 
-func (p *PTR) String() string {
-	p.l.Lock()
-	defer p.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (p *PTR) Key() string {
+	return strings.ToLower(p.PTR)
+}
 
+// Equal reports whether r has the same type and RDATA.
+func (p *PTR) Equal(r Record) bool { return recordEqual(p, r) }
+
+func (p *PTR) String() string {
 	bOut, _ := json.Marshal(p)
 	return string(bOut)
 }
 
 func (p *PTR) FromJSon(v string) error {
-	p.l.Lock()
-	defer p.l.Unlock()
-
 	return json.Unmarshal([]byte(v), p)
 }
 
 // MX is a DNS MX record.
 type MX struct {
-	l    sync.Mutex
 	Pref int
 	MX   string
 }
@@ -872,9 +972,6 @@ func (MX) Type() Type { return TypeMX }
 
 // Length returns the encoded RDATA size.
 func (m MX) Length(com Compressor) (int, error) {
-	m.l.Lock()
-	defer m.l.Unlock()
-
 	n, err := com.Length(m.MX)
 	if err != nil {
 		return 0, err
@@ -884,9 +981,6 @@ func (m MX) Length(com Compressor) (int, error) {
 
 // Pack encodes m as RDATA.
 func (m MX) Pack(b []byte, com Compressor) ([]byte, error) {
-	m.l.Lock()
-	defer m.l.Unlock()
-
 	pref := uint16(m.Pref)
 	if int(pref) != m.Pref {
 		return nil, errFieldOverflow
@@ -900,9 +994,6 @@ func (m MX) Pack(b []byte, com Compressor) ([]byte, error) {
 
 // Unpack decodes m from RDATA in b.
 func (m *MX) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	m.l.Lock()
-	defer m.l.Unlock()
-
 	if len(b) < 2 {
 		return nil, errResourceLen
 	}
@@ -915,30 +1006,28 @@ func (m *MX) Unpack(b []byte, dec Decompressor) ([]byte, error) {
 }
 
 func (m *MX) Get() interface{} {
-	m.l.Lock()
-	defer m.l.Unlock()
-
 	return m
 }
 
-func (m *MX) String() string {
-	m.l.Lock()
-	defer m.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (m *MX) Key() string {
+	return strconv.Itoa(m.Pref) + " " + strings.ToLower(m.MX)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (m *MX) Equal(r Record) bool { return recordEqual(m, r) }
 
+func (m *MX) String() string {
 	bOut, _ := json.Marshal(m)
 	return string(bOut)
 }
 
 func (m *MX) FromJSon(v string) error {
-	m.l.Lock()
-	defer m.l.Unlock()
-
 	return json.Unmarshal([]byte(v), m)
 }
 
 // NS is a DNS MX record.
 type NS struct {
-	l  sync.Mutex
 	NS string
 }
 
@@ -947,55 +1036,46 @@ func (NS) Type() Type { return TypeNS }
 
 // Length returns the encoded RDATA size.
 func (n NS) Length(com Compressor) (int, error) {
-	n.l.Lock()
-	defer n.l.Unlock()
-
 	return com.Length(n.NS)
 }
 
 // Pack encodes n as RDATA.
 func (n NS) Pack(b []byte, com Compressor) ([]byte, error) {
-	n.l.Lock()
-	defer n.l.Unlock()
-
 	return com.Pack(b, n.NS)
 }
 
 // Unpack decodes n from RDATA in b.
 func (n *NS) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	n.l.Lock()
-	defer n.l.Unlock()
-
 	var err error
 	n.NS, b, err = dec.Unpack(b)
 	return b, err
 }
 
 func (n *NS) Get() interface{} {
-	n.l.Lock()
-	defer n.l.Unlock()
-
 	return n
 }
 
-func (n *NS) String() string {
-	n.l.Lock()
-	defer n.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (n *NS) Key() string {
+	return strings.ToLower(n.NS)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (n *NS) Equal(r Record) bool { return recordEqual(n, r) }
 
+func (n *NS) String() string {
 	bOut, _ := json.Marshal(n)
 	return string(bOut)
 }
 
 func (n *NS) FromJSon(v string) error {
-	n.l.Lock()
-	defer n.l.Unlock()
-
 	return json.Unmarshal([]byte(v), n)
 }
 
 // TXT is a DNS TXT record.
 type TXT struct {
-	l   sync.Mutex
+	// TXT holds the character-strings of the record. Strings longer than 255
+	// bytes are split into several character-strings when packed.
 	TXT []string
 }
 
@@ -1004,24 +1084,22 @@ func (TXT) Type() Type { return TypeTXT }
 
 // Length returns the encoded RDATA size.
 func (t TXT) Length(_ Compressor) (int, error) {
-	t.l.Lock()
-	defer t.l.Unlock()
-
 	var n int
 	for _, s := range t.TXT {
-		n += 1 + len(s)
+		n += len(s) + (len(s)+254)/255
+		if len(s) == 0 {
+			n++
+		}
 	}
 	return n, nil
 }
 
 // Pack encodes t as RDATA.
 func (t TXT) Pack(b []byte, _ Compressor) ([]byte, error) {
-	t.l.Lock()
-	defer t.l.Unlock()
-
 	for _, s := range t.TXT {
-		if len(s) > 255 {
-			return nil, errSegTooLong
+		for len(s) > 255 {
+			b = append(append(b, 255), s[:255]...)
+			s = s[255:]
 		}
 
 		b = append(append(b, byte(len(s))), []byte(s)...)
@@ -1031,9 +1109,6 @@ func (t TXT) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes t from RDATA in b.
 func (t *TXT) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	t.l.Lock()
-	defer t.l.Unlock()
-
 	var txts []string
 	for len(b) > 0 {
 		txtlen := int(b[0])
@@ -1050,30 +1125,38 @@ func (t *TXT) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 }
 
 func (t *TXT) Get() interface{} {
-	t.l.Lock()
-	defer t.l.Unlock()
-
 	return t
 }
 
-func (t *TXT) String() string {
-	t.l.Lock()
-	defer t.l.Unlock()
+// Data returns the character-strings of t joined together, the text of
+// records such as SPF, DKIM or DMARC records split across several strings.
+func (t *TXT) Data() string {
+	return strings.Join(t.TXT, "")
+}
 
+// Key returns the RDATA as a comparable string.
+func (t *TXT) Key() string {
+	txts := make([]string, 0, len(t.TXT))
+	for _, txt := range t.TXT {
+		txts = append(txts, strconv.Quote(txt))
+	}
+	return strings.Join(txts, " ")
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (t *TXT) Equal(r Record) bool { return recordEqual(t, r) }
+
+func (t *TXT) String() string {
 	bOut, _ := json.Marshal(t)
 	return string(bOut)
 }
 
 func (t *TXT) FromJSon(v string) error {
-	t.l.Lock()
-	defer t.l.Unlock()
-
 	return json.Unmarshal([]byte(v), t)
 }
 
 // SRV is a DNS SRV record.
 type SRV struct {
-	l        sync.Mutex
 	Priority int
 	Weight   int
 	Port     int
@@ -1085,9 +1168,6 @@ func (SRV) Type() Type { return TypeSRV }
 
 // Length returns the encoded RDATA size.
 func (s SRV) Length(_ Compressor) (int, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	n, err := compressor{}.Length(s.Target)
 	if err != nil {
 		return 0, err
@@ -1097,9 +1177,6 @@ func (s SRV) Length(_ Compressor) (int, error) {
 
 // Pack encodes s as RDATA.
 func (s SRV) Pack(b []byte, _ Compressor) ([]byte, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	var (
 		priority = uint16(s.Priority)
 		weight   = uint16(s.Weight)
@@ -1126,9 +1203,6 @@ func (s SRV) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes s from RDATA in b.
 func (s *SRV) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	if len(b) < 6 {
 		return nil, errResourceLen
 	}
@@ -1143,30 +1217,28 @@ func (s *SRV) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 }
 
 func (s *SRV) Get() interface{} {
-	s.l.Lock()
-	defer s.l.Unlock()
-
 	return s
 }
 
-func (s *SRV) String() string {
-	s.l.Lock()
-	defer s.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (s *SRV) Key() string {
+	return fmt.Sprintf("%d %d %d %s", s.Priority, s.Weight, s.Port, strings.ToLower(s.Target))
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (s *SRV) Equal(r Record) bool { return recordEqual(s, r) }
 
+func (s *SRV) String() string {
 	bOut, _ := json.Marshal(s)
 	return string(bOut)
 }
 
 func (s *SRV) FromJSon(v string) error {
-	s.l.Lock()
-	defer s.l.Unlock()
-	fmt.Printf("srv v: %s\n", v)
 	return json.Unmarshal([]byte(v), s)
 }
 
 // DNAME is a DNS DNAME record.
 type DNAME struct {
-	l     sync.Mutex
 	DNAME string
 }
 
@@ -1174,56 +1246,45 @@ type DNAME struct {
 func (DNAME) Type() Type { return TypeDNAME }
 
 // Length returns the encoded RDATA size.
-func (d DNAME) Length(com Compressor) (int, error) {
-	d.l.Lock()
-	defer d.l.Unlock()
-
-	return com.Length(d.DNAME)
+func (d DNAME) Length(_ Compressor) (int, error) {
+	return compressor{}.Length(d.DNAME)
 }
 
-// Pack encodes c as RDATA.
-func (d DNAME) Pack(b []byte, com Compressor) ([]byte, error) {
-	d.l.Lock()
-	defer d.l.Unlock()
-
-	return com.Pack(b, d.DNAME)
+// Pack encodes c as RDATA. The target is not compressed as per RFC 6672.
+func (d DNAME) Pack(b []byte, _ Compressor) ([]byte, error) {
+	return compressor{}.Pack(b, d.DNAME)
 }
 
 // Unpack decodes c from RDATA in b.
 func (d *DNAME) Unpack(b []byte, dec Decompressor) ([]byte, error) {
-	d.l.Lock()
-	defer d.l.Unlock()
-
 	var err error
 	d.DNAME, b, err = dec.Unpack(b)
 	return b, err
 }
 
 func (d *DNAME) Get() interface{} {
-	d.l.Lock()
-	defer d.l.Unlock()
-
 	return d
 }
 
-func (d *DNAME) String() string {
-	d.l.Lock()
-	defer d.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (d *DNAME) Key() string {
+	return strings.ToLower(d.DNAME)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (d *DNAME) Equal(r Record) bool { return recordEqual(d, r) }
 
+func (d *DNAME) String() string {
 	bOut, _ := json.Marshal(d)
 	return string(bOut)
 }
 
 func (d *DNAME) FromJSon(v string) error {
-	d.l.Lock()
-	defer d.l.Unlock()
-
 	return json.Unmarshal([]byte(v), d)
 }
 
 // OPT is a DNS OPT record.
 type OPT struct {
-	l       sync.Mutex
 	Options []edns.Option
 }
 
@@ -1232,9 +1293,6 @@ func (o OPT) Type() Type { return TypeOPT }
 
 // Length returns the encoded RDATA size.
 func (o OPT) Length(_ Compressor) (int, error) {
-	o.l.Lock()
-	defer o.l.Unlock()
-
 	var n int
 	for _, opt := range o.Options {
 		n += opt.Length()
@@ -1244,9 +1302,6 @@ func (o OPT) Length(_ Compressor) (int, error) {
 
 // Pack encodes o as RDATA.
 func (o OPT) Pack(b []byte, _ Compressor) ([]byte, error) {
-	o.l.Lock()
-	defer o.l.Unlock()
-
 	var err error
 	for _, opt := range o.Options {
 		if b, err = opt.Pack(b); err != nil {
@@ -1258,9 +1313,6 @@ func (o OPT) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes o from RDATA in b.
 func (o *OPT) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	o.l.Lock()
-	defer o.l.Unlock()
-
 	o.Options = nil
 
 	var err error
@@ -1275,57 +1327,65 @@ func (o *OPT) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 }
 
 func (o *OPT) Get() interface{} {
-	o.l.Lock()
-	defer o.l.Unlock()
-
 	return o
 }
 
-func (o *OPT) String() string {
-	o.l.Lock()
-	defer o.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (o *OPT) Key() string {
+	opts := make([]string, 0, len(o.Options))
+	for _, opt := range o.Options {
+		opts = append(opts, fmt.Sprintf("%d:%x", opt.Code, opt.Data))
+	}
+	return strings.Join(opts, " ")
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (o *OPT) Equal(r Record) bool { return recordEqual(o, r) }
 
+func (o *OPT) String() string {
 	bOut, _ := json.Marshal(o)
 	return string(bOut)
 }
 
 func (o *OPT) FromJSon(v string) error {
-	o.l.Lock()
-	defer o.l.Unlock()
-
 	return json.Unmarshal([]byte(v), o)
 }
 
 // type CAA is a DNS CAA record.
 type CAA struct {
-	l              sync.Mutex
+	// Flags is the flags byte of the record. IssuerCritical sets the
+	// CAAFlagIssuerCritical bit in addition to Flags.
+	Flags          uint8
 	IssuerCritical bool
 
 	Tag   string
 	Value string
 }
 
+// CAAFlagIssuerCritical is the issuer critical flag of a CAA record, bit 0 of
+// the flags byte, see RFC 8659, section 4.1.
+const CAAFlagIssuerCritical uint8 = 0x80
+
+// flags returns the flags byte of c.
+func (c CAA) flags() uint8 {
+	if c.IssuerCritical {
+		return c.Flags | CAAFlagIssuerCritical
+	}
+	return c.Flags
+}
+
 // Type returns the RR type identifier.
 func (CAA) Type() Type { return TypeCAA }
 
 // Length returns the encoded RDATA size.
 func (c CAA) Length(_ Compressor) (int, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	return 2 + len(c.Tag) + len(c.Value), nil
 }
 
 // Pack encodes c as RDATA.
 func (c CAA) Pack(b []byte, _ Compressor) ([]byte, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	buf := make([]byte, 2, 2+len(c.Tag)+len(c.Value))
-
-	if c.IssuerCritical {
-		buf[0] = 1
-	}
+	buf[0] = c.flags()
 
 	tagLength := len(c.Tag)
 	if tagLength == 0 {
@@ -1344,16 +1404,12 @@ func (c CAA) Pack(b []byte, _ Compressor) ([]byte, error) {
 
 // Unpack decodes c from RDATA in b.
 func (c *CAA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	if len(b) < 2 {
 		return nil, errResourceLen
 	}
 
-	if b[0]&0x01 > 0 {
-		c.IssuerCritical = true
-	}
+	c.Flags = b[0]
+	c.IssuerCritical = b[0]&CAAFlagIssuerCritical != 0
 
 	tagLength := int(b[1])
 	if tagLength == 0 {
@@ -1370,23 +1426,94 @@ func (c *CAA) Unpack(b []byte, _ Decompressor) ([]byte, error) {
 }
 
 func (c *CAA) Get() interface{} {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	return c
 }
 
-func (c *CAA) String() string {
-	c.l.Lock()
-	defer c.l.Unlock()
+// Key returns the RDATA as a comparable string.
+func (c *CAA) Key() string {
+	return fmt.Sprintf("%d %s %q", c.flags(), c.Tag, c.Value)
+}
 
+// Equal reports whether r has the same type and RDATA.
+func (c *CAA) Equal(r Record) bool { return recordEqual(c, r) }
+
+func (c *CAA) String() string {
 	bOut, _ := json.Marshal(c)
 	return string(bOut)
 }
 
 func (c *CAA) FromJSon(v string) error {
-	c.l.Lock()
-	defer c.l.Unlock()
-
 	return json.Unmarshal([]byte(v), c)
 }
+
+// ZONEMD is a DNS ZONEMD record, the message digest of a zone.
+type ZONEMD struct {
+	Serial int
+	Scheme uint8
+	Hash   uint8
+	Digest []byte
+}
+
+// ZONEMD schemes and hash algorithms, see RFC 8976, section 5.
+const (
+	ZONEMDSchemeSimple uint8 = 1
+
+	ZONEMDHashSHA384 uint8 = 1
+	ZONEMDHashSHA512 uint8 = 2
+)
+
+// Type returns the RR type identifier.
+func (ZONEMD) Type() Type { return TypeZONEMD }
+
+// Length returns the encoded RDATA size.
+func (z ZONEMD) Length(_ Compressor) (int, error) {
+	return 6 + len(z.Digest), nil
+}
+
+// Pack encodes z as RDATA.
+func (z ZONEMD) Pack(b []byte, _ Compressor) ([]byte, error) {
+	serial := uint32(z.Serial)
+	if int(serial) != z.Serial {
+		return nil, errFieldOverflow
+	}
+
+	buf := make([]byte, 6, 6+len(z.Digest))
+	nbo.PutUint32(buf[:4], serial)
+	buf[4], buf[5] = z.Scheme, z.Hash
+
+	return append(b, append(buf, z.Digest...)...), nil
+}
+
+// Unpack decodes z from RDATA in b.
+func (z *ZONEMD) Unpack(b []byte, _ Decompressor) ([]byte, error) {
+	if len(b) < 6 {
+		return nil, errResourceLen
+	}
+
+	z.Serial = int(nbo.Uint32(b[:4]))
+	z.Scheme, z.Hash = b[4], b[5]
+	z.Digest = append([]byte(nil), b[6:]...)
+
+	return nil, nil
+}
+
+func (z *ZONEMD) Get() interface{} {
+	return z
+}
+
+// Key returns the RDATA as a comparable string.
+func (z *ZONEMD) Key() string {
+	return fmt.Sprintf("%d %d %d %x", z.Serial, z.Scheme, z.Hash, z.Digest)
+}
+
+// Equal reports whether r has the same type and RDATA.
+func (z *ZONEMD) Equal(r Record) bool { return recordEqual(z, r) }
+
+func (z *ZONEMD) String() string {
+	bOut, _ := json.Marshal(z)
+	return string(bOut)
+}
+
+func (z *ZONEMD) FromJSon(v string) error {
+	return json.Unmarshal([]byte(v), z)
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -165,6 +166,27 @@ func TestMessagePackUnpack(t *testing.T) {
 				0x00, 0x00, 0x1C, 0x00, 0x01, // .	IN	AAAA
 			},
 		},
+		{
+			name: "AD, CD and Z bits",
+
+			msg: Message{
+				ID:               0x1002,
+				Response:         true,
+				RecursionDesired: true,
+				AuthenticData:    true,
+				CheckingDisabled: true,
+				Z:                true,
+			},
+
+			raw: []byte{
+				0x10, 0x02, // ID=0x1002
+				0x81, 0x70, // QR=1,RD=1,Z=1,AD=1,CD=1
+				0x00, 0x00, // QDCOUNT=0
+				0x00, 0x00, // ANCOUNT=0
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+			},
+		},
 		{
 			name: "txt.example.com.	IN	TXT",
 
@@ -718,6 +740,103 @@ func TestMessagePackUnpack(t *testing.T) {
 				'p', 'k', 'i', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm',
 			},
 		},
+		{
+			name: ". 60 IN ZONEMD",
+
+			msg: Message{
+				ID:       0x10a,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  ".",
+						Type:  TypeZONEMD,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:  ".",
+						Class: ClassIN,
+						TTL:   60 * time.Second,
+						Record: &ZONEMD{
+							Serial: 2018031900,
+							Scheme: ZONEMDSchemeSimple,
+							Hash:   ZONEMDHashSHA384,
+							Digest: []byte{0xde, 0xad, 0xbe, 0xef},
+						},
+					},
+				},
+			},
+
+			raw: []byte{
+				0x01, 0x0a, // ID=0x010a
+				0x80, 0x00, // RD=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				0x00, 0x00, 0x3f, 0x00, 0x01, // .      IN      ZONEMD
+
+				// .    60      IN      ZONEMD 2018031900 1 1 deadbeef
+				0x00,
+				0x00, 0x3f, 0x00, 0x01, // TYPE=ZONEMD,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x0a,
+
+				0x78, 0x48, 0xb9, 0x1c, // SERIAL=2018031900
+				0x01, 0x01, // SCHEME=SIMPLE,HASH=SHA384
+				0xde, 0xad, 0xbe, 0xef,
+			},
+		},
+		{
+			name: ". 60 IN WKS",
+
+			msg: Message{
+				ID:       0x10b,
+				Response: true,
+				Questions: []Question{
+					{
+						Name:  ".",
+						Type:  TypeWKS,
+						Class: ClassIN,
+					},
+				},
+				Answers: []Resource{
+					{
+						Name:  ".",
+						Class: ClassIN,
+						TTL:   60 * time.Second,
+						Record: &WKS{
+							Address:  net.IPv4(10, 0, 0, 1).To4(),
+							Protocol: 6,
+							BitMap:   []byte{0x00, 0x00, 0x00, 0x40}, // smtp
+						},
+					},
+				},
+			},
+
+			raw: []byte{
+				0x01, 0x0b, // ID=0x010b
+				0x80, 0x00, // RD=1
+				0x00, 0x01, // QDCOUNT=1
+				0x00, 0x01, // ANCOUNT=1
+				0x00, 0x00, // NSCOUNT=0
+				0x00, 0x00, // ARCOUNT=0
+
+				0x00, 0x00, 0x0b, 0x00, 0x01, // .      IN      WKS
+
+				// .    60      IN      WKS 10.0.0.1 TCP smtp
+				0x00,
+				0x00, 0x0b, 0x00, 0x01, // TYPE=WKS,CLASS=IN
+				0x00, 0x00, 0x00, 0x3C, // TTL=60
+				0x00, 0x09,
+
+				0x0a, 0x00, 0x00, 0x01, // 10.0.0.1
+				0x06, // PROTOCOL=TCP
+				0x00, 0x00, 0x00, 0x40,
+			},
+		},
 		{
 			name: "compressed response",
 
@@ -1282,7 +1401,7 @@ func TestInvalidCAA(t *testing.T) {
 				0x00, 0x00, 0x00, 0x3C, // TTL=60
 				0x00, 0x10,
 
-				0x01, 0x00,
+				0x80, 0x00,
 				'c', 'a', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm',
 			},
 
@@ -1339,3 +1458,72 @@ func TestInvalidCAA(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordEqual(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b  Record
+		equal bool
+	}{
+		{&A{A: net.IPv4(10, 0, 0, 1).To4()}, &A{A: net.IPv4(10, 0, 0, 1)}, true},
+		{&A{A: net.IPv4(10, 0, 0, 1).To4()}, &A{A: net.IPv4(10, 0, 0, 2).To4()}, false},
+		{&A{A: net.IPv4(10, 0, 0, 1).To4()}, &AAAA{AAAA: net.ParseIP("::ffff:10.0.0.1")}, false},
+		{&MX{Pref: 10, MX: "mx.local."}, &MX{Pref: 10, MX: "mx.local."}, true},
+		{&MX{Pref: 10, MX: "mx.local."}, &MX{Pref: 20, MX: "mx.local."}, false},
+		{&SOA{NS: "ns.local.", Serial: 1}, &SOA{NS: "ns.local.", Serial: 1}, true},
+		{&SOA{NS: "ns.local.", Serial: 1}, &SOA{NS: "ns.local.", Serial: 2}, false},
+		{&TXT{TXT: []string{"a b"}}, &TXT{TXT: []string{"a", "b"}}, false},
+		{&SRV{Priority: 1, Port: 53, Target: "ns.local."}, &SRV{Priority: 1, Port: 53, Target: "ns.local."}, true},
+		{&CAA{Tag: "issue", Value: "ca.local"}, &CAA{IssuerCritical: true, Tag: "issue", Value: "ca.local"}, false},
+		{&CNAME{CNAME: "a.local."}, &DNAME{DNAME: "a.local."}, false},
+		{&CNAME{CNAME: "a.local."}, nil, false},
+		{&CNAME{CNAME: "A.Local."}, &CNAME{CNAME: "a.local."}, true},
+		{&NS{NS: "NS.local."}, &NS{NS: "ns.local."}, true},
+		{&MX{Pref: 10, MX: "MX.local."}, &MX{Pref: 10, MX: "mx.local."}, true},
+		{&PTR{PTR: "Host.local."}, &PTR{PTR: "host.local."}, true},
+		{&SRV{Priority: 1, Port: 53, Target: "NS.local."}, &SRV{Priority: 1, Port: 53, Target: "ns.local."}, true},
+		{&SOA{NS: "NS.local.", MBox: "Admin.local."}, &SOA{NS: "ns.local.", MBox: "admin.local."}, true},
+		{&DNAME{DNAME: "A.local."}, &DNAME{DNAME: "a.local."}, true},
+		{&TXT{TXT: []string{"A"}}, &TXT{TXT: []string{"a"}}, false},
+	}
+
+	for _, test := range tests {
+		if want, got := test.equal, test.a.Equal(test.b); want != got {
+			t.Errorf("%s.Equal(%v): want %t, got %t", test.a.Key(), test.b, want, got)
+		}
+	}
+}
+
+func TestRecordSharedPack(t *testing.T) {
+	t.Parallel()
+
+	rrs := []Record{
+		&A{net.IPv4(10, 0, 0, 1).To4()},
+		&MX{10, "mx.local."},
+		&TXT{[]string{"a", "b"}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, rr := range rrs {
+				if _, err := rr.Pack(nil, compressor{}); err != nil {
+					t.Error(err)
+				}
+				if rr.Key() == "" || rr.String() == "" {
+					t.Errorf("empty text for %T", rr)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	a := *rrs[0].(*A)
+	if !a.Equal(rrs[0]) {
+		t.Errorf("want copy of %s to be equal", rrs[0].Key())
+	}
+}
@@ -3,6 +3,8 @@ package dns
 import (
 	"context"
 	"time"
+
+	"github.com/helmutkemper/dns/edns"
 )
 
 // MessageWriter is used by a DNS handler to serve a DNS query.
@@ -13,6 +15,10 @@ type MessageWriter interface {
 	Recursion(bool)
 	// Status sets the Response code (RCODE) bits of the header.
 	Status(RCode)
+	// AuthenticData sets the Authentic Data (AD) bit of the header.
+	AuthenticData(bool)
+	// CheckingDisabled sets the Checking Disabled (CD) bit of the header.
+	CheckingDisabled(bool)
 
 	// Answer adds a record to the answers section.
 	Answer(string, time.Duration, Record)
@@ -25,6 +31,10 @@ type MessageWriter interface {
 	// message or error.
 	Recur(context.Context) (*Message, error)
 
+	// Forward sends the request query to the upstream RoundTripper instead
+	// of the default forwarder, and returns the response message or error.
+	Forward(context.Context, RoundTripper) (*Message, error)
+
 	// Reply sends the response message.
 	//
 	// For large messages sent over a UDP connection, an ErrTruncatedMessage
@@ -32,13 +42,176 @@ type MessageWriter interface {
 	Reply(context.Context) error
 }
 
+// ResourceWriter is implemented by the MessageWriters of a Server, a Client
+// and the handlers of a ResolveMux, to add records of a class other than IN,
+// such as the CH records answering version.bind, or the mDNS records with the
+// cache-flush bit set in their class, see RFC 6762 Section 10.2.
+type ResourceWriter interface {
+	// AnswerResource adds a record to the answers section.
+	AnswerResource(Resource)
+	// AuthorityResource adds a record to the authority section.
+	AuthorityResource(Resource)
+	// AdditionalResource adds a record to the additional section.
+	AdditionalResource(Resource)
+}
+
+// packedWriter is implemented by MessageWriters that can reply with a
+// response packed in advance, such as one cached by a Zone.
+type packedWriter interface {
+	MessageWriter
+
+	// Pack returns the response written so far in wire format.
+	Pack() ([]byte, error)
+
+	// SetPacked makes Reply send b, a response in wire format with the ID
+	// of the query, in place of the response written so far.
+	SetPacked(b []byte) error
+}
+
+// memoryWriter is the MessageWriter of a query served in memory, its
+// response read back rather than sent, which has no upstream to recur to.
+type memoryWriter struct {
+	*messageWriter
+}
+
+func (memoryWriter) Recur(context.Context) (*Message, error) { return nil, ErrUnsupportedOp }
+
+func (memoryWriter) Forward(context.Context, RoundTripper) (*Message, error) {
+	return nil, ErrUnsupportedOp
+}
+
+func (memoryWriter) Reply(context.Context) error { return nil }
+
+// response returns the response written, or the one packed in advance, such
+// as from the pack cache of a zone.
+func (w memoryWriter) response() (*Message, error) {
+	if w.packed == nil {
+		w.minimize()
+		return w.msg, nil
+	}
+
+	msg := new(Message)
+	if _, err := msg.Unpack(w.packed); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
 type messageWriter struct {
 	msg *Message
+
+	packed []byte
+
+	compression compressionMode
+	minimal     bool                 // omits the records not required, see minimize
+	padding     int                  // the block length of the padded response, see pad
+	counters    *compressionCounters // if not nil, counts the packed responses
+	stats       *queryCounters       // if not nil, counts the RCODE of the packed responses
+}
+
+// Pack and SetPacked are unsupported by a minimal or padding writer, so that
+// the responses packed in advance for other writers are not sent.
+func (w *messageWriter) Pack() ([]byte, error) {
+	if w.minimal || w.padding > 0 {
+		return nil, ErrUnsupportedOp
+	}
+	return w.msg.Pack(nil, w.compressed())
+}
+
+func (w *messageWriter) SetPacked(b []byte) error {
+	if w.minimal || w.padding > 0 {
+		return ErrUnsupportedOp
+	}
+	w.packed = b
+	return nil
 }
 
-func (w *messageWriter) Authoritative(aa bool) { w.msg.Authoritative = aa }
-func (w *messageWriter) Recursion(ra bool)     { w.msg.RecursionAvailable = ra }
-func (w *messageWriter) Status(rc RCode)       { w.msg.RCode = rc }
+// pack appends the response to b.
+func (w *messageWriter) pack(b []byte) ([]byte, error) {
+	if w.packed != nil {
+		if w.stats != nil && len(w.packed) > 3 {
+			w.stats.response(RCode(w.packed[3] & 0xF))
+		}
+		return append(b, w.packed...), nil
+	}
+
+	w.minimize()
+
+	n, compress := len(b), w.compressed()
+
+	msg := w.msg
+	if w.padding > 0 {
+		var err error
+		if msg, err = padded(msg, w.padding, compress); err != nil {
+			return nil, err
+		}
+	}
+
+	b, saved, err := msg.pack(b, compress)
+	if err == nil && w.counters != nil {
+		w.counters.add(len(b)-n, saved, compress)
+	}
+	if err == nil && w.stats != nil {
+		w.stats.response(w.msg.RCode)
+	}
+	return b, err
+}
+
+// Compress enables or disables the name compression of the response.
+func (w *messageWriter) Compress(compress bool) {
+	w.compression = compressionOff
+	if compress {
+		w.compression = compressionOn
+	}
+}
+
+// minimize omits the records of the response not required, if the writer is
+// minimal.
+func (w *messageWriter) minimize() {
+	if !w.minimal {
+		return
+	}
+
+	var omitted int
+	if w.msg, omitted = minimize(w.msg); omitted > 0 && w.stats != nil {
+		w.stats.minimize(omitted)
+	}
+}
+
+func (w *messageWriter) compressed() bool { return w.compression != compressionOff }
+
+func (w *messageWriter) Authoritative(aa bool)    { w.msg.Authoritative = aa }
+func (w *messageWriter) Recursion(ra bool)        { w.msg.RecursionAvailable = ra }
+func (w *messageWriter) Status(rc RCode)          { w.msg.RCode = rc }
+func (w *messageWriter) AuthenticData(ad bool)    { w.msg.AuthenticData = ad }
+func (w *messageWriter) CheckingDisabled(cd bool) { w.msg.CheckingDisabled = cd }
+
+// truncated sets the Truncated (TC) bit of the header, such as for a query
+// answered over TCP only.
+func (w *messageWriter) truncated(tc bool) { w.msg.Truncated = tc }
+
+// slip replaces the response with an empty truncated one, its question and
+// OPT record kept, so that the client retries over TCP.
+func (w *messageWriter) slip() {
+	msg := *w.msg
+	msg.Answers, msg.Authorities, msg.Additionals = nil, nil, nil
+	for _, res := range w.msg.Additionals {
+		if _, ok := res.Record.(*OPT); ok {
+			msg.Additionals = append(msg.Additionals, res)
+		}
+	}
+	msg.Truncated = true
+
+	w.msg, w.packed = &msg, nil
+}
+
+// rcode returns the RCODE of the response, or of the one packed in advance.
+func (w *messageWriter) rcode() RCode {
+	if len(w.packed) > 3 {
+		return RCode(w.packed[3] & 0xF)
+	}
+	return w.msg.RCode
+}
 
 func (w *messageWriter) Answer(fqdn string, ttl time.Duration, rec Record) {
 	w.msg.Answers = append(w.msg.Answers, w.rr(fqdn, ttl, rec))
@@ -52,6 +225,56 @@ func (w *messageWriter) Additional(fqdn string, ttl time.Duration, rec Record) {
 	w.msg.Additionals = append(w.msg.Additionals, w.rr(fqdn, ttl, rec))
 }
 
+func (w *messageWriter) AnswerResource(res Resource) {
+	w.msg.Answers = append(w.msg.Answers, res)
+}
+
+func (w *messageWriter) AuthorityResource(res Resource) {
+	w.msg.Authorities = append(w.msg.Authorities, res)
+}
+
+func (w *messageWriter) AdditionalResource(res Resource) {
+	w.msg.Additionals = append(w.msg.Additionals, res)
+}
+
+// pad makes the response padded to a multiple of block bytes, if its query
+// has an EDNS Padding option, see padded.
+func (w *messageWriter) pad(block int) { w.padding = block }
+
+// setOption sets opt in the OPT record echoed from the query, if any, in
+// place of the option of the same code.
+func (w *messageWriter) setOption(opt edns.Option) {
+	for i, res := range w.msg.Additionals {
+		if o, ok := res.Record.(*OPT); ok {
+			options := make([]edns.Option, 0, len(o.Options)+1)
+			for _, v := range o.Options {
+				if v.Code != opt.Code {
+					options = append(options, v)
+				}
+			}
+
+			ress := append([]Resource(nil), w.msg.Additionals...)
+			ress[i].Record = &OPT{Options: append(options, opt)}
+			w.msg.Additionals = ress
+			return
+		}
+	}
+}
+
+// extendedError adds opt to the OPT record echoed from the query, if any.
+func (w *messageWriter) extendedError(opt edns.Option) {
+	for i, res := range w.msg.Additionals {
+		if o, ok := res.Record.(*OPT); ok {
+			ress := append([]Resource(nil), w.msg.Additionals...)
+			ress[i].Record = &OPT{
+				Options: append(o.Options[:len(o.Options):len(o.Options)], opt),
+			}
+			w.msg.Additionals = ress
+			return
+		}
+	}
+}
+
 func (w *messageWriter) rr(fqdn string, ttl time.Duration, rec Record) Resource {
 	return Resource{
 		Name:   fqdn,
@@ -60,3 +283,33 @@ func (w *messageWriter) rr(fqdn string, ttl time.Duration, rec Record) Resource
 		Record: rec,
 	}
 }
+
+// writeAnswer adds res to the answers of w, with its class if w is a
+// ResourceWriter.
+func writeAnswer(w MessageWriter, res Resource) {
+	if rw, ok := w.(ResourceWriter); ok {
+		rw.AnswerResource(res)
+		return
+	}
+	w.Answer(res.Name, res.TTL, res.Record)
+}
+
+// writeAuthority adds res to the authorities of w, with its class if w is a
+// ResourceWriter.
+func writeAuthority(w MessageWriter, res Resource) {
+	if rw, ok := w.(ResourceWriter); ok {
+		rw.AuthorityResource(res)
+		return
+	}
+	w.Authority(res.Name, res.TTL, res.Record)
+}
+
+// writeAdditional adds res to the additionals of w, with its class if w is a
+// ResourceWriter.
+func writeAdditional(w MessageWriter, res Resource) {
+	if rw, ok := w.(ResourceWriter); ok {
+		rw.AdditionalResource(res)
+		return
+	}
+	w.Additional(res.Name, res.TTL, res.Record)
+}
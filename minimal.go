@@ -0,0 +1,73 @@
+package dns
+
+// The types of the signatures of a message, kept by minimize as the last
+// records of the additional section, see RFC 2931 and RFC 8945.
+const (
+	typeSIG  Type = 24
+	typeTSIG Type = 250
+)
+
+// minimize returns msg without the authority and additional records not
+// required by its answers, as with the minimal-responses option of BIND, and
+// the number of records omitted:
+//
+//   - the authority section of a positive answer is omitted;
+//   - the authority records of a negative answer, the SOA record, and of a
+//     referral, the NS records, are kept;
+//   - the additional records are omitted but for the OPT record, the
+//     signatures of the message, and the addresses of the name servers of a
+//     referral, its glue.
+func minimize(msg *Message) (*Message, int) {
+	referral := len(msg.Answers) == 0 && isReferral(msg.Authorities)
+
+	var omitted int
+	m := *msg
+	if len(msg.Answers) > 0 && len(msg.Authorities) > 0 {
+		m.Authorities, omitted = nil, len(msg.Authorities)
+	}
+
+	var additionals []Resource
+	for _, res := range msg.Additionals {
+		switch t := res.Record.Type(); {
+		case t == TypeOPT || t == typeSIG || t == typeTSIG:
+		case referral && (t == TypeA || t == TypeAAAA) && isNameServer(msg.Authorities, res.Name):
+		default:
+			omitted++
+			continue
+		}
+		additionals = append(additionals, res)
+	}
+	if len(additionals) < len(msg.Additionals) {
+		m.Additionals = additionals
+	}
+
+	if omitted == 0 {
+		return msg, 0
+	}
+	return &m, omitted
+}
+
+// isReferral reports whether the authority records rs are those of a
+// referral: NS records without a SOA record.
+func isReferral(rs []Resource) bool {
+	var ns bool
+	for _, res := range rs {
+		switch res.Record.Type() {
+		case TypeSOA:
+			return false
+		case TypeNS:
+			ns = true
+		}
+	}
+	return ns
+}
+
+// isNameServer reports whether name is the target of an NS record of rs.
+func isNameServer(rs []Resource, name string) bool {
+	for _, res := range rs {
+		if ns, ok := res.Record.(*NS); ok && NormalizeKey(ns.NS) == NormalizeKey(name) {
+			return true
+		}
+	}
+	return false
+}
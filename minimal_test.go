@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMinimize(t *testing.T) {
+	t.Parallel()
+
+	var (
+		a    = Resource{Name: "www.example.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}
+		ns   = Resource{Name: "sub.example.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns.sub.example."}}
+		glue = Resource{Name: "ns.sub.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 53).To4()}}
+		soa  = Resource{Name: "example.", Class: ClassIN, TTL: time.Hour, Record: &SOA{NS: "ns.example.", MBox: "hostmaster.example."}}
+		mx   = Resource{Name: "mail.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 25).To4()}}
+		opt  = Resource{Name: ".", Class: 1232, Record: &OPT{}}
+	)
+
+	tests := []struct {
+		name string
+
+		msg, want *Message
+		omitted   int
+	}{
+		{
+			name: "answer",
+			msg:  &Message{Answers: []Resource{a}, Authorities: []Resource{ns}, Additionals: []Resource{glue, opt}},
+			want: &Message{Answers: []Resource{a}, Additionals: []Resource{opt}},
+
+			omitted: 2,
+		},
+		{
+			name: "negative",
+			msg:  &Message{RCode: NXDomain, Authorities: []Resource{soa}, Additionals: []Resource{mx}},
+			want: &Message{RCode: NXDomain, Authorities: []Resource{soa}},
+
+			omitted: 1,
+		},
+		{
+			name: "referral",
+			msg:  &Message{Authorities: []Resource{ns}, Additionals: []Resource{glue, mx, opt}},
+			want: &Message{Authorities: []Resource{ns}, Additionals: []Resource{glue, opt}},
+
+			omitted: 1,
+		},
+		{
+			name: "minimal",
+			msg:  &Message{Answers: []Resource{a}, Additionals: []Resource{opt}},
+			want: &Message{Answers: []Resource{a}, Additionals: []Resource{opt}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, omitted := minimize(test.msg)
+			if want, got := test.want, msg; !reflect.DeepEqual(want, got) {
+				t.Errorf("want message %+v, got %+v", want, got)
+			}
+			if want, got := test.omitted, omitted; want != got {
+				t.Errorf("want %d records omitted, got %d", want, got)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"math/big"
 	"net"
+	"sort"
 	"sync/atomic"
 )
 
@@ -68,6 +69,51 @@ func (s NameServers) RoundRobin() ProxyFunc {
 	}
 }
 
+// Weighted picks a random Addr of s in proportion to its weight, the value
+// at the same index in weights. An Addr with a weight of zero is never picked.
+// Weighted panics if weights and s differ in length or a weight is negative.
+func (s NameServers) Weighted(weights []int) ProxyFunc {
+	if len(weights) != len(s) {
+		panic("dns: weights and nameservers differ in length")
+	}
+
+	addrsByNet := make(map[string][]net.Addr, len(s))
+	sumsByNet := make(map[string][]int64, len(s))
+	for i, addr := range s {
+		if weights[i] < 0 {
+			panic("dns: negative nameserver weight")
+		}
+		if weights[i] == 0 {
+			continue
+		}
+
+		network, sums := addr.Network(), sumsByNet[addr.Network()]
+
+		sum := int64(weights[i])
+		if len(sums) > 0 {
+			sum += sums[len(sums)-1]
+		}
+		addrsByNet[network] = append(addrsByNet[network], addr)
+		sumsByNet[network] = append(sums, sum)
+	}
+
+	return func(_ context.Context, addr net.Addr) (net.Addr, error) {
+		network := addr.Network()
+		sums, ok := sumsByNet[network]
+		if !ok {
+			return nil, errors.New("no nameservers for network: " + network)
+		}
+
+		n, err := cryptorand.Int(cryptorand.Reader, big.NewInt(sums[len(sums)-1]))
+		if err != nil {
+			return nil, err
+		}
+
+		idx := sort.Search(len(sums), func(i int) bool { return sums[i] > n.Int64() })
+		return addrsByNet[network][idx], nil
+	}
+}
+
 func (s NameServers) netAddrsMap() map[string][]net.Addr {
 	addrsByNet := make(map[string][]net.Addr, len(s))
 	for _, addr := range s {
@@ -90,3 +90,39 @@ func TestNamserverRoundRobin(t *testing.T) {
 		})
 	}
 }
+
+func TestNameServersWeighted(t *testing.T) {
+	t.Parallel()
+
+	proxyfn := testNameServers.Weighted([]int{9, 1, 1, 0})
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		addr, err := proxyfn(context.Background(), new(net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[addr.String()]++
+	}
+
+	if got := counts["8.8.8.8:53"]; got < 800 || got > 980 {
+		t.Errorf("want about 900 picks of the primary, got %d", got)
+	}
+	if want, got := 1000, counts["8.8.8.8:53"]+counts["8.8.4.4:53"]; want != got {
+		t.Errorf("want %d picks, got %d", want, got)
+	}
+
+	for i := 0; i < 10; i++ {
+		addr, err := proxyfn(context.Background(), new(net.TCPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "8.8.8.8:53", addr.String(); want != got {
+			t.Fatalf("want zero weight address skipped, got %s", got)
+		}
+	}
+
+	if _, err := proxyfn(context.Background(), new(net.IPAddr)); err == nil {
+		t.Error("want error for unknown network")
+	}
+}
@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxNetBIOSName is the length of the longest NetBIOS name, the 16th byte of
+// the encoded name being its suffix, see RFC 1001, section 14.
+const maxNetBIOSName = 15
+
+// singleLabel matches the single-label names, such as "printer.".
+var singleLabel = regexp.MustCompile(`^([^.]+)\.?$`)
+
+// NetBIOSBridge is a Handler answering the queries for single-label names,
+// such as "printer.", sent by legacy clients resolving the bare host names of
+// a Windows network. The suffixed names, such as "printer.corp.example.", are
+// resolved with the Forwarder for each of the Suffixes in turn, and the first
+// found answers the query with its records renamed back to the bare name. The
+// names none of them answers for are looked up with WINS, if set.
+//
+// The queries for other names are forwarded upstream.
+type NetBIOSBridge struct {
+	// Suffixes are the domains appended to the bare names, tried in order,
+	// such as "corp.example.".
+	Suffixes []string
+
+	// Forwarder resolves the suffixed names, such as the Forwarder of the
+	// Server. The Suffixes are not tried if it is nil.
+	Forwarder RoundTripper
+
+	// WINS, if not nil, returns the addresses of a NetBIOS name, such as
+	// those registered with a WINS server or seen in the broadcasts of the
+	// network. It is called with the bare name, without its trailing dot,
+	// for the A, AAAA and ALL questions of the names of up to 15 bytes.
+	// NetBIOS names are case-insensitive. A name without addresses does
+	// not exist.
+	WINS func(ctx context.Context, name string) ([]net.IP, error)
+
+	// TTL is the TTL of the answers for the addresses of WINS. If zero, one
+	// minute is used.
+	TTL time.Duration
+}
+
+// ServeDNS answers the questions for single-label names, and forwards the
+// queries for other names upstream.
+func (b *NetBIOSBridge) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	if len(r.Questions) == 0 || !b.bare(r.Questions) {
+		msg, err := w.Recur(ctx)
+		if err != nil || msg == nil {
+			w.Status(ServFail)
+			return
+		}
+		writeMessage(w, msg)
+		return
+	}
+
+	var (
+		nodata *Message // response of a suffixed name without the records
+		failed bool
+	)
+	if b.Forwarder != nil {
+		for _, suffix := range b.Suffixes {
+			msg, err := w.Forward(ctx, b.suffixed(suffix))
+			switch {
+			case err != nil || msg == nil:
+				if ctx.Err() != nil {
+					w.Status(ServFail)
+					return
+				}
+				failed = true
+			case msg.RCode == NoError && len(msg.Answers) > 0:
+				writeMessage(w, msg)
+				return
+			case msg.RCode == NoError && nodata == nil:
+				nodata = msg
+			}
+		}
+	}
+
+	if b.WINS != nil {
+		found, err := b.wins(ctx, w, r.Questions)
+		switch {
+		case err != nil:
+			failed = true
+		case found:
+			return
+		}
+	}
+
+	switch {
+	case nodata != nil:
+		writeMessage(w, nodata)
+	case failed:
+		w.Status(ServFail)
+	default:
+		w.Status(NXDomain)
+	}
+}
+
+// bare reports whether the names of the questions qs are single-label names.
+func (b *NetBIOSBridge) bare(qs []Question) bool {
+	for _, q := range qs {
+		if q.Name == "." || !singleLabel.MatchString(q.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// suffixed returns the Forwarder resolving the bare names with the suffix.
+func (b *NetBIOSBridge) suffixed(suffix string) RoundTripper {
+	rw := &Rewriter{Rules: []RewriteRule{{
+		Regexp:  singleLabel,
+		Replace: "${1}." + strings.Trim(suffix, ".") + ".",
+	}}}
+	return rw.Intercept(b.Forwarder)
+}
+
+// wins answers the address questions qs with the addresses of WINS. It
+// returns whether a name has addresses.
+func (b *NetBIOSBridge) wins(ctx context.Context, w MessageWriter, qs []Question) (bool, error) {
+	ttl := b.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+
+	var found bool
+	for _, q := range qs {
+		name := strings.TrimSuffix(q.Name, ".")
+		if len(name) > maxNetBIOSName {
+			continue
+		}
+		if q.Type != TypeA && q.Type != TypeAAAA && q.Type != TypeALL {
+			continue
+		}
+
+		ips, err := b.WINS(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		if len(ips) > 0 {
+			found = true
+		}
+		for _, ip := range ips {
+			ip4 := ip.To4()
+			switch {
+			case (q.Type == TypeA || q.Type == TypeALL) && ip4 != nil:
+				w.Answer(q.Name, ttl, &A{A: ip4})
+			case (q.Type == TypeAAAA || q.Type == TypeALL) && ip4 == nil:
+				w.Answer(q.Name, ttl, &AAAA{AAAA: ip.To16()})
+			}
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetBIOSBridge(t *testing.T) {
+	t.Parallel()
+
+	records := map[string]Record{
+		"printer.branch.example.": &A{A: net.IPv4(192, 0, 2, 10).To4()},
+		"printer.corp.example.":   &A{A: net.IPv4(192, 0, 2, 20).To4()},
+		"www.example.":            &A{A: net.IPv4(192, 0, 2, 80).To4()},
+	}
+	upstream := &Client{
+		Transport: nopDialer{},
+		Resolver: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			for _, q := range r.Questions {
+				rr, ok := records[strings.ToLower(q.Name)]
+				switch {
+				case !ok:
+					w.Status(NXDomain)
+				case rr.Type() == q.Type:
+					w.Answer(q.Name, time.Minute, rr)
+				}
+			}
+		}),
+	}
+
+	bridge := &NetBIOSBridge{
+		Suffixes:  []string{"corp.example.", "branch.example"},
+		Forwarder: upstream,
+		WINS: func(ctx context.Context, name string) ([]net.IP, error) {
+			if strings.EqualFold(name, "fileserver") {
+				return []net.IP{net.ParseIP("192.0.2.30"), net.ParseIP("2001:db8::30")}, nil
+			}
+			return nil, nil
+		},
+	}
+	srv := &Server{Handler: bridge, Forwarder: upstream}
+
+	tests := []struct {
+		name string
+
+		question Question
+
+		rcode   RCode
+		answers []Resource
+	}{
+		{
+			name:     "suffix",
+			question: Question{Name: "printer.", Type: TypeA, Class: ClassIN},
+			answers:  []Resource{{Name: "printer.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 20).To4()}}},
+		},
+		{
+			name:     "no data",
+			question: Question{Name: "printer.", Type: TypeAAAA, Class: ClassIN},
+		},
+		{
+			name:     "WINS",
+			question: Question{Name: "FILESERVER.", Type: TypeAAAA, Class: ClassIN},
+			answers:  []Resource{{Name: "FILESERVER.", Class: ClassIN, TTL: time.Minute, Record: &AAAA{AAAA: net.ParseIP("2001:db8::30")}}},
+		},
+		{
+			name:     "nxdomain",
+			question: Question{Name: "scanner.", Type: TypeA, Class: ClassIN},
+			rcode:    NXDomain,
+		},
+		{
+			name:     "forwarded",
+			question: Question{Name: "www.example.", Type: TypeA, Class: ClassIN},
+			answers:  []Resource{{Name: "www.example.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 80).To4()}}},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := srv.ServeMessage(context.Background(), &Query{
+				Message: &Message{Questions: []Question{test.question}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if want, got := test.answers, msg.Answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestNetBIOSBridgeWINSError(t *testing.T) {
+	t.Parallel()
+
+	bridge := &NetBIOSBridge{
+		WINS: func(context.Context, string) ([]net.IP, error) {
+			return nil, errors.New("WINS server unreachable")
+		},
+	}
+
+	msg, err := (&Server{Handler: bridge}).ServeMessage(context.Background(), &Query{
+		Message: &Message{Questions: []Question{{Name: "printer.", Type: TypeA, Class: ClassIN}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, msg.RCode; want != got {
+		t.Errorf("want rcode %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"strings"
+)
+
+// cut returns the key of the highest name occluding the key dn: a name below
+// the origin with NS records, a delegation, dn included, or with a DNAME
+// record, dn excluded, see RFC 2181, section 6, and RFC 6672, section 2.4.
+// It returns keys with the keys read, dn included.
+func (z *Zone) cut(dn string, keys []string) (string, Type, []string) {
+	if dn == "" {
+		return "", 0, append(keys, dn)
+	}
+
+	for end := len(dn); ; {
+		i := strings.LastIndexByte(dn[:end], '.')
+		k := dn[i+1:]
+		keys = append(keys, k)
+
+		if len(z.store().GetRecords(k, TypeNS)) > 0 {
+			return k, TypeNS, keys
+		}
+		if k != dn && len(z.store().GetRecords(k, TypeDNAME)) > 0 {
+			return k, TypeDNAME, keys
+		}
+
+		if i < 0 {
+			return "", 0, keys
+		}
+		end = i
+	}
+}
+
+// serveReferral writes the referral to the delegation at the key cut: its NS
+// records to the authority section, and the addresses of the names they point
+// to in the zone, its glue, to the additional section. It returns keys with
+// the keys read.
+func (z *Zone) serveReferral(w MessageWriter, r *Query, cut string, class Class, keys []string, volatile *bool) []string {
+	w.Authoritative(false)
+
+	var targets []string
+	for _, res := range z.answers(r, z.fqdn(cut), class, z.store().GetRecords(cut, TypeNS), volatile) {
+		writeAuthority(w, res)
+		targets = append(targets, res.Record.(*NS).NS)
+	}
+	return z.serveAddresses(w, r, class, targets, keys, volatile)
+}
+
+// serveAddresses writes the A and AAAA records of the names in the zone to the
+// additional section. It returns keys with the keys read.
+func (z *Zone) serveAddresses(w MessageWriter, r *Query, class Class, names []string, keys []string, volatile *bool) []string {
+	for _, name := range names {
+		dn, ok := z.key(name)
+		if !ok {
+			continue
+		}
+
+		keys = append(keys, dn)
+
+		rrs, ok := z.store().GetKey(dn)
+		if !ok {
+			continue
+		}
+		for _, t := range []Type{TypeA, TypeAAAA} {
+			for _, res := range z.answers(r, name, class, rrs[t], volatile) {
+				writeAdditional(w, res)
+			}
+		}
+	}
+	return keys
+}
+
+// Occluded returns the names of the zone that are not answered, being below a
+// delegation or a DNAME record, sorted. The addresses below a delegation are
+// left out, as they may be the glue of its name servers.
+func (z *Zone) Occluded() []string {
+	var names []string
+	for _, k := range z.store().Names() {
+		cut, t, _ := z.cut(k, nil)
+		if cut == "" || cut == k {
+			continue
+		}
+
+		if t == TypeNS {
+			rrs, _ := z.store().GetKey(k)
+			glue := true
+			for t, rr := range rrs {
+				if len(rr) > 0 && t != TypeA && t != TypeAAAA {
+					glue = false
+				}
+			}
+			if glue {
+				continue
+			}
+		}
+		names = append(names, z.fqdn(k))
+	}
+	return names
+}
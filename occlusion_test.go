@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZoneOcclusion(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "occ.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.occ.dev.", MBox: "hostmaster.occ.dev."},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"":       {TypeNS: {&NS{NS: "ns.occ.dev."}}},
+			"sub":    {TypeNS: {&NS{NS: "ns.sub.occ.dev."}}},
+			"ns.sub": {TypeA: {&A{A: net.IPv4(192, 0, 2, 53).To4()}}},
+			"a.sub":  {TypeTXT: {&TXT{TXT: []string{"occluded"}}}},
+			"old":    {TypeDNAME: {&DNAME{DNAME: "new.occ.dev."}}},
+			"a.old":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}),
+	}
+
+	tests := []struct {
+		name string
+
+		question      Question
+		authoritative bool
+		answers       []Type
+		authorities   []Type
+		additionals   []Type
+	}{
+		{
+			name:        "below a delegation",
+			question:    Question{Name: "a.sub.occ.dev.", Type: TypeTXT, Class: ClassIN},
+			authorities: []Type{TypeNS},
+			additionals: []Type{TypeA},
+		},
+		{
+			name:        "delegation",
+			question:    Question{Name: "sub.occ.dev.", Type: TypeNS, Class: ClassIN},
+			authorities: []Type{TypeNS},
+			additionals: []Type{TypeA},
+		},
+		{
+			name:          "below a DNAME",
+			question:      Question{Name: "a.old.occ.dev.", Type: TypeA, Class: ClassIN},
+			authoritative: true,
+			answers:       []Type{TypeDNAME, TypeCNAME},
+		},
+		{
+			name:          "DNAME",
+			question:      Question{Name: "old.occ.dev.", Type: TypeDNAME, Class: ClassIN},
+			authoritative: true,
+			answers:       []Type{TypeDNAME},
+		},
+	}
+
+	types := func(rs []Resource) []Type {
+		var v []Type
+		for _, res := range rs {
+			v = append(v, res.Record.Type())
+		}
+		return v
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{Message: &Message{Questions: []Question{test.question}}}
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			zone.ServeDNS(context.Background(), w, q)
+
+			if want, got := NoError, w.msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if want, got := test.authoritative, w.msg.Authoritative; want != got {
+				t.Errorf("want authoritative %t, got %t", want, got)
+			}
+			if want, got := test.answers, types(w.msg.Answers); !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %v, got %v", want, got)
+			}
+			if want, got := test.authorities, types(w.msg.Authorities); !reflect.DeepEqual(want, got) {
+				t.Errorf("want authorities %v, got %v", want, got)
+			}
+			if want, got := test.additionals, types(w.msg.Additionals); !reflect.DeepEqual(want, got) {
+				t.Errorf("want additionals %v, got %v", want, got)
+			}
+		})
+	}
+
+	if want, got := []string{"a.old.occ.dev.", "a.sub.occ.dev."}, zone.Occluded(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want occluded names %q, got %q", want, got)
+	}
+}
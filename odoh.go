@@ -0,0 +1,391 @@
+//go:build go1.26
+
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	odohContentType = "application/oblivious-dns-message"
+	odohConfigsPath = "/.well-known/odohconfigs"
+	odohVersion     = 0x0001
+
+	odohQuery    = 0x01
+	odohResponse = 0x02
+)
+
+var errODoHConfig = errors.New("no supported ODoH config")
+
+// odohConfig is an ObliviousDoHConfigContents with a supported suite.
+type odohConfig struct {
+	contents []byte
+	keyID    []byte
+
+	pk   hpke.PublicKey
+	kdf  hpke.KDF
+	aead hpke.AEAD
+
+	hash   func() hash.Hash
+	nk, nn int
+}
+
+// parseODoHConfigs returns the first supported config of an
+// ObliviousDoHConfigs structure.
+func parseODoHConfigs(b []byte) (*odohConfig, error) {
+	if len(b) < 2 || int(nbo.Uint16(b)) != len(b)-2 {
+		return nil, errODoHConfig
+	}
+
+	for b = b[2:]; len(b) >= 4; {
+		version, n := nbo.Uint16(b), int(nbo.Uint16(b[2:]))
+		if len(b) < 4+n {
+			break
+		}
+		contents := b[4 : 4+n]
+		b = b[4+n:]
+
+		if version != odohVersion {
+			continue
+		}
+		if cfg, err := newODoHConfig(contents); err == nil {
+			return cfg, nil
+		}
+	}
+	return nil, errODoHConfig
+}
+
+func newODoHConfig(contents []byte) (*odohConfig, error) {
+	if len(contents) < 8 || int(nbo.Uint16(contents[6:])) != len(contents)-8 {
+		return nil, errODoHConfig
+	}
+
+	cfg := &odohConfig{contents: append([]byte(nil), contents...)}
+
+	kem, err := hpke.NewKEM(nbo.Uint16(contents))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.pk, err = kem.NewPublicKey(contents[8:]); err != nil {
+		return nil, err
+	}
+	if cfg.kdf, err = hpke.NewKDF(nbo.Uint16(contents[2:])); err != nil {
+		return nil, err
+	}
+	if cfg.aead, err = hpke.NewAEAD(nbo.Uint16(contents[4:])); err != nil {
+		return nil, err
+	}
+
+	switch cfg.kdf.ID() {
+	case 0x0001:
+		cfg.hash = sha256.New
+	case 0x0002:
+		cfg.hash = sha512.New384
+	case 0x0003:
+		cfg.hash = sha512.New
+	default:
+		return nil, errODoHConfig
+	}
+
+	switch cfg.aead.ID() {
+	case 0x0001:
+		cfg.nk, cfg.nn = 16, 12
+	case 0x0002:
+		cfg.nk, cfg.nn = 32, 12
+	default:
+		return nil, errODoHConfig
+	}
+
+	prk, err := hkdf.Extract(cfg.hash, cfg.contents, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.keyID, err = hkdf.Expand(cfg.hash, prk, "odoh key id", cfg.hash().Size()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// odohMessage encodes an ObliviousDoHMessage.
+func odohMessage(typ byte, keyID, msg []byte) []byte {
+	b := []byte{typ}
+	b = appendOpaque16(b, keyID)
+	return appendOpaque16(b, msg)
+}
+
+// parseODoHMessage decodes an ObliviousDoHMessage of type typ.
+func parseODoHMessage(typ byte, b []byte) (keyID, msg []byte, err error) {
+	if len(b) < 1 || b[0] != typ {
+		return nil, nil, errResourceLen
+	}
+	if keyID, b, err = readOpaque16(b[1:]); err != nil {
+		return nil, nil, err
+	}
+	if msg, b, err = readOpaque16(b); err != nil {
+		return nil, nil, err
+	}
+	if len(b) != 0 {
+		return nil, nil, errResourceLen
+	}
+	return keyID, msg, nil
+}
+
+func appendOpaque16(b, v []byte) []byte {
+	b = append(b, byte(len(v)>>8), byte(len(v)))
+	return append(b, v...)
+}
+
+func readOpaque16(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 || len(b)-2 < int(nbo.Uint16(b)) {
+		return nil, nil, errResourceLen
+	}
+	n := 2 + int(nbo.Uint16(b))
+	return b[2:n], b[n:], nil
+}
+
+func odohAAD(typ byte, v []byte) []byte {
+	return appendOpaque16([]byte{typ}, v)
+}
+
+// responseAEAD derives the cipher and nonce of the response to qplain, see RFC
+// 9230, section 6.4.
+func (cfg *odohConfig) responseAEAD(export func(string, int) ([]byte, error), qplain, nonce []byte) (cipher.AEAD, []byte, error) {
+	secret, err := export("odoh response", cfg.nk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	salt := appendOpaque16(append([]byte(nil), qplain...), nonce)
+
+	prk, err := hkdf.Extract(cfg.hash, secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := hkdf.Expand(cfg.hash, prk, "odoh key", cfg.nk)
+	if err != nil {
+		return nil, nil, err
+	}
+	aeadNonce, err := hkdf.Expand(cfg.hash, prk, "odoh nonce", cfg.nn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, aeadNonce, nil
+}
+
+// odohConfig returns the config of the target of addr, fetching it from the
+// well-known location of the target on first use.
+func (t *Transport) odohConfig(ctx context.Context, addr ObliviousAddr) (*odohConfig, error) {
+	t.odohmu.Lock()
+	cfg, ok := t.odohcfgs[addr.Target.Host]
+	t.odohmu.Unlock()
+	if ok {
+		return cfg, nil
+	}
+
+	u := &url.URL{Scheme: addr.Target.Scheme, Host: addr.Target.Host, Path: odohConfigsPath}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("odoh configs: " + res.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = parseODoHConfigs(b); err != nil {
+		return nil, err
+	}
+
+	t.odohmu.Lock()
+	defer t.odohmu.Unlock()
+
+	if t.odohcfgs == nil {
+		t.odohcfgs = make(map[string]*odohConfig)
+	}
+	t.odohcfgs[addr.Target.Host] = cfg
+	return cfg, nil
+}
+
+// odohConn sends each message to an oblivious target as an HTTP request whose
+// response is read by the next call to Recv.
+type odohConn struct {
+	t    *Transport
+	addr ObliviousAddr
+	ctx  context.Context
+
+	mu       sync.Mutex
+	deadline time.Time
+	res      []byte
+	err      error
+}
+
+func (t *Transport) dialODoH(ctx context.Context, addr ObliviousAddr) (Conn, error) {
+	if _, err := t.odohConfig(ctx, addr); err != nil {
+		return nil, err
+	}
+	return &odohConn{t: t, addr: addr, ctx: ctx}, nil
+}
+
+func (c *odohConn) Send(msg *Message) error {
+	qmsg, err := msg.Pack(nil, true)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.exchange(qmsg)
+
+	c.mu.Lock()
+	c.res, c.err = res, err
+	c.mu.Unlock()
+
+	return err
+}
+
+func (c *odohConn) Recv(msg *Message) error {
+	c.mu.Lock()
+	res, err := c.res, c.err
+	c.res, c.err = nil, ErrUnsupportedOp
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.Unpack(res)
+	return err
+}
+
+// exchange encrypts qmsg for the target, sends it through the proxy and
+// returns the decrypted response.
+func (c *odohConn) exchange(qmsg []byte) ([]byte, error) {
+	ctx := c.ctx
+	c.mu.Lock()
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	c.mu.Unlock()
+
+	cfg, err := c.t.odohConfig(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// ObliviousDoHMessagePlaintext, without padding
+	qplain := appendOpaque16(appendOpaque16(nil, qmsg), nil)
+
+	enc, sender, err := hpke.NewSender(cfg.pk, cfg.kdf, cfg.aead, []byte("odoh query"))
+	if err != nil {
+		return nil, err
+	}
+	ct, err := sender.Seal(odohAAD(odohQuery, cfg.keyID), qplain)
+	if err != nil {
+		return nil, err
+	}
+
+	body := odohMessage(odohQuery, cfg.keyID, append(enc, ct...))
+
+	u := *c.addr.Proxy
+	params := u.Query()
+	params.Set("targethost", c.addr.Target.Host)
+	params.Set("targetpath", c.addr.Target.Path)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", odohContentType)
+	req.Header.Set("Accept", odohContentType)
+
+	res, err := c.t.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		if res.StatusCode == http.StatusUnauthorized {
+			// the target rotated its key
+			c.t.odohmu.Lock()
+			delete(c.t.odohcfgs, c.addr.Target.Host)
+			c.t.odohmu.Unlock()
+		}
+		return nil, errors.New("odoh: " + res.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, rct, err := parseODoHMessage(odohResponse, b)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, aeadNonce, err := cfg.responseAEAD(sender.Export, qplain, nonce)
+	if err != nil {
+		return nil, err
+	}
+	rplain, err := aead.Open(nil, aeadNonce, rct, odohAAD(odohResponse, nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	rmsg, _, err := readOpaque16(rplain)
+	return rmsg, err
+}
+
+func (c *odohConn) Read([]byte) (int, error)  { return 0, ErrUnsupportedOp }
+func (c *odohConn) Write([]byte) (int, error) { return 0, ErrUnsupportedOp }
+func (c *odohConn) Close() error              { return nil }
+func (c *odohConn) LocalAddr() net.Addr       { return nil }
+func (c *odohConn) RemoteAddr() net.Addr      { return c.addr }
+
+func (c *odohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *odohConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *odohConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
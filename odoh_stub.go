@@ -0,0 +1,12 @@
+//go:build !go1.26
+
+package dns
+
+import "context"
+
+// odohConfig is unused without the crypto/hpke package of Go 1.26.
+type odohConfig struct{}
+
+func (t *Transport) dialODoH(ctx context.Context, addr ObliviousAddr) (Conn, error) {
+	return nil, ErrUnsupportedNetwork
+}
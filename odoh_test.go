@@ -0,0 +1,158 @@
+//go:build go1.26
+
+package dns
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/hpke"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTransportODoH(t *testing.T) {
+	t.Parallel()
+
+	kem := hpke.DHKEM(ecdh.X25519())
+	priv, err := kem.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01}
+	contents = appendOpaque16(contents, priv.PublicKey().Bytes())
+
+	configs := []byte{byte(odohVersion >> 8), byte(odohVersion & 0xff)}
+	configs = appendOpaque16(configs, contents)
+	configs = appendOpaque16(nil, configs)
+
+	cfg, err := newODoHConfig(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets := make(chan string, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(odohConfigsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(configs)
+	})
+	mux.HandleFunc("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		targets <- r.URL.Query().Get("targethost") + r.URL.Query().Get("targetpath")
+
+		if want, got := odohContentType, r.Header.Get("Content-Type"); want != got {
+			t.Errorf("want content type %q, got %q", want, got)
+		}
+
+		b, _ := io.ReadAll(r.Body)
+		keyID, encrypted, err := parseODoHMessage(odohQuery, b)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if want, got := string(cfg.keyID), string(keyID); want != got {
+			t.Errorf("want key ID %x, got %x", want, got)
+		}
+
+		enc, ct := encrypted[:32], encrypted[32:]
+		recipient, err := hpke.NewRecipient(enc, priv, cfg.kdf, cfg.aead, []byte("odoh query"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		qplain, err := recipient.Open(odohAAD(odohQuery, keyID), ct)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		qmsg, _, err := readOpaque16(qplain)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var msg Message
+		if _, err := msg.Unpack(qmsg); err != nil {
+			t.Error(err)
+			return
+		}
+
+		res := response(&msg)
+		res.Answers = []Resource{
+			{
+				Name:   msg.Questions[0].Name,
+				Class:  ClassIN,
+				TTL:    time.Minute,
+				Record: &A{A: net.IPv4(127, 0, 0, 1).To4()},
+			},
+		}
+		rmsg, err := res.Pack(nil, true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+
+		aead, aeadNonce, err := cfg.responseAEAD(recipient.Export, qplain, nonce)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rplain := appendOpaque16(appendOpaque16(nil, rmsg), nil)
+		rct := aead.Seal(nil, aeadNonce, rplain, odohAAD(odohResponse, nonce))
+
+		w.Header().Set("Content-Type", odohContentType)
+		w.Write(odohMessage(odohResponse, nonce, rct))
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ObliviousAddr{
+		Proxy:  base.ResolveReference(&url.URL{Path: "/proxy"}),
+		Target: base.ResolveReference(&url.URL{Path: "/dns-query"}),
+	}
+
+	client := &Client{
+		Transport: &Transport{HTTPClient: srv.Client()},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, err := client.Do(ctx, &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.dev.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := base.Host+"/dns-query", <-targets; want != got {
+		t.Errorf("want target %q, got %q", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	if want, got := net.IPv4(127, 0, 0, 1), msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,29 @@
+package dns
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// ObliviousAddr indicates the remote DNS service is an Oblivious DNS over
+// HTTPS target reached through an oblivious proxy, as defined in RFC 9230.
+// The target config is fetched from its well-known location on first use.
+// Oblivious DoH requires Go 1.26, and dialing an ObliviousAddr fails with
+// ErrUnsupportedNetwork when built with an older release.
+type ObliviousAddr struct {
+	Proxy  *url.URL // proxy endpoint, such as "https://proxy.example/proxy"
+	Target *url.URL // target endpoint, such as "https://odoh.example/dns-query"
+}
+
+// Network returns "odoh".
+func (a ObliviousAddr) Network() string { return "odoh" }
+
+// String returns the target endpoint.
+func (a ObliviousAddr) String() string { return a.Target.String() }
+
+func (t *Transport) httpClient() *http.Client {
+	if t.HTTPClient != nil {
+		return t.HTTPClient
+	}
+	return http.DefaultClient
+}
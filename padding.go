@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"math"
+
+	"github.com/helmutkemper/dns/edns"
+)
+
+// padded returns msg with the EDNS Padding option of its OPT record, if any,
+// sized for the packed message to be a multiple of block bytes long, see RFC
+// 7830 and RFC 8467, section 4.1. The message is padded no further than the
+// largest message.
+func padded(msg *Message, block int, compress bool) (*Message, error) {
+	i, j := -1, -1
+	for k, res := range msg.Additionals {
+		if o, ok := res.Record.(*OPT); ok {
+			for l, opt := range o.Options {
+				if opt.Code == edns.OptionCodePadding {
+					i, j = k, l
+				}
+			}
+		}
+	}
+	if i < 0 {
+		return msg, nil
+	}
+
+	options := append([]edns.Option(nil), msg.Additionals[i].Record.(*OPT).Options...)
+	options[j] = edns.Option{Code: edns.OptionCodePadding}
+
+	m := *msg
+	m.Additionals = append([]Resource(nil), msg.Additionals...)
+	m.Additionals[i].Record = &OPT{Options: options}
+
+	b, _, err := m.pack(nil, compress)
+	if err != nil {
+		return nil, err
+	}
+	if n := (block - len(b)%block) % block; n > 0 && len(b)+n <= math.MaxUint16 {
+		options[j].Data = make([]byte, n)
+	}
+	return &m, nil
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/edns"
+)
+
+func TestServerPadding(t *testing.T) {
+	t.Parallel()
+
+	sizes := make(chan int, 2)
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		Listeners:    []ListenerPolicy{{Network: "tcp", Padding: 128}},
+		ResponseHook: func(q *Query, b []byte) { sizes <- len(b) },
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, padding := range []bool{true, false} {
+		opt := &OPT{}
+		if padding {
+			opt.Options = []edns.Option{{Code: edns.OptionCodePadding, Data: make([]byte, 10)}}
+		}
+
+		msg, err := new(Client).Do(context.Background(), &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions:   []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}},
+				Additionals: []Resource{{Name: ".", Class: 4096, Record: opt}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Answers) != 1 {
+			t.Errorf("want answer, got %+v", msg)
+		}
+
+		if n := <-sizes; padding != (n%128 == 0) {
+			t.Errorf("padding %t: want response padded %t, got %d bytes", padding, padding, n)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package dns
 
 import (
 	"io"
+	"net"
+	"os"
 	"sync"
 	"time"
 )
@@ -9,18 +11,60 @@ import (
 type pipeline struct {
 	Conn
 
+	addr  net.Addr
+	born  time.Time
+	state func(net.Addr, ConnState) // if not nil, Transport.ConnState
+
 	rmu, wmu sync.Mutex
 
 	mu       sync.Mutex
 	inflight map[int]pipelineTx
 	readerr  error
+	draining bool
 }
 
+// alive reports whether the pipeline takes new queries.
 func (p *pipeline) alive() bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	return p.readerr == nil
+	return p.readerr == nil && !p.draining
+}
+
+// expired reports whether the pipeline was dialed more than age ago.
+func (p *pipeline) expired(age time.Duration) bool {
+	return age > 0 && time.Since(p.born) >= age
+}
+
+// drain stops the pipeline from taking new queries, and closes it once the
+// responses to the queries in flight are read.
+func (p *pipeline) drain() {
+	p.mu.Lock()
+	if p.draining || p.readerr != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.draining = true
+	idle := len(p.inflight) == 0
+	p.mu.Unlock()
+
+	p.notify(StateDraining)
+	if idle {
+		p.Conn.Close()
+	}
+}
+
+// drained reports whether a draining pipeline has no query in flight.
+//
+// p.mu held
+func (p *pipeline) drained() bool {
+	return p.draining && len(p.inflight) == 0
+}
+
+func (p *pipeline) notify(state ConnState) {
+	if p.state != nil {
+		p.state(p.addr, state)
+	}
 }
 
 func (p *pipeline) conn() Conn {
@@ -30,6 +74,7 @@ func (p *pipeline) conn() Conn {
 			msgerrc: make(chan msgerr),
 			abortc:  make(chan struct{}),
 		},
+		deadlinec: make(chan struct{}),
 	}
 }
 
@@ -47,6 +92,7 @@ func (p *pipeline) run() {
 		p.mu.Lock()
 		tx, ok := p.inflight[msg.ID]
 		delete(p.inflight, msg.ID)
+		drained := ok && p.drained()
 		p.mu.Unlock()
 
 		if !ok {
@@ -54,6 +100,10 @@ func (p *pipeline) run() {
 		}
 
 		go tx.deliver(msgerr{msg: &msg})
+
+		if drained {
+			p.Conn.Close()
+		}
 	}
 	p.rmu.Unlock()
 
@@ -68,6 +118,8 @@ func (p *pipeline) run() {
 	for _, tx := range txs {
 		go tx.deliver(msgerr{err: err})
 	}
+
+	p.notify(StateClosed)
 }
 
 type pipelineConn struct {
@@ -75,21 +127,70 @@ type pipelineConn struct {
 
 	aborto sync.Once
 	tx     pipelineTx
+	ids    []int // IDs of the queries sent
 
+	dlmu                        sync.Mutex
 	readDeadline, writeDeadline time.Time
+	deadlinec                   chan struct{} // closed when readDeadline changes
 }
 
+// Close drops the queries sent on the connection from the inflight queries of
+// the pipeline. The pipelined connection is left open, unless it is draining
+// and no other query is in flight.
 func (c *pipelineConn) Close() error {
-	c.aborto.Do(c.tx.abort)
+	c.aborto.Do(func() {
+		c.tx.abort()
+
+		c.mu.Lock()
+		for _, id := range c.ids {
+			if tx, ok := c.inflight[id]; ok && tx.abortc == c.tx.abortc {
+				delete(c.inflight, id)
+			}
+		}
+		drained := len(c.ids) > 0 && c.drained()
+		c.mu.Unlock()
+
+		if drained {
+			c.Conn.Close()
+		}
+	})
 	return nil
 }
 
+// Recv waits for the response to the query sent, until the read deadline.
 func (c *pipelineConn) Recv(msg *Message) error {
 	var me msgerr
-	select {
-	case me = <-c.tx.msgerrc:
-	case <-c.tx.abortc:
-		return io.ErrUnexpectedEOF
+	for received := false; !received; {
+		c.dlmu.Lock()
+		deadline, deadlinec := c.readDeadline, c.deadlinec
+		c.dlmu.Unlock()
+
+		var (
+			timer    *time.Timer
+			timeoutc <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			timeoutc = timer.C
+		}
+
+		select {
+		case me = <-c.tx.msgerrc:
+			received = true
+		case <-c.tx.abortc:
+			return io.ErrUnexpectedEOF
+		case <-timeoutc:
+			return os.ErrDeadlineExceeded
+		case <-deadlinec:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 
 	if err := me.err; err != nil {
@@ -105,10 +206,14 @@ func (c *pipelineConn) Send(msg *Message) error {
 		return err
 	}
 
+	c.dlmu.Lock()
+	deadline := c.writeDeadline
+	c.dlmu.Unlock()
+
 	c.wmu.Lock()
 	defer c.wmu.Unlock()
 
-	if err := c.Conn.SetWriteDeadline(c.writeDeadline); err != nil {
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
 		return err
 	}
 
@@ -122,11 +227,19 @@ func (c *pipelineConn) SetDeadline(t time.Time) error {
 }
 
 func (c *pipelineConn) SetReadDeadline(t time.Time) error {
+	c.dlmu.Lock()
+	defer c.dlmu.Unlock()
+
 	c.readDeadline = t
+	close(c.deadlinec)
+	c.deadlinec = make(chan struct{})
 	return nil
 }
 
 func (c *pipelineConn) SetWriteDeadline(t time.Time) error {
+	c.dlmu.Lock()
+	defer c.dlmu.Unlock()
+
 	c.writeDeadline = t
 	return nil
 }
@@ -140,6 +253,7 @@ func (c *pipelineConn) register(msg *Message) error {
 	}
 
 	c.inflight[msg.ID] = c.tx
+	c.ids = append(c.ids, msg.ID)
 	return nil
 }
 
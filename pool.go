@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWorkers is the number of workers of a WorkerPool with zero
+	// Workers.
+	defaultWorkers = 256
+
+	// defaultQueueLen is the queue length of a WorkerPool with the
+	// OverloadQueue policy and zero QueueLen.
+	defaultQueueLen = 1024
+)
+
+// An OverloadPolicy is how a WorkerPool handles a query when all of its
+// workers are busy.
+type OverloadPolicy int
+
+const (
+	// OverloadQueue keeps the query in a queue until a worker is free. The
+	// query is dropped if the queue is full, or if it waits longer than the
+	// QueueTimeout of the pool.
+	OverloadQueue OverloadPolicy = iota
+
+	// OverloadDrop drops the query without a response.
+	OverloadDrop
+
+	// OverloadServFail answers the query with a "Server Failure" message.
+	OverloadServFail
+)
+
+// A WorkerPool runs queries on a bounded number of goroutines, so that a flood
+// of queries can not start an unbounded number of goroutines. The zero value
+// is a pool of defaultWorkers workers with the OverloadQueue policy.
+//
+// Workers are started for queries and exit once no query is queued, so an
+// idle pool holds no goroutines.
+//
+// A server and the client it forwards queries with should not share a pool:
+// the forwarded queries would compete for the workers held by the handlers
+// waiting for them, and fail with ErrOverloaded.
+type WorkerPool struct {
+	// Workers is the number of goroutines running queries, defaultWorkers
+	// if zero.
+	Workers int
+
+	// Policy is how a query is handled when all the workers are busy.
+	Policy OverloadPolicy
+
+	// QueueLen is the number of queries the OverloadQueue policy keeps
+	// waiting for a worker, defaultQueueLen if zero.
+	QueueLen int
+
+	// QueueTimeout is how long a query may wait in the queue before it is
+	// dropped. If zero, queued queries wait until a worker is free.
+	QueueTimeout time.Duration
+
+	inito sync.Once
+	sem   chan struct{} // one token per running worker
+	queue chan queuedJob
+}
+
+type queuedJob struct {
+	f      func()
+	queued time.Time
+}
+
+func (p *WorkerPool) init() {
+	p.inito.Do(func() {
+		n := p.Workers
+		if n <= 0 {
+			n = defaultWorkers
+		}
+
+		var qlen int
+		if p.Policy == OverloadQueue {
+			if qlen = p.QueueLen; qlen <= 0 {
+				qlen = defaultQueueLen
+			}
+		}
+
+		p.sem = make(chan struct{}, n)
+		p.queue = make(chan queuedJob, qlen)
+	})
+}
+
+// Do runs f on a worker and returns without waiting for it. If all the
+// workers are busy and f can not be queued, f is not run and Do returns
+// ErrOverloaded.
+func (p *WorkerPool) Do(f func()) error {
+	p.init()
+
+	select {
+	case p.sem <- struct{}{}:
+		go p.work(f)
+		return nil
+	default:
+	}
+
+	select {
+	case p.queue <- queuedJob{f: f, queued: time.Now()}:
+	default:
+		return ErrOverloaded
+	}
+
+	// A worker may have exited between the two selects and left the job
+	// with no one to run it.
+	select {
+	case p.sem <- struct{}{}:
+		go p.work(nil)
+	default:
+	}
+	return nil
+}
+
+func (p *WorkerPool) work(f func()) {
+	for {
+		if f != nil {
+			f()
+		}
+
+		var ok bool
+		if f, ok = p.next(); !ok {
+			return
+		}
+	}
+}
+
+// next returns the next queued job to run, or releases the worker and returns
+// false if there is none.
+func (p *WorkerPool) next() (func(), bool) {
+	for {
+		select {
+		case j := <-p.queue:
+			if p.QueueTimeout > 0 && time.Since(j.queued) > p.QueueTimeout {
+				continue
+			}
+			return j.f, true
+		default:
+		}
+
+		<-p.sem
+
+		// A job queued after the check above would wait for the next
+		// query, unless this worker or a new one picks it up.
+		if len(p.queue) == 0 {
+			return nil, false
+		}
+		select {
+		case p.sem <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+}
+
+// WorkerPoolStats is a snapshot of the state of a WorkerPool.
+type WorkerPoolStats struct {
+	Workers int // goroutines running queries
+	Queued  int // queries waiting for a worker
+}
+
+// Stats returns the state of the pool.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	p.init()
+
+	return WorkerPoolStats{
+		Workers: len(p.sem),
+		Queued:  len(p.queue),
+	}
+}
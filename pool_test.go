@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	t.Parallel()
+
+	pool := &WorkerPool{
+		Workers:  1,
+		QueueLen: 1,
+	}
+
+	var (
+		block = make(chan struct{})
+		ran   = make(chan int, 3)
+	)
+	if err := pool.Do(func() { <-block; ran <- 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Do(func() { ran <- 2 }); err != nil {
+		t.Fatalf("want query queued, got %v", err)
+	}
+	if want, got := ErrOverloaded, pool.Do(func() { ran <- 3 }); want != got {
+		t.Errorf("want error %v with a full queue, got %v", want, got)
+	}
+
+	close(block)
+	if want, got := 1, <-ran; want != got {
+		t.Errorf("want query %d to run first, got %d", want, got)
+	}
+	if want, got := 2, <-ran; want != got {
+		t.Errorf("want queued query %d to run, got %d", want, got)
+	}
+
+	// workers exit once idle, new queries start new ones
+	for i := 0; i < 100; i++ {
+		done := make(chan struct{})
+		for pool.Do(func() { close(done) }) != nil {
+			time.Sleep(time.Millisecond)
+		}
+		<-done
+	}
+}
+
+func TestWorkerPoolQueueTimeout(t *testing.T) {
+	t.Parallel()
+
+	pool := &WorkerPool{
+		Workers:      1,
+		QueueTimeout: 10 * time.Millisecond,
+	}
+
+	block := make(chan struct{})
+	if err := pool.Do(func() { <-block }); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := make(chan struct{}, 1)
+	if err := pool.Do(func() { expired <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+
+	ran := make(chan struct{})
+	for pool.Do(func() { close(ran) }) != nil {
+		time.Sleep(time.Millisecond)
+	}
+	<-ran
+
+	select {
+	case <-expired:
+		t.Error("want expired query to be dropped")
+	default:
+	}
+}
+
+func TestServerWorkersServFail(t *testing.T) {
+	t.Parallel()
+
+	var (
+		block   = make(chan struct{})
+		started = make(chan struct{}, 1)
+	)
+	defer close(block)
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			started <- struct{}{}
+			<-block
+		}),
+		Workers: &WorkerPool{
+			Workers: 1,
+			Policy:  OverloadServFail,
+		},
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	go new(Client).Do(ctx, query)
+	<-started
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := new(Client).Do(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := ServFail, res.RCode; want != got {
+		t.Errorf("want rcode %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,408 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPowerDNSLine is the longest request of a pipe or unix connector.
+const maxPowerDNSLine = 1 << 20
+
+var errPowerDNSResult = errors.New("remote backend call failed")
+
+// PowerDNSBackend serves the answers of a Handler, such as a Zone, to PowerDNS
+// through its remote backend protocol, so that the records of a package
+// RRSet are served by an existing PowerDNS deployment. Its ServeHTTP method
+// is the endpoint of the http connector, in its plain or post_json modes, and
+// ServeConn that of the pipe and unix connectors, see
+// https://doc.powerdns.com/authoritative/backends/remote.html.
+//
+// A lookup is answered with the answers of the Handler to the question, the
+// NS records of a delegation included. A zone transfer of the Handler answers
+// a list. The methods of the protocol not listed here are answered with a
+// false result, which PowerDNS takes as no data.
+type PowerDNSBackend struct {
+	Handler Handler
+
+	// Zones are the origins of the zones served, returned by the
+	// getAllDomains and getDomainInfo methods, their domain_id being their
+	// index.
+	Zones []string
+}
+
+// pdnsRequest is a call of the remote backend protocol.
+type pdnsRequest struct {
+	Method     string         `json:"method"`
+	Parameters pdnsParameters `json:"parameters"`
+}
+
+type pdnsParameters struct {
+	QType    string `json:"qtype,omitempty"`
+	QName    string `json:"qname,omitempty"`
+	Remote   string `json:"remote,omitempty"`
+	ZoneName string `json:"zonename,omitempty"`
+	DomainID int    `json:"domain_id"`
+	Name     string `json:"name,omitempty"`
+}
+
+// pdnsRecord is a record of the result of a lookup or list call.
+type pdnsRecord struct {
+	QType   string `json:"qtype"`
+	QName   string `json:"qname"`
+	Content string `json:"content"`
+	TTL     int64  `json:"ttl"`
+	Auth    *bool  `json:"auth,omitempty"`
+}
+
+// pdnsDomain is the result of the getDomainInfo call.
+type pdnsDomain struct {
+	ID     int    `json:"id"`
+	Zone   string `json:"zone"`
+	Kind   string `json:"kind"`
+	Serial uint32 `json:"serial"`
+}
+
+type pdnsResponse struct {
+	Result interface{} `json:"result"`
+}
+
+// ServeHTTP answers a call of the http connector: a JSON request POSTed in
+// the post_json mode, or a GET of the URL of the method, such as
+// <url>/lookup/<qname>/<qtype>.
+func (b *PowerDNSBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req pdnsRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		req = pdnsPathRequest(r.URL.Path)
+		req.Parameters.Remote = r.Header.Get("X-RemoteBackend-remote")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.call(r.Context(), req))
+}
+
+// pdnsPathRequest returns the call of the path of a GET request of the http
+// connector: its method is the first segment naming one, followed by its
+// parameters.
+func pdnsPathRequest(path string) pdnsRequest {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i, seg := range segs {
+		args := segs[i+1:]
+		arg := func(n int) string {
+			if n < len(args) {
+				return args[n]
+			}
+			return ""
+		}
+
+		req := pdnsRequest{Method: seg}
+		switch seg {
+		case "initialize", "getAllDomains":
+		case "lookup":
+			req.Parameters.QName, req.Parameters.QType = arg(0), arg(1)
+		case "list":
+			req.Parameters.DomainID, _ = strconv.Atoi(arg(0))
+			req.Parameters.ZoneName = arg(1)
+		case "getDomainInfo":
+			req.Parameters.Name = arg(0)
+		default:
+			continue
+		}
+		return req
+	}
+	return pdnsRequest{}
+}
+
+// ServeConn answers the calls of the pipe or unix connector read from conn, a
+// JSON request per line, until it is closed.
+func (b *PowerDNSBackend) ServeConn(ctx context.Context, conn io.ReadWriter) error {
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(nil, maxPowerDNSLine)
+
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		var req pdnsRequest
+		res := pdnsResponse{Result: false}
+		if err := json.Unmarshal(sc.Bytes(), &req); err == nil {
+			res = b.call(ctx, req)
+		}
+
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func (b *PowerDNSBackend) call(ctx context.Context, req pdnsRequest) pdnsResponse {
+	var result interface{}
+	switch req.Method {
+	case "initialize":
+		result = true
+	case "lookup":
+		result = b.lookup(ctx, req.Parameters)
+	case "list":
+		result = b.list(ctx, req.Parameters)
+	case "getAllDomains":
+		domains := make([]pdnsDomain, 0, len(b.Zones))
+		for i, zone := range b.Zones {
+			domains = append(domains, b.domain(ctx, i, zone))
+		}
+		result = domains
+	case "getDomainInfo":
+		for i, zone := range b.Zones {
+			if strings.EqualFold(NormalizeKey(zone), NormalizeKey(req.Parameters.Name)) {
+				result = b.domain(ctx, i, zone)
+			}
+		}
+	}
+
+	if result == nil {
+		result = false
+	}
+	return pdnsResponse{Result: result}
+}
+
+func (b *PowerDNSBackend) lookup(ctx context.Context, p pdnsParameters) interface{} {
+	t, ok := parseTypeText(p.QType)
+	if !ok {
+		return nil
+	}
+
+	name := strings.TrimSuffix(p.QName, ".") + "."
+	msg := b.query(ctx, name, t, p.Remote)
+
+	rrs := pdnsRecords(msg.Answers)
+	for _, res := range msg.Authorities {
+		if res.Record.Type() == TypeNS && strings.EqualFold(res.Name, name) && (t == TypeALL || t == TypeNS) {
+			auth := false
+			rrs = append(rrs, pdnsRecord{QType: typeText(TypeNS), QName: res.Name, Content: rdataText(res.Record), TTL: int64(res.TTL / time.Second), Auth: &auth})
+		}
+	}
+
+	if len(rrs) == 0 {
+		return nil
+	}
+	return rrs
+}
+
+func (b *PowerDNSBackend) list(ctx context.Context, p pdnsParameters) interface{} {
+	zone := p.ZoneName
+	if zone == "" && p.DomainID >= 0 && p.DomainID < len(b.Zones) {
+		zone = b.Zones[p.DomainID]
+	}
+	if zone == "" {
+		return nil
+	}
+
+	msg := b.query(ctx, strings.TrimSuffix(zone, ".")+".", TypeAXFR, "")
+	if msg.RCode != NoError || len(msg.Answers) < 2 {
+		return nil
+	}
+
+	// the closing SOA record is left out
+	return pdnsRecords(msg.Answers[:len(msg.Answers)-1])
+}
+
+func (b *PowerDNSBackend) domain(ctx context.Context, id int, zone string) pdnsDomain {
+	zone = strings.TrimSuffix(zone, ".") + "."
+
+	d := pdnsDomain{ID: id, Zone: zone, Kind: "native"}
+	for _, res := range b.query(ctx, zone, TypeSOA, "").Answers {
+		if soa, ok := res.Record.(*SOA); ok {
+			d.Serial = uint32(soa.Serial)
+		}
+	}
+	return d
+}
+
+// query returns the response of the handler to the question for name of type
+// t, from the remote address of PowerDNS, if any.
+func (b *PowerDNSBackend) query(ctx context.Context, name string, t Type, remote string) *Message {
+	q := &Query{
+		Message: &Message{
+			Questions: []Question{{Name: name, Type: t, Class: ClassIN}},
+		},
+	}
+	if ip := net.ParseIP(remote); ip != nil {
+		q.RemoteAddr = &net.UDPAddr{IP: ip}
+	}
+
+	w := memoryWriter{&messageWriter{msg: reply(q.Message)}}
+	b.Handler.ServeDNS(ctx, w, q)
+
+	msg, err := w.response()
+	if err != nil {
+		return &Message{RCode: ServFail}
+	}
+	return msg
+}
+
+func pdnsRecords(rs []Resource) []pdnsRecord {
+	rrs := make([]pdnsRecord, 0, len(rs))
+	for _, res := range rs {
+		if res.Record.Type() == TypeOPT {
+			continue
+		}
+		rrs = append(rrs, pdnsRecord{
+			QType:   typeText(res.Record.Type()),
+			QName:   res.Name,
+			Content: rdataText(res.Record),
+			TTL:     int64(res.TTL / time.Second),
+		})
+	}
+	return rrs
+}
+
+// PowerDNSRemote is a Handler answering the queries with the records of a
+// PowerDNS remote backend served over HTTP, such as one written for
+// PowerDNS, sent the calls of the http connector in its post_json mode.
+//
+// Each question is looked up with type ANY, as PowerDNS does, and answered
+// with the records of its type, or with its CNAME record. A name without
+// records does not exist. A failed call is a SERVFAIL.
+type PowerDNSRemote struct {
+	// URL is the url of the connector, the method of a call appended to it
+	// as a path segment.
+	URL string
+
+	// Client sends the calls. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// TTL is the TTL of the records returned without one. If zero, one
+	// minute is used.
+	TTL time.Duration
+}
+
+// ServeDNS answers the questions of r with the records of the backend.
+func (p *PowerDNSRemote) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	w.Authoritative(true)
+
+	var found bool
+	for _, q := range r.Questions {
+		params := pdnsParameters{QName: q.Name, QType: "ANY", DomainID: -1}
+		if r.RemoteAddr != nil {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr.String()); err == nil {
+				params.Remote = host
+			}
+		}
+
+		rs, err := p.records(ctx, pdnsRequest{Method: "lookup", Parameters: params})
+		if err != nil {
+			w.Status(ServFail)
+			return
+		}
+
+		for _, res := range rs {
+			found = true
+
+			if t := res.Record.Type(); q.Type == TypeALL || t == q.Type || t == TypeCNAME {
+				writeAnswer(w, res)
+			}
+		}
+	}
+
+	if !found {
+		w.Status(NXDomain)
+	}
+}
+
+// List returns the records of the zone, as listed by the backend.
+func (p *PowerDNSRemote) List(ctx context.Context, zone string) ([]Resource, error) {
+	return p.records(ctx, pdnsRequest{
+		Method:     "list",
+		Parameters: pdnsParameters{ZoneName: strings.TrimSuffix(zone, ".") + ".", DomainID: -1},
+	})
+}
+
+// records returns the records of the result of the lookup or list call req.
+// A false result is no records. The records of types without a presentation
+// format here are left out.
+func (p *PowerDNSRemote) records(ctx context.Context, req pdnsRequest) ([]Resource, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+"/"+req.Method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	hres, err := client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer hres.Body.Close()
+
+	if hres.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if hres.StatusCode != http.StatusOK {
+		return nil, errPowerDNSResult
+	}
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(hres.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	var rrs []pdnsRecord
+	if err := json.Unmarshal(res.Result, &rrs); err != nil {
+		var ok bool
+		if json.Unmarshal(res.Result, &ok) == nil && !ok {
+			return nil, nil
+		}
+		return nil, errPowerDNSResult
+	}
+
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	rs := make([]Resource, 0, len(rrs))
+	for _, rr := range rrs {
+		t, ok := parseTypeText(rr.QType)
+		if !ok {
+			continue
+		}
+		record, err := parseRDataText(t, rr.Content)
+		if err != nil {
+			continue
+		}
+
+		res := Resource{
+			Name:   strings.TrimSuffix(rr.QName, ".") + ".",
+			Class:  ClassIN,
+			TTL:    time.Duration(rr.TTL) * time.Second,
+			Record: record,
+		}
+		if rr.TTL <= 0 {
+			res.TTL = ttl
+		}
+		rs = append(rs, res)
+	}
+	return rs, nil
+}
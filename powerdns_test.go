@@ -0,0 +1,242 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pdnsZone = &Zone{
+	Origin: "pdns.dev.",
+	TTL:    time.Hour,
+	SOA:    &SOA{NS: "ns.pdns.dev.", MBox: "hostmaster.pdns.dev.", Serial: 7},
+	RRs: NewRRSet(map[string]map[Type][]Record{
+		"":    {TypeNS: {&NS{NS: "ns.pdns.dev."}}},
+		"www": {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}}, TypeTXT: {&TXT{TXT: []string{"hello world"}}}},
+		"app": {TypeCNAME: {&CNAME{CNAME: "www.pdns.dev."}}},
+	}),
+}
+
+func TestPowerDNSBackend(t *testing.T) {
+	t.Parallel()
+
+	backend := &PowerDNSBackend{Handler: pdnsZone, Zones: []string{"pdns.dev"}}
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name string
+
+		method, path, body string
+		result             string
+	}{
+		{
+			name:   "initialize",
+			method: "GET", path: "/dns/initialize",
+			result: `true`,
+		},
+		{
+			name:   "lookup",
+			method: "GET", path: "/dns/lookup/www.pdns.dev./ANY",
+			result: `[{"qtype":"A","qname":"www.pdns.dev.","content":"192.0.2.1","ttl":3600},` +
+				`{"qtype":"TXT","qname":"www.pdns.dev.","content":"\"hello world\"","ttl":3600}]`,
+		},
+		{
+			name:   "lookup post_json",
+			method: "POST", path: "/dns/lookup",
+			body:   `{"method":"lookup","parameters":{"qtype":"SOA","qname":"pdns.dev.","remote":"192.0.2.53","zone-id":-1}}`,
+			result: `[{"qtype":"SOA","qname":"pdns.dev.","content":"ns.pdns.dev. hostmaster.pdns.dev. 7 0 0 0 0","ttl":3600}]`,
+		},
+		{
+			name:   "lookup no data",
+			method: "GET", path: "/dns/lookup/none.pdns.dev./A",
+			result: `false`,
+		},
+		{
+			name:   "getDomainInfo",
+			method: "GET", path: "/dns/getDomainInfo/pdns.dev.",
+			result: `{"id":0,"zone":"pdns.dev.","kind":"native","serial":7}`,
+		},
+		{
+			name:   "getAllDomains",
+			method: "POST", path: "/dns/getAllDomains",
+			body:   `{"method":"getAllDomains","parameters":{"include_disabled":true}}`,
+			result: `[{"id":0,"zone":"pdns.dev.","kind":"native","serial":7}]`,
+		},
+		{
+			name:   "unknown method",
+			method: "POST", path: "/dns/getDomainMetadata",
+			body:   `{"method":"getDomainMetadata","parameters":{"name":"pdns.dev.","kind":"ALLOW-AXFR-FROM"}}`,
+			result: `false`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			var body struct {
+				Result json.RawMessage `json:"result"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if want, got := test.result, string(body.Result); want != got {
+				t.Errorf("want result %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestPowerDNSBackendServeConn(t *testing.T) {
+	t.Parallel()
+
+	backend := &PowerDNSBackend{Handler: pdnsZone, Zones: []string{"pdns.dev."}}
+
+	in := strings.Join([]string{
+		`{"method":"initialize","parameters":{"path":"/tmp/pdns.sock","timeout":"2000"}}`,
+		`{"method":"list","parameters":{"zonename":"pdns.dev.","domain_id":0}}`,
+		`not json`,
+	}, "\n")
+
+	var out bytes.Buffer
+	conn := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(in), &out}
+
+	if err := backend.ServeConn(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if want, got := 3, len(lines); want != got {
+		t.Fatalf("want %d responses, got %d", want, got)
+	}
+	if want, got := `{"result":true}`, lines[0]; want != got {
+		t.Errorf("want response %s, got %s", want, got)
+	}
+	if want, got := `{"result":false}`, lines[2]; want != got {
+		t.Errorf("want response %s, got %s", want, got)
+	}
+
+	var list struct {
+		Result []pdnsRecord `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, rr := range list.Result {
+		got = append(got, rr.QType+" "+rr.QName)
+	}
+	want := []string{"SOA pdns.dev.", "NS pdns.dev.", "CNAME app.pdns.dev.", "A www.pdns.dev.", "TXT www.pdns.dev."}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %q, got %q", want, got)
+	}
+}
+
+func TestPowerDNSRemote(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(&PowerDNSBackend{Handler: pdnsZone, Zones: []string{"pdns.dev."}})
+	t.Cleanup(srv.Close)
+
+	remote := &PowerDNSRemote{URL: srv.URL + "/dns/"}
+
+	tests := []struct {
+		name string
+
+		question Question
+		rcode    RCode
+		answers  []string
+	}{
+		{
+			name:     "answer",
+			question: Question{Name: "www.pdns.dev.", Type: TypeA, Class: ClassIN},
+			answers:  []string{"www.pdns.dev. 192.0.2.1"},
+		},
+		{
+			name:     "CNAME",
+			question: Question{Name: "app.pdns.dev.", Type: TypeA, Class: ClassIN},
+			answers:  []string{"app.pdns.dev. www.pdns.dev."},
+		},
+		{
+			name:     "no data",
+			question: Question{Name: "www.pdns.dev.", Type: TypeAAAA, Class: ClassIN},
+		},
+		{
+			name:     "nxdomain",
+			question: Question{Name: "none.pdns.dev.", Type: TypeA, Class: ClassIN},
+			rcode:    NXDomain,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{Message: &Message{Questions: []Question{test.question}}}
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			remote.ServeDNS(context.Background(), w, q)
+
+			if want, got := test.rcode, w.msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+
+			var answers []string
+			for _, res := range w.msg.Answers {
+				answers = append(answers, res.Name+" "+rdataText(res.Record))
+			}
+			if want, got := test.answers, answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %q, got %q", want, got)
+			}
+		})
+	}
+
+	rs, err := remote.List(context.Background(), "pdns.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 5, len(rs); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+	if want, got := (&TXT{TXT: []string{"hello world"}}), rs[len(rs)-1].Record; !reflect.DeepEqual(want, got) {
+		t.Errorf("want record %v, got %v", want, got)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend down", http.StatusBadGateway)
+	}))
+	t.Cleanup(broken.Close)
+
+	q := &Query{Message: &Message{Questions: []Question{{Name: "www.pdns.dev.", Type: TypeA, Class: ClassIN}}}}
+	w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+	(&PowerDNSRemote{URL: broken.URL}).ServeDNS(context.Background(), w, q)
+	if want, got := ServFail, w.msg.RCode; want != got {
+		t.Errorf("want rcode %v, got %v", want, got)
+	}
+}
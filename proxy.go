@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt for the
+// PROXY protocol.
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+const proxyV1MaxLen = 107
+
+// proxyConn is a connection accepted from a trusted proxy. Reads start after
+// the PROXY header and RemoteAddr is the client address sent by the proxy.
+type proxyConn struct {
+	net.Conn
+
+	r    *bufio.Reader
+	addr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.addr }
+
+// trustedProxy reports whether addr is within s.TrustedProxies.
+func (s *Server) trustedProxy(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, ipnet := range s.TrustedProxies {
+		if ipnet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxied reads the PROXY header of a connection from a trusted proxy, and
+// returns conn unchanged for other peers.
+func (s *Server) proxied(conn net.Conn) (net.Conn, error) {
+	if !s.trustedProxy(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	if s.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	r := bufio.NewReader(conn)
+
+	addr, err := readProxyHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+
+	return &proxyConn{Conn: conn, r: r, addr: addr}, nil
+}
+
+// readProxyHeader reads a v1 or v2 PROXY header from r and returns the
+// source address it carries, or nil for a connection made by the proxy
+// itself.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+
+	if b, err = r.Peek(len(proxyV2Sig)); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+
+	return nil, errProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+
+	body := make([]byte, nbo.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+
+	var ipLen int
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil // AF_UNSPEC or AF_UNIX
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, errProxyHeader
+	}
+
+	ip := make(net.IP, ipLen)
+	copy(ip, body[:ipLen])
+	port := nbo.Uint16(body[2*ipLen:])
+
+	switch hdr[13] & 0xf {
+	case 1: // STREAM
+		return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+	case 2: // DGRAM
+		return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+
+	v2 := func(cmd, fam byte, body []byte) []byte {
+		b := append([]byte(nil), proxyV2Sig...)
+		b = append(b, 0x20|cmd, fam, byte(len(body)>>8), byte(len(body)))
+		return append(b, body...)
+	}
+
+	tests := []struct {
+		name string
+		hdr  []byte
+		addr net.Addr
+		err  error
+	}{
+		{
+			name: "v1-tcp4",
+			hdr:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 53\r\n"),
+			addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324},
+		},
+		{
+			name: "v1-tcp6",
+			hdr:  []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4000 53\r\n"),
+			addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4000},
+		},
+		{
+			name: "v1-unknown",
+			hdr:  []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name: "v1-malformed",
+			hdr:  []byte("PROXY TCP4 192.0.2.1\r\n"),
+			err:  errProxyHeader,
+		},
+		{
+			name: "v2-tcp4",
+			hdr:  v2(1, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0, 53}),
+			addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 56324},
+		},
+		{
+			name: "v2-local",
+			hdr:  v2(0, 0x00, nil),
+		},
+		{
+			name: "garbage",
+			hdr:  []byte("\x00\x1c\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00"),
+			err:  errProxyHeader,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := bufio.NewReader(bytes.NewReader(append(test.hdr, "query"...)))
+
+			addr, err := readProxyHeader(r)
+			if want, got := test.err, err; want != got {
+				t.Fatalf("want error %v, got %v", want, got)
+			}
+			if err != nil {
+				return
+			}
+
+			if want, got := test.addr, addr; (want == nil) != (got == nil) || (want != nil && want.String() != got.String()) {
+				t.Errorf("want addr %v, got %v", want, got)
+			}
+
+			rest := make([]byte, 5)
+			if _, err := r.Read(rest); err != nil || string(rest) != "query" {
+				t.Errorf("want header consumed, read %q (%v)", rest, err)
+			}
+		})
+	}
+}
+
+func TestServerTrustedProxy(t *testing.T) {
+	t.Parallel()
+
+	var loopback []*net.IPNet
+	for _, cidr := range []string{"127.0.0.0/8", "::1/128"} {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loopback = append(loopback, ipnet)
+	}
+
+	addrs := make(chan net.Addr, 1)
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			addrs <- r.RemoteAddr
+		}),
+		TrustedProxies: loopback,
+	}
+	mustStart(srv)
+
+	conn, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 53\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{
+		Questions: []Question{
+			{Name: "app.dev.", Type: TypeA, Class: ClassIN},
+		},
+	}
+	buf, err := msg.Pack(make([]byte, 2), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbo.PutUint16(buf[:2], uint16(len(buf)-2))
+	if _, err := conn.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case addr := <-addrs:
+		if want, got := "192.0.2.1:56324", addr.String(); want != got {
+			t.Errorf("want remote addr %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("query not served")
+	}
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The prefix lengths of the client subnets of a Quota, as those of the EDNS
+// Client Subnet option recommended by RFC 7871, section 11.1.
+const (
+	defaultQuotaIPv4PrefixLen = 24
+	defaultQuotaIPv6PrefixLen = 56
+)
+
+// Quota limits the queries served for the clients of each subnet, and for the
+// names of each zone, in each Window. Unlike a response rate limit, the limits
+// apply to the queries, whatever their responses, and are meant to keep a
+// client or a zone from taking the server for itself. The queries over quota
+// are refused, or with Truncate answered with a truncated response.
+//
+// A Quota is set around the Handler of a Server:
+//
+//	srv.Handler = (&dns.Quota{ClientQueries: 100}).Handler(zone)
+type Quota struct {
+	// ClientQueries is the number of queries served to the clients of a
+	// subnet in a Window. If zero, there is no limit.
+	ClientQueries int
+
+	// IPv4PrefixLen and IPv6PrefixLen are the prefix lengths of the client
+	// subnets. If zero, the subnets are /24 and /56.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// Zones maps the zones, such as "example.com.", to the number of queries
+	// served for the names in each of them in a Window. A name counts for
+	// the longest zone it is in.
+	Zones map[string]int
+
+	// Window is the period the queries are counted for. If zero, a second.
+	Window time.Duration
+
+	// Truncate answers the UDP queries over quota with an empty truncated
+	// response, so that the clients retry over TCP, which a spoofed source
+	// cannot. The TCP queries over quota are refused.
+	Truncate bool
+
+	mu     sync.Mutex
+	start  time.Time // of the window
+	counts map[quotaKey]int
+
+	queries, clients, truncated uint64
+	zones                       sync.Map // zone => *uint64, the queries over its quota
+}
+
+// quotaKey is a client subnet or a zone counted by a Quota.
+type quotaKey struct {
+	zone   bool
+	subnet string
+}
+
+// QuotaStats is a snapshot of the counters of a Quota.
+type QuotaStats struct {
+	Queries   uint64            // queries counted
+	Clients   uint64            // queries over the quota of their client subnet
+	Zones     map[string]uint64 // queries over the quota of each zone
+	Truncated uint64            // queries over quota answered with a truncated response
+}
+
+// Handler returns a Handler serving the queries within quota with h.
+func (q *Quota) Handler(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		if q.allow(r, time.Now()) {
+			h.ServeDNS(ctx, w, r)
+			return
+		}
+
+		if q.Truncate && r.RemoteAddr != nil && strings.HasPrefix(r.RemoteAddr.Network(), "udp") {
+			if tw, ok := w.(interface{ truncated(bool) }); ok {
+				atomic.AddUint64(&q.truncated, 1)
+				tw.truncated(true)
+				return
+			}
+		}
+		w.Status(Refused)
+	})
+}
+
+// allow counts the query r, and reports whether it is within quota.
+func (q *Quota) allow(r *Query, now time.Time) bool {
+	atomic.AddUint64(&q.queries, 1)
+
+	client, zone := q.subnet(r.RemoteAddr), q.zone(r.Message)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	window := q.Window
+	if window <= 0 {
+		window = time.Second
+	}
+	if q.counts == nil || now.Sub(q.start) >= window || now.Before(q.start) {
+		q.start, q.counts = now, make(map[quotaKey]int)
+	}
+
+	if client != "" && q.ClientQueries > 0 {
+		k := quotaKey{subnet: client}
+		if q.counts[k] >= q.ClientQueries {
+			atomic.AddUint64(&q.clients, 1)
+			return false
+		}
+		q.counts[k]++
+	}
+
+	if zone != "" {
+		k := quotaKey{zone: true, subnet: zone}
+		if q.counts[k] >= q.Zones[zone] {
+			v, _ := q.zones.LoadOrStore(zone, new(uint64))
+			atomic.AddUint64(v.(*uint64), 1)
+			return false
+		}
+		q.counts[k]++
+	}
+	return true
+}
+
+// subnet returns the client subnet of addr, or "" if addr has no address.
+func (q *Quota) subnet(addr net.Addr) string {
+	return clientSubnet(addr, q.IPv4PrefixLen, q.IPv6PrefixLen)
+}
+
+// zone returns the longest zone of Zones of the questions of msg, or "".
+func (q *Quota) zone(msg *Message) string {
+	var zone string
+	for _, question := range msg.Questions {
+		for z := range q.Zones {
+			if len(z) > len(zone) && hasNameSuffix(question.Name, z) {
+				zone = z
+			}
+		}
+	}
+	return zone
+}
+
+// Stats returns the counters of the quota.
+func (q *Quota) Stats() QuotaStats {
+	stats := QuotaStats{
+		Queries:   atomic.LoadUint64(&q.queries),
+		Clients:   atomic.LoadUint64(&q.clients),
+		Zones:     make(map[string]uint64),
+		Truncated: atomic.LoadUint64(&q.truncated),
+	}
+	q.zones.Range(func(k, v any) bool {
+		stats.Zones[k.(string)] = atomic.LoadUint64(v.(*uint64))
+		return true
+	})
+	return stats
+}
+
+// Expvar returns the counters of Stats as an expvar.Var, published with
+// expvar.Publish, such as under "dns_quota", to alert on the queries over
+// quota.
+func (q *Quota) Expvar() expvar.Var {
+	return expvar.Func(func() any { return q.Stats() })
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	quota := &Quota{
+		ClientQueries: 2,
+		Zones:         map[string]int{"busy.dev.": 3, "dev.": 100},
+		Window:        time.Hour,
+		Truncate:      true,
+	}
+	srv := &Server{
+		Handler: quota.Handler(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		})),
+	}
+
+	var (
+		alice = &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53000}
+		bob   = &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 53000} // alice's subnet
+		carol = &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 53000}
+		dave  = &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53000}
+	)
+
+	tests := []struct {
+		name string
+
+		addr      net.Addr
+		qname     string
+		rcode     RCode
+		truncated bool
+	}{
+		{name: "alice", addr: alice, qname: "test.dev."},
+		{name: "bob", addr: bob, qname: "test.dev."},
+		{name: "subnet over quota", addr: bob, qname: "test.dev.", truncated: true},
+		{name: "carol", addr: carol, qname: "a.busy.dev."},
+		{name: "carol again", addr: carol, qname: "b.busy.dev."},
+		{name: "dave", addr: dave, qname: "busy.dev."},
+		{name: "zone over quota", addr: dave, qname: "c.BUSY.dev.", truncated: true},
+		{name: "tcp over quota", addr: carol, qname: "test.dev.", rcode: Refused},
+	}
+
+	for _, test := range tests {
+		msg, err := srv.ServeMessage(context.Background(), &Query{
+			RemoteAddr: test.addr,
+			Message:    &Message{Questions: []Question{{Name: test.qname, Type: TypeA, Class: ClassIN}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.rcode, msg.RCode; want != got {
+			t.Errorf("%s: want rcode %v, got %v", test.name, want, got)
+		}
+		if want, got := test.truncated, msg.Truncated; want != got {
+			t.Errorf("%s: want truncated %t, got %t", test.name, want, got)
+		}
+		if want, got := !test.truncated && test.rcode == NoError, len(msg.Answers) == 1; want != got {
+			t.Errorf("%s: want answered %t, got %v", test.name, want, msg.Answers)
+		}
+	}
+
+	stats := quota.Stats()
+	if want, got := uint64(len(tests)), stats.Queries; want != got {
+		t.Errorf("want %d queries, got %d", want, got)
+	}
+	if want, got := uint64(2), stats.Clients; want != got {
+		t.Errorf("want %d queries over client quota, got %d", want, got)
+	}
+	if want, got := uint64(1), stats.Zones["busy.dev."]; want != got {
+		t.Errorf("want %d query over zone quota, got %d", want, got)
+	}
+	if want, got := uint64(2), stats.Truncated; want != got {
+		t.Errorf("want %d truncated responses, got %d", want, got)
+	}
+}
+
+func TestQuotaWindow(t *testing.T) {
+	t.Parallel()
+
+	quota := &Quota{ClientQueries: 1, Window: time.Minute}
+	query := &Query{
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53000},
+		Message:    &Message{Questions: []Question{{Name: "test.dev.", Type: TypeA, Class: ClassIN}}},
+	}
+
+	now := time.Now()
+	if !quota.allow(query, now) {
+		t.Error("want first query allowed")
+	}
+	if quota.allow(query, now.Add(time.Second)) {
+		t.Error("want second query over quota")
+	}
+	if !quota.allow(query, now.Add(time.Minute)) {
+		t.Error("want query of the next window allowed")
+	}
+}
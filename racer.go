@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+const defaultStagger = 100 * time.Millisecond
+
+// Racer is a RoundTripper racing a query across several addresses of the same
+// upstream, such as its UDP and TCP addresses, or its DNS-over-TLS and plain
+// addresses, in the manner of Happy Eyeballs, see RFC 8305. The query is sent
+// to the first of Addrs, then to each following one after Stagger or as soon
+// as the previous attempts failed. The first valid response is returned and the
+// other attempts are canceled. A response is valid unless it is truncated or a
+// SERVFAIL; if no response is valid, the last one received or the last error
+// is returned. The RemoteAddr of the query is ignored.
+type Racer struct {
+	// Client sends the queries. If nil, a zero Client is used.
+	Client *Client
+
+	Addrs []net.Addr
+
+	// Stagger is the delay before the query is sent to the next address. If
+	// zero, 100 milliseconds is used.
+	Stagger time.Duration
+}
+
+type raceResult struct {
+	msg *Message
+	err error
+}
+
+// Do sends query to the addresses of r until a valid response is received.
+func (r *Racer) Do(ctx context.Context, query *Query) (*Message, error) {
+	if len(r.Addrs) == 0 {
+		return nil, errNoUpstream
+	}
+
+	client := r.Client
+	if client == nil {
+		client = new(Client)
+	}
+
+	stagger := r.Stagger
+	if stagger == 0 {
+		stagger = defaultStagger
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resc := make(chan raceResult, len(r.Addrs))
+	start := func(addr net.Addr) {
+		msg := *query.Message
+		q := &Query{Message: &msg, RemoteAddr: addr}
+
+		go func() {
+			msg, err := client.Do(ctx, q)
+			resc <- raceResult{msg, err}
+		}()
+	}
+
+	timer := time.NewTimer(stagger)
+	defer timer.Stop()
+
+	var (
+		last      raceResult
+		next      = 1
+		pending   = 1
+		truncated bool
+	)
+	start(r.Addrs[0])
+
+	for pending > 0 {
+		truncated = false
+
+		select {
+		case res := <-resc:
+			pending--
+			if res.err == nil && !res.msg.Truncated && res.msg.RCode != ServFail {
+				return res.msg, nil
+			}
+			if res.msg != nil || last.msg == nil {
+				last = res
+			}
+			truncated = res.msg != nil && res.msg.Truncated
+			// a failed attempt starts the next one without waiting
+		case <-timer.C:
+		}
+
+		if next < len(r.Addrs) {
+			if addr := r.Addrs[next]; truncated && strings.HasPrefix(addr.Network(), "tcp") {
+				ContextClientTrace(ctx).retriedTCP(addr)
+			}
+			start(r.Addrs[next])
+			next, pending = next+1, pending+1
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(stagger)
+		}
+	}
+	return last.msg, last.err
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRacer(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	good, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a nameserver that never answers
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+
+	refused, err := net.ResolveTCPAddr("tcp", mustUnusedAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		w.Status(ServFail)
+	}))
+	servfail, err := net.ResolveUDPAddr("udp", failing.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		addrs   []net.Addr
+		stagger time.Duration
+	}{
+		{
+			name: "lost query",
+
+			addrs:   []net.Addr{dead.LocalAddr(), good},
+			stagger: 20 * time.Millisecond,
+		},
+		{
+			name: "refused connection",
+
+			addrs:   []net.Addr{refused, good},
+			stagger: time.Minute,
+		},
+		{
+			name: "servfail",
+
+			addrs:   []net.Addr{servfail, good},
+			stagger: time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := &Racer{
+				Client:  &Client{Transport: &Transport{DisablePipelining: true}},
+				Addrs:   test.addrs,
+				Stagger: test.stagger,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			msg, err := r.Do(ctx, &Query{
+				Message: &Message{
+					Questions: []Question{questions["A"]},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := NoError, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if want, got := 1, len(msg.Answers); want != got {
+				t.Errorf("want %d answers, got %d", want, got)
+			}
+		})
+	}
+}
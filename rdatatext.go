@@ -0,0 +1,286 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errRDataText = errors.New("invalid record data")
+
+// rdataText returns the data of rr in the presentation format of master
+// files, see RFC 1035, section 5.1. The types without a presentation format
+// here are written as their Key.
+func rdataText(rr Record) string {
+	rr, _ = unwrapRecord(rr)
+
+	switch rr := rr.(type) {
+	case *A:
+		return rr.A.String()
+	case *AAAA:
+		return rr.AAAA.String()
+	case *NS:
+		return rr.NS
+	case *CNAME:
+		return rr.CNAME
+	case *PTR:
+		return rr.PTR
+	case *DNAME:
+		return rr.DNAME
+	case *MX:
+		return fmt.Sprintf("%d %s", rr.Pref, rr.MX)
+	case *SRV:
+		return fmt.Sprintf("%d %d %d %s", rr.Priority, rr.Weight, rr.Port, rr.Target)
+	case *SOA:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", rr.NS, rr.MBox, uint32(rr.Serial),
+			rr.Refresh/time.Second, rr.Retry/time.Second, rr.Expire/time.Second, rr.MinTTL/time.Second)
+	case *TXT:
+		quoted := make([]string, len(rr.TXT))
+		for i, s := range rr.TXT {
+			quoted[i] = quoteText(s)
+		}
+		return strings.Join(quoted, " ")
+	case *CAA:
+		return fmt.Sprintf("%d %s %s", rr.flags(), rr.Tag, quoteText(rr.Value))
+	default:
+		return rr.Key()
+	}
+}
+
+// parseRDataText returns the record of type t with the data s in the
+// presentation format written by rdataText. The domain names are absolute,
+// with or without their trailing dot.
+func parseRDataText(t Type, s string) (Record, error) {
+	fields, err := textFields(s)
+	if err != nil {
+		return nil, err
+	}
+
+	want := func(n int) error {
+		if len(fields) != n {
+			return errRDataText
+		}
+		return nil
+	}
+	name := func(i int) string {
+		return strings.TrimSuffix(fields[i], ".") + "."
+	}
+	numbers := func(bits int, idx ...int) ([]int, error) {
+		v := make([]int, len(idx))
+		for i, j := range idx {
+			n, err := strconv.ParseUint(fields[j], 10, bits)
+			if err != nil {
+				return nil, errRDataText
+			}
+			v[i] = int(n)
+		}
+		return v, nil
+	}
+
+	switch t {
+	case TypeA, TypeAAAA:
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || (t == TypeA) == strings.Contains(fields[0], ":") {
+			return nil, errRDataText
+		}
+		if t == TypeA {
+			return &A{A: ip.To4()}, nil
+		}
+		return &AAAA{AAAA: ip.To16()}, nil
+	case TypeNS, TypeCNAME, TypePTR, TypeDNAME:
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		switch t {
+		case TypeNS:
+			return &NS{NS: name(0)}, nil
+		case TypeCNAME:
+			return &CNAME{CNAME: name(0)}, nil
+		case TypePTR:
+			return &PTR{PTR: name(0)}, nil
+		}
+		return &DNAME{DNAME: name(0)}, nil
+	case TypeMX:
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		v, err := numbers(16, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &MX{Pref: v[0], MX: name(1)}, nil
+	case TypeSRV:
+		if err := want(4); err != nil {
+			return nil, err
+		}
+		v, err := numbers(16, 0, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		return &SRV{Priority: v[0], Weight: v[1], Port: v[2], Target: name(3)}, nil
+	case TypeSOA:
+		if err := want(7); err != nil {
+			return nil, err
+		}
+		v, err := numbers(32, 2, 3, 4, 5, 6)
+		if err != nil {
+			return nil, err
+		}
+		return &SOA{
+			NS:      name(0),
+			MBox:    name(1),
+			Serial:  v[0],
+			Refresh: time.Duration(v[1]) * time.Second,
+			Retry:   time.Duration(v[2]) * time.Second,
+			Expire:  time.Duration(v[3]) * time.Second,
+			MinTTL:  time.Duration(v[4]) * time.Second,
+		}, nil
+	case TypeTXT:
+		if len(fields) == 0 {
+			return nil, errRDataText
+		}
+		return &TXT{TXT: fields}, nil
+	case TypeCAA:
+		if err := want(3); err != nil {
+			return nil, err
+		}
+		v, err := numbers(8, 0)
+		if err != nil {
+			return nil, err
+		}
+		flags := uint8(v[0])
+		return &CAA{Flags: flags, IssuerCritical: flags&CAAFlagIssuerCritical != 0, Tag: fields[1], Value: fields[2]}, nil
+	}
+	return nil, errUnknownType
+}
+
+// hasRDataText reports whether the records of type t have a presentation
+// format here.
+func hasRDataText(t Type) bool {
+	switch t {
+	case TypeA, TypeAAAA, TypeNS, TypeCNAME, TypePTR, TypeDNAME, TypeMX, TypeSRV, TypeSOA, TypeTXT, TypeCAA:
+		return true
+	}
+	return false
+}
+
+// genericRData returns the data of rr in the generic format of the unknown
+// types, see RFC 3597, section 5.
+func genericRData(rr Record) string {
+	b, _ := rr.Pack(nil, nil)
+	if len(b) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %x`, len(b), b)
+}
+
+// rdataOrGeneric returns the data of rr in presentation format, or in the
+// generic format if its type has none here.
+func rdataOrGeneric(rr Record) string {
+	if !hasRDataText(rr.Type()) {
+		return genericRData(rr)
+	}
+	return rdataText(rr)
+}
+
+// quoteText returns s as a quoted character-string, with its quotes and
+// backslashes escaped, and its non-printable bytes as \DDD escapes, see RFC
+// 1035, section 5.1.
+func quoteText(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < ' ' || c > '~':
+			b = append(b, fmt.Sprintf("\\%03d", c)...)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+// textFields splits s into its fields, separated by spaces, and unescapes
+// them, a quoted field holding spaces.
+func textFields(s string) ([]string, error) {
+	var (
+		fields []string
+		field  []byte
+		quoted bool
+		in     bool // in a field
+	)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			if quoted {
+				fields, field, in = append(fields, string(field)), nil, false
+			} else if in {
+				return nil, errRDataText
+			}
+			quoted = !quoted
+			in = quoted
+		case (c == ' ' || c == '\t') && !quoted:
+			if in {
+				fields, field, in = append(fields, string(field)), nil, false
+			}
+		case c == '\\':
+			if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+				n, _ := strconv.Atoi(s[i+1 : i+4])
+				if n > 0xFF {
+					return nil, errRDataText
+				}
+				field, i = append(field, byte(n)), i+3
+			} else if i+1 < len(s) {
+				field, i = append(field, s[i+1]), i+1
+			} else {
+				return nil, errRDataText
+			}
+			in = true
+		default:
+			field, in = append(field, c), true
+		}
+	}
+	if quoted {
+		return nil, errRDataText
+	}
+	if in {
+		fields = append(fields, string(field))
+	}
+	return fields, nil
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// parseTypeText returns the type of the mnemonic name, such as "AAAA", or of
+// its generic form, such as "TYPE65", see RFC 3597. "ANY" is TypeALL.
+func parseTypeText(name string) (Type, bool) {
+	name = strings.ToUpper(name)
+	if name == "ANY" {
+		return TypeALL, true
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(name, "TYPE"), 10, 16); err == nil && strings.HasPrefix(name, "TYPE") {
+		return Type(n), true
+	}
+
+	for t := range NewRecordByType {
+		if typeText(t) == name {
+			return t, true
+		}
+	}
+	for _, t := range []Type{TypeAXFR, TypeIXFR} {
+		if typeText(t) == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRDataText(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		rr   Record
+		text string
+	}{
+		{&A{A: net.IPv4(192, 0, 2, 1).To4()}, "192.0.2.1"},
+		{&AAAA{AAAA: net.ParseIP("2001:db8::1")}, "2001:db8::1"},
+		{&NS{NS: "ns.example.com."}, "ns.example.com."},
+		{&CNAME{CNAME: "www.example.com."}, "www.example.com."},
+		{&PTR{PTR: "host.example.com."}, "host.example.com."},
+		{&DNAME{DNAME: "example.net."}, "example.net."},
+		{&MX{Pref: 10, MX: "mail.example.com."}, "10 mail.example.com."},
+		{&SRV{Priority: 1, Weight: 2, Port: 5060, Target: "sip.example.com."}, "1 2 5060 sip.example.com."},
+		{
+			&SOA{NS: "ns.example.com.", MBox: "hostmaster.example.com.", Serial: 2024010101, Refresh: time.Hour, Retry: time.Minute, Expire: 24 * time.Hour, MinTTL: 5 * time.Minute},
+			"ns.example.com. hostmaster.example.com. 2024010101 3600 60 86400 300",
+		},
+		{&TXT{TXT: []string{"v=spf1 -all", `a "quoted" \ string`, "\x00\xff"}}, `"v=spf1 -all" "a \"quoted\" \\ string" "\000\255"`},
+		{&TXT{TXT: []string{""}}, `""`},
+		{&CAA{Flags: 128, IssuerCritical: true, Tag: "issue", Value: "ca.example.net"}, `128 issue "ca.example.net"`},
+	}
+
+	for _, test := range tests {
+		if want, got := test.text, rdataText(test.rr); want != got {
+			t.Errorf("%T: want text %q, got %q", test.rr, want, got)
+		}
+
+		rr, err := parseRDataText(test.rr.Type(), test.text)
+		if err != nil {
+			t.Errorf("%T: %v", test.rr, err)
+			continue
+		}
+		if want, got := test.rr, rr; !reflect.DeepEqual(want, got) {
+			t.Errorf("%T: want record %v, got %v", test.rr, want, got)
+		}
+	}
+
+	for _, text := range []string{`"unterminated`, `a"b"`, `trailing\`} {
+		if _, err := parseRDataText(TypeTXT, text); err != errRDataText {
+			t.Errorf("%q: want error %v, got %v", text, errRDataText, err)
+		}
+	}
+	if _, err := parseRDataText(TypeA, "2001:db8::1"); err != errRDataText {
+		t.Errorf("want error %v, got %v", errRDataText, err)
+	}
+	if rr, err := parseRDataText(TypeMX, "10 mail.example.com"); err != nil || rr.(*MX).MX != "mail.example.com." {
+		t.Errorf("want an absolute name, got %v, %v", rr, err)
+	}
+}
+
+func TestParseTypeText(t *testing.T) {
+	t.Parallel()
+
+	for name, want := range map[string]Type{"A": TypeA, "aaaa": TypeAAAA, "ANY": TypeALL, "AXFR": TypeAXFR, "TYPE65": 65} {
+		if got, ok := parseTypeText(name); !ok || want != got {
+			t.Errorf("%s: want type %d, got %d", name, want, got)
+		}
+	}
+	if _, ok := parseTypeText("BOGUS"); ok {
+		t.Error("want no type for BOGUS")
+	}
+}
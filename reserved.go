@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ednsFlagsZ is the mask of the reserved EDNS flags, the lower 16 bits of the
+// TTL of an OPT record other than the DO bit.
+const ednsFlagsZ = 0x7FFF
+
+// A ReservedBitsPolicy is how messages with the reserved Z bit of the header,
+// or reserved EDNS flags, set are handled. These bits must be zero, and are
+// set by malformed messages or by fingerprinting tools.
+type ReservedBitsPolicy int
+
+const (
+	// ReservedBitsIgnore handles the message as received.
+	ReservedBitsIgnore ReservedBitsPolicy = iota
+
+	// ReservedBitsNormalize clears the reserved bits of the message.
+	ReservedBitsNormalize
+
+	// ReservedBitsDrop drops a query without a response. A Client fails
+	// with ErrReservedBits on a response.
+	ReservedBitsDrop
+
+	// ReservedBitsFormErr answers a query with a "Format Error" message. A
+	// Client fails with ErrReservedBits on a response.
+	ReservedBitsFormErr
+)
+
+// hasReservedBits reports whether a reserved bit of msg is set.
+func hasReservedBits(msg *Message) bool {
+	if msg.Z {
+		return true
+	}
+
+	for _, res := range msg.Additionals {
+		if _, ok := res.Record.(*OPT); ok && uint32(res.TTL/time.Second)&ednsFlagsZ != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeReservedBits clears the reserved bits of msg.
+func normalizeReservedBits(msg *Message) {
+	msg.Z = false
+
+	for i, res := range msg.Additionals {
+		if _, ok := res.Record.(*OPT); ok {
+			ttl := uint32(res.TTL/time.Second) &^ ednsFlagsZ
+			msg.Additionals[i].TTL = time.Duration(ttl) * time.Second
+		}
+	}
+}
+
+// reservedBits applies the ReservedBits policy of the server to the query r.
+// It reports whether the query was rejected, after answering it if so.
+func (s *Server) reservedBits(ctx context.Context, w MessageWriter, r *Query) bool {
+	if s.ReservedBits == ReservedBitsIgnore || !hasReservedBits(r.Message) {
+		return false
+	}
+
+	switch s.ReservedBits {
+	case ReservedBitsNormalize:
+		normalizeReservedBits(r.Message)
+		return false
+	case ReservedBitsFormErr:
+		w.Status(FormErr)
+		if err := w.Reply(ctx); err != nil {
+			s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+		}
+	}
+	return true
+}
+
+// reservedBits applies the ReservedBits policy of the client to the response
+// msg.
+func (c *Client) reservedBits(msg *Message) error {
+	if c.ReservedBits == ReservedBitsIgnore || !hasReservedBits(msg) {
+		return nil
+	}
+
+	if c.ReservedBits == ReservedBitsNormalize {
+		normalizeReservedBits(msg)
+		return nil
+	}
+	return ErrReservedBits
+}
@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerReservedBits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		policy ReservedBitsPolicy
+
+		rcode   RCode
+		dropped bool
+		z       bool
+	}{
+		{name: "ignore", policy: ReservedBitsIgnore, z: true},
+		{name: "normalize", policy: ReservedBitsNormalize},
+		{name: "drop", policy: ReservedBitsDrop, dropped: true},
+		{name: "formerr", policy: ReservedBitsFormErr, rcode: FormErr},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := &Server{
+				Addr:         mustUnusedAddr(),
+				ReservedBits: test.policy,
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					if want, got := test.z, hasReservedBits(r.Message); want != got {
+						t.Errorf("want reserved bits %t, got %t", want, got)
+					}
+					w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+				}),
+			}
+			mustStart(srv)
+
+			addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			msg, err := new(Client).Do(ctx, &Query{
+				RemoteAddr: addr,
+				Message: &Message{
+					Z: true,
+					Questions: []Question{
+						{Name: "z.dev.", Type: TypeA, Class: ClassIN},
+					},
+					Additionals: []Resource{
+						{Name: ".", Class: 1232, TTL: 0x8001 * time.Second, Record: &OPT{}},
+					},
+				},
+			})
+			if test.dropped {
+				if err == nil {
+					t.Error("want dropped query")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.rcode, msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if msg.Z {
+				t.Error("want Z bit cleared in the response")
+			}
+		})
+	}
+}
+
+func TestClientReservedBits(t *testing.T) {
+	t.Parallel()
+
+	msg := func() *Message {
+		return &Message{
+			Z: true,
+			Additionals: []Resource{
+				{Name: ".", Class: 1232, TTL: 0x8040 * time.Second, Record: &OPT{}},
+			},
+		}
+	}
+
+	if err := new(Client).reservedBits(msg()); err != nil {
+		t.Errorf("want reserved bits ignored, got %v", err)
+	}
+
+	m := msg()
+	if err := (&Client{ReservedBits: ReservedBitsNormalize}).reservedBits(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Z || hasReservedBits(m) {
+		t.Error("want reserved bits cleared")
+	}
+	if want, got := 0x8000*time.Second, m.Additionals[0].TTL; want != got {
+		t.Errorf("want OPT TTL %v with the DO bit kept, got %v", want, got)
+	}
+
+	if want, got := ErrReservedBits, (&Client{ReservedBits: ReservedBitsDrop}).reservedBits(msg()); want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
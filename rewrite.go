@@ -0,0 +1,255 @@
+package dns
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Rewriter rewrites the questions of queries and the records of their
+// responses according to Rules. The first rule matching a question applies to
+// it.
+//
+// Intercept rewrites the queries sent through a RoundTripper, such as with
+// Client.Use or around the Forwarder of a Server. Handler rewrites the queries
+// served by a Handler; its upstream queries, made with Recur, are sent for the
+// original questions.
+type Rewriter struct {
+	Rules []RewriteRule
+}
+
+// RewriteRule is a rule of a Rewriter.
+type RewriteRule struct {
+	// Type, if not TypeANY, is the question type the rule applies to.
+	Type Type
+
+	// Suffix matches the names equal to or under it, case-insensitively. An
+	// empty Suffix matches all names. It is ignored if Regexp is set.
+	Suffix string
+
+	// Regexp, if not nil, matches the names of the questions.
+	Regexp *regexp.Regexp
+
+	// Replace, if not empty, replaces the matched suffix of the name, or is
+	// the template expanded for the match of Regexp, as with
+	// Regexp.ReplaceAllString. The owner names of the response records are
+	// rewritten back to the original names.
+	Replace string
+
+	// NewType, if not TypeANY, replaces the type of the question, such as to
+	// answer TypeALL queries with the A records.
+	NewType Type
+
+	// Answer, if not nil, rewrites each answer of the response, such as to
+	// replace private addresses. If it returns false the answer is dropped.
+	Answer func(Resource) (Resource, bool)
+}
+
+func (r *RewriteRule) match(q Question) bool {
+	if r.Type != TypeANY && r.Type != q.Type {
+		return false
+	}
+	if r.Regexp != nil {
+		return r.Regexp.MatchString(q.Name)
+	}
+	return hasNameSuffix(q.Name, r.Suffix)
+}
+
+func (r *RewriteRule) rename(name string) string {
+	switch {
+	case r.Replace == "":
+		return name
+	case r.Regexp != nil:
+		return r.Regexp.ReplaceAllString(name, r.Replace)
+	default:
+		return name[:len(name)-len(r.Suffix)] + r.Replace
+	}
+}
+
+// hasNameSuffix reports whether name is suffix or a name under it.
+func hasNameSuffix(name, suffix string) bool {
+	switch {
+	case suffix == "":
+		return true
+	case len(name) < len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix):
+		return false
+	}
+	return len(name) == len(suffix) || suffix[0] == '.' || name[len(name)-len(suffix)-1] == '.'
+}
+
+// rewriting is the rewriting of the questions of a query.
+type rewriting struct {
+	rules []*RewriteRule // indexed by question, nil if no rule matched
+	orig  []Question
+	new   []Question
+}
+
+func (rw *Rewriter) rewrite(msg *Message) (*Message, *rewriting) {
+	var (
+		rwg     = &rewriting{orig: msg.Questions}
+		matched bool
+	)
+	for _, q := range msg.Questions {
+		var rule *RewriteRule
+		for i := range rw.Rules {
+			if rw.Rules[i].match(q) {
+				rule, matched = &rw.Rules[i], true
+				break
+			}
+		}
+
+		if rule != nil {
+			q.Name = rule.rename(q.Name)
+			if rule.NewType != TypeANY {
+				q.Type = rule.NewType
+			}
+		}
+		rwg.rules = append(rwg.rules, rule)
+		rwg.new = append(rwg.new, q)
+	}
+	if !matched {
+		return msg, nil
+	}
+
+	m := *msg
+	m.Questions = rwg.new
+	return &m, rwg
+}
+
+// name returns the original name of a name of the rewritten questions.
+func (rwg *rewriting) name(fqdn string) string {
+	for i, rule := range rwg.rules {
+		if rule == nil || rule.Replace == "" {
+			continue
+		}
+		if strings.EqualFold(fqdn, rwg.new[i].Name) {
+			return rwg.orig[i].Name
+		}
+		if rule.Regexp == nil && hasNameSuffix(fqdn, rule.Replace) {
+			return fqdn[:len(fqdn)-len(rule.Replace)] + rule.Suffix
+		}
+	}
+	return fqdn
+}
+
+// answer rewrites an answer of the response, or returns false to drop it.
+func (rwg *rewriting) answer(res Resource) (Resource, bool) {
+	res.Name = rwg.name(res.Name)
+	for _, rule := range rwg.rules {
+		if rule != nil && rule.Answer != nil {
+			var ok bool
+			if res, ok = rule.Answer(res); !ok {
+				return res, false
+			}
+		}
+	}
+	return res, true
+}
+
+func (rwg *rewriting) response(msg *Message) *Message {
+	m := *msg
+	m.Questions = rwg.orig
+	m.Answers = nil
+	for _, res := range msg.Answers {
+		if res, ok := rwg.answer(res); ok {
+			m.Answers = append(m.Answers, res)
+		}
+	}
+	m.Authorities = rwg.resources(msg.Authorities)
+	m.Additionals = rwg.resources(msg.Additionals)
+	return &m
+}
+
+func (rwg *rewriting) resources(s []Resource) []Resource {
+	if len(s) == 0 {
+		return s
+	}
+
+	v := make([]Resource, 0, len(s))
+	for _, res := range s {
+		res.Name = rwg.name(res.Name)
+		v = append(v, res)
+	}
+	return v
+}
+
+// Intercept returns a RoundTripper sending the rewritten queries to next, and
+// returning their responses rewritten back.
+func (rw *Rewriter) Intercept(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+		msg, rwg := rw.rewrite(query.Message)
+		if rwg == nil {
+			return next.Do(ctx, query)
+		}
+
+		q := *query
+		q.Message = msg
+
+		res, err := next.Do(ctx, &q)
+		if err != nil {
+			return nil, err
+		}
+		return rwg.response(res), nil
+	})
+}
+
+// Handler returns a Handler serving the rewritten queries with h, and writing
+// its records rewritten back.
+func (rw *Rewriter) Handler(h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		msg, rwg := rw.rewrite(r.Message)
+		if rwg == nil {
+			h.ServeDNS(ctx, w, r)
+			return
+		}
+
+		q := *r
+		q.Message = msg
+
+		h.ServeDNS(ctx, &rewriteWriter{MessageWriter: w, rwg: rwg}, &q)
+	})
+}
+
+type rewriteWriter struct {
+	MessageWriter
+
+	rwg *rewriting
+}
+
+func (w *rewriteWriter) Answer(fqdn string, ttl time.Duration, rec Record) {
+	res, ok := w.rwg.answer(Resource{Name: fqdn, TTL: ttl, Record: rec})
+	if ok {
+		w.MessageWriter.Answer(res.Name, res.TTL, res.Record)
+	}
+}
+
+func (w *rewriteWriter) Authority(fqdn string, ttl time.Duration, rec Record) {
+	w.MessageWriter.Authority(w.rwg.name(fqdn), ttl, rec)
+}
+
+func (w *rewriteWriter) Additional(fqdn string, ttl time.Duration, rec Record) {
+	w.MessageWriter.Additional(w.rwg.name(fqdn), ttl, rec)
+}
+
+func (w *rewriteWriter) AnswerResource(res Resource) {
+	if res, ok := w.rwg.answer(res); ok {
+		writeAnswer(w.MessageWriter, res)
+	}
+}
+
+func (w *rewriteWriter) AuthorityResource(res Resource) {
+	res.Name = w.rwg.name(res.Name)
+	writeAuthority(w.MessageWriter, res)
+}
+
+func (w *rewriteWriter) AdditionalResource(res Resource) {
+	res.Name = w.rwg.name(res.Name)
+	writeAdditional(w.MessageWriter, res)
+}
+
+func (w *rewriteWriter) Compress(compress bool) {
+	if cw, ok := w.MessageWriter.(CompressionWriter); ok {
+		cw.Compress(compress)
+	}
+}
@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRewriterIntercept(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		if want, got := "app.new.dev.", r.Questions[0].Name; want != got {
+			t.Errorf("want upstream question %q, got %q", want, got)
+		}
+		w.Answer("app.new.dev.", time.Minute, &A{A: net.IPv4(10, 0, 0, 1).To4()})
+		w.Answer("app.new.dev.", time.Minute, &A{A: net.IPv4(203, 0, 113, 1).To4()})
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+
+	rw := &Rewriter{
+		Rules: []RewriteRule{
+			{
+				Suffix:  "old.dev.",
+				Replace: "new.dev.",
+				Answer: func(res Resource) (Resource, bool) {
+					a, ok := res.Record.(*A)
+					return res, !ok || !private.Contains(a.A)
+				},
+			},
+		},
+	}
+
+	client := new(Client)
+	client.Use(rw.Intercept)
+
+	msg, err := client.Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.old.dev.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "app.old.dev.", msg.Questions[0].Name; want != got {
+		t.Errorf("want question %q, got %q", want, got)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	if want, got := "app.old.dev.", msg.Answers[0].Name; want != got {
+		t.Errorf("want answer name %q, got %q", want, got)
+	}
+	if want, got := net.IPv4(203, 0, 113, 1), msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want answer %s, got %s", want, got)
+	}
+}
+
+func TestRewriterHandler(t *testing.T) {
+	t.Parallel()
+
+	rw := &Rewriter{
+		Rules: []RewriteRule{
+			{
+				Type:    TypeALL,
+				NewType: TypeA,
+			},
+			{
+				Regexp:  regexp.MustCompile(`^(\w+)-v1\.rw\.dev\.$`),
+				Replace: "$1.rw.dev.",
+			},
+		},
+	}
+
+	srv := mustServer(rw.Handler(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		q := r.Questions[0]
+		if q.Type != TypeA || q.Name != "app.rw.dev." {
+			w.Status(NXDomain)
+			return
+		}
+		w.Answer(q.Name, time.Minute, &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	})))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q Question
+
+		answer string
+	}{
+		{q: Question{Name: "app.rw.dev.", Type: TypeALL, Class: ClassIN}, answer: "app.rw.dev."},
+		{q: Question{Name: "app-v1.rw.dev.", Type: TypeA, Class: ClassIN}, answer: "app-v1.rw.dev."},
+		{q: Question{Name: "app-v2.rw.dev.", Type: TypeA, Class: ClassIN}},
+	}
+
+	for _, test := range tests {
+		msg, err := new(Client).Do(context.Background(), &Query{
+			RemoteAddr: addr,
+			Message:    &Message{Questions: []Question{test.q}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.answer == "" {
+			if want, got := NXDomain, msg.RCode; want != got {
+				t.Errorf("%s: want rcode %v, got %v", test.q.Name, want, got)
+			}
+			continue
+		}
+		if want, got := 1, len(msg.Answers); want != got {
+			t.Fatalf("%s: want %d answers, got %d", test.q.Name, want, got)
+		}
+		if want, got := test.answer, msg.Answers[0].Name; want != got {
+			t.Errorf("%s: want answer name %q, got %q", test.q.Name, want, got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The defaults of a ResponseRateLimit.
+const (
+	defaultRRLResponses = 5
+	defaultRRLSlip      = 2
+)
+
+// ResponseRateLimit limits the rate of the identical UDP responses sent to the
+// clients of each subnet, like the response rate limiting of BIND, so that the
+// server does not reflect and amplify the attacks spoofing the address of
+// their victim. The responses over the rate are dropped, but every Slip-th one
+// is replaced with an empty truncated response, so that the clients of the
+// subnet retry over TCP, which a spoofed source cannot. The TCP and TLS
+// responses are not limited.
+//
+// The responses are identical for the same name, type and RCODE. The "Name
+// Error" and error responses to the clients of a subnet are counted together,
+// whatever their name, so that the random names of a query flood are limited.
+type ResponseRateLimit struct {
+	// ResponsesPerSecond is the number of identical responses sent to the
+	// clients of a subnet each second. If zero, 5.
+	ResponsesPerSecond int
+
+	// Slip is the number of the responses over the rate for each one
+	// replaced with an empty truncated response, the others dropped. If
+	// zero, 2; if 1, all of them are truncated. If negative, all of them are
+	// dropped.
+	Slip int
+
+	// IPv4PrefixLen and IPv6PrefixLen are the prefix lengths of the client
+	// subnets. If zero, the subnets are /24 and /56.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	mu     sync.Mutex
+	start  time.Time // of the second counted
+	counts map[rrlKey]int
+}
+
+// rrlKey is the client subnet and the response counted by a
+// ResponseRateLimit.
+type rrlKey struct {
+	subnet string
+	name   string
+	t      Type
+	rcode  RCode
+}
+
+// limit counts the response of RCODE rcode to the query r, and returns
+// QueryServe if it is within the rate, or whether it is dropped or truncated.
+func (l *ResponseRateLimit) limit(r *Query, rcode RCode, now time.Time) QueryAction {
+	subnet := clientSubnet(r.RemoteAddr, l.IPv4PrefixLen, l.IPv6PrefixLen)
+	if subnet == "" {
+		return QueryServe
+	}
+
+	k := rrlKey{subnet: subnet, rcode: rcode}
+	if rcode == NoError && len(r.Questions) > 0 {
+		k.name, k.t = strings.ToLower(r.Questions[0].Name), r.Questions[0].Type
+	}
+
+	rate := l.ResponsesPerSecond
+	if rate <= 0 {
+		rate = defaultRRLResponses
+	}
+	slip := l.Slip
+	if slip == 0 {
+		slip = defaultRRLSlip
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts == nil || now.Sub(l.start) >= time.Second || now.Before(l.start) {
+		l.start, l.counts = now, make(map[rrlKey]int)
+	}
+
+	l.counts[k]++
+	if over := l.counts[k] - rate; over > 0 {
+		if slip > 0 && over%slip == 0 {
+			return QueryTruncate
+		}
+		return QueryDrop
+	}
+	return QueryServe
+}
+
+// clientSubnet returns the subnet of the prefix lengths v4 and v6 of addr, /24
+// and /56 if zero, or "" if addr has no address.
+func clientSubnet(addr net.Addr, v4, v6 int) string {
+	ip := addrIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		if v4 <= 0 {
+			v4 = defaultQuotaIPv4PrefixLen
+		}
+		return ip4.Mask(net.CIDRMask(v4, 32)).String()
+	}
+
+	if v6 <= 0 {
+		v6 = defaultQuotaIPv6PrefixLen
+	}
+	return ip.Mask(net.CIDRMask(v6, 128)).String()
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResponseRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var (
+		now   = time.Now()
+		query = func(ip net.IP, name string) *Query {
+			return &Query{
+				RemoteAddr: &net.UDPAddr{IP: ip, Port: 53},
+				Message:    &Message{Questions: []Question{{Name: name, Type: TypeA, Class: ClassIN}}},
+			}
+		}
+		client = net.IPv4(192, 0, 2, 1)
+		peer   = net.IPv4(192, 0, 2, 2)
+		other  = net.IPv4(198, 51, 100, 1)
+	)
+
+	l := &ResponseRateLimit{ResponsesPerSecond: 2}
+
+	want := []QueryAction{QueryServe, QueryServe, QueryDrop, QueryTruncate, QueryDrop, QueryTruncate}
+	for i, want := range want {
+		ip := client
+		if i%2 == 1 {
+			ip = peer // of the same subnet
+		}
+		if got := l.limit(query(ip, "www.local."), NoError, now); want != got {
+			t.Errorf("response %d: want action %v, got %v", i+1, want, got)
+		}
+	}
+
+	if want, got := QueryServe, l.limit(query(client, "WWW.local."), NXDomain, now); want != got {
+		t.Errorf("want other RCODE served, got %v", got)
+	}
+	if want, got := QueryServe, l.limit(query(other, "www.local."), NoError, now); want != got {
+		t.Errorf("want other subnet served, got %v", got)
+	}
+	if want, got := QueryServe, l.limit(query(client, "www.local."), NoError, now.Add(time.Second)); want != got {
+		t.Errorf("want response served the next second, got %v", got)
+	}
+
+	l = &ResponseRateLimit{ResponsesPerSecond: 1, Slip: -1}
+	for i, name := range []string{"a.local.", "b.local.", "c.local."} {
+		want := QueryDrop
+		if i == 0 {
+			want = QueryServe
+		}
+		if got := l.limit(query(client, name), NXDomain, now); want != got {
+			t.Errorf("%s: want NXDOMAIN action %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestServerResponseRateLimit(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(192, 0, 2, 1).To4()})
+		}),
+		Listeners: []ListenerPolicy{
+			{Network: "udp", ResponseRateLimit: &ResponseRateLimit{ResponsesPerSecond: 1, Slip: 1}},
+		},
+	}
+	mustStart(srv)
+
+	udpAddr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		addr      net.Addr
+		truncated bool
+	}{
+		{name: "udp", addr: udpAddr},
+		{name: "udp over rate", addr: udpAddr, truncated: true},
+		{name: "tcp", addr: tcpAddr},
+		{name: "tcp again", addr: tcpAddr},
+	}
+
+	for _, test := range tests {
+		msg, err := new(Client).Do(context.Background(), &Query{
+			RemoteAddr: test.addr,
+			Message: &Message{
+				Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := test.truncated, msg.Truncated; want != got {
+			t.Errorf("%s: want truncated %t, got %t", test.name, want, got)
+		}
+		if want, got := !test.truncated, len(msg.Answers) == 1; want != got {
+			t.Errorf("%s: want answered %t, got %v", test.name, want, msg.Answers)
+		}
+	}
+
+	if want, got := uint64(1), srv.QueryStats().RateLimited; want != got {
+		t.Errorf("want %d response rate limited, got %d", want, got)
+	}
+}
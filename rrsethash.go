@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"sort"
+)
+
+// Dedup returns rs without the resources of the same name, class, type and
+// data as an earlier one, whatever the case of their names, see RFC 2181,
+// section 5. The resource kept has the lowest TTL of its duplicates. The
+// resources that cannot be packed are kept as is. If rs has no duplicates,
+// rs itself is returned.
+func Dedup(rs []Resource) []Resource {
+	if len(rs) < 2 {
+		return rs
+	}
+
+	var (
+		seen = make(map[string]int, len(rs))
+		out  []Resource
+	)
+	for i, res := range rs {
+		k, err := dedupKey(res)
+		if err != nil {
+			if out != nil {
+				out = append(out, res)
+			}
+			continue
+		}
+
+		j, dup := seen[k]
+		if !dup {
+			if out != nil {
+				seen[k] = len(out)
+				out = append(out, res)
+			} else {
+				seen[k] = i
+			}
+			continue
+		}
+
+		if out == nil {
+			out = append(make([]Resource, 0, len(rs)-1), rs[:i]...)
+		}
+		if res.TTL < out[j].TTL {
+			out[j].TTL = res.TTL
+		}
+	}
+	if out == nil {
+		return rs
+	}
+	return out
+}
+
+// dedupKey returns the canonical wire format of res without its TTL.
+func dedupKey(res Resource) (string, error) {
+	res.TTL = 0
+	b, err := CanonicalBytes(res)
+	return string(b), err
+}
+
+// HashRRset returns the SHA-256 digest of the records of rs, whatever their
+// order, their duplicates, their TTLs and the case of their names: of their
+// canonical wire format without TTL, sorted. Two RRsets, or the sections of
+// two responses, with the same hash hold the same data.
+func HashRRset(rs []Resource) ([sha256.Size]byte, error) {
+	keys := make([]string, 0, len(rs))
+	for _, res := range rs {
+		k, err := dedupKey(res)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			h.Write([]byte(k))
+		}
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
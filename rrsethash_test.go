@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	t.Parallel()
+
+	a1 := &A{A: net.IPv4(192, 0, 2, 1).To4()}
+	a2 := &A{A: net.IPv4(192, 0, 2, 2).To4()}
+
+	tests := []struct {
+		name     string
+		rs, want []Resource
+	}{
+		{
+			name: "no-duplicates",
+			rs: []Resource{
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a1},
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a2},
+			},
+			want: []Resource{
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a1},
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a2},
+			},
+		},
+		{
+			name: "duplicates",
+			rs: []Resource{
+				{Name: "a.example.", Class: ClassIN, TTL: time.Hour, Record: a1},
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a2},
+				{Name: "A.Example.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+				{Name: "a.example.", Class: ClassIN, TTL: time.Hour, Record: a2},
+				{Name: "b.example.", Class: ClassIN, TTL: time.Hour, Record: a1},
+			},
+			want: []Resource{
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a1},
+				{Name: "a.example.", Class: ClassIN, TTL: time.Minute, Record: a2},
+				{Name: "b.example.", Class: ClassIN, TTL: time.Hour, Record: a1},
+			},
+		},
+		{
+			name: "case-of-rdata-names",
+			rs: []Resource{
+				{Name: "example.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "NS1.example."}},
+				{Name: "example.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "ns1.example."}},
+				{Name: "example.", Class: ClassCH, TTL: time.Hour, Record: &NS{NS: "ns1.example."}},
+			},
+			want: []Resource{
+				{Name: "example.", Class: ClassIN, TTL: time.Hour, Record: &NS{NS: "NS1.example."}},
+				{Name: "example.", Class: ClassCH, TTL: time.Hour, Record: &NS{NS: "ns1.example."}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			rs := append([]Resource(nil), test.rs...)
+			if want, got := test.want, Dedup(rs); !reflect.DeepEqual(want, got) {
+				t.Errorf("want resources %v, got %v", want, got)
+			}
+			if want, got := test.rs, rs; !reflect.DeepEqual(want, got) {
+				t.Errorf("want resources left as is %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestHashRRset(t *testing.T) {
+	t.Parallel()
+
+	a1 := Resource{Name: "a.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}}
+	a2 := Resource{Name: "a.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}}
+
+	h, err := HashRRset([]Resource{a1, a2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := a1
+	same.Name, same.TTL = "A.EXAMPLE.", time.Minute
+	if o, err := HashRRset([]Resource{a2, same, a1}); err != nil || o != h {
+		t.Errorf("want hash %x, got %x (%v)", h, o, err)
+	}
+	if o, err := HashRRset([]Resource{a1}); err != nil || o == h {
+		t.Errorf("want hash other than %x, got %x (%v)", h, o, err)
+	}
+
+	if _, err := HashRRset([]Resource{{Name: "not-fqdn", Class: ClassIN, Record: a1.Record}}); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestZoneReaderDedup(t *testing.T) {
+	t.Parallel()
+
+	z, err := cloudZone([]Resource{
+		{Name: "example.", Class: ClassIN, TTL: time.Hour, Record: &SOA{NS: "ns1.example.", MBox: "admin.example."}},
+		{Name: "www.example.", Class: ClassIN, TTL: time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+		{Name: "WWW.example.", Class: ClassIN, TTL: time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+	}, "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Record{&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}, TTL: time.Minute}}
+	if got := z.GetRecords("www", TypeA); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,407 @@
+package dns
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// opNotify is the opcode of the NOTIFY messages, see RFC 1996.
+const opNotify OpCode = 4
+
+const (
+	defaultSchedulerRetry = time.Minute
+	defaultNotifyRetry    = 2 * time.Second
+	defaultNotifyAttempts = 5
+	defaultJitter         = 0.1
+)
+
+var errNotifyRefused = errors.New("notify refused")
+
+// Scheduler runs the timers of secondary zones, and sends the NOTIFY messages
+// of primary zones, see RFC 1035, section 4.3.5 and RFC 1996. A secondary
+// zone is refreshed after the Refresh interval of its SOA record, and after a
+// failure every Retry interval; its records expire after the Expire interval
+// without a successful refresh, until the next one. The NOTIFY messages are
+// sent to each secondary server, again after a failure.
+//
+// The delays are shortened by a random Jitter, so that the zones loaded
+// together are not refreshed together. Run runs the timers; the zones may be
+// added before or while it runs.
+type Scheduler struct {
+	// Refresh refreshes the secondary zone origin, such as with a SOA query
+	// to its primary server, and a zone transfer if its serial is newer. It
+	// returns the SOA record of the zone held once refreshed, whose intervals
+	// schedule the next refresh.
+	Refresh func(ctx context.Context, origin string) (*SOA, error)
+
+	// Expire, if not nil, is called once the records of the zone origin
+	// expired, so that they are no longer answered. The zone is refreshed
+	// again nonetheless.
+	Expire func(ctx context.Context, origin string)
+
+	// SendNotify, if not nil, sends the NOTIFY message of the zone origin to
+	// the server at addr. If nil, it is sent with Client.
+	SendNotify func(ctx context.Context, origin string, soa *SOA, addr net.Addr) error
+
+	// Client sends the NOTIFY messages of a Scheduler without SendNotify.
+	// If nil, a zero Client is used.
+	Client *Client
+
+	// NotifyRetry is the delay before a failed NOTIFY is sent again, doubled
+	// after each failure. If zero, 2 seconds is used.
+	NotifyRetry time.Duration
+
+	// NotifyAttempts is the number of times a NOTIFY is sent to a server at
+	// most. If zero, it is sent 5 times.
+	NotifyAttempts int
+
+	// Jitter is the fraction of the delays they are shortened by, at most.
+	// If zero, 0.1 is used. If negative, there is no jitter.
+	Jitter float64
+
+	// Logger, if not nil, logs the failed refreshes and NOTIFY messages, and
+	// the expired zones.
+	Logger Logger
+
+	mu     sync.Mutex
+	zones  map[string]*scheduledZone
+	events scheduleQueue
+	wakec  chan struct{}
+}
+
+type scheduledZone struct {
+	origin string
+	gen    int // of the events of the zone, bumped once removed
+
+	soa        *SOA      // of the last refresh, nil before the first
+	expires    time.Time // once the records expire, zero once expired
+	refreshing bool
+	pending    bool // a refresh is due once the current one is done
+}
+
+// scheduleEvent is a refresh of a zone, or a NOTIFY to a server.
+type scheduleEvent struct {
+	at time.Time
+
+	zone *scheduledZone
+	gen  int
+
+	origin  string // of a NOTIFY
+	soa     *SOA
+	addr    net.Addr
+	attempt int
+}
+
+type scheduleQueue []*scheduleEvent
+
+func (q scheduleQueue) Len() int            { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q scheduleQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *scheduleQueue) Push(x interface{}) { *q = append(*q, x.(*scheduleEvent)) }
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Add schedules the secondary zone origin, with the SOA record of the zone
+// held, or nil if it is not loaded yet. A zone without a SOA record is
+// refreshed at once; a zone already scheduled is rescheduled.
+func (s *Scheduler) Add(origin string, soa *SOA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := NormalizeKey(origin)
+	if s.zones == nil {
+		s.zones = make(map[string]*scheduledZone)
+	}
+
+	z := &scheduledZone{origin: origin, soa: soa}
+	if old, ok := s.zones[k]; ok {
+		z.gen = old.gen + 1
+		old.gen++
+	}
+	s.zones[k] = z
+
+	now := time.Now()
+	if soa == nil {
+		s.push(&scheduleEvent{at: now, zone: z, gen: z.gen})
+		return
+	}
+	z.expires = now.Add(soa.Expire)
+	s.push(&scheduleEvent{at: now.Add(s.jitter(soa.Refresh)), zone: z, gen: z.gen})
+}
+
+// Remove stops the timers of the zone origin.
+func (s *Scheduler) Remove(origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := NormalizeKey(origin)
+	if z, ok := s.zones[k]; ok {
+		z.gen++
+		delete(s.zones, k)
+	}
+}
+
+// Notified refreshes the zone origin at once, such as when a NOTIFY message
+// is received for it. It reports whether the zone is scheduled.
+func (s *Scheduler) Notified(origin string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z, ok := s.zones[NormalizeKey(origin)]
+	if !ok {
+		return false
+	}
+	if z.refreshing {
+		z.pending = true
+		return true
+	}
+	s.push(&scheduleEvent{at: time.Now(), zone: z, gen: z.gen})
+	return true
+}
+
+// Notify sends the NOTIFY message of the zone origin, with its new SOA record
+// soa, to each of the servers at addrs, after a random delay of up to Jitter
+// seconds.
+func (s *Scheduler) Notify(origin string, soa *SOA, addrs ...net.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, addr := range addrs {
+		s.push(&scheduleEvent{
+			at:     now.Add(time.Second - s.jitter(time.Second)),
+			origin: origin,
+			soa:    soa,
+			addr:   addr,
+		})
+	}
+}
+
+// Run runs the refreshes and sends the NOTIFY messages at their time until
+// ctx is done, and returns ctx.Err().
+func (s *Scheduler) Run(ctx context.Context) error {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		wakec := s.wake()
+
+		var (
+			due  []*scheduleEvent
+			now  = time.Now()
+			wait = time.Hour
+		)
+		for len(s.events) > 0 {
+			if e := s.events[0]; e.at.After(now) {
+				wait = e.at.Sub(now)
+				break
+			}
+			due = append(due, heap.Pop(&s.events).(*scheduleEvent))
+		}
+		for _, e := range due {
+			s.start(ctx, e)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wakec:
+		case <-timer.C:
+		}
+	}
+}
+
+// start runs the event e on a new goroutine.
+//
+// s.mu held
+func (s *Scheduler) start(ctx context.Context, e *scheduleEvent) {
+	if e.addr != nil {
+		go s.notify(ctx, e)
+		return
+	}
+
+	z := e.zone
+	if e.gen != z.gen {
+		return // removed or rescheduled
+	}
+	if z.refreshing {
+		z.pending = true
+		return
+	}
+	z.refreshing = true
+
+	go s.refresh(ctx, z, e.gen)
+}
+
+func (s *Scheduler) refresh(ctx context.Context, z *scheduledZone, gen int) {
+	soa, err := s.Refresh(ctx, z.origin)
+	if err == nil && soa == nil {
+		err = errNoSOA
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z.refreshing = false
+	if gen != z.gen {
+		return
+	}
+	if err != nil && ctx.Err() == nil {
+		s.log(ctx, slog.LevelWarn, "dns refresh", "zone", z.origin, "err", err)
+	}
+
+	now := time.Now()
+
+	var next time.Duration
+	switch {
+	case err == nil:
+		z.soa, z.expires = soa, now.Add(soa.Expire)
+		next = s.jitter(soa.Refresh)
+	case z.soa != nil:
+		next = s.jitter(z.soa.Retry)
+		if !z.expires.IsZero() && !now.Before(z.expires) {
+			z.expires = time.Time{}
+			s.expire(ctx, z.origin)
+		}
+	default:
+		next = s.jitter(defaultSchedulerRetry)
+	}
+
+	if z.pending {
+		z.pending, next = false, 0
+	}
+	s.push(&scheduleEvent{at: now.Add(next), zone: z, gen: z.gen})
+}
+
+// expire calls Expire for the zone origin on a new goroutine.
+//
+// s.mu held
+func (s *Scheduler) expire(ctx context.Context, origin string) {
+	s.log(ctx, slog.LevelWarn, "dns zone expired", "zone", origin)
+
+	if s.Expire != nil {
+		go s.Expire(ctx, origin)
+	}
+}
+
+func (s *Scheduler) notify(ctx context.Context, e *scheduleEvent) {
+	var err error
+	if s.SendNotify != nil {
+		err = s.SendNotify(ctx, e.origin, e.soa, e.addr)
+	} else {
+		err = s.notifyClient(ctx, e.origin, e.soa, e.addr)
+	}
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	s.log(ctx, slog.LevelWarn, "dns notify", "zone", e.origin, "addr", e.addr, "err", err)
+
+	attempts := s.NotifyAttempts
+	if attempts <= 0 {
+		attempts = defaultNotifyAttempts
+	}
+	if e.attempt+1 >= attempts {
+		return
+	}
+
+	retry := s.NotifyRetry
+	if retry <= 0 {
+		retry = defaultNotifyRetry
+	}
+	retry <<= uint(e.attempt)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := *e
+	next.at, next.attempt = time.Now().Add(s.jitter(retry)), e.attempt+1
+	s.push(&next)
+}
+
+// notifyClient sends the NOTIFY message of the zone origin to the server at
+// addr with Client, see RFC 1996, section 3.7.
+func (s *Scheduler) notifyClient(ctx context.Context, origin string, soa *SOA, addr net.Addr) error {
+	msg := &Message{
+		OpCode:        opNotify,
+		Authoritative: true,
+		Questions:     []Question{{Name: origin, Type: TypeSOA, Class: ClassIN}},
+	}
+	if soa != nil {
+		msg.Answers = []Resource{{Name: origin, Class: ClassIN, Record: soa}}
+	}
+
+	client := s.Client
+	if client == nil {
+		client = new(Client)
+	}
+
+	res, err := client.Do(ctx, &Query{RemoteAddr: addr, Message: msg})
+	if err != nil {
+		return err
+	}
+	if res.RCode != NoError {
+		return errNotifyRefused
+	}
+	return nil
+}
+
+// push schedules the event e, and wakes Run up.
+//
+// s.mu held
+func (s *Scheduler) push(e *scheduleEvent) {
+	heap.Push(&s.events, e)
+
+	if s.wakec != nil {
+		close(s.wakec)
+		s.wakec = nil
+	}
+}
+
+// wake returns the channel closed once an event is pushed.
+//
+// s.mu held
+func (s *Scheduler) wake() chan struct{} {
+	if s.wakec == nil {
+		s.wakec = make(chan struct{})
+	}
+	return s.wakec
+}
+
+// jitter returns d shortened by up to the Jitter fraction of it.
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	j := s.Jitter
+	if j == 0 {
+		j = defaultJitter
+	}
+	if j < 0 || d <= 0 {
+		return d
+	}
+	return d - time.Duration(rand.Float64()*j*float64(d))
+}
+
+func (s *Scheduler) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Log(ctx, level, msg, args...)
+	}
+}
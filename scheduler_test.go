@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRefresh(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu        sync.Mutex
+		refreshes = make(map[string]int)
+		expiredc  = make(chan string, 1)
+	)
+
+	sched := &Scheduler{
+		Refresh: func(ctx context.Context, origin string) (*SOA, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			refreshes[origin]++
+			switch origin {
+			case "failing.dev.":
+				if refreshes[origin] > 1 {
+					return nil, errors.New("primary unreachable")
+				}
+				return &SOA{Refresh: 10 * time.Millisecond, Retry: 10 * time.Millisecond, Expire: 50 * time.Millisecond}, nil
+			case "notified.dev.":
+				return &SOA{Refresh: time.Hour, Retry: time.Hour, Expire: time.Hour}, nil
+			}
+			return &SOA{Refresh: 10 * time.Millisecond, Retry: time.Hour, Expire: time.Hour}, nil
+		},
+		Expire: func(ctx context.Context, origin string) {
+			expiredc <- origin
+		},
+		Jitter: -1,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sched.Run(ctx)
+
+	sched.Add("refreshed.dev.", nil)
+	sched.Add("failing.dev.", nil)
+	sched.Add("notified.dev.", &SOA{Refresh: time.Hour, Retry: time.Hour, Expire: time.Hour})
+	sched.Add("removed.dev.", &SOA{Refresh: 10 * time.Millisecond})
+	sched.Remove("removed.dev.")
+
+	if sched.Notified("unknown.dev.") {
+		t.Error("want unknown zone not notified")
+	}
+	if !sched.Notified("NOTIFIED.dev") {
+		t.Error("want zone notified")
+	}
+
+	select {
+	case origin := <-expiredc:
+		if want, got := "failing.dev.", origin; want != got {
+			t.Errorf("want zone %q expired, got %q", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("want zone expired")
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := refreshes["refreshed.dev."]
+		mu.Unlock()
+
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want zone refreshed 3 times, got %d", n)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if want, got := 1, refreshes["notified.dev."]; want != got {
+		t.Errorf("want notified zone refreshed %d time, got %d", want, got)
+	}
+	if want, got := 0, refreshes["removed.dev."]; want != got {
+		t.Errorf("want removed zone refreshed %d times, got %d", want, got)
+	}
+}
+
+func TestSchedulerNotify(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		notifies []*Message
+	)
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		notifies = append(notifies, r.Message)
+		if len(notifies) == 1 {
+			w.Status(ServFail)
+		}
+	}))
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched := &Scheduler{
+		NotifyRetry: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sched.Run(ctx)
+
+	soa := &SOA{NS: "ns.notify.dev.", MBox: "hostmaster.notify.dev.", Serial: 42}
+	sched.Notify("notify.dev.", soa, addr)
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := len(notifies)
+		mu.Unlock()
+
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want NOTIFY sent again, got %d", n)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	msg := notifies[1]
+	if want, got := opNotify, msg.OpCode; want != got {
+		t.Errorf("want opcode %d, got %d", want, got)
+	}
+	if want, got := (Question{Name: "notify.dev.", Type: TypeSOA, Class: ClassIN}), msg.Questions[0]; want != got {
+		t.Errorf("want question %v, got %v", want, got)
+	}
+	if len(msg.Answers) != 1 || msg.Answers[0].Record.(*SOA).Serial != 42 {
+		t.Errorf("want SOA record answered, got %v", msg.Answers)
+	}
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+)
+
+// Selector selects the answers of a Zone to a question from the records of its
+// name and type, such as to spread the clients across the addresses of an
+// RRset. The records stored with metadata are passed as their *RREntry, and
+// answered without it.
+type Selector interface {
+	// Select returns the answers to the query r, chosen or reordered from
+	// answers. It must not modify the records of answers.
+	Select(r *Query, answers []Resource) []Resource
+}
+
+// SelectorFunc is an adapter allowing the use of a function as a Selector.
+type SelectorFunc func(*Query, []Resource) []Resource
+
+// Select calls f(r, answers).
+func (f SelectorFunc) Select(r *Query, answers []Resource) []Resource {
+	return f(r, answers)
+}
+
+// StickySelector is a Selector answering each client with the same A and AAAA
+// records of an RRset, for session affinity. The records are ranked by a hash
+// of the client IP address and the record, so that adding or removing a
+// record only moves the clients answered with it. Other records are answered
+// unchanged.
+type StickySelector struct {
+	// N is the number of records of each type to answer with. If zero, one
+	// record is answered.
+	N int
+}
+
+// Select answers with the N highest ranked A and AAAA records for the client
+// of r. Queries without a client IP address get all the records.
+func (s StickySelector) Select(r *Query, answers []Resource) []Resource {
+	ip := addrIP(r.RemoteAddr)
+	if ip == nil {
+		return answers
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	n := s.N
+	if n <= 0 {
+		n = 1
+	}
+
+	type ranked struct {
+		Resource
+
+		score uint64
+	}
+
+	var (
+		out []Resource
+		rrs = make(map[Type][]ranked)
+	)
+	for _, res := range answers {
+		switch t := res.Record.Type(); t {
+		case TypeA, TypeAAAA:
+			h := fnv.New64a()
+			h.Write(ip)
+			h.Write([]byte(res.Record.Key()))
+
+			rrs[t] = append(rrs[t], ranked{Resource: res, score: h.Sum64()})
+		default:
+			out = append(out, res)
+		}
+	}
+
+	for _, t := range []Type{TypeA, TypeAAAA} {
+		v := rrs[t]
+		sort.Slice(v, func(i, j int) bool { return v[i].score > v[j].score })
+
+		for i := 0; i < len(v) && i < n; i++ {
+			out = append(out, v[i].Resource)
+		}
+	}
+	return out
+}
+
+// addrIP returns the IP address of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case nil:
+		return nil
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStickySelector(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin:   "sticky.dev.",
+		TTL:      time.Minute,
+		Selector: StickySelector{},
+	}
+	zone.SetKey("app", map[Type][]Record{
+		TypeA: {
+			&A{A: net.IPv4(10, 0, 0, 1).To4()},
+			&A{A: net.IPv4(10, 0, 0, 2).To4()},
+			&A{A: net.IPv4(10, 0, 0, 3).To4()},
+			&A{A: net.IPv4(10, 0, 0, 4).To4()},
+		},
+	})
+
+	serve := func(client net.IP) string {
+		w := &clientWriter{messageWriter: &messageWriter{msg: new(Message)}}
+		zone.ServeDNS(context.Background(), w, &Query{
+			RemoteAddr: &net.UDPAddr{IP: client, Port: 53000},
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.sticky.dev.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+
+		if want, got := 1, len(w.msg.Answers); want != got {
+			t.Fatalf("want %d answers, got %d", want, got)
+		}
+		return w.msg.Answers[0].Record.(*A).A.String()
+	}
+
+	chosen := make(map[string]string)
+	for i := 1; i <= 64; i++ {
+		client := net.IPv4(192, 0, 2, byte(i))
+
+		addr := serve(client)
+		for j := 0; j < 3; j++ {
+			if got := serve(client); addr != got {
+				t.Fatalf("client %s: want answer %s, got %s", client, addr, got)
+			}
+		}
+		chosen[client.String()] = addr
+	}
+
+	counts := make(map[string]int)
+	for _, addr := range chosen {
+		counts[addr]++
+	}
+	if len(counts) < 2 {
+		t.Errorf("want clients spread across the records, got %v", counts)
+	}
+
+	// removing a record only moves the clients answered with it
+	zone.DeleteRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 4).To4()})
+
+	for client, addr := range chosen {
+		if addr == "10.0.0.4" {
+			continue
+		}
+		if got := serve(net.ParseIP(client)); addr != got {
+			t.Errorf("client %s: want answer %s, got %s", client, addr, got)
+		}
+	}
+}
@@ -4,12 +4,23 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"log"
+	"log/slog"
 	"net"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/helmutkemper/dns/edns"
 )
 
+// defaultUDPQueryTimeout is the time a UDP query is served for by a Server
+// without a UDPQueryTimeout.
+const defaultUDPQueryTimeout = 5 * time.Second
+
 // A Server defines parameters for running a DNS server. The zero value for
 // Server is a valid configuration
 type Server struct {
@@ -17,128 +28,403 @@ type Server struct {
 	Handler   Handler     // handler to invoke
 	TLSConfig *tls.Config // optional TLS config, used by ListenAndServeTLS
 
+	// ClientCAs, if not nil, makes ServeTLS require client certificates
+	// signed by one of these authorities. Queries from a client with a
+	// verified certificate carry its Identity.
+	ClientCAs *x509.CertPool
+
+	// ClientRoles maps the name of a verified client identity to its roles.
+	ClientRoles map[string][]string
+
+	// Listeners override the Handler, the Forwarder, the recursion and the
+	// privacy and abuse policies of the queries of some of the listeners of
+	// the server, by transport or local address, such as to pad the
+	// responses of the DNS over TLS listeners and require cookies on the
+	// UDP ones. The first policy matching a query applies to it.
+	Listeners []ListenerPolicy
+
+	// SNIRoutes maps the server names requested by TLS clients (SNI) to the
+	// certificate and the handler of their connections, for resolvers
+	// serving several tenants on one address. A name is lower case, or a
+	// wildcard such as "*.example.com" matching a single label. Connections
+	// for other names use TLSConfig and Handler.
+	//
+	// ServeTLS negotiates the "dot" ALPN protocol, added to the NextProtos
+	// of TLSConfig.
+	SNIRoutes map[string]SNIRoute
+
+	// TrustedProxies lists the networks of load balancers that prefix TCP and
+	// TLS connections with a PROXY protocol v1 or v2 header. The header is
+	// required on connections from these networks, and its source address is
+	// used as the RemoteAddr of their queries. Other peers must not send it.
+	TrustedProxies []*net.IPNet
+
+	// SocketOptions, if not nil, are applied to the sockets of the listeners
+	// created by ListenAndServe and ListenAndServeTLS.
+	SocketOptions *SocketOptions
+
 	// Forwarder relays a recursive query. If nil, recursive queries are
 	// answered with a "Query Refused" message.
+	//
+	// A query that a Client would forward back to the address it was received
+	// on fails with ErrForwardingLoop, and the query is answered with a
+	// "Server Failure" message carrying an Extended DNS Error, as when a limit
+	// below is exceeded.
 	Forwarder RoundTripper
 
-	// ErrorLog specifies an optional logger for errors accepting connections,
-	// reading data, and unpacking messages.
-	// If nil, logging is done via the log package's standard logger.
+	// ForwardEDNSOptions are the codes of the EDNS options of a query copied
+	// into the query forwarded upstream. The other options are stripped,
+	// and the OPT record, its payload size and flags, is kept. If nil, only
+	// the EDNS Client Subnet option is copied: the cookies of a query are
+	// those of the client and this server, see RFC 7873, its padding is
+	// that of the transport it was received over, see RFC 7830, and the
+	// Extended DNS Errors are only meaningful in responses, see RFC 8914. If
+	// empty but not nil, every option is stripped.
+	ForwardEDNSOptions []edns.OptionCode
+
+	// MaxUpstreamQueries limits the number of queries sent upstream by a
+	// Client while serving a query. If zero, there is no limit.
+	MaxUpstreamQueries int
+
+	// MaxCNAMEChain limits the length of the CNAME chains in the responses to
+	// the queries sent upstream by a Client. If zero, there is no limit.
+	MaxCNAMEChain int
+
+	// Logger, if not nil, logs the events of the server: malformed queries,
+	// failed reads and replies, handler panics, failed forwarded queries and
+	// zone transfers.
+	Logger Logger
+
+	// ErrorLog specifies an optional logger for the events of LevelWarn and
+	// above when Logger is nil. If nil too, logging is done via the log
+	// package's standard logger.
 	ErrorLog *log.Logger
+
+	// ResponseHook is an optional function called with the packed bytes of
+	// each response message, without any transport framing, after they are
+	// written to the connection.
+	ResponseHook func(*Query, []byte)
+
+	// Capture, if not nil, is called with the packed bytes of each query
+	// read and each response written by the server, such as the Capture
+	// method of a PcapWriter. Queries that fail to unpack are captured too.
+	Capture func(CapturePacket)
+
+	// Workers, if not nil, serves queries on a bounded pool of goroutines
+	// instead of a new goroutine per query, and applies its OverloadPolicy
+	// to the queries it rejects. Reading from connections never waits for a
+	// worker.
+	Workers *WorkerPool
+
+	// IdleTimeout is the maximum amount of time to wait for the next query
+	// on a TCP or TLS connection before closing it. If zero, there is no
+	// timeout.
+	IdleTimeout time.Duration
+
+	// ReadTimeout is the maximum duration for reading a query from a TCP or
+	// TLS connection once its first byte is received, and for the TLS
+	// handshake. WriteTimeout is the maximum duration for writing a response
+	// to a TCP or TLS connection. If zero, there is no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// MaxConnLifetime is the maximum amount of time a TCP or TLS connection
+	// is kept open. No query is read once it elapses, and the connection is
+	// closed after the pending responses are written. If zero, connections
+	// are kept open until the client closes them or they become idle.
+	MaxConnLifetime time.Duration
+
+	// UDPQueryTimeout is the time a UDP query is served for once received:
+	// the context of the handler, and so of its upstream queries, is done
+	// when it elapses, the client having sent the query again or given up
+	// by then. If zero, 5 seconds are used, the default timeout of the stub
+	// resolvers, see resolv.conf(5). If negative, there is no deadline.
+	//
+	// StreamQueryTimeout is the time a TCP or TLS query is served for once
+	// read. If zero, the IdleTimeout is used, if any.
+	//
+	// QueryBudget returns the time left to a handler.
+	UDPQueryTimeout    time.Duration
+	StreamQueryTimeout time.Duration
+
+	// MaxConns limits the number of TCP and TLS connections served at the
+	// same time. Connections accepted past the limit are closed at once. If
+	// zero, there is no limit.
+	MaxConns int
+
+	// MaxUDPSize, if larger than 512, is the largest UDP message of the
+	// server. Queries up to MaxUDPSize bytes are read, and a response is
+	// sent up to the UDP payload size advertised in the EDNS OPT record of
+	// its query, at most MaxUDPSize, and truncated past it. Otherwise UDP
+	// messages are 512 bytes at most. TCP and TLS messages are up to 65535
+	// bytes, whatever MaxUDPSize.
+	MaxUDPSize int
+
+	// ReservedBits is how queries with the reserved Z bit of the header, or
+	// reserved EDNS flags, set are handled. By default they are ignored.
+	ReservedBits ReservedBitsPolicy
+
+	// DisableCompression disables the name compression of the responses.
+	// A handler may still set it for a response with a CompressionWriter.
+	DisableCompression bool
+
+	// SuppressDuplicates coalesces the retransmissions of a UDP query, from
+	// the same address with the same ID and question, received while the
+	// query is being served. The handler serves the first query only, and
+	// its response is written again for each retransmission.
+	SuppressDuplicates bool
+
+	// Classifier, if not nil, classifies each query before it is served,
+	// given the rates of the queries of its client, and the query is
+	// dropped, truncated or tarpitted as classified, such as by a
+	// NXDomainDetector. The queries so handled are counted by QueryStats.
+	Classifier QueryClassifier
+
+	// CookieSecret is the secret the server cookies of the listeners
+	// requiring cookies are hashed with, see RFC 7873. The servers of an
+	// anycast address share it. If nil, a random secret is generated.
+	CookieSecret []byte
+
+	// Tarpit, if not nil, delays the responses to the queries of the
+	// clients of its networks, and sets the delay of those tarpitted by the
+	// Classifier.
+	Tarpit *Tarpit
+
+	// SnapshotCache, if not nil, is a Cache of the Handler whose snapshot
+	// is loaded from SnapshotFile once ListenAndServe or ListenAndServeTLS
+	// starts, and saved to it once it returns, such as when its context is
+	// done on shutdown, so that the entries not expired survive a restart.
+	// A snapshot that fails to load is logged, and the cache starts cold.
+	SnapshotCache *Cache
+	SnapshotFile  string
+
+	// ResponseRateLimit, if not nil, limits the rate of the identical UDP
+	// responses to the clients of each subnet. The responses so dropped or
+	// truncated are counted by QueryStats.
+	ResponseRateLimit *ResponseRateLimit
+
+	// MinimalResponses omits the authority and additional records not
+	// required by the answers of a response, like the minimal-responses
+	// option of BIND, to make responses smaller and less amplifying: the
+	// authority section of the positive answers and the additional records
+	// but for the OPT record, the signatures and the glue of referrals. The
+	// records omitted are counted by QueryStats. The responses are not
+	// served from the pack cache of a Zone.
+	MinimalResponses bool
+
+	conno sync.Once
+	conns chan struct{}
+
+	cookieo   sync.Once
+	cookieKey []byte
+
+	compc  compressionCounters
+	queryc queryCounters
+	dups   duplicates
+	rates  clientRates
+}
+
+// CompressionStats returns the name compression counters of the responses.
+func (s *Server) CompressionStats() CompressionStats {
+	return s.compc.stats()
+}
+
+// messageWriter returns the writer of the response to req.
+func (s *Server) messageWriter(req *Query) *messageWriter {
+	w := &messageWriter{
+		msg:      reply(req.Message),
+		counters: &s.compc,
+		stats:    &s.queryc,
+		minimal:  s.MinimalResponses,
+	}
+	if s.DisableCompression {
+		w.compression = compressionOff
+	}
+	return w
+}
+
+// store returns the records of the handler, or a store without records if the
+// handler is not an RRStore.
+func (s *Server) store() RRStore {
+	if rs, ok := s.Handler.(RRStore); ok {
+		return rs
+	}
+	return HandlerFunc(nil)
 }
 
 func (s *Server) Clear() {
-	s.Handler.Clear()
+	s.store().Clear()
 }
 
 func (s *Server) Set(v map[string]map[Type][]Record) {
-	s.Handler.Set(v)
+	s.store().Set(v)
 }
 
 func (s *Server) SetKey(k string, v map[Type][]Record) {
-	s.Handler.SetKey(k, v)
+	s.store().SetKey(k, v)
 }
 
 func (s *Server) Len() int {
-	return s.Handler.Len()
+	return s.store().Len()
 }
 
 func (s *Server) GetKey(k string) (map[Type][]Record, bool) {
-	return s.Handler.GetKey(k)
+	return s.store().GetKey(k)
 }
 
 func (s *Server) DeleteKey(k string) {
-	s.Handler.DeleteKey(k)
+	s.store().DeleteKey(k)
 }
 func (s *Server) DeleteRecordInKey(k string, r Record) {
-	s.Handler.DeleteRecordInKey(k, r)
+	s.store().DeleteRecordInKey(k, r)
 }
 func (s *Server) AppendRecordInKey(k string, r Record) {
-	s.Handler.AppendRecordInKey(k, r)
+	s.store().AppendRecordInKey(k, r)
 }
 func (s *Server) GetAll() map[string]map[Type][]Record {
-	return s.Handler.GetAll()
+	return s.store().GetAll()
+}
+
+func (s *Server) Txn(f func(*RRSetTxn) error) error {
+	return s.store().Txn(f)
+}
+
+func (s *Server) Range(f func(string, Type, Record) bool) {
+	s.store().Range(f)
+}
+
+func (s *Server) AppendEntryInKey(k string, e *RREntry) {
+	s.store().AppendEntryInKey(k, e)
+}
+
+func (s *Server) AppendLeaseInKey(k string, r Record, lease time.Duration) {
+	s.store().AppendLeaseInKey(k, r, lease)
+}
+
+func (s *Server) AppendClassRecordInKey(k string, class Class, r Record) {
+	s.store().AppendClassRecordInKey(k, class, r)
+}
+
+func (s *Server) Stats() RRSetStats {
+	return s.store().Stats()
+}
+
+func (s *Server) DumpText() string {
+	return s.store().DumpText()
+}
+
+func (s *Server) GetEntries(name string, t Type) []*RREntry {
+	return s.store().GetEntries(name, t)
+}
+
+func (s *Server) GetRecords(name string, t Type) []Record {
+	return s.store().GetRecords(name, t)
+}
+
+func (s *Server) Names() []string {
+	return s.store().Names()
+}
+
+func (s *Server) CountByType() map[Type]int {
+	return s.store().CountByType()
+}
+
+func (s *Server) Watch(ctx context.Context) <-chan ChangeEvent {
+	return s.store().Watch(ctx)
 }
 
 func (el *Server) SetBeforeOnClear(v func(map[string]map[Type][]Record)) {
-	el.Handler.SetBeforeOnClear(v)
+	el.store().SetBeforeOnClear(v)
 }
 
 func (el *Server) SetBeforeOnChange(v func(Event, string, interface{}, interface{})) {
-	el.Handler.SetBeforeOnChange(v)
+	el.store().SetBeforeOnChange(v)
 }
 
 func (el *Server) SetBeforeOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetBeforeOnSetKey(v)
+	el.store().SetBeforeOnSetKey(v)
 }
 
 func (el *Server) SetBeforeDeleteKey(v func(string, map[Type][]Record)) {
-	el.Handler.SetBeforeDeleteKey(v)
+	el.store().SetBeforeDeleteKey(v)
 }
 
 func (el *Server) SetBeforeOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetBeforeOnDeleteKeyInRecord(v)
+	el.store().SetBeforeOnDeleteKeyInRecord(v)
 }
 
 func (el *Server) SetBeforeOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetBeforeOnAppendKeyInRecord(v)
+	el.store().SetBeforeOnAppendKeyInRecord(v)
 }
 
 func (el *Server) SetOnClear(v func(map[string]map[Type][]Record)) {
-	el.Handler.SetOnClear(v)
+	el.store().SetOnClear(v)
 }
 
 func (el *Server) SetOnChange(v func(Event, string, interface{}, interface{})) {
-	el.Handler.SetOnChange(v)
+	el.store().SetOnChange(v)
 }
 
 func (el *Server) SetOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetOnSetKey(v)
+	el.store().SetOnSetKey(v)
 }
 
 func (el *Server) SetOnDeleteKey(v func(string, map[Type][]Record)) {
-	el.Handler.SetOnDeleteKey(v)
+	el.store().SetOnDeleteKey(v)
 }
 
 func (el *Server) SetOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetOnDeleteKeyInRecord(v)
+	el.store().SetOnDeleteKeyInRecord(v)
 }
 
 func (el *Server) SetOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	el.Handler.SetOnAppendKeyInRecord(v)
+	el.store().SetOnAppendKeyInRecord(v)
 }
 
 // ListenAndServe listens on both the TCP and UDP network address s.Addr and
 // then calls Serve or ServePacket to handle queries on incoming connections.
-// If srv.Addr is blank, ":domain" is used. ListenAndServe always returns a
-// non-nil error.
+// If srv.Addr is blank, ":domain" is used. Once ctx is done, the listeners are
+// closed and ctx.Err() returned. ListenAndServe always returns a non-nil
+// error.
 func (s *Server) ListenAndServe(ctx context.Context) error {
 	addr := s.Addr
 	if addr == "" {
 		addr = ":domain"
 	}
 
-	ln, err := net.Listen("tcp", addr)
+	ln, err := s.listen(ctx, "tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.ListenPacket("udp", addr)
+	conn, err := s.listenPacket(ctx, "udp", addr)
 	if err != nil {
+		ln.Close()
 		return err
 	}
 
-	errc := make(chan error, 1)
+	s.loadSnapshot(ctx)
+	defer s.saveSnapshot(ctx)
+
+	errc := make(chan error, 2)
 	go func() { errc <- s.Serve(ctx, ln) }()
 	go func() { errc <- s.ServePacket(ctx, conn) }()
 
-	return <-errc
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		ln.Close()
+		conn.Close()
+		return ctx.Err()
+	}
 }
 
 // ListenAndServeTLS listens on the TCP network address s.Addr and then calls
 // Serve to handle requests on incoming TLS connections.
 //
-// If s.Addr is blank, ":853" is used.
+// If s.Addr is blank, ":853" is used. Once ctx is done, the listener is closed
+// and ctx.Err() returned.
 //
 // ListenAndServeTLS always returns a non-nil error.
 func (s *Server) ListenAndServeTLS(ctx context.Context) error {
@@ -147,12 +433,24 @@ func (s *Server) ListenAndServeTLS(ctx context.Context) error {
 		addr = ":domain"
 	}
 
-	ln, err := net.Listen("tcp", addr)
+	ln, err := s.listen(ctx, "tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	return s.ServeTLS(ctx, ln)
+	s.loadSnapshot(ctx)
+	defer s.saveSnapshot(ctx)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.ServeTLS(ctx, ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		ln.Close()
+		return ctx.Err()
+	}
 }
 
 // Serve accepts incoming connections on the Listener ln, creating a new
@@ -170,8 +468,26 @@ func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 		if err != nil {
 			return err
 		}
+		if !s.acquireConn() {
+			conn.Close()
+			continue
+		}
+
+		go func(conn net.Conn) {
+			defer s.releaseConn()
+
+			atomic.AddInt64(&s.queryc.conns, 1)
+			defer atomic.AddInt64(&s.queryc.conns, -1)
 
-		go s.serveStream(ctx, conn)
+			pconn, err := s.proxied(conn)
+			if err != nil {
+				s.log(ctx, slog.LevelWarn, "dns proxy", "remote", conn.RemoteAddr(), "err", err)
+				conn.Close()
+				return
+			}
+
+			s.serveStream(ctx, pconn)
+		}(conn)
 	}
 }
 
@@ -185,37 +501,52 @@ func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
 func (s *Server) ServePacket(ctx context.Context, conn net.PacketConn) error {
 	defer conn.Close()
 
+	// a query is copied out of the read buffer, sized for the largest one
+	rbuf := make([]byte, s.maxUDPSize())
+
 	for {
-		buf := make([]byte, maxPacketLen)
-		n, addr, err := conn.ReadFrom(buf)
+		n, addr, err := conn.ReadFrom(rbuf)
 		if err != nil {
 			return err
 		}
+		buf := append([]byte(nil), rbuf[:n]...)
 
 		req := &Query{
 			Message:    new(Message),
 			RemoteAddr: addr,
+			LocalAddr:  conn.LocalAddr(),
+			Raw:        buf,
 		}
+		s.capture("udp", addr, conn.LocalAddr(), false, req.Raw)
 
-		if buf, err = req.Message.Unpack(buf[:n]); err != nil {
-			s.logf("dns unpack: %s", err.Error())
+		if buf, err = req.Message.Unpack(buf); err != nil {
+			s.log(ctx, slog.LevelWarn, "dns unpack", "remote", addr, "err", err)
 			continue
 		}
 		if len(buf) != 0 {
-			s.logf("dns unpack: malformed packet, extra message bytes")
+			s.log(ctx, slog.LevelWarn, "dns unpack: malformed packet, extra message bytes", "remote", addr)
 			continue
 		}
 
 		pw := &packetWriter{
-			messageWriter: &messageWriter{
-				msg: response(req.Message),
-			},
+			messageWriter: s.messageWriter(req),
 
 			addr: addr,
 			conn: conn,
+			max:  s.udpResponseSize(req.Message),
+			hook: s.responseHook(req, "udp", conn.LocalAddr()),
+		}
+
+		if s.reservedBits(ctx, pw, req) {
+			continue
+		}
+
+		done, dup := s.coalesce(pw, req)
+		if dup {
+			continue
 		}
 
-		go s.handle(ctx, pw, req)
+		s.dispatch(ctx, pw, req, s.udpQueryTimeout(), done)
 	}
 }
 
@@ -228,99 +559,394 @@ func (s *Server) ServePacket(ctx context.Context, conn net.PacketConn) error {
 //
 // ServeTLS always returns a non-nil error.
 func (s *Server) ServeTLS(ctx context.Context, ln net.Listener) error {
-	ln = tls.NewListener(ln, s.TLSConfig.Clone())
 	defer ln.Close()
 
+	cfg := s.tlsConfig()
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			return err
 		}
+		if !s.acquireConn() {
+			conn.Close()
+			continue
+		}
 
 		go func(conn net.Conn) {
+			defer s.releaseConn()
+
+			atomic.AddInt64(&s.queryc.conns, 1)
+			defer atomic.AddInt64(&s.queryc.conns, -1)
+
+			pconn, err := s.proxied(conn)
+			if err != nil {
+				s.log(ctx, slog.LevelWarn, "dns proxy", "remote", conn.RemoteAddr(), "err", err)
+				conn.Close()
+				return
+			}
+			conn = tls.Server(pconn, cfg)
+
+			if s.ReadTimeout > 0 {
+				conn.SetDeadline(time.Now().Add(s.ReadTimeout))
+			}
 			if err := conn.(*tls.Conn).Handshake(); err != nil {
-				s.logf("dns handshake: %s", err.Error())
+				s.log(ctx, slog.LevelWarn, "dns handshake", "remote", conn.RemoteAddr(), "err", err)
+				conn.Close()
 				return
 			}
+			conn.SetDeadline(time.Time{})
 
 			s.serveStream(ctx, conn)
 		}(conn)
 	}
 }
 
+// maxUDPSize returns the largest UDP message of the server, MaxUDPSize if
+// larger than 512 bytes.
+func (s *Server) maxUDPSize() int {
+	if s.MaxUDPSize > maxPacketLen {
+		return s.MaxUDPSize
+	}
+	return maxPacketLen
+}
+
+// udpResponseSize returns the largest UDP response to the query msg: its
+// EDNS payload size, at most MaxUDPSize, see RFC 6891, section 6.2.5.
+func (s *Server) udpResponseSize(msg *Message) int {
+	if n := udpPayloadSize(msg); n < s.maxUDPSize() {
+		return n
+	}
+	return s.maxUDPSize()
+}
+
+// acquireConn reports whether another stream connection may be served.
+func (s *Server) acquireConn() bool {
+	if s.MaxConns <= 0 {
+		return true
+	}
+
+	s.conno.Do(func() { s.conns = make(chan struct{}, s.MaxConns) })
+
+	select {
+	case s.conns <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseConn() {
+	if s.MaxConns > 0 {
+		<-s.conns
+	}
+}
+
+// readDeadline returns the deadline for a read of at most d on a connection
+// opened at born, or the zero time if the read is not bounded.
+func (s *Server) readDeadline(born time.Time, d time.Duration) time.Time {
+	var t time.Time
+	if d > 0 {
+		t = time.Now().Add(d)
+	}
+	if s.MaxConnLifetime > 0 {
+		if end := born.Add(s.MaxConnLifetime); t.IsZero() || end.Before(t) {
+			t = end
+		}
+	}
+	return t
+}
+
+// serveStream serves the queries read from conn, then closes conn once the
+// responses to them are written.
 func (s *Server) serveStream(ctx context.Context, conn net.Conn) {
 	var (
 		rbuf = bufio.NewReader(conn)
+		born = time.Now()
+
+		identity   *ClientIdentity
+		serverName string
 
 		lbuf [2]byte
 		mu   sync.Mutex
+		wg   sync.WaitGroup
 	)
 
+	defer func() {
+		wg.Wait()
+		conn.Close()
+	}()
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		identity = s.clientIdentity(tc)
+		serverName = tc.ConnectionState().ServerName
+	}
+
+	deadlines := s.IdleTimeout > 0 || s.ReadTimeout > 0 || s.MaxConnLifetime > 0
+
 	for {
-		if _, err := rbuf.Read(lbuf[:]); err != nil {
-			if err != io.EOF {
-				s.logf("dns read: %s", err.Error())
+		if deadlines {
+			conn.SetReadDeadline(s.readDeadline(born, s.IdleTimeout))
+		}
+		if _, err := rbuf.Peek(1); err != nil {
+			if ne, ok := err.(net.Error); err != io.EOF && !(ok && ne.Timeout()) {
+				s.log(ctx, slog.LevelWarn, "dns read", "remote", conn.RemoteAddr(), "err", err)
 			}
 			return
 		}
 
+		if deadlines {
+			conn.SetReadDeadline(s.readDeadline(born, s.ReadTimeout))
+		}
+		if _, err := io.ReadFull(rbuf, lbuf[:]); err != nil {
+			s.log(ctx, slog.LevelWarn, "dns read", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+
 		buf := make([]byte, int(nbo.Uint16(lbuf[:])))
 		if _, err := io.ReadFull(rbuf, buf); err != nil {
-			s.logf("dns read: %s", err.Error())
+			s.log(ctx, slog.LevelWarn, "dns read", "remote", conn.RemoteAddr(), "err", err)
 			return
 		}
 
 		req := &Query{
 			Message:    new(Message),
 			RemoteAddr: conn.RemoteAddr(),
+			LocalAddr:  conn.LocalAddr(),
+			Raw:        buf,
+			Identity:   identity,
+			ServerName: serverName,
 		}
+		s.capture("tcp", req.RemoteAddr, conn.LocalAddr(), false, buf)
 
 		var err error
 		if buf, err = req.Message.Unpack(buf); err != nil {
-			s.logf("dns unpack: %s", err.Error())
+			s.log(ctx, slog.LevelWarn, "dns unpack", "remote", req.RemoteAddr, "err", err)
 			continue
 		}
 		if len(buf) != 0 {
-			s.logf("dns unpack: malformed packet, extra message bytes")
+			s.log(ctx, slog.LevelWarn, "dns unpack: malformed packet, extra message bytes", "remote", req.RemoteAddr)
 			continue
 		}
 
 		sw := streamWriter{
-			messageWriter: &messageWriter{
-				msg: response(req.Message),
-			},
+			messageWriter: s.messageWriter(req),
 
-			mu:   &mu,
-			conn: conn,
+			mu:      &mu,
+			conn:    conn,
+			hook:    s.responseHook(req, "tcp", conn.LocalAddr()),
+			timeout: s.WriteTimeout,
+		}
+
+		if s.reservedBits(ctx, sw, req) {
+			continue
 		}
 
-		go s.handle(ctx, sw, req)
+		wg.Add(1)
+		s.dispatch(ctx, sw, req, s.streamQueryTimeout(), wg.Done)
 	}
 }
 
+// dispatch serves r on a new goroutine, or on a worker of s.Workers, with a
+// context done after timeout, if positive. If done is not nil, it is called
+// once r is served or rejected.
+func (s *Server) dispatch(ctx context.Context, w MessageWriter, r *Query, timeout time.Duration, done func()) {
+	if done == nil {
+		done = func() {}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+
+		served := done
+		done = func() {
+			cancel()
+			served()
+		}
+	}
+
+	if s.Workers == nil {
+		go func() {
+			defer done()
+
+			s.handle(ctx, w, r)
+		}()
+		return
+	}
+
+	err := s.Workers.Do(func() {
+		defer done()
+
+		s.handle(ctx, w, r)
+	})
+	if err == nil {
+		return
+	}
+	defer done()
+
+	if err == ErrOverloaded && s.Workers.Policy == OverloadServFail {
+		w.Status(ServFail)
+
+		if err := w.Reply(ctx); err != nil {
+			s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+		}
+	}
+}
+
+func (s *Server) udpQueryTimeout() time.Duration {
+	if s.UDPQueryTimeout == 0 {
+		return defaultUDPQueryTimeout
+	}
+	return s.UDPQueryTimeout
+}
+
+func (s *Server) streamQueryTimeout() time.Duration {
+	if s.StreamQueryTimeout == 0 {
+		return s.IdleTimeout
+	}
+	return s.StreamQueryTimeout
+}
+
+// QueryBudget returns the time left to serve the query of ctx, the context of
+// a Handler, before its deadline, or false if it has none. A handler may skip
+// an upstream query it has no time left for.
+func QueryBudget(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
 func (s *Server) handle(ctx context.Context, w MessageWriter, r *Query) {
+	atomic.AddUint64(&s.queryc.queries, 1)
+
+	guard := &forwardGuard{
+		maxQueries: s.MaxUpstreamQueries,
+		maxCNAME:   s.MaxCNAMEChain,
+	}
+	if lw, ok := w.(interface{ localAddr() net.Addr }); ok {
+		guard.local = lw.localAddr()
+	}
+	ctx = withForwardGuard(ctx, guard)
+
+	transport := writerTransport(w)
+	lp := s.listenerPolicy(transport, r)
+
 	sw := &serverWriter{
 		MessageWriter: w,
-		forwarder:     s.Forwarder,
+		server:        s,
+		forwarder:     s.forwarder(lp),
 		query:         r,
+		guard:         guard,
+		tarpit:        s.Tarpit,
+	}
+
+	if transport == "udp" {
+		sw.rrl = s.ResponseRateLimit
+	}
+
+	classifier := s.Classifier
+	if lp != nil {
+		if lp.Classifier != nil {
+			classifier = lp.Classifier
+		}
+		if lp.Tarpit != nil {
+			sw.tarpit = lp.Tarpit
+		}
+		if lp.ResponseRateLimit != nil && transport == "udp" {
+			sw.rrl = lp.ResponseRateLimit
+		}
+		if pw, ok := w.(interface{ pad(int) }); ok && lp.Padding > 0 {
+			pw.pad(lp.Padding)
+		}
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			s.log(ctx, slog.LevelError, "dns handler panic", "remote", r.RemoteAddr, "panic", v, "stack", string(buf))
+
+			if !sw.replied {
+				sw.Status(ServFail)
+				if err := sw.Reply(ctx); err != nil {
+					s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+				}
+			}
+		}
+	}()
+
+	if lp != nil && lp.RequireCookies && transport == "udp" && s.requireCookie(ctx, sw, r) {
+		return
+	}
+	if classifier != nil && s.classify(ctx, classifier, sw, r) {
+		return
+	}
+	if sw.tarpit != nil && !sw.tarpitted && sw.tarpit.tarpitted(r.RemoteAddr) {
+		atomic.AddUint64(&s.queryc.tarpitted, 1)
+		sw.tarpitted = true
 	}
 
-	s.Handler.ServeDNS(ctx, sw, r)
+	s.handler(r, lp).ServeDNS(ctx, sw, r)
 
 	if !sw.replied {
 		if err := sw.Reply(ctx); err != nil {
-			s.logf("dns: %s", err.Error())
+			s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+		}
+	}
+	s.logTransfer(ctx, r)
+}
+
+// ServeMessage returns the response of s to the query r, served in memory
+// rather than read from a connection, as by the adapters of other DNS servers.
+// The query is served as those of the connections of s are, its recursion
+// forwarded to the Forwarder, except that the response is returned whole,
+// neither truncated nor padded.
+func (s *Server) ServeMessage(ctx context.Context, r *Query) (*Message, error) {
+	w := memoryWriter{s.messageWriter(r)}
+	s.handle(ctx, w, r)
+
+	return w.response()
+}
+
+// logTransfer logs the zone transfers requested by r.
+func (s *Server) logTransfer(ctx context.Context, r *Query) {
+	for _, q := range r.Questions {
+		if q.Type == TypeAXFR || q.Type == TypeIXFR {
+			s.log(ctx, slog.LevelInfo, "dns transfer", "remote", r.RemoteAddr, "zone", q.Name, "type", q.Type)
+		}
+	}
+}
+
+// responseHook returns the function called with the packed responses to r,
+// written over network from local, or nil if there is none.
+func (s *Server) responseHook(r *Query, network string, local net.Addr) func([]byte) {
+	if s.ResponseHook == nil && s.Capture == nil {
+		return nil
+	}
+
+	return func(b []byte) {
+		if s.ResponseHook != nil {
+			s.ResponseHook(r, b)
 		}
+		s.capture(network, local, r.RemoteAddr, true, b)
 	}
 }
 
-func (s *Server) logf(format string, args ...interface{}) {
-	printf := log.Printf
-	if s.ErrorLog != nil {
-		printf = s.ErrorLog.Printf
+func (s *Server) capture(network string, src, dst net.Addr, response bool, b []byte) {
+	if s.Capture == nil {
+		return
 	}
 
-	printf(format, args...)
+	s.Capture(CapturePacket{
+		Time:     time.Now(),
+		Network:  network,
+		Src:      src,
+		Dst:      dst,
+		Response: response,
+		Data:     b,
+	})
 }
 
 type packetWriter struct {
@@ -328,51 +954,75 @@ type packetWriter struct {
 
 	addr net.Addr
 	conn net.PacketConn
+	max  int // largest response, truncated past it
+	hook func([]byte)
 }
 
+func (w packetWriter) localAddr() net.Addr { return w.conn.LocalAddr() }
+
 func (w packetWriter) Recur(ctx context.Context) (*Message, error) {
 	return nil, ErrUnsupportedOp
 }
 
+func (w packetWriter) Forward(ctx context.Context, rt RoundTripper) (*Message, error) {
+	return nil, ErrUnsupportedOp
+}
+
 func (w packetWriter) Reply(ctx context.Context) error {
-	buf, err := w.msg.Pack(nil, true)
+	buf, err := w.pack(nil)
 	if err != nil {
 		return err
 	}
 
-	if len(buf) > maxPacketLen {
+	if len(buf) > w.max {
 		return w.truncate(buf)
 	}
 
-	_, err = w.conn.WriteTo(buf, w.addr)
-	return err
+	if _, err = w.conn.WriteTo(buf, w.addr); err != nil {
+		return err
+	}
+	if w.hook != nil {
+		w.hook(buf)
+	}
+	return nil
 }
 
 func (w packetWriter) truncate(buf []byte) error {
 	var err error
-	if buf, err = truncate(buf, maxPacketLen); err != nil {
+	if buf, err = truncate(buf, w.max); err != nil {
 		return err
 	}
 
 	if _, err := w.conn.WriteTo(buf, w.addr); err != nil {
 		return err
 	}
+	if w.hook != nil {
+		w.hook(buf)
+	}
 	return ErrTruncatedMessage
 }
 
 type streamWriter struct {
 	*messageWriter
 
-	mu   *sync.Mutex
-	conn net.Conn
+	mu      *sync.Mutex
+	conn    net.Conn
+	hook    func([]byte)
+	timeout time.Duration
 }
 
+func (w streamWriter) localAddr() net.Addr { return w.conn.LocalAddr() }
+
 func (w streamWriter) Recur(ctx context.Context) (*Message, error) {
 	return nil, ErrUnsupportedOp
 }
 
+func (w streamWriter) Forward(ctx context.Context, rt RoundTripper) (*Message, error) {
+	return nil, ErrUnsupportedOp
+}
+
 func (w streamWriter) Reply(ctx context.Context) error {
-	buf, err := w.msg.Pack(make([]byte, 2), true)
+	buf, err := w.pack(make([]byte, 2))
 	if err != nil {
 		return err
 	}
@@ -386,20 +1036,40 @@ func (w streamWriter) Reply(ctx context.Context) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	_, err = w.conn.Write(buf)
-	return err
+	if w.timeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+	if _, err = w.conn.Write(buf); err != nil {
+		return err
+	}
+	if w.hook != nil {
+		w.hook(buf[2:])
+	}
+	return nil
 }
 
 type serverWriter struct {
 	MessageWriter
 
+	server    *Server
 	forwarder RoundTripper
 	query     *Query
+	guard     *forwardGuard
+
+	client    string  // whose rates count the response, if classified
+	tarpit    *Tarpit // of the listener of the query
+	tarpitted bool    // the response is delayed by tarpit
+
+	rrl *ResponseRateLimit // of the listener of a UDP query
 
 	replied bool
 }
 
-func (w serverWriter) Recur(ctx context.Context) (*Message, error) {
+func (w *serverWriter) Recur(ctx context.Context) (*Message, error) {
+	return w.Forward(ctx, w.forwarder)
+}
+
+func (w *serverWriter) Forward(ctx context.Context, rt RoundTripper) (*Message, error) {
 	query := &Query{
 		Message:    request(w.query.Message),
 		RemoteAddr: w.query.RemoteAddr,
@@ -413,15 +1083,95 @@ func (w serverWriter) Recur(ctx context.Context) (*Message, error) {
 	}
 	query.Questions = qs
 
-	return w.forward(ctx, query)
+	return w.forward(ctx, rt, query)
+}
+
+// forward sends query to rt, or refuses it if rt is nil, with only the EDNS
+// options of ForwardEDNSOptions.
+func (w *serverWriter) forward(ctx context.Context, rt RoundTripper, query *Query) (*Message, error) {
+	if w.server != nil {
+		codes := w.server.ForwardEDNSOptions
+		if codes == nil {
+			codes = defaultForwardEDNSOptions
+		}
+		query.Additionals = forwardEDNSOptions(query.Additionals, codes)
+	}
+
+	if rt == nil {
+		rt = refuser
+	}
+
+	msg, err := rt.Do(ctx, query)
+	if err != nil && w.server != nil {
+		w.server.log(ctx, slog.LevelWarn, "dns forward", "remote", query.RemoteAddr, "err", err)
+	}
+	return msg, err
 }
 
-func (w serverWriter) Reply(ctx context.Context) error {
+func (w *serverWriter) Reply(ctx context.Context) error {
 	w.replied = true
 
+	if err := w.guard.violation(); err != nil {
+		w.Status(ServFail)
+		if ew, ok := w.MessageWriter.(interface{ extendedError(edns.Option) }); ok {
+			ew.extendedError(extendedError(err))
+		}
+	}
+
+	if rw, ok := w.MessageWriter.(interface{ rcode() RCode }); ok && w.client != "" {
+		w.server.rates.response(w.client, rw.rcode(), time.Now())
+	}
+
+	if rw, ok := w.MessageWriter.(interface{ rcode() RCode }); ok && w.rrl != nil {
+		switch w.rrl.limit(w.query, rw.rcode(), time.Now()) {
+		case QueryDrop:
+			atomic.AddUint64(&w.server.queryc.rateLimited, 1)
+			return nil
+		case QueryTruncate:
+			atomic.AddUint64(&w.server.queryc.rateLimited, 1)
+			if sw, ok := w.MessageWriter.(interface{ slip() }); ok {
+				sw.slip()
+			}
+		}
+	}
+
+	if w.tarpitted {
+		w.server.delayReply(ctx, w.MessageWriter, w.query, w.tarpit)
+		return nil
+	}
 	return w.MessageWriter.Reply(ctx)
 }
 
+func (w *serverWriter) AnswerResource(res Resource)     { writeAnswer(w.MessageWriter, res) }
+func (w *serverWriter) AuthorityResource(res Resource)  { writeAuthority(w.MessageWriter, res) }
+func (w *serverWriter) AdditionalResource(res Resource) { writeAdditional(w.MessageWriter, res) }
+
+func (w *serverWriter) truncated(tc bool) {
+	if tw, ok := w.MessageWriter.(interface{ truncated(bool) }); ok {
+		tw.truncated(tc)
+	}
+}
+
+func (w *serverWriter) Compress(compress bool) {
+	if cw, ok := w.MessageWriter.(CompressionWriter); ok {
+		cw.Compress(compress)
+	}
+}
+
+func (w *serverWriter) Pack() ([]byte, error) {
+	if pw, ok := w.MessageWriter.(packedWriter); ok {
+		return pw.Pack()
+	}
+	return nil, ErrUnsupportedOp
+}
+
+func (w *serverWriter) SetPacked(b []byte) error {
+	if pw, ok := w.MessageWriter.(packedWriter); ok {
+		return pw.SetPacked(b)
+	}
+	return ErrUnsupportedOp
+}
+
 func response(msg *Message) *Message {
 	res := new(Message)
 	*res = *msg // shallow copy
@@ -431,19 +1181,21 @@ func response(msg *Message) *Message {
 	return res
 }
 
+// reply returns the response to be written for the query msg. The AD bit of a
+// query only signals that the client understands it, so it is cleared until
+// set by the handler, and the reserved Z bit is cleared.
+func reply(msg *Message) *Message {
+	res := response(msg)
+	res.AuthenticData, res.Z = false, false
+
+	return res
+}
+
 var refuser = &Client{
 	Transport: nopDialer{},
 	Resolver:  HandlerFunc(Refuse),
 }
 
-func (w serverWriter) forward(ctx context.Context, query *Query) (*Message, error) {
-	if w.forwarder != nil {
-		return w.forwarder.Do(ctx, query)
-	}
-
-	return refuser.Do(ctx, query)
-}
-
 type nopDialer struct{}
 
 func (nopDialer) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
@@ -2,11 +2,15 @@ package dns
 
 import (
 	"context"
+	"io"
 	"net"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/benburkert/dns/edns"
 )
 
 func TestServerListenAndServe(t *testing.T) {
@@ -115,6 +119,67 @@ func TestServerMessageTruncation(t *testing.T) {
 	}
 }
 
+func TestServerMaxUDPSize(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			for i := 1; i < 63; i++ {
+				w.Answer(strings.Repeat("a", i)+".localhost.", time.Minute, &A{A: localhost})
+			}
+		}),
+		MaxUDPSize: 4096,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+
+		payload   int
+		truncated bool
+	}{
+		{name: "without EDNS", truncated: true},
+		{name: "small payload", payload: 1232, truncated: true},
+		{name: "large payload", payload: 4096},
+		{name: "payload past MaxUDPSize", payload: 8192},
+	}
+
+	for _, test := range tests {
+		query := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "test.local.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		}
+		if test.payload > 0 {
+			query.Additionals = []Resource{
+				{Name: ".", Class: Class(test.payload), Record: &OPT{}},
+			}
+		}
+
+		msg, err := new(Client).Do(context.Background(), query)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if want, got := test.truncated, msg.Truncated; want != got {
+			t.Errorf("%s: want truncated %t, got %t", test.name, want, got)
+		}
+		if !msg.Truncated && len(msg.Answers) != 62 {
+			t.Errorf("%s: want %d answers, got %d", test.name, 62, len(msg.Answers))
+		}
+	}
+}
+
 func TestServerForward(t *testing.T) {
 	t.Run("nil forwarder", func(t *testing.T) {
 		t.Parallel()
@@ -183,6 +248,601 @@ func TestServerForward(t *testing.T) {
 			t.Errorf("want A record %q, got %q", want, got)
 		}
 	})
+
+	t.Run("dnssec header bits", func(t *testing.T) {
+		t.Parallel()
+
+		zone := &Zone{
+			Origin: "local.",
+			TTL:    time.Minute,
+		}
+		zone.AppendRecordInKey("zone", &A{A: net.IPv4(127, 0, 0, 1).To4()})
+
+		mux := new(ResolveMux)
+		mux.Handle(TypeANY, "zone.local.", zone)
+		mux.Handle(TypeANY, ".", HandlerFunc(Recursor))
+
+		srv := &Server{
+			Addr:    mustUnusedAddr(),
+			Handler: mux,
+			Forwarder: &Client{
+				Transport: nopDialer{},
+				Resolver: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					if !r.CheckingDisabled {
+						t.Error("want CD bit forwarded upstream")
+					}
+					w.AuthenticData(true)
+					w.Answer("test.local.", time.Minute, &A{A: net.IPv4(127, 0, 0, 2).To4()})
+				}),
+			},
+		}
+		mustStart(srv)
+
+		addrUDP, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name string
+
+			ad bool
+		}{
+			{name: "test.local.", ad: true},
+			{name: "zone.local."},
+		}
+
+		for _, test := range tests {
+			msg, err := new(Client).Do(context.Background(), &Query{
+				RemoteAddr: addrUDP,
+				Message: &Message{
+					RecursionDesired: true,
+					AuthenticData:    true,
+					CheckingDisabled: true,
+					Questions: []Question{
+						{Name: test.name, Type: TypeA, Class: ClassIN},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := test.ad, msg.AuthenticData; want != got {
+				t.Errorf("%s: want AD bit %t, got %t", test.name, want, got)
+			}
+			if !msg.CheckingDisabled {
+				t.Errorf("%s: want CD bit echoed", test.name)
+			}
+		}
+	})
+
+	t.Run("handler selected forwarder", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			internal = net.IPv4(10, 0, 0, 1).To4()
+			external = net.IPv4(192, 0, 2, 1).To4()
+		)
+
+		forwarder := func(ip net.IP) RoundTripper {
+			return &Client{
+				Transport: nopDialer{},
+				Resolver: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					w.Answer(r.Questions[0].Name, time.Minute, &A{A: ip})
+				}),
+			}
+		}
+
+		var (
+			internalForwarder = forwarder(internal)
+			externalForwarder = forwarder(external)
+		)
+
+		srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			rt := externalForwarder
+			if strings.HasSuffix(r.Questions[0].Name, ".internal.") {
+				rt = internalForwarder
+			}
+
+			msg, err := w.Forward(ctx, rt)
+			if err != nil {
+				w.Status(ServFail)
+				return
+			}
+			writeMessage(w, msg)
+		}))
+
+		addrUDP, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, want := range map[string]net.IP{
+			"test.internal.": internal,
+			"test.example.":  external,
+		} {
+			query := &Query{
+				RemoteAddr: addrUDP,
+				Message: &Message{
+					Questions: []Question{
+						{Name: name, Type: TypeA},
+					},
+				},
+			}
+
+			msg, err := new(Client).Do(context.Background(), query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+				t.Errorf("%s: want A record %q, got %q", name, want, got)
+			}
+		}
+	})
+}
+
+func TestServerWireBytes(t *testing.T) {
+	t.Parallel()
+
+	localhost := net.IPv4(127, 0, 0, 1).To4()
+
+	var (
+		queryc    = make(chan []byte, 1)
+		responsec = make(chan []byte, 1)
+	)
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			queryc <- r.Raw
+			w.Answer("test.local.", time.Minute, &A{A: localhost})
+		}),
+		ResponseHook: func(r *Query, b []byte) {
+			responsec <- append([]byte(nil), b...)
+		},
+	}
+	mustStart(srv)
+
+	for _, network := range []string{"udp", "tcp"} {
+		addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var raddr net.Addr = addr
+		if network == "tcp" {
+			raddr = &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+		}
+
+		query := &Query{
+			RemoteAddr: raddr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "test.local.", Type: TypeA},
+				},
+			},
+		}
+
+		if _, err := new(Client).Do(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+
+		var req, res Message
+		if _, err := req.Unpack(<-queryc); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := query.Questions, req.Questions; !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want raw query questions %+v, got %+v", network, want, got)
+		}
+
+		if _, err := res.Unpack(<-responsec); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := req.ID, res.ID; want != got {
+			t.Errorf("%s: want raw response ID %d, got %d", network, want, got)
+		}
+		if want, got := localhost, res.Answers[0].Record.(*A).A; !want.Equal(got) {
+			t.Errorf("%s: want A record %q, got %q", network, want, got)
+		}
+	}
+}
+
+func TestServerSingleReply(t *testing.T) {
+	t.Parallel()
+
+	var (
+		repliedc  = make(chan struct{})
+		responses int32
+	)
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer("test.local.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+			if err := w.Reply(ctx); err != nil {
+				t.Error(err)
+			}
+			close(repliedc)
+		}),
+		ResponseHook: func(r *Query, b []byte) {
+			atomic.AddInt32(&responses, 1)
+		},
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.local.", Type: TypeA},
+			},
+		},
+	}
+
+	if _, err := new(Client).Do(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	<-repliedc
+	time.Sleep(50 * time.Millisecond)
+
+	if want, got := int32(1), atomic.LoadInt32(&responses); want != got {
+		t.Errorf("want %d response sent, got %d", want, got)
+	}
+}
+
+func TestServerResourceWriter(t *testing.T) {
+	t.Parallel()
+
+	version := Resource{
+		Name:   "version.bind.",
+		Class:  ClassCH,
+		Record: &TXT{TXT: []string{"dns"}},
+	}
+	flush := Resource{
+		Name:   "printer.local.",
+		Class:  ClassIN | 0x8000, // cache-flush, RFC 6762 Section 10.2
+		TTL:    2 * time.Minute,
+		Record: &A{A: net.IPv4(192, 0, 2, 7).To4()},
+	}
+
+	mux := new(ResolveMux)
+	mux.HandleFunc(TypeANY, ".", func(ctx context.Context, w MessageWriter, r *Query) {
+		rw, ok := w.(ResourceWriter)
+		if !ok {
+			t.Error("want a ResourceWriter")
+			return
+		}
+		rw.AnswerResource(version)
+		rw.AdditionalResource(flush)
+	})
+
+	srv := mustServer(mux)
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := new(Client).Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "version.bind.", Type: TypeTXT, Class: ClassCH},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := []Resource{version}, msg.Answers; !reflect.DeepEqual(want, got) {
+		t.Errorf("want answers %+v, got %+v", want, got)
+	}
+	if want, got := []Resource{flush}, msg.Additionals; !reflect.DeepEqual(want, got) {
+		t.Errorf("want additionals %+v, got %+v", want, got)
+	}
+}
+
+func TestServerStreamTimeouts(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		srv  *Server
+	}{
+		{"idle", &Server{IdleTimeout: 50 * time.Millisecond}},
+		{"lifetime", &Server{MaxConnLifetime: 50 * time.Millisecond}},
+		{"read", &Server{ReadTimeout: 50 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := test.srv
+			srv.Addr = mustUnusedAddr()
+			srv.Handler = HandlerFunc(func(context.Context, MessageWriter, *Query) {})
+			mustStart(srv)
+
+			conn, err := net.Dial("tcp", srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if test.name == "read" {
+				// a partial length prefix
+				if _, err := conn.Write([]byte{0}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("want connection closed by the server, got %v", err)
+			}
+		})
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr:     mustUnusedAddr(),
+		Handler:  HandlerFunc(func(context.Context, MessageWriter, *Query) {}),
+		MaxConns: 1,
+	}
+	mustStart(srv)
+
+	first, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("want connection past the limit closed, got %v", err)
+	}
+
+	first.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := first.Read(make([]byte, 1)); err == io.EOF {
+		t.Error("want first connection kept open")
+	}
+}
+
+func TestServerSuppressDuplicates(t *testing.T) {
+	t.Parallel()
+
+	var (
+		releasec = make(chan struct{})
+		calls    int32
+	)
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			atomic.AddInt32(&calls, 1)
+			<-releasec
+			w.Answer("test.local.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		SuppressDuplicates: true,
+	}
+	mustStart(srv)
+
+	conn, err := net.Dial("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := &Message{
+		ID: 0x4242,
+		Questions: []Question{
+			{Name: "test.local.", Type: TypeA, Class: ClassIN},
+		},
+	}
+	buf, err := msg.Pack(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); srv.QueryStats().Duplicates == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("want the retransmission coalesced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(releasec)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		b := make([]byte, maxPacketLen)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+
+		res := new(Message)
+		if _, err := res.Unpack(b[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := msg.ID, res.ID; want != got {
+			t.Errorf("response %d: want ID %d, got %d", i, want, got)
+		}
+		if want, got := 1, len(res.Answers); want != got {
+			t.Errorf("response %d: want %d answer, got %d", i, want, got)
+		}
+	}
+
+	if want, got := int32(1), atomic.LoadInt32(&calls); want != got {
+		t.Errorf("want %d handler call, got %d", want, got)
+	}
+	if want, got := uint64(1), srv.QueryStats().Duplicates; want != got {
+		t.Errorf("want %d duplicate, got %d", want, got)
+	}
+
+	// once answered, the query is served again
+	if _, err := conn.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, maxPacketLen)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int32(2), atomic.LoadInt32(&calls); want != got {
+		t.Errorf("want %d handler calls, got %d", want, got)
+	}
+}
+
+func TestServerMinimalResponses(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer("test.local.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+			w.Authority("local.", time.Hour, &NS{NS: "ns.local."})
+			w.Additional("ns.local.", time.Hour, &A{A: net.IPv4(127, 0, 0, 53).To4()})
+		}),
+		MinimalResponses: true,
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := new(Client).Do(context.Background(), &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "test.local.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answer, got %d", want, got)
+	}
+	if len(msg.Authorities) > 0 || len(msg.Additionals) > 0 {
+		t.Errorf("want authority and additional records omitted, got %v and %v", msg.Authorities, msg.Additionals)
+	}
+
+	stats := srv.QueryStats()
+	if want, got := uint64(1), stats.Minimized; want != got {
+		t.Errorf("want %d response minimized, got %d", want, got)
+	}
+	if want, got := uint64(2), stats.Omitted; want != got {
+		t.Errorf("want %d records omitted, got %d", want, got)
+	}
+}
+
+func TestServerQueryTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		network string
+		udp     time.Duration
+		stream  time.Duration
+		budget  time.Duration // 0 if no deadline
+	}{
+		{name: "udp", network: "udp", udp: 100 * time.Millisecond, budget: 100 * time.Millisecond},
+		{name: "udp default", network: "udp", budget: defaultUDPQueryTimeout},
+		{name: "udp none", network: "udp", udp: -1},
+		{name: "tcp", network: "tcp", stream: 100 * time.Millisecond, budget: 100 * time.Millisecond},
+		{name: "tcp none", network: "tcp"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			type served struct {
+				budget time.Duration
+				ok     bool
+				err    error
+			}
+			servedc := make(chan served, 1)
+
+			srv := &Server{
+				Addr: mustUnusedAddr(),
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					budget, ok := QueryBudget(ctx)
+					if ok && budget < time.Second {
+						<-ctx.Done()
+					}
+					servedc <- served{budget, ok, ctx.Err()}
+				}),
+				UDPQueryTimeout:    test.udp,
+				StreamQueryTimeout: test.stream,
+			}
+			mustStart(srv)
+
+			conn, err := net.Dial(test.network, srv.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			msg := &Message{Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}}}
+			buf, err := msg.Pack(nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.network == "tcp" {
+				buf = append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...)
+			}
+			if _, err := conn.Write(buf); err != nil {
+				t.Fatal(err)
+			}
+
+			var s served
+			select {
+			case s = <-servedc:
+			case <-time.After(2 * time.Second):
+				t.Fatal("want the query served")
+			}
+
+			if want, got := test.budget > 0, s.ok; want != got {
+				t.Fatalf("want deadline %t, got %t", want, got)
+			}
+			if !s.ok {
+				return
+			}
+			if s.budget <= 0 || s.budget > test.budget {
+				t.Errorf("want budget up to %v, got %v", test.budget, s.budget)
+			}
+			if test.budget < time.Second && s.err != context.DeadlineExceeded {
+				t.Errorf("want context deadline exceeded, got %v", s.err)
+			}
+		})
+	}
 }
 
 func mustServer(handler Handler) *Server {
@@ -236,3 +896,145 @@ func mustUnusedAddr() string {
 		return lnTCP.Addr().String()
 	}
 }
+
+func TestServerForwardEDNSOptions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ecs     = edns.Option{Code: edns.OptionCodeEDNSClientSubnet, Data: []byte{0, 1, 24, 0, 192, 0, 2}}
+		cookie  = edns.Option{Code: edns.OptionCodeCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+		padding = edns.Option{Code: edns.OptionCodePadding, Data: make([]byte, 16)}
+	)
+
+	tests := []struct {
+		name string
+
+		codes   []edns.OptionCode
+		options []edns.Option
+	}{
+		{
+			name:    "default",
+			options: []edns.Option{ecs},
+		},
+		{
+			name:    "cookie",
+			codes:   []edns.OptionCode{edns.OptionCodeCookie, edns.OptionCodePadding},
+			options: []edns.Option{cookie, padding},
+		},
+		{
+			name:  "none",
+			codes: []edns.OptionCode{},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var forwarded *Query
+			srv := &Server{
+				Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+					if _, err := w.Recur(ctx); err != nil {
+						w.Status(ServFail)
+					}
+				}),
+				Forwarder: RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+					forwarded = query
+					return response(query.Message), nil
+				}),
+				ForwardEDNSOptions: test.codes,
+			}
+
+			opt := &OPT{Options: []edns.Option{ecs, cookie, padding}}
+			_, err := srv.ServeMessage(context.Background(), &Query{
+				Message: &Message{
+					Questions:   []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}},
+					Additionals: []Resource{{Name: ".", Class: 1232, Record: opt}},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 1, len(forwarded.Additionals); want != got {
+				t.Fatalf("want %d OPT record forwarded, got %d", want, got)
+			}
+			if want, got := Class(1232), forwarded.Additionals[0].Class; want != got {
+				t.Errorf("want payload size %d, got %d", want, got)
+			}
+			if want, got := test.options, forwarded.Additionals[0].Record.(*OPT).Options; !reflect.DeepEqual(want, got) {
+				t.Errorf("want options %v, got %v", want, got)
+			}
+			if want, got := 3, len(opt.Options); want != got {
+				t.Errorf("want %d options left in the query, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestServerServeMessage(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "local.",
+		TTL:    time.Minute,
+		SOA:    &SOA{NS: "ns.local.", MBox: "hostmaster.local."},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"test": {TypeA: {&A{A: net.IPv4(127, 0, 0, 1).To4()}}},
+		}),
+	}
+
+	upstream := Question{Name: "example.com.", Type: TypeA, Class: ClassIN}
+	srv := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			if r.Questions[0].Name == upstream.Name {
+				msg, err := w.Recur(ctx)
+				if err != nil {
+					w.Status(ServFail)
+					return
+				}
+				for _, res := range msg.Answers {
+					writeAnswer(w, res)
+				}
+				return
+			}
+			zone.ServeDNS(ctx, w, r)
+		}),
+		Forwarder: &Client{
+			Transport: nopDialer{},
+			Resolver:  &answerHandler{map[Question]Record{upstream: &A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		},
+	}
+
+	tests := []struct {
+		question Question
+		answer   Record
+	}{
+		{Question{Name: "test.local.", Type: TypeA, Class: ClassIN}, &A{A: net.IPv4(127, 0, 0, 1).To4()}},
+		{upstream, &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+	}
+
+	// the second round of queries is answered by the pack cache of the zone
+	for i := 0; i < 2; i++ {
+		for _, test := range tests {
+			msg, err := srv.ServeMessage(context.Background(), &Query{
+				Message: &Message{ID: 0x4242, Questions: []Question{test.question}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := 0x4242, msg.ID; want != got {
+				t.Errorf("%s: want ID %d, got %d", test.question.Name, want, got)
+			}
+			if !msg.Response || len(msg.Answers) != 1 {
+				t.Fatalf("%s: want one answer, got %+v", test.question.Name, msg)
+			}
+			if want, got := test.answer, msg.Answers[0].Record; !reflect.DeepEqual(want, got) {
+				t.Errorf("%s: want answer %v, got %v", test.question.Name, want, got)
+			}
+		}
+	}
+}
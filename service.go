@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// servicesKey is the key of the PTR records enumerating the service types of
+// a domain, see RFC 6763, section 9.
+const servicesKey = "_services._dns-sd._udp"
+
+var (
+	errInvalidInstance = errors.New("invalid service instance name")
+	errInvalidService  = errors.New("invalid service name")
+	errInvalidPort     = errors.New("invalid service port")
+	errNoTargets       = errors.New("no service targets")
+)
+
+// PublishService publishes the instance of the service, such as "printer" of
+// "ipp" over "tcp", as DNS-based service discovery expects, see RFC 6763: a
+// PTR record of the service type, such as _ipp._tcp, to the instance, and
+// the SRV record of each target host and TXT record of the instance. The
+// service type is enumerated by a PTR record of _services._dns-sd._udp too.
+//
+// The service and proto are given with or without their leading underscore.
+// The targets are domain names; a name without a trailing dot is relative to
+// the origin. The txt attributes are written as key=value strings, sorted by
+// key, or as a lone key for an empty value. Publishing an instance again
+// replaces its SRV and TXT records.
+//
+// The records are added in a single transaction, and take the TTL of the
+// zone.
+func (z *Zone) PublishService(instance, service, proto string, port int, txt map[string]string, targets ...string) error {
+	svc, name, err := z.serviceKeys(instance, service, proto)
+	if err != nil {
+		return err
+	}
+	if port < 0 || port > 0xFFFF {
+		return errInvalidPort
+	}
+	if len(targets) == 0 {
+		return errNoTargets
+	}
+
+	rrs := make(map[Type][]Record, 2)
+	for _, target := range targets {
+		if !strings.HasSuffix(target, ".") {
+			target = z.fqdn(target)
+		}
+		rrs[TypeSRV] = append(rrs[TypeSRV], &SRV{Port: port, Target: target})
+	}
+	rrs[TypeTXT] = []Record{&TXT{TXT: serviceTXT(txt)}}
+
+	return z.Txn(func(tx *RRSetTxn) error {
+		tx.SetKey(name, rrs)
+
+		appendUnique(tx, svc, &PTR{PTR: z.fqdn(name)})
+		appendUnique(tx, servicesKey, &PTR{PTR: z.fqdn(svc)})
+		return nil
+	})
+}
+
+// UnpublishService removes the records of the instance of the service
+// published by PublishService. The service type is no longer enumerated once
+// its last instance is removed.
+func (z *Zone) UnpublishService(instance, service, proto string) error {
+	svc, name, err := z.serviceKeys(instance, service, proto)
+	if err != nil {
+		return err
+	}
+
+	return z.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteKey(name)
+
+		tx.DeleteRecordInKey(svc, &PTR{PTR: z.fqdn(name)})
+		if rrs, _ := tx.GetKey(svc); len(rrs[TypePTR]) == 0 {
+			tx.DeleteRecordInKey(servicesKey, &PTR{PTR: z.fqdn(svc)})
+		}
+		return nil
+	})
+}
+
+// serviceKeys returns the keys of the service type and of the instance.
+func (z *Zone) serviceKeys(instance, service, proto string) (string, string, error) {
+	// the instance is a single label, dots left out as the keys are not
+	// escaped
+	if instance == "" || len(instance) > 63 || strings.ContainsRune(instance, '.') {
+		return "", "", errInvalidInstance
+	}
+
+	label := func(s string) string {
+		if s = strings.TrimPrefix(s, "_"); s == "" || len(s) > 62 || strings.ContainsRune(s, '.') {
+			return ""
+		}
+		return "_" + s
+	}
+
+	service, proto = label(service), label(proto)
+	if service == "" || proto == "" {
+		return "", "", errInvalidService
+	}
+
+	// the instance keeps its case in the PTR record, the keys are normalized
+	// by the store
+	svc := NormalizeKey(service + "." + proto)
+	return svc, instance + "." + svc, nil
+}
+
+// serviceTXT returns the strings of the TXT record of the attributes txt. An
+// instance without attributes has a single empty string, see RFC 6763,
+// section 6.1.
+func serviceTXT(txt map[string]string) []string {
+	if len(txt) == 0 {
+		return []string{""}
+	}
+
+	keys := make([]string, 0, len(txt))
+	for k := range txt {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	v := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if txt[k] == "" {
+			v = append(v, k)
+		} else {
+			v = append(v, k+"="+txt[k])
+		}
+	}
+	return v
+}
+
+// appendUnique adds the record to the key, unless the key holds an equal
+// record already.
+func appendUnique(tx *RRSetTxn, k string, r Record) {
+	rrs, _ := tx.GetKey(k)
+	for _, rr := range rrs[r.Type()] {
+		if rr.Equal(r) {
+			return
+		}
+	}
+	tx.AppendRecordInKey(k, r)
+}
@@ -0,0 +1,122 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZonePublishService(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "sd.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.sd.dev.", MBox: "hostmaster.sd.dev."},
+	}
+
+	if err := zone.PublishService("Printer", "ipp", "_tcp", 631, map[string]string{"rp": "ipp/print", "txtvers": "1", "color": ""}, "host", "backup.example.net."); err != nil {
+		t.Fatal(err)
+	}
+	if err := zone.PublishService("Scanner", "_ipp", "tcp", 631, nil, "host"); err != nil {
+		t.Fatal(err)
+	}
+
+	ptrs := func(k string) []string {
+		var v []string
+		for _, rr := range zone.GetRecords(k, TypePTR) {
+			v = append(v, rr.(*PTR).PTR)
+		}
+		return v
+	}
+
+	if want, got := []string{"_ipp._tcp.sd.dev."}, ptrs("_services._dns-sd._udp"); !reflect.DeepEqual(want, got) {
+		t.Errorf("want service types %q, got %q", want, got)
+	}
+	if want, got := []string{"Printer._ipp._tcp.sd.dev.", "Scanner._ipp._tcp.sd.dev."}, ptrs("_ipp._tcp"); !reflect.DeepEqual(want, got) {
+		t.Errorf("want instances %q, got %q", want, got)
+	}
+
+	want := []Record{
+		&SRV{Port: 631, Target: "host.sd.dev."},
+		&SRV{Port: 631, Target: "backup.example.net."},
+	}
+	if got := zone.GetRecords("printer._ipp._tcp", TypeSRV); !reflect.DeepEqual(want, got) {
+		t.Errorf("want SRV records %v, got %v", want, got)
+	}
+
+	want = []Record{&TXT{TXT: []string{"color", "rp=ipp/print", "txtvers=1"}}}
+	if got := zone.GetRecords("printer._ipp._tcp", TypeTXT); !reflect.DeepEqual(want, got) {
+		t.Errorf("want TXT records %v, got %v", want, got)
+	}
+	want = []Record{&TXT{TXT: []string{""}}}
+	if got := zone.GetRecords("scanner._ipp._tcp", TypeTXT); !reflect.DeepEqual(want, got) {
+		t.Errorf("want TXT records %v, got %v", want, got)
+	}
+
+	// publishing again replaces the records of the instance
+	if err := zone.PublishService("Printer", "ipp", "tcp", 8631, nil, "host"); err != nil {
+		t.Fatal(err)
+	}
+	want = []Record{&SRV{Port: 8631, Target: "host.sd.dev."}}
+	if got := zone.GetRecords("printer._ipp._tcp", TypeSRV); !reflect.DeepEqual(want, got) {
+		t.Errorf("want SRV records %v, got %v", want, got)
+	}
+	if want, got := 2, len(ptrs("_ipp._tcp")); want != got {
+		t.Errorf("want %d instances, got %d", want, got)
+	}
+
+	if err := zone.UnpublishService("printer", "ipp", "tcp"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"Scanner._ipp._tcp.sd.dev."}, ptrs("_ipp._tcp"); !reflect.DeepEqual(want, got) {
+		t.Errorf("want instances %q, got %q", want, got)
+	}
+	if _, ok := zone.GetKey("printer._ipp._tcp"); ok {
+		t.Error("want the records of the instance removed")
+	}
+	if want, got := 1, len(ptrs("_services._dns-sd._udp")); want != got {
+		t.Errorf("want %d service types, got %d", want, got)
+	}
+
+	if err := zone.UnpublishService("Scanner", "ipp", "tcp"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, zone.Len(); want != got {
+		t.Errorf("want %d names left, got %d", want, got)
+	}
+}
+
+func TestZonePublishServiceErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		instance, service, proto string
+		port                     int
+		targets                  []string
+		err                      error
+	}{
+		{name: "dotted instance", instance: "a.b", service: "ipp", proto: "tcp", port: 631, targets: []string{"host"}, err: errInvalidInstance},
+		{name: "no service", instance: "a", service: "_", proto: "tcp", port: 631, targets: []string{"host"}, err: errInvalidService},
+		{name: "port", instance: "a", service: "ipp", proto: "tcp", port: 1 << 16, targets: []string{"host"}, err: errInvalidPort},
+		{name: "no targets", instance: "a", service: "ipp", proto: "tcp", port: 631, err: errNoTargets},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			zone := &Zone{Origin: "sd.dev."}
+			if want, got := test.err, zone.PublishService(test.instance, test.service, test.proto, test.port, nil, test.targets...); want != got {
+				t.Errorf("want error %v, got %v", want, got)
+			}
+			if want, got := 0, zone.Len(); want != got {
+				t.Errorf("want %d names, got %d", want, got)
+			}
+		})
+	}
+}
@@ -4,10 +4,81 @@ import (
 	"context"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// defaultMaxOutstanding and defaultOutstandingTimeout bound the queries of the
+// packet sessions of a Client without MaxOutstanding or OutstandingTimeout.
+const (
+	defaultMaxOutstanding     = 256
+	defaultOutstandingTimeout = 5 * time.Second
+)
+
+// SessionStats is a snapshot of the counters of the UDP connections returned
+// by the Dial of a Client.
+type SessionStats struct {
+	Outstanding int64  // queries written and not answered
+	Dropped     uint64 // duplicate responses, and responses to expired queries
+	Rejected    uint64 // queries written past MaxOutstanding
+}
+
+type sessionCounters struct {
+	outstanding int64
+	dropped     uint64
+	rejected    uint64
+}
+
+func (c *sessionCounters) stats() SessionStats {
+	return SessionStats{
+		Outstanding: atomic.LoadInt64(&c.outstanding),
+		Dropped:     atomic.LoadUint64(&c.dropped),
+		Rejected:    atomic.LoadUint64(&c.rejected),
+	}
+}
+
+// SessionStats returns the counters of the UDP connections returned by Dial.
+func (c *Client) SessionStats() SessionStats {
+	return c.sessionc.stats()
+}
+
+func (c *Client) maxOutstanding() int {
+	if c.MaxOutstanding <= 0 {
+		return defaultMaxOutstanding
+	}
+	return c.MaxOutstanding
+}
+
+func (c *Client) outstandingTimeout() time.Duration {
+	if c.OutstandingTimeout <= 0 {
+		return defaultOutstandingTimeout
+	}
+	return c.OutstandingTimeout
+}
+
+// packetSession is a UDP connection returned by Client.Dial. Each query
+// written is sent on a connection of its own, so the responses of the queries
+// in flight are not read by one another, and its response is read back once:
+// a response to a query already answered, or to an expired query, is
+// dropped.
 type packetSession struct {
 	session
+
+	mu          sync.Mutex
+	outstanding map[sessionKey]*outstandingQuery
+}
+
+// sessionKey identifies a query written to a packet session, and its
+// retransmissions.
+type sessionKey struct {
+	addr string
+	id   int
+}
+
+type outstandingQuery struct {
+	pending  int       // queries sent and not answered, retransmissions included
+	deadline time.Time // of the last query written
 }
 
 func (s *packetSession) Read(b []byte) (int, error) {
@@ -47,8 +118,15 @@ func (s *packetSession) Write(b []byte) (int, error) {
 		Message:    msg,
 	}
 
-	go s.do(query)
+	key := sessionKey{addr: s.addr.String(), id: msg.ID}
+	if err := s.track(key); err != nil {
+		return 0, err
+	}
 
+	if err := s.start(func() { s.exchange(query, key) }); err != nil {
+		s.answered(key, err)
+		return 0, err
+	}
 	return len(b), nil
 }
 
@@ -56,6 +134,103 @@ func (s *packetSession) WriteTo(b []byte, addr net.Addr) (int, error) {
 	return s.Write(b)
 }
 
+// track adds the query of key to the outstanding queries, or counts a
+// retransmission of it.
+func (s *packetSession) track(key sessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	deadline := now.Add(s.client.outstandingTimeout())
+
+	if q, ok := s.outstanding[key]; ok {
+		q.pending++
+		q.deadline = deadline
+		return nil
+	}
+
+	if len(s.outstanding) >= s.client.maxOutstanding() {
+		s.expire(now)
+	}
+	if len(s.outstanding) >= s.client.maxOutstanding() {
+		atomic.AddUint64(&s.client.sessionc.rejected, 1)
+		return ErrOverloaded
+	}
+
+	if s.outstanding == nil {
+		s.outstanding = make(map[sessionKey]*outstandingQuery)
+	}
+	s.outstanding[key] = &outstandingQuery{pending: 1, deadline: deadline}
+	atomic.AddInt64(&s.client.sessionc.outstanding, 1)
+	return nil
+}
+
+// expire drops the outstanding queries past their deadline.
+//
+// s.mu held
+func (s *packetSession) expire(now time.Time) {
+	for key, q := range s.outstanding {
+		if now.After(q.deadline) {
+			delete(s.outstanding, key)
+			atomic.AddInt64(&s.client.sessionc.outstanding, -1)
+		}
+	}
+}
+
+// answered reports whether the response to the query of key, or its error, is
+// read back. The first response of an outstanding query is, and the error of
+// its last query pending. Any other response is dropped.
+func (s *packetSession) answered(key sessionKey, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q, ok := s.outstanding[key]
+	if !ok {
+		if err == nil {
+			atomic.AddUint64(&s.client.sessionc.dropped, 1)
+		}
+		return false
+	}
+	if err != nil && q.pending > 1 {
+		q.pending--
+		return false
+	}
+
+	delete(s.outstanding, key)
+	atomic.AddInt64(&s.client.sessionc.outstanding, -1)
+	return true
+}
+
+// exchange sends query on a connection of its own, until the outstanding
+// timeout of the client, and passes the response on to Read unless dropped.
+func (s *packetSession) exchange(query *Query, key sessionKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.outstandingTimeout())
+	defer cancel()
+
+	msg, err := s.roundtrip(ctx, query)
+	if s.answered(key, err) {
+		s.msgerrc <- msgerr{msg, err}
+	}
+}
+
+func (s *packetSession) roundtrip(ctx context.Context, query *Query) (*Message, error) {
+	conn, err := s.client.dial(ctx, s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connection may be nil for a Resolver answering every query
+	if conn != nil {
+		defer conn.Close()
+
+		t, _ := ctx.Deadline()
+		if err := conn.SetDeadline(t); err != nil {
+			return nil, err
+		}
+	}
+	return s.client.do(ctx, conn, query)
+}
+
 type streamSession struct {
 	session
 
@@ -125,8 +300,9 @@ func (s streamSession) Write(b []byte) (int, error) {
 		Message:    msg,
 	}
 
-	go s.do(query)
-
+	if err := s.start(func() { s.do(query) }); err != nil {
+		return 0, err
+	}
 	return len(b), nil
 }
 
@@ -145,6 +321,15 @@ type msgerr struct {
 	err error
 }
 
+// start runs the query on a new goroutine, or on a worker of the client.
+func (s session) start(do func()) error {
+	if s.client.Workers == nil {
+		go do()
+		return nil
+	}
+	return s.client.Workers.Do(do)
+}
+
 func (s session) do(query *Query) {
 	msg, err := s.client.do(context.Background(), s.Conn, query)
 	s.msgerrc <- msgerr{msg, err}
@@ -160,8 +345,9 @@ func (s session) recv() (*Message, error) {
 
 func truncate(buf []byte, maxPacketLength int) ([]byte, error) {
 	msg := new(Message)
-	if _, err := msg.Unpack(buf[:maxPacketLen]); err != nil {
-		if err != errResourceLen && err != errBaseLen {
+	if _, err := msg.Unpack(buf[:maxPacketLength]); err != nil {
+		// the records cut short are dropped
+		if err != errResourceLen && err != errBaseLen && err != errCalcLen {
 			return nil, err
 		}
 	}
@@ -3,7 +3,10 @@ package dns
 import (
 	"context"
 	"net"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 )
 
 func TestPacketSession(t *testing.T) {
@@ -53,13 +56,16 @@ func TestPacketSession(t *testing.T) {
 	}
 
 	buf = make([]byte, 100)
-	if _, err := ps.Read(buf); err != nil {
+	n, err := ps.Read(buf)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if n > len(buf) {
+		t.Errorf("want at most %d bytes read, got %d", len(buf), n)
+	}
 
-	_, err = msg.Unpack(buf)
-	if want, got := errResourceLen, err; want != got {
-		t.Fatalf("want %v error, got %v", want, got)
+	if _, err = msg.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
 	}
 	if want, got := true, msg.Truncated; want != got {
 		t.Errorf("response message was not truncated")
@@ -130,3 +136,81 @@ func TestStreamSession(t *testing.T) {
 		t.Errorf("want %d extra buffer bytes, got %d", want, got)
 	}
 }
+
+func TestPacketSessionOutstanding(t *testing.T) {
+	t.Parallel()
+
+	releasec := make(chan struct{})
+
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		<-releasec
+		w.Answer("app.localhost.", time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	client := &Client{MaxOutstanding: 2}
+
+	conn, err := client.Dial(context.Background(), "udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	write := func(id int) error {
+		msg := &Message{
+			ID: id,
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+		}
+
+		buf, err := msg.Pack(nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write(buf)
+		return err
+	}
+
+	// a retransmission of the first query, then a second query
+	for _, id := range []int{1, 1, 2} {
+		if err := write(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, got := ErrOverloaded, write(3); want != got {
+		t.Errorf("want error %v past the outstanding queries, got %v", want, got)
+	}
+	close(releasec)
+
+	var ids []int
+	for i := 0; i < 2; i++ {
+		buf := make([]byte, maxPacketLen)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msg := new(Message)
+		if _, err := msg.Unpack(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	sort.Ints(ids)
+
+	if want, got := []int{1, 2}, ids; !reflect.DeepEqual(want, got) {
+		t.Errorf("want responses %v, got %v", want, got)
+	}
+
+	for deadline := time.Now().Add(time.Second); client.SessionStats().Dropped == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("want the duplicate response dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := SessionStats{Dropped: 1, Rejected: 1}
+	if got := client.SessionStats(); want != got {
+		t.Errorf("want session stats %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,88 @@
+package dns
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// alpnDoT is the ALPN protocol of DNS over TLS, see RFC 7858 and the TLS
+// Application-Layer Protocol Negotiation Protocol IDs registry.
+const alpnDoT = "dot"
+
+// SNIRoute is the certificate and the handler of the TLS connections for a
+// server name, see Server.SNIRoutes.
+type SNIRoute struct {
+	// Certificate, if not nil, is presented to the clients requesting the
+	// server name. If nil, the certificates of Server.TLSConfig are.
+	Certificate *tls.Certificate
+
+	// GetCertificate, if not nil, returns the certificate presented to the
+	// clients requesting the server name in place of Certificate, such as
+	// the GetCertificate of a CertReloader.
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// Handler, if not nil, serves the queries of the connections for the
+	// server name in place of Server.Handler.
+	Handler Handler
+}
+
+// sniRoute returns the route of the server name, matched exactly or by a
+// wildcard such as "*.example.com" for a single leading label.
+func (s *Server) sniRoute(name string) (SNIRoute, bool) {
+	if len(s.SNIRoutes) == 0 || name == "" {
+		return SNIRoute{}, false
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if route, ok := s.SNIRoutes[name]; ok {
+		return route, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if route, ok := s.SNIRoutes["*"+name[i:]]; ok {
+			return route, true
+		}
+	}
+	return SNIRoute{}, false
+}
+
+// handler returns the handler of r, by the server name of its connection, or
+// by the listener policy p, if not nil.
+func (s *Server) handler(r *Query, p *ListenerPolicy) Handler {
+	if route, ok := s.sniRoute(r.ServerName); ok && route.Handler != nil {
+		return route.Handler
+	}
+	if p != nil && p.Handler != nil {
+		return p.Handler
+	}
+	return s.Handler
+}
+
+// getCertificate returns the certificate of the route of the requested server
+// name, or the certificate returned by get, if not nil.
+func (s *Server) getCertificate(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if route, ok := s.sniRoute(hello.ServerName); ok {
+			if route.GetCertificate != nil {
+				return route.GetCertificate(hello)
+			}
+			if route.Certificate != nil {
+				return route.Certificate, nil
+			}
+		}
+		if get != nil {
+			return get(hello)
+		}
+		// nil makes crypto/tls pick one of the Certificates of the config
+		return nil, nil
+	}
+}
+
+// withALPN returns protos with the ALPN protocol of DNS over TLS.
+func withALPN(protos []string) []string {
+	for _, p := range protos {
+		if p == alpnDoT {
+			return protos
+		}
+	}
+	return append(protos[:len(protos):len(protos)], alpnDoT)
+}
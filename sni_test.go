@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/benburkert/dns/internal/must"
+)
+
+func TestServeTLSSNIRoutes(t *testing.T) {
+	t.Parallel()
+
+	ca := must.CACert("ca.dev", nil)
+
+	answer := func(ip net.IP) Handler {
+		return HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: ip.To4()})
+		})
+	}
+
+	srv := &Server{
+		Handler: answer(net.IPv4(127, 0, 0, 1)),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*must.LeafCert("dns.dev", ca).TLS()},
+		},
+		SNIRoutes: map[string]SNIRoute{
+			"a.tenant.dev": {
+				Certificate: must.LeafCert("a.tenant.dev", ca).TLS(),
+				Handler:     answer(net.IPv4(10, 0, 0, 1)),
+			},
+			"*.wild.dev": {
+				Certificate: must.LeafCert("x.wild.dev", ca).TLS(),
+				Handler:     answer(net.IPv4(10, 0, 0, 2)),
+			},
+			"cert.dev": {
+				Certificate: must.LeafCert("cert.dev", ca).TLS(),
+			},
+		},
+	}
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(context.Background(), ln)
+
+	tests := []struct {
+		serverName string
+		protos     []string
+
+		answer net.IP
+		err    bool
+	}{
+		{serverName: "dns.dev", answer: net.IPv4(127, 0, 0, 1)},
+		{serverName: "A.Tenant.dev", answer: net.IPv4(10, 0, 0, 1)},
+		{serverName: "x.wild.dev", answer: net.IPv4(10, 0, 0, 2)},
+		{serverName: "cert.dev", answer: net.IPv4(127, 0, 0, 1)},
+		{serverName: "dns.dev", protos: []string{"dot", "h2"}, answer: net.IPv4(127, 0, 0, 1)},
+		{serverName: "dns.dev", protos: []string{"h2"}, err: true},
+	}
+
+	for _, test := range tests {
+		var state tls.ConnectionState
+
+		client := &Client{
+			Transport: &Transport{
+				TLSConfig: &tls.Config{
+					ServerName: test.serverName,
+					RootCAs:    must.CertPool(ca.TLS()),
+					NextProtos: test.protos,
+					VerifyConnection: func(cs tls.ConnectionState) error {
+						state = cs
+						return nil
+					},
+				},
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, err := client.Do(ctx, &Query{
+			RemoteAddr: OverTLSAddr{ln.Addr()},
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.dev.", Type: TypeA, Class: ClassIN},
+				},
+			},
+		})
+		cancel()
+
+		if test.err {
+			if err == nil {
+				t.Errorf("%s %q: want the handshake to fail", test.serverName, test.protos)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.serverName, err)
+		}
+
+		if want, got := "dot", state.NegotiatedProtocol; want != got {
+			t.Errorf("%s: want ALPN protocol %q, got %q", test.serverName, want, got)
+		}
+		if want, got := test.answer, msg.Answers[0].Record.(*A).A; !want.Equal(got) {
+			t.Errorf("%s: want answer %s, got %s", test.serverName, want, got)
+		}
+	}
+}
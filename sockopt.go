@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var errUnsupportedSockopt = errors.New("unsupported socket option")
+
+// SocketOptions are options applied to the sockets of a Server or Transport
+// before they are bound or connected.
+//
+// FreeBind, Transparent, V6Only and TOS are only supported on Linux. On other
+// platforms, setting them makes listening and dialing fail; Control may be
+// used instead.
+type SocketOptions struct {
+	// FreeBind sets IP_FREEBIND, allowing a listener to bind an address that
+	// is not yet configured on the host, such as an anycast address.
+	FreeBind bool
+
+	// Transparent sets IP_TRANSPARENT, or IPV6_TRANSPARENT, allowing sockets
+	// to bind and send from addresses not local to the host.
+	Transparent bool
+
+	// V6Only sets IPV6_V6ONLY on IPv6 sockets, so that they do not accept
+	// IPv4-mapped traffic.
+	V6Only bool
+
+	// TOS, if not zero, sets IP_TOS, or IPV6_TCLASS, to mark traffic. The
+	// DSCP is the upper six bits, for example 46<<2 for Expedited Forwarding.
+	TOS int
+
+	// Control, if not nil, is called after the options above are applied,
+	// with the same arguments as the Control func of a net.Dialer. It may set
+	// other options with c.Control and syscall.SetsockoptInt.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+func (o *SocketOptions) control(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) { err = o.set(network, fd) }); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.Control != nil {
+		return o.Control(network, address, c)
+	}
+	return nil
+}
+
+func isIPv6Network(network string) bool {
+	return strings.HasSuffix(network, "6")
+}
+
+// listenConfig returns the ListenConfig of sockets with the options o.
+func (o *SocketOptions) listenConfig() *net.ListenConfig {
+	if o == nil {
+		return new(net.ListenConfig)
+	}
+	return &net.ListenConfig{Control: o.control}
+}
+
+func (s *Server) listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	return s.SocketOptions.listenConfig().Listen(ctx, network, addr)
+}
+
+func (s *Server) listenPacket(ctx context.Context, network, addr string) (net.PacketConn, error) {
+	return s.SocketOptions.listenConfig().ListenPacket(ctx, network, addr)
+}
+
+// dialer returns the dialer of the transport sockets.
+func (t *Transport) dialer() *net.Dialer {
+	if t.SocketOptions == nil {
+		return defaultDialer
+	}
+	return &net.Dialer{
+		Resolver: defaultDialer.Resolver,
+		Control:  t.SocketOptions.control,
+	}
+}
@@ -0,0 +1,40 @@
+package dns
+
+import "syscall"
+
+// ipv6Transparent is IPV6_TRANSPARENT, missing from the syscall package.
+const ipv6Transparent = 0x4b
+
+func (o *SocketOptions) set(network string, fd uintptr) error {
+	ipv6 := isIPv6Network(network)
+
+	if o.FreeBind {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_FREEBIND, 1); err != nil {
+			return err
+		}
+	}
+	if o.Transparent {
+		level, opt := syscall.IPPROTO_IP, syscall.IP_TRANSPARENT
+		if ipv6 {
+			level, opt = syscall.IPPROTO_IPV6, ipv6Transparent
+		}
+		if err := syscall.SetsockoptInt(int(fd), level, opt, 1); err != nil {
+			return err
+		}
+	}
+	if o.V6Only && ipv6 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1); err != nil {
+			return err
+		}
+	}
+	if o.TOS != 0 {
+		level, opt := syscall.IPPROTO_IP, syscall.IP_TOS
+		if ipv6 {
+			level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+		}
+		if err := syscall.SetsockoptInt(int(fd), level, opt, o.TOS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
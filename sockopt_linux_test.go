@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSocketOptions(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		toss = make(map[string]int)
+	)
+
+	opts := &SocketOptions{
+		TOS: 46 << 2,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var (
+				tos int
+				err error
+			)
+			c.Control(func(fd uintptr) {
+				tos, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			toss[network] = tos
+			return err
+		},
+	}
+
+	srv := &Server{
+		Addr:          "127.0.0.1:" + portOf(t, mustUnusedAddr()),
+		Handler:       &answerHandler{answers},
+		SocketOptions: opts,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := srv.listen(ctx, "tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := srv.listenPacket(ctx, "udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.Serve(ctx, ln)
+	go srv.ServePacket(ctx, conn)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Transport: &Transport{SocketOptions: opts},
+	}
+
+	qctx, qcancel := context.WithTimeout(ctx, time.Second)
+	defer qcancel()
+
+	msg, err := client.Do(qctx, &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{questions["A"]},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(msg.Answers); want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, network := range []string{"tcp4", "udp4"} {
+		if want, got := opts.TOS, toss[network]; want != got {
+			t.Errorf("want %s TOS %#x, got %#x", network, want, got)
+		}
+	}
+}
+
+func TestSocketOptionsFreeBind(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		SocketOptions: &SocketOptions{FreeBind: true},
+	}
+
+	// 192.0.2.0/24 is reserved for documentation, and not configured on the
+	// host.
+	conn, err := srv.listenPacket(context.Background(), "udp4", "192.0.2.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func portOf(t *testing.T, addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package dns
+
+func (o *SocketOptions) set(network string, fd uintptr) error {
+	if o.FreeBind || o.Transparent || o.V6Only || o.TOS != 0 {
+		return errUnsupportedSockopt
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net"
+	"syscall"
+	"time"
+)
+
+var errPortRange = errors.New("invalid source port range")
+
+// sourcePortAttempts is the number of random ports tried before giving up on
+// a busy range.
+const sourcePortAttempts = 16
+
+// PortRange is an inclusive range of network ports.
+type PortRange struct {
+	Min, Max int
+}
+
+func (r PortRange) valid() bool {
+	return 0 < r.Min && r.Min <= r.Max && r.Max <= 65535
+}
+
+// random returns a port drawn uniformly from the range.
+func (r PortRange) random() (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(r.Max-r.Min+1)))
+	if err != nil {
+		return 0, err
+	}
+	return r.Min + int(n.Int64()), nil
+}
+
+// dialSourcePort dials a UDP socket bound to a random port of SourcePorts.
+func (t *Transport) dialSourcePort(ctx context.Context, network, address string) (net.Conn, error) {
+	if !t.SourcePorts.valid() {
+		return nil, errPortRange
+	}
+
+	var err error
+	for i := 0; i < sourcePortAttempts; i++ {
+		var port int
+		if port, err = t.SourcePorts.random(); err != nil {
+			return nil, err
+		}
+
+		d := *t.dialer()
+		d.LocalAddr = &net.UDPAddr{Port: port}
+
+		var conn net.Conn
+		if conn, err = d.DialContext(ctx, network, address); err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// freshPacketConn is a PacketConn that sends each message from a new socket,
+// receiving from the socket of the last message sent.
+type freshPacketConn struct {
+	*PacketConn
+
+	dial func() (net.Conn, error)
+	sent bool
+
+	rdeadline, wdeadline time.Time
+}
+
+func (c *freshPacketConn) Send(msg *Message) error {
+	if c.sent {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(c.rdeadline); err != nil {
+			conn.Close()
+			return err
+		}
+		if err := conn.SetWriteDeadline(c.wdeadline); err != nil {
+			conn.Close()
+			return err
+		}
+
+		c.PacketConn.Conn.Close()
+		c.PacketConn.Conn = conn
+	}
+	c.sent = true
+
+	return c.PacketConn.Send(msg)
+}
+
+func (c *freshPacketConn) SetDeadline(t time.Time) error {
+	c.rdeadline, c.wdeadline = t, t
+	return c.PacketConn.SetDeadline(t)
+}
+
+func (c *freshPacketConn) SetReadDeadline(t time.Time) error {
+	c.rdeadline = t
+	return c.PacketConn.SetReadDeadline(t)
+}
+
+func (c *freshPacketConn) SetWriteDeadline(t time.Time) error {
+	c.wdeadline = t
+	return c.PacketConn.SetWriteDeadline(t)
+}
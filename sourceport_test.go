@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransportSourcePorts(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srcs := make(chan *net.UDPAddr, 8)
+	go func() {
+		buf := make([]byte, maxPacketLen)
+		for {
+			n, addr, err := ln.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			srcs <- addr.(*net.UDPAddr)
+
+			var msg Message
+			if _, err := msg.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			b, err := response(&msg).Pack(nil, true)
+			if err != nil {
+				continue
+			}
+			ln.WriteTo(b, addr)
+		}
+	}()
+
+	ports := PortRange{Min: 20000, Max: 59999}
+	tport := &Transport{
+		SocketPerQuery: true,
+		SourcePorts:    ports,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	conn, err := tport.DialAddr(ctx, ln.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int]bool)
+	for i := 0; i < 4; i++ {
+		msg := &Message{
+			ID:        i,
+			Questions: []Question{questions["A"]},
+		}
+		if err := conn.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.Recv(msg); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := i, msg.ID; want != got {
+			t.Errorf("want response ID %d, got %d", want, got)
+		}
+
+		src := <-srcs
+		if src.Port < ports.Min || src.Port > ports.Max {
+			t.Errorf("want source port in %d-%d, got %d", ports.Min, ports.Max, src.Port)
+		}
+		seen[src.Port] = true
+	}
+
+	if len(seen) < 2 {
+		t.Errorf("want queries sent from distinct sockets, got ports %v", seen)
+	}
+
+	_, err = (&Transport{SourcePorts: PortRange{Min: 2, Max: 1}}).DialAddr(ctx, ln.LocalAddr())
+	if want, got := errPortRange, err; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,295 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StampProtocol is the protocol of a DNS stamp.
+type StampProtocol uint8
+
+// Stamp protocols, see https://dnscrypt.info/stamps-specifications.
+const (
+	StampPlain    StampProtocol = 0x00
+	StampDNSCrypt StampProtocol = 0x01
+	StampDoH      StampProtocol = 0x02
+	StampDoT      StampProtocol = 0x03
+	StampDoQ      StampProtocol = 0x04
+	StampODoH     StampProtocol = 0x05
+)
+
+// StampProps are the informal properties of a server announced in its stamp.
+type StampProps uint64
+
+// Stamp properties.
+const (
+	StampDNSSEC   StampProps = 1 << 0 // the server validates DNSSEC
+	StampNoLog    StampProps = 1 << 1 // the server keeps no logs
+	StampNoFilter StampProps = 1 << 2 // the server does not filter answers
+)
+
+var errInvalidStamp = errors.New("invalid DNS stamp")
+
+// Stamp is a parsed DNS stamp (sdns://) describing how to reach a server.
+type Stamp struct {
+	Protocol StampProtocol
+	Props    StampProps
+
+	// Addr is the IP address of the server, with an optional port. It may be
+	// empty for DoH, DoT and DoQ servers reached through Hostname.
+	Addr string
+
+	// PublicKey is the provider public key of a DNSCrypt server.
+	PublicKey []byte
+
+	// ProviderName is the provider name of a DNSCrypt server.
+	ProviderName string
+
+	// Hashes are the SHA256 digests of the TBS certificates of the chain of a
+	// DoH, DoT or DoQ server. Any one of them must match.
+	Hashes [][]byte
+
+	// Hostname is the server name of a DoH, DoT, DoQ or ODoH server, used for
+	// the TLS server name and the HTTP host.
+	Hostname string
+
+	// Path is the HTTP path of a DoH or ODoH server.
+	Path string
+
+	// Bootstrap lists the IP addresses of resolvers used to resolve Hostname.
+	Bootstrap []string
+}
+
+// ParseStamp parses a DNS stamp, such as "sdns://AAcAAAAAAAAABzEuMC4wLjE".
+func ParseStamp(s string) (*Stamp, error) {
+	if !strings.HasPrefix(s, "sdns://") {
+		return nil, errInvalidStamp
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s[len("sdns://"):], "="))
+	if err != nil {
+		return nil, errInvalidStamp
+	}
+	if len(b) < 1 {
+		return nil, errInvalidStamp
+	}
+
+	st := &Stamp{Protocol: StampProtocol(b[0])}
+	r := stampReader{b: b[1:]}
+
+	if st.Protocol > StampODoH {
+		return nil, errInvalidStamp
+	}
+	st.Props = StampProps(r.uint64())
+
+	switch st.Protocol {
+	case StampPlain:
+		st.Addr = r.lp()
+	case StampDNSCrypt:
+		st.Addr = r.lp()
+		st.PublicKey = []byte(r.lp())
+		st.ProviderName = r.lp()
+	case StampDoH:
+		st.Addr = r.lp()
+		st.Hashes = r.vlp()
+		st.Hostname = r.lp()
+		st.Path = r.lp()
+		if !r.done() {
+			st.Bootstrap = r.vlpStrings()
+		}
+	case StampDoT, StampDoQ:
+		st.Addr = r.lp()
+		st.Hashes = r.vlp()
+		st.Hostname = r.lp()
+		if !r.done() {
+			st.Bootstrap = r.vlpStrings()
+		}
+	case StampODoH:
+		st.Hostname = r.lp()
+		st.Path = r.lp()
+	}
+
+	if r.err || !r.done() {
+		return nil, errInvalidStamp
+	}
+	return st, nil
+}
+
+// String returns the sdns:// form of the stamp.
+func (st *Stamp) String() string {
+	var w stampWriter
+	w.b = append(w.b, byte(st.Protocol))
+
+	var props [8]byte
+	binary.LittleEndian.PutUint64(props[:], uint64(st.Props))
+	w.b = append(w.b, props[:]...)
+
+	switch st.Protocol {
+	case StampPlain:
+		w.lp(st.Addr)
+	case StampDNSCrypt:
+		w.lp(st.Addr)
+		w.lp(string(st.PublicKey))
+		w.lp(st.ProviderName)
+	case StampDoH:
+		w.lp(st.Addr)
+		w.vlp(st.Hashes)
+		w.lp(st.Hostname)
+		w.lp(st.Path)
+		if len(st.Bootstrap) > 0 {
+			w.vlpStrings(st.Bootstrap)
+		}
+	case StampDoT, StampDoQ:
+		w.lp(st.Addr)
+		w.vlp(st.Hashes)
+		w.lp(st.Hostname)
+		if len(st.Bootstrap) > 0 {
+			w.vlpStrings(st.Bootstrap)
+		}
+	case StampODoH:
+		w.lp(st.Hostname)
+		w.lp(st.Path)
+	}
+
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(w.b)
+}
+
+// NameServers returns the addresses of the server for use with a Transport.
+// A plain stamp yields a UDP and a TCP address, and a DoT stamp an
+// OverTLSAddr. The Transport does not implement the other protocols, for
+// which ErrUnsupportedNetwork is returned.
+func (st *Stamp) NameServers() (NameServers, error) {
+	switch st.Protocol {
+	case StampPlain:
+		ip, port, err := st.hostPort(53)
+		if err != nil {
+			return nil, err
+		}
+		return NameServers{
+			&net.UDPAddr{IP: ip, Port: port},
+			&net.TCPAddr{IP: ip, Port: port},
+		}, nil
+	case StampDoT:
+		ip, port, err := st.hostPort(853)
+		if err != nil {
+			return nil, err
+		}
+		return NameServers{
+			OverTLSAddr{&net.TCPAddr{IP: ip, Port: port}},
+		}, nil
+	}
+	return nil, ErrUnsupportedNetwork
+}
+
+func (st *Stamp) hostPort(defaultPort int) (net.IP, int, error) {
+	host, port := st.Addr, defaultPort
+	if h, p, err := net.SplitHostPort(st.Addr); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, 0, errInvalidStamp
+		}
+		host, port = h, int(n)
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return nil, 0, errInvalidStamp
+	}
+	return ip, port, nil
+}
+
+type stampReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *stampReader) done() bool { return len(r.b) == 0 }
+
+func (r *stampReader) next(n int) []byte {
+	if r.err || len(r.b) < n {
+		r.err = true
+		return nil
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *stampReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *stampReader) lp() string {
+	n := r.next(1)
+	if n == nil {
+		return ""
+	}
+	return string(r.next(int(n[0])))
+}
+
+func (r *stampReader) vlp() [][]byte {
+	var v [][]byte
+	for {
+		n := r.next(1)
+		if n == nil {
+			return nil
+		}
+
+		if b := r.next(int(n[0] &^ 0x80)); len(b) > 0 {
+			v = append(v, append([]byte(nil), b...))
+		}
+		if n[0]&0x80 == 0 {
+			return v
+		}
+	}
+}
+
+func (r *stampReader) vlpStrings() []string {
+	var v []string
+	for _, b := range r.vlp() {
+		v = append(v, string(b))
+	}
+	return v
+}
+
+type stampWriter struct {
+	b []byte
+}
+
+func (w *stampWriter) lp(s string) {
+	w.b = append(w.b, byte(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *stampWriter) vlp(v [][]byte) {
+	if len(v) == 0 {
+		w.b = append(w.b, 0)
+		return
+	}
+
+	for i, b := range v {
+		n := byte(len(b))
+		if i < len(v)-1 {
+			n |= 0x80
+		}
+		w.b = append(w.b, n)
+		w.b = append(w.b, b...)
+	}
+}
+
+func (w *stampWriter) vlpStrings(v []string) {
+	b := make([][]byte, 0, len(v))
+	for _, s := range v {
+		b = append(b, []byte(s))
+	}
+	w.vlp(b)
+}
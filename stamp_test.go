@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseStamp(t *testing.T) {
+	t.Parallel()
+
+	st, err := ParseStamp("sdns://AAcAAAAAAAAABzEuMC4wLjE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Stamp{
+		Protocol: StampPlain,
+		Props:    StampDNSSEC | StampNoLog | StampNoFilter,
+		Addr:     "1.0.0.1",
+	}
+	if !reflect.DeepEqual(want, st) {
+		t.Errorf("want stamp %+v, got %+v", want, st)
+	}
+
+	ns, err := st.NameServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := (NameServers{
+		&net.UDPAddr{IP: net.ParseIP("1.0.0.1"), Port: 53},
+		&net.TCPAddr{IP: net.ParseIP("1.0.0.1"), Port: 53},
+	}), ns; !reflect.DeepEqual(want, got) {
+		t.Errorf("want nameservers %v, got %v", want, got)
+	}
+}
+
+func TestStampRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []*Stamp{
+		{Protocol: StampPlain, Addr: "[2606:4700::1111]:5353"},
+		{Protocol: StampDNSCrypt, Props: StampDNSSEC, Addr: "192.0.2.1:8443", PublicKey: []byte{1, 2, 3}, ProviderName: "2.dnscrypt-cert.example"},
+		{Protocol: StampDoH, Addr: "192.0.2.1", Hashes: [][]byte{{1}, {2, 3}}, Hostname: "doh.example", Path: "/dns-query", Bootstrap: []string{"9.9.9.9"}},
+		{Protocol: StampDoT, Props: StampNoLog, Addr: "192.0.2.1", Hashes: [][]byte{{4, 5}}, Hostname: "dot.example"},
+		{Protocol: StampODoH, Hostname: "odoh.example", Path: "/dns-query"},
+	}
+
+	for _, want := range tests {
+		got, err := ParseStamp(want.String())
+		if err != nil {
+			t.Errorf("%s: %v", want, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("want stamp %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestStampNameServers(t *testing.T) {
+	t.Parallel()
+
+	dot := &Stamp{Protocol: StampDoT, Addr: "192.0.2.1", Hostname: "dot.example"}
+	ns, err := dot.NameServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := (NameServers{OverTLSAddr{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 853}}}), ns; !reflect.DeepEqual(want, got) {
+		t.Errorf("want nameservers %v, got %v", want, got)
+	}
+
+	doh := &Stamp{Protocol: StampDoH, Hostname: "doh.example", Path: "/dns-query"}
+	if _, err := doh.NameServers(); err != ErrUnsupportedNetwork {
+		t.Errorf("want error %v, got %v", ErrUnsupportedNetwork, err)
+	}
+
+	for _, s := range []string{"https://example", "sdns://", "sdns://AAcAAAAAAAAABzEuMC4w"} {
+		if _, err := ParseStamp(s); err != errInvalidStamp {
+			t.Errorf("%s: want error %v, got %v", s, errInvalidStamp, err)
+		}
+	}
+}
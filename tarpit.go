@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTarpitDelay   = time.Second
+	defaultTarpitPending = 10000
+)
+
+// Tarpit delays the responses to the queries of some clients, those of its
+// Networks and those tarpitted by the Classifier of the server, to slow the
+// scanners down rather than block them. A response delayed is held packed on
+// a timer, and no goroutine waits for it; neither does the worker of the
+// query, if any, nor its connection.
+//
+// The responses of ServeMessage are returned at once.
+type Tarpit struct {
+	// Delay is the time the responses are delayed by. If zero, a second.
+	Delay time.Duration
+
+	// Networks are the networks of the clients whose responses are all
+	// delayed.
+	Networks []*net.IPNet
+
+	// MaxPending is the number of responses delayed at most. The responses
+	// past it are dropped, and counted by QueryStats. If zero, 10000.
+	MaxPending int
+}
+
+// tarpitted reports whether the responses to addr are delayed.
+func (t *Tarpit) tarpitted(addr net.Addr) bool {
+	ip := addrIP(addr)
+	return ip != nil && inNetworks(t.Networks, ip)
+}
+
+func (t *Tarpit) delay() time.Duration {
+	if t != nil && t.Delay > 0 {
+		return t.Delay
+	}
+	return defaultTarpitDelay
+}
+
+func (t *Tarpit) maxPending() int64 {
+	if t != nil && t.MaxPending > 0 {
+		return int64(t.MaxPending)
+	}
+	return defaultTarpitPending
+}
+
+// delayReply replies with w to the query r once the delay of the tarpit t
+// elapses, or drops the response if too many are delayed.
+func (s *Server) delayReply(ctx context.Context, w MessageWriter, r *Query, t *Tarpit) {
+	if atomic.AddInt64(&s.queryc.delayed, 1) > t.maxPending() {
+		atomic.AddInt64(&s.queryc.delayed, -1)
+		atomic.AddUint64(&s.queryc.dropped, 1)
+		return
+	}
+
+	// the query is served, and its context done, by the time of the reply
+	ctx = context.WithoutCancel(ctx)
+
+	time.AfterFunc(t.delay(), func() {
+		defer atomic.AddInt64(&s.queryc.delayed, -1)
+
+		if err := w.Reply(ctx); err != nil {
+			s.log(ctx, slog.LevelError, "dns reply", "remote", r.RemoteAddr, "err", err)
+		}
+	})
+}
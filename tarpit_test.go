@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerTarpit(t *testing.T) {
+	t.Parallel()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, loopback6, _ := net.ParseCIDR("::1/128")
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		Workers: &WorkerPool{Workers: 1},
+		Tarpit:  &Tarpit{Delay: 300 * time.Millisecond, Networks: []*net.IPNet{loopback, loopback6}},
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 4
+
+	var (
+		wg    sync.WaitGroup
+		start = time.Now()
+		errc  = make(chan error, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			_, err := new(Client).Do(ctx, &Query{
+				RemoteAddr: addr,
+				Message:    &Message{Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}}},
+			})
+			errc <- err
+		}()
+	}
+	wg.Wait()
+	close(errc)
+
+	for err := range errc {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the worker is not held by the responses delayed
+	elapsed := time.Since(start)
+	if elapsed < 300*time.Millisecond || elapsed >= n*300*time.Millisecond {
+		t.Errorf("want responses delayed in parallel, got them after %v", elapsed)
+	}
+	if want, got := uint64(n), srv.QueryStats().Tarpitted; want != got {
+		t.Errorf("want %d queries tarpitted, got %d", want, got)
+	}
+}
+
+func TestServerTarpitMaxPending(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{
+		Addr: mustUnusedAddr(),
+		Handler: HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+			w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+		}),
+		Classifier: QueryClassifierFunc(func(ctx context.Context, client net.Addr, q Question, rates ClientRates) QueryAction {
+			return QueryTarpit
+		}),
+		Tarpit: &Tarpit{Delay: time.Second, MaxPending: 1},
+	}
+	mustStart(srv)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(ctx context.Context) error {
+		_, err := new(Client).Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message:    &Message{Questions: []Question{{Name: "test.local.", Type: TypeA, Class: ClassIN}}},
+		})
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- query(context.Background()) }()
+
+	for srv.QueryStats().Delayed == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err := query(ctx); err == nil {
+		t.Error("want response past MaxPending dropped")
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := uint64(1), srv.QueryStats().Dropped; want != got {
+		t.Errorf("want %d response dropped, got %d", want, got)
+	}
+}
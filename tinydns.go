@@ -0,0 +1,310 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The default TTLs of the lines of tinydns-data.
+const (
+	tinydnsTTL    = 86400 * time.Second
+	tinydnsNSTTL  = 259200 * time.Second
+	tinydnsSOATTL = 2560 * time.Second
+)
+
+// maxTinydnsText is the length of the character-strings a TXT line is split
+// into.
+const maxTinydnsText = 127
+
+// ParseTinydns returns the zone origin of the data r in the format of
+// tinydns-data, the file compiled into data.cdb by djbdns. The records of
+// other zones are left out, and so are the location and timestamp fields.
+// The zone TTL is that of its SOA record, and the records with another TTL
+// are stored as an RREntry with their TTL.
+//
+// The lines supported are those of the SOA records (Z), the NS records and
+// their addresses (&), the addresses with (=) or without (+) their PTR
+// record, the MX records and their addresses (@), the PTR (^), TXT (') and
+// CNAME (C) records, and the records in generic format (:), such as those of
+// the AAAA records. The "." lines, and the other ones, are an error.
+func ParseTinydns(r io.Reader, origin string) (*Zone, error) {
+	var (
+		rs []Resource
+		sc = bufio.NewScanner(r)
+		n  int
+	)
+	for sc.Scan() {
+		n++
+
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+
+		res, err := parseTinydnsLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rs = append(rs, res...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cloudZone(rs, origin)
+}
+
+// parseTinydnsLine returns the records of the tinydns-data line.
+func parseTinydnsLine(line string) ([]Resource, error) {
+	raw := strings.Split(line[1:], ":")
+	fields := make([]string, len(raw))
+	for i, f := range raw {
+		fields[i] = unescapeOctal(f)
+	}
+
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	ttl := func(i int, def time.Duration) (time.Duration, error) {
+		if field(i) == "" {
+			return def, nil
+		}
+		n, err := strconv.ParseUint(field(i), 10, 32)
+		if err != nil {
+			return 0, errRDataText
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+	ip := func(i int) (net.IP, error) {
+		ip := net.ParseIP(field(i)).To4()
+		if ip == nil {
+			return nil, errRDataText
+		}
+		return ip, nil
+	}
+
+	name := tinydnsName(field(0))
+	rr := func(owner string, d time.Duration, rec Record) Resource {
+		return Resource{Name: owner, Class: ClassIN, TTL: d, Record: rec}
+	}
+
+	switch line[0] {
+	case 'Z':
+		v := make([]int, 5)
+		defs := []int{0, 16384, 2048, 1048576, 2560}
+		for i := range v {
+			v[i] = defs[i]
+			if f := field(i + 3); f != "" {
+				n, err := strconv.ParseUint(f, 10, 32)
+				if err != nil {
+					return nil, errRDataText
+				}
+				v[i] = int(n)
+			}
+		}
+		d, err := ttl(8, tinydnsSOATTL)
+		if err != nil {
+			return nil, err
+		}
+		return []Resource{rr(name, d, &SOA{
+			NS:      tinydnsName(field(1)),
+			MBox:    tinydnsName(field(2)),
+			Serial:  v[0],
+			Refresh: time.Duration(v[1]) * time.Second,
+			Retry:   time.Duration(v[2]) * time.Second,
+			Expire:  time.Duration(v[3]) * time.Second,
+			MinTTL:  time.Duration(v[4]) * time.Second,
+		})}, nil
+	case '&', '@':
+		i, def, label := 3, tinydnsNSTTL, "ns"
+		if line[0] == '@' {
+			i, def, label = 4, tinydnsTTL, "mx"
+		}
+		d, err := ttl(i, def)
+		if err != nil {
+			return nil, err
+		}
+
+		host := field(2)
+		if !strings.Contains(host, ".") {
+			host += "." + label + "." + strings.TrimSuffix(field(0), ".")
+		}
+		host = tinydnsName(host)
+
+		var rs []Resource
+		if line[0] == '&' {
+			rs = append(rs, rr(name, d, &NS{NS: host}))
+		} else {
+			pref := 0
+			if f := field(3); f != "" {
+				n, err := strconv.ParseUint(f, 10, 16)
+				if err != nil {
+					return nil, errRDataText
+				}
+				pref = int(n)
+			}
+			rs = append(rs, rr(name, d, &MX{Pref: pref, MX: host}))
+		}
+		if field(1) != "" {
+			a, err := ip(1)
+			if err != nil {
+				return nil, err
+			}
+			rs = append(rs, rr(host, d, &A{A: a}))
+		}
+		return rs, nil
+	case '=', '+':
+		d, err := ttl(2, tinydnsTTL)
+		if err != nil {
+			return nil, err
+		}
+		a, err := ip(1)
+		if err != nil {
+			return nil, err
+		}
+
+		rs := []Resource{rr(name, d, &A{A: a})}
+		if rev, ok := ReverseName(a); ok && line[0] == '=' {
+			rs = append(rs, rr(rev, d, &PTR{PTR: name}))
+		}
+		return rs, nil
+	case '^', 'C':
+		d, err := ttl(2, tinydnsTTL)
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '^' {
+			return []Resource{rr(name, d, &PTR{PTR: tinydnsName(field(1))})}, nil
+		}
+		return []Resource{rr(name, d, &CNAME{CNAME: tinydnsName(field(1))})}, nil
+	case '\'':
+		d, err := ttl(2, tinydnsTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		text := field(1)
+		txt := &TXT{}
+		for len(text) > maxTinydnsText {
+			txt.TXT, text = append(txt.TXT, text[:maxTinydnsText]), text[maxTinydnsText:]
+		}
+		txt.TXT = append(txt.TXT, text)
+		return []Resource{rr(name, d, txt)}, nil
+	case ':':
+		d, err := ttl(3, tinydnsTTL)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(field(1), 10, 16)
+		if err != nil {
+			return nil, errRDataText
+		}
+		t := Type(n)
+		newRecord, ok := NewRecordByType[t]
+		if !ok || t == TypeSOA || t == TypeOPT {
+			return nil, errUnknownType
+		}
+
+		rec := newRecord()
+		data := []byte(field(2))
+		if rest, err := rec.Unpack(data, decompressor(data)); err != nil || len(rest) != 0 {
+			return nil, errRDataText
+		}
+		return []Resource{rr(name, d, rec)}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", errUnknownType, line[:1])
+}
+
+// tinydnsName returns the domain name of a tinydns-data fqdn, with the
+// trailing dot it goes without.
+func tinydnsName(fqdn string) string {
+	return strings.TrimSuffix(fqdn, ".") + "."
+}
+
+// escapeTinydns returns s with the bytes that are not printable, and the
+// colons and backslashes, as \ooo octal escapes.
+func escapeTinydns(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == ':' || c == '\\' {
+			fmt.Fprintf(&b, `\%03o`, c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// WriteTinydns writes the zone z in the format of tinydns-data: its SOA
+// record, then its records of class IN by name and type. The A, NS, MX, PTR,
+// CNAME and TXT records are written as the lines of their type, without the
+// addresses of the NS and MX lines; the other records, such as the AAAA
+// records, in generic format.
+func WriteTinydns(w io.Writer, z *Zone) error {
+	bw := bufio.NewWriter(w)
+
+	origin := strings.TrimSuffix(z.fqdn(""), ".")
+	if soa := z.SOA; soa != nil {
+		fmt.Fprintf(bw, "Z%s:%s:%s:%d:%d:%d:%d:%d:%d\n", escapeTinydns(origin),
+			escapeTinydns(strings.TrimSuffix(soa.NS, ".")), escapeTinydns(strings.TrimSuffix(soa.MBox, ".")),
+			uint32(soa.Serial), soa.Refresh/time.Second, soa.Retry/time.Second, soa.Expire/time.Second,
+			soa.MinTTL/time.Second, z.TTL/time.Second)
+	}
+
+	for _, set := range zoneRRsets(z) {
+		name := escapeTinydns(strings.TrimSuffix(set.name, "."))
+		ttl := int64(set.ttl / time.Second)
+		for _, rr := range set.rrs {
+			writeTinydnsLine(bw, name, ttl, rr)
+		}
+	}
+	return bw.Flush()
+}
+
+func writeTinydnsLine(w io.Writer, name string, ttl int64, rr Record) {
+	host := func(s string) string { return escapeTinydns(strings.TrimSuffix(s, ".")) }
+
+	switch rr := rr.(type) {
+	case *A:
+		fmt.Fprintf(w, "+%s:%s:%d\n", name, rr.A, ttl)
+		return
+	case *NS:
+		fmt.Fprintf(w, "&%s::%s:%d\n", name, host(rr.NS), ttl)
+		return
+	case *MX:
+		fmt.Fprintf(w, "@%s::%s:%d:%d\n", name, host(rr.MX), rr.Pref, ttl)
+		return
+	case *PTR:
+		fmt.Fprintf(w, "^%s:%s:%d\n", name, host(rr.PTR), ttl)
+		return
+	case *CNAME:
+		fmt.Fprintf(w, "C%s:%s:%d\n", name, host(rr.CNAME), ttl)
+		return
+	case *TXT:
+		if tinydnsText(rr) {
+			fmt.Fprintf(w, "'%s:%s:%d\n", name, escapeTinydns(strings.Join(rr.TXT, "")), ttl)
+			return
+		}
+	}
+
+	b, _ := rr.Pack(nil, nil)
+	fmt.Fprintf(w, ":%s:%d:%s:%d\n", name, rr.Type(), escapeTinydns(string(b)), ttl)
+}
+
+// tinydnsText reports whether the character-strings of txt are those of a
+// TXT line, split every 127 bytes.
+func tinydnsText(txt *TXT) bool {
+	for i, s := range txt.TXT {
+		if len(s) > maxTinydnsText || (len(s) < maxTinydnsText && i < len(txt.TXT)-1) {
+			return false
+		}
+	}
+	return len(txt.TXT) > 0
+}
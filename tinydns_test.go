@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const tinydnsData = `# example.com
+Zexample.com:ns1.example.com:hostmaster.example.com:2024010101:3600:600:86400:300:3600
+&example.com:192.0.2.53:ns1:3600
+=www.example.com:192.0.2.1:3600
++ftp.example.com:192.0.2.2
+@example.com:192.0.2.25:mail:10:3600
+^1.2.0.192.in-addr.arpa:www.example.com:3600
+'example.com:v=spf1 -all:3600
+'long.example.com:` + "\\072" + `colon:3600
+Cwebmail.example.com:mail.mx.example.com:3600
+:v6.example.com:28:\040\001\015\270\000\000\000\000\000\000\000\000\000\000\000\001:3600
+-disabled.example.com:192.0.2.99
++other.example.net:192.0.2.100
+`
+
+func TestParseTinydns(t *testing.T) {
+	t.Parallel()
+
+	z, err := ParseTinydns(strings.NewReader(tinydnsData), "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := (&SOA{
+		NS:      "ns1.example.com.",
+		MBox:    "hostmaster.example.com.",
+		Serial:  2024010101,
+		Refresh: time.Hour,
+		Retry:   10 * time.Minute,
+		Expire:  24 * time.Hour,
+		MinTTL:  5 * time.Minute,
+	}), z.SOA; !reflect.DeepEqual(want, got) {
+		t.Errorf("want SOA %+v, got %+v", want, got)
+	}
+	if want, got := time.Hour, z.TTL; want != got {
+		t.Errorf("want TTL %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name string
+		t    Type
+		want []Record
+	}{
+		{"", TypeNS, []Record{&NS{NS: "ns1.ns.example.com."}}},
+		{"ns1.ns", TypeA, []Record{&A{A: net.IPv4(192, 0, 2, 53).To4()}}},
+		{"www", TypeA, []Record{&A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		{"ftp", TypeA, []Record{&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}, TTL: 24 * time.Hour}}},
+		{"", TypeMX, []Record{&MX{Pref: 10, MX: "mail.mx.example.com."}}},
+		{"mail.mx", TypeA, []Record{&A{A: net.IPv4(192, 0, 2, 25).To4()}}},
+		{"", TypeTXT, []Record{&TXT{TXT: []string{"v=spf1 -all"}}}},
+		{"long", TypeTXT, []Record{&TXT{TXT: []string{":colon"}}}},
+		{"webmail", TypeCNAME, []Record{&CNAME{CNAME: "mail.mx.example.com."}}},
+		{"v6", TypeAAAA, []Record{&AAAA{AAAA: net.ParseIP("2001:db8::1")}}},
+		{"disabled", TypeA, nil},
+	}
+	for _, test := range tests {
+		if want, got := test.want, z.GetRecords(test.name, test.t); !reflect.DeepEqual(want, got) {
+			t.Errorf("%q %v: want records %v, got %v", test.name, test.t, want, got)
+		}
+	}
+
+	rev, err := ParseTinydns(strings.NewReader(tinydnsData+"Z2.0.192.in-addr.arpa:ns1.example.com:hostmaster.example.com\n"), "2.0.192.in-addr.arpa.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range rev.GetRecords("1", TypePTR) {
+		if got, _ := unwrapRecord(rr); !reflect.DeepEqual(&PTR{PTR: "www.example.com."}, got) {
+			t.Errorf("want PTR record www.example.com., got %v", got)
+		}
+	}
+	if len(rev.GetRecords("1", TypePTR)) == 0 {
+		t.Error("want PTR records, got none")
+	}
+
+	for _, data := range []string{
+		"+bad.example.com:not-an-ip\n",
+		".example.com:192.0.2.53:a\n",
+		"Zexample.com:ns1.example.com:hostmaster.example.com:x\n",
+	} {
+		if _, err := ParseTinydns(strings.NewReader(data), "example.com."); err == nil {
+			t.Errorf("%q: want error", data)
+		}
+	}
+}
+
+func TestWriteTinydns(t *testing.T) {
+	t.Parallel()
+
+	z := &Zone{
+		Origin: "example.com.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns1.example.com.", MBox: "hostmaster.example.com.", Serial: 7, Refresh: time.Hour, Retry: time.Minute, Expire: 24 * time.Hour, MinTTL: time.Minute},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"": {
+				TypeNS: {&NS{NS: "ns1.example.com."}},
+				TypeMX: {&MX{Pref: 10, MX: "mail.example.com."}},
+			},
+			"www":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}}, TypeAAAA: {&AAAA{AAAA: net.ParseIP("2001:db8::1")}}},
+			"txt":  {TypeTXT: {&TXT{TXT: []string{"a:b"}}}},
+			"pair": {TypeTXT: {&TXT{TXT: []string{"a", "b"}}}},
+			"ftp":  {TypeCNAME: {&RREntry{Record: &CNAME{CNAME: "www.example.com."}, TTL: time.Minute}}},
+		}),
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTinydns(&buf, z); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Zexample.com:ns1.example.com:hostmaster.example.com:7:3600:60:86400:60:3600\n" +
+		"&example.com::ns1.example.com:3600\n" +
+		"@example.com::mail.example.com:10:3600\n" +
+		"Cftp.example.com:www.example.com:60\n" +
+		":pair.example.com:16:\\001a\\001b:3600\n" +
+		"'txt.example.com:a\\072b:3600\n" +
+		"+www.example.com:192.0.2.1:3600\n" +
+		":www.example.com:28: \\001\\015\\270\\000\\000\\000\\000\\000\\000\\000\\000\\000\\000\\000\\001:3600\n"
+	if got := buf.String(); want != got {
+		t.Errorf("want data\n%s\ngot\n%s", want, got)
+	}
+
+	parsed, err := ParseTinydns(&buf, "example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := DiffZones(z, parsed); !d.Empty() {
+		t.Errorf("want zone read back, got difference\n%s", d)
+	}
+}
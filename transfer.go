@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+var (
+	errTransferRefused    = errors.New("zone transfer refused")
+	errIncompleteTransfer = errors.New("incomplete zone transfer")
+)
+
+// serveTransfer answers an AXFR question for the zone with all its records in
+// a single message, between two copies of the SOA record, see RFC 5936.
+func (z *Zone) serveTransfer(w MessageWriter, q Question) {
+	w.Answer(q.Name, z.TTL, z.SOA)
+
+	z.store().Range(func(name string, t Type, rr Record) bool {
+		if t == TypeSOA || recordClass(rr) != ClassIN {
+			return true
+		}
+		if rr, ttl, ok := z.answer(rr); ok {
+			w.Answer(z.fqdn(name), ttl, rr)
+		}
+		return true
+	})
+
+	w.Answer(q.Name, z.TTL, z.SOA)
+}
+
+// fqdn returns the domain name of the RRSet key k of the zone.
+func (z *Zone) fqdn(k string) string {
+	origin := strings.TrimSuffix(z.Origin, ".") + "."
+	if k == "" {
+		return origin
+	}
+	if origin == "." {
+		return k + "."
+	}
+	return k + "." + origin
+}
+
+// Transfer requests the zone origin from the server at addr with an AXFR
+// query over a stream connection, and returns the transferred zone. Records
+// with a TTL other than the one of the SOA record are stored as an RREntry
+// with their TTL.
+func (c *Client) Transfer(ctx context.Context, addr net.Addr, origin string) (*Zone, error) {
+	msgc, err := c.DoStream(ctx, &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: origin, Type: TypeAXFR, Class: ClassIN},
+			},
+		},
+	})
+	if err != nil {
+		c.log(ctx, slog.LevelWarn, "dns transfer", "addr", addr, "zone", origin, "err", err)
+		return nil, err
+	}
+
+	zr := newZoneReader(origin)
+	for msg := range msgc {
+		zr.add(msg)
+	}
+
+	var z *Zone
+	switch {
+	case zr.err != nil:
+		err = zr.err
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	default:
+		z, err = zr.zone()
+	}
+	if err != nil {
+		c.log(ctx, slog.LevelWarn, "dns transfer", "addr", addr, "zone", origin, "err", err)
+		return nil, err
+	}
+
+	c.log(ctx, slog.LevelInfo, "dns transfer", "addr", addr, "zone", origin, "serial", z.SOA.Serial, "names", z.Len())
+	return z, nil
+}
+
+// zoneReader builds the zone of the messages of an AXFR response. Records
+// with a TTL other than the one of the SOA record are stored as an RREntry
+// with their TTL, and the duplicate records are dropped.
+type zoneReader struct {
+	z    *Zone
+	rrs  []Resource // of the zone, but its SOA record
+	soas int
+	err  error // set by an error response
+}
+
+func newZoneReader(origin string) *zoneReader {
+	return &zoneReader{z: &Zone{Origin: origin}}
+}
+
+func (zr *zoneReader) add(msg *Message) {
+	if msg.RCode != NoError {
+		zr.err = errTransferRefused
+		return
+	}
+
+	z := zr.z
+	for _, res := range msg.Answers {
+		if soa, ok := res.Record.(*SOA); ok {
+			if zr.soas++; zr.soas == 1 {
+				z.SOA, z.TTL = soa, res.TTL
+			}
+			continue
+		}
+		if zr.soas != 1 {
+			continue
+		}
+
+		if _, ok := z.key(res.Name); ok {
+			zr.rrs = append(zr.rrs, res)
+		}
+	}
+}
+
+// zone returns the zone read, or an error unless it was closed by a second
+// SOA record.
+func (zr *zoneReader) zone() (*Zone, error) {
+	if zr.err != nil {
+		return nil, zr.err
+	}
+	if zr.soas < 2 {
+		return nil, errIncompleteTransfer
+	}
+
+	z := zr.z
+	all := make(map[string]map[Type][]Record)
+	for _, res := range Dedup(zr.rrs) {
+		k, _ := z.key(res.Name)
+
+		rr := res.Record
+		if res.TTL != z.TTL {
+			rr = &RREntry{Record: rr, TTL: res.TTL}
+		}
+
+		if all[k] == nil {
+			all[k] = make(map[Type][]Record)
+		}
+		all[k][res.Record.Type()] = append(all[k][res.Record.Type()], rr)
+	}
+
+	z.RRs = NewRRSet(all)
+	return z, nil
+}
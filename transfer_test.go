@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClientTransfer(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "xfr.dev.",
+		TTL:    time.Hour,
+		SOA: &SOA{
+			NS:     "ns.xfr.dev.",
+			MBox:   "hostmaster.xfr.dev.",
+			Serial: 7,
+		},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"": {
+				TypeNS: {&NS{NS: "ns.xfr.dev."}},
+			},
+			"app": {
+				TypeA: {
+					&A{A: net.IPv4(10, 0, 0, 1).To4()},
+					&RREntry{Record: &A{A: net.IPv4(10, 0, 0, 2).To4()}, TTL: time.Minute},
+				},
+			},
+		}),
+	}
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	got, err := new(Client).Transfer(ctx, addr, "xfr.dev.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := zone.SOA, got.SOA; !reflect.DeepEqual(want, got) {
+		t.Errorf("want SOA %+v, got %+v", want, got)
+	}
+	if want, got := zone.TTL, got.TTL; want != got {
+		t.Errorf("want zone TTL %v, got %v", want, got)
+	}
+	if want, got := zone.GetAll(), got.GetAll(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want records %v, got %v", want, got)
+	}
+
+	_, err = new(Client).Transfer(ctx, addr, "other.dev.")
+	if want, got := errTransferRefused, err; want != got {
+		t.Errorf("want error %v, got %v", want, got)
+	}
+}
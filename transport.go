@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Transport is an implementation of AddrDialer that manages connections to DNS
@@ -18,6 +21,10 @@ type Transport struct {
 	// method of a new net.Dialer is used by default.
 	DialContext func(context.Context, string, string) (net.Conn, error)
 
+	// SocketOptions, if not nil, are applied to the sockets dialed when
+	// DialContext is nil.
+	SocketOptions *SocketOptions
+
 	// Proxy modifies the address of the DNS server to dial.
 	Proxy ProxyFunc
 
@@ -25,19 +32,90 @@ type Transport struct {
 	// connections as defined in RFC 7766, section 6.2.1.1.
 	DisablePipelining bool
 
+	// SocketPerQuery sends each message of a UDP connection from a fresh
+	// socket, rather than from one connected socket for the lifetime of the
+	// connection.
+	SocketPerQuery bool
+
+	// SourcePorts, if not zero, is the range of the local ports of UDP
+	// sockets, each socket being bound to a port drawn at random from it.
+	// SourcePorts is ignored when DialContext is set.
+	SourcePorts PortRange
+
+	// MaxUDPSize, if larger than 512, is the largest query sent on a UDP
+	// connection. Larger queries fail with ErrOversizedMessage, to be sent
+	// over TCP. The responses are read up to the EDNS payload size of
+	// their query.
+	MaxUDPSize int
+
+	// HTTPClient sends the requests to ObliviousAddr servers. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// MaxConnAge, if not zero, is the maximum age of a pipelined TCP or TLS
+	// connection. Past it, the connection takes no new queries and is
+	// closed once the responses to its queries are read, the next queries
+	// dialing a new connection. Long-lived connections to servers behind a
+	// load balancer are so moved to the nodes it routes to.
+	MaxConnAge time.Duration
+
+	// ConnState, if not nil, is called with the address of the server when
+	// a pipelined connection is dialed, starts draining, and is closed, by
+	// the transport or the server. It is called from the goroutines of the
+	// transport, and must not block.
+	ConnState func(net.Addr, ConnState)
+
 	plinemu sync.Mutex
 	plines  map[net.Addr]*pipeline
+
+	odohmu   sync.Mutex
+	odohcfgs map[string]*odohConfig
+}
+
+// ConnState is the state of a pipelined connection of a Transport, passed to
+// its ConnState hook.
+type ConnState int
+
+const (
+	// StateNew is a connection just dialed.
+	StateNew ConnState = iota
+
+	// StateDraining is a connection past the MaxConnAge of its transport,
+	// waiting for the responses to its queries before closing.
+	StateDraining
+
+	// StateClosed is a connection closed, once drained or by the server.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateDraining:
+		return "draining"
+	case StateClosed:
+		return "closed"
+	}
+	return "ConnState(" + strconv.Itoa(int(s)) + ")"
 }
 
 // DialAddr dials a net Addr and returns a Conn.
 func (t *Transport) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
+	if addr, ok := addr.(ObliviousAddr); ok {
+		return t.dialODoH(ctx, addr)
+	}
+
 	if !t.DisablePipelining {
 		if pline := t.getPipeline(addr); pline != nil && pline.alive() {
-			return pline.conn(), nil
+			if !pline.expired(t.MaxConnAge) {
+				return pline.conn(), nil
+			}
+			pline.drain()
 		}
 	}
 
-	conn, err := t.dialAddr(ctx, addr)
+	conn, err := t.dialAddr(ctx, addr, !t.DisablePipelining)
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +123,7 @@ func (t *Transport) DialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 	return conn, nil
 }
 
-func (t *Transport) dialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
+func (t *Transport) dialAddr(ctx context.Context, addr net.Addr, pipelining bool) (Conn, error) {
 	conn, dnsOverTLS, err := t.dial(ctx, addr)
 	if err != nil {
 		return nil, err
@@ -64,29 +142,45 @@ func (t *Transport) dialAddr(ctx context.Context, addr net.Addr) (Conn, error) {
 		if t.TLSConfig != nil {
 			cfg = t.TLSConfig.Clone()
 		}
+		if len(cfg.NextProtos) == 0 {
+			cfg.NextProtos = []string{alpnDoT}
+		}
 
 		conn = tls.Client(conn, cfg)
-		if err := conn.(*tls.Conn).Handshake(); err != nil {
+		if err := conn.(*tls.Conn).HandshakeContext(ctx); err != nil {
+			conn.Close()
 			return nil, err
 		}
 	}
 
 	if _, ok := conn.(net.PacketConn); ok {
-		return &PacketConn{
-			Conn: conn,
-		}, nil
-	}
+		pconn := &PacketConn{
+			Conn:    traceFirstByte(ctx, conn),
+			maxSize: t.MaxUDPSize,
+		}
 
-	sconn := &StreamConn{
-		Conn: conn,
+		if t.SocketPerQuery {
+			ctx := context.WithoutCancel(ctx)
+			return &freshPacketConn{
+				PacketConn: pconn,
+				dial: func() (net.Conn, error) {
+					conn, _, err := t.dial(ctx, addr)
+					if err != nil {
+						return nil, err
+					}
+					return traceFirstByte(ctx, conn), nil
+				},
+			}, nil
+		}
+		return pconn, nil
 	}
 
-	if !t.DisablePipelining {
-		pline := t.setPipeline(addr, sconn)
+	if pipelining {
+		pline := t.setPipeline(addr, &StreamConn{Conn: conn})
 		return pline.conn(), nil
 	}
 
-	return sconn, nil
+	return &StreamConn{Conn: traceFirstByte(ctx, conn)}, nil
 }
 
 var defaultDialer = &net.Dialer{
@@ -101,6 +195,12 @@ func (t *Transport) dial(ctx context.Context, addr net.Addr) (net.Conn, bool, er
 		}
 	}
 
+	if guard := forwardGuardFrom(ctx); guard != nil {
+		if err := guard.dial(addr); err != nil {
+			return nil, false, err
+		}
+	}
+
 	network, dnsOverTLS := addr.Network(), false
 	if strings.HasSuffix(network, "-tls") {
 		network, dnsOverTLS = network[:len(network)-4], true
@@ -108,15 +208,36 @@ func (t *Transport) dial(ctx context.Context, addr net.Addr) (net.Conn, bool, er
 
 	dial := t.DialContext
 	if dial == nil {
-		dial = defaultDialer.DialContext
+		dial = t.dialer().DialContext
+	}
+
+	u := proxyURL(addr)
+	if u != nil && !strings.HasPrefix(network, "tcp") {
+		return nil, false, ErrUnsupportedNetwork
 	}
 
-	conn, err := dial(ctx, network, addr.String())
+	trace := ContextClientTrace(ctx)
+	trace.connectStart(network, addr.String())
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch {
+	case u != nil:
+		conn, err = dialProxy(ctx, dial, u, addr.String())
+	case t.DialContext == nil && t.SourcePorts != (PortRange{}) && strings.HasPrefix(network, "udp"):
+		conn, err = t.dialSourcePort(ctx, network, addr.String())
+	default:
+		conn, err = dial(ctx, network, addr.String())
+	}
+
+	trace.connectDone(network, addr.String(), err)
 	if err != nil {
 		return nil, false, err
 	}
 
-	return conn, dnsOverTLS, err
+	return conn, dnsOverTLS, nil
 }
 
 func (t *Transport) getPipeline(addr net.Addr) *pipeline {
@@ -133,17 +254,25 @@ func (t *Transport) getPipeline(addr net.Addr) *pipeline {
 func (t *Transport) setPipeline(addr net.Addr, conn Conn) *pipeline {
 	pline := &pipeline{
 		Conn:     conn,
+		addr:     addr,
+		born:     time.Now(),
+		state:    t.ConnState,
 		inflight: make(map[int]pipelineTx),
 	}
+	pline.notify(StateNew)
 	go pline.run()
 
 	t.plinemu.Lock()
-	defer t.plinemu.Unlock()
-
 	if t.plines == nil {
 		t.plines = make(map[net.Addr]*pipeline)
 	}
-
+	old := t.plines[addr]
 	t.plines[addr] = pline
+	t.plinemu.Unlock()
+
+	// a pipeline dialed concurrently, or expired, is replaced
+	if old != nil {
+		old.drain()
+	}
 	return pline
 }
@@ -208,3 +208,79 @@ func (a *answerHandler) ServeDNS(ctx context.Context, w MessageWriter, r *Query)
 		}
 	}
 }
+
+func TestTransportMaxConnAge(t *testing.T) {
+	t.Parallel()
+
+	releasec := make(chan struct{})
+	srv := mustServer(HandlerFunc(func(ctx context.Context, w MessageWriter, r *Query) {
+		if r.Questions[0].Name == "slow.dev." {
+			<-releasec
+		}
+		w.Answer(r.Questions[0].Name, time.Minute, &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	}))
+
+	addr, err := net.ResolveTCPAddr("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statec := make(chan ConnState, 16)
+	client := &Client{
+		Transport: &Transport{
+			MaxConnAge: 50 * time.Millisecond,
+			ConnState: func(a net.Addr, state ConnState) {
+				if a != addr {
+					t.Errorf("want address %v, got %v", addr, a)
+				}
+				statec <- state
+			},
+		},
+	}
+
+	query := func(name string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err := client.Do(ctx, &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{{Name: name, Type: TypeA, Class: ClassIN}},
+			},
+		})
+		return err
+	}
+
+	wantStates := func(want ...ConnState) {
+		t.Helper()
+
+		for _, want := range want {
+			select {
+			case got := <-statec:
+				if want != got {
+					t.Fatalf("want connection %s, got %s", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("want connection %s", want)
+			}
+		}
+	}
+
+	slowc := make(chan error, 1)
+	go func() { slowc <- query("slow.dev.") }()
+	wantStates(StateNew)
+
+	time.Sleep(60 * time.Millisecond)
+
+	// the expired connection drains while a new one serves the query
+	if err := query("fast.dev."); err != nil {
+		t.Fatal(err)
+	}
+	wantStates(StateDraining, StateNew)
+
+	close(releasec)
+	if err := <-slowc; err != nil {
+		t.Fatalf("want the query in flight answered, got %v", err)
+	}
+	wantStates(StateClosed)
+}
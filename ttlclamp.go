@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"time"
+)
+
+// TTLClamp bounds the TTLs of the records of the responses to forwarded
+// queries, such as to make clients resolve names again quickly during a
+// migration, or to spare the upstream servers the queries for records with
+// short TTLs.
+//
+// A TTLClamp is added to a Client with Use, or around the Forwarder of a
+// Server:
+//
+//	client.Use((&dns.TTLClamp{MaxTTL: time.Minute}).Intercept)
+type TTLClamp struct {
+	// MinTTL and MaxTTL, if not zero, clamp the TTLs of the records. The
+	// TTLs of zero, of records not to be cached, are kept, whatever MinTTL.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// ZeroTTL, if not zero, replaces the TTLs of zero.
+	ZeroTTL time.Duration
+}
+
+// Intercept returns a RoundTripper sending the queries to next, and returning
+// their responses with the TTLs of the records of each section clamped. The
+// OPT record, whose TTL holds the extended RCODE and flags, is left as is.
+func (c *TTLClamp) Intercept(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, query *Query) (*Message, error) {
+		msg, err := next.Do(ctx, query)
+		if err != nil || msg == nil {
+			return msg, err
+		}
+
+		m := *msg
+		m.Answers = c.clamp(msg.Answers)
+		m.Authorities = c.clamp(msg.Authorities)
+		m.Additionals = c.clamp(msg.Additionals)
+		return &m, nil
+	})
+}
+
+// clamp returns a copy of rs with the TTLs clamped, or rs if none changed, so
+// that the records of a cached response are not modified.
+func (c *TTLClamp) clamp(rs []Resource) []Resource {
+	var v []Resource
+	for i, res := range rs {
+		ttl := c.ttl(res)
+		if ttl == res.TTL {
+			continue
+		}
+
+		if v == nil {
+			v = append([]Resource(nil), rs...)
+		}
+		v[i].TTL = ttl
+	}
+	if v == nil {
+		return rs
+	}
+	return v
+}
+
+func (c *TTLClamp) ttl(res Resource) time.Duration {
+	ttl := res.TTL
+	switch {
+	case res.Record.Type() == TypeOPT:
+		return ttl
+	case ttl == 0:
+		return c.ZeroTTL
+	case c.MinTTL > 0 && ttl < c.MinTTL:
+		ttl = c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+	return ttl
+}
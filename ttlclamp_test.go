@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTTLClamp(t *testing.T) {
+	t.Parallel()
+
+	upstream := &Message{
+		Answers: []Resource{
+			{Name: "short.dev.", Class: ClassIN, TTL: time.Second, Record: &A{A: net.IPv4(192, 0, 2, 1).To4()}},
+			{Name: "long.dev.", Class: ClassIN, TTL: 24 * time.Hour, Record: &A{A: net.IPv4(192, 0, 2, 2).To4()}},
+			{Name: "zero.dev.", Class: ClassIN, Record: &A{A: net.IPv4(192, 0, 2, 3).To4()}},
+			{Name: "fit.dev.", Class: ClassIN, TTL: 5 * time.Minute, Record: &A{A: net.IPv4(192, 0, 2, 4).To4()}},
+		},
+		Additionals: []Resource{
+			{Name: ".", Class: 1232, Record: &OPT{}},
+		},
+	}
+	next := RoundTripperFunc(func(context.Context, *Query) (*Message, error) {
+		msg := *upstream
+		return &msg, nil
+	})
+
+	tests := []struct {
+		name string
+
+		clamp *TTLClamp
+		ttls  []time.Duration
+	}{
+		{
+			name:  "min and max",
+			clamp: &TTLClamp{MinTTL: time.Minute, MaxTTL: time.Hour},
+			ttls:  []time.Duration{time.Minute, time.Hour, 0, 5 * time.Minute},
+		},
+		{
+			name:  "zero",
+			clamp: &TTLClamp{MaxTTL: time.Minute, ZeroTTL: 10 * time.Second},
+			ttls:  []time.Duration{time.Second, time.Minute, 10 * time.Second, time.Minute},
+		},
+		{
+			name:  "none",
+			clamp: new(TTLClamp),
+			ttls:  []time.Duration{time.Second, 24 * time.Hour, 0, 5 * time.Minute},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			msg, err := test.clamp.Intercept(next).Do(context.Background(), &Query{Message: new(Message)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ttls []time.Duration
+			for _, res := range msg.Answers {
+				ttls = append(ttls, res.TTL)
+			}
+			if want, got := test.ttls, ttls; !reflect.DeepEqual(want, got) {
+				t.Errorf("want TTLs %v, got %v", want, got)
+			}
+			if want, got := upstream.Additionals, msg.Additionals; !reflect.DeepEqual(want, got) {
+				t.Errorf("want OPT record kept, got %v", got)
+			}
+		})
+	}
+
+	if want, got := time.Second, upstream.Answers[0].TTL; want != got {
+		t.Errorf("want upstream records unmodified, got TTL %v", got)
+	}
+}
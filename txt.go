@@ -0,0 +1,294 @@
+package dns
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoTXTRecord        = errors.New("no matching TXT record")
+	errMultipleTXTRecords = errors.New("multiple matching TXT records")
+	errInvalidTXTRecord   = errors.New("invalid TXT record")
+)
+
+// SPF is a Sender Policy Framework record, see RFC 7208.
+type SPF struct {
+	Mechanisms []SPFMechanism
+
+	// Modifiers maps the names of the modifiers, such as "redirect" or
+	// "exp", to their values.
+	Modifiers map[string]string
+}
+
+// SPFMechanism is a directive of an SPF record, such as "-all" or
+// "ip4:192.0.2.0/24".
+type SPFMechanism struct {
+	// Qualifier is one of '+', '-', '~' or '?'.
+	Qualifier byte
+
+	// Name is the mechanism, such as "include" or "ip4", in lowercase.
+	Name string
+
+	// Value is the text after the colon, or the CIDR lengths of an "a" or
+	// "mx" mechanism without a domain, such as "/24".
+	Value string
+}
+
+// ParseSPF returns the SPF record among the TXT records of answers. It is an
+// error if none or more than one record starts with "v=spf1", see RFC 7208,
+// section 4.5.
+func ParseSPF(answers []Resource) (*SPF, error) {
+	txt, err := matchTXT(answers, func(s string) bool {
+		return strings.EqualFold(s, "v=spf1") || hasPrefixFold(s, "v=spf1 ")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spf := &SPF{Modifiers: make(map[string]string)}
+	for _, term := range strings.Fields(txt)[1:] {
+		if i := strings.IndexAny(term, "=:/"); i > 0 && term[i] == '=' {
+			spf.Modifiers[strings.ToLower(term[:i])] = term[i+1:]
+			continue
+		}
+
+		m := SPFMechanism{Qualifier: '+'}
+		if strings.IndexByte("+-~?", term[0]) >= 0 {
+			m.Qualifier, term = term[0], term[1:]
+		}
+
+		m.Name = term
+		if i := strings.IndexAny(term, ":/"); i >= 0 {
+			m.Name, m.Value = term[:i], term[i:]
+			if term[i] == ':' {
+				m.Value = term[i+1:]
+			}
+		}
+		m.Name = strings.ToLower(m.Name)
+
+		switch m.Name {
+		case "all", "include", "a", "mx", "ptr", "ip4", "ip6", "exists":
+		default:
+			return nil, errInvalidTXTRecord
+		}
+		spf.Mechanisms = append(spf.Mechanisms, m)
+	}
+	return spf, nil
+}
+
+// DKIM is a DKIM public key record, see RFC 6376, section 3.6.1.
+type DKIM struct {
+	// KeyType is the "k" tag, "rsa" if absent.
+	KeyType string
+
+	// PublicKey is the decoded "p" tag. It is empty for a revoked key.
+	PublicKey []byte
+
+	// Hashes, Services and Flags are the colon separated lists of the "h",
+	// "s" and "t" tags.
+	Hashes   []string
+	Services []string
+	Flags    []string
+
+	// Tags holds all the tags of the record.
+	Tags map[string]string
+}
+
+// ParseDKIM returns the DKIM key record among the TXT records of answers, the
+// response to a query of the TXT records of "<selector>._domainkey.<domain>".
+func ParseDKIM(answers []Resource) (*DKIM, error) {
+	txt, err := matchTXT(answers, func(s string) bool {
+		tags, err := parseTagList(s)
+		if err != nil {
+			return false
+		}
+		if v, ok := tags["v"]; ok {
+			return v == "DKIM1" && firstTag(s) == "v"
+		}
+		_, ok := tags["p"]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags, _ := parseTagList(txt)
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, errInvalidTXTRecord
+	}
+
+	dkim := &DKIM{
+		KeyType:  "rsa",
+		Hashes:   tagValues(tags["h"]),
+		Services: tagValues(tags["s"]),
+		Flags:    tagValues(tags["t"]),
+		Tags:     tags,
+	}
+	if k, ok := tags["k"]; ok {
+		dkim.KeyType = k
+	}
+	if dkim.PublicKey, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(p), "")); err != nil {
+		return nil, errInvalidTXTRecord
+	}
+	return dkim, nil
+}
+
+// DMARC is a DMARC policy record, see RFC 7489, section 6.3. Optional tags
+// absent from the record are set to their default values.
+type DMARC struct {
+	// Policy and SubdomainPolicy are the "p" and "sp" tags, such as "none",
+	// "quarantine" or "reject".
+	Policy          string
+	SubdomainPolicy string
+
+	// Percent is the "pct" tag, the percentage of messages the policy applies
+	// to.
+	Percent int
+
+	// AggregateURIs and FailureURIs are the "rua" and "ruf" report URIs.
+	AggregateURIs []string
+	FailureURIs   []string
+
+	// DKIMAlignment and SPFAlignment are the "adkim" and "aspf" tags, "r"
+	// for relaxed or "s" for strict.
+	DKIMAlignment string
+	SPFAlignment  string
+
+	// Tags holds all the tags of the record.
+	Tags map[string]string
+}
+
+// ParseDMARC returns the DMARC record among the TXT records of answers, the
+// response to a query of the TXT records of "_dmarc.<domain>". It is an
+// error if none or more than one record starts with "v=DMARC1".
+func ParseDMARC(answers []Resource) (*DMARC, error) {
+	txt, err := matchTXT(answers, func(s string) bool {
+		tags, err := parseTagList(s)
+		return err == nil && tags["v"] == "DMARC1" && firstTag(s) == "v"
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags, _ := parseTagList(txt)
+
+	dmarc := &DMARC{
+		Policy:          strings.ToLower(tags["p"]),
+		SubdomainPolicy: strings.ToLower(tags["sp"]),
+		Percent:         100,
+		AggregateURIs:   uriValues(tags["rua"]),
+		FailureURIs:     uriValues(tags["ruf"]),
+		DKIMAlignment:   "r",
+		SPFAlignment:    "r",
+		Tags:            tags,
+	}
+
+	switch dmarc.Policy {
+	case "none", "quarantine", "reject":
+	default:
+		return nil, errInvalidTXTRecord
+	}
+	if dmarc.SubdomainPolicy == "" {
+		dmarc.SubdomainPolicy = dmarc.Policy
+	}
+	if pct, ok := tags["pct"]; ok {
+		if dmarc.Percent, err = strconv.Atoi(pct); err != nil || dmarc.Percent < 0 || dmarc.Percent > 100 {
+			return nil, errInvalidTXTRecord
+		}
+	}
+	if v, ok := tags["adkim"]; ok {
+		dmarc.DKIMAlignment = strings.ToLower(v)
+	}
+	if v, ok := tags["aspf"]; ok {
+		dmarc.SPFAlignment = strings.ToLower(v)
+	}
+	return dmarc, nil
+}
+
+// matchTXT returns the data of the only TXT record of answers for which match
+// returns true.
+func matchTXT(answers []Resource, match func(string) bool) (string, error) {
+	var (
+		txt   string
+		found bool
+	)
+	for _, res := range answers {
+		rr, ok := res.Record.(*TXT)
+		if !ok {
+			continue
+		}
+
+		if s := rr.Data(); match(s) {
+			if found {
+				return "", errMultipleTXTRecords
+			}
+			txt, found = s, true
+		}
+	}
+	if !found {
+		return "", errNoTXTRecord
+	}
+	return txt, nil
+}
+
+// parseTagList parses a list of semicolon separated tag=value pairs, see RFC
+// 6376, section 3.2.
+func parseTagList(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		i := strings.IndexByte(spec, '=')
+		if i < 0 {
+			return nil, errInvalidTXTRecord
+		}
+
+		name := strings.TrimSpace(spec[:i])
+		if name == "" {
+			return nil, errInvalidTXTRecord
+		}
+		if _, ok := tags[name]; ok {
+			return nil, errInvalidTXTRecord
+		}
+		tags[name] = strings.TrimSpace(spec[i+1:])
+	}
+	return tags, nil
+}
+
+// firstTag returns the name of the first tag of the tag list s.
+func firstTag(s string) string {
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return ""
+}
+
+func tagValues(v string) []string {
+	var vals []string
+	for _, s := range strings.Split(v, ":") {
+		if s = strings.TrimSpace(s); s != "" {
+			vals = append(vals, s)
+		}
+	}
+	return vals
+}
+
+func uriValues(v string) []string {
+	var uris []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			uris = append(uris, s)
+		}
+	}
+	return uris
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
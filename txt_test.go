@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTXTPackLong(t *testing.T) {
+	t.Parallel()
+
+	txt := &TXT{TXT: []string{strings.Repeat("a", 300), ""}}
+
+	n, err := txt.Length(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := txt.Pack(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := n, len(b); want != got {
+		t.Errorf("want packed length %d, got %d", want, got)
+	}
+
+	got := new(TXT)
+	if _, err := got.Unpack(b, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := []string{strings.Repeat("a", 255), strings.Repeat("a", 45), ""}, got.TXT; !reflect.DeepEqual(want, got) {
+		t.Errorf("want strings %q, got %q", want, got)
+	}
+	if want, got := txt.Data(), got.Data(); want != got {
+		t.Errorf("want data %q, got %q", want, got)
+	}
+}
+
+func txtAnswers(txts ...[]string) []Resource {
+	var answers []Resource
+	for _, txt := range txts {
+		answers = append(answers, Resource{
+			Name:   "example.com.",
+			Class:  ClassIN,
+			Record: &TXT{TXT: txt},
+		})
+	}
+	return answers
+}
+
+func TestParseSPF(t *testing.T) {
+	t.Parallel()
+
+	answers := txtAnswers(
+		[]string{"google-site-verification=abc"},
+		[]string{"v=spf1 ip4:192.0.2.0/24 a/28 mx:mail.example.com ", "include:_spf.example.net ~all redirect=_spf.example.com"},
+	)
+
+	spf, err := ParseSPF(answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &SPF{
+		Mechanisms: []SPFMechanism{
+			{Qualifier: '+', Name: "ip4", Value: "192.0.2.0/24"},
+			{Qualifier: '+', Name: "a", Value: "/28"},
+			{Qualifier: '+', Name: "mx", Value: "mail.example.com"},
+			{Qualifier: '+', Name: "include", Value: "_spf.example.net"},
+			{Qualifier: '~', Name: "all"},
+		},
+		Modifiers: map[string]string{"redirect": "_spf.example.com"},
+	}
+	if !reflect.DeepEqual(want, spf) {
+		t.Errorf("want SPF %+v, got %+v", want, spf)
+	}
+
+	if _, err := ParseSPF(append(answers, txtAnswers([]string{"v=spf1 -all"})...)); err != errMultipleTXTRecords {
+		t.Errorf("want error %v, got %v", errMultipleTXTRecords, err)
+	}
+	if _, err := ParseSPF(answers[:1]); err != errNoTXTRecord {
+		t.Errorf("want error %v, got %v", errNoTXTRecord, err)
+	}
+	if _, err := ParseSPF(txtAnswers([]string{"v=spf1 foo:bar -all"})); err != errInvalidTXTRecord {
+		t.Errorf("want error %v, got %v", errInvalidTXTRecord, err)
+	}
+}
+
+func TestParseDKIM(t *testing.T) {
+	t.Parallel()
+
+	dkim, err := ParseDKIM(txtAnswers([]string{"v=DKIM1; k=ed25519; h=sha256; t=y:s; ", "p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "ed25519", dkim.KeyType; want != got {
+		t.Errorf("want key type %q, got %q", want, got)
+	}
+	if want, got := 32, len(dkim.PublicKey); want != got {
+		t.Errorf("want %d byte key, got %d", want, got)
+	}
+	if want, got := []string{"sha256"}, dkim.Hashes; !reflect.DeepEqual(want, got) {
+		t.Errorf("want hashes %q, got %q", want, got)
+	}
+	if want, got := []string{"y", "s"}, dkim.Flags; !reflect.DeepEqual(want, got) {
+		t.Errorf("want flags %q, got %q", want, got)
+	}
+
+	revoked, err := ParseDKIM(txtAnswers([]string{"p="}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "rsa", revoked.KeyType; want != got {
+		t.Errorf("want key type %q, got %q", want, got)
+	}
+	if len(revoked.PublicKey) != 0 {
+		t.Errorf("want revoked key, got %x", revoked.PublicKey)
+	}
+
+	if _, err := ParseDKIM(txtAnswers([]string{"v=DKIM1; p=not base64!"})); err != errInvalidTXTRecord {
+		t.Errorf("want error %v, got %v", errInvalidTXTRecord, err)
+	}
+}
+
+func TestParseDMARC(t *testing.T) {
+	t.Parallel()
+
+	dmarc, err := ParseDMARC(txtAnswers([]string{"v=DMARC1; p=Reject; pct=50; rua=mailto:a@example.com,mailto:b@example.com; aspf=s"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &DMARC{
+		Policy:          "reject",
+		SubdomainPolicy: "reject",
+		Percent:         50,
+		AggregateURIs:   []string{"mailto:a@example.com", "mailto:b@example.com"},
+		DKIMAlignment:   "r",
+		SPFAlignment:    "s",
+		Tags:            dmarc.Tags,
+	}
+	if !reflect.DeepEqual(want, dmarc) {
+		t.Errorf("want DMARC %+v, got %+v", want, dmarc)
+	}
+
+	if _, err := ParseDMARC(txtAnswers([]string{"p=none; v=DMARC1"})); err != errNoTXTRecord {
+		t.Errorf("want error %v, got %v", errNoTXTRecord, err)
+	}
+	if _, err := ParseDMARC(txtAnswers([]string{"v=DMARC1; p=allow"})); err != errInvalidTXTRecord {
+		t.Errorf("want error %v, got %v", errInvalidTXTRecord, err)
+	}
+}
@@ -10,6 +10,7 @@ var Events = [...]string{
 	"deleteKey",
 	"deleteKeyInRecord",
 	"appendKeyInRecord",
+	"txn",
 }
 
 func (el Event) String() string {
@@ -23,4 +24,26 @@ const (
 	KEventDeleteKey
 	KEventDeleteKeyInRecord
 	KEventAppendKeyInRecord
+	KEventTxn
 )
+
+// ChangeEvent describes a single change made to an RRSet.
+type ChangeEvent struct {
+	// Event is the kind of change.
+	Event Event
+
+	// Key is the record name that changed. It is empty for KEventClear and
+	// KEventSet, which replace the whole set.
+	Key string
+
+	// Type is the type of the record appended or deleted by
+	// KEventAppendKeyInRecord and KEventDeleteKeyInRecord, TypeANY otherwise.
+	Type Type
+
+	// Old and New are the records of Key before and after the change.
+	Old, New map[Type][]Record
+
+	// Changes holds one KEventSetKey or KEventDeleteKey event per key
+	// changed by a KEventTxn transaction.
+	Changes []ChangeEvent
+}
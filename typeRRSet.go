@@ -1,17 +1,108 @@
 package dns
 
 import (
-	"reflect"
+	"context"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// watchBuffer is the number of events buffered for each RRSet watcher.
+const watchBuffer = 64
+
+// RRStore is the set of operations on stored resource records. It is
+// implemented by RRSet and ShardedRRSet.
+type RRStore interface {
+	Clear()
+	Set(map[string]map[Type][]Record)
+	SetKey(string, map[Type][]Record)
+	Len() int
+	GetKey(string) (map[Type][]Record, bool)
+	DeleteKey(string)
+	DeleteRecordInKey(string, Record)
+	AppendRecordInKey(string, Record)
+	GetAll() map[string]map[Type][]Record
+	Txn(func(*RRSetTxn) error) error
+	Range(func(string, Type, Record) bool)
+	AppendEntryInKey(string, *RREntry)
+	AppendLeaseInKey(string, Record, time.Duration)
+	AppendClassRecordInKey(string, Class, Record)
+	GetEntries(string, Type) []*RREntry
+	GetRecords(string, Type) []Record
+	Names() []string
+	CountByType() map[Type]int
+	Stats() RRSetStats
+	DumpText() string
+	Watch(context.Context) <-chan ChangeEvent
+	SetBeforeOnClear(func(map[string]map[Type][]Record))
+	SetBeforeOnChange(func(Event, string, interface{}, interface{}))
+	SetBeforeOnSetKey(func(string, map[Type][]Record, map[Type][]Record))
+	SetBeforeDeleteKey(func(string, map[Type][]Record))
+	SetBeforeOnDeleteKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
+	SetBeforeOnAppendKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
+	SetOnClear(func(map[string]map[Type][]Record))
+	SetOnChange(func(Event, string, interface{}, interface{}))
+	SetOnSetKey(func(string, map[Type][]Record, map[Type][]Record))
+	SetOnDeleteKey(func(string, map[Type][]Record))
+	SetOnDeleteKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
+	SetOnAppendKeyInRecord(func(string, map[Type][]Record, map[Type][]Record))
+}
+
+// RREntry is a record stored in an RRSet along with its metadata. An *RREntry
+// is itself a Record, so it can be stored and retrieved wherever records are.
+type RREntry struct {
+	Record
+
+	// TTL overrides the zone TTL of the record when non-zero.
+	TTL time.Duration
+
+	// Expires, when non-zero, is the time at which the entry is removed
+	// from the RRSet it was written to.
+	Expires time.Time
+
+	// Class is the class of the record, such as ClassCH for the CHAOS
+	// records of a server. If zero, the record is of class IN. A Zone
+	// answers the record to the questions of its class, or of class ANY.
+	Class Class
+
+	Meta RRMeta
+}
+
+// RRMeta is the metadata of an RREntry.
+type RRMeta struct {
+	Comment string // free form description
+	Source  string // origin of the record, such as a file or a service
+
+	// Unhealthy records are kept in the RRSet but not used in answers.
+	Unhealthy bool
+
+	// Region is the region of the clients the record is answered to by a
+	// GeoSelector. Records without a region are answered to all clients.
+	Region string
+}
+
+// unwrapRecord returns the record stored in r and its TTL override, if any.
+func unwrapRecord(r Record) (Record, time.Duration) {
+	if e, ok := r.(*RREntry); ok {
+		return e.Record, e.TTL
+	}
+	return r, 0
+}
+
 // RRSet is a set of resource records indexed by record name and record type.
-// RRSet is a thread type safe, preventing more than one operation from being made per time on map type
+// RRSet is a thread type safe, preventing more than one operation from being made per time on map type.
+// Reads may run concurrently and return copies that are safe to modify.
 type RRSet struct {
-	l    sync.Mutex
+	l    sync.RWMutex
 	m    map[string]map[Type][]Record
 	init bool
 
+	watchers map[chan ChangeEvent]chan struct{}
+
+	mutations  uint64
+	lastChange time.Time
+
 	onClear             func(old map[string]map[Type][]Record)
 	onSet               func(old map[string]map[Type][]Record, new map[string]map[Type][]Record)
 	onChange            func(event Event, k string, old, new interface{})
@@ -29,6 +120,14 @@ type RRSet struct {
 	beforeOnAppendKeyInRecord func(k string, old map[Type][]Record, new map[Type][]Record)
 }
 
+// NewRRSet returns an RRSet holding the records in v.
+func NewRRSet(v map[string]map[Type][]Record) *RRSet {
+	rrs := new(RRSet)
+	rrs.Set(v)
+
+	return rrs
+}
+
 func (el *RRSet) SetBeforeOnClear(v func(map[string]map[Type][]Record)) {
 	el.beforeOnClear = v
 }
@@ -85,19 +184,20 @@ func (el *RRSet) deferOnClear(v map[string]map[Type][]Record) {
 
 func (el *RRSet) deferOnChange(event Event, k string, old interface{}) {
 	if el.onChange != nil {
-		el.onChange(event, k, old, el.m)
+		el.onChange(event, k, old, el.GetAll())
 	}
 }
 
 func (el *RRSet) deferOnSet(old map[string]map[Type][]Record) {
 	if el.onSet != nil {
-		el.onSet(old, el.m)
+		el.onSet(old, el.GetAll())
 	}
 }
 
 func (el *RRSet) deferOnSetKey(k string, old map[Type][]Record) {
 	if el.onSetKey != nil {
-		el.onSetKey(k, old, el.m[k])
+		new, _ := el.GetKey(k)
+		el.onSetKey(k, old, new)
 	}
 }
 
@@ -109,14 +209,98 @@ func (el *RRSet) deferDeleteKey(k string, old map[Type][]Record) {
 
 func (el *RRSet) deferOnDeleteKeyInRecord(k string, old map[Type][]Record) {
 	if el.onDeleteKeyInRecord != nil {
-		el.onDeleteKeyInRecord(k, old, el.m[k])
+		new, _ := el.GetKey(k)
+		el.onDeleteKeyInRecord(k, old, new)
 	}
 }
 
 func (el *RRSet) deferOnAppendKeyInRecord(k string, old map[Type][]Record) {
 	if el.onAppendKeyInRecord != nil {
-		el.onAppendKeyInRecord(k, old, el.m[k])
+		new, _ := el.GetKey(k)
+		el.onAppendKeyInRecord(k, old, new)
+	}
+}
+
+// Watch returns a channel that receives a ChangeEvent for every change made to
+// the RRSet until ctx is done, after which the channel is closed.
+//
+// Events are delivered without blocking writers. A watcher that falls more
+// than watchBuffer events behind is dropped and its channel is closed; it
+// should call GetAll and Watch again to resynchronize.
+func (el *RRSet) Watch(ctx context.Context) <-chan ChangeEvent {
+	c := make(chan ChangeEvent, watchBuffer)
+	stop := make(chan struct{})
+
+	el.l.Lock()
+	if el.watchers == nil {
+		el.watchers = make(map[chan ChangeEvent]chan struct{})
 	}
+	el.watchers[c] = stop
+	el.l.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+
+		el.l.Lock()
+		defer el.l.Unlock()
+
+		if _, ok := el.watchers[c]; ok {
+			delete(el.watchers, c)
+			close(c)
+		}
+	}()
+
+	return c
+}
+
+// el.l held
+func (el *RRSet) publish(event Event, k string, t Type, old map[Type][]Record) {
+	el.touch()
+
+	if len(el.watchers) == 0 {
+		return
+	}
+
+	ev := ChangeEvent{
+		Event: event,
+		Key:   k,
+		Type:  t,
+	}
+	if k != "" {
+		ev.Old = copyRecords(old)
+		ev.New = copyRecords(el.m[k])
+	}
+
+	el.send(ev)
+}
+
+// el.l held
+func (el *RRSet) send(ev ChangeEvent) {
+	for c, stop := range el.watchers {
+		select {
+		case c <- ev:
+		default:
+			delete(el.watchers, c)
+			close(c)
+			close(stop)
+		}
+	}
+}
+
+func copyRecords(v map[Type][]Record) map[Type][]Record {
+	if v == nil {
+		return nil
+	}
+
+	cp := make(map[Type][]Record, len(v))
+	for t, rrs := range v {
+		cp[t] = append([]Record(nil), rrs...)
+	}
+	return cp
 }
 
 // Clear RRSet
@@ -139,6 +323,7 @@ func (el *RRSet) Clear() {
 	defer el.deferOnClear(old)
 	defer el.deferOnChange(KEventClear, "", old)
 	defer el.l.Unlock()
+	defer el.publish(KEventClear, "", TypeANY, nil)
 
 	if el.beforeOnClear != nil {
 		el.beforeOnClear(el.m)
@@ -173,6 +358,7 @@ func (el *RRSet) Set(v map[string]map[Type][]Record) {
 	defer el.deferOnSet(old)
 	defer el.deferOnChange(KEventSet, "", old)
 	defer el.l.Unlock()
+	defer el.publish(KEventSet, "", TypeANY, nil)
 
 	if el.beforeOnSet != nil {
 		el.beforeOnSet(old, v)
@@ -182,11 +368,66 @@ func (el *RRSet) Set(v map[string]map[Type][]Record) {
 		el.beforeOnChange(KEventSet, "", old, v)
 	}
 
-	el.m = v
+	el.m = normalizeKeys(v)
+	if el.m == nil {
+		el.m = make(map[string]map[Type][]Record)
+	}
+	for k, rrsByType := range el.m {
+		el.expireNew(k, old[k], rrsByType)
+	}
+}
+
+// NormalizeKey returns the canonical form of an RRSet key: record names are
+// case-insensitive and may be written with or without a trailing dot. All
+// RRSet operations normalize their keys.
+func NormalizeKey(k string) string {
+	k = strings.TrimSuffix(k, ".")
+
+	for i := 0; i < len(k); i++ {
+		if 'A' <= k[i] && k[i] <= 'Z' {
+			b := []byte(k)
+			for j := i; j < len(b); j++ {
+				if 'A' <= b[j] && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return k
+}
+
+// normalizeKeys returns v with normalized keys, merging the records of keys
+// that only differ by case.
+func normalizeKeys(v map[string]map[Type][]Record) map[string]map[Type][]Record {
+	normalized := true
+	for k := range v {
+		if NormalizeKey(k) != k {
+			normalized = false
+			break
+		}
+	}
+	if normalized {
+		return v
+	}
+
+	m := make(map[string]map[Type][]Record, len(v))
+	for k, rrsByType := range v {
+		k = NormalizeKey(k)
+		if m[k] == nil {
+			m[k] = make(map[Type][]Record, len(rrsByType))
+		}
+		for t, rrs := range rrsByType {
+			m[k][t] = append(m[k][t], rrs...)
+		}
+	}
+	return m
 }
 
 // Set a new record on given key
 func (el *RRSet) SetKey(k string, v map[Type][]Record) {
+	k = NormalizeKey(k)
+
 	el.l.Lock()
 
 	var old = make(map[Type][]Record)
@@ -197,6 +438,7 @@ func (el *RRSet) SetKey(k string, v map[Type][]Record) {
 	defer el.deferOnSetKey(k, old)
 	defer el.deferOnChange(KEventSetKey, k, old)
 	defer el.l.Unlock()
+	defer el.publish(KEventSetKey, k, TypeANY, old)
 
 	if el.init == false {
 		el.init = true
@@ -212,28 +454,33 @@ func (el *RRSet) SetKey(k string, v map[Type][]Record) {
 	}
 
 	el.m[k] = v
+	el.expireNew(k, old, v)
 }
 
 // Get length
 func (el *RRSet) Len() int {
-	el.l.Lock()
-	defer el.l.Unlock()
+	el.l.RLock()
+	defer el.l.RUnlock()
 
 	return len(el.m)
 }
 
-// Get record by given key
+// Get a copy of the records by given key
 func (el *RRSet) GetKey(k string) (map[Type][]Record, bool) {
-	el.l.Lock()
-	defer el.l.Unlock()
+	k = NormalizeKey(k)
+
+	el.l.RLock()
+	defer el.l.RUnlock()
 
 	r, ok := el.m[k]
 
-	return r, ok
+	return copyRecords(r), ok
 }
 
 // Delete record by given key
 func (el *RRSet) DeleteKey(k string) {
+	k = NormalizeKey(k)
+
 	el.l.Lock()
 
 	var old = make(map[Type][]Record)
@@ -244,6 +491,7 @@ func (el *RRSet) DeleteKey(k string) {
 	defer el.deferDeleteKey(k, old)
 	defer el.deferOnChange(KEventDeleteKey, k, old)
 	defer el.l.Unlock()
+	defer el.publish(KEventDeleteKey, k, TypeANY, old)
 
 	if el.onDeleteKey != nil {
 		el.onDeleteKey(k, old)
@@ -257,222 +505,301 @@ func (el *RRSet) DeleteKey(k string) {
 }
 
 // Delete record inside a given key
+// The first record of the same type that is Equal to r is removed. The key is
+// deleted once it holds no more records.
 func (el *RRSet) DeleteRecordInKey(k string, r Record) {
+	el.deleteRecordInKey(k, r.Type(), r.Equal, false)
+}
+
+// deleteRecordInKey removes the first record of type t that matches. If
+// ifFound is set and no record matches, the RRSet is left untouched and no
+// callback or event fires.
+func (el *RRSet) deleteRecordInKey(k string, t Type, match func(Record) bool, ifFound bool) {
+	k = NormalizeKey(k)
+
 	el.l.Lock()
 
+	New, found := deleteRecord(el.m[k], t, match)
+	if ifFound && !found {
+		el.l.Unlock()
+		return
+	}
+
+	if el.init == false {
+		el.init = true
+		el.m = make(map[string]map[Type][]Record)
+	}
+
 	var old = make(map[Type][]Record)
 	for k, v := range el.m[k] {
 		old[k] = v
 	}
 
-	New := el.m[k]
-	recordData := r.Get()
-
 	defer el.deferOnDeleteKeyInRecord(k, old)
 	defer el.deferOnChange(KEventDeleteKeyInRecord, k, old)
 	defer el.l.Unlock()
+	defer el.publish(KEventDeleteKeyInRecord, k, t, old)
 
-	for rListType, rListValue := range old {
-		if rListType == r.Type() {
-			switch rListType {
-			case TypeA:
-
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	if el.beforeOnDeleteKeyInRecord != nil {
+		el.beforeOnDeleteKeyInRecord(k, old, New)
+	}
 
-					if recordData.(*A).A.Equal(listRecordData.(*A).A) {
-						New[TypeA] = append(New[TypeA][:i], New[TypeA][i+1:]...)
-						return
-					}
-				}
+	if el.beforeOnChange != nil {
+		el.beforeOnChange(KEventDeleteKeyInRecord, k, old, New)
+	}
 
-			case TypeNS:
+	if len(New) == 0 {
+		delete(el.m, k)
+		return
+	}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	el.m[k] = New
+}
 
-					if recordData.(*NS).NS == listRecordData.(*NS).NS {
-						New[TypeNS] = append(New[TypeNS][:i], New[TypeNS][i+1:]...)
-						return
-					}
+// deleteRecord returns a copy of rrsByType without the first record of type t
+// that matches and without empty types, and whether a record matched.
+func deleteRecord(rrsByType map[Type][]Record, t Type, match func(Record) bool) (map[Type][]Record, bool) {
+	var found bool
+
+	New := make(map[Type][]Record, len(rrsByType))
+	for rListType, rListValue := range rrsByType {
+		if rListType == t && !found {
+			for i, v := range rListValue {
+				if match(v) {
+					rListValue = append(append([]Record(nil), rListValue[:i]...), rListValue[i+1:]...)
+					found = true
+					break
 				}
+			}
+		}
 
-			case TypeCNAME:
+		if len(rListValue) != 0 {
+			New[rListType] = rListValue
+		}
+	}
+	return New, found
+}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+func (el *RRSet) AppendRecordInKey(k string, r Record) {
+	k = NormalizeKey(k)
 
-					if recordData.(*CNAME).CNAME == listRecordData.(*CNAME).CNAME {
-						New[TypeCNAME] = append(New[TypeCNAME][:i], New[TypeCNAME][i+1:]...)
-						return
-					}
-				}
+	el.l.Lock()
 
-			case TypeSOA:
+	if el.init == false {
+		el.init = true
+		el.m = make(map[string]map[Type][]Record)
+	}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	var old = make(map[Type][]Record)
+	for k, v := range el.m[k] {
+		old[k] = v
+	}
 
-					if recordData.(*SOA).Serial == listRecordData.(*SOA).Serial {
-						New[TypeSOA] = append(New[TypeSOA][:i], New[TypeSOA][i+1:]...)
-						return
-					}
-				}
+	New := copyRecords(el.m[k])
+	if New == nil {
+		New = make(map[Type][]Record)
+	}
 
-			case TypePTR:
+	defer el.deferOnAppendKeyInRecord(k, old)
+	defer el.deferOnChange(KEventAppendKeyInRecord, k, old)
+	defer el.l.Unlock()
+	defer el.publish(KEventAppendKeyInRecord, k, r.Type(), old)
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	rType := r.Type()
+	if e, ok := r.(*RREntry); ok && !e.Expires.IsZero() {
+		New, _ = deleteRecord(New, rType, r.Equal)
+		el.expireAt(k, e)
+	}
+	New[rType] = append(New[rType], r)
 
-					if recordData.(*PTR).PTR == listRecordData.(*PTR).PTR {
-						New[TypePTR] = append(New[TypePTR][:i], New[TypePTR][i+1:]...)
-						return
-					}
-				}
+	if el.beforeOnAppendKeyInRecord != nil {
+		el.beforeOnAppendKeyInRecord(k, old, New)
+	}
 
-			case TypeMX:
+	if el.beforeOnChange != nil {
+		el.beforeOnChange(KEventClear, k, old, New)
+	}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	el.m[k] = New
+}
 
-					if recordData.(*MX).MX == listRecordData.(*MX).MX {
-						New[TypeMX] = append(New[TypeMX][:i], New[TypeMX][i+1:]...)
-						return
-					}
-				}
+// Get a copy of all records
+func (el *RRSet) GetAll() map[string]map[Type][]Record {
+	el.l.RLock()
+	defer el.l.RUnlock()
 
-			case TypeTXT:
+	if el.m == nil {
+		return nil
+	}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	all := make(map[string]map[Type][]Record, len(el.m))
+	for k, v := range el.m {
+		all[k] = copyRecords(v)
+	}
+	return all
+}
 
-					if reflect.DeepEqual(recordData.(*TXT).TXT, listRecordData.(*TXT).TXT) {
-						New[TypeTXT] = append(New[TypeTXT][:i], New[TypeTXT][i+1:]...)
-						return
-					}
-				}
+// Range calls f for each record in the set, ordered by name and type, until f
+// returns false. f is called on a snapshot of the set and may modify the RRSet.
+func (el *RRSet) Range(f func(name string, t Type, r Record) bool) {
+	rangeRecords(el.GetAll(), f)
+}
 
-			case TypeAAAA:
+func rangeRecords(all map[string]map[Type][]Record, f func(string, Type, Record) bool) {
+	for _, name := range sortedNames(all) {
+		rrsByType := all[name]
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+		types := make([]Type, 0, len(rrsByType))
+		for t := range rrsByType {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
 
-					if recordData.(*AAAA).AAAA.Equal(listRecordData.(*AAAA).AAAA) {
-						New[TypeAAAA] = append(New[TypeAAAA][:i], New[TypeAAAA][i+1:]...)
-						return
-					}
+		for _, t := range types {
+			for _, r := range rrsByType[t] {
+				if !f(name, t, r) {
+					return
 				}
+			}
+		}
+	}
+}
 
-			case TypeSRV:
-
-				for i, v := range rListValue {
-					listRecordData := v.Get()
-
-					if recordData.(*SRV).Target == listRecordData.(*SRV).Target {
-						New[TypeSRV] = append(New[TypeSRV][:i], New[TypeSRV][i+1:]...)
-						return
-					}
-				}
+// Get a copy of the records of a type by given key
+func (el *RRSet) GetRecords(name string, t Type) []Record {
+	name = NormalizeKey(name)
 
-			case TypeDNAME:
+	el.l.RLock()
+	defer el.l.RUnlock()
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	rrs := el.m[name][t]
+	if len(rrs) == 0 {
+		return nil
+	}
+	return append([]Record(nil), rrs...)
+}
 
-					if recordData.(*DNAME).DNAME == listRecordData.(*DNAME).DNAME {
-						New[TypeDNAME] = append(New[TypeDNAME][:i], New[TypeDNAME][i+1:]...)
-						return
-					}
-				}
+// Append a record with metadata inside a given key
+func (el *RRSet) AppendEntryInKey(k string, e *RREntry) {
+	el.AppendRecordInKey(k, e)
+}
 
-			case TypeOPT:
+// Append a record that is removed once lease elapses
+// A record of the key Equal to r is replaced, so appending the same record again
+// renews its lease. The removal fires the KEventDeleteKeyInRecord callbacks and
+// events. If r is an *RREntry it is copied and its Expires is overwritten.
+func (el *RRSet) AppendLeaseInKey(k string, r Record, lease time.Duration) {
+	e := &RREntry{Record: r}
+	if re, ok := r.(*RREntry); ok {
+		cp := *re
+		e = &cp
+	}
+	e.Expires = time.Now().Add(lease)
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+	el.AppendRecordInKey(k, e)
+}
 
-					if reflect.DeepEqual(recordData.(*OPT).Options, listRecordData.(*OPT).Options) {
-						New[TypeOPT] = append(New[TypeOPT][:i], New[TypeOPT][i+1:]...)
-						return
-					}
-				}
+// Append a record of a class inside a given key
+// The record is stored as an RREntry with the class. If r is an *RREntry it is
+// copied and its Class is overwritten.
+func (el *RRSet) AppendClassRecordInKey(k string, class Class, r Record) {
+	el.AppendRecordInKey(k, classEntry(class, r))
+}
 
-			case TypeCAA:
+func classEntry(class Class, r Record) *RREntry {
+	e := &RREntry{Record: r}
+	if re, ok := r.(*RREntry); ok {
+		cp := *re
+		e = &cp
+	}
+	e.Class = class
+	return e
+}
 
-				for i, v := range rListValue {
-					listRecordData := v.Get()
+// expireAt removes e from the key at e.Expires, unless it was removed first.
+func (el *RRSet) expireAt(k string, e *RREntry) {
+	time.AfterFunc(time.Until(e.Expires), func() {
+		el.deleteRecordInKey(k, e.Type(), func(r Record) bool { return r == Record(e) }, true)
+	})
+}
 
-					if recordData.(*CAA).Value == listRecordData.(*CAA).Value && recordData.(*CAA).Tag == listRecordData.(*CAA).Tag {
-						New[TypeCAA] = append(New[TypeCAA][:i], New[TypeCAA][i+1:]...)
-						return
-					}
-				}
+// expireNew arms the expiry of the entries of New that are not in old.
+//
+// el.l held
+func (el *RRSet) expireNew(k string, old, New map[Type][]Record) {
+	for t, rrs := range New {
+		for _, r := range rrs {
+			e, ok := r.(*RREntry)
+			if !ok || e.Expires.IsZero() || containsRecord(old[t], r) {
+				continue
 			}
+			el.expireAt(k, e)
 		}
 	}
+}
 
-	pass := false
-	for k := range New {
-		if len(New[k]) != 0 {
-			pass = true
-			break
+func containsRecord(rrs []Record, r Record) bool {
+	for _, rr := range rrs {
+		if rr == r {
+			return true
 		}
 	}
-	if pass == false {
-		el.DeleteKey(k)
-	}
-
-	if el.beforeOnDeleteKeyInRecord != nil {
-		el.beforeOnDeleteKeyInRecord(k, old, New)
-	}
-
-	if el.beforeOnChange != nil {
-		el.beforeOnChange(KEventClear, k, old, New)
-	}
-
-	el.m[k] = New
+	return false
 }
 
-func (el *RRSet) AppendRecordInKey(k string, r Record) {
-	el.l.Lock()
-
-	if el.init == false {
-		el.init = true
-		el.m = make(map[string]map[Type][]Record)
+// Get the records of a type by given key with their metadata
+// Records appended without metadata are returned in an entry with zero TTL and Meta.
+func (el *RRSet) GetEntries(name string, t Type) []*RREntry {
+	rrs := el.GetRecords(name, t)
+	if rrs == nil {
+		return nil
 	}
 
-	var old = make(map[Type][]Record)
-	for k, v := range el.m[k] {
-		old[k] = v
+	entries := make([]*RREntry, 0, len(rrs))
+	for _, r := range rrs {
+		e, ok := r.(*RREntry)
+		if !ok {
+			e = &RREntry{Record: r}
+		}
+		entries = append(entries, e)
 	}
+	return entries
+}
 
-	New := el.m[k]
+// Get all keys in sorted order
+func (el *RRSet) Names() []string {
+	el.l.RLock()
+	defer el.l.RUnlock()
 
-	defer el.deferOnAppendKeyInRecord(k, old)
-	defer el.deferOnChange(KEventAppendKeyInRecord, k, old)
-	defer el.l.Unlock()
+	return sortedNames(el.m)
+}
 
-	rType := r.Type()
-	if len(New[rType]) == 0 {
-		New = map[Type][]Record{rType: {r}}
-	} else {
-		New[rType] = append(New[rType], r)
-	}
+// Get the number of records of each type
+func (el *RRSet) CountByType() map[Type]int {
+	el.l.RLock()
+	defer el.l.RUnlock()
 
-	if el.beforeOnAppendKeyInRecord != nil {
-		el.beforeOnAppendKeyInRecord(k, old, New)
-	}
+	return countByType(el.m)
+}
 
-	if el.beforeOnChange != nil {
-		el.beforeOnChange(KEventClear, k, old, New)
+func countByType(m map[string]map[Type][]Record) map[Type]int {
+	counts := make(map[Type]int)
+	for _, rrsByType := range m {
+		for t, rrs := range rrsByType {
+			if len(rrs) > 0 {
+				counts[t] += len(rrs)
+			}
+		}
 	}
-
-	el.m[k] = New
+	return counts
 }
 
-// Get all records
-func (el *RRSet) GetAll() map[string]map[Type][]Record {
-	el.l.Lock()
-	defer el.l.Unlock()
+func sortedNames(m map[string]map[Type][]Record) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	return el.m
+	return names
 }
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MarshalJSON encodes the RRSet as an object of record names, each holding an
+// object of record type names, such as "TypeA", to the JSON form of records.
+// An *RREntry is encoded as an object holding the JSON form of its record in
+// "Record" along with its TTL, Expires, Class and Meta.
+func (el *RRSet) MarshalJSON() ([]byte, error) {
+	return marshalRecords(el.GetAll())
+}
+
+// UnmarshalJSON replaces the records of the RRSet with the ones decoded from
+// the form written by MarshalJSON.
+func (el *RRSet) UnmarshalJSON(b []byte) error {
+	all, err := unmarshalRecords(b)
+	if err != nil {
+		return err
+	}
+
+	el.Set(all)
+	return nil
+}
+
+func marshalRecords(all map[string]map[Type][]Record) ([]byte, error) {
+	v := make(map[string]map[string][]json.RawMessage, len(all))
+	for name, rrsByType := range all {
+		rrs := make(map[string][]json.RawMessage, len(rrsByType))
+		for t, records := range rrsByType {
+			if t.String() == "" {
+				return nil, errUnknownType
+			}
+
+			for _, r := range records {
+				raw, err := marshalRecord(r)
+				if err != nil {
+					return nil, err
+				}
+				rrs[t.String()] = append(rrs[t.String()], raw)
+			}
+		}
+		v[name] = rrs
+	}
+
+	return json.Marshal(v)
+}
+
+// entryJSON is the JSON form of an *RREntry.
+type entryJSON struct {
+	Record  json.RawMessage
+	TTL     time.Duration `json:",omitempty"`
+	Expires *time.Time    `json:",omitempty"`
+	Class   Class         `json:",omitempty"`
+	Meta    *RRMeta       `json:",omitempty"`
+}
+
+func marshalRecord(r Record) (json.RawMessage, error) {
+	e, ok := r.(*RREntry)
+	if !ok {
+		return json.RawMessage(r.String()), nil
+	}
+
+	v := entryJSON{
+		Record: json.RawMessage(e.Record.String()),
+		TTL:    e.TTL,
+		Class:  e.Class,
+	}
+	if !e.Expires.IsZero() {
+		v.Expires = &e.Expires
+	}
+	if e.Meta != (RRMeta{}) {
+		v.Meta = &e.Meta
+	}
+	return json.Marshal(v)
+}
+
+func unmarshalRecord(t Type, raw json.RawMessage) (Record, error) {
+	var v entryJSON
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v.Record == nil {
+		r := NewRecordByType[t]()
+		if err := r.FromJSon(string(raw)); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	r := NewRecordByType[t]()
+	if err := r.FromJSon(string(v.Record)); err != nil {
+		return nil, err
+	}
+
+	e := &RREntry{Record: r, TTL: v.TTL, Class: v.Class}
+	if v.Expires != nil {
+		e.Expires = *v.Expires
+	}
+	if v.Meta != nil {
+		e.Meta = *v.Meta
+	}
+	return e, nil
+}
+
+func unmarshalRecords(b []byte) (map[string]map[Type][]Record, error) {
+	var v map[string]map[string][]json.RawMessage
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]map[Type][]Record, len(v))
+	for name, rrs := range v {
+		rrsByType := make(map[Type][]Record, len(rrs))
+		for typeName, records := range rrs {
+			t, ok := typeByName(typeName)
+			if !ok {
+				return nil, errUnknownType
+			}
+
+			for _, raw := range records {
+				r, err := unmarshalRecord(t, raw)
+				if err != nil {
+					return nil, err
+				}
+				rrsByType[t] = append(rrsByType[t], r)
+			}
+		}
+		all[name] = rrsByType
+	}
+
+	return all, nil
+}
+
+func typeByName(name string) (Type, bool) {
+	for t := range NewRecordByType {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+type zoneJSON struct {
+	Origin string
+	TTL    time.Duration
+
+	SOA *SOA
+
+	RRs json.RawMessage
+}
+
+// MarshalJSON encodes the zone, including all of its records.
+func (z *Zone) MarshalJSON() ([]byte, error) {
+	rrs, err := marshalRecords(z.store().GetAll())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(zoneJSON{
+		Origin: z.Origin,
+		TTL:    z.TTL,
+		SOA:    z.SOA,
+		RRs:    rrs,
+	})
+}
+
+// UnmarshalJSON decodes the zone from the form written by MarshalJSON.
+func (z *Zone) UnmarshalJSON(b []byte) error {
+	var v zoneJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	if len(v.RRs) > 0 {
+		all, err := unmarshalRecords(v.RRs)
+		if err != nil {
+			return err
+		}
+		z.store().Set(all)
+	}
+
+	z.Origin = v.Origin
+	z.TTL = v.TTL
+	z.SOA = v.SOA
+
+	return nil
+}
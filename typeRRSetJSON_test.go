@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZoneJSON(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "localhost.",
+		TTL:    time.Hour,
+		SOA: &SOA{
+			NS:     "dns.localhost.",
+			MBox:   "hostmaster.localhost.",
+			Serial: 42,
+		},
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	zone.AppendRecordInKey("app", &AAAA{AAAA: net.ParseIP("dead:beef::1")})
+	zone.AppendRecordInKey("mail", &MX{Pref: 10, MX: "mx.localhost."})
+	zone.AppendRecordInKey("mail", &TXT{TXT: []string{"v=spf1 -all"}})
+
+	b, err := json.Marshal(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(Zone)
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := zone.Origin, got.Origin; want != got {
+		t.Errorf("want origin %q, got %q", want, got)
+	}
+	if want, got := zone.TTL, got.TTL; want != got {
+		t.Errorf("want TTL %s, got %s", want, got)
+	}
+	if want, got := zone.SOA.Key(), got.SOA.Key(); want != got {
+		t.Errorf("want SOA %q, got %q", want, got)
+	}
+
+	zone.Range(func(name string, typ Type, r Record) bool {
+		rrs := got.GetRecords(name, typ)
+		if len(rrs) == 0 {
+			t.Errorf("missing %s %s records", name, typ)
+			return true
+		}
+
+		var found bool
+		for _, rr := range rrs {
+			found = found || rr.Equal(r)
+		}
+		if !found {
+			t.Errorf("missing %s %s record %s", name, typ, r.Key())
+		}
+		return true
+	})
+	if want, got := zone.CountByType(), got.CountByType(); len(want) != len(got) {
+		t.Errorf("want record counts %v, got %v", want, got)
+	}
+}
+
+func TestRRSetEntryJSON(t *testing.T) {
+	t.Parallel()
+
+	expires := time.Now().Add(time.Hour).Round(0)
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	rrs.AppendEntryInKey("app", &RREntry{
+		Record:  &A{A: net.IPv4(10, 0, 0, 2).To4()},
+		TTL:     time.Minute,
+		Expires: expires,
+		Class:   ClassCH,
+		Meta: RRMeta{
+			Comment:   "canary",
+			Source:    "deploy",
+			Unhealthy: true,
+		},
+	})
+
+	b, err := json.Marshal(&rrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got RRSet
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := got.GetEntries("app", TypeA)
+	if want, got := 2, len(entries); want != got {
+		t.Fatalf("want %d entries, got %d", want, got)
+	}
+	for _, e := range entries {
+		switch e.Record.Key() {
+		case "10.0.0.1":
+			if want, got := (RREntry{Record: e.Record}), *e; !reflect.DeepEqual(want, got) {
+				t.Errorf("want plain entry %+v, got %+v", want, got)
+			}
+		case "10.0.0.2":
+			if want, got := time.Minute, e.TTL; want != got {
+				t.Errorf("want TTL %s, got %s", want, got)
+			}
+			if want, got := expires, e.Expires; !want.Equal(got) {
+				t.Errorf("want expires %s, got %s", want, got)
+			}
+			if want, got := ClassCH, e.Class; want != got {
+				t.Errorf("want class %d, got %d", want, got)
+			}
+			if want, got := (RRMeta{Comment: "canary", Source: "deploy", Unhealthy: true}), e.Meta; want != got {
+				t.Errorf("want meta %+v, got %+v", want, got)
+			}
+		default:
+			t.Errorf("unexpected record %s", e.Record.Key())
+		}
+	}
+}
+
+func TestRRSetUnmarshalJSONUnknownType(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	if err := json.Unmarshal([]byte(`{"app":{"TypeBOGUS":[{}]}}`), &rrs); err != errUnknownType {
+		t.Errorf("want error %v, got %v", errUnknownType, err)
+	}
+}
@@ -0,0 +1,310 @@
+package dns
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultShards is the number of shards of a ShardedRRSet with zero Shards.
+const defaultShards = 32
+
+// ShardedRRSet is an RRStore that spreads records over independently locked
+// RRSet shards by a hash of the record name, so that updates of different
+// names do not contend for a single lock. It suits sets with many names and
+// frequent updates, such as service discovery backends.
+//
+// Operations on a single key behave as on an RRSet. Clear and Set are applied
+// shard by shard and are not atomic; Txn locks every shard and is atomic.
+// Callbacks are registered on, and called by, each shard.
+type ShardedRRSet struct {
+	// Shards is the number of shards, defaultShards if zero. It must not be
+	// changed once the set is in use.
+	Shards int
+
+	inito  sync.Once
+	shards []RRSet
+}
+
+func (s *ShardedRRSet) init() {
+	s.inito.Do(func() {
+		n := s.Shards
+		if n <= 0 {
+			n = defaultShards
+		}
+		s.shards = make([]RRSet, n)
+	})
+}
+
+func (s *ShardedRRSet) index(k string) int {
+	s.init()
+
+	h := fnv.New32a()
+	h.Write([]byte(NormalizeKey(k)))
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+func (s *ShardedRRSet) shard(k string) *RRSet {
+	return &s.shards[s.index(k)]
+}
+
+func (s *ShardedRRSet) each(f func(*RRSet)) {
+	s.init()
+
+	for i := range s.shards {
+		f(&s.shards[i])
+	}
+}
+
+func (s *ShardedRRSet) Clear() {
+	s.each((*RRSet).Clear)
+}
+
+func (s *ShardedRRSet) Set(v map[string]map[Type][]Record) {
+	s.init()
+
+	parts := make([]map[string]map[Type][]Record, len(s.shards))
+	for i := range parts {
+		parts[i] = make(map[string]map[Type][]Record)
+	}
+	for k, rrsByType := range normalizeKeys(v) {
+		parts[s.index(k)][k] = rrsByType
+	}
+
+	for i := range s.shards {
+		s.shards[i].Set(parts[i])
+	}
+}
+
+func (s *ShardedRRSet) SetKey(k string, v map[Type][]Record) {
+	s.shard(k).SetKey(k, v)
+}
+
+func (s *ShardedRRSet) Len() int {
+	var n int
+	s.each(func(rrs *RRSet) { n += rrs.Len() })
+	return n
+}
+
+func (s *ShardedRRSet) GetKey(k string) (map[Type][]Record, bool) {
+	return s.shard(k).GetKey(k)
+}
+
+func (s *ShardedRRSet) DeleteKey(k string) {
+	s.shard(k).DeleteKey(k)
+}
+
+func (s *ShardedRRSet) DeleteRecordInKey(k string, r Record) {
+	s.shard(k).DeleteRecordInKey(k, r)
+}
+
+func (s *ShardedRRSet) AppendRecordInKey(k string, r Record) {
+	s.shard(k).AppendRecordInKey(k, r)
+}
+
+func (s *ShardedRRSet) GetAll() map[string]map[Type][]Record {
+	all := make(map[string]map[Type][]Record)
+	s.each(func(rrs *RRSet) {
+		for k, v := range rrs.GetAll() {
+			all[k] = v
+		}
+	})
+	return all
+}
+
+// Txn locks every shard and applies the changes made through tx atomically.
+// Each shard with changed keys reports its part of the transaction as a
+// KEventTxn event. As with RRSet.Txn, f must not call methods of the set.
+func (s *ShardedRRSet) Txn(f func(tx *RRSetTxn) error) error {
+	changes, err := s.txn(f)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range changes {
+		if c.old != nil {
+			s.shards[i].deferOnTxn(c)
+		}
+	}
+	return nil
+}
+
+func (s *ShardedRRSet) txn(f func(tx *RRSetTxn) error) ([]txnChange, error) {
+	s.init()
+
+	for i := range s.shards {
+		s.shards[i].l.Lock()
+		defer s.shards[i].l.Unlock()
+
+		s.shards[i].initLocked()
+	}
+
+	tx := newRRSetTxn(func(k string) map[Type][]Record { return s.shard(k).m[k] })
+	if err := f(tx); err != nil {
+		return nil, err
+	}
+
+	keysByShard := make([][]string, len(s.shards))
+	for _, k := range tx.keys {
+		i := s.index(k)
+		keysByShard[i] = append(keysByShard[i], k)
+	}
+
+	changes := make([]txnChange, len(s.shards))
+	for i, keys := range keysByShard {
+		if len(keys) > 0 {
+			changes[i] = s.shards[i].commit(tx, keys)
+		}
+	}
+	return changes, nil
+}
+
+func (s *ShardedRRSet) Range(f func(string, Type, Record) bool) {
+	rangeRecords(s.GetAll(), f)
+}
+
+func (s *ShardedRRSet) AppendEntryInKey(k string, e *RREntry) {
+	s.shard(k).AppendEntryInKey(k, e)
+}
+
+func (s *ShardedRRSet) AppendLeaseInKey(k string, r Record, lease time.Duration) {
+	s.shard(k).AppendLeaseInKey(k, r, lease)
+}
+
+func (s *ShardedRRSet) AppendClassRecordInKey(k string, class Class, r Record) {
+	s.shard(k).AppendClassRecordInKey(k, class, r)
+}
+
+func (s *ShardedRRSet) GetEntries(name string, t Type) []*RREntry {
+	return s.shard(name).GetEntries(name, t)
+}
+
+func (s *ShardedRRSet) GetRecords(name string, t Type) []Record {
+	return s.shard(name).GetRecords(name, t)
+}
+
+func (s *ShardedRRSet) Names() []string {
+	var names []string
+	s.each(func(rrs *RRSet) { names = append(names, rrs.Names()...) })
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *ShardedRRSet) CountByType() map[Type]int {
+	counts := make(map[Type]int)
+	s.each(func(rrs *RRSet) {
+		for t, n := range rrs.CountByType() {
+			counts[t] += n
+		}
+	})
+	return counts
+}
+
+func (s *ShardedRRSet) Stats() RRSetStats {
+	stats := RRSetStats{Records: make(map[Type]int)}
+	s.each(func(rrs *RRSet) {
+		shard := rrs.Stats()
+
+		stats.Names += shard.Names
+		for t, n := range shard.Records {
+			stats.Records[t] += n
+		}
+		stats.Mutations += shard.Mutations
+		if shard.LastChange.After(stats.LastChange) {
+			stats.LastChange = shard.LastChange
+		}
+	})
+	return stats
+}
+
+func (s *ShardedRRSet) DumpText() string {
+	return dumpText(s.GetAll())
+}
+
+// Watch merges the events of all shards. If any shard drops the watcher for
+// falling behind, the returned channel is closed.
+func (s *ShardedRRSet) Watch(ctx context.Context) <-chan ChangeEvent {
+	s.init()
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var (
+		c  = make(chan ChangeEvent, watchBuffer)
+		wg sync.WaitGroup
+	)
+	for i := range s.shards {
+		events := s.shards[i].Watch(ctx)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+
+			for ev := range events {
+				select {
+				case c <- ev:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(c)
+	}()
+
+	return c
+}
+
+func (s *ShardedRRSet) SetBeforeOnClear(v func(map[string]map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeOnClear(v) })
+}
+
+func (s *ShardedRRSet) SetBeforeOnChange(v func(Event, string, interface{}, interface{})) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeOnChange(v) })
+}
+
+func (s *ShardedRRSet) SetBeforeOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeOnSetKey(v) })
+}
+
+func (s *ShardedRRSet) SetBeforeDeleteKey(v func(string, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeDeleteKey(v) })
+}
+
+func (s *ShardedRRSet) SetBeforeOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeOnDeleteKeyInRecord(v) })
+}
+
+func (s *ShardedRRSet) SetBeforeOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetBeforeOnAppendKeyInRecord(v) })
+}
+
+func (s *ShardedRRSet) SetOnClear(v func(map[string]map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetOnClear(v) })
+}
+
+func (s *ShardedRRSet) SetOnChange(v func(Event, string, interface{}, interface{})) {
+	s.each(func(rrs *RRSet) { rrs.SetOnChange(v) })
+}
+
+func (s *ShardedRRSet) SetOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetOnSetKey(v) })
+}
+
+func (s *ShardedRRSet) SetOnDeleteKey(v func(string, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetOnDeleteKey(v) })
+}
+
+func (s *ShardedRRSet) SetOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetOnDeleteKeyInRecord(v) })
+}
+
+func (s *ShardedRRSet) SetOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
+	s.each(func(rrs *RRSet) { rrs.SetOnAppendKeyInRecord(v) })
+}
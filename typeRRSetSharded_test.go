@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestShardedRRSet(t *testing.T) {
+	t.Parallel()
+
+	rrs := &ShardedRRSet{Shards: 4}
+
+	events := rrs.Watch(context.Background())
+
+	for i := 0; i < 16; i++ {
+		rrs.AppendRecordInKey("app"+strconv.Itoa(i), &A{A: net.IPv4(10, 0, 0, byte(i)).To4()})
+	}
+	for i := 0; i < 16; i++ {
+		if ev := <-events; ev.Event != KEventAppendKeyInRecord {
+			t.Errorf("want event %q, got %q", KEventAppendKeyInRecord, ev.Event)
+		}
+	}
+
+	if want, got := 16, rrs.Len(); want != got {
+		t.Errorf("want %d keys, got %d", want, got)
+	}
+	if want, got := 16, rrs.CountByType()[TypeA]; want != got {
+		t.Errorf("want %d A records, got %d", want, got)
+	}
+	if want, got := 16, len(rrs.GetAll()); want != got {
+		t.Errorf("want %d keys in snapshot, got %d", want, got)
+	}
+	if names := rrs.Names(); names[0] != "app0" || names[1] != "app1" || names[2] != "app10" {
+		t.Errorf("want sorted names, got %v", names)
+	}
+	if want, got := net.IPv4(10, 0, 0, 3), rrs.GetRecords("APP3.", TypeA)[0].(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+
+	err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteKey("app0")
+		tx.DeleteKey("app1")
+		tx.SetKey("db", map[Type][]Record{TypeA: {&A{A: net.IPv4(10, 0, 1, 1).To4()}}})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 15, rrs.Len(); want != got {
+		t.Errorf("want %d keys after txn, got %d", want, got)
+	}
+
+	errAbort := errors.New("abort")
+	if err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteKey("db")
+		return errAbort
+	}); err != errAbort {
+		t.Errorf("want error %v, got %v", errAbort, err)
+	}
+	if _, ok := rrs.GetKey("db"); !ok {
+		t.Error("want aborted txn to keep key db")
+	}
+
+	rrs.Set(map[string]map[Type][]Record{
+		"web": {TypeA: {&A{A: net.IPv4(10, 0, 2, 1).To4()}}},
+	})
+	if want, got := []string{"web"}, rrs.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %v, got %v", want, got)
+	}
+}
+
+func TestShardedRRSetZone(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "localhost.",
+		RRs:    new(ShardedRRSet),
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	res, err := new(Client).Do(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(res.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	if want, got := net.IPv4(10, 0, 0, 1), res.Answers[0].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+}
+
+func benchmarkRRStore(b *testing.B, rrs RRStore) {
+	const keys = 1024
+
+	for i := 0; i < keys; i++ {
+		rrs.AppendRecordInKey("app"+strconv.Itoa(i), &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			k := "app" + strconv.Itoa(i%keys)
+			if i%10 == 0 {
+				rrs.SetKey(k, map[Type][]Record{TypeA: {&A{A: net.IPv4(10, 0, 0, byte(i)).To4()}}})
+			} else {
+				rrs.GetRecords(k, TypeA)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkRRSetParallel(b *testing.B) {
+	benchmarkRRStore(b, new(RRSet))
+}
+
+func BenchmarkShardedRRSetParallel(b *testing.B) {
+	benchmarkRRStore(b, new(ShardedRRSet))
+}
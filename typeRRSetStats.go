@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRSetStats is a snapshot of the counters of an RRSet.
+type RRSetStats struct {
+	Names   int          // number of keys
+	Records map[Type]int // number of records of each type
+
+	Mutations  uint64    // number of changes made to the set
+	LastChange time.Time // time of the last change, zero if never changed
+}
+
+// el.l held
+func (el *RRSet) touch() {
+	el.mutations++
+	el.lastChange = time.Now()
+}
+
+// Get the counters of the set
+func (el *RRSet) Stats() RRSetStats {
+	el.l.RLock()
+	defer el.l.RUnlock()
+
+	return RRSetStats{
+		Names:      len(el.m),
+		Records:    countByType(el.m),
+		Mutations:  el.mutations,
+		LastChange: el.lastChange,
+	}
+}
+
+// Get a zone file style listing of the records, for debugging
+// Names are relative, the empty key is written as "@". Entry TTLs are written
+// when set, and the metadata of entries as a comment.
+func (el *RRSet) DumpText() string {
+	return dumpText(el.GetAll())
+}
+
+func dumpText(all map[string]map[Type][]Record) string {
+	var b strings.Builder
+	rangeRecords(all, func(name string, t Type, r Record) bool {
+		if name == "" {
+			name = "@"
+		}
+
+		rr, ttl := unwrapRecord(r)
+
+		b.WriteString(name)
+		b.WriteByte('\t')
+		if ttl != 0 {
+			b.WriteString(strconv.FormatInt(int64(ttl/time.Second), 10))
+		}
+		b.WriteByte('\t')
+		b.WriteString(classText(recordClass(r)))
+		b.WriteByte('\t')
+		b.WriteString(typeText(t))
+		b.WriteByte('\t')
+		b.WriteString(rr.Key())
+
+		if e, ok := r.(*RREntry); ok {
+			b.WriteString(entryComment(e))
+		}
+		b.WriteByte('\n')
+		return true
+	})
+	return b.String()
+}
+
+// typeText returns the zone file mnemonic of t.
+func typeText(t Type) string {
+	if s := t.String(); s != "" {
+		return strings.TrimPrefix(s, "Type")
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// classText returns the zone file mnemonic of c.
+func classText(c Class) string {
+	switch c {
+	case ClassIN:
+		return "IN"
+	case ClassCH:
+		return "CH"
+	case ClassHS:
+		return "HS"
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+func entryComment(e *RREntry) string {
+	var notes []string
+	if e.Meta.Comment != "" {
+		notes = append(notes, e.Meta.Comment)
+	}
+	if e.Meta.Source != "" {
+		notes = append(notes, "source="+e.Meta.Source)
+	}
+	if e.Meta.Region != "" {
+		notes = append(notes, "region="+e.Meta.Region)
+	}
+	if e.Meta.Unhealthy {
+		notes = append(notes, "unhealthy")
+	}
+	if !e.Expires.IsZero() {
+		notes = append(notes, "expires="+e.Expires.UTC().Format(time.RFC3339))
+	}
+
+	if len(notes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\t; %s", strings.Join(notes, " "))
+}
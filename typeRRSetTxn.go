@@ -0,0 +1,175 @@
+package dns
+
+// RRSetTxn is a set of changes to an RRSet that are applied together by
+// RRSet.Txn. A RRSetTxn must not be used after the Txn function returns.
+type RRSetTxn struct {
+	base func(k string) map[Type][]Record
+
+	m    map[string]map[Type][]Record
+	keys []string
+}
+
+// Txn calls f with a transaction and, if f returns nil, applies all the
+// changes made through the transaction at once. If f returns an error the
+// changes are discarded and the error is returned.
+//
+// The RRSet is write locked while f runs, so f must only use tx and must not
+// call methods of the RRSet, such as GetKey, which would deadlock.
+//
+// The before and after change callbacks and the watchers receive a single
+// KEventTxn event. The callbacks get the old and new records of the changed
+// keys only, a nil map of records meaning the key is deleted.
+func (el *RRSet) Txn(f func(tx *RRSetTxn) error) error {
+	c, err := el.txn(f)
+	if err != nil {
+		return err
+	}
+
+	el.deferOnTxn(c)
+	return nil
+}
+
+// txnChange holds the old and new records of the keys changed by a commit.
+type txnChange struct {
+	old, new map[string]map[Type][]Record
+}
+
+func (el *RRSet) deferOnTxn(c txnChange) {
+	if el.onChange != nil {
+		el.onChange(KEventTxn, "", c.old, c.new)
+	}
+}
+
+func (el *RRSet) txn(f func(tx *RRSetTxn) error) (txnChange, error) {
+	el.l.Lock()
+	defer el.l.Unlock()
+
+	el.initLocked()
+
+	tx := newRRSetTxn(func(k string) map[Type][]Record { return el.m[k] })
+	if err := f(tx); err != nil {
+		return txnChange{}, err
+	}
+
+	return el.commit(tx, tx.keys), nil
+}
+
+func newRRSetTxn(base func(string) map[Type][]Record) *RRSetTxn {
+	return &RRSetTxn{
+		base: base,
+		m:    make(map[string]map[Type][]Record),
+	}
+}
+
+// el.l held
+func (el *RRSet) initLocked() {
+	if el.init == false {
+		el.init = true
+		el.m = make(map[string]map[Type][]Record)
+	}
+}
+
+// commit applies the changes of tx to keys and returns their old and new
+// records.
+//
+// el.l held
+func (el *RRSet) commit(tx *RRSetTxn, keys []string) txnChange {
+	var (
+		old = make(map[string]map[Type][]Record, len(keys))
+		New = make(map[string]map[Type][]Record, len(keys))
+	)
+	for _, k := range keys {
+		old[k] = el.m[k]
+		New[k] = tx.m[k]
+	}
+
+	if el.beforeOnChange != nil {
+		el.beforeOnChange(KEventTxn, "", old, New)
+	}
+
+	ev := ChangeEvent{
+		Event: KEventTxn,
+		Type:  TypeANY,
+	}
+	for _, k := range keys {
+		change := ChangeEvent{
+			Event: KEventSetKey,
+			Key:   k,
+			Type:  TypeANY,
+			Old:   copyRecords(old[k]),
+			New:   copyRecords(New[k]),
+		}
+
+		if New[k] == nil {
+			change.Event = KEventDeleteKey
+			delete(el.m, k)
+		} else {
+			el.m[k] = New[k]
+			el.expireNew(k, old[k], New[k])
+		}
+
+		ev.Changes = append(ev.Changes, change)
+	}
+	el.touch()
+	el.send(ev)
+
+	return txnChange{old: old, new: New}
+}
+
+// GetKey returns a copy of the records of the key, including the changes made
+// by the transaction.
+func (tx *RRSetTxn) GetKey(k string) (map[Type][]Record, bool) {
+	v := tx.get(k)
+
+	return copyRecords(v), v != nil
+}
+
+// SetKey replaces the records of the key.
+func (tx *RRSetTxn) SetKey(k string, v map[Type][]Record) {
+	tx.put(k, copyRecords(v))
+}
+
+// DeleteKey deletes the key and all of its records.
+func (tx *RRSetTxn) DeleteKey(k string) {
+	tx.put(k, nil)
+}
+
+// AppendRecordInKey adds the record to the key.
+func (tx *RRSetTxn) AppendRecordInKey(k string, r Record) {
+	v := copyRecords(tx.get(k))
+	if v == nil {
+		v = make(map[Type][]Record)
+	}
+	v[r.Type()] = append(v[r.Type()], r)
+
+	tx.put(k, v)
+}
+
+// DeleteRecordInKey removes the first record of the key that is Equal to r.
+// The key is deleted once it holds no more records.
+func (tx *RRSetTxn) DeleteRecordInKey(k string, r Record) {
+	v, _ := deleteRecord(tx.get(k), r.Type(), r.Equal)
+	if len(v) == 0 {
+		v = nil
+	}
+
+	tx.put(k, v)
+}
+
+func (tx *RRSetTxn) get(k string) map[Type][]Record {
+	k = NormalizeKey(k)
+
+	if v, ok := tx.m[k]; ok {
+		return v
+	}
+	return tx.base(k)
+}
+
+func (tx *RRSetTxn) put(k string, v map[Type][]Record) {
+	k = NormalizeKey(k)
+
+	if _, ok := tx.m[k]; !ok {
+		tx.keys = append(tx.keys, k)
+	}
+	tx.m[k] = v
+}
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestRRSetTxn(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("old", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+
+	events := rrs.Watch(context.Background())
+
+	err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteKey("old")
+		tx.AppendRecordInKey("new", &A{A: net.IPv4(10, 0, 0, 2).To4()})
+		tx.AppendRecordInKey("new", &A{A: net.IPv4(10, 0, 0, 3).To4()})
+		tx.DeleteRecordInKey("new", &A{A: net.IPv4(10, 0, 0, 3).To4()})
+
+		if _, ok := tx.GetKey("old"); ok {
+			t.Error("want deleted key hidden inside transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := rrs.GetKey("old"); ok {
+		t.Error("want old key deleted")
+	}
+	if want, got := 1, len(rrs.GetRecords("new", TypeA)); want != got {
+		t.Errorf("want %d new records, got %d", want, got)
+	}
+
+	ev := <-events
+	if want, got := KEventTxn, ev.Event; want != got {
+		t.Fatalf("want event %q, got %q", want, got)
+	}
+	if want, got := 2, len(ev.Changes); want != got {
+		t.Fatalf("want %d changes, got %d", want, got)
+	}
+	if want, got := KEventDeleteKey, ev.Changes[0].Event; want != got {
+		t.Errorf("want change %q, got %q", want, got)
+	}
+	if want, got := KEventSetKey, ev.Changes[1].Event; want != got {
+		t.Errorf("want change %q, got %q", want, got)
+	}
+}
+
+func TestRRSetTxnOnChange(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("kept", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+
+	var old, new interface{}
+	rrs.SetOnChange(func(event Event, k string, o, n interface{}) {
+		if event == KEventTxn {
+			old, new = o, n
+		}
+	})
+
+	err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 2).To4()})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(old.(map[string]map[Type][]Record)); want != got {
+		t.Errorf("want %d old keys, got %d", want, got)
+	}
+	changed := new.(map[string]map[Type][]Record)
+	if want, got := 1, len(changed); want != got {
+		t.Fatalf("want %d changed keys, got %d", want, got)
+	}
+	if want, got := 1, len(changed["app"][TypeA]); want != got {
+		t.Errorf("want %d new app records, got %d", want, got)
+	}
+}
+
+func TestRRSetTxnRollback(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+
+	errAbort := errors.New("abort")
+	err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.DeleteKey("app")
+		tx.AppendRecordInKey("other", &A{A: net.IPv4(10, 0, 0, 2).To4()})
+		return errAbort
+	})
+	if want, got := errAbort, err; want != got {
+		t.Fatalf("want error %v, got %v", want, got)
+	}
+
+	if want, got := []string{"app"}, rrs.Names(); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+}
+
+func TestRRSetTxnAfterSetNil(t *testing.T) {
+	t.Parallel()
+
+	rrs := NewRRSet(nil)
+	err := rrs.Txn(func(tx *RRSetTxn) error {
+		tx.AppendRecordInKey("new", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(rrs.GetRecords("new", TypeA)); want != got {
+		t.Errorf("want %d new records, got %d", want, got)
+	}
+}
@@ -0,0 +1,369 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRRSetWatch(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := rrs.Watch(ctx)
+
+	a := &A{A: net.IPv4(127, 0, 0, 1).To4()}
+	rrs.AppendRecordInKey("app", a)
+
+	ev := <-events
+	if want, got := KEventAppendKeyInRecord, ev.Event; want != got {
+		t.Errorf("want event %q, got %q", want, got)
+	}
+	if want, got := "app", ev.Key; want != got {
+		t.Errorf("want key %q, got %q", want, got)
+	}
+	if want, got := TypeA, ev.Type; want != got {
+		t.Errorf("want type %s, got %s", want, got)
+	}
+	if want, got := 0, len(ev.Old[TypeA]); want != got {
+		t.Errorf("want %d old records, got %d", want, got)
+	}
+	if want, got := 1, len(ev.New[TypeA]); want != got {
+		t.Fatalf("want %d new records, got %d", want, got)
+	}
+	if want, got := Record(a), ev.New[TypeA][0]; want != got {
+		t.Errorf("want new record %v, got %v", want, got)
+	}
+
+	rrs.Clear()
+	if ev := <-events; ev.Event != KEventClear {
+		t.Errorf("want event %q, got %q", KEventClear, ev.Event)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestRRSetWatchSlowConsumer(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+
+	events := rrs.Watch(context.Background())
+	for i := 0; i <= watchBuffer; i++ {
+		rrs.SetKey("app", map[Type][]Record{})
+	}
+
+	var n int
+	for range events {
+		n++
+	}
+	if want, got := watchBuffer, n; want != got {
+		t.Errorf("want %d buffered events, got %d", want, got)
+	}
+}
+
+func TestRRSetWatchDroppedExits(t *testing.T) {
+	var rrs RRSet
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		events := rrs.Watch(context.Background())
+		for j := 0; j <= watchBuffer; j++ {
+			rrs.SetKey("app", map[Type][]Record{})
+		}
+		for range events {
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before+10 {
+		t.Errorf("want dropped watchers to exit, %d goroutines before, %d after", before, n)
+	}
+}
+
+func TestRRSetCopyOnRead(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("app", &A{A: net.IPv4(127, 0, 0, 1).To4()})
+
+	rrsByType, ok := rrs.GetKey("app")
+	if !ok {
+		t.Fatal("missing app key")
+	}
+	rrsByType[TypeA] = nil
+	rrsByType[TypeAAAA] = []Record{&AAAA{AAAA: net.ParseIP("::1")}}
+
+	all := rrs.GetAll()
+	delete(all, "app")
+
+	rrsByType, ok = rrs.GetKey("app")
+	if !ok {
+		t.Fatal("app key removed through GetAll copy")
+	}
+	if want, got := 1, len(rrsByType[TypeA]); want != got {
+		t.Errorf("want %d A records, got %d", want, got)
+	}
+	if _, ok := rrsByType[TypeAAAA]; ok {
+		t.Error("AAAA records added through GetKey copy")
+	}
+}
+
+func TestRRSetDeleteRecordInKey(t *testing.T) {
+	t.Parallel()
+
+	var (
+		rrs RRSet
+
+		mx1 = &MX{Pref: 10, MX: "a.mx.local."}
+		mx2 = &MX{Pref: 20, MX: "b.mx.local."}
+		txt = &TXT{TXT: []string{"v=spf1 -all"}}
+	)
+
+	rrs.AppendRecordInKey("mail", mx1)
+	rrs.AppendRecordInKey("mail", mx2)
+	rrs.AppendRecordInKey("mail", txt)
+
+	var before int
+	rrs.SetBeforeOnDeleteKeyInRecord(func(string, map[Type][]Record, map[Type][]Record) {
+		before++
+	})
+
+	rrs.DeleteRecordInKey("mail", &MX{Pref: 20, MX: "b.mx.local."})
+
+	rrsByType, _ := rrs.GetKey("mail")
+	if want, got := 1, len(rrsByType[TypeMX]); want != got {
+		t.Fatalf("want %d MX records, got %d", want, got)
+	}
+	if !rrsByType[TypeMX][0].Equal(mx1) {
+		t.Errorf("want MX record %s, got %s", mx1.Key(), rrsByType[TypeMX][0].Key())
+	}
+
+	rrs.DeleteRecordInKey("mail", &MX{Pref: 10, MX: "a.mx.local."})
+	rrs.DeleteRecordInKey("mail", &TXT{TXT: []string{"v=spf1 -all"}})
+
+	if _, ok := rrs.GetKey("mail"); ok {
+		t.Error("want empty key deleted")
+	}
+	if want, got := 3, before; want != got {
+		t.Errorf("want %d before hook calls, got %d", want, got)
+	}
+}
+
+func TestRRSetRange(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.AppendRecordInKey("b", &AAAA{AAAA: net.ParseIP("::1")})
+	rrs.AppendRecordInKey("b", &A{A: net.IPv4(127, 0, 0, 1).To4()})
+	rrs.AppendRecordInKey("a", &A{A: net.IPv4(127, 0, 0, 2).To4()})
+	rrs.AppendRecordInKey("a", &A{A: net.IPv4(127, 0, 0, 3).To4()})
+
+	var visited []string
+	rrs.Range(func(name string, t Type, r Record) bool {
+		visited = append(visited, name+" "+t.String()+" "+r.Key())
+		return len(visited) < 3
+	})
+
+	want := []string{
+		"a TypeA 127.0.0.2",
+		"a TypeA 127.0.0.3",
+		"b TypeA 127.0.0.1",
+	}
+	if !reflect.DeepEqual(want, visited) {
+		t.Errorf("want visited %q, got %q", want, visited)
+	}
+
+	if want, got := []string{"a", "b"}, rrs.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+	if want, got := 2, len(rrs.GetRecords("a", TypeA)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+	if want, got := map[Type]int{TypeA: 3, TypeAAAA: 1}, rrs.CountByType(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want counts %v, got %v", want, got)
+	}
+}
+
+func TestRRSetNormalizedKeys(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	rrs.Set(map[string]map[Type][]Record{
+		"Foo.App.": {TypeA: {&A{A: net.IPv4(10, 0, 0, 1).To4()}}},
+		"foo.app":  {TypeA: {&A{A: net.IPv4(10, 0, 0, 2).To4()}}},
+	})
+	rrs.AppendRecordInKey("FOO.app", &A{A: net.IPv4(10, 0, 0, 3).To4()})
+
+	if want, got := []string{"foo.app"}, rrs.Names(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want names %q, got %q", want, got)
+	}
+	if want, got := 3, len(rrs.GetRecords("foo.APP.", TypeA)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+
+	rrs.DeleteKey("Foo.App")
+	if want, got := 0, rrs.Len(); want != got {
+		t.Errorf("want %d keys, got %d", want, got)
+	}
+}
+
+func TestRRSetLease(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+
+	events := rrs.Watch(context.Background())
+
+	a := &A{A: net.IPv4(10, 0, 0, 1).To4()}
+	rrs.AppendLeaseInKey("host", a, time.Hour)
+	rrs.AppendLeaseInKey("host", a, 50*time.Millisecond)
+	rrs.AppendRecordInKey("host", &A{A: net.IPv4(10, 0, 0, 2).To4()})
+
+	entries := rrs.GetEntries("host", TypeA)
+	if want, got := 2, len(entries); want != got {
+		t.Fatalf("want %d entries after renewal, got %d", want, got)
+	}
+	if entries[0].Expires.IsZero() {
+		t.Error("want leased entry to have an expiry")
+	}
+
+	for i := 0; i < 3; i++ {
+		<-events
+	}
+
+	select {
+	case ev := <-events:
+		if want, got := KEventDeleteKeyInRecord, ev.Event; want != got {
+			t.Errorf("want event %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leased record did not expire")
+	}
+
+	rrsByType, ok := rrs.GetKey("host")
+	if !ok {
+		t.Fatal("want unleased record to be kept")
+	}
+	if want, got := net.IPv4(10, 0, 0, 2), rrsByType[TypeA][0].(*A).A; len(rrsByType[TypeA]) != 1 || !want.Equal(got) {
+		t.Errorf("want only A record %s, got %v", want, rrsByType[TypeA])
+	}
+
+	select {
+	case ev := <-events:
+		t.Errorf("want no event for the renewed lease, got %q", ev.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRRSetLeaseWrites(t *testing.T) {
+	t.Parallel()
+
+	lease := func() *RREntry {
+		return &RREntry{
+			Record:  &A{A: net.IPv4(10, 0, 0, 1).To4()},
+			Expires: time.Now().Add(50 * time.Millisecond),
+		}
+	}
+
+	tests := []struct {
+		name  string
+		write func(*testing.T, *RRSet)
+	}{
+		{"Set", func(t *testing.T, rrs *RRSet) {
+			rrs.Set(map[string]map[Type][]Record{"host": {TypeA: {lease()}}})
+		}},
+		{"SetKey", func(t *testing.T, rrs *RRSet) {
+			rrs.SetKey("host", map[Type][]Record{TypeA: {lease()}})
+		}},
+		{"Txn", func(t *testing.T, rrs *RRSet) {
+			rrs.Txn(func(tx *RRSetTxn) error {
+				tx.AppendRecordInKey("host", lease())
+				return nil
+			})
+		}},
+		{"UnmarshalJSON", func(t *testing.T, rrs *RRSet) {
+			b, err := json.Marshal(NewRRSet(map[string]map[Type][]Record{"host": {TypeA: {lease()}}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(b, rrs); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			rrs := new(RRSet)
+			test.write(t, rrs)
+
+			if want, got := 1, len(rrs.GetRecords("host", TypeA)); want != got {
+				t.Fatalf("want %d record, got %d", want, got)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for len(rrs.GetRecords("host", TypeA)) != 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("leased record did not expire")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestRRSetStats(t *testing.T) {
+	t.Parallel()
+
+	var rrs RRSet
+	if stats := rrs.Stats(); stats.Mutations != 0 || !stats.LastChange.IsZero() {
+		t.Errorf("want no mutations, got %+v", stats)
+	}
+
+	rrs.AppendRecordInKey("", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	rrs.AppendEntryInKey("app", &RREntry{
+		Record: &MX{Pref: 10, MX: "mail.localhost."},
+		TTL:    time.Minute,
+		Meta:   RRMeta{Source: "test", Unhealthy: true},
+	})
+	rrs.Txn(func(tx *RRSetTxn) error {
+		tx.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 2).To4()})
+		return nil
+	})
+
+	stats := rrs.Stats()
+	if want, got := 2, stats.Names; want != got {
+		t.Errorf("want %d names, got %d", want, got)
+	}
+	if want, got := map[Type]int{TypeA: 2, TypeMX: 1}, stats.Records; !reflect.DeepEqual(want, got) {
+		t.Errorf("want counts %v, got %v", want, got)
+	}
+	if want, got := uint64(3), stats.Mutations; want != got {
+		t.Errorf("want %d mutations, got %d", want, got)
+	}
+	if stats.LastChange.IsZero() {
+		t.Error("want last change time")
+	}
+
+	want := "@\t\tIN\tA\t10.0.0.1\n" +
+		"app\t\tIN\tA\t10.0.0.2\n" +
+		"app\t60\tIN\tMX\t10 mail.localhost.\t; source=test unhealthy\n"
+	if got := rrs.DumpText(); want != got {
+		t.Errorf("want dump:\n%s\ngot:\n%s", want, got)
+	}
+}
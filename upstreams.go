@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errNoUpstream = errors.New("no available upstream")
+
+const (
+	defaultFailureThreshold = 5
+	defaultProbeInterval    = 30 * time.Second
+)
+
+// Upstreams is a RoundTripper sending each query to one of NameServers on the
+// network of the RemoteAddr of the query, in turn, through Client. It may be
+// used as the Forwarder of a Server.
+//
+// The outcome of the queries to each nameserver is tracked: after
+// FailureThreshold consecutive failures the circuit of a nameserver opens and
+// it is skipped. Once ProbeInterval has passed a single query is sent to probe
+// it, which closes the circuit on success or keeps it open for another
+// interval. A query fails with an error, a timeout or a SERVFAIL response;
+// queries canceled by the caller are not counted.
+type Upstreams struct {
+	// Client sends the queries. If nil, a zero Client is used.
+	Client *Client
+
+	NameServers NameServers
+
+	// FailureThreshold is the number of consecutive failures that open the
+	// circuit of a nameserver. If zero, 5 is used.
+	FailureThreshold int
+
+	// ProbeInterval is the time an open circuit skips a nameserver before it
+	// is probed. If zero, 30 seconds is used.
+	ProbeInterval time.Duration
+
+	mu     sync.Mutex
+	states []upstreamState
+	next   int
+}
+
+// UpstreamStats is a snapshot of the counters of a nameserver of Upstreams.
+type UpstreamStats struct {
+	Addr net.Addr
+
+	Queries  uint64 // number of queries sent
+	Failures uint64 // number of failed queries, including timeouts
+	Timeouts uint64 // number of queries that timed out
+
+	// Latency is the smoothed round trip time of the successful queries, a
+	// moving average weighting the last query by 1/8.
+	Latency time.Duration
+
+	// Open is set while the circuit of the nameserver is open.
+	Open bool
+}
+
+// SuccessRate returns the fraction of the queries that succeeded.
+func (s UpstreamStats) SuccessRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.Queries-s.Failures) / float64(s.Queries)
+}
+
+// TimeoutRate returns the fraction of the queries that timed out.
+func (s UpstreamStats) TimeoutRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.Timeouts) / float64(s.Queries)
+}
+
+type upstreamState struct {
+	UpstreamStats
+
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+}
+
+// Do sends query to the next available nameserver.
+func (u *Upstreams) Do(ctx context.Context, query *Query) (*Message, error) {
+	idx, err := u.pick(query.RemoteAddr.Network())
+	if err != nil {
+		return nil, err
+	}
+
+	q := *query
+	q.RemoteAddr = u.NameServers[idx]
+
+	client := u.Client
+	if client == nil {
+		client = new(Client)
+	}
+
+	start := time.Now()
+	msg, err := client.Do(ctx, &q)
+	u.record(idx, time.Since(start), msg, err)
+
+	return msg, err
+}
+
+// Stats returns the counters of each nameserver, in the order of NameServers.
+func (u *Upstreams) Stats() []UpstreamStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.init()
+
+	stats := make([]UpstreamStats, 0, len(u.states))
+	for _, st := range u.states {
+		stats = append(stats, st.UpstreamStats)
+	}
+	return stats
+}
+
+// u.mu held
+func (u *Upstreams) init() {
+	if len(u.states) == len(u.NameServers) {
+		return
+	}
+
+	u.states = make([]upstreamState, len(u.NameServers))
+	for i, addr := range u.NameServers {
+		u.states[i].Addr = addr
+	}
+}
+
+// pick returns the index of the next nameserver on network with a closed
+// circuit, or due to be probed.
+func (u *Upstreams) pick(network string) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.init()
+
+	interval := u.ProbeInterval
+	if interval == 0 {
+		interval = defaultProbeInterval
+	}
+
+	for n := 0; n < len(u.states); n++ {
+		idx := (u.next + n) % len(u.states)
+
+		st := &u.states[idx]
+		if st.Addr.Network() != network {
+			continue
+		}
+		if st.Open {
+			if st.probing || time.Since(st.openedAt) < interval {
+				continue
+			}
+			st.probing = true
+		}
+
+		u.next = idx + 1
+		return idx, nil
+	}
+	return 0, errNoUpstream
+}
+
+func (u *Upstreams) record(idx int, rtt time.Duration, msg *Message, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	st := &u.states[idx]
+	st.probing = false
+
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	st.Queries++
+
+	if err == nil && msg.RCode != ServFail {
+		if st.Latency == 0 {
+			st.Latency = rtt
+		} else {
+			st.Latency += (rtt - st.Latency) / 8
+		}
+		st.consecutive, st.Open = 0, false
+		return
+	}
+
+	st.Failures++
+	if ne, ok := err.(net.Error); ok && ne.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		st.Timeouts++
+	}
+
+	threshold := u.FailureThreshold
+	if threshold == 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	st.consecutive++
+	if st.Open || st.consecutive >= threshold {
+		st.Open, st.openedAt = true, time.Now()
+	}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpstreamsCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	srv := mustServer(&answerHandler{answers})
+
+	good, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a nameserver that never answers
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+
+	u := &Upstreams{
+		Client:           &Client{Transport: &Transport{DisablePipelining: true}},
+		NameServers:      NameServers{dead.LocalAddr(), good},
+		FailureThreshold: 2,
+		ProbeInterval:    200 * time.Millisecond,
+	}
+
+	query := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := u.Do(ctx, &Query{
+			RemoteAddr: new(net.UDPAddr),
+			Message: &Message{
+				Questions: []Question{questions["A"]},
+			},
+		})
+		return err
+	}
+
+	var failures int
+	for i := 0; i < 8; i++ {
+		if query() != nil {
+			failures++
+		}
+	}
+	if want, got := 2, failures; want != got {
+		t.Errorf("want %d failed queries, got %d", want, got)
+	}
+
+	stats := u.Stats()
+	if want, got := uint64(2), stats[0].Timeouts; want != got {
+		t.Errorf("want %d timeouts, got %d", want, got)
+	}
+	if !stats[0].Open {
+		t.Error("want open circuit")
+	}
+	if want, got := uint64(6), stats[1].Queries; want != got {
+		t.Errorf("want %d queries to the good nameserver, got %d", want, got)
+	}
+	if want, got := 1.0, stats[1].SuccessRate(); want != got {
+		t.Errorf("want success rate %v, got %v", want, got)
+	}
+	if stats[1].Latency <= 0 {
+		t.Errorf("want latency, got %v", stats[1].Latency)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	// the first query after the interval probes the dead nameserver
+	if query() == nil {
+		t.Error("want probe to fail")
+	}
+	if err := query(); err != nil {
+		t.Error(err)
+	}
+
+	stats = u.Stats()
+	if want, got := uint64(3), stats[0].Queries; want != got {
+		t.Errorf("want %d queries to the dead nameserver, got %d", want, got)
+	}
+	if !stats[0].Open {
+		t.Error("want open circuit after failed probe")
+	}
+
+	if _, err := u.Do(context.Background(), &Query{RemoteAddr: new(net.TCPAddr), Message: new(Message)}); err != errNoUpstream {
+		t.Errorf("want error %v, got %v", errNoUpstream, err)
+	}
+}
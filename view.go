@@ -0,0 +1,321 @@
+package dns
+
+import (
+	"time"
+)
+
+// maxNamePointers bounds the compression pointers followed to read a name. A
+// name has at most 127 labels, so a longer chain is a pointer cycle.
+const maxNamePointers = 127
+
+// MessageView is a message read in place from its wire format, for packet
+// inspection at high rates. Unlike Message.Unpack, MessageView.Unpack does not
+// copy the names and the record data of the message: they are sub-slices of
+// the buffer it was read from. A MessageView reused for the next message
+// reuses its sections, so reading a message does not allocate.
+//
+// The buffer must not be modified or reused while the view, or a NameView or
+// slice read from it, is in use.
+type MessageView struct {
+	// Header is the header of the message. Its sections are empty, the
+	// records are in the sections of the view.
+	Header Message
+
+	Questions   []QuestionView
+	Answers     []ResourceView
+	Authorities []ResourceView
+	Additionals []ResourceView
+
+	msg []byte
+}
+
+// Unpack reads the message b into m, and returns the bytes after it. The
+// names and record data of the message are checked but not decoded.
+func (m *MessageView) Unpack(b []byte) ([]byte, error) {
+	qdcount, ancount, nscount, arcount, err := m.Header.readHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	m.msg = b
+	m.Questions = m.Questions[:0]
+	m.Answers = m.Answers[:0]
+	m.Authorities = m.Authorities[:0]
+	m.Additionals = m.Additionals[:0]
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var q QuestionView
+		if off, err = q.unpack(b, off); err != nil {
+			return nil, err
+		}
+		m.Questions = append(m.Questions, q)
+	}
+
+	for _, section := range []struct {
+		rs    *[]ResourceView
+		count int
+	}{
+		{&m.Answers, ancount},
+		{&m.Authorities, nscount},
+		{&m.Additionals, arcount},
+	} {
+		for i := 0; i < section.count; i++ {
+			var r ResourceView
+			if off, err = r.unpack(b, off); err != nil {
+				return nil, err
+			}
+			*section.rs = append(*section.rs, r)
+		}
+	}
+
+	m.msg = b[:off]
+	return b[off:], nil
+}
+
+// Message returns the message of the view decoded by Message.Unpack, with
+// its own copy of the names and records.
+func (m *MessageView) Message() (*Message, error) {
+	msg := new(Message)
+	if _, err := msg.Unpack(m.msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// QuestionView is a question of a MessageView.
+type QuestionView struct {
+	Name  NameView
+	Type  Type
+	Class Class
+}
+
+func (q *QuestionView) unpack(msg []byte, off int) (int, error) {
+	var err error
+	if q.Name, off, err = readName(msg, off); err != nil {
+		return 0, err
+	}
+
+	if len(msg)-off < 4 {
+		return 0, errResourceLen
+	}
+
+	q.Type = Type(nbo.Uint16(msg[off:]))
+	q.Class = Class(nbo.Uint16(msg[off+2:]))
+	return off + 4, nil
+}
+
+// ResourceView is a resource record of a MessageView.
+type ResourceView struct {
+	Name  NameView
+	Type  Type
+	Class Class
+	TTL   time.Duration
+
+	// Data is the RDATA of the record, a slice of the message. Its names
+	// may be compressed, read them with DataName.
+	Data []byte
+
+	msg     []byte
+	dataOff int // offset of Data in msg
+}
+
+func (r *ResourceView) unpack(msg []byte, off int) (int, error) {
+	var err error
+	if r.Name, off, err = readName(msg, off); err != nil {
+		return 0, err
+	}
+
+	if len(msg)-off < 10 {
+		return 0, errResourceLen
+	}
+
+	r.Type = Type(nbo.Uint16(msg[off:]))
+	r.Class = Class(nbo.Uint16(msg[off+2:]))
+	r.TTL = time.Duration(nbo.Uint32(msg[off+4:])) * time.Second
+
+	rdlen := int(nbo.Uint16(msg[off+8:]))
+	off += 10
+	if len(msg)-off < rdlen {
+		return 0, errResourceLen
+	}
+
+	r.Data = msg[off : off+rdlen : off+rdlen]
+	r.msg, r.dataOff = msg, off
+	return off + rdlen, nil
+}
+
+// Record returns the record decoded from the RDATA, as by Message.Unpack.
+func (r ResourceView) Record() (Record, error) {
+	newfn, ok := NewRecordByType[r.Type]
+	if !ok {
+		return nil, errUnknownType
+	}
+
+	record := newfn()
+	buf, err := record.Unpack(r.Data, decompressor(r.msg))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		return nil, errResTooLong
+	}
+	return record, nil
+}
+
+// DataName returns the name at offset i of the RDATA, such as 0 for the
+// target of a CNAME or 2 for the exchange of an MX record, and the offset
+// after it.
+func (r ResourceView) DataName(i int) (NameView, int, error) {
+	if i < 0 || i >= len(r.Data) {
+		return NameView{}, 0, errBaseLen
+	}
+
+	name, off, err := readName(r.msg[:r.dataOff+len(r.Data)], r.dataOff+i)
+	if err != nil {
+		return NameView{}, 0, err
+	}
+	return name, off - r.dataOff, nil
+}
+
+// Strings calls f with each character-string of the RDATA, such as the
+// strings of a TXT record, until f returns false.
+func (r ResourceView) Strings(f func([]byte) bool) error {
+	for b := r.Data; len(b) > 0; {
+		n := int(b[0])
+		if len(b) < 1+n {
+			return errCalcLen
+		}
+		if !f(b[1 : 1+n : 1+n]) {
+			return nil
+		}
+		b = b[1+n:]
+	}
+	return nil
+}
+
+// NameView is a domain name of a MessageView, read in place from the wire
+// format of the message.
+type NameView struct {
+	msg []byte
+	off int
+}
+
+// readName checks the name at offset off of msg, and returns it and the offset
+// after it.
+func readName(msg []byte, off int) (NameView, int, error) {
+	name, end := NameView{msg: msg, off: off}, -1
+
+	for ptrs := 0; ; {
+		if off >= len(msg) {
+			return NameView{}, 0, errBaseLen
+		}
+
+		c := msg[off]
+		switch {
+		case c == 0x00:
+			if end < 0 {
+				end = off + 1
+			}
+			return name, end, nil
+		case isPointer(c):
+			if off+1 >= len(msg) {
+				return NameView{}, 0, errBaseLen
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			if ptrs++; ptrs > maxNamePointers {
+				return NameView{}, 0, errPtrCycle
+			}
+
+			off = int(nbo.Uint16(msg[off:]) & 0x3FFF)
+			if off >= len(msg) || isPointer(msg[off]) {
+				return NameView{}, 0, errInvalidPtr
+			}
+		default:
+			if off += 1 + int(c); off > len(msg) {
+				return NameView{}, 0, errCalcLen
+			}
+		}
+	}
+}
+
+// Labels calls f with each label of the name, a slice of the message, until f
+// returns false. The root name has no labels.
+func (n NameView) Labels(f func([]byte) bool) {
+	if n.msg == nil {
+		return
+	}
+
+	for off := n.off; ; {
+		c := n.msg[off]
+		switch {
+		case c == 0x00:
+			return
+		case isPointer(c):
+			off = int(nbo.Uint16(n.msg[off:]) & 0x3FFF)
+		default:
+			if !f(n.msg[off+1 : off+1+int(c) : off+1+int(c)]) {
+				return
+			}
+			off += 1 + int(c)
+		}
+	}
+}
+
+// AppendText appends the name to b as a fully qualified domain name, as
+// returned by Message.Unpack, and returns the extended buffer.
+func (n NameView) AppendText(b []byte) []byte {
+	start := len(b)
+	n.Labels(func(label []byte) bool {
+		b = append(append(b, label...), '.')
+		return true
+	})
+	if len(b) == start {
+		b = append(b, '.')
+	}
+	return b
+}
+
+// String returns the name as a fully qualified domain name. Unlike the other
+// methods of NameView, it allocates.
+func (n NameView) String() string {
+	return string(n.AppendText(make([]byte, 0, 64)))
+}
+
+// Equal reports whether the name is name, compared case-insensitively. The
+// trailing dot of name is optional.
+func (n NameView) Equal(name string) bool {
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+
+	equal := true
+	n.Labels(func(label []byte) bool {
+		if len(name) < len(label) || (len(name) > len(label) && name[len(label)] != '.') {
+			equal = false
+			return false
+		}
+		for i, c := range label {
+			if lowerASCII(c) != lowerASCII(name[i]) {
+				equal = false
+				return false
+			}
+		}
+
+		name = name[len(label):]
+		if len(name) > 0 {
+			name = name[1:]
+		}
+		return true
+	})
+	return equal && name == ""
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
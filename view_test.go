@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageView(t *testing.T) {
+	t.Parallel()
+
+	for _, compress := range []bool{false, true} {
+		msg := largeTestMsg()
+		buf, err := msg.Pack(nil, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var view MessageView
+		rest, err := view.Unpack(append(buf, 0xFF))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := []byte{0xFF}, rest; !bytes.Equal(want, got) {
+			t.Errorf("want rest %x, got %x", want, got)
+		}
+
+		want := new(Message)
+		if _, err := want.Unpack(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := want.ID, view.Header.ID; want != got {
+			t.Errorf("want ID %d, got %d", want, got)
+		}
+		if !view.Header.Response || !view.Header.Authoritative {
+			t.Error("want QR and AA bits")
+		}
+
+		if want, got := len(want.Questions), len(view.Questions); want != got {
+			t.Fatalf("want %d questions, got %d", want, got)
+		}
+		for i, q := range want.Questions {
+			got := view.Questions[i]
+			if q.Name != got.Name.String() || q.Type != got.Type || q.Class != got.Class {
+				t.Errorf("question %d: want %+v, got %s %d %d", i, q, got.Name, got.Type, got.Class)
+			}
+		}
+
+		for _, section := range []struct {
+			want []Resource
+			got  []ResourceView
+		}{
+			{want.Answers, view.Answers},
+			{want.Authorities, view.Authorities},
+			{want.Additionals, view.Additionals},
+		} {
+			if want, got := len(section.want), len(section.got); want != got {
+				t.Fatalf("want %d records, got %d", want, got)
+			}
+
+			for i, res := range section.want {
+				got := section.got[i]
+				if want, got := res.Name, got.Name.String(); want != got {
+					t.Errorf("record %d: want name %q, got %q", i, want, got)
+				}
+				if want, got := res.Record.Type(), got.Type; want != got {
+					t.Errorf("record %d: want type %d, got %d", i, want, got)
+				}
+				if want, got := res.TTL, got.TTL; want != got {
+					t.Errorf("record %d: want TTL %v, got %v", i, want, got)
+				}
+
+				rr, err := got.Record()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !res.Record.Equal(rr) {
+					t.Errorf("record %d: want %v, got %v", i, res.Record, rr)
+				}
+			}
+		}
+
+		copied, err := view.Message()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, copied) {
+			t.Errorf("want message %+v, got %+v", want, copied)
+		}
+	}
+}
+
+func TestMessageViewData(t *testing.T) {
+	t.Parallel()
+
+	msg := Message{
+		Questions: []Question{{Name: "Example.COM.", Type: TypeMX, Class: ClassIN}},
+		Answers: []Resource{
+			{Name: "example.com.", Class: ClassIN, Record: &MX{Pref: 10, MX: "mail.example.com."}},
+			{Name: "example.com.", Class: ClassIN, Record: &TXT{TXT: []string{"a", "bc"}}},
+		},
+	}
+	buf, err := msg.Pack(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var view MessageView
+	if _, err := view.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	q := view.Questions[0].Name
+	for _, name := range []string{"example.com.", "EXAMPLE.com", "Example.COM."} {
+		if !q.Equal(name) {
+			t.Errorf("want name equal to %q", name)
+		}
+	}
+	for _, name := range []string{"example.org.", "com.", "www.example.com.", "example.co", "."} {
+		if q.Equal(name) {
+			t.Errorf("want name not equal to %q", name)
+		}
+	}
+
+	mx, off, err := view.Answers[0].DataName(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "mail.example.com.", string(mx.AppendText(nil)); want != got {
+		t.Errorf("want MX %q, got %q", want, got)
+	}
+	if want, got := len(view.Answers[0].Data), off; want != got {
+		t.Errorf("want offset %d after the name, got %d", want, got)
+	}
+
+	var strs []string
+	if err := view.Answers[1].Strings(func(b []byte) bool {
+		strs = append(strs, string(b))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"a", "bc"}, strs; !reflect.DeepEqual(want, got) {
+		t.Errorf("want strings %q, got %q", want, got)
+	}
+}
+
+func TestMessageViewErrors(t *testing.T) {
+	t.Parallel()
+
+	header := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+
+	tests := []struct {
+		name string
+
+		question []byte
+		err      error
+	}{
+		{name: "truncated name", question: []byte{3, 'c', 'o'}, err: errCalcLen},
+		{name: "no root", question: []byte{3, 'c', 'o', 'm'}, err: errBaseLen},
+		{name: "pointer cycle", question: []byte{1, 'a', 0xC0, 12}, err: errPtrCycle},
+		{name: "pointer to pointer", question: []byte{0xC0, 12}, err: errInvalidPtr},
+		{name: "pointer past end", question: []byte{0xC0, 0xFF}, err: errInvalidPtr},
+		{name: "no type", question: []byte{0, 0, 1}, err: errResourceLen},
+	}
+
+	for _, test := range tests {
+		var view MessageView
+		_, err := view.Unpack(append(append([]byte(nil), header...), test.question...))
+		if want, got := test.err, err; want != got {
+			t.Errorf("%s: want error %v, got %v", test.name, want, got)
+		}
+	}
+}
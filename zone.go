@@ -2,10 +2,17 @@ package dns
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+// defaultMaxANYAnswers is the number of answers to a query of type ALL by a
+// Zone without MaxANYAnswers.
+const defaultMaxANYAnswers = 64
+
 // Zone is a contiguous set DNS records under an origin domain name.
 type Zone struct {
 	Origin string
@@ -13,138 +20,559 @@ type Zone struct {
 
 	SOA *SOA
 
-	RRs RRSet
+	// RRs holds the records of the zone, such as an *RRSet or a
+	// *ShardedRRSet. If nil, an *RRSet is created on first use by the
+	// methods of the zone.
+	RRs RRStore
+
+	// PackCacheSize is the maximum number of packed responses kept in the
+	// pack cache. Zero disables the cache.
+	PackCacheSize int
+
+	// Selector, if not nil, selects the answers from the records of each
+	// question. The pack cache is not used with a Selector, as the answers
+	// may differ between clients.
+	Selector Selector
+
+	// FullResponses, if set, adds the NS records of the origin to the
+	// authority section of the answers, and the A and AAAA records of the
+	// names the NS, MX and SRV records of the response point to in the zone
+	// to its additional section, as expected from an authoritative server.
+	FullResponses bool
+
+	// MinimalANY answers the queries of type ALL (ANY) with the first RRset
+	// of the name only, as RFC 8482 allows, instead of every record stored
+	// at the name.
+	MinimalANY bool
+
+	// MaxANYAnswers bounds the answers to a query of type ALL, the records
+	// past it being left out. If zero, 64 answers are written at most.
+	MaxANYAnswers int
+
+	rrso  sync.Once
+	packo sync.Once
+	packc *packCache
+}
+
+func (z *Zone) store() RRStore {
+	z.rrso.Do(func() {
+		if z.RRs == nil {
+			z.RRs = new(RRSet)
+		}
+	})
+	return z.RRs
 }
 
 func (z *Zone) Clear() {
-	z.RRs.Clear()
+	z.store().Clear()
 }
 
 func (z *Zone) Set(v map[string]map[Type][]Record) {
-	z.RRs.Set(v)
+	z.store().Set(v)
 }
 
 func (z *Zone) SetKey(k string, v map[Type][]Record) {
-	z.RRs.SetKey(k, v)
+	z.store().SetKey(k, v)
 }
 
 func (z *Zone) Len() int {
-	return z.RRs.Len()
+	return z.store().Len()
 }
 
 func (z *Zone) GetKey(k string) (map[Type][]Record, bool) {
-	return z.RRs.GetKey(k)
+	return z.store().GetKey(k)
 }
 
 func (z *Zone) DeleteKey(k string) {
-	z.RRs.DeleteKey(k)
+	z.store().DeleteKey(k)
 }
 
 func (z *Zone) DeleteRecordInKey(k string, r Record) {
-	z.RRs.DeleteRecordInKey(k, r)
+	z.store().DeleteRecordInKey(k, r)
 }
 
 func (z *Zone) AppendRecordInKey(k string, r Record) {
-	z.RRs.AppendRecordInKey(k, r)
+	z.store().AppendRecordInKey(k, r)
 }
 
 func (z *Zone) GetAll() map[string]map[Type][]Record {
-	return z.RRs.GetAll()
+	return z.store().GetAll()
+}
+
+func (z *Zone) Txn(f func(*RRSetTxn) error) error {
+	return z.store().Txn(f)
+}
+
+func (z *Zone) Range(f func(string, Type, Record) bool) {
+	z.store().Range(f)
+}
+
+func (z *Zone) AppendEntryInKey(k string, e *RREntry) {
+	z.store().AppendEntryInKey(k, e)
+}
+
+func (z *Zone) AppendLeaseInKey(k string, r Record, lease time.Duration) {
+	z.store().AppendLeaseInKey(k, r, lease)
+}
+
+func (z *Zone) AppendClassRecordInKey(k string, class Class, r Record) {
+	z.store().AppendClassRecordInKey(k, class, r)
+}
+
+func (z *Zone) Stats() RRSetStats {
+	return z.store().Stats()
+}
+
+// DumpText returns a zone file style listing of the zone, for debugging.
+func (z *Zone) DumpText() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "$ORIGIN %s\n", z.Origin)
+	fmt.Fprintf(&b, "$TTL %d\n", z.TTL/time.Second)
+	if z.SOA != nil {
+		fmt.Fprintf(&b, "@\t\tIN\tSOA\t%s\n", z.SOA.Key())
+	}
+	b.WriteString(z.store().DumpText())
+
+	return b.String()
+}
+
+func (z *Zone) GetEntries(name string, t Type) []*RREntry {
+	return z.store().GetEntries(name, t)
+}
+
+func (z *Zone) GetRecords(name string, t Type) []Record {
+	return z.store().GetRecords(name, t)
+}
+
+func (z *Zone) Names() []string {
+	return z.store().Names()
+}
+
+func (z *Zone) CountByType() map[Type]int {
+	return z.store().CountByType()
+}
+
+func (z *Zone) Watch(ctx context.Context) <-chan ChangeEvent {
+	return z.store().Watch(ctx)
 }
 
 func (z *Zone) SetBeforeOnClear(v func(map[string]map[Type][]Record)) {
-	z.RRs.SetBeforeOnClear(v)
+	z.store().SetBeforeOnClear(v)
 }
 
 func (z *Zone) SetBeforeOnChange(v func(Event, string, interface{}, interface{})) {
-	z.RRs.SetBeforeOnChange(v)
+	z.store().SetBeforeOnChange(v)
 }
 
 func (z *Zone) SetBeforeOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetBeforeOnSetKey(v)
+	z.store().SetBeforeOnSetKey(v)
 }
 
 func (z *Zone) SetBeforeDeleteKey(v func(string, map[Type][]Record)) {
-	z.RRs.SetBeforeDeleteKey(v)
+	z.store().SetBeforeDeleteKey(v)
 }
 
 func (z *Zone) SetBeforeOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetBeforeOnDeleteKeyInRecord(v)
+	z.store().SetBeforeOnDeleteKeyInRecord(v)
 }
 
 func (z *Zone) SetBeforeOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetBeforeOnAppendKeyInRecord(v)
+	z.store().SetBeforeOnAppendKeyInRecord(v)
 }
 
 func (z *Zone) SetOnClear(v func(map[string]map[Type][]Record)) {
-	z.RRs.SetOnClear(v)
+	z.store().SetOnClear(v)
 }
 
 func (z *Zone) SetOnChange(v func(Event, string, interface{}, interface{})) {
-	z.RRs.SetOnChange(v)
+	z.store().SetOnChange(v)
 }
 
 func (z *Zone) SetOnSetKey(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetOnSetKey(v)
+	z.store().SetOnSetKey(v)
 }
 
 func (z *Zone) SetOnDeleteKey(v func(string, map[Type][]Record)) {
-	z.RRs.SetOnDeleteKey(v)
+	z.store().SetOnDeleteKey(v)
 }
 
 func (z *Zone) SetOnDeleteKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetOnDeleteKeyInRecord(v)
+	z.store().SetOnDeleteKeyInRecord(v)
 }
 
 func (z *Zone) SetOnAppendKeyInRecord(v func(string, map[Type][]Record, map[Type][]Record)) {
-	z.RRs.SetOnAppendKeyInRecord(v)
+	z.store().SetOnAppendKeyInRecord(v)
 }
 
 // ServeDNS answers DNS queries in zone z.
 func (z *Zone) ServeDNS(ctx context.Context, w MessageWriter, r *Query) {
+	if pw, ok := w.(packedWriter); ok && z.PackCacheSize > 0 && z.Selector == nil {
+		z.servePacked(pw, r)
+		return
+	}
+
+	z.serve(w, r)
+}
+
+// serve writes the answers to r. It reports whether a record was found, the
+// keys the answers were read from, and whether an answer has a TTL that
+// depends on the current time.
+func (z *Zone) serve(w MessageWriter, r *Query) (found bool, keys []string, volatile bool) {
 	w.Authoritative(true)
 
-	var found bool
+	var answered []Resource // if FullResponses
+
 	for _, q := range r.Questions {
-		if !strings.HasSuffix(q.Name, z.Origin) {
+		dn, ok := z.key(q.Name)
+		if !ok {
 			continue
 		}
-		if q.Type == TypeSOA && q.Name == z.Origin {
+		if q.Type == TypeSOA && dn == "" && z.SOA != nil && classMatch(ClassIN, q.Class) {
 			w.Answer(q.Name, z.TTL, z.SOA)
 			found = true
 
 			continue
 		}
+		if q.Type == TypeAXFR && dn == "" && z.SOA != nil && classMatch(ClassIN, q.Class) {
+			z.serveTransfer(w, q)
+
+			// a transfer depends on every key, not only on the keys read
+			found, volatile = true, true
+
+			continue
+		}
+
+		// the names below a delegation are referred to it, and the names
+		// below a DNAME answered with it
+		var (
+			cut  string
+			kind Type
+		)
+		if cut, kind, keys = z.cut(dn, keys); cut != "" {
+			if kind == TypeNS {
+				keys = z.serveReferral(w, r, cut, q.Class, keys, &volatile)
+			} else {
+				var answers []Resource
+				if answers, keys, ok = z.dname(r, q, cut, keys, &volatile); !ok {
+					w.Status(YXDomain)
+				}
+
+				for _, res := range answers {
+					writeAnswer(w, res)
 
-		dn := q.Name[:len(q.Name)-len(z.Origin)-1]
+					if z.FullResponses {
+						answered = append(answered, res)
+					}
+				}
+			}
+			found = true
 
-		rrs, ok := z.RRs.GetKey(dn)
+			continue
+		}
+
+		rrs, ok := z.store().GetKey(dn)
 		if !ok {
 			continue
 		}
 
-		for _, rr := range rrs[q.Type] {
-			w.Answer(q.Name, z.TTL, rr)
+		var answers []Resource
+		if q.Type == TypeALL {
+			answers = z.anyAnswers(r, q.Name, q.Class, dn, rrs, &volatile)
+		} else {
+			answers = z.answers(r, q.Name, q.Class, rrs[q.Type], &volatile)
+		}
+
+		for _, res := range answers {
+			writeAnswer(w, res)
 			found = true
 
-			if r.RecursionDesired && rr.Type() == TypeCNAME {
-				name := rr.(*CNAME).CNAME
-				dn := name[:len(name)-len(z.Origin)-1]
+			if z.FullResponses {
+				answered = append(answered, res)
+			}
+
+			if r.RecursionDesired && q.Type != TypeALL && res.Record.Type() == TypeCNAME {
+				var chased []Resource
+				chased, keys = z.chase(r, q, res.Record.(*CNAME).CNAME, keys, &volatile)
+
+				for _, res := range chased {
+					writeAnswer(w, res)
 
-				if rrs, ok := z.RRs.GetKey(dn); ok {
-					for _, rr := range rrs[q.Type] {
-						w.Answer(name, z.TTL, rr)
+					if z.FullResponses {
+						answered = append(answered, res)
 					}
 				}
 			}
 		}
 	}
 
+	if len(answered) > 0 {
+		keys = z.serveSections(w, r, answered, keys, &volatile)
+	}
+
 	if !found {
 		w.Status(NXDomain)
 
-		if z.SOA != nil {
+		if z.SOA != nil && (len(r.Questions) == 0 || classMatch(ClassIN, r.Questions[0].Class)) {
 			w.Authority(z.Origin, z.TTL, z.SOA)
 		}
 	}
+	return found, keys, volatile
+}
+
+// chase returns the answers for question q of the CNAME target name, if in the
+// zone. It returns keys with the keys read.
+func (z *Zone) chase(r *Query, q Question, name string, keys []string, volatile *bool) ([]Resource, []string) {
+	dn, ok := z.key(name)
+	if !ok {
+		return nil, keys
+	}
+
+	keys = append(keys, dn)
+
+	rrs, ok := z.store().GetKey(dn)
+	if !ok {
+		return nil, keys
+	}
+	return z.answers(r, name, q.Class, rrs[q.Type], volatile), keys
+}
+
+// serveSections writes the NS records of the origin to the authority section,
+// unless answered, and the addresses of the targets of the answers and
+// authorities to the additional section. It returns keys with the keys read.
+func (z *Zone) serveSections(w MessageWriter, r *Query, answered []Resource, keys []string, volatile *bool) []string {
+	origin, class := z.fqdn(""), answered[0].Class
+
+	var targets []string
+	target := func(res Resource) {
+		var name string
+		switch rr := res.Record.(type) {
+		case *NS:
+			name = rr.NS
+		case *MX:
+			name = rr.MX
+		case *SRV:
+			name = rr.Target
+		default:
+			return
+		}
+		for _, t := range targets {
+			if strings.EqualFold(t, name) {
+				return
+			}
+		}
+		targets = append(targets, name)
+	}
+
+	apex := false
+	for _, res := range answered {
+		target(res)
+		apex = apex || (res.Record.Type() == TypeNS && strings.EqualFold(res.Name, origin))
+	}
+
+	if !apex {
+		keys = append(keys, "")
+		if rrs, ok := z.store().GetKey(""); ok {
+			for _, res := range z.answers(r, origin, class, rrs[TypeNS], volatile) {
+				writeAuthority(w, res)
+				target(res)
+			}
+		}
+	}
+
+	return z.serveAddresses(w, r, class, targets, keys, volatile)
+}
+
+// answers returns the answers for name from its records rrs of the class,
+// selected by the Selector of the zone. volatile is set if a record expires.
+func (z *Zone) answers(r *Query, name string, class Class, rrs []Record, volatile *bool) []Resource {
+	var answers []Resource
+	for _, rr := range rrs {
+		*volatile = *volatile || leased(rr)
+
+		c := recordClass(rr)
+		if !classMatch(c, class) {
+			continue
+		}
+		if _, ttl, ok := z.answer(rr); ok {
+			answers = append(answers, Resource{Name: name, Class: c, TTL: ttl, Record: rr})
+		}
+	}
+
+	if z.Selector != nil && len(answers) > 0 {
+		answers = z.Selector.Select(r, answers)
+	}
+	for i := range answers {
+		answers[i].Record, _ = unwrapRecord(answers[i].Record)
+	}
+	return answers
+}
+
+// anyAnswers returns the answers for name to a query of type ALL, from the
+// records rrs of its key dn: the RRsets of every type, in type order, or the
+// first one if MinimalANY is set, up to MaxANYAnswers records.
+func (z *Zone) anyAnswers(r *Query, name string, class Class, dn string, rrs map[Type][]Record, volatile *bool) []Resource {
+	max := z.MaxANYAnswers
+	if max <= 0 {
+		max = defaultMaxANYAnswers
+	}
+
+	var answers []Resource
+	if dn == "" && z.SOA != nil && classMatch(ClassIN, class) {
+		answers = append(answers, Resource{Name: name, Class: ClassIN, TTL: z.TTL, Record: z.SOA})
+	}
+
+	types := make([]Type, 0, len(rrs))
+	for t := range rrs {
+		if t != TypeSOA || len(answers) == 0 {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	for _, t := range types {
+		if len(answers) >= max || (z.MinimalANY && len(answers) > 0) {
+			break
+		}
+		answers = append(answers, z.answers(r, name, class, rrs[t], volatile)...)
+	}
+
+	if len(answers) > max {
+		answers = answers[:max]
+	}
+	return answers
+}
+
+// answer returns the record to answer with and its TTL, or false if the record
+// is unhealthy.
+func (z *Zone) answer(rr Record) (Record, time.Duration, bool) {
+	var expires time.Time
+	if e, ok := rr.(*RREntry); ok {
+		if e.Meta.Unhealthy {
+			return nil, 0, false
+		}
+		expires = e.Expires
+	}
+
+	rr, ttl := unwrapRecord(rr)
+	if ttl == 0 {
+		ttl = z.TTL
+	}
+
+	if !expires.IsZero() {
+		lease := time.Until(expires).Truncate(time.Second)
+		if lease <= 0 {
+			return nil, 0, false
+		}
+		if lease < ttl {
+			ttl = lease
+		}
+	}
+	return rr, ttl, true
+}
+
+// zoneRRset is an RRset of a zone, with the TTL of its answers.
+type zoneRRset struct {
+	name string
+	t    Type
+	ttl  time.Duration
+	rrs  []Record
+}
+
+// values returns the records of the RRset in presentation format.
+func (s zoneRRset) values() []string {
+	values := make([]string, len(s.rrs))
+	for i, rr := range s.rrs {
+		values[i] = rdataText(rr)
+	}
+	return values
+}
+
+// zoneRRsets returns the RRsets of class IN of z answered, sorted by name and
+// type, without its SOA record. The TTL of an RRset is the lowest of its
+// records.
+func zoneRRsets(z *Zone) []zoneRRset {
+	type key struct {
+		name string
+		t    Type
+	}
+	byKey := make(map[key]*zoneRRset)
+
+	z.store().Range(func(name string, t Type, rr Record) bool {
+		if t == TypeSOA || recordClass(rr) != ClassIN {
+			return true
+		}
+
+		rr, ttl, ok := z.answer(rr)
+		if !ok {
+			return true
+		}
+
+		k := key{z.fqdn(name), t}
+		set := byKey[k]
+		if set == nil {
+			set = &zoneRRset{name: k.name, t: t, ttl: ttl}
+			byKey[k] = set
+		}
+		if ttl < set.ttl {
+			set.ttl = ttl
+		}
+		set.rrs = append(set.rrs, rr)
+		return true
+	})
+
+	sets := make([]zoneRRset, 0, len(byKey))
+	for _, set := range byKey {
+		sets = append(sets, *set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].name != sets[j].name {
+			return sets[i].name < sets[j].name
+		}
+		return sets[i].t < sets[j].t
+	})
+	return sets
+}
+
+// recordClass returns the class of the stored record rr, IN unless it is an
+// entry of another class.
+func recordClass(rr Record) Class {
+	if e, ok := rr.(*RREntry); ok && e.Class != 0 {
+		return e.Class
+	}
+	return ClassIN
+}
+
+// classMatch reports whether a record of class c answers a question of class
+// qc. A question of class ANY matches every record, and one of class zero is
+// taken as IN.
+func classMatch(c, qc Class) bool {
+	return c == qc || qc == ClassANY || (qc == 0 && c == ClassIN)
+}
+
+// leased reports whether rr is an entry that expires.
+func leased(rr Record) bool {
+	e, ok := rr.(*RREntry)
+	return ok && !e.Expires.IsZero()
+}
+
+// NameKey returns the RRSet key of the domain name fqdn within the zone, such
+// as "www" for "www.example.com." in the zone example.com., or false if the
+// name is outside the zone. Names are compared case-insensitively.
+func (z *Zone) NameKey(fqdn string) (string, bool) { return z.key(fqdn) }
+
+// key returns the RRSet key of a domain name within the zone, or false if the
+// name is outside the zone. Names are compared case-insensitively.
+func (z *Zone) key(fqdn string) (string, bool) {
+	name, origin := NormalizeKey(fqdn), NormalizeKey(z.Origin)
+
+	switch {
+	case name == origin:
+		return "", true
+	case origin == "":
+		return name, true
+	case strings.HasSuffix(name, "."+origin):
+		return name[:len(name)-len(origin)-1], true
+	}
+	return "", false
 }
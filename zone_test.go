@@ -15,7 +15,7 @@ var localhostZone = &Zone{
 		NS:   "dns.localhost.",
 		MBox: "hostmaster.localhost.",
 	},
-	RRs: RRSet{
+	RRs: NewRRSet(map[string]map[Type][]Record{
 		"1.app": {
 			TypeA: {
 				&A{net.IPv4(10, 42, 0, 1).To4()},
@@ -57,7 +57,7 @@ var localhostZone = &Zone{
 				&CNAME{CNAME: "app.localhost."},
 			},
 		},
-	},
+	}),
 }
 
 func TestZone(t *testing.T) {
@@ -95,7 +95,7 @@ func TestZone(t *testing.T) {
 	}
 
 	for i, answer := range res.Answers {
-		rec := localhostZone.RRs["app"][TypeA][i]
+		rec := localhostZone.GetRecords("app", TypeA)[i]
 		if want, got := rec.(*A), answer.Record.(*A); !reflect.DeepEqual(*want, *got) {
 			t.Errorf("want answer record %+v, got %+v", *want, *got)
 		}
@@ -179,13 +179,361 @@ func TestZone(t *testing.T) {
 	if want, got := 4, len(res.Answers); want != got {
 		t.Errorf("want %d answers, got %d", want, got)
 	}
-	if want, got := localhostZone.RRs["cname"][TypeA][0].(*CNAME), res.Answers[0].Record.(*CNAME); !reflect.DeepEqual(*want, *got) {
+	if want, got := localhostZone.GetRecords("cname", TypeA)[0].(*CNAME), res.Answers[0].Record.(*CNAME); !reflect.DeepEqual(*want, *got) {
 		t.Fatalf("want %+v record, got %+v", want, got)
 	}
 	for i, answer := range res.Answers[1:] {
-		rec := localhostZone.RRs["app"][TypeA][i]
+		rec := localhostZone.GetRecords("app", TypeA)[i]
 		if want, got := rec.(*A), answer.Record.(*A); !reflect.DeepEqual(*want, *got) {
 			t.Errorf("want answer record %+v, got %+v", *want, *got)
 		}
 	}
 }
+
+func TestZoneEntries(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "localhost.",
+		TTL:    time.Hour,
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+	zone.AppendEntryInKey("app", &RREntry{
+		Record: &A{A: net.IPv4(10, 0, 0, 2).To4()},
+		TTL:    time.Minute,
+		Meta:   RRMeta{Source: "test"},
+	})
+	zone.AppendEntryInKey("app", &RREntry{
+		Record: &A{A: net.IPv4(10, 0, 0, 3).To4()},
+		Meta:   RRMeta{Unhealthy: true},
+	})
+
+	entries := zone.GetEntries("app", TypeA)
+	if want, got := 3, len(entries); want != got {
+		t.Fatalf("want %d entries, got %d", want, got)
+	}
+	if want, got := "test", entries[1].Meta.Source; want != got {
+		t.Errorf("want entry source %q, got %q", want, got)
+	}
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &Query{
+		RemoteAddr: addr,
+		Message: &Message{
+			Questions: []Question{
+				{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+			},
+		},
+	}
+
+	res, err := new(Client).Do(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(res.Answers); want != got {
+		t.Fatalf("want %d answers, got %d", want, got)
+	}
+	if want, got := time.Hour, res.Answers[0].TTL; want != got {
+		t.Errorf("want TTL %s, got %s", want, got)
+	}
+	if want, got := time.Minute, res.Answers[1].TTL; want != got {
+		t.Errorf("want TTL %s, got %s", want, got)
+	}
+	if want, got := net.IPv4(10, 0, 0, 2), res.Answers[1].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+
+	q.Questions[0].Name = "APP.LocalHost."
+	if res, err = new(Client).Do(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(res.Answers); want != got {
+		t.Errorf("want %d answers for mixed case name, got %d", want, got)
+	}
+}
+
+func TestZoneFullResponses(t *testing.T) {
+	t.Parallel()
+
+	for _, packCache := range []int{0, 16} {
+		zone := &Zone{
+			Origin:        "full.dev.",
+			TTL:           time.Hour,
+			SOA:           &SOA{NS: "ns1.full.dev.", MBox: "hostmaster.full.dev."},
+			PackCacheSize: packCache,
+			FullResponses: true,
+			RRs: NewRRSet(map[string]map[Type][]Record{
+				"": {
+					TypeNS: {&NS{NS: "ns1.full.dev."}, &NS{NS: "ns.elsewhere.dev."}},
+					TypeMX: {&MX{Pref: 10, MX: "mail.full.dev."}},
+				},
+				"ns1":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 53).To4()}}},
+				"mail": {TypeAAAA: {&AAAA{AAAA: net.ParseIP("2001:db8::25")}}},
+				"_sip._udp": {
+					TypeSRV: {&SRV{Priority: 1, Port: 5060, Target: "sip.full.dev."}},
+				},
+				"sip": {TypeA: {&A{A: net.IPv4(192, 0, 2, 5).To4()}}},
+			}),
+		}
+
+		serve := func(name string, typ Type) *Message {
+			q := &Query{Message: &Message{
+				Questions: []Question{{Name: name, Type: typ, Class: ClassIN}},
+			}}
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			zone.ServeDNS(context.Background(), w, q)
+
+			b, err := w.pack(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := new(Message)
+			if _, err := msg.Unpack(b); err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		}
+
+		tests := []struct {
+			name string
+			typ  Type
+
+			authorities []string
+			additionals []string
+		}{
+			{
+				name:        "full.dev.",
+				typ:         TypeNS,
+				additionals: []string{"ns1.full.dev."},
+			},
+			{
+				name:        "full.dev.",
+				typ:         TypeMX,
+				authorities: []string{"full.dev.", "full.dev."},
+				additionals: []string{"mail.full.dev.", "ns1.full.dev."},
+			},
+			{
+				name:        "_sip._udp.full.dev.",
+				typ:         TypeSRV,
+				authorities: []string{"full.dev.", "full.dev."},
+				additionals: []string{"sip.full.dev.", "ns1.full.dev."},
+			},
+			{
+				name: "none.full.dev.",
+				typ:  TypeA,
+
+				authorities: []string{"full.dev."}, // SOA
+			},
+		}
+
+		for _, test := range tests {
+			for i := 0; i < 2; i++ { // from the pack cache the second time
+				msg := serve(test.name, test.typ)
+
+				var authorities, additionals []string
+				for _, res := range msg.Authorities {
+					authorities = append(authorities, res.Name)
+				}
+				for _, res := range msg.Additionals {
+					additionals = append(additionals, res.Name)
+				}
+
+				if want, got := test.authorities, authorities; !reflect.DeepEqual(want, got) {
+					t.Errorf("%s %d: want authorities %q, got %q", test.name, test.typ, want, got)
+				}
+				if want, got := test.additionals, additionals; !reflect.DeepEqual(want, got) {
+					t.Errorf("%s %d: want additionals %q, got %q", test.name, test.typ, want, got)
+				}
+			}
+		}
+
+		// the cached responses with the glue of a changed name are dropped
+		entries := zone.PackCacheStats().Entries
+		zone.SetKey("mail", map[Type][]Record{TypeA: {&A{A: net.IPv4(192, 0, 2, 25).To4()}}})
+
+		deadline := time.Now().Add(time.Second)
+		for packCache > 0 && zone.PackCacheStats().Entries == entries {
+			if time.Now().After(deadline) {
+				t.Fatal("cached response not invalidated")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		msg := serve("full.dev.", TypeMX)
+		if want, got := TypeA, msg.Additionals[0].Record.Type(); want != got {
+			t.Errorf("want glue of type %d, got %d", want, got)
+		}
+
+		zone.Close()
+	}
+}
+
+func TestZoneANY(t *testing.T) {
+	t.Parallel()
+
+	zone := func(minimal bool, max int) *Zone {
+		return &Zone{
+			Origin:        "any.dev.",
+			TTL:           time.Hour,
+			SOA:           &SOA{NS: "ns.any.dev.", MBox: "hostmaster.any.dev."},
+			MinimalANY:    minimal,
+			MaxANYAnswers: max,
+			RRs: NewRRSet(map[string]map[Type][]Record{
+				"": {
+					TypeNS: {&NS{NS: "ns.any.dev."}},
+				},
+				"app": {
+					TypeTXT:  {&TXT{TXT: []string{"app"}}},
+					TypeAAAA: {&AAAA{AAAA: net.ParseIP("2001:db8::1")}},
+					TypeA: {
+						&A{A: net.IPv4(192, 0, 2, 1).To4()},
+						&A{A: net.IPv4(192, 0, 2, 2).To4()},
+					},
+				},
+			}),
+		}
+	}
+
+	tests := []struct {
+		name string
+
+		zone  *Zone
+		qname string
+		types []Type
+	}{
+		{
+			name:  "every type",
+			zone:  zone(false, 0),
+			qname: "app.any.dev.",
+			types: []Type{TypeA, TypeA, TypeTXT, TypeAAAA},
+		},
+		{
+			name:  "origin",
+			zone:  zone(false, 0),
+			qname: "any.dev.",
+			types: []Type{TypeSOA, TypeNS},
+		},
+		{
+			name:  "minimal",
+			zone:  zone(true, 0),
+			qname: "app.any.dev.",
+			types: []Type{TypeA, TypeA},
+		},
+		{
+			name:  "max answers",
+			zone:  zone(false, 3),
+			qname: "app.any.dev.",
+			types: []Type{TypeA, TypeA, TypeTXT},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{
+				Message: &Message{
+					Questions: []Question{
+						{Name: test.qname, Type: TypeALL, Class: ClassIN},
+					},
+				},
+			}
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			test.zone.ServeDNS(context.Background(), w, q)
+
+			var types []Type
+			for _, res := range w.msg.Answers {
+				types = append(types, res.Record.Type())
+			}
+			if want, got := test.types, types; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answer types %v, got %v", want, got)
+			}
+			if want, got := NoError, w.msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestZoneClasses(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin: "class.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.class.dev.", MBox: "hostmaster.class.dev."},
+	}
+	zone.AppendRecordInKey("version", &TXT{TXT: []string{"in"}})
+	zone.AppendClassRecordInKey("version", ClassCH, &TXT{TXT: []string{"chaos"}})
+
+	tests := []struct {
+		name string
+
+		question Question
+		rcode    RCode
+		answers  []Resource
+	}{
+		{
+			name:     "IN",
+			question: Question{Name: "version.class.dev.", Type: TypeTXT, Class: ClassIN},
+			answers: []Resource{
+				{Name: "version.class.dev.", Class: ClassIN, TTL: time.Hour, Record: &TXT{TXT: []string{"in"}}},
+			},
+		},
+		{
+			name:     "CH",
+			question: Question{Name: "version.class.dev.", Type: TypeTXT, Class: ClassCH},
+			answers: []Resource{
+				{Name: "version.class.dev.", Class: ClassCH, TTL: time.Hour, Record: &TXT{TXT: []string{"chaos"}}},
+			},
+		},
+		{
+			name:     "ANY",
+			question: Question{Name: "version.class.dev.", Type: TypeTXT, Class: ClassANY},
+			answers: []Resource{
+				{Name: "version.class.dev.", Class: ClassIN, TTL: time.Hour, Record: &TXT{TXT: []string{"in"}}},
+				{Name: "version.class.dev.", Class: ClassCH, TTL: time.Hour, Record: &TXT{TXT: []string{"chaos"}}},
+			},
+		},
+		{
+			name:     "HS",
+			question: Question{Name: "version.class.dev.", Type: TypeTXT, Class: ClassHS},
+			rcode:    NXDomain,
+		},
+		{
+			name:     "SOA of another class",
+			question: Question{Name: "class.dev.", Type: TypeSOA, Class: ClassCH},
+			rcode:    NXDomain,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			q := &Query{Message: &Message{Questions: []Question{test.question}}}
+
+			w := &clientWriter{messageWriter: &messageWriter{msg: response(q.Message)}}
+			zone.ServeDNS(context.Background(), w, q)
+
+			if want, got := test.rcode, w.msg.RCode; want != got {
+				t.Errorf("want rcode %v, got %v", want, got)
+			}
+			if want, got := test.answers, w.msg.Answers; !reflect.DeepEqual(want, got) {
+				t.Errorf("want answers %+v, got %+v", want, got)
+			}
+		})
+	}
+}
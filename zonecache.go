@@ -0,0 +1,309 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PackCacheStats is a snapshot of the counters of the pack cache of a Zone.
+type PackCacheStats struct {
+	Hits   uint64 // queries answered with a cached response
+	Misses uint64 // cacheable queries answered by packing the records
+
+	Entries int // number of cached responses
+	Bytes   int // size of the cached responses and their names
+}
+
+// ednsBuckets are the upper bounds of the EDNS payload sizes that share a
+// cached response.
+var ednsBuckets = [...]int{512, 1232, 4096, 65535}
+
+// packKey identifies the queries that get the same packed response, apart
+// from the ID and the EDNS values echoed from the query.
+type packKey struct {
+	name  string
+	qtype Type
+	class Class
+	bits  uint16 // header flags and codes of the query
+	edns  int    // EDNS payload size bucket, 0 without EDNS
+}
+
+// newPackKey returns the key of a query that is answered from the pack cache,
+// or false if the query is not cacheable: it must have a single question and
+// no records other than an EDNS OPT record without options.
+func newPackKey(m *Message) (packKey, bool) {
+	if len(m.Questions) != 1 || len(m.Answers) != 0 || len(m.Authorities) != 0 {
+		return packKey{}, false
+	}
+
+	q := m.Questions[0]
+	key := packKey{
+		name:  q.Name,
+		qtype: q.Type,
+		class: q.Class,
+		bits:  m.headerBits(),
+	}
+
+	switch len(m.Additionals) {
+	case 0:
+	case 1:
+		res := m.Additionals[0]
+		if opt, ok := res.Record.(*OPT); !ok || len(opt.Options) != 0 || res.Name != "." {
+			return packKey{}, false
+		}
+
+		for _, max := range ednsBuckets {
+			if int(res.Class) <= max {
+				key.edns = max
+				break
+			}
+		}
+	default:
+		return packKey{}, false
+	}
+
+	return key, true
+}
+
+// packedReply returns a copy of the cached response b for the query m.
+func packedReply(b []byte, m *Message) []byte {
+	b = append([]byte(nil), b...)
+	nbo.PutUint16(b[:2], uint16(m.ID))
+
+	// The OPT record of the query is echoed as the last record, with an
+	// empty owner name and RDATA.
+	if len(m.Additionals) == 1 {
+		opt, n := m.Additionals[0], len(b)
+		nbo.PutUint16(b[n-8:n-6], uint16(opt.Class))
+		nbo.PutUint32(b[n-6:n-2], uint32(opt.TTL/time.Second))
+	}
+	return b
+}
+
+// servePacked answers r from the pack cache, or writes the answers and caches
+// the packed response.
+func (z *Zone) servePacked(w packedWriter, r *Query) {
+	c := z.packCache()
+
+	key, ok := newPackKey(r.Message)
+	if !ok {
+		z.serve(w, r)
+		return
+	}
+
+	if b, ok := c.get(key); ok {
+		if err := w.SetPacked(packedReply(b, r.Message)); err == nil {
+			return
+		}
+	}
+
+	gen := c.generation()
+
+	found, keys, volatile := z.serve(w, r)
+	if !found || volatile {
+		return
+	}
+	if b, err := w.Pack(); err == nil {
+		c.put(key, gen, b, keys)
+	}
+}
+
+func (z *Zone) packCache() *packCache {
+	z.packo.Do(func() {
+		z.packc = &packCache{size: z.PackCacheSize}
+		z.packc.flush()
+
+		if z.PackCacheSize > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			z.packc.cancel = cancel
+
+			go z.packc.watch(ctx, z.store(), z.store().Watch(ctx))
+		}
+	})
+	return z.packc
+}
+
+// PackCacheStats returns the counters of the pack cache.
+func (z *Zone) PackCacheStats() PackCacheStats {
+	return z.packCache().stats()
+}
+
+// FlushPackCache drops the cached responses. Changes to the records of the
+// zone are tracked through Watch, but FlushPackCache must be called after
+// changing the Origin, TTL or SOA of the zone.
+func (z *Zone) FlushPackCache() {
+	z.packCache().flush()
+}
+
+// Close stops the pack cache from tracking changes to the records of the zone
+// and drops the cached responses. Queries are still answered after Close, but
+// without the pack cache.
+func (z *Zone) Close() error {
+	z.packCache().close()
+	return nil
+}
+
+// packCache holds packed responses of a Zone. Each response is dropped on a
+// change of the keys its answers were read from.
+type packCache struct {
+	mu sync.Mutex
+
+	size    int
+	gen     uint64 // incremented by each invalidation
+	entries map[packKey]*packEntry
+	deps    map[string]map[packKey]struct{}
+
+	hits, misses uint64
+	bytes        int
+
+	cancel context.CancelFunc // stops watch
+	closed bool
+}
+
+type packEntry struct {
+	b    []byte
+	keys []string
+}
+
+func (c *packCache) get(key packKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	return e.b, true
+}
+
+func (c *packCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// put caches b unless the cache was invalidated since generation gen, when b
+// may have been packed from stale records. A random entry is evicted when the
+// cache is full.
+func (c *packCache) put(key packKey, gen uint64, b []byte, keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || c.closed {
+		return
+	}
+
+	c.remove(key)
+	for k := range c.entries {
+		if len(c.entries) < c.size {
+			break
+		}
+		c.remove(k)
+	}
+
+	c.entries[key] = &packEntry{b: b, keys: keys}
+	for _, k := range keys {
+		if c.deps[k] == nil {
+			c.deps[k] = make(map[packKey]struct{})
+		}
+		c.deps[k][key] = struct{}{}
+	}
+	c.bytes += len(b) + len(key.name)
+}
+
+// c.mu held
+func (c *packCache) remove(key packKey) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, key)
+	for _, k := range e.keys {
+		delete(c.deps[k], key)
+		if len(c.deps[k]) == 0 {
+			delete(c.deps, k)
+		}
+	}
+	c.bytes -= len(e.b) + len(key.name)
+}
+
+// invalidate drops the responses read from the key k.
+func (c *packCache) invalidate(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key := range c.deps[k] {
+		c.remove(key)
+	}
+}
+
+func (c *packCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[packKey]*packEntry)
+	c.deps = make(map[string]map[packKey]struct{})
+	c.bytes = 0
+}
+
+func (c *packCache) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.flush()
+}
+
+func (c *packCache) stats() PackCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return PackCacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+		Bytes:   c.bytes,
+	}
+}
+
+// watch invalidates the responses of the keys changed in s. If the watcher
+// falls behind and is dropped, the cache is flushed and watching starts over
+// until ctx is done.
+func (c *packCache) watch(ctx context.Context, s RRStore, events <-chan ChangeEvent) {
+	for {
+		for ev := range events {
+			switch ev.Event {
+			case KEventClear, KEventSet:
+				c.flush()
+			case KEventTxn:
+				for _, change := range ev.Changes {
+					c.invalidate(change.Key)
+				}
+			default:
+				c.invalidate(ev.Key)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		events = s.Watch(ctx)
+		c.flush()
+	}
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestZonePackCache(t *testing.T) {
+	t.Parallel()
+
+	zone := &Zone{
+		Origin:        "localhost.",
+		TTL:           time.Hour,
+		PackCacheSize: 16,
+	}
+	zone.AppendRecordInKey("app", &A{A: net.IPv4(10, 0, 0, 1).To4()})
+
+	srv := mustServer(zone)
+
+	addr, err := net.ResolveUDPAddr("udp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(udpSize Class) *Message {
+		q := &Query{
+			RemoteAddr: addr,
+			Message: &Message{
+				Questions: []Question{
+					{Name: "app.localhost.", Type: TypeA, Class: ClassIN},
+				},
+				Additionals: []Resource{
+					{Name: ".", Class: udpSize, Record: &OPT{}},
+				},
+			},
+		}
+
+		res, err := new(Client).Do(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, got := 1, len(res.Answers); want != got {
+			t.Fatalf("want %d answers, got %d", want, got)
+		}
+		if want, got := 1, len(res.Additionals); want != got {
+			t.Fatalf("want %d additionals, got %d", want, got)
+		}
+		if want, got := udpSize, res.Additionals[0].Class; want != got {
+			t.Errorf("want echoed UDP size %d, got %d", want, got)
+		}
+		return res
+	}
+
+	query(1200)
+	res := query(1232)
+	if want, got := net.IPv4(10, 0, 0, 1), res.Answers[0].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+
+	stats := zone.PackCacheStats()
+	if want, got := uint64(1), stats.Hits; want != got {
+		t.Errorf("want %d hits, got %d", want, got)
+	}
+	if want, got := uint64(1), stats.Misses; want != got {
+		t.Errorf("want %d misses, got %d", want, got)
+	}
+	if want, got := 1, stats.Entries; want != got {
+		t.Errorf("want %d entries, got %d", want, got)
+	}
+	if stats.Bytes == 0 {
+		t.Error("want cached bytes")
+	}
+
+	zone.SetKey("app", map[Type][]Record{TypeA: {&A{A: net.IPv4(10, 0, 0, 2).To4()}}})
+
+	deadline := time.Now().Add(time.Second)
+	for zone.PackCacheStats().Entries != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cached response not invalidated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	res = query(1232)
+	if want, got := net.IPv4(10, 0, 0, 2), res.Answers[0].Record.(*A).A; !want.Equal(got) {
+		t.Errorf("want A record %s, got %s", want, got)
+	}
+}
+
+func TestZoneClose(t *testing.T) {
+	t.Parallel()
+
+	rrs := new(RRSet)
+	zone := &Zone{
+		Origin:        "localhost.",
+		TTL:           time.Hour,
+		RRs:           rrs,
+		PackCacheSize: 16,
+	}
+
+	watchers := func() int {
+		rrs.l.Lock()
+		defer rrs.l.Unlock()
+
+		return len(rrs.watchers)
+	}
+
+	zone.PackCacheStats()
+	if want, got := 1, watchers(); want != got {
+		t.Fatalf("want %d watcher, got %d", want, got)
+	}
+
+	if err := zone.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for watchers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pack cache still watching after Close")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,252 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ZoneDiff is the difference between two versions of a zone: the RRsets
+// added, removed and changed, by name and type, as returned by DiffZones.
+// The SOA records of the versions are apart from the RRsets.
+type ZoneDiff struct {
+	Origin string
+
+	OldSOA, NewSOA *SOA
+	OldTTL, NewTTL time.Duration // of the SOA records
+
+	Added   []DiffRRset
+	Removed []DiffRRset
+	Changed []DiffChange
+}
+
+// DiffRRset is an RRset of a ZoneDiff.
+type DiffRRset struct {
+	Name    string
+	Type    Type
+	TTL     time.Duration
+	Records []Record
+}
+
+// DiffChange is an RRset of both versions of a zone, with other records or
+// another TTL.
+type DiffChange struct {
+	Old, New DiffRRset
+}
+
+// DiffZones returns the difference between the RRsets of class IN of the
+// zones a, the old version, and b, the new one, sorted by name and type. The
+// records are compared by their data and the TTL of their RRset, the lowest
+// of its records.
+func DiffZones(a, b *Zone) ZoneDiff {
+	d := ZoneDiff{
+		Origin: b.fqdn(""),
+		OldSOA: a.SOA,
+		NewSOA: b.SOA,
+		OldTTL: a.TTL,
+		NewTTL: b.TTL,
+	}
+
+	prev, next := zoneRRsets(a), zoneRRsets(b)
+	for len(prev) > 0 || len(next) > 0 {
+		switch {
+		case len(next) == 0 || (len(prev) > 0 && rrsetLess(prev[0], next[0])):
+			d.Removed = append(d.Removed, diffRRset(prev[0]))
+			prev = prev[1:]
+		case len(prev) == 0 || rrsetLess(next[0], prev[0]):
+			d.Added = append(d.Added, diffRRset(next[0]))
+			next = next[1:]
+		default:
+			if prev[0].ttl != next[0].ttl || !sameRecords(prev[0].rrs, next[0].rrs) {
+				d.Changed = append(d.Changed, DiffChange{Old: diffRRset(prev[0]), New: diffRRset(next[0])})
+			}
+			prev, next = prev[1:], next[1:]
+		}
+	}
+	return d
+}
+
+func diffRRset(set zoneRRset) DiffRRset {
+	return DiffRRset{Name: set.name, Type: set.t, TTL: set.ttl, Records: set.rrs}
+}
+
+// rrsetLess reports whether a sorts before b in the order of zoneRRsets.
+func rrsetLess(a, b zoneRRset) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	return a.t < b.t
+}
+
+// sameRecords reports whether a and b hold the same records, in any order.
+func sameRecords(a, b []Record) bool {
+	return len(a) == len(b) && len(missingRecords(a, b)) == 0
+}
+
+// missingRecords returns the records of a not in b.
+func missingRecords(a, b []Record) []Record {
+	var missing []Record
+	for _, rr := range a {
+		found := false
+		for _, other := range b {
+			if rr.Equal(other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, rr)
+		}
+	}
+	return missing
+}
+
+// Empty reports whether the versions of the zone have the same RRsets.
+func (d ZoneDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Deleted returns the records of the old version not in the new one, with
+// the TTL of their RRset. The records of an RRset whose TTL changed are all
+// deleted.
+func (d ZoneDiff) Deleted() []Resource {
+	var rs []Resource
+	for _, set := range d.Removed {
+		rs = set.resources(rs, set.Records)
+	}
+	for _, c := range d.Changed {
+		if c.Old.TTL != c.New.TTL {
+			rs = c.Old.resources(rs, c.Old.Records)
+		} else {
+			rs = c.Old.resources(rs, missingRecords(c.Old.Records, c.New.Records))
+		}
+	}
+	return rs
+}
+
+// Inserted returns the records of the new version not in the old one, with
+// the TTL of their RRset. The records of an RRset whose TTL changed are all
+// inserted.
+func (d ZoneDiff) Inserted() []Resource {
+	var rs []Resource
+	for _, set := range d.Added {
+		rs = set.resources(rs, set.Records)
+	}
+	for _, c := range d.Changed {
+		if c.Old.TTL != c.New.TTL {
+			rs = c.New.resources(rs, c.New.Records)
+		} else {
+			rs = c.New.resources(rs, missingRecords(c.New.Records, c.Old.Records))
+		}
+	}
+	return rs
+}
+
+func (set DiffRRset) resources(rs []Resource, rrs []Record) []Resource {
+	for _, rr := range rrs {
+		rs = append(rs, Resource{Name: set.Name, Class: ClassIN, TTL: set.TTL, Record: rr})
+	}
+	return rs
+}
+
+// IXFR returns the answers of an incremental zone transfer from the old
+// version to the new one, a single difference sequence between the SOA
+// records of the new version, see RFC 1995, section 4: the old SOA record,
+// the records deleted, the new SOA record and the records inserted. Both
+// versions must have a SOA record.
+func (d ZoneDiff) IXFR() []Resource {
+	var (
+		origin = d.Origin
+		newSOA = Resource{Name: origin, Class: ClassIN, TTL: d.NewTTL, Record: d.NewSOA}
+		oldSOA = Resource{Name: origin, Class: ClassIN, TTL: d.OldTTL, Record: d.OldSOA}
+	)
+
+	rs := []Resource{newSOA, oldSOA}
+	rs = append(rs, d.Deleted()...)
+	rs = append(rs, newSOA)
+	rs = append(rs, d.Inserted()...)
+	return append(rs, newSOA)
+}
+
+// String returns the difference as the lines of the records deleted, with a
+// leading "-", then of the records inserted, with a leading "+", in master
+// file format, preceded by the serials of the versions.
+func (d ZoneDiff) String() string {
+	var b strings.Builder
+	if d.OldSOA != nil && d.NewSOA != nil {
+		fmt.Fprintf(&b, "; %s serial %d -> %d\n", d.Origin, uint32(d.OldSOA.Serial), uint32(d.NewSOA.Serial))
+	}
+	for _, res := range d.Deleted() {
+		fmt.Fprintf(&b, "-%s\n", resourceText(res))
+	}
+	for _, res := range d.Inserted() {
+		fmt.Fprintf(&b, "+%s\n", resourceText(res))
+	}
+	return b.String()
+}
+
+func resourceText(res Resource) string {
+	return fmt.Sprintf("%s %d IN %s %s", res.Name, res.TTL/time.Second, typeText(res.Record.Type()), rdataOrGeneric(res.Record))
+}
+
+type diffRRsetJSON struct {
+	Name  string   `json:"name"`
+	Type  string   `json:"type"`
+	TTL   int64    `json:"ttl"`
+	RData []string `json:"rdata"`
+}
+
+func (set DiffRRset) json() diffRRsetJSON {
+	v := diffRRsetJSON{
+		Name:  set.Name,
+		Type:  typeText(set.Type),
+		TTL:   int64(set.TTL / time.Second),
+		RData: make([]string, len(set.Records)),
+	}
+	for i, rr := range set.Records {
+		v.RData[i] = rdataOrGeneric(rr)
+	}
+	return v
+}
+
+// MarshalJSON returns the difference as a JSON object, the records of the
+// RRsets in presentation format.
+func (d ZoneDiff) MarshalJSON() ([]byte, error) {
+	type change struct {
+		Old diffRRsetJSON `json:"old"`
+		New diffRRsetJSON `json:"new"`
+	}
+	v := struct {
+		Origin    string          `json:"origin"`
+		OldSerial *uint32         `json:"old_serial,omitempty"`
+		NewSerial *uint32         `json:"new_serial,omitempty"`
+		Added     []diffRRsetJSON `json:"added"`
+		Removed   []diffRRsetJSON `json:"removed"`
+		Changed   []change        `json:"changed"`
+	}{
+		Origin:  d.Origin,
+		Added:   []diffRRsetJSON{},
+		Removed: []diffRRsetJSON{},
+		Changed: []change{},
+	}
+	if d.OldSOA != nil {
+		serial := uint32(d.OldSOA.Serial)
+		v.OldSerial = &serial
+	}
+	if d.NewSOA != nil {
+		serial := uint32(d.NewSOA.Serial)
+		v.NewSerial = &serial
+	}
+
+	for _, set := range d.Added {
+		v.Added = append(v.Added, set.json())
+	}
+	for _, set := range d.Removed {
+		v.Removed = append(v.Removed, set.json())
+	}
+	for _, c := range d.Changed {
+		v.Changed = append(v.Changed, change{Old: c.Old.json(), New: c.New.json()})
+	}
+	return json.Marshal(v)
+}
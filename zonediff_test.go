@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffZones(t *testing.T) {
+	t.Parallel()
+
+	zone := func(serial int, rrs map[string]map[Type][]Record) *Zone {
+		return &Zone{
+			Origin: "diff.dev.",
+			TTL:    time.Hour,
+			SOA:    &SOA{NS: "ns.diff.dev.", MBox: "hostmaster.diff.dev.", Serial: serial},
+			RRs:    NewRRSet(rrs),
+		}
+	}
+
+	a := zone(1, map[string]map[Type][]Record{
+		"www":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}, &A{A: net.IPv4(192, 0, 2, 2).To4()}}},
+		"old":  {TypeTXT: {&TXT{TXT: []string{"gone"}}}},
+		"mail": {TypeA: {&A{A: net.IPv4(192, 0, 2, 25).To4()}}},
+		"same": {TypeA: {&A{A: net.IPv4(192, 0, 2, 9).To4()}}},
+	})
+	b := zone(2, map[string]map[Type][]Record{
+		"www":  {TypeA: {&A{A: net.IPv4(192, 0, 2, 2).To4()}, &A{A: net.IPv4(192, 0, 2, 3).To4()}}},
+		"new":  {TypeAAAA: {&AAAA{AAAA: net.ParseIP("2001:db8::1")}}},
+		"mail": {TypeA: {&RREntry{Record: &A{A: net.IPv4(192, 0, 2, 25).To4()}, TTL: time.Minute}}},
+		"same": {TypeA: {&A{A: net.IPv4(192, 0, 2, 9).To4()}}},
+	})
+
+	d := DiffZones(a, b)
+	if d.Empty() {
+		t.Fatal("want zones different")
+	}
+	if !DiffZones(a, a).Empty() {
+		t.Error("want no difference of a zone with itself")
+	}
+
+	if want, got := []DiffRRset{{Name: "new.diff.dev.", Type: TypeAAAA, TTL: time.Hour, Records: []Record{&AAAA{AAAA: net.ParseIP("2001:db8::1")}}}}, d.Added; !reflect.DeepEqual(want, got) {
+		t.Errorf("want added %v, got %v", want, got)
+	}
+	if want, got := []DiffRRset{{Name: "old.diff.dev.", Type: TypeTXT, TTL: time.Hour, Records: []Record{&TXT{TXT: []string{"gone"}}}}}, d.Removed; !reflect.DeepEqual(want, got) {
+		t.Errorf("want removed %v, got %v", want, got)
+	}
+	if want, got := 2, len(d.Changed); want != got {
+		t.Fatalf("want %d changed, got %d", want, got)
+	}
+
+	want := "; diff.dev. serial 1 -> 2\n" +
+		"-old.diff.dev. 3600 IN TXT \"gone\"\n" +
+		"-mail.diff.dev. 3600 IN A 192.0.2.25\n" +
+		"-www.diff.dev. 3600 IN A 192.0.2.1\n" +
+		"+new.diff.dev. 3600 IN AAAA 2001:db8::1\n" +
+		"+mail.diff.dev. 60 IN A 192.0.2.25\n" +
+		"+www.diff.dev. 3600 IN A 192.0.2.3\n"
+	if got := d.String(); want != got {
+		t.Errorf("want text\n%s\ngot\n%s", want, got)
+	}
+
+	ixfr := d.IXFR()
+	if want, got := 2+3+1+3+1, len(ixfr); want != got {
+		t.Fatalf("want %d IXFR records, got %d", want, got)
+	}
+	for i, serial := range map[int]int{0: 2, 1: 1, 5: 2, 9: 2} {
+		if soa, ok := ixfr[i].Record.(*SOA); !ok || soa.Serial != serial {
+			t.Errorf("want SOA serial %d at %d, got %v", serial, i, ixfr[i].Record)
+		}
+	}
+
+	b2 := zone(3, map[string]map[Type][]Record{
+		"www": {TypeA: {&A{A: net.IPv4(192, 0, 2, 3).To4()}, &A{A: net.IPv4(192, 0, 2, 2).To4()}}},
+	})
+	if d := DiffZones(zone(2, map[string]map[Type][]Record{
+		"www": {TypeA: {&A{A: net.IPv4(192, 0, 2, 2).To4()}, &A{A: net.IPv4(192, 0, 2, 3).To4()}}},
+	}), b2); !d.Empty() {
+		t.Errorf("want records in another order unchanged, got %v", d)
+	}
+}
+
+func TestZoneDiffJSON(t *testing.T) {
+	t.Parallel()
+
+	a := &Zone{
+		Origin: "diff.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.diff.dev.", MBox: "hostmaster.diff.dev.", Serial: 1},
+		RRs:    NewRRSet(nil),
+	}
+	b := &Zone{
+		Origin: "diff.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.diff.dev.", MBox: "hostmaster.diff.dev.", Serial: 2},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"www": {TypeA: {&A{A: net.IPv4(192, 0, 2, 1).To4()}}},
+		}),
+	}
+
+	buf, err := json.Marshal(DiffZones(a, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"origin":"diff.dev.","old_serial":1,"new_serial":2,` +
+		`"added":[{"name":"www.diff.dev.","type":"A","ttl":3600,"rdata":["192.0.2.1"]}],"removed":[],"changed":[]}`
+	if got := string(buf); want != got {
+		t.Errorf("want JSON %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errZONEMDUnsupported = errors.New("unsupported ZONEMD scheme or hash algorithm")
+	errZONEMDMissing     = errors.New("zone has no supported ZONEMD record")
+	errZONEMDMismatch    = errors.New("zone digest does not match ZONEMD record")
+)
+
+// NewZONEMD returns the ZONEMD record of z computed with the SIMPLE scheme
+// and the hash algorithm hash, such as ZONEMDHashSHA384. The apex ZONEMD
+// records of z are not part of the digest, so the result may be stored at the
+// apex of z with AppendRecordInKey("", rr) once any previous one is removed.
+func NewZONEMD(z *Zone, hash uint8) (*ZONEMD, error) {
+	if z.SOA == nil {
+		return nil, errZONEMDMissing
+	}
+
+	digest, err := zoneDigest(z, ZONEMDSchemeSimple, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ZONEMD{
+		Serial: z.SOA.Serial,
+		Scheme: ZONEMDSchemeSimple,
+		Hash:   hash,
+		Digest: digest,
+	}, nil
+}
+
+// VerifyZONEMD checks the zone digest of z against the ZONEMD records at its
+// apex, see RFC 8976, section 4. The zone is valid if any record with the
+// serial of the SOA record and a supported scheme and hash algorithm matches.
+func VerifyZONEMD(z *Zone) error {
+	if z.SOA == nil {
+		return errZONEMDMissing
+	}
+
+	err := errZONEMDMissing
+	for _, rr := range z.GetRecords("", TypeZONEMD) {
+		rr, _ := unwrapRecord(rr)
+
+		zmd, ok := rr.(*ZONEMD)
+		if !ok || zmd.Serial != z.SOA.Serial {
+			continue
+		}
+
+		digest, derr := zoneDigest(z, zmd.Scheme, zmd.Hash)
+		if derr == errZONEMDUnsupported {
+			continue
+		}
+		if derr != nil {
+			return derr
+		}
+		if subtle.ConstantTimeCompare(digest, zmd.Digest) == 1 {
+			return nil
+		}
+		err = errZONEMDMismatch
+	}
+	return err
+}
+
+// zoneDigest returns the digest of the records of z in canonical form and
+// order, see RFC 8976, section 3.3.
+func zoneDigest(z *Zone, scheme, alg uint8) ([]byte, error) {
+	var h hash.Hash
+	switch {
+	case scheme != ZONEMDSchemeSimple:
+		return nil, errZONEMDUnsupported
+	case alg == ZONEMDHashSHA384:
+		h = sha512.New384()
+	case alg == ZONEMDHashSHA512:
+		h = sha512.New()
+	default:
+		return nil, errZONEMDUnsupported
+	}
+
+	rrs, err := canonicalRRs(z)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range rrs {
+		h.Write(rr.wire)
+	}
+	return h.Sum(nil), nil
+}
+
+type canonicalRR struct {
+	labels []string
+	typ    Type
+	rdata  []byte
+	wire   []byte
+}
+
+// canonicalRRs returns the wire format of the records of z, with the SOA
+// record and without the apex ZONEMD records, sorted and without duplicates.
+func canonicalRRs(z *Zone) ([]canonicalRR, error) {
+	var rrs []canonicalRR
+
+	add := func(name string, ttl time.Duration, rr Record) error {
+		name = strings.ToLower(name)
+
+		b, err := CanonicalBytes(Resource{Name: name, Class: ClassIN, TTL: ttl, Record: rr})
+		if err != nil {
+			return err
+		}
+
+		n, _ := compressor{}.Length(name)
+		rrs = append(rrs, canonicalRR{
+			labels: canonicalLabels(name),
+			typ:    rr.Type(),
+			rdata:  b[n+10:],
+			wire:   b,
+		})
+		return nil
+	}
+
+	if err := add(z.fqdn(""), z.TTL, z.SOA); err != nil {
+		return nil, err
+	}
+
+	var err error
+	z.store().Range(func(name string, t Type, rr Record) bool {
+		if t == TypeSOA || (t == TypeZONEMD && name == "") {
+			return true
+		}
+		if e, ok := rr.(*RREntry); ok && e.Meta.Unhealthy {
+			return true
+		}
+
+		rr, ttl := unwrapRecord(rr)
+		if ttl == 0 {
+			ttl = z.TTL
+		}
+		err = add(z.fqdn(name), ttl, rr)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rrs, func(i, j int) bool {
+		a, b := rrs[i], rrs[j]
+		if c := compareLabels(a.labels, b.labels); c != 0 {
+			return c < 0
+		}
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		return bytes.Compare(a.rdata, b.rdata) < 0
+	})
+
+	out := rrs[:0]
+	for i, rr := range rrs {
+		if i > 0 && bytes.Equal(rr.wire, rrs[i-1].wire) {
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out, nil
+}
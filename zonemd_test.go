@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+// zonemdExample returns the zone of RFC 8976, appendix A.1.
+func zonemdExample() *Zone {
+	return &Zone{
+		Origin: "example.",
+		TTL:    24 * time.Hour,
+		SOA: &SOA{
+			NS:      "ns1.example.",
+			MBox:    "admin.example.",
+			Serial:  2018031900,
+			Refresh: 1800 * time.Second,
+			Retry:   900 * time.Second,
+			Expire:  604800 * time.Second,
+			MinTTL:  86400 * time.Second,
+		},
+		RRs: NewRRSet(map[string]map[Type][]Record{
+			"": {
+				TypeNS: {
+					&NS{NS: "ns1.example."},
+					&NS{NS: "ns2.example."},
+				},
+			},
+			"ns1": {
+				TypeA: {&RREntry{Record: &A{A: net.ParseIP("203.0.113.63").To4()}, TTL: time.Hour}},
+			},
+			"ns2": {
+				TypeAAAA: {&RREntry{Record: &AAAA{AAAA: net.ParseIP("2001:db8::63")}, TTL: time.Hour}},
+			},
+		}),
+	}
+}
+
+func TestNewZONEMD(t *testing.T) {
+	t.Parallel()
+
+	zmd, err := NewZONEMD(zonemdExample(), ZONEMDHashSHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "c68090d90a7aed716bc459f9340e3d7c1370d4d24b7e2fc3a1ddc0b9a87153b9a9713b3c9ae5cc27777f98b8e730044c"
+	if got := hex.EncodeToString(zmd.Digest); want != got {
+		t.Errorf("want digest %s, got %s", want, got)
+	}
+	if want, got := 2018031900, zmd.Serial; want != got {
+		t.Errorf("want serial %d, got %d", want, got)
+	}
+}
+
+func TestVerifyZONEMD(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		update func(*Zone)
+		err    error
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "case insensitive duplicate",
+
+			update: func(z *Zone) {
+				z.AppendRecordInKey("", &NS{NS: "NS1.Example."})
+			},
+		},
+		{
+			name: "duplicate record",
+
+			update: func(z *Zone) {
+				z.AppendRecordInKey("", &NS{NS: "ns1.example."})
+			},
+		},
+		{
+			name: "modified record",
+
+			update: func(z *Zone) {
+				z.AppendRecordInKey("ns3", &A{A: net.ParseIP("203.0.113.64").To4()})
+			},
+			err: errZONEMDMismatch,
+		},
+		{
+			name: "serial mismatch",
+
+			update: func(z *Zone) {
+				z.SOA.Serial++
+			},
+			err: errZONEMDMissing,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			z := zonemdExample()
+			for _, hash := range []uint8{ZONEMDHashSHA384, ZONEMDHashSHA512} {
+				zmd, err := NewZONEMD(z, hash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				z.AppendRecordInKey("", zmd)
+			}
+
+			if test.update != nil {
+				test.update(z)
+			}
+
+			if want, got := test.err, VerifyZONEMD(z); want != got {
+				t.Errorf("want error %v, got %v", want, got)
+			}
+		})
+	}
+}
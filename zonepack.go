@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"errors"
+)
+
+// maxTransferMessageLen is the largest message packed by a ZonePacker. Every
+// offset in such a message can be the target of a compression pointer.
+const maxTransferMessageLen = 0x4000
+
+var (
+	errNoZoneSOA      = errors.New("zone without an SOA record")
+	errRecordTooLarge = errors.New("record larger than a transfer message")
+)
+
+// ZonePacker packs whole zones into a single buffer, as the messages of an
+// AXFR response, see RFC 5936. The buffer and the name compression table are
+// reused from one zone to the next, instead of packing a message per record.
+//
+// The buffer is laid out as on a stream connection, each message prefixed
+// with its length, so it can be written as is to a TCP or TLS connection. It
+// is also a snapshot of the zone, read back by UnpackZone.
+//
+// A ZonePacker must not be used concurrently.
+type ZonePacker struct {
+	// MaxMessageSize bounds the size of each message. If zero, or larger
+	// than 16384 bytes, 16384 is used.
+	MaxMessageSize int
+
+	buf []byte
+	tbl map[string]int
+	com Compressor // compressor of the current message
+
+	start   int // offset of the current message in buf
+	answers int // answers of the current message
+	msg     Message
+}
+
+// Pack packs the records of z between two copies of its SOA record, with the
+// ID id and the question of the zone origin in the first message. The
+// returned buffer is overwritten by the next call to Pack.
+func (p *ZonePacker) Pack(z *Zone, id int) ([]byte, error) {
+	if z.SOA == nil {
+		return nil, errNoZoneSOA
+	}
+	if p.tbl == nil {
+		p.tbl = make(map[string]int)
+	}
+
+	origin := z.fqdn("")
+
+	p.buf = p.buf[:0]
+	p.msg = Message{ID: id, Response: true, Authoritative: true}
+	if err := p.begin([]Question{{Name: origin, Type: TypeAXFR, Class: ClassIN}}); err != nil {
+		return nil, err
+	}
+
+	if err := p.add(Resource{Name: origin, Class: ClassIN, TTL: z.TTL, Record: z.SOA}); err != nil {
+		return nil, err
+	}
+
+	// The records of a name are ranged over together, its FQDN is built
+	// once.
+	key, fqdn := "", origin
+	var err error
+	z.store().Range(func(name string, t Type, rr Record) bool {
+		if t == TypeSOA || recordClass(rr) != ClassIN {
+			return true
+		}
+		if name != key {
+			key, fqdn = name, name+"."+origin
+			if origin == "." {
+				fqdn = name + "."
+			}
+		}
+		if rr, ttl, ok := z.answer(rr); ok {
+			err = p.add(Resource{Name: fqdn, Class: ClassIN, TTL: ttl, Record: rr})
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.add(Resource{Name: origin, Class: ClassIN, TTL: z.TTL, Record: z.SOA}); err != nil {
+		return nil, err
+	}
+	p.end()
+
+	return p.buf, nil
+}
+
+func (p *ZonePacker) maxMessageSize() int {
+	if p.MaxMessageSize <= 0 || p.MaxMessageSize > maxTransferMessageLen {
+		return maxTransferMessageLen
+	}
+	return p.MaxMessageSize
+}
+
+// begin starts a message with the questions, after its length prefix.
+func (p *ZonePacker) begin(questions []Question) error {
+	for k := range p.tbl {
+		delete(p.tbl, k)
+	}
+
+	p.buf = append(p.buf, 0, 0)
+	p.start, p.answers = len(p.buf), 0
+
+	p.msg.Questions = questions
+
+	var err error
+	if p.buf, err = p.msg.packHeader(p.buf); err != nil {
+		return err
+	}
+
+	p.com = compressor{tbl: p.tbl, offset: p.start}
+	for _, q := range questions {
+		if p.buf, err = q.Pack(p.buf, p.com); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end sets the length prefix and the answer count of the current message.
+func (p *ZonePacker) end() {
+	nbo.PutUint16(p.buf[p.start-2:], uint16(len(p.buf)-p.start))
+	nbo.PutUint16(p.buf[p.start+6:], uint16(p.answers))
+}
+
+// add appends res to the answers of the current message, or of a new message
+// if it does not fit.
+func (p *ZonePacker) add(res Resource) error {
+	for {
+		n := len(p.buf)
+
+		b, err := res.Pack(p.buf, p.com)
+		if err != nil {
+			return err
+		}
+		if len(b)-p.start <= p.maxMessageSize() && p.answers < 0xFFFF {
+			p.buf = b
+			p.answers++
+			return nil
+		}
+
+		// The names of res recorded in the table are past the end of the
+		// message, they are dropped by begin.
+		p.buf = b[:n]
+		if p.answers == 0 {
+			return errRecordTooLarge
+		}
+
+		p.end()
+		if err := p.begin(nil); err != nil {
+			return err
+		}
+	}
+}
+
+// UnpackZone returns the zone origin of b, the messages of an AXFR response
+// each prefixed with its length, such as a buffer packed by a ZonePacker.
+func UnpackZone(b []byte, origin string) (*Zone, error) {
+	zr := newZoneReader(origin)
+
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errBaseLen
+		}
+
+		n := int(nbo.Uint16(b))
+		if len(b)-2 < n {
+			return nil, errCalcLen
+		}
+
+		msg := new(Message)
+		if _, err := msg.Unpack(b[2 : 2+n]); err != nil {
+			return nil, err
+		}
+		zr.add(msg)
+
+		b = b[2+n:]
+	}
+	return zr.zone()
+}
@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func packZone(names int) *Zone {
+	all := map[string]map[Type][]Record{
+		"": {TypeNS: {&NS{NS: "ns.pack.dev."}}},
+	}
+	for i := 0; i < names; i++ {
+		all["app"+strconv.Itoa(i)] = map[Type][]Record{
+			TypeA: {
+				&A{A: net.IPv4(10, 0, byte(i>>8), byte(i)).To4()},
+				&RREntry{Record: &A{A: net.IPv4(10, 1, byte(i>>8), byte(i)).To4()}, TTL: time.Minute},
+			},
+		}
+	}
+
+	return &Zone{
+		Origin: "pack.dev.",
+		TTL:    time.Hour,
+		SOA:    &SOA{NS: "ns.pack.dev.", MBox: "hostmaster.pack.dev.", Serial: 3},
+		RRs:    NewRRSet(all),
+	}
+}
+
+func TestZonePacker(t *testing.T) {
+	t.Parallel()
+
+	zone := packZone(200)
+	p := &ZonePacker{MaxMessageSize: 512}
+
+	b, err := p.Pack(zone, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []*Message
+	for rest := b; len(rest) > 0; {
+		n := int(nbo.Uint16(rest))
+		if n > 512 {
+			t.Errorf("message %d: want at most 512 bytes, got %d", len(msgs), n)
+		}
+
+		msg := new(Message)
+		if _, err := msg.Unpack(rest[2 : 2+n]); err != nil {
+			t.Fatalf("message %d: %v", len(msgs), err)
+		}
+		msgs = append(msgs, msg)
+		rest = rest[2+n:]
+	}
+
+	if len(msgs) < 2 {
+		t.Fatalf("want several messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if want, got := 42, msg.ID; want != got {
+			t.Errorf("message %d: want ID %d, got %d", i, want, got)
+		}
+	}
+	if want, got := 1, len(msgs[0].Questions); want != got {
+		t.Errorf("want %d question in the first message, got %d", want, got)
+	}
+
+	var answers int
+	for _, msg := range msgs {
+		answers += len(msg.Answers)
+	}
+	if want, got := 2+1+2*200, answers; want != got {
+		t.Errorf("want %d answers, got %d", want, got)
+	}
+
+	got, err := UnpackZone(b, zone.Origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := zone.SOA.Serial, got.SOA.Serial; want != got {
+		t.Errorf("want serial %d, got %d", want, got)
+	}
+	if want, got := zone.DumpText(), got.DumpText(); want != got {
+		t.Errorf("want zone\n%s\ngot\n%s", want, got)
+	}
+
+	// the buffer is reused
+	if b2, err := p.Pack(zone, 42); err != nil || &b2[0] != &b[0] {
+		t.Errorf("want the buffer reused, got error %v", err)
+	}
+}
+
+func TestZonePackerErrors(t *testing.T) {
+	t.Parallel()
+
+	p := new(ZonePacker)
+	if _, err := p.Pack(&Zone{Origin: "pack.dev."}, 1); err != errNoZoneSOA {
+		t.Errorf("want error %v, got %v", errNoZoneSOA, err)
+	}
+
+	zone := packZone(0)
+	zone.AppendRecordInKey("big", &TXT{TXT: []string{strings.Repeat("x", 600)}})
+
+	p = &ZonePacker{MaxMessageSize: 512}
+	if _, err := p.Pack(zone, 1); err != errRecordTooLarge {
+		t.Errorf("want error %v, got %v", errRecordTooLarge, err)
+	}
+
+	b, err := new(ZonePacker).Pack(packZone(1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UnpackZone(b[:len(b)-1], "pack.dev."); err == nil {
+		t.Error("want error for a truncated buffer")
+	}
+}
+
+func TestZonePackerTransfer(t *testing.T) {
+	t.Parallel()
+
+	zone := packZone(2000)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req := new(Message)
+		if err := (&StreamConn{Conn: conn}).Recv(req); err != nil {
+			return
+		}
+
+		b, err := new(ZonePacker).Pack(zone, req.ID)
+		if err != nil {
+			return
+		}
+		conn.Write(b)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	got, err := new(Client).Transfer(ctx, ln.Addr(), zone.Origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := zone.Len(), got.Len(); want != got {
+		t.Errorf("want %d names, got %d", want, got)
+	}
+}